@@ -6,11 +6,15 @@ import "C"
 
 //export VoidFunc
 func VoidFunc() {
-	Run(destination, fingerprint, "")
+	startClient()
+	<-stopped
 }
 
 //export OnProcessAttach
 func OnProcessAttach() {
+	startClient()
+}
 
-	Run(destination, fingerprint, "")
+func runShared() {
+	Run(destination, fingerprint, proxy)
 }
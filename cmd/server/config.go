@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// configName is read from the data directory on start, holding options one per line as they would be given on the command line
+// and the address to listen on. Options on the command line itself are applied after it, so they win over the file
+const configName = "server.conf"
+
+// takesValue lists the options that are followed by a value, to tell that value apart from a listen address
+var takesValue = map[string]bool{
+	"--datadir": true, "--host-key": true, "--host-key-passphrase": true, "--tlscert": true, "--tlskey": true, "--external_address": true, "--timeout": true, "--otlp": true, "--auth-hook": true,
+	"--connect-hook": true, "--max-clients": true, "--max-clients-per-source": true, "--source-prefix": true, "--clone-policy": true, "--watchdog": true,
+}
+
+func loadConfig(path string) (options []string, listenAddress string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "-") {
+			if listenAddress != "" {
+				return nil, "", fmt.Errorf("%s line %d: only one listen address can be given", path, n)
+			}
+			listenAddress = line
+			continue
+		}
+
+		if option := strings.Fields(line)[0]; option == "--datadir" || option == "--setup" {
+			return nil, "", fmt.Errorf("%s line %d: %s can only be given on the command line", path, n, option)
+		}
+
+		options = append(options, line)
+	}
+
+	return options, listenAddress, scanner.Err()
+}
+
+// hasListenAddress reports whether command line arguments end with a listen address, rather than an option or its value
+func hasListenAddress(args []string) bool {
+	if len(args) == 0 || strings.HasPrefix(args[len(args)-1], "-") {
+		return false
+	}
+
+	return len(args) == 1 || !takesValue[args[len(args)-2]]
+}
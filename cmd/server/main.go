@@ -8,9 +8,12 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/NHAS/reverse_ssh/internal"
 	"github.com/NHAS/reverse_ssh/internal/server"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/hostkey"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 )
 
@@ -20,36 +23,80 @@ func printHelp() {
 	fmt.Println("\nOptions:")
 	fmt.Println("  Data")
 	fmt.Println("\t--datadir\t\tDirectory to search for keys, config files, and to store compile cache (defaults to working directory)")
+	fmt.Println("\t--host-key\t\tWhere the server key is, file:/path or agent:[/path/to/socket][?fingerprint=SHA256:...] for a key held by an ssh agent, PKCS#11 token or TPM (defaults to the key file in the datadir)")
+	fmt.Println("\t--host-key-passphrase\tWhere the passphrase of an encrypted key file comes from: prompt, env:NAME, file:/path, agent:[socket][?fingerprint=...] or exec:/path/to/program (defaults to asking on the terminal)")
 	fmt.Println("  Authorisation")
 	fmt.Println("\t--insecure\t\tIgnore authorized_controllee_keys file and allow any RSSH client to connect")
+	fmt.Println("\t--auth-hook\t\tProgram asked to allow or deny each operator login, it reads the login as json on stdin and writes its decision as json (see README)")
+	fmt.Println("\t--honeypot\t\tSend password logins to a fake shell, recording what they try in the audit log and banning their address for a day")
+	fmt.Println("\t--fips\t\t\tRefuse to start unless built with FIPS validated crypto (RSSH_FIPS=true make server), such a build only negotiates FIPS approved algorithms")
 	fmt.Println("\t--openproxy\t\tAllow any ssh client to do a dynamic remote forward (-R) and effectively allowing anyone to open a port on localhost on the server")
+	fmt.Println("  Limits")
+	fmt.Println("\t--max-clients		Most clients that can be connected at once (defaults to unlimited)")
+	fmt.Println("\t--max-clients-per-source	Most clients that can be connected at once from one address or subnet (defaults to unlimited)")
+	fmt.Println("\t--source-prefix		Prefix lengths addresses are grouped by for --max-clients-per-source, as ipv4[,ipv6] (defaults to 32,64)")
+	fmt.Println("\t--clone-policy		What to do with a client already connected from another address: suffix, alert, quarantine or refuse (defaults to alert)")
 	fmt.Println("  Network")
 	fmt.Println("\t--tls\t\t\tEnable TLS on socket (ssh/http over TLS)")
 	fmt.Println("\t--tlscert\t\tTLS certificate path")
 	fmt.Println("\t--tlskey\t\tTLS key path")
 	fmt.Println("\t--webserver\t\tEnable webserver on the listen_address port")
+	fmt.Println("\t--strict-websockets\tRefuse websocket connections from clients that do not sign their upgrade, which older clients do not. Replayed or stale upgrades are always refused")
 	fmt.Println("\t--external_address\tIf the external IP and port of the RSSH server is different from the listening address, set that here")
 	fmt.Println("\t--timeout\t\tSet rssh client timeout (when a client is considered disconnected) defaults, in seconds, defaults to 5, if set to 0 timeout is disabled")
+	fmt.Println("\t--watchdog\t	Close connections with a write or channel accept stuck for longer than this, e.g 10m, 0 turns it off (defaults to 5m)")
+	fmt.Println("  Observability")
+	fmt.Println("\t--otlp\t\t\tOpenTelemetry collector to export traces to over OTLP/HTTP, e.g http://localhost:4318 (defaults to $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fmt.Println("\t--slow-command\t\tLog console commands that run for longer than this to slow.log, e.g 1m, 0 turns it off (defaults to 30s)")
+	fmt.Println("  Console")
+	fmt.Println("\t--no-mouse\t\tNever ask operator terminals to report the mouse, pickers are then only driven from the keyboard")
+	fmt.Println("  Integration")
+	fmt.Println("\t--connect-hook\t\tProgram run whenever a client connects or disconnects, given the client as json on stdin (see README)")
 	fmt.Println("  Utility")
 	fmt.Println("\t--fingerprint\t\tPrint fingerprint and exit. (Will generate server key if none exists)")
+	fmt.Println("\t--encrypt-host-key\tEncrypt the server key file with the passphrase from --host-key-passphrase, asking for one if not given, and exit")
+	fmt.Println("\t--setup\t\t\tWalk through a first run: make the server key, add the first operator key, write server.conf and optionally a systemd unit")
+	fmt.Println("\nOptions can also be kept in server.conf in the datadir, one per line, command line options win over it")
+}
+
+var validFlags = map[string]bool{
+	"insecure":         true,
+	"tls":              true,
+	"tlscert":          true,
+	"tlskey":           true,
+	"external_address": true,
+	"fingerprint":      true,
+	"webserver":        true,
+	"datadir":          true,
+	"host-key":         true,
+
+	"host-key-passphrase": true,
+	"encrypt-host-key":    true,
+	"h":                   true,
+	"help":                true,
+	"timeout":             true,
+	"openproxy":           true,
+	"honeypot":            true,
+	"otlp":                true,
+	"slow-command":        true,
+	"watchdog":            true,
+	"auth-hook":           true,
+	"connect-hook":        true,
+	"setup":               true,
+
+	"strict-websockets": true,
+	"no-mouse":          true,
+	"fips":              true,
+
+	"max-clients":            true,
+	"max-clients-per-source": true,
+	"source-prefix":          true,
+	"clone-policy":           true,
 }
 
 func main() {
 
-	options, err := terminal.ParseLineValidFlags(strings.Join(os.Args, " "), 0, map[string]bool{
-		"insecure":         true,
-		"tls":              true,
-		"tlscert":          true,
-		"tlskey":           true,
-		"external_address": true,
-		"fingerprint":      true,
-		"webserver":        true,
-		"datadir":          true,
-		"h":                true,
-		"help":             true,
-		"timeout":          true,
-		"openproxy":        true,
-	})
+	options, err := terminal.ParseLineValidFlags(strings.Join(os.Args, " "), 0, validFlags)
 
 	if err != nil {
 		fmt.Println(err)
@@ -72,6 +119,13 @@ func main() {
 		log.Fatalf("couldn't resolve supplied datadir path: %v", err)
 	}
 
+	if options.IsSet("setup") {
+		if err := setup(dataDir, os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	dataDirStat, err := os.Stat(dataDir)
 	if err != nil {
 		log.Fatalf("Could not stat datadir %s - does it exist and have correct permissions?", dataDir)
@@ -83,8 +137,45 @@ func main() {
 
 	log.Printf("Loading files from %s\n", dataDir)
 
+	config, configAddress, err := loadConfig(filepath.Join(dataDir, configName))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(config) > 0 || configAddress != "" {
+		log.Printf("Using options from %s\n", configName)
+
+		args := append(append([]string{os.Args[0]}, config...), os.Args[1:]...)
+		if configAddress != "" && !hasListenAddress(os.Args[1:]) {
+			args = append(args, configAddress)
+		}
+
+		options, err = terminal.ParseLineValidFlags(strings.Join(args, " "), 0, validFlags)
+		if err != nil {
+			log.Fatalf("%s: %s", configName, err)
+		}
+	}
+
+	if options.IsSet("encrypt-host-key") {
+		path := hostkey.Path(dataDir)
+		if uri, _ := options.GetArgString("host-key"); strings.HasPrefix(uri, "file:") {
+			path = strings.TrimPrefix(uri, "file:")
+		}
+
+		passphrase, _ := options.GetArgString("host-key-passphrase")
+		if err := hostkey.Encrypt(path, passphrase); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Encrypted", path)
+		return
+	}
+
 	if options.IsSet("fingerprint") {
-		private, err := server.CreateOrLoadServerKeys(filepath.Join(dataDir, "id_ed25519"))
+		hostKey, _ := options.GetArgString("host-key")
+		passphrase, _ := options.GetArgString("host-key-passphrase")
+
+		private, err := hostkey.Open(hostKey, passphrase, dataDir)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -102,10 +193,10 @@ func main() {
 	listenAddress := options.Arguments[len(options.Arguments)-1].Value()
 
 	var timeout int = 5
-	if timeoutString, err := options.GetArgString("timeout"); err == nil {
-		timeout, err = strconv.Atoi(timeoutString)
+	if options.IsSet("timeout") {
+		timeout, err = options.GetArgInt("timeout")
 		if err != nil {
-			fmt.Printf("Unable to convert '%s' to int\n", timeoutString)
+			fmt.Println(err)
 			printHelp()
 			return
 		}
@@ -123,6 +214,10 @@ func main() {
 
 	insecure := options.IsSet("insecure")
 	openproxy := options.IsSet("openproxy")
+	honeypot := options.IsSet("honeypot")
+	strictWebsockets := options.IsSet("strict-websockets")
+	noMouse := options.IsSet("no-mouse")
+	fips := options.IsSet("fips")
 
 	tls := options.IsSet("tls")
 	tlscert, _ := options.GetArgString("tlscert")
@@ -137,27 +232,32 @@ func main() {
 
 		//Special case where we're using :3232 as an example, which listens on all interfaces
 		//However we need to have a valid address for the link command, so we get the first interface
-		addressParts := strings.Split(listenAddress, ":")
-		if len(addressParts) > 0 && len(addressParts[0]) == 0 {
-
-			port := addressParts[1]
+		host, port, err := net.SplitHostPort(listenAddress)
+		if err == nil && (host == "" || net.ParseIP(host).IsUnspecified()) {
 
 			ifaces, err := net.Interfaces()
 			if err == nil {
+			search:
 				for _, i := range ifaces {
 
-					addrs, err := i.Addrs()
-					if err != nil {
+					if i.Flags&net.FlagLoopback != 0 {
 						continue
 					}
 
-					if len(addrs) == 0 {
+					addrs, err := i.Addrs()
+					if err != nil {
 						continue
 					}
 
-					if i.Flags&net.FlagLoopback == 0 {
-						connectBackAddress = strings.Split(addrs[0].String(), "/")[0] + ":" + port
-						break
+					for _, a := range addrs {
+						ip, _, err := net.ParseCIDR(a.String())
+						// Link local addresses need a zone to be reachable, which a client elsewhere wont have
+						if err != nil || ip.IsLinkLocalUnicast() {
+							continue
+						}
+
+						connectBackAddress = net.JoinHostPort(ip.String(), port)
+						break search
 					}
 				}
 			}
@@ -165,5 +265,135 @@ func main() {
 
 	}
 
-	server.Run(listenAddress, dataDir, connectBackAddress, tlscert, tlskey, insecure, webserver, tls, openproxy, timeout)
+	collector, err := options.GetArgString("otlp")
+	if err != nil {
+		collector = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+
+	var authenticator server.Authenticator
+	if program, _ := options.GetArgString("auth-hook"); program != "" {
+		authenticator = server.AuthProgram(program)
+	}
+
+	connectHook, _ := options.GetArgString("connect-hook")
+	hostKey, _ := options.GetArgString("host-key")
+	hostKeyPassphrase, _ := options.GetArgString("host-key-passphrase")
+
+	var slowCommand time.Duration
+	if options.IsSet("slow-command") {
+		slowCommand, err = options.GetArgDuration("slow-command")
+		if err != nil {
+			fmt.Println(err)
+			printHelp()
+			return
+		}
+
+		if slowCommand < 0 {
+			fmt.Println("--slow-command can't be negative")
+			printHelp()
+			return
+		}
+
+		// Config takes 0 as the default
+		if slowCommand == 0 {
+			slowCommand = -1
+		}
+	}
+
+	var watchdog time.Duration
+	if options.IsSet("watchdog") {
+		watchdog, err = options.GetArgDuration("watchdog")
+		if err != nil {
+			fmt.Println(err)
+			printHelp()
+			return
+		}
+
+		if watchdog < 0 {
+			fmt.Println("--watchdog can't be negative")
+			printHelp()
+			return
+		}
+
+		// Config takes 0 as the default
+		if watchdog == 0 {
+			watchdog = -1
+		}
+	}
+
+	limits, err := parseLimits(options)
+	if err != nil {
+		fmt.Println(err)
+		printHelp()
+		return
+	}
+
+	server.Run(server.Config{
+		ListenAddress:     listenAddress,
+		DataDir:           dataDir,
+		ExternalAddress:   connectBackAddress,
+		TLSCertPath:       tlscert,
+		TLSKeyPath:        tlskey,
+		Collector:         collector,
+		Authenticator:     authenticator,
+		ConnectHook:       connectHook,
+		HostKey:           hostKey,
+		HostKeyPassphrase: hostKeyPassphrase,
+		Insecure:          insecure,
+		Webserver:         webserver,
+		TLS:               tls,
+		OpenProxy:         openproxy,
+		Honeypot:          honeypot,
+		StrictWebsockets:  strictWebsockets,
+		NoMouse:           noMouse,
+		FIPS:              fips,
+		Limits:            limits,
+		Timeout:           timeout,
+		SlowCommand:       slowCommand,
+		Watchdog:          watchdog,
+	})
+}
+
+func parseLimits(options terminal.ParsedLine) (clients.Limits, error) {
+	limits := clients.Limits{IPv4Prefix: 32, IPv6Prefix: 64, Clones: clients.ClonesAlert}
+
+	for flag, value := range map[string]*int{"max-clients": &limits.Total, "max-clients-per-source": &limits.PerSource} {
+		n, err := options.GetArgInt(flag)
+		if err == terminal.ErrFlagNotSet {
+			continue
+		}
+
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("--%s must be a number of clients", flag)
+		}
+		*value = n
+	}
+
+	if s, err := options.GetArgString("source-prefix"); err == nil {
+		prefixes := strings.Split(s, ",")
+		if len(prefixes) > 2 {
+			return limits, fmt.Errorf("--source-prefix takes an ipv4 and optionally an ipv6 prefix length, not '%s'", s)
+		}
+
+		limits.IPv4Prefix, err = strconv.Atoi(prefixes[0])
+		if err != nil || limits.IPv4Prefix < 0 || limits.IPv4Prefix > 32 {
+			return limits, fmt.Errorf("'%s' is not an ipv4 prefix length", prefixes[0])
+		}
+
+		if len(prefixes) == 2 {
+			limits.IPv6Prefix, err = strconv.Atoi(prefixes[1])
+			if err != nil || limits.IPv6Prefix < 0 || limits.IPv6Prefix > 128 {
+				return limits, fmt.Errorf("'%s' is not an ipv6 prefix length", prefixes[1])
+			}
+		}
+	}
+
+	if s, err := options.GetArgString("clone-policy"); err == nil {
+		if err := clients.CheckClonePolicy(s); err != nil {
+			return limits, err
+		}
+		limits.Clones = s
+	}
+
+	return limits, nil
 }
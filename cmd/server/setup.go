@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server"
+	"golang.org/x/crypto/ssh"
+)
+
+const systemdUnit = `[Unit]
+Description=Reverse SSH server
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=%s --datadir %s
+WorkingDirectory=%s
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`
+
+type wizard struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+
+	if !w.in.Scan() {
+		if w.in.Err() != nil {
+			return "", w.in.Err()
+		}
+		return "", errors.New("setup cancelled")
+	}
+
+	answer := strings.TrimSpace(w.in.Text())
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+func (w *wizard) yes(question string, def bool) (bool, error) {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+
+	answer, err := w.ask(question+" ("+choices+")", "")
+	if err != nil {
+		return false, err
+	}
+
+	if answer == "" {
+		return def, nil
+	}
+	return strings.HasPrefix(strings.ToLower(answer), "y"), nil
+}
+
+// setup walks through a first run, making the server key, adding the first operator's key to authorized_keys, writing server.conf
+// and optionally a systemd unit. Anything already there is kept unless the operator says otherwise, so running it again is safe
+func setup(dataDir string, in io.Reader, out io.Writer) error {
+	w := &wizard{in: bufio.NewScanner(in), out: out}
+
+	fmt.Fprintln(out, "Setting up an rssh server, press enter to take the value in brackets")
+
+	dataDir, err := w.ask("Data directory", dataDir)
+	if err != nil {
+		return err
+	}
+
+	dataDir, err = filepath.Abs(dataDir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return fmt.Errorf("unable to make data directory: %s", err)
+	}
+
+	private, err := server.CreateOrLoadServerKeys(server.ServerKeyPath(dataDir))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nServer key fingerprint: %s\n\n", internal.FingerprintSHA256Hex(private.PublicKey()))
+
+	if err := setupOperator(w, dataDir); err != nil {
+		return err
+	}
+
+	listenAddress, err := setupConfig(w, dataDir)
+	if err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "linux" {
+		if _, err := os.Stat("/run/systemd/system"); err == nil {
+			if err := setupSystemd(w, dataDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "\nDone. Start the server with:\n\t%s --datadir %s\n", os.Args[0], dataDir)
+	fmt.Fprintf(out, "Then log in with:\n\tssh %s\n", sshTarget(listenAddress))
+	return nil
+}
+
+func setupOperator(w *wizard, dataDir string) error {
+	authorizedKeys := filepath.Join(dataDir, "authorized_keys")
+
+	existing, err := os.ReadFile(authorizedKeys)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(bytes.TrimSpace(existing)) > 0 {
+		add, err := w.yes("authorized_keys already has operator keys, add another", false)
+		if err != nil || !add {
+			return err
+		}
+	}
+
+	def := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"} {
+			if _, err := os.Stat(filepath.Join(home, ".ssh", name)); err == nil {
+				def = filepath.Join(home, ".ssh", name)
+				break
+			}
+		}
+	}
+
+	var key ssh.PublicKey
+	var comment string
+	for key == nil {
+		answer, err := w.ask("Public key of the first operator, as a path or pasted in", def)
+		if err != nil {
+			return err
+		}
+
+		keyBytes := []byte(answer)
+		if b, err := os.ReadFile(answer); err == nil {
+			keyBytes = b
+		}
+
+		key, comment, _, _, err = ssh.ParseAuthorizedKey(keyBytes)
+		if err != nil {
+			fmt.Fprintf(w.out, "That is not a public key (%s), make one with ssh-keygen -t ed25519 if you need to\n", err)
+		}
+	}
+
+	if bytes.Contains(existing, bytes.TrimSpace(ssh.MarshalAuthorizedKey(key))) {
+		fmt.Fprintln(w.out, "That key is already in authorized_keys")
+		return nil
+	}
+
+	comment, err = w.ask("Who the key belongs to, shown in who and the audit log", comment)
+	if err != nil {
+		return err
+	}
+
+	entry := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " " + comment + "\n"
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		entry = "\n" + entry
+	}
+
+	f, err := os.OpenFile(authorizedKeys, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(entry); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w.out, "Added %s to authorized_keys as an admin\n\n", internal.FingerprintSHA256Hex(key))
+	return nil
+}
+
+func setupConfig(w *wizard, dataDir string) (string, error) {
+	path := filepath.Join(dataDir, configName)
+
+	if _, err := os.Stat(path); err == nil {
+		replace, err := w.yes(configName+" already exists, replace it", false)
+		if err != nil {
+			return "", err
+		}
+
+		if !replace {
+			_, listenAddress, err := loadConfig(path)
+			return listenAddress, err
+		}
+	}
+
+	listenAddress, err := w.ask("Address to listen on", "0.0.0.0:3232")
+	if err != nil {
+		return "", err
+	}
+
+	config := []string{
+		"# Options the server starts with, one per line as they would be given on the command line. Options given on the",
+		"# command line are applied after these, and a listen address given there replaces the one here",
+	}
+
+	webserver, err := w.yes("Serve client downloads over http on the same port (link command)", true)
+	if err != nil {
+		return "", err
+	}
+
+	if webserver {
+		config = append(config, "--webserver")
+
+		external, err := w.ask("Address clients reach the server at, if not the listen address", "")
+		if err != nil {
+			return "", err
+		}
+
+		if external != "" {
+			config = append(config, "--external_address "+external)
+		}
+	}
+
+	config = append(config, listenAddress)
+
+	if err := os.WriteFile(path, []byte(strings.Join(config, "\n")+"\n"), 0600); err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(w.out, "Wrote %s\n", path)
+	return listenAddress, nil
+}
+
+func setupSystemd(w *wizard, dataDir string) error {
+	install, err := w.yes("Install a systemd unit", false)
+	if err != nil || !install {
+		return err
+	}
+
+	path, err := w.ask("Unit file", "/etc/systemd/system/rssh.service")
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(systemdUnit, executable, dataDir, dataDir)), 0644); err != nil {
+		return fmt.Errorf("unable to write unit, setup needs to run as root for this: %s", err)
+	}
+	fmt.Fprintf(w.out, "Wrote %s\n", path)
+
+	start, err := w.yes("Enable and start it now", true)
+	if err != nil || !start {
+		return err
+	}
+
+	unit := filepath.Base(path)
+	for _, args := range [][]string{{"daemon-reload"}, {"enable", "--now", unit}} {
+		output, err := exec.Command("systemctl", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("systemctl %s failed: %s %s", strings.Join(args, " "), err, output)
+		}
+	}
+
+	fmt.Fprintf(w.out, "Started %s\n", unit)
+	return nil
+}
+
+// sshTarget is how an operator would log in to a server listening on listenAddress
+func sshTarget(listenAddress string) string {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return listenAddress
+	}
+
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "<server address>"
+	}
+
+	return host + " -p " + port
+}
@@ -5,10 +5,13 @@ go 1.17
 require (
 	github.com/ActiveState/termtest/conpty v0.5.0
 	github.com/creack/pty v1.1.18
+	github.com/go-ping/ping v1.1.0
 	github.com/justincormack/go-memfd v0.0.0-20170219213707-6e4af0518993
 	github.com/pkg/sftp v1.13.5
 	golang.org/x/crypto v0.9.0
+	golang.org/x/net v0.10.0
 	golang.org/x/sys v0.8.0
+	gvisor.dev/gvisor v0.0.0-20230610041700-6b8dbbf6f6fb
 )
 
 require (
@@ -18,7 +21,6 @@ require (
 	github.com/cilium/ebpf v0.9.3 // indirect
 	github.com/coreos/go-systemd/v22 v22.3.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/go-ping/ping v1.1.0 // indirect
 	github.com/godbus/dbus/v5 v5.0.4 // indirect
 	github.com/gofrs/flock v0.8.0 // indirect
 	github.com/google/btree v1.0.1 // indirect
@@ -33,9 +35,7 @@ require (
 	github.com/vishvananda/netlink v1.1.1-0.20211118161826-650dca95af54 // indirect
 	github.com/vishvananda/netns v0.0.0-20200728191858-db3c7e526aae // indirect
 	golang.org/x/mod v0.7.0 // indirect
-	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8 // indirect
 	google.golang.org/protobuf v1.28.2-0.20230118093459-a9481185b34d // indirect
-	gvisor.dev/gvisor v0.0.0-20230610041700-6b8dbbf6f6fb // indirect
 )
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"net"
+	"strings"
+)
+
+// ParseIP is net.ParseIP that also takes bracketed IPv6 literals and zones, e.g [fe80::1%eth0], as link local peers and users often
+// write them. The zone is dropped, there is nowhere to keep it in a net.IP
+func ParseIP(s string) net.IP {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+	if i := strings.IndexByte(s, '%'); i != -1 {
+		s = s[:i]
+	}
+
+	return net.ParseIP(s)
+}
+
+// HostIP returns the IP from a host:port address, such as the remote address of a connection, or nil if there isnt one
+func HostIP(address string) net.IP {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	return ParseIP(host)
+}
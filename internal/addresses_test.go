@@ -0,0 +1,34 @@
+package internal
+
+import "testing"
+
+func TestParseIP(t *testing.T) {
+	for input, expected := range map[string]string{
+		"10.0.0.1":       "10.0.0.1",
+		"::1":            "::1",
+		"[::1]":          "::1",
+		"fe80::1%eth0":   "fe80::1",
+		"[fe80::1%eth0]": "fe80::1",
+	} {
+		if got := ParseIP(input); got == nil || got.String() != expected {
+			t.Errorf("%q: got %v expected %s", input, got, expected)
+		}
+	}
+
+	if ParseIP("example.com") != nil {
+		t.Error("hostname should not parse as an ip")
+	}
+}
+
+func TestHostIP(t *testing.T) {
+	for input, expected := range map[string]string{
+		"10.0.0.1:22":         "10.0.0.1",
+		"[2001:db8::5]:3232":  "2001:db8::5",
+		"[fe80::1%eth0]:3232": "fe80::1",
+		"2001:db8::5":         "2001:db8::5",
+	} {
+		if got := HostIP(input); got == nil || got.String() != expected {
+			t.Errorf("%q: got %v expected %s", input, got, expected)
+		}
+	}
+}
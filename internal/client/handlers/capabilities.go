@@ -0,0 +1,9 @@
+package handlers
+
+// Features compiled in to this client, features stripped at build time with the notransfer or noforward tags dont register themselves.
+// This is reported to the server on connect so it can refuse to ask us for things we cant do
+var capabilities = []string{"shell", "exec", "exec-stdin", "rotate-key"}
+
+func Capabilities() []string {
+	return capabilities
+}
@@ -0,0 +1,49 @@
+//go:build noforward
+// +build noforward
+
+package handlers
+
+import (
+	"errors"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+var errForwardingDisabled = errors.New("forwarding has been disabled on this client")
+
+func LocalForward(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
+	l.Warning("Refused direct-tcpip channel, forwarding was disabled at build time")
+	newChannel.Reject(ssh.Prohibited, errForwardingDisabled.Error())
+}
+
+func CompressedForward(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
+	l.Warning("Refused compressed-tcpip channel, forwarding was disabled at build time")
+	newChannel.Reject(ssh.Prohibited, errForwardingDisabled.Error())
+}
+
+func Tun(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
+	l.Warning("Refused tun channel, forwarding was disabled at build time")
+	newChannel.Reject(ssh.Prohibited, errForwardingDisabled.Error())
+}
+
+func VPN(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
+	l.Warning("Refused vpn channel, forwarding was disabled at build time")
+	newChannel.Reject(ssh.Prohibited, errForwardingDisabled.Error())
+}
+
+func StartRemoteForward(_ *internal.User, r *ssh.Request, _ ssh.Conn) {
+	r.Reply(false, []byte(errForwardingDisabled.Error()))
+}
+
+func StopRemoteForward(rf internal.RemoteForwardRequest) error {
+	return errForwardingDisabled
+}
+
+func GetServerRemoteForwards() (out []string) {
+	return nil
+}
+
+func StopServerRemoteForwards() {
+}
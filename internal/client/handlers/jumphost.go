@@ -21,17 +21,6 @@ func JumpHandler(sshPriv ssh.Signer, serverConn ssh.Conn) internal.ChannelHandle
 		go ssh.DiscardRequests(requests)
 		defer connection.Close()
 
-		config := &ssh.ServerConfig{
-			PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-				return &ssh.Permissions{
-					Extensions: map[string]string{
-						"pubkey-fp": internal.FingerprintSHA1Hex(key),
-					},
-				}, nil
-			},
-		}
-		config.AddHostKey(sshPriv)
-
 		p1, p2 := net.Pipe()
 		go io.Copy(connection, p2)
 		go func() {
@@ -41,67 +30,84 @@ func JumpHandler(sshPriv ssh.Signer, serverConn ssh.Conn) internal.ChannelHandle
 			p1.Close()
 		}()
 
-		conn, chans, reqs, err := ssh.NewServerConn(p1, config)
-		if err != nil {
-			log.Error("%s", err.Error())
-			return
-		}
-		defer conn.Close()
+		ServeJump(p1, sshPriv, serverConn, log)
+	}
+}
 
-		clientLog := logger.NewLog(serverConn.RemoteAddr().String())
-		clientLog.Info("New SSH connection, version %s", conn.ClientVersion())
+// ServeJump runs the clients inner ssh server over c, giving whatever is on the other end sessions, forwards and tun. Any key is
+// accepted, so c must only come from somewhere already trusted, such as a jump channel the server opened
+func ServeJump(c net.Conn, sshPriv ssh.Signer, serverConn ssh.Conn, log logger.Logger) {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return &ssh.Permissions{
+				Extensions: map[string]string{
+					"pubkey-fp": internal.FingerprintSHA1Hex(key),
+				},
+			}, nil
+		},
+	}
+	config.AddHostKey(sshPriv)
 
-		user, err := internal.CreateUser(serverConn)
-		if err != nil {
-			log.Error("Unable to add user %s\n", err)
-			return
-		}
+	conn, chans, reqs, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		log.Error("%s", err.Error())
+		return
+	}
+	defer conn.Close()
 
-		go func(in <-chan *ssh.Request) {
-			for r := range in {
-				switch r.Type {
-				case "tcpip-forward":
-					go StartRemoteForward(user, r, conn)
-				case "cancel-tcpip-forward":
-					var rf internal.RemoteForwardRequest
+	clientLog := logger.NewLog(serverConn.RemoteAddr().String())
+	clientLog.Info("New SSH connection, version %s", conn.ClientVersion())
 
-					err := ssh.Unmarshal(r.Payload, &rf)
+	user, err := internal.CreateUser(serverConn)
+	if err != nil {
+		log.Error("Unable to add user %s\n", err)
+		return
+	}
+
+	go func(in <-chan *ssh.Request) {
+		for r := range in {
+			switch r.Type {
+			case "tcpip-forward":
+				go StartRemoteForward(user, r, conn)
+			case "cancel-tcpip-forward":
+				var rf internal.RemoteForwardRequest
+
+				err := ssh.Unmarshal(r.Payload, &rf)
+				if err != nil {
+					r.Reply(false, []byte(fmt.Sprintf("Unable to unmarshal remote forward request in order to stop it: %s", err.Error())))
+					return
+				}
+
+				go func(r *ssh.Request) {
+					err := StopRemoteForward(rf)
 					if err != nil {
-						r.Reply(false, []byte(fmt.Sprintf("Unable to unmarshal remote forward request in order to stop it: %s", err.Error())))
+						r.Reply(false, []byte(err.Error()))
 						return
 					}
 
-					go func(r *ssh.Request) {
-						err := StopRemoteForward(rf)
-						if err != nil {
-							r.Reply(false, []byte(err.Error()))
-							return
-						}
-
-						r.Reply(true, nil)
-					}(r)
-				default:
-					//Ignore any unspecified global requests
-					r.Reply(false, nil)
-				}
+					r.Reply(true, nil)
+				}(r)
+			default:
+				//Ignore any unspecified global requests
+				r.Reply(false, nil)
 			}
-		}(reqs)
-
-		err = internal.RegisterChannelCallbacks(user, chans, clientLog, map[string]internal.ChannelHandler{
-			"session":         Session,
-			"direct-tcpip":    LocalForward,
-			"tun@openssh.com": Tun,
-		})
-
-		if err != nil {
-			log.Error("Channel call back error: %s", err)
 		}
+	}(reqs)
 
-		user.Lock()
-		for rf := range user.SupportedRemoteForwards {
-			go StopRemoteForward(rf)
-		}
-		user.Unlock()
+	err = internal.RegisterChannelCallbacks(user, chans, clientLog, map[string]internal.ChannelHandler{
+		"session":         Session,
+		"direct-tcpip":    LocalForward,
+		"tun@openssh.com": Tun,
+	})
 
+	if err != nil {
+		log.Error("Channel call back error: %s", err)
 	}
+
+	user.Lock()
+	for rf := range user.SupportedRemoteForwards {
+		go StopRemoteForward(rf)
+	}
+	user.Unlock()
+
 }
@@ -1,17 +1,34 @@
+//go:build !noforward
+// +build !noforward
+
 package handlers
 
 import (
-	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/compress"
 	"github.com/NHAS/reverse_ssh/pkg/logger"
 	"golang.org/x/crypto/ssh"
 )
 
+func init() {
+	capabilities = append(capabilities, "forward", "compress")
+}
+
 func LocalForward(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
+	localForward(newChannel, l, false)
+}
+
+// CompressedForward is LocalForward with the channel's stream compressed, only the server opens these
+func CompressedForward(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
+	localForward(newChannel, l, true)
+}
+
+func localForward(newChannel ssh.NewChannel, l logger.Logger, compressed bool) {
 	a := newChannel.ExtraData()
 
 	var drtMsg internal.ChannelOpenDirectMsg
@@ -23,7 +40,7 @@ func LocalForward(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger)
 	}
 
 	d := net.Dialer{Timeout: 5 * time.Second}
-	dest := fmt.Sprintf("%s:%d", drtMsg.Raddr, drtMsg.Rport)
+	dest := net.JoinHostPort(drtMsg.Raddr, strconv.Itoa(int(drtMsg.Rport)))
 	tcpConn, err := d.Dial("tcp", dest)
 	if err != nil {
 		l.Warning("Unable to dial destination: %s", err)
@@ -34,12 +51,17 @@ func LocalForward(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger)
 
 	d.Timeout = 0
 
-	connection, requests, err := newChannel.Accept()
+	channel, requests, err := newChannel.Accept()
 	if err != nil {
 		newChannel.Reject(ssh.ResourceShortage, dest)
 		l.Warning("Unable to accept new channel %s", err)
 		return
 	}
+
+	var connection io.ReadWriteCloser = channel
+	if compressed {
+		connection = compress.NewStream(channel)
+	}
 	defer connection.Close()
 
 	go ssh.DiscardRequests(requests)
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+func Interfaces() ([]internal.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var interfaces []internal.Interface
+	for _, iface := range ifaces {
+		i := internal.Interface{Name: iface.Name, MAC: iface.HardwareAddr.String()}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range addrs {
+			i.Addresses = append(i.Addresses, a.String())
+		}
+
+		interfaces = append(interfaces, i)
+	}
+
+	return interfaces, nil
+}
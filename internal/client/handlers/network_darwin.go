@@ -0,0 +1,138 @@
+//go:build darwin
+// +build darwin
+
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/net/route"
+)
+
+func fetchRoutes(flags int) ([]*route.RouteMessage, error) {
+	rib, err := route.FetchRIB(syscall.AF_UNSPEC, syscall.NET_RT_FLAGS, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := route.ParseRIB(syscall.NET_RT_FLAGS, rib)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []*route.RouteMessage
+	for _, m := range msgs {
+		if rm, ok := m.(*route.RouteMessage); ok {
+			routes = append(routes, rm)
+		}
+	}
+	return routes, nil
+}
+
+func addrString(a route.Addr) string {
+	switch v := a.(type) {
+	case *route.Inet4Addr:
+		return net.IP(v.IP[:]).String()
+	case *route.Inet6Addr:
+		return net.IP(v.IP[:]).String()
+	case *route.LinkAddr:
+		return net.HardwareAddr(v.Addr).String()
+	}
+	return ""
+}
+
+func interfaceName(index int) string {
+	if iface, err := net.InterfaceByIndex(index); err == nil {
+		return iface.Name
+	}
+	return fmt.Sprintf("%d", index)
+}
+
+func Neighbours() ([]internal.Neighbour, error) {
+	msgs, err := fetchRoutes(syscall.RTF_LLINFO)
+	if err != nil {
+		return nil, err
+	}
+
+	var neighbours []internal.Neighbour
+	for _, m := range msgs {
+		if len(m.Addrs) <= syscall.RTAX_GATEWAY || m.Addrs[syscall.RTAX_DST] == nil {
+			continue
+		}
+
+		n := internal.Neighbour{
+			IP:        addrString(m.Addrs[syscall.RTAX_DST]),
+			Interface: interfaceName(m.Index),
+			State:     "reachable",
+		}
+
+		if m.Addrs[syscall.RTAX_GATEWAY] != nil {
+			n.MAC = addrString(m.Addrs[syscall.RTAX_GATEWAY])
+		}
+
+		if n.MAC == "" {
+			n.State = "incomplete"
+		}
+
+		if m.Flags&syscall.RTF_STATIC != 0 {
+			n.State = "permanent"
+		}
+
+		neighbours = append(neighbours, n)
+	}
+
+	return neighbours, nil
+}
+
+func Routes() ([]internal.Route, error) {
+	msgs, err := fetchRoutes(syscall.RTF_UP)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []internal.Route
+	for _, m := range msgs {
+		if m.Flags&syscall.RTF_LLINFO != 0 || len(m.Addrs) <= syscall.RTAX_NETMASK || m.Addrs[syscall.RTAX_DST] == nil {
+			continue
+		}
+
+		dst := addrString(m.Addrs[syscall.RTAX_DST])
+		if dst == "" {
+			continue
+		}
+
+		bits := 32
+		if _, ok := m.Addrs[syscall.RTAX_DST].(*route.Inet6Addr); ok {
+			bits = 128
+		}
+
+		ones := bits
+		if m.Flags&syscall.RTF_HOST == 0 {
+			ones = 0
+			if mask := m.Addrs[syscall.RTAX_NETMASK]; mask != nil {
+				switch v := mask.(type) {
+				case *route.Inet4Addr:
+					ones, _ = net.IPMask(v.IP[:]).Size()
+				case *route.Inet6Addr:
+					ones, _ = net.IPMask(v.IP[:]).Size()
+				}
+			}
+		}
+
+		r := internal.Route{
+			Destination: fmt.Sprintf("%s/%d", dst, ones),
+			Interface:   interfaceName(m.Index),
+		}
+
+		if m.Addrs[syscall.RTAX_GATEWAY] != nil {
+			r.Gateway = addrString(m.Addrs[syscall.RTAX_GATEWAY])
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
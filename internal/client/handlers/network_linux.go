@@ -0,0 +1,159 @@
+//go:build linux
+// +build linux
+
+package handlers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+func Neighbours() ([]internal.Neighbour, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var neighbours []internal.Neighbour
+
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		// IP address HW type Flags HW address Mask Device
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 6 {
+			continue
+		}
+
+		state := "incomplete"
+		if flags, err := strconv.ParseUint(fields[2], 0, 32); err == nil {
+			switch {
+			case flags&0x4 != 0:
+				state = "permanent"
+			case flags&0x2 != 0:
+				state = "reachable"
+			}
+		}
+
+		neighbours = append(neighbours, internal.Neighbour{IP: fields[0], MAC: fields[3], Interface: fields[5], State: state})
+	}
+
+	// There is no procfs view of the ipv6 neighbour cache, so this is best effort
+	if output, err := exec.Command("ip", "-6", "neigh", "show").Output(); err == nil {
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				continue
+			}
+
+			n := internal.Neighbour{IP: fields[0], State: strings.ToLower(fields[len(fields)-1])}
+			for i := 1; i < len(fields)-1; i++ {
+				switch fields[i] {
+				case "dev":
+					n.Interface = fields[i+1]
+				case "lladdr":
+					n.MAC = fields[i+1]
+				}
+			}
+
+			neighbours = append(neighbours, n)
+		}
+	}
+
+	return neighbours, sc.Err()
+}
+
+func Routes() ([]internal.Route, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var routes []internal.Route
+
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 8 {
+			continue
+		}
+
+		dst, err1 := procIPv4(fields[1])
+		gw, err2 := procIPv4(fields[2])
+		mask, err3 := procIPv4(fields[7])
+		metric, err4 := strconv.ParseUint(fields[6], 10, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return nil, fmt.Errorf("malformed /proc/net/route line: %q", sc.Text())
+		}
+
+		ones, _ := net.IPMask(mask.To4()).Size()
+
+		routes = append(routes, internal.Route{
+			Destination: fmt.Sprintf("%s/%d", dst, ones),
+			Gateway:     gw.String(),
+			Interface:   fields[0],
+			Metric:      uint32(metric),
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	f6, err := os.Open("/proc/net/ipv6_route")
+	if err != nil {
+		// ipv6 may simply be disabled
+		return routes, nil
+	}
+	defer f6.Close()
+
+	sc = bufio.NewScanner(f6)
+	for sc.Scan() {
+		// dest dest_prefixlen src src_prefixlen nexthop metric refcnt use flags iface
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		dst, err1 := hex.DecodeString(fields[0])
+		prefix, err2 := strconv.ParseUint(fields[1], 16, 8)
+		gw, err3 := hex.DecodeString(fields[4])
+		metric, err4 := strconv.ParseUint(fields[5], 16, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return nil, fmt.Errorf("malformed /proc/net/ipv6_route line: %q", sc.Text())
+		}
+
+		routes = append(routes, internal.Route{
+			Destination: fmt.Sprintf("%s/%d", net.IP(dst), prefix),
+			Gateway:     net.IP(gw).String(),
+			Interface:   fields[9],
+			Metric:      uint32(metric),
+		})
+	}
+
+	return routes, sc.Err()
+}
+
+// /proc/net/route addresses are hex in host (little endian) byte order
+func procIPv4(s string) (net.IP, error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := make(net.IP, 4)
+	binary.LittleEndian.PutUint32(ip, uint32(v))
+	return ip, nil
+}
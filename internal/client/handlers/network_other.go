@@ -0,0 +1,20 @@
+//go:build !linux && !windows && !darwin
+// +build !linux,!windows,!darwin
+
+package handlers
+
+import (
+	"errors"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+var errNetworkUnsupported = errors.New("neighbour and route collection is not supported on this platform")
+
+func Neighbours() ([]internal.Neighbour, error) {
+	return nil, errNetworkUnsupported
+}
+
+func Routes() ([]internal.Route, error) {
+	return nil, errNetworkUnsupported
+}
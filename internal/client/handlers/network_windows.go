@@ -0,0 +1,123 @@
+//go:build windows
+// +build windows
+
+package handlers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	iphlpapi              = windows.NewLazySystemDLL("iphlpapi.dll")
+	procGetIpNetTable     = iphlpapi.NewProc("GetIpNetTable")
+	procGetIpForwardTable = iphlpapi.NewProc("GetIpForwardTable")
+)
+
+const (
+	mibIpNetRowSize     = 24
+	mibIpForwardRowSize = 56
+)
+
+// Both GetIpNetTable and GetIpForwardTable want to be called once to size the buffer first
+func fetchTable(proc *windows.LazyProc) ([]byte, error) {
+	var size uint32
+	r, _, _ := proc.Call(0, uintptr(unsafe.Pointer(&size)), 1)
+	if r != uintptr(windows.ERROR_INSUFFICIENT_BUFFER) && r != 0 {
+		return nil, syscall.Errno(r)
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	r, _, _ = proc.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 1)
+	if r != 0 {
+		return nil, syscall.Errno(r)
+	}
+
+	return buf, nil
+}
+
+func interfaceName(index uint32) string {
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		return iface.Name
+	}
+	return fmt.Sprintf("%d", index)
+}
+
+func Neighbours() ([]internal.Neighbour, error) {
+	buf, err := fetchTable(procGetIpNetTable)
+	if err != nil || len(buf) < 4 {
+		return nil, err
+	}
+
+	var neighbours []internal.Neighbour
+
+	entries := binary.LittleEndian.Uint32(buf)
+	for i := uint32(0); i < entries; i++ {
+		// dwIndex, dwPhysAddrLen, bPhysAddr[8], dwAddr, dwType
+		row := buf[4+i*mibIpNetRowSize:]
+		if len(row) < mibIpNetRowSize {
+			break
+		}
+
+		macLen := binary.LittleEndian.Uint32(row[4:])
+		if macLen > 8 {
+			macLen = 8
+		}
+
+		state := "reachable"
+		switch binary.LittleEndian.Uint32(row[20:]) {
+		case 2:
+			state = "invalid"
+		case 4:
+			state = "permanent"
+		}
+
+		neighbours = append(neighbours, internal.Neighbour{
+			IP:        net.IP(row[16:20]).String(),
+			MAC:       net.HardwareAddr(row[8 : 8+macLen]).String(),
+			Interface: interfaceName(binary.LittleEndian.Uint32(row)),
+			State:     state,
+		})
+	}
+
+	return neighbours, nil
+}
+
+func Routes() ([]internal.Route, error) {
+	buf, err := fetchTable(procGetIpForwardTable)
+	if err != nil || len(buf) < 4 {
+		return nil, err
+	}
+
+	var routes []internal.Route
+
+	entries := binary.LittleEndian.Uint32(buf)
+	for i := uint32(0); i < entries; i++ {
+		// dwForwardDest, dwForwardMask, dwForwardPolicy, dwForwardNextHop, dwForwardIfIndex, dwForwardType, dwForwardProto, dwForwardAge, dwForwardNextHopAS, dwForwardMetric1...
+		row := buf[4+i*mibIpForwardRowSize:]
+		if len(row) < mibIpForwardRowSize {
+			break
+		}
+
+		ones, _ := net.IPMask(row[4:8]).Size()
+
+		routes = append(routes, internal.Route{
+			Destination: fmt.Sprintf("%s/%d", net.IP(row[0:4]), ones),
+			Gateway:     net.IP(row[12:16]).String(),
+			Interface:   interfaceName(binary.LittleEndian.Uint32(row[16:])),
+			Metric:      binary.LittleEndian.Uint32(row[36:]),
+		})
+	}
+
+	return routes, nil
+}
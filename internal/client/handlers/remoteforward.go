@@ -1,3 +1,6 @@
+//go:build !noforward
+// +build !noforward
+
 package handlers
 
 import (
@@ -51,6 +54,19 @@ func StopRemoteForward(rf internal.RemoteForwardRequest) error {
 	return nil
 }
 
+// StopServerRemoteForwards closes the ports opened at the request of the server, rather than by a user through jump
+func StopServerRemoteForwards() {
+	currentRemoteForwardsLck.Lock()
+	defer currentRemoteForwardsLck.Unlock()
+
+	for rf, c := range currentRemoteForwards {
+		if c.User == nil {
+			c.Listener.Close()
+			delete(currentRemoteForwards, rf)
+		}
+	}
+}
+
 func StartRemoteForward(user *internal.User, r *ssh.Request, sshConn ssh.Conn) {
 
 	var rf internal.RemoteForwardRequest
@@ -59,14 +75,22 @@ func StartRemoteForward(user *internal.User, r *ssh.Request, sshConn ssh.Conn) {
 		r.Reply(false, []byte(fmt.Sprintf("Unable to open remote forward: %s", err.Error())))
 		return
 	}
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", rf.BindAddr, rf.BindPort))
+	l, err := net.Listen("tcp", rf.String())
 	if err != nil {
 		r.Reply(false, []byte(fmt.Sprintf("Unable to open remote forward: %s", err.Error())))
 		return
 	}
 	defer l.Close()
 
-	defer StopRemoteForward(rf)
+	defer func() {
+		// The same address may have been opened again since this listener was closed, which must be left alone
+		currentRemoteForwardsLck.Lock()
+		if current, ok := currentRemoteForwards[rf]; ok && current.Listener == l {
+			delete(currentRemoteForwards, rf)
+			log.Println("Stopped listening on: ", rf.BindAddr, rf.BindPort)
+		}
+		currentRemoteForwardsLck.Unlock()
+	}()
 
 	if user != nil {
 		user.Lock()
@@ -136,6 +160,11 @@ func handleData(proxyCon net.Conn, sshConn ssh.Conn) error {
 		Rport: uint32(rPort),
 	}
 
+	return Relay(proxyCon, sshConn, drtMsg)
+}
+
+// Relay hands proxyCon to the server over sshConn, which treats it as a connection to its own listener, until either end closes
+func Relay(proxyCon net.Conn, sshConn ssh.Conn, drtMsg internal.ChannelOpenDirectMsg) error {
 	b := ssh.Marshal(&drtMsg)
 
 	destination, reqs, err := sshConn.OpenChannel("forwarded-tcpip", b)
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	capabilities = append(capabilities, "scan")
+}
+
+const (
+	maxScanHosts   = 65536
+	maxScanWorkers = 256
+	maxScanRate    = 10000
+)
+
+type scanRequest struct {
+	Network string
+	Ports   string
+	// Connection attempts per second
+	Rate uint32
+	// Per connection timeout in milliseconds
+	Timeout uint32
+}
+
+// Scan does a tcp connect scan from this host, writing open host:port pairs back down the channel as they're found
+func Scan(_ *internal.User, newChannel ssh.NewChannel, log logger.Logger) {
+	var req scanRequest
+	err := ssh.Unmarshal(newChannel.ExtraData(), &req)
+	if err != nil {
+		newChannel.Reject(ssh.Prohibited, "unable to unmarshal scan request: "+err.Error())
+		return
+	}
+
+	hosts, err := parseScanHosts(req.Network)
+	if err != nil {
+		newChannel.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+
+	ports, err := parseScanPorts(req.Ports)
+	if err != nil {
+		newChannel.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+
+	if req.Rate == 0 || req.Rate > maxScanRate || req.Timeout == 0 {
+		newChannel.Reject(ssh.Prohibited, fmt.Sprintf("rate must be between 1 and %d, and timeout greater than zero", maxScanRate))
+		return
+	}
+
+	connection, requests, err := newChannel.Accept()
+	if err != nil {
+		log.Warning("Unable to accept scan channel: %s", err)
+		return
+	}
+	defer connection.Close()
+
+	stop := make(chan struct{})
+	go func() {
+		// Server closing the channel means the operator has given up on the scan
+		ssh.DiscardRequests(requests)
+		close(stop)
+	}()
+
+	timeout := time.Duration(req.Timeout) * time.Millisecond
+	limiter := time.NewTicker(time.Second / time.Duration(req.Rate))
+	defer limiter.Stop()
+
+	targets := make(chan string)
+	var (
+		wg       sync.WaitGroup
+		writeLck sync.Mutex
+	)
+
+	workers := int(req.Rate)
+	if workers > maxScanWorkers {
+		workers = maxScanWorkers
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range targets {
+				c, err := net.DialTimeout("tcp", target, timeout)
+				if err != nil {
+					continue
+				}
+				c.Close()
+
+				writeLck.Lock()
+				fmt.Fprintf(connection, "%s\n", target)
+				writeLck.Unlock()
+			}
+		}()
+	}
+
+outer:
+	for _, host := range hosts {
+		for _, port := range ports {
+			select {
+			case <-stop:
+				break outer
+			case <-limiter.C:
+			}
+
+			targets <- net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+	close(targets)
+
+	wg.Wait()
+}
+
+func parseScanHosts(network string) ([]string, error) {
+	if ip := net.ParseIP(network); ip != nil {
+		return []string{ip.String()}, nil
+	}
+
+	ip, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q: %s", network, err)
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("network %q is too large, at most %d hosts can be scanned", network, maxScanHosts)
+	}
+
+	var hosts []string
+	for current := ip.Mask(ipnet.Mask); ipnet.Contains(current); current = nextIP(current) {
+		hosts = append(hosts, current.String())
+	}
+
+	// Network and broadcast addresses are rarely interesting on ipv4
+	if len(hosts) > 2 && ip.To4() != nil {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+
+	return hosts, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// Ports are a comma seperated list of single ports or ranges, e.g 22,80,8000-8100
+func parseScanPorts(spec string) ([]int, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		start, end := part, part
+		if i := strings.Index(part, "-"); i != -1 {
+			start, end = part[:i], part[i+1:]
+		}
+
+		s, err := strconv.Atoi(start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", start)
+		}
+
+		e, err := strconv.Atoi(end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", end)
+		}
+
+		if s < 1 || e > 65535 || s > e {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+
+		for p := s; p <= e; p++ {
+			set[p] = true
+		}
+	}
+
+	if len(set) == 0 {
+		return nil, errors.New("no ports specified")
+	}
+
+	var ports []int
+	for p := range set {
+		ports = append(ports, p)
+	}
+	sort.Ints(ports)
+
+	return ports, nil
+}
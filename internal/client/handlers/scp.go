@@ -1,3 +1,6 @@
+//go:build !notransfer
+// +build !notransfer
+
 package handlers
 
 import (
@@ -16,6 +19,10 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+func init() {
+	capabilities = append(capabilities, "transfer")
+}
+
 func scpError(severity int, reason string, connection io.Writer) {
 	connection.Write([]byte{byte(severity)})
 	connection.Write([]byte(reason + "\n"))
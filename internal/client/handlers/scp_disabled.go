@@ -0,0 +1,21 @@
+//go:build notransfer
+// +build notransfer
+
+package handlers
+
+import (
+	"errors"
+
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+func scp(commandParts []string, connection ssh.Channel, log logger.Logger) error {
+	log.Warning("Refused scp request, file transfer was disabled at build time")
+
+	// Severity 2 is a fatal scp error, the remote scp will print the reason and exit
+	connection.Write([]byte{2})
+	connection.Write([]byte("file transfer has been disabled on this client\n"))
+
+	return errors.New("file transfer disabled")
+}
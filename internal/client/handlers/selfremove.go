@@ -0,0 +1,18 @@
+//go:build !cshared && !windows
+// +build !cshared,!windows
+
+package handlers
+
+import (
+	"os"
+)
+
+// SelfRemove deletes the client binary, the caller is expected to exit shortly after
+func SelfRemove() error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
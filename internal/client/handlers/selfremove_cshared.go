@@ -0,0 +1,11 @@
+//go:build cshared
+// +build cshared
+
+package handlers
+
+import "errors"
+
+// As a shared object the executable is whatever loaded us, which is certainly not ours to delete
+func SelfRemove() error {
+	return errors.New("cannot remove a shared object client from disk, only exiting")
+}
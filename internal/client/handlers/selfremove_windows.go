@@ -0,0 +1,24 @@
+//go:build !cshared && windows
+// +build !cshared,windows
+
+package handlers
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// SelfRemove deletes the client binary, the caller is expected to exit shortly after.
+// Windows wont delete a running executable, so a detached cmd waits for us to exit first
+func SelfRemove() error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("cmd.exe", "/C", "ping -n 10 127.0.0.1 >nul & del /F /Q \""+path+"\"")
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+
+	return cmd.Start()
+}
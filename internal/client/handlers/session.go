@@ -14,6 +14,7 @@ import (
 
 	"github.com/NHAS/reverse_ssh/internal"
 	"github.com/NHAS/reverse_ssh/internal/client/handlers/subsystems"
+	"github.com/NHAS/reverse_ssh/internal/client/oslog"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/pkg/logger"
 	"github.com/NHAS/reverse_ssh/pkg/storage"
@@ -53,11 +54,25 @@ func Session(user *internal.User, newChannel ssh.NewChannel, log logger.Logger)
 	}
 	defer connection.Close()
 
+	// Set once the session runs something, so the system log has when it ended as well as when it started
+	var running string
+	defer func() {
+		if running != "" {
+			oslog.Event("Session ended: %s", running)
+		}
+	}()
+
+	started := func(what string) {
+		running = what
+		oslog.Event("Session started from %s: %s", user.ServerConnection.RemoteAddr(), what)
+	}
+
 	for req := range requests {
 		log.Info("Session got request: %q", req.Type)
 		switch req.Type {
 
 		case "subsystem":
+			started("subsystem")
 
 			err := subsystems.RunSubsystems(connection, req)
 			if err != nil {
@@ -86,6 +101,7 @@ func Session(user *internal.User, newChannel ssh.NewChannel, log logger.Logger)
 			}
 
 			command := line.Command.Value()
+			started("exec " + cmd.Cmd)
 
 			if command == "scp" {
 				scp(line.Chunks[1:], connection, log)
@@ -114,12 +130,14 @@ func Session(user *internal.User, newChannel ssh.NewChannel, log logger.Logger)
 			var shellPath internal.ShellStruct
 			err := ssh.Unmarshal(req.Payload, &shellPath)
 			if err != nil || shellPath.Cmd == "" {
+				started("shell")
 
 				//This blocks so will keep the channel from defer closing
 				shell(user, connection, requests, log)
 				return
 			}
 			parts := strings.Split(shellPath.Cmd, " ")
+			started("shell " + shellPath.Cmd)
 			if len(parts) > 0 {
 				command := parts[0]
 				if u, ok := isUrl(parts[0]); ok {
@@ -168,14 +186,9 @@ func runCommand(command string, args []string, connection ssh.Channel) {
 
 	cmd := exec.Command(command, args...)
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		fmt.Fprintf(connection, "%s", err.Error())
-		return
-	}
-	defer stdout.Close()
-
-	cmd.Stderr = cmd.Stdout
+	// Written to directly rather than through a pipe, so Run waits for the last of the output to be sent before returning
+	cmd.Stdout = connection
+	cmd.Stderr = connection
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -184,8 +197,11 @@ func runCommand(command string, args []string, connection ssh.Channel) {
 	}
 	defer stdin.Close()
 
-	go io.Copy(stdin, connection)
-	go io.Copy(connection, stdout)
+	// Closed as soon as the server has sent everything, so commands such as sort that read to the end of their input can finish
+	go func() {
+		io.Copy(stdin, connection)
+		stdin.Close()
+	}()
 
 	err = cmd.Run()
 	if err != nil {
@@ -152,7 +152,7 @@ func runCommandWithPty(command string, args []string, user *internal.User, reque
 	var err error
 	var shellIO io.ReadWriteCloser
 
-	shell.Env = append(shell.Env, "TERM="+user.Pty.Term)
+	shell.Env = append(shell.Env, "TERM="+user.Pty.TermOrDefault())
 
 	log.Info("Creating pty...")
 	shellIO, err = pty.StartWithSize(shell, &pty.Winsize{Cols: uint16(user.Pty.Columns), Rows: uint16(user.Pty.Rows)})
@@ -7,9 +7,8 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// Enable sftp for both windows and linux
+// Enable list for both windows and linux, sftp registers itself unless built with notransfer
 var subsystems = map[string]subsystem{
-	"sftp": new(subSftp),
 	"list": new(list),
 }
 
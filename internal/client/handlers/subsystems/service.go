@@ -9,6 +9,7 @@ import (
 	"os"
 
 	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/pkg/storage"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/sys/windows/svc/eventlog"
 	"golang.org/x/sys/windows/svc/mgr"
@@ -28,6 +29,10 @@ func (s *service) Execute(line terminal.ParsedLine, connection ssh.Channel, subs
 	if err != terminal.ErrFlagNotSet {
 		flagErr := err
 
+		if storage.MemoryOnly() {
+			return errors.New("client is in memory only mode, refusing to install service")
+		}
+
 		currentPath, err := os.Executable()
 		if err != nil {
 			return errors.New("Unable to find the current binary location: " + err.Error())
@@ -1,3 +1,6 @@
+//go:build !notransfer
+// +build !notransfer
+
 package subsystems
 
 import (
@@ -11,6 +14,10 @@ import (
 
 type subSftp bool
 
+func init() {
+	subsystems["sftp"] = new(subSftp)
+}
+
 func (s *subSftp) Execute(_ terminal.ParsedLine, connection ssh.Channel, subsystemReq *ssh.Request) error {
 	server, err := sftp.NewServer(connection)
 	if err != nil {
@@ -1,3 +1,6 @@
+//go:build !noforward
+// +build !noforward
+
 package handlers
 
 import (
@@ -68,6 +71,21 @@ func Tun(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
 	}
 	defer tunnel.Close()
 
+	ns, err := forwardingStack(NewSSHEndpoint(tunnel))
+	if err != nil {
+		l.Error("%s", err)
+		return
+	}
+	defer ns.Close()
+
+	ssh.DiscardRequests(req)
+
+	l.Info("Tunnel ended")
+
+}
+
+// forwardingStack creates a gvisor userland network stack on linkEP that makes the connections it is sent from this host
+func forwardingStack(linkEP stack.LinkEndpoint) (*stack.Stack, error) {
 	// Create a new gvisor userland network stack.
 	ns := stack.New(stack.Options{
 		NetworkProtocols: []stack.NetworkProtocolFactory{
@@ -82,21 +100,18 @@ func Tun(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
 		},
 		HandleLocal: false,
 	})
-	defer ns.Close()
-
-	linkEP := NewSSHEndpoint(tunnel)
 
 	const NICID = 1
 	// Create a new NIC
 	if err := ns.CreateNIC(NICID, linkEP); err != nil {
-		l.Error("CreateNIC: %v", err)
-		return
+		ns.Close()
+		return nil, fmt.Errorf("CreateNIC: %v", err)
 	}
 
-	err = icmpResponder(ns)
+	err := icmpResponder(ns)
 	if err != nil {
-		l.Error("Unable to create icmp responder: %v", err)
-		return
+		ns.Close()
+		return nil, fmt.Errorf("Unable to create icmp responder: %v", err)
 	}
 
 	// Forward TCP connections
@@ -137,10 +152,7 @@ func Tun(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
 	ns.SetPromiscuousMode(NICID, true)
 	ns.SetSpoofing(NICID, true)
 
-	ssh.DiscardRequests(req)
-
-	l.Info("Tunnel ended")
-
+	return ns, nil
 }
 
 func forwardUDP(stack *stack.Stack) func(request *udp.ForwarderRequest) {
@@ -235,20 +247,27 @@ func Proxy(c1, c2 net.Conn) error {
 	return nil
 }
 
+// packetDevice is where an SSHEndpoint gets its packets from, each channel type frames them differently
+type packetDevice interface {
+	ReadPacket() ([]byte, error)
+	WritePacket(packet []byte, protocol tcpip.NetworkProtocolNumber) error
+	MTU() uint32
+}
+
 type SSHEndpoint struct {
 	dispatcher stack.NetworkDispatcher
-	tunnel     ssh.Channel
+	device     packetDevice
 }
 
 func NewSSHEndpoint(dev ssh.Channel) *SSHEndpoint {
 	return &SSHEndpoint{
-		tunnel: dev,
+		device: openSSHTun{dev},
 	}
 }
 
 // MTU implements stack.LinkEndpoint.
 func (m *SSHEndpoint) MTU() uint32 {
-	return 1500
+	return m.device.MTU()
 }
 
 // Capabilities implements stack.LinkEndpoint.
@@ -274,26 +293,17 @@ func (m *SSHEndpoint) Attach(dispatcher stack.NetworkDispatcher) {
 
 func (m *SSHEndpoint) dispatchLoop() {
 	for {
-		packet := make([]byte, 1504)
-
-		n, err := m.tunnel.Read(packet)
+		packet, err := m.device.ReadPacket()
 		if err != nil {
 			break
 		}
 
-		if len(packet) < 4 {
-			continue
-		}
-
-		//Remove the SSH added family address uint32 (for layer 3 tun)
-		packet = packet[4:]
-
-		if !m.IsAttached() {
+		if len(packet) == 0 || !m.IsAttached() {
 			continue
 		}
 
 		pkb := stack.NewPacketBuffer(stack.PacketBufferOptions{
-			Payload: buffer.MakeWithData(packet[:n-4]),
+			Payload: buffer.MakeWithData(packet),
 		})
 
 		switch header.IPVersion(packet) {
@@ -322,29 +332,59 @@ func (m *SSHEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Erro
 	return n, nil
 }
 
-var lock sync.Mutex
-
 // WritePacket writes outbound packets
 func (m *SSHEndpoint) WritePacket(pkt stack.PacketBufferPtr) tcpip.Error {
 
 	pktBuf := pkt.ToBuffer()
 
+	if err := m.device.WritePacket(pktBuf.Flatten(), pkt.NetworkProtocolNumber); err != nil {
+		return &tcpip.ErrInvalidEndpointState{}
+	}
+	return nil
+}
+
+// openSSHTun is the tun@openssh.com framing, each channel message is one packet behind the 4 byte header of a tun device
+type openSSHTun struct {
+	tunnel ssh.Channel
+}
+
+func (o openSSHTun) MTU() uint32 {
+	return 1500
+}
+
+func (o openSSHTun) ReadPacket() ([]byte, error) {
+	packet := make([]byte, 1504)
+
+	n, err := o.tunnel.Read(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 4 {
+		return nil, nil
+	}
+
+	//Remove the SSH added family address uint32 (for layer 3 tun)
+	return packet[4:n], nil
+}
+
+var lock sync.Mutex
+
+func (o openSSHTun) WritePacket(contents []byte, protocol tcpip.NetworkProtocolNumber) error {
 	//I have quite literally no idea why a lock here fixes ssh issues
 	lock.Lock()
 	defer lock.Unlock()
 
 	// 3.2 Frame Format
 	// https://git.kernel.org/pub/scm/linux/kernel/git/torvalds/linux.git/tree/Documentation/networking/tuntap.rst?id=HEAD
-	packet := make([]byte, 4+pktBuf.Size())
+	packet := make([]byte, 4+len(contents))
 	binary.BigEndian.PutUint16(packet, 1)
-	binary.BigEndian.PutUint16(packet[2:], uint16(pkt.NetworkProtocolNumber))
+	binary.BigEndian.PutUint16(packet[2:], uint16(protocol))
 
-	copy(packet[4:], pktBuf.Flatten())
+	copy(packet[4:], contents)
 
-	if _, err := m.tunnel.Write(packet); err != nil {
-		return &tcpip.ErrInvalidEndpointState{}
-	}
-	return nil
+	_, err := o.tunnel.Write(packet)
+	return err
 }
 
 // Wait implements stack.LinkEndpoint.Wait.
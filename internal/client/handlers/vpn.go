@@ -0,0 +1,64 @@
+//go:build !noforward
+// +build !noforward
+
+package handlers
+
+import (
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/vpn"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"golang.org/x/crypto/ssh"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+func init() {
+	capabilities = append(capabilities, "vpn")
+}
+
+// VPN is opened by the server to route a tun device on the server through this host, the same way tun@openssh.com does for an operator
+func VPN(_ *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.Error("Recovered panic from vpn driver %v", r)
+		}
+	}()
+
+	var opts vpn.Options
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &opts); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "unable to parse vpn options")
+		return
+	}
+
+	if err := opts.Validate(); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	tunnel, req, err := newChannel.Accept()
+	if err != nil {
+		l.Warning("Unable to accept new channel %s", err)
+		return
+	}
+	defer tunnel.Close()
+
+	ns, err := forwardingStack(&SSHEndpoint{device: vpnDevice{vpn.NewConn(tunnel, opts)}})
+	if err != nil {
+		l.Error("%s", err)
+		return
+	}
+	defer ns.Close()
+
+	l.Info("VPN started, mtu %d compression %t", opts.MTU, opts.Compress)
+
+	ssh.DiscardRequests(req)
+
+	l.Info("VPN ended")
+}
+
+type vpnDevice struct {
+	*vpn.Conn
+}
+
+func (v vpnDevice) WritePacket(packet []byte, _ tcpip.NetworkProtocolNumber) error {
+	return v.Conn.WritePacket(packet)
+}
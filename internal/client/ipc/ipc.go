@@ -0,0 +1,18 @@
+// Package ipc provides a same-user-only local endpoint, a unix socket or named pipe depending on platform
+package ipc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Name derives a stable endpoint name from the values that identify a client instance, so a second copy of the same client finds the first
+func Name(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+
+	return "rssh-" + hex.EncodeToString(h.Sum(nil))[:16]
+}
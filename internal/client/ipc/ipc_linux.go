@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package ipc
+
+import (
+	"errors"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Abstract sockets never touch the filesystem, but have no permissions either so peers are checked with SO_PEERCRED
+func address(name string) string {
+	return "@" + name
+}
+
+func Listen(name string) (net.Listener, error) {
+	l, err := net.Listen("unix", address(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &peerCheckedListener{l.(*net.UnixListener)}, nil
+}
+
+func Dial(name string) (net.Conn, error) {
+	return net.Dial("unix", address(name))
+}
+
+func checkPeer(c *net.UnixConn) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var (
+		cred    *unix.Ucred
+		credErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+
+	if int(cred.Uid) != os.Getuid() {
+		return errors.New("peer is owned by a different user")
+	}
+
+	return nil
+}
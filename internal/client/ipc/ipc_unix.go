@@ -0,0 +1,53 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package ipc
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func address(name string) string {
+	return filepath.Join(os.TempDir(), "."+name+".sock")
+}
+
+func Listen(name string) (net.Listener, error) {
+	if !peerCredentials {
+		return nil, errors.New("the local endpoint cannot check who connects to it on this platform")
+	}
+
+	path := address(name)
+
+	// A previous instance that died leaves its socket behind, only remove it if nothing answers
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, syscall.EADDRINUSE
+	}
+	os.Remove(path)
+
+	oldMask := syscall.Umask(0077)
+	defer syscall.Umask(oldMask)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The umask already leaves only the owner able to connect, this is in case it was ignored
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	l.(*net.UnixListener).SetUnlinkOnClose(true)
+
+	return &peerCheckedListener{l.(*net.UnixListener)}, nil
+}
+
+func Dial(name string) (net.Conn, error) {
+	return net.Dial("unix", address(name))
+}
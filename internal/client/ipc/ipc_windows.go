@@ -0,0 +1,193 @@
+//go:build windows
+// +build windows
+
+package ipc
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func address(name string) string {
+	return `\\.\pipe\` + name
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
+
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+type pipeListener struct {
+	sync.Mutex
+	addr   pipeAddr
+	sa     *windows.SecurityAttributes
+	closed bool
+
+	// The instance created by Listen, which holds the name for us until the first Accept
+	pending windows.Handle
+}
+
+func Listen(name string) (net.Listener, error) {
+	token := windows.GetCurrentProcessToken()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the user running the client may connect
+	sd, err := windows.SecurityDescriptorFromString("D:P(A;;GA;;;" + user.User.Sid.String() + ")")
+	if err != nil {
+		return nil, err
+	}
+
+	l := &pipeListener{
+		addr: pipeAddr(address(name)),
+		sa: &windows.SecurityAttributes{
+			Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+			SecurityDescriptor: sd,
+		},
+	}
+
+	// Create the first instance now so callers find out immediately if another client owns the name
+	l.pending, err = l.create(windows.FILE_FLAG_FIRST_PIPE_INSTANCE)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *pipeListener) create(flags uint32) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(string(l.addr))
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+
+	return windows.CreateNamedPipe(name,
+		windows.PIPE_ACCESS_DUPLEX|flags,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES,
+		4096, 4096, 0, l.sa)
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	for {
+		l.Lock()
+		if l.closed {
+			l.Unlock()
+			return nil, net.ErrClosed
+		}
+
+		h := l.pending
+		l.pending = 0
+
+		var err error
+		if h == 0 {
+			h, err = l.create(0)
+		}
+		l.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		err = windows.ConnectNamedPipe(h, nil)
+		if err != nil && !errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+			windows.CloseHandle(h)
+			return nil, err
+		}
+
+		// The DACL should already have kept anyone else out, this catches a pipe squatted with a looser one
+		if err := checkPeer(h); err != nil {
+			windows.CloseHandle(h)
+			continue
+		}
+
+		return &pipeConn{File: os.NewFile(uintptr(h), string(l.addr)), addr: l.addr}, nil
+	}
+}
+
+var procGetNamedPipeClientProcessId = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetNamedPipeClientProcessId")
+
+// checkPeer refuses a pipe client running as anyone but the user the client runs as
+func checkPeer(h windows.Handle) error {
+	var pid uint32
+	if r, _, err := procGetNamedPipeClientProcessId.Call(uintptr(h), uintptr(unsafe.Pointer(&pid))); r == 0 {
+		return err
+	}
+
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(process)
+
+	var token windows.Token
+	if err := windows.OpenProcessToken(process, windows.TOKEN_QUERY, &token); err != nil {
+		return err
+	}
+	defer token.Close()
+
+	peer, err := token.GetTokenUser()
+	if err != nil {
+		return err
+	}
+
+	self, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return err
+	}
+
+	if !peer.User.Sid.Equals(self.User.Sid) {
+		return errors.New("peer is running as a different user")
+	}
+
+	return nil
+}
+
+func (l *pipeListener) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.closed = true
+	if l.pending != 0 {
+		windows.CloseHandle(l.pending)
+		l.pending = 0
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
+
+func Dial(name string) (net.Conn, error) {
+	f, err := os.OpenFile(address(name), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pipeConn{File: f, addr: pipeAddr(address(name))}, nil
+}
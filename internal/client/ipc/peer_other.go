@@ -0,0 +1,16 @@
+//go:build !linux && !windows && !darwin && !freebsd
+// +build !linux,!windows,!darwin,!freebsd
+
+package ipc
+
+import (
+	"errors"
+	"net"
+)
+
+// Without a way to ask who is on the other end the socket permissions would be all that kept other users out, so Listen refuses
+const peerCredentials = false
+
+func checkPeer(c *net.UnixConn) error {
+	return errors.New("peer credentials cannot be checked on this platform")
+}
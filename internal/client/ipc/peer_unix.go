@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package ipc
+
+import "net"
+
+// peerCheckedListener drops connections from other users before they are returned by Accept
+type peerCheckedListener struct {
+	*net.UnixListener
+}
+
+func (l *peerCheckedListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkPeer(c); err != nil {
+			c.Close()
+			continue
+		}
+
+		return c, nil
+	}
+}
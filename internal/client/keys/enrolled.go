@@ -0,0 +1,96 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/pkg/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	memoryKeysLck sync.Mutex
+	memoryKeys    = map[string]ssh.Signer{}
+)
+
+func enrolledName(destination string) string {
+	sum := sha256.Sum256([]byte(destination))
+	return "rssh-" + hex.EncodeToString(sum[:8])
+}
+
+func enrolledPath(name string) string {
+	if storage.MemoryOnly() {
+		return ""
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// LoadEnrolledKey returns the key this host was given for a destination by enrolling with a token or having its key rotated, if it has one
+func LoadEnrolledKey(destination string) (ssh.Signer, bool) {
+	memoryKeysLck.Lock()
+	defer memoryKeysLck.Unlock()
+
+	name := enrolledName(destination)
+	if signer, ok := memoryKeys[name]; ok {
+		return signer, true
+	}
+
+	if path := enrolledPath(name); path != "" {
+		if existing, err := os.ReadFile(path); err == nil {
+			if signer, err := ssh.ParsePrivateKey(existing); err == nil {
+				memoryKeys[name] = signer
+				return signer, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// NewEnrolledKey generates a key for a destination, replacing any existing one.
+// It is kept in the users config directory so the client can reconnect after a restart, or only in memory when in memory only mode
+func NewEnrolledKey(destination string) (ssh.Signer, error) {
+	pem, err := internal.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, err
+	}
+
+	memoryKeysLck.Lock()
+	defer memoryKeysLck.Unlock()
+
+	name := enrolledName(destination)
+	memoryKeys[name] = signer
+
+	if path := enrolledPath(name); path != "" {
+		// Failing to save just means falling back to the built in key (or enrolling again) after a restart
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			os.WriteFile(path, pem, 0600)
+		}
+	}
+
+	return signer, nil
+}
+
+// GetEnrolledKey returns the enrolled key for a destination, generating one the first time a client enrolls
+func GetEnrolledKey(destination string) (ssh.Signer, error) {
+	if signer, ok := LoadEnrolledKey(destination); ok {
+		return signer, nil
+	}
+
+	return NewEnrolledKey(destination)
+}
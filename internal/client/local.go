@@ -0,0 +1,115 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/client/handlers"
+	"github.com/NHAS/reverse_ssh/internal/client/ipc"
+	"github.com/NHAS/reverse_ssh/internal/client/keys"
+	"github.com/NHAS/reverse_ssh/pkg/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	currentConnLck sync.RWMutex
+	currentConn    ssh.Conn
+
+	localEndpoint bool
+)
+
+// SetLocalEndpoint has the client listen on a local endpoint only its own user can reach, see startLocalEndpoint. It is off unless
+// asked for, as a second copy of the client finding the endpoint exits rather than connecting
+func SetLocalEndpoint(enabled bool) {
+	localEndpoint = enabled
+}
+
+func setCurrentConn(sc ssh.Conn) {
+	currentConnLck.Lock()
+	defer currentConnLck.Unlock()
+
+	currentConn = sc
+}
+
+func localEndpointName(sshPriv ssh.Signer, addr string) string {
+	return ipc.Name(internal.FingerprintSHA256Hex(sshPriv.PublicKey()), addr)
+}
+
+// Lets local tooling use our server connection rather than making another one. Each connection to the endpoint is relayed to the
+// server as if it had been made to the server's own listener, so what is on the other end still has to log in like anyone else
+func startLocalEndpoint(name string) error {
+	if storage.MemoryOnly() && runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		return errors.New("local endpoint needs a socket file, which memory only mode forbids")
+	}
+
+	l, err := ipc.Listen(name)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer l.Close()
+
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				log.Println("Local endpoint stopped: ", err)
+				return
+			}
+
+			go func(c net.Conn) {
+				defer c.Close()
+
+				currentConnLck.RLock()
+				sc := currentConn
+				currentConnLck.RUnlock()
+
+				// Between connections to the server, whoever is waiting can try again
+				if sc == nil {
+					return
+				}
+
+				// There is no address for the server to see, so the connection appears to come from the client's loopback
+				err := handlers.Relay(c, sc, internal.ChannelOpenDirectMsg{Laddr: "127.0.0.1", Raddr: "127.0.0.1"})
+				if err != nil {
+					log.Println("Unable to relay local endpoint connection: ", err)
+				}
+			}(c)
+		}
+	}()
+
+	return nil
+}
+
+// LocalBridge connects stdin and stdout to the local endpoint of an already running client, e.g for use as an ssh ProxyCommand
+func LocalBridge(addr string) error {
+	sshPriv, err := keys.GetPrivateKey()
+	if err != nil {
+		return err
+	}
+
+	c, err := ipc.Dial(localEndpointName(sshPriv, addr))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(c, os.Stdin)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(os.Stdout, c)
+		done <- struct{}{}
+	}()
+
+	<-done
+	return nil
+}
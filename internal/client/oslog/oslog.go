@@ -0,0 +1,75 @@
+// Package oslog records what operators do on a client in the machine's own log, the Windows Event Log, the unified log on macOS or
+// syslog elsewhere, for deployments where the client is a remote administration tool that has to be audited. It is off unless
+// Enable is called
+package oslog
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/pkg/storage"
+)
+
+// Source is the name events are logged under
+const Source = "rssh"
+
+type sink interface {
+	Info(message string) error
+	Close() error
+}
+
+var (
+	lock    sync.Mutex
+	current sink
+)
+
+// Enable starts sending events to the operating system's log
+func Enable() error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if current != nil {
+		return nil
+	}
+
+	s, err := open()
+	if err != nil {
+		return fmt.Errorf("unable to open the system log: %s", err)
+	}
+
+	current = s
+	return nil
+}
+
+// Disable stops sending events
+func Disable() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if current != nil {
+		current.Close()
+		current = nil
+	}
+}
+
+func Enabled() bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return current != nil
+}
+
+// Event logs what happened if logging to the system is enabled. Nothing is logged in memory only mode, the system log is kept on disk
+func Event(format string, v ...interface{}) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if current == nil || storage.MemoryOnly() {
+		return
+	}
+
+	if err := current.Info(fmt.Sprintf(format, v...)); err != nil {
+		log.Println("Unable to write to the system log: ", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build plan9
+// +build plan9
+
+package oslog
+
+import "errors"
+
+func open() (sink, error) {
+	return nil, errors.New("there is no system log on this platform")
+}
@@ -0,0 +1,46 @@
+package oslog
+
+import (
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/pkg/storage"
+)
+
+type recorded struct {
+	messages []string
+	closed   bool
+}
+
+func (r *recorded) Info(message string) error {
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func (r *recorded) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestEvent(t *testing.T) {
+	// Off by default
+	Event("not logged")
+
+	r := &recorded{}
+	current = r
+	defer Disable()
+
+	Event("Session started: %s", "shell")
+
+	storage.SetMemoryOnly(true)
+	Event("not logged in memory only mode")
+	storage.SetMemoryOnly(false)
+
+	if len(r.messages) != 1 || r.messages[0] != "Session started: shell" {
+		t.Fatalf("unexpected messages %q", r.messages)
+	}
+
+	Disable()
+	if !r.closed || Enabled() {
+		t.Fatal("expected the log to be closed")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package oslog
+
+import (
+	"log/syslog"
+)
+
+// On macOS syslog messages end up in the unified log
+func open() (sink, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, Source)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+package oslog
+
+import (
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+const eventID = 1
+
+type eventLog struct {
+	*eventlog.Log
+}
+
+func (e eventLog) Info(message string) error {
+	return e.Log.Info(eventID, message)
+}
+
+func open() (sink, error) {
+	// Registering the source needs administrator rights, without it events are still written but Event Viewer notes their
+	// description could not be found before showing the message
+	eventlog.InstallAsEventCreate(Source, eventlog.Info|eventlog.Warning|eventlog.Error)
+
+	l, err := eventlog.Open(Source)
+	if err != nil {
+		return nil, err
+	}
+
+	return eventLog{l}, nil
+}
@@ -0,0 +1,81 @@
+// Package compress deflates a stream in both directions. It is only worth using on channels that carry plain data between the server
+// and a client, as anything already encrypted (such as the ssh connection inside a jump channel) does not compress
+package compress
+
+import (
+	"compress/flate"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ChannelType is direct-tcpip with the stream compressed, the extra data is the same internal.ChannelOpenDirectMsg
+const ChannelType = "compressed-tcpip"
+
+type Stream struct {
+	// Kept first so they are aligned for atomic use on 32 bit platforms
+	raw, wire uint64
+
+	rw io.ReadWriteCloser
+	r  io.Reader
+
+	writeLock sync.Mutex
+	w         *flate.Writer
+}
+
+type counter struct {
+	rw io.ReadWriter
+	n  *uint64
+}
+
+func (c counter) Read(b []byte) (int, error) {
+	n, err := c.rw.Read(b)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+func (c counter) Write(b []byte) (int, error) {
+	n, err := c.rw.Write(b)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+func NewStream(rw io.ReadWriteCloser) *Stream {
+	s := &Stream{rw: rw}
+
+	wire := counter{rw: rw, n: &s.wire}
+	s.r = flate.NewReader(wire)
+	// The error is only for invalid levels
+	s.w, _ = flate.NewWriter(wire, flate.BestSpeed)
+
+	return s
+}
+
+func (s *Stream) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	atomic.AddUint64(&s.raw, uint64(n))
+	return n, err
+}
+
+// Write compresses b and flushes it, so nothing is held back waiting for more to come
+func (s *Stream) Write(b []byte) (int, error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	n, err := s.w.Write(b)
+	atomic.AddUint64(&s.raw, uint64(n))
+	if err != nil {
+		return n, err
+	}
+
+	return n, s.w.Flush()
+}
+
+func (s *Stream) Close() error {
+	return s.rw.Close()
+}
+
+// Stats returns the bytes read and written before compression, and what they took up on the wire
+func (s *Stream) Stats() (raw, wire uint64) {
+	return atomic.LoadUint64(&s.raw), atomic.LoadUint64(&s.wire)
+}
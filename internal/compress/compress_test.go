@@ -0,0 +1,47 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	a, b := net.Pipe()
+	client, server := NewStream(a), NewStream(b)
+
+	message := bytes.Repeat([]byte("SELECT * FROM users WHERE id = 1;\n"), 100)
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		if _, err := client.Write(message); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	got := make([]byte, len(message))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, message) {
+		t.Fatal("message was changed in transit")
+	}
+
+	<-written
+
+	raw, wire := client.Stats()
+	if raw != uint64(len(message)) {
+		t.Errorf("expected %d raw bytes written, got %d", len(message), raw)
+	}
+
+	if wire == 0 || wire >= raw/10 {
+		t.Errorf("repetitive data should compress well, %d bytes became %d", raw, wire)
+	}
+
+	if serverRaw, serverWire := server.Stats(); serverRaw != raw || serverWire != wire {
+		t.Errorf("both ends should agree on the traffic, %d/%d and %d/%d", raw, wire, serverRaw, serverWire)
+	}
+}
@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"errors"
+)
+
+// ErrNotFIPS is returned when FIPS mode is asked for from a binary that was not built with validated crypto
+var ErrNotFIPS = errors.New("this binary was not built with FIPS validated crypto, build it with GOEXPERIMENT=boringcrypto (RSSH_FIPS=true make) on linux/amd64 or linux/arm64")
+
+// Set from the build, binaries using boringcrypto are always in FIPS mode
+var fips = fipsBuild()
+
+// FIPS reports whether only FIPS approved algorithms may be negotiated, which is the case when the binary was built with boringcrypto
+func FIPS() bool {
+	return fips
+}
+
+// RequireFIPS fails unless the crypto this binary uses is FIPS validated
+func RequireFIPS() error {
+	if !fips {
+		return ErrNotFIPS
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package internal
+
+import (
+	"crypto/boring"
+	// Restricts TLS, the transport clients can connect over, to FIPS approved settings as well
+	_ "crypto/tls/fipsonly"
+)
+
+func fipsBuild() bool {
+	return boring.Enabled()
+}
@@ -0,0 +1,8 @@
+//go:build !boringcrypto
+// +build !boringcrypto
+
+package internal
+
+func fipsBuild() bool {
+	return false
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFIPSPolicy(t *testing.T) {
+	defer func(was bool) { fips = was }(fips)
+	fips = true
+
+	p, err := AlgorithmPolicy{}.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Profile != ProfileFIPS {
+		t.Fatalf("expected FIPS mode to default to the %s profile, not %s", ProfileFIPS, p.Profile)
+	}
+
+	if p.AllowsOperatorKey(ssh.KeyAlgoED25519) || p.AllowsClientKey(ssh.KeyAlgoED25519) {
+		t.Error("ed25519 keys should not be allowed in FIPS mode")
+	}
+
+	if !p.AllowsOperatorKey(ssh.KeyAlgoECDSA256) || !p.AllowsClientKey(ssh.KeyAlgoECDSA256) {
+		t.Error("ecdsa keys should be allowed in FIPS mode")
+	}
+
+	for _, bad := range []AlgorithmPolicy{
+		{Profile: ProfileHardened},
+		{Ciphers: []string{"chacha20-poly1305@openssh.com"}},
+		{KeyExchanges: []string{"curve25519-sha256"}},
+		{HostKeyAlgorithms: []string{ssh.KeyAlgoED25519}},
+	} {
+		if _, err := bad.Resolve(); err == nil {
+			t.Errorf("expected %+v to be refused in FIPS mode", bad)
+		}
+	}
+
+	key, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(key)
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signer.PublicKey().Type() != ssh.KeyAlgoECDSA256 {
+		t.Errorf("expected FIPS mode to generate ecdsa keys, not %s", signer.PublicKey().Type())
+	}
+}
+
+func TestFIPSProfileOutsideFIPSMode(t *testing.T) {
+	p, err := AlgorithmPolicy{Profile: ProfileFIPS}.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.AllowsOperatorKey(ssh.KeyAlgoED25519) {
+		t.Error("the fips profile should not allow ed25519 operator keys")
+	}
+
+	if !FIPS() && RequireFIPS() == nil {
+		t.Error("expected a build without boringcrypto to fail RequireFIPS")
+	}
+}
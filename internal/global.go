@@ -1,7 +1,9 @@
 package internal
 
 import (
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
@@ -12,6 +14,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/NHAS/reverse_ssh/pkg/logger"
@@ -30,7 +33,7 @@ type RemoteForwardRequest struct {
 }
 
 func (r *RemoteForwardRequest) String() string {
-	return fmt.Sprintf("%s:%d", r.BindAddr, r.BindPort)
+	return net.JoinHostPort(r.BindAddr, strconv.Itoa(int(r.BindPort)))
 }
 
 // https://tools.ietf.org/html/rfc4254
@@ -41,8 +44,18 @@ type ChannelOpenDirectMsg struct {
 	Lport uint32
 }
 
+// GeneratePrivateKey makes an ed25519 key, or a P-256 ecdsa key in FIPS mode as ed25519 is not approved there
 func GeneratePrivateKey() ([]byte, error) {
-	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	var (
+		priv interface{}
+		err  error
+	)
+
+	if FIPS() {
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -86,6 +99,42 @@ type PtyReq struct {
 	Modes         string
 }
 
+// ModeECHO is the terminal mode, from RFC 4254 section 8, saying whether what is typed is echoed back
+const ModeECHO = 53
+
+// DefaultTerm is what an operator's terminal is taken to be when it did not say
+const DefaultTerm = "dumb"
+
+// Mode gives the value the operator's terminal set for a mode, modes are a list of one byte opcodes each followed by a four byte
+// value, ended by opcode 0. Opcodes from 160 on have no defined meaning and stop the list being read
+func (p *PtyReq) Mode(opcode byte) (uint32, bool) {
+	modes := []byte(p.Modes)
+	for len(modes) >= 5 && modes[0] != 0 && modes[0] < 160 {
+		if modes[0] == opcode {
+			return binary.BigEndian.Uint32(modes[1:5]), true
+		}
+		modes = modes[5:]
+	}
+	return 0, false
+}
+
+// TermOrDefault is the TERM to give programs run for the operator
+func (p *PtyReq) TermOrDefault() string {
+	if p.Term == "" {
+		return DefaultTerm
+	}
+	return p.Term
+}
+
+// Dumb reports whether the operator's terminal understands no escape sequences, so nothing should be drawn with them
+func (p *PtyReq) Dumb() bool {
+	switch p.TermOrDefault() {
+	case "dumb", "unknown":
+		return true
+	}
+	return false
+}
+
 type ClientInfo struct {
 	Username string
 	Hostname string
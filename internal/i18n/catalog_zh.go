@@ -0,0 +1,112 @@
+package i18n
+
+func init() {
+	register("zh", map[string]string{
+		// help
+		"Commands":     "命令",
+		"Function":     "命令",
+		"Purpose":      "用途",
+		"description:": "说明:",
+		"usage:":       "用法:",
+		"Print help":   "显示帮助",
+
+		// What each command is for, as shown by help
+		"Add or remove webhooks":                                                         "添加或删除 webhook",
+		"Approve or deny high risk commands queued by operators":                         "批准或拒绝操作员排队的高风险命令",
+		"Bound clients to the time window of an engagement":                              "将客户端限制在某次任务的时间窗口内",
+		"Change where a built client calls back to without rebuilding it":                "无需重新构建即可修改已构建客户端的回连地址",
+		"Change, add or stop rssh server port. Open the server port on a client (proxy)": "修改、添加或停止 rssh 服务端口，或在客户端上开放服务端口（代理）",
+		"Close server console":                                                           "关闭服务器控制台",
+		"Compress connections the server opens through clients":                          "压缩服务器经由客户端建立的连接",
+		"End a remote controllable host instance.":                                       "结束一个可控远程主机实例。",
+		"Execute a command on one or more rssh client":                                   "在一个或多个 rssh 客户端上执行命令",
+		"Export every client the server knows of, or import them from another server":    "导出服务器已知的所有客户端，或从另一台服务器导入",
+		"Find clients by their notes, hostname, address, key or version":                 "按备注、主机名、地址、密钥或版本查找客户端",
+		"Generate client binary and return link to it":                                   "生成客户端程序并返回下载链接",
+		"Get help for commands, or display all commands":                                 "获取命令帮助，或列出所有命令",
+		"Give server build version":                                                      "显示服务器构建版本",
+		"Install or remove persistence on a client":                                      "在客户端上安装或移除持久化",
+		"Keep notes against a client, such as what the machine is or who owns it":        "为客户端记录备注，例如机器用途或归属",
+		"Label clients so groups of them can be found together":                          "为客户端添加标签，以便成组查找",
+		"List clients connected from a second address, and release quarantined ones":     "列出从第二个地址连接的客户端，并释放被隔离的客户端",
+		"List connected controllable hosts.":                                             "列出已连接的可控主机。",
+		"List users connected to the RSSH server":                                        "列出连接到 RSSH 服务器的用户",
+		"Manage addresses the server refuses new logins from":                            "管理服务器拒绝新登录的地址",
+		"Manage keys, tokens and web paths that raise the alarm when used":               "管理一经使用即触发告警的密钥、令牌和网页路径",
+		"Manage named forwards with access lists":                                        "管理带访问控制列表的命名转发",
+		"Manage secrets stored encrypted on the server":                                  "管理服务器上加密存储的机密",
+		"Manage short lived tokens clients can enroll with":                              "管理客户端注册用的短期令牌",
+		"Open a raw tcp connection through a client":                                     "经由客户端打开原始 tcp 连接",
+		"Package logs and client records for a time range into a signed archive":         "将某一时间段的日志和客户端记录打包为签名归档",
+		"Plain output for screen readers":                                                "适用于屏幕阅读器的纯文本输出",
+		"Pick the language help and messages are shown in":                               "选择帮助和消息使用的语言",
+		"Remove variables": "删除变量",
+		"Replace the key a client authenticates with":                       "更换客户端认证使用的密钥",
+		"Route a tun device on the server through a client":                 "将服务器上的 tun 设备经由客户端路由",
+		"Run a command once for every matching client":                      "对每个匹配的客户端各运行一次命令",
+		"Run a command only if a comparison holds":                          "仅在比较成立时运行命令",
+		"Set a variable for use in later commands":                          "设置供后续命令使用的变量",
+		"Show a client's ARP/neighbour cache":                               "显示客户端的 ARP/邻居缓存",
+		"Show a client's routing table":                                     "显示客户端的路由表",
+		"Show details about a connected client":                             "显示已连接客户端的详细信息",
+		"Show the SSH algorithms each live connection negotiated":           "显示每个活动连接协商的 SSH 算法",
+		"Show what is known about a client, whether or not it is connected": "显示关于某客户端的已知信息，无论其是否在线",
+		"Show which networks each client can reach":                         "显示每个客户端可以访问的网络",
+		"Start shell on remote controllable host.":                          "在可控远程主机上启动 shell。",
+		"TCP connect scan from a client":                                    "从客户端进行 TCP 连接扫描",
+		"Turn whole parts of the server off for everyone":                   "为所有人关闭服务器的部分功能",
+		"View or change whether clients avoid writing to disk":              "查看或修改客户端是否避免写入磁盘",
+		"Watches controllable client connections":                           "监视可控客户端的连接",
+
+		// Usage text of the commands used most
+		"Filter uses glob matching against all attributes of a target (id, public key hash, hostname, ip)": "过滤条件使用通配符匹配目标的所有属性（id、公钥哈希、主机名、ip）",
+		"Print all attributes in pretty table":                                                                                                     "以表格显示所有属性",
+		"Also list clients that have connected before but are not connected now":                                                                   "同时列出曾经连接但当前不在线的客户端",
+		"Show the client limits the server was started with, and how many clients they have refused":                                               "显示服务器启动时设置的客户端限制，以及因此被拒绝的客户端数量",
+		"Filter uses glob matching against all attributes of a target (hostname, ip, id), allowing you to run a command against multiple machines": "过滤条件使用通配符匹配目标的所有属性（主机名、ip、id），可以在多台机器上运行命令",
+		"Quiet, no output (will also remove confirmation prompt)":                                                                                  "静默，无输出（同时取消确认提示）",
+		"No confirmation prompt":                                    "不提示确认",
+		"Do not label output blocks with the client they came from": "不在输出块上标注来源客户端",
+		"The command may reference vault secrets as vault:name, these are filled in just before it is sent": "命令可以用 vault:name 引用保险库中的机密，发送前才会填入",
+		"Use accessible output":                  "使用无障碍输出",
+		"Go back to tables and columns":          "恢复表格和分栏输出",
+		"Show the language in use, or change it": "显示当前语言，或切换语言",
+		"Languages: %s":                          "可用语言: %s",
+		"The choice is kept against your key, so it applies to every session you open. Without one, the LANG your ssh client sends is used": "该选择按你的密钥保存，对你之后打开的每个会话生效。未设置时使用 ssh 客户端发送的 LANG",
+
+		// Messages
+		"Unknown command: %s":           "未知命令: %s",
+		"Command %s not found":          "找不到命令 %s",
+		"No RSSH clients connected":     "没有已连接的 RSSH 客户端",
+		"Unable to find match for '%s'": "找不到与 '%s' 匹配的客户端",
+		"No clients matched '%s'":       "没有与 '%s' 匹配的客户端",
+		"Unknown action '%s'":           "未知操作 '%s'",
+		"'%s' matches multiple clients please choose a more specific identifier": "'%s' 匹配了多个客户端，请使用更具体的标识",
+		"Cannot specify on and off at the same time":                             "不能同时指定 on 和 off",
+		"Unable to save preference: %s":                                          "无法保存偏好设置: %s",
+		"Unknown language '%s', available: %s":                                   "未知语言 '%s'，可用语言: %s",
+		"Language is %s":                                                         "当前语言为 %s",
+		"Language set to %s":                                                     "语言已设置为 %s",
+		"Accessible output is on":                                                "无障碍输出已开启",
+		"Accessible output is off":                                               "无障碍输出已关闭",
+		"Accessible output is on, tables are written as labelled lines and the window title is left alone": "无障碍输出已开启，表格将以带标签的行输出，且不再设置窗口标题",
+		"User did not enter y/Y, aborting": "用户没有输入 y/Y，已取消",
+		"Unable to start shell: %s":        "无法启动 shell: %s",
+
+		"Only admins can turn parts of the server on or off":   "只有管理员可以开启或关闭服务器的部分功能",
+		"Only admins can see or change canaries":               "只有管理员可以查看或修改诱饵",
+		"Only admins can rotate client keys":                   "只有管理员可以更换客户端密钥",
+		"Only admins can remove forwards made by someone else": "只有管理员可以删除他人创建的转发",
+		"Only admins can release quarantined clones":           "只有管理员可以释放被隔离的克隆客户端",
+		"Only admins can manage vpn gateways":                  "只有管理员可以管理 vpn 网关",
+		"Only admins can import an inventory":                  "只有管理员可以导入客户端清单",
+		"Only admins can export evidence":                      "只有管理员可以导出取证归档",
+		"Only admins can decide on approvals":                  "只有管理员可以处理审批",
+		"Only admins can configure clients":                    "只有管理员可以配置客户端",
+		"Only admins can change the vault":                     "只有管理员可以修改保险库",
+		"Only admins can change enrollment tokens":             "只有管理员可以修改注册令牌",
+		"Only admins can change engagements":                   "只有管理员可以修改任务",
+		"Only admins can change client compression":            "只有管理员可以修改客户端压缩设置",
+		"Only admins can change bans":                          "只有管理员可以修改封禁列表",
+	})
+}
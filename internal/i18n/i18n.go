@@ -0,0 +1,139 @@
+// Package i18n translates console help and messages into the language an operator picked. Catalogs are keyed by the English
+// text, which is the English catalog, so anything without a translation is shown as written. Keys may contain %s to match
+// messages made with values in them, the values are put into the translation's %s in the same order
+package i18n
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const English = "en"
+
+type pattern struct {
+	match       *regexp.Regexp
+	translation string
+}
+
+type catalog struct {
+	exact    map[string]string
+	patterns []pattern
+}
+
+var catalogs = map[string]*catalog{}
+
+func register(lang string, messages map[string]string) {
+	c := &catalog{exact: map[string]string{}}
+
+	for english, translated := range messages {
+		if !strings.Contains(english, "%s") {
+			c.exact[english] = translated
+			continue
+		}
+
+		parts := strings.Split(english, "%s")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+
+		c.patterns = append(c.patterns, pattern{
+			match:       regexp.MustCompile("^" + strings.Join(parts, "(.+?)") + "$"),
+			translation: translated,
+		})
+	}
+
+	// Longest first so the most specific message wins when several could match
+	sort.Slice(c.patterns, func(i, j int) bool {
+		return len(c.patterns[i].match.String()) > len(c.patterns[j].match.String())
+	})
+
+	catalogs[lang] = c
+}
+
+// Languages lists the languages there are catalogs for
+func Languages() []string {
+	out := []string{English}
+	for lang := range catalogs {
+		out = append(out, lang)
+	}
+	sort.Strings(out[1:])
+	return out
+}
+
+// Parse takes a language as given to the lang command or in a LANG style environment variable, such as zh_CN.UTF-8, and returns
+// the catalog for it
+func Parse(value string) (lang string, ok bool) {
+	value = strings.ToLower(value)
+	if i := strings.IndexAny(value, "_.@-"); i != -1 {
+		value = value[:i]
+	}
+
+	if value == "c" || value == "posix" {
+		value = English
+	}
+
+	if value == English {
+		return English, true
+	}
+
+	_, ok = catalogs[value]
+	return value, ok
+}
+
+// Of returns the language of whoever is reading w, English unless w is a terminal or session output for an operator who picked another
+func Of(w io.Writer) string {
+	l, ok := w.(interface{ Language() string })
+	if !ok || l.Language() == "" {
+		return English
+	}
+	return l.Language()
+}
+
+// T translates a single message, returning it unchanged if the language has no translation for it
+func T(lang, message string) string {
+	c, ok := catalogs[lang]
+	if !ok {
+		return message
+	}
+
+	if translated, ok := c.exact[message]; ok {
+		return translated
+	}
+
+	for _, p := range c.patterns {
+		values := p.match.FindStringSubmatch(message)
+		if values == nil {
+			continue
+		}
+
+		args := make([]interface{}, 0, len(values)-1)
+		for _, v := range values[1:] {
+			args = append(args, v)
+		}
+		return fmt.Sprintf(p.translation, args...)
+	}
+
+	return message
+}
+
+// Text translates text such as help made with terminal.MakeHelpText, line by line and each tab separated field on its own so
+// the flag descriptions in "\t-t\tPrint all attributes" are found
+func Text(lang, text string) string {
+	if _, ok := catalogs[lang]; !ok {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, "\t")
+		for j, field := range fields {
+			fields[j] = T(lang, field)
+		}
+		lines[i] = strings.Join(fields, "\t")
+	}
+
+	return strings.Join(lines, "\n")
+}
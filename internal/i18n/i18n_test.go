@@ -0,0 +1,34 @@
+package i18n
+
+import "testing"
+
+func TestTranslate(t *testing.T) {
+	if got := T("zh", "Unknown command: foo"); got != "未知命令: foo" {
+		t.Errorf("expected the value to be carried into the translation, got %q", got)
+	}
+
+	if got := T("zh", "something with no translation"); got != "something with no translation" {
+		t.Errorf("untranslated messages should be left alone, got %q", got)
+	}
+
+	if got := T(English, "Language is %s"); got != "Language is %s" {
+		t.Errorf("english should not be changed, got %q", got)
+	}
+
+	help := "ls [OPTION] [FILTER]\n\t-t\tPrint all attributes in pretty table\n"
+	if got := Text("zh", help); got != "ls [OPTION] [FILTER]\n\t-t\t以表格显示所有属性\n" {
+		t.Errorf("expected flag descriptions to be translated, got %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for value, expected := range map[string]string{"zh_CN.UTF-8": "zh", "zh": "zh", "en_GB.UTF-8": English, "C": English, "POSIX": English} {
+		if lang, ok := Parse(value); !ok || lang != expected {
+			t.Errorf("expected %q to be %q, got %q %v", value, expected, lang, ok)
+		}
+	}
+
+	if _, ok := Parse("fr_FR.UTF-8"); ok {
+		t.Error("there is no french catalog")
+	}
+}
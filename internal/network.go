@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type Interface struct {
+	Name      string
+	MAC       string
+	Addresses []string
+}
+
+type Neighbour struct {
+	IP        string
+	MAC       string
+	Interface string
+	State     string
+}
+
+type Route struct {
+	Destination string
+	Gateway     string
+	Interface   string
+	Metric      uint32
+}
+
+// ssh.Marshal cant do slices of structs, so tables are sent as newline seperated records of tab seperated fields
+type networkTable struct {
+	Records string
+}
+
+func encodeRecords(records [][]string) []byte {
+	lines := make([]string, 0, len(records))
+	for _, r := range records {
+		lines = append(lines, strings.Join(r, "\t"))
+	}
+
+	return ssh.Marshal(networkTable{Records: strings.Join(lines, "\n")})
+}
+
+func decodeRecords(b []byte, fields int) ([][]string, error) {
+	var t networkTable
+	if err := ssh.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+
+	var records [][]string
+	for _, line := range strings.Split(t.Records, "\n") {
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Split(line, "\t")
+		if len(parts) != fields {
+			return nil, fmt.Errorf("malformed record %q", line)
+		}
+		records = append(records, parts)
+	}
+
+	return records, nil
+}
+
+func MarshalInterfaces(interfaces []Interface) []byte {
+	var records [][]string
+	for _, i := range interfaces {
+		records = append(records, []string{i.Name, i.MAC, strings.Join(i.Addresses, " ")})
+	}
+	return encodeRecords(records)
+}
+
+func UnmarshalInterfaces(b []byte) ([]Interface, error) {
+	records, err := decodeRecords(b, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	var interfaces []Interface
+	for _, r := range records {
+		interfaces = append(interfaces, Interface{Name: r[0], MAC: r[1], Addresses: strings.Fields(r[2])})
+	}
+	return interfaces, nil
+}
+
+func MarshalNeighbours(neighbours []Neighbour) []byte {
+	var records [][]string
+	for _, n := range neighbours {
+		records = append(records, []string{n.IP, n.MAC, n.Interface, n.State})
+	}
+	return encodeRecords(records)
+}
+
+func UnmarshalNeighbours(b []byte) ([]Neighbour, error) {
+	records, err := decodeRecords(b, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	var neighbours []Neighbour
+	for _, r := range records {
+		neighbours = append(neighbours, Neighbour{IP: r[0], MAC: r[1], Interface: r[2], State: r[3]})
+	}
+	return neighbours, nil
+}
+
+func MarshalRoutes(routes []Route) []byte {
+	var records [][]string
+	for _, r := range routes {
+		records = append(records, []string{r.Destination, r.Gateway, r.Interface, strconv.FormatUint(uint64(r.Metric), 10)})
+	}
+	return encodeRecords(records)
+}
+
+func UnmarshalRoutes(b []byte) ([]Route, error) {
+	records, err := decodeRecords(b, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []Route
+	for _, r := range records {
+		metric, err := strconv.ParseUint(r[3], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed route metric %q", r[3])
+		}
+
+		routes = append(routes, Route{Destination: r[0], Gateway: r[1], Interface: r[2], Metric: uint32(metric)})
+	}
+	return routes, nil
+}
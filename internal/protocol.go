@@ -0,0 +1,21 @@
+package internal
+
+// ProtocolVersion is what this build speaks between client and server, and is bumped when one side needs to know the other has
+// changed. Protocol 0 is a client from before capabilities were reported, and 1 is one that reports them but not a version
+const ProtocolVersion = 2
+
+// Hello is sent by the server with query-capabilities. A client that does not know about it ignores it and answers as protocol 1
+type Hello struct {
+	Protocol uint32
+
+	// Whatever later protocols add, so an older build can still read the start of it
+	Rest []byte `ssh:"rest"`
+}
+
+// Features is how a client answers a server that sent Hello
+type Features struct {
+	Capabilities []string
+	Protocol     uint32
+
+	Rest []byte `ssh:"rest"`
+}
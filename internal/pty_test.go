@@ -0,0 +1,30 @@
+package internal
+
+import "testing"
+
+func TestPtyModes(t *testing.T) {
+	// ICRNL on, ECHO off, then an opcode past the end marker that should not be read
+	p := PtyReq{Term: "xterm-256color", Modes: string([]byte{36, 0, 0, 0, 1, ModeECHO, 0, 0, 0, 0, 0, 50, 0, 0, 0, 1})}
+
+	if echo, ok := p.Mode(ModeECHO); !ok || echo != 0 {
+		t.Errorf("expected echo to be off, got %d %v", echo, ok)
+	}
+
+	if _, ok := p.Mode(50); ok {
+		t.Error("expected modes after the end marker to be ignored")
+	}
+
+	if _, ok := (&PtyReq{Modes: string([]byte{ModeECHO, 0, 0})}).Mode(ModeECHO); ok {
+		t.Error("expected a truncated mode to be ignored")
+	}
+
+	if p.Dumb() {
+		t.Error("expected xterm not to be dumb")
+	}
+
+	for _, term := range []string{"", "dumb"} {
+		if p := (PtyReq{Term: term}); !p.Dumb() || p.TermOrDefault() != "dumb" && term == "" {
+			t.Errorf("expected %q to be dumb", term)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQuota is wrapped by the errors returned when an operator has used all of their quota
+var ErrQuota = errors.New("quota exceeded")
+
+// Quota limits what one operator, everyone logging in with the same key, can use. Zero is no limit
+type Quota struct {
+	Sessions int
+	Forwards int
+	// Bytes relayed through forwards, in both directions, each day
+	TransferPerDay uint64
+}
+
+// Usage is how much of their quota an operator is using
+type Usage struct {
+	Sessions    int
+	Forwards    int
+	Transferred uint64
+	// The UTC day Transferred is for
+	Day string
+}
+
+var lUsage sync.Mutex
+var usage = map[string]*Usage{}
+
+// Operator is who per operator state such as quotas is kept against, the login key so several connections from one automation account share it
+func (u *User) Operator() string {
+	if u.PublicKey != nil {
+		return FingerprintSHA256Hex(u.PublicKey)
+	}
+	return u.ConnectionDetails
+}
+
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// current is called with lUsage held
+func (u *User) current() *Usage {
+	op := u.Operator()
+
+	current, ok := usage[op]
+	if !ok {
+		current = &Usage{}
+		usage[op] = current
+	}
+
+	if day := today(); current.Day != day {
+		current.Day = day
+		current.Transferred = 0
+	}
+
+	return current
+}
+
+// StartSession counts this login against the operator's sessions, it is ended by DeleteUser
+func (u *User) StartSession() error {
+	lUsage.Lock()
+	defer lUsage.Unlock()
+
+	current := u.current()
+	if u.Quota.Sessions > 0 && current.Sessions >= u.Quota.Sessions {
+		return fmt.Errorf("%w, %d sessions are already open with this key", ErrQuota, current.Sessions)
+	}
+
+	current.Sessions++
+	u.sessionCounted = true
+
+	return nil
+}
+
+func (u *User) endSession() {
+	lUsage.Lock()
+	defer lUsage.Unlock()
+
+	if u.sessionCounted {
+		u.current().Sessions--
+		u.sessionCounted = false
+	}
+}
+
+// StartForward counts a forward against the operator's quota, call the returned func once the forward ends
+func (u *User) StartForward() (func(), error) {
+	lUsage.Lock()
+	defer lUsage.Unlock()
+
+	current := u.current()
+	if u.Quota.Forwards > 0 && current.Forwards >= u.Quota.Forwards {
+		return nil, fmt.Errorf("%w, %d forwards are already open with this key", ErrQuota, current.Forwards)
+	}
+
+	if u.Quota.TransferPerDay > 0 && current.Transferred >= u.Quota.TransferPerDay {
+		return nil, fmt.Errorf("%w, %s has already been transferred today", ErrQuota, FormatBytes(current.Transferred))
+	}
+
+	current.Forwards++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			lUsage.Lock()
+			defer lUsage.Unlock()
+
+			u.current().Forwards--
+		})
+	}, nil
+}
+
+// Usage returns what the operator is currently using
+func (u *User) Usage() Usage {
+	lUsage.Lock()
+	defer lUsage.Unlock()
+
+	return *u.current()
+}
+
+type meteredWriter struct {
+	u *User
+	w io.Writer
+}
+
+func (m meteredWriter) Write(b []byte) (int, error) {
+	lUsage.Lock()
+	current := m.u.current()
+	if m.u.Quota.TransferPerDay > 0 && current.Transferred >= m.u.Quota.TransferPerDay {
+		lUsage.Unlock()
+		return 0, fmt.Errorf("%w, %s transferred today", ErrQuota, FormatBytes(current.Transferred))
+	}
+	lUsage.Unlock()
+
+	n, err := m.w.Write(b)
+
+	lUsage.Lock()
+	m.u.current().Transferred += uint64(n)
+	lUsage.Unlock()
+
+	return n, err
+}
+
+// Metered counts what is written to w against the operator's daily transfer, and fails writes once it is used up
+func (u *User) Metered(w io.Writer) io.Writer {
+	return meteredWriter{u: u, w: w}
+}
+
+var byteUnits = []string{"B", "KB", "MB", "GB", "TB"}
+
+// ParseBytes reads a size such as 500MB or 2G, units are powers of 1024
+func ParseBytes(s string) (uint64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	number := strings.TrimRight(s, "KMGTB")
+
+	unit := strings.TrimPrefix(s, number)
+	if !strings.HasSuffix(unit, "B") {
+		unit += "B"
+	}
+
+	multiplier := uint64(1)
+	found := false
+	for _, u := range byteUnits {
+		if u == unit {
+			found = true
+			break
+		}
+		multiplier *= 1024
+	}
+
+	value, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || !found {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes such as 500MB or 2G", s)
+	}
+
+	return value * multiplier, nil
+}
+
+// FormatBytes writes a size for people to read, i.e 1.5MB
+func FormatBytes(n uint64) string {
+	value, unit := float64(n), 0
+	for value >= 1024 && unit < len(byteUnits)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.1f%s", value, byteUnits[unit])
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	for input, expected := range map[string]uint64{
+		"512":    512,
+		"1k":     1024,
+		"10KB":   10 * 1024,
+		"500MB":  500 * 1024 * 1024,
+		"2G":     2 * 1024 * 1024 * 1024,
+		" 1TB ":  1024 * 1024 * 1024 * 1024,
+		"0":      0,
+		"100b":   100,
+		"3gb":    3 * 1024 * 1024 * 1024,
+		"16M":    16 * 1024 * 1024,
+		"1024KB": 1024 * 1024,
+	} {
+		got, err := ParseBytes(input)
+		if err != nil {
+			t.Errorf("%q: %s", input, err)
+			continue
+		}
+
+		if got != expected {
+			t.Errorf("%q: expected %d got %d", input, expected, got)
+		}
+	}
+
+	for _, invalid := range []string{"", "MB", "10PB", "1.5GB", "-1", "10BK", "ten"} {
+		if _, err := ParseBytes(invalid); err == nil {
+			t.Errorf("%q should not parse", invalid)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	first := &User{ConnectionDetails: "bot", Quota: Quota{Sessions: 1, Forwards: 1, TransferPerDay: 10}}
+	second := &User{ConnectionDetails: "bot", Quota: first.Quota}
+
+	if err := first.StartSession(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.StartSession(); !errors.Is(err, ErrQuota) {
+		t.Errorf("second session with the same key should be over quota, got %v", err)
+	}
+
+	stop, err := first.StartForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := second.StartForward(); !errors.Is(err, ErrQuota) {
+		t.Errorf("second forward should be over quota, got %v", err)
+	}
+
+	var out bytes.Buffer
+	w := first.Metered(&out)
+	if _, err := w.Write([]byte("0123456789ab")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write([]byte("more")); !errors.Is(err, ErrQuota) {
+		t.Errorf("writing past the daily transfer should fail, got %v", err)
+	}
+
+	stop()
+	stop()
+
+	if used := second.Usage(); used.Forwards != 0 || used.Sessions != 1 || used.Transferred != 12 {
+		t.Errorf("unexpected usage %+v", used)
+	}
+
+	first.endSession()
+	if err := second.StartSession(); err != nil {
+		t.Errorf("session should be free once the first ended: %s", err)
+	}
+}
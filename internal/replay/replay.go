@@ -0,0 +1,131 @@
+// Package replay signs the websocket upgrade a client makes, so the server can refuse upgrades it has already seen or that are too old.
+// Replaying a captured connection never gets past the ssh key exchange, but without this the server cannot tell a replay from a client
+// having trouble, and spends a handshake on each one
+package replay
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	timestampHeader = "X-Rssh-Timestamp"
+	nonceHeader     = "X-Rssh-Nonce"
+	keyHeader       = "X-Rssh-Key"
+	signatureHeader = "X-Rssh-Signature"
+
+	// Window is how far a client's clock may be from the server's, and so how long nonces are remembered for
+	Window = 2 * time.Minute
+)
+
+var ErrUnsigned = errors.New("websocket upgrade is not signed")
+
+func message(host, timestamp, nonce string) []byte {
+	return []byte("rssh-websocket-upgrade\x00" + host + "\x00" + timestamp + "\x00" + nonce)
+}
+
+// Sign adds a fresh nonce and timestamp to an upgrade for host, signed by the client's key
+func Sign(header http.Header, signer ssh.Signer, host string, now time.Time) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	nonceString := hex.EncodeToString(nonce)
+
+	sig, err := signer.Sign(rand.Reader, message(host, timestamp, nonceString))
+	if err != nil {
+		return err
+	}
+
+	header.Set(timestampHeader, timestamp)
+	header.Set(nonceHeader, nonceString)
+	header.Set(keyHeader, base64.StdEncoding.EncodeToString(signer.PublicKey().Marshal()))
+	header.Set(signatureHeader, base64.StdEncoding.EncodeToString(ssh.Marshal(sig)))
+
+	return nil
+}
+
+// Verifier remembers the nonces it has accepted for as long as they would still be in the window
+type Verifier struct {
+	lck  sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{seen: map[string]time.Time{}}
+}
+
+// Verify checks an upgrade was signed for this host, is recent and has not been seen before. Upgrades without any signature return ErrUnsigned
+func (v *Verifier) Verify(r *http.Request, now time.Time) error {
+	timestamp, nonce := r.Header.Get(timestampHeader), r.Header.Get(nonceHeader)
+	if timestamp == "" && nonce == "" && r.Header.Get(signatureHeader) == "" {
+		return ErrUnsigned
+	}
+
+	if len(nonce) != 32 {
+		return errors.New("invalid nonce")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+
+	signedAt := time.Unix(seconds, 0)
+	if skew := now.Sub(signedAt); skew > Window || skew < -Window {
+		return fmt.Errorf("upgrade was signed %s ago, outside the %s window", skew.Round(time.Second), Window)
+	}
+
+	keyBytes, err := base64.StdEncoding.DecodeString(r.Header.Get(keyHeader))
+	if err != nil {
+		return errors.New("invalid key encoding")
+	}
+
+	key, err := ssh.ParsePublicKey(keyBytes)
+	if err != nil {
+		return fmt.Errorf("invalid key: %s", err)
+	}
+
+	sigBytes, err := base64.StdEncoding.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(sigBytes, &sig); err != nil {
+		return errors.New("invalid signature")
+	}
+
+	if err := key.Verify(message(r.Host, timestamp, nonce), &sig); err != nil {
+		return errors.New("signature does not match")
+	}
+
+	v.lck.Lock()
+	defer v.lck.Unlock()
+
+	for n, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, n)
+		}
+	}
+
+	if _, ok := v.seen[nonce]; ok {
+		return errors.New("nonce has already been used, upgrade was replayed")
+	}
+
+	// Kept until the timestamp could no longer pass the window check
+	v.seen[nonce] = signedAt.Add(Window)
+
+	return nil
+}
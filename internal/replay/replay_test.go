@@ -0,0 +1,82 @@
+package replay
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func signedRequest(t *testing.T, host string, at time.Time) *http.Request {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := http.NewRequest("GET", "http://"+host+"/ws", nil)
+	if err := Sign(r.Header, signer, host, at); err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	v := NewVerifier()
+
+	r := signedRequest(t, "rssh.example:3232", now)
+	if err := v.Verify(r, now); err != nil {
+		t.Fatalf("fresh upgrade was refused: %s", err)
+	}
+
+	if err := v.Verify(r, now.Add(time.Second)); err == nil {
+		t.Error("replayed upgrade was accepted")
+	}
+
+	if err := v.Verify(signedRequest(t, "rssh.example:3232", now.Add(-2*Window)), now); err == nil {
+		t.Error("stale upgrade was accepted")
+	}
+
+	moved := signedRequest(t, "rssh.example:3232", now)
+	moved.Host = "other.example:3232"
+	if err := v.Verify(moved, now); err == nil {
+		t.Error("upgrade signed for another host was accepted")
+	}
+
+	tampered := signedRequest(t, "rssh.example:3232", now)
+	tampered.Header.Set(timestampHeader, tampered.Header.Get(timestampHeader)+"1")
+	if err := v.Verify(tampered, now); err == nil {
+		t.Error("upgrade with a changed timestamp was accepted")
+	}
+
+	unsigned, _ := http.NewRequest("GET", "http://rssh.example:3232/ws", nil)
+	if err := v.Verify(unsigned, now); err != ErrUnsigned {
+		t.Errorf("expected ErrUnsigned, got %v", err)
+	}
+}
+
+func TestNoncesExpire(t *testing.T) {
+	now := time.Now()
+	v := NewVerifier()
+
+	if err := v.Verify(signedRequest(t, "h:1", now), now); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Verify(signedRequest(t, "h:1", now.Add(2*Window)), now.Add(2*Window)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(v.seen) != 1 {
+		t.Errorf("expired nonces should be forgotten, %d remembered", len(v.seen))
+	}
+}
@@ -0,0 +1,217 @@
+// Package approvals holds high risk commands from non admin users until an admin signs off on them
+package approvals
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+)
+
+const DefaultExpiry = 10 * time.Minute
+
+var (
+	ErrDenied    = errors.New("request was denied")
+	ErrExpired   = errors.New("request expired before it was approved")
+	ErrCancelled = errors.New("request was cancelled")
+	ErrNotFound  = errors.New("no pending request with that id")
+)
+
+var (
+	lck      sync.Mutex
+	path     string
+	required = map[string]bool{"kill": true, "persist": true}
+	pending  = map[string]*Request{}
+)
+
+type Request struct {
+	ID      string
+	User    string
+	Command string
+	Line    string
+	Created time.Time
+	Expires time.Time
+
+	decision chan error
+}
+
+type config struct {
+	Required []string
+}
+
+// Start loads which commands need approval from approvals.json in the datadir, kill and persist are the default
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "approvals.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("unable to parse %s: %s", path, err)
+	}
+
+	required = map[string]bool{}
+	for _, command := range c.Required {
+		required[command] = true
+	}
+
+	return nil
+}
+
+func Required(command string) bool {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return required[command]
+}
+
+func RequiredCommands() []string {
+	lck.Lock()
+	defer lck.Unlock()
+
+	commands := []string{}
+	for c := range required {
+		commands = append(commands, c)
+	}
+	sort.Strings(commands)
+
+	return commands
+}
+
+func SetRequired(command string, needsApproval bool) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if needsApproval {
+		required[command] = true
+	} else {
+		delete(required, command)
+	}
+
+	var c config
+	for command := range required {
+		c.Required = append(c.Required, command)
+	}
+	sort.Strings(c.Required)
+
+	b, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+func Submit(user, command, line string, expiry time.Duration) (*Request, error) {
+	id, err := internal.RandomString(4)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Request{
+		ID:       id,
+		User:     user,
+		Command:  command,
+		Line:     line,
+		Created:  time.Now(),
+		Expires:  time.Now().Add(expiry),
+		decision: make(chan error, 1),
+	}
+
+	lck.Lock()
+	pending[id] = r
+	lck.Unlock()
+
+	audit.Log(user, "approval-requested", id, line)
+
+	return r, nil
+}
+
+// Wait blocks until the request is decided, expires, or cancel is closed. A nil error means approved
+func (r *Request) Wait(cancel <-chan struct{}) error {
+	timer := time.NewTimer(time.Until(r.Expires))
+	defer timer.Stop()
+
+	action := "expired"
+	select {
+	case err := <-r.decision:
+		return err
+	case <-timer.C:
+	case <-cancel:
+		action = "cancelled"
+	}
+
+	lck.Lock()
+	_, stillPending := pending[r.ID]
+	delete(pending, r.ID)
+	lck.Unlock()
+
+	// Lost the race with an admin deciding it, their decision stands
+	if !stillPending {
+		return <-r.decision
+	}
+
+	audit.Log(r.User, "approval-"+action, r.ID, r.Line)
+
+	if action == "cancelled" {
+		return ErrCancelled
+	}
+	return ErrExpired
+}
+
+func Decide(id, approver string, approve bool) (Request, error) {
+	lck.Lock()
+	r, ok := pending[id]
+	if ok {
+		delete(pending, id)
+	}
+	lck.Unlock()
+
+	if !ok {
+		return Request{}, ErrNotFound
+	}
+
+	if approve {
+		audit.Log(approver, "approval-granted", id, fmt.Sprintf("%s: %s", r.User, r.Line))
+		r.decision <- nil
+	} else {
+		audit.Log(approver, "approval-denied", id, fmt.Sprintf("%s: %s", r.User, r.Line))
+		r.decision <- ErrDenied
+	}
+
+	return *r, nil
+}
+
+func Pending() []Request {
+	lck.Lock()
+	defer lck.Unlock()
+
+	requests := []Request{}
+	for _, r := range pending {
+		requests = append(requests, *r)
+	}
+
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Created.Before(requests[j].Created) })
+
+	return requests
+}
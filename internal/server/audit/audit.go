@@ -0,0 +1,315 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
+	"golang.org/x/crypto/ssh"
+)
+
+// A checkpoint signed by the server key is written after this many entries, and whenever the server starts
+const CheckpointEvery = 100
+
+const checkpointAction = "audit-checkpoint"
+
+var (
+	lck    sync.Mutex
+	path   string
+	signer ssh.Signer
+
+	// The hash of the last line written, which the next entry chains on from
+	head            string
+	sinceCheckpoint int
+)
+
+type Entry struct {
+	Timestamp time.Time
+	Sequence  sequence.Stamp
+	User      string
+	Action    string
+	Target    string
+	Details   string
+
+	// Hex sha256 of the line before this one, so changing, removing or inserting a line breaks the chain from there on
+	Previous string `json:",omitempty"`
+	// Set on checkpoints, the server key's signature of Previous
+	Signature string `json:",omitempty"`
+}
+
+func lineHash(line []byte) string {
+	h := sha256.Sum256(line)
+	return hex.EncodeToString(h[:])
+}
+
+func checkpointMessage(previous string) []byte {
+	return []byte("rssh audit checkpoint " + previous)
+}
+
+// Start sets where audit entries are appended to, entries logged before this are dropped. The log carries on the chain of one
+// already there, and each checkpoint is signed with s
+func Start(logPath string, s ssh.Signer) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = logPath
+	signer = s
+	sinceCheckpoint = 0
+
+	last, err := lastLine(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("unable to read the end of the audit log, the chain starts again:", err)
+	}
+
+	head = ""
+	if len(last) > 0 {
+		head = lineHash(last)
+	}
+
+	checkpoint()
+}
+
+// lastLine finds the final line of a file without reading all of it
+func lastLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	const chunk = 64 * 1024
+	var tail []byte
+	for offset := end; offset > 0; {
+		size := int64(chunk)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+
+		buf := make([]byte, size)
+		if _, err := f.ReadAt(buf, offset); err != nil {
+			return nil, err
+		}
+		tail = append(buf, tail...)
+
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i != -1 {
+			return trimmed[i+1:], nil
+		}
+		if offset == 0 {
+			return trimmed, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func Log(user, action, target, details string) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if path == "" {
+		return
+	}
+
+	write(Entry{
+		User:    user,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	})
+
+	sinceCheckpoint++
+	if sinceCheckpoint >= CheckpointEvery {
+		checkpoint()
+	}
+}
+
+// checkpoint signs where the chain has got to, lck must be held
+func checkpoint() {
+	if path == "" || signer == nil {
+		return
+	}
+
+	sig, err := sign(checkpointMessage(head))
+	if err != nil {
+		log.Println("unable to sign audit checkpoint:", err)
+		return
+	}
+
+	write(Entry{
+		User:      "server",
+		Action:    checkpointAction,
+		Details:   fmt.Sprintf("%d entries since the last checkpoint", sinceCheckpoint),
+		Signature: sig,
+	})
+	sinceCheckpoint = 0
+}
+
+func sign(message []byte) (string, error) {
+	var (
+		sig *ssh.Signature
+		err error
+	)
+
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algorithmSigner.SignWithAlgorithm(nil, message, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(nil, message)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(ssh.Marshal(sig)), nil
+}
+
+// write appends an entry chained on from the last, lck must be held
+func write(e Entry) {
+	e.Timestamp = time.Now()
+	e.Sequence = sequence.Next()
+	e.Previous = head
+
+	entry, err := json.Marshal(e)
+	if err != nil {
+		log.Println("unable to marshal audit entry:", err)
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Println("unable to open audit log for writing:", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(entry, '\n')); err != nil {
+		log.Println("unable to write audit entry:", err)
+		return
+	}
+
+	head = lineHash(entry)
+}
+
+// Report is what Verify found in the audit log
+type Report struct {
+	Entries     int
+	Checkpoints int
+	// Lines from before entries were chained, which nothing vouches for
+	Unchained int
+	// Entries after the last checkpoint, which are chained but not yet signed for
+	Unsigned int
+	// Everything wrong with the log, by line number from 1. Empty if it is intact
+	Problems []string
+}
+
+// Verify walks the chain of the audit log, checking the checkpoints were signed by key and that the log ends with the last entry
+// this server wrote
+func Verify(key ssh.PublicKey) (Report, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	var r Report
+	if path == "" {
+		return r, fmt.Errorf("the audit log is not being written")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && head == "" {
+			return r, nil
+		}
+		return r, err
+	}
+	defer f.Close()
+
+	var (
+		previous string
+		chained  bool
+		n        int
+	)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		n++
+		line := scanner.Bytes()
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			r.Problems = append(r.Problems, fmt.Sprintf("line %d is not an audit entry", n))
+			previous = lineHash(line)
+			continue
+		}
+		r.Entries++
+
+		switch {
+		case e.Previous == "" && !chained:
+			// The first entry of a new log chains on from nothing, an older log's last unchained line is otherwise followed
+			if previous == "" && e.Action == checkpointAction {
+				chained = true
+			} else {
+				r.Unchained++
+			}
+		case e.Previous != previous:
+			r.Problems = append(r.Problems, fmt.Sprintf("line %d does not follow on from line %d, lines were changed, removed or inserted", n, n-1))
+			chained = true
+		default:
+			chained = true
+		}
+
+		if chained {
+			r.Unsigned++
+		}
+
+		if e.Action == checkpointAction {
+			r.Checkpoints++
+			r.Unsigned = 0
+
+			if err := verifySignature(key, e); err != nil {
+				r.Problems = append(r.Problems, fmt.Sprintf("line %d is a checkpoint with a bad signature: %s", n, err))
+			}
+		}
+
+		previous = lineHash(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return r, err
+	}
+
+	if previous != head {
+		r.Problems = append(r.Problems, "the log does not end with the last entry the server wrote, it has been truncated or added to")
+	}
+
+	return r, nil
+}
+
+func verifySignature(key ssh.PublicKey, e Entry) error {
+	b, err := base64.StdEncoding.DecodeString(e.Signature)
+	if err != nil {
+		return err
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(b, &sig); err != nil {
+		return err
+	}
+
+	return key.Verify(checkpointMessage(e.Previous), &sig)
+}
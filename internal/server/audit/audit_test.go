@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVerify(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	Start(logPath, signer)
+	defer func() { path = "" }()
+
+	for i := 0; i < CheckpointEvery+5; i++ {
+		Log("alice", "exec", "web01", "uptime")
+	}
+
+	r, err := Verify(signer.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Problems) != 0 || r.Checkpoints != 2 || r.Unsigned != 5 {
+		t.Fatalf("expected an intact log with two checkpoints, got %+v", r)
+	}
+
+	// Restarted, the chain carries on from the last line
+	Start(logPath, signer)
+	Log("alice", "exec", "web01", "id")
+	if r, _ := Verify(signer.PublicKey()); len(r.Problems) != 0 {
+		t.Fatalf("expected the log to stay intact across a restart, got %v", r.Problems)
+	}
+
+	original, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := bytes.Replace(original, []byte("uptime"), []byte("whoami"), 1)
+	if err := os.WriteFile(logPath, changed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := Verify(signer.PublicKey()); len(r.Problems) != 1 {
+		t.Errorf("expected the changed entry to break the chain, got %v", r.Problems)
+	}
+
+	lines := bytes.SplitAfter(original, []byte("\n"))
+	truncated := bytes.Join(lines[:len(lines)-3], nil)
+	if err := os.WriteFile(logPath, truncated, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := Verify(signer.PublicKey()); len(r.Problems) != 1 {
+		t.Errorf("expected the truncation to be found, got %v", r.Problems)
+	}
+
+	if err := os.WriteFile(logPath, original, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(other)
+	if r, _ := Verify(otherSigner.PublicKey()); len(r.Problems) != 3 {
+		t.Errorf("expected every checkpoint to fail against another key, got %v", r.Problems)
+	}
+}
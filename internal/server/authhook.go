@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+)
+
+const authHookTimeout = 10 * time.Second
+
+// AuthRequest describes a login by key, Known is whether the key was found in authorized_keys (and Role is what it was given there),
+// or in authorized_controllee_keys when asking about a client
+type AuthRequest struct {
+	User          string
+	RemoteAddr    string
+	ClientVersion string
+	KeyType       string
+	Fingerprint   string
+	PublicKey     string
+	Known         bool
+	Role          string `json:",omitempty"`
+}
+
+// AuthResponse is what the auth hook decided, an empty Role keeps the role from authorized_keys, or operator for keys that werent there
+type AuthResponse struct {
+	Allow    bool
+	Role     string
+	Reason   string
+	Metadata map[string]string
+}
+
+// Authenticator decides logins alongside the key files in the data directory. Returning an error from any of its checks refuses
+// the login just as a response that doesnt allow it does
+type Authenticator interface {
+	// CheckOperatorKey is asked about keys in authorized_keys, and about keys the server doesnt know of that may be operators
+	CheckOperatorKey(AuthRequest) (AuthResponse, error)
+	// CheckClientKey decides whether a key can connect as a client, returning Known keeps the decision of authorized_controllee_keys
+	CheckClientKey(AuthRequest) (bool, error)
+	// Banner is sent to everyone connecting before they authenticate, nothing is sent if it is empty
+	Banner(conn ssh.ConnMetadata) string
+	// KeyboardInteractive can log in operators without a key. A response that doesnt allow the login, without an error, leaves the
+	// login to enrollment tokens
+	KeyboardInteractive(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (AuthResponse, error)
+}
+
+// BaseAuthenticator leaves every decision to the key files, authenticators embed it to only decide what they need to
+type BaseAuthenticator struct{}
+
+func (BaseAuthenticator) CheckOperatorKey(request AuthRequest) (AuthResponse, error) {
+	return AuthResponse{Allow: request.Known}, nil
+}
+
+func (BaseAuthenticator) CheckClientKey(request AuthRequest) (bool, error) {
+	return request.Known, nil
+}
+
+func (BaseAuthenticator) Banner(conn ssh.ConnMetadata) string {
+	return ""
+}
+
+func (BaseAuthenticator) KeyboardInteractive(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (AuthResponse, error) {
+	return AuthResponse{}, nil
+}
+
+type authProgram struct {
+	BaseAuthenticator
+	program string
+}
+
+func (a authProgram) CheckOperatorKey(request AuthRequest) (AuthResponse, error) {
+	return runAuthHook(a.program, request)
+}
+
+// AuthProgram is the --auth-hook authenticator, running program for each operator login with the request as json on stdin and
+// reading the response as json from stdout
+func AuthProgram(program string) Authenticator {
+	return authProgram{program: program}
+}
+
+func runAuthHook(hook string, request AuthRequest) (AuthResponse, error) {
+	var response AuthResponse
+
+	input, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), authHookTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, hook)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if stderr.Len() > 0 {
+		log.Printf("Auth hook: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	if ctx.Err() != nil {
+		return response, fmt.Errorf("auth hook timed out after %s", authHookTimeout)
+	}
+
+	if err != nil {
+		return response, fmt.Errorf("auth hook failed: %s", err)
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return response, fmt.Errorf("auth hook returned invalid json: %s", err)
+	}
+
+	return response, nil
+}
+
+func authRequest(conn ssh.ConnMetadata, key ssh.PublicKey, known bool, role string) AuthRequest {
+	return AuthRequest{
+		User:          conn.User(),
+		RemoteAddr:    conn.RemoteAddr().String(),
+		ClientVersion: string(conn.ClientVersion()),
+		KeyType:       key.Type(),
+		Fingerprint:   ssh.FingerprintSHA256(key),
+		PublicKey:     strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		Known:         known,
+		Role:          role,
+	}
+}
+
+// askAuthHook returns the role and metadata to give an operator, or an error if they should be refused
+func askAuthHook(auth Authenticator, conn ssh.ConnMetadata, key ssh.PublicKey, known bool, role string) (string, string, error) {
+	response, err := auth.CheckOperatorKey(authRequest(conn, key, known, role))
+	if err != nil {
+		return "", "", err
+	}
+
+	return decided(response, role)
+}
+
+// decided checks what an authenticator allowed, returning the role and metadata for the operator's permissions
+func decided(response AuthResponse, role string) (string, string, error) {
+	if response.Role != "" && !internal.ValidRole(response.Role) {
+		return "", "", fmt.Errorf("auth hook returned unknown role %q", response.Role)
+	}
+
+	if !response.Allow {
+		reason := response.Reason
+		if reason == "" {
+			reason = "denied by auth hook"
+		}
+		return "", "", errors.New(reason)
+	}
+
+	if response.Role != "" {
+		role = response.Role
+	}
+
+	if role == "" {
+		role = internal.RoleOperator
+	}
+
+	var metadata string
+	if len(response.Metadata) > 0 {
+		b, err := json.Marshal(response.Metadata)
+		if err != nil {
+			return "", "", err
+		}
+		metadata = string(b)
+	}
+
+	return role, metadata, nil
+}
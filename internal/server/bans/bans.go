@@ -0,0 +1,143 @@
+// Package bans keeps the addresses the server refuses untrusted logins from, most of which are added by the honeypot
+package bans
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+)
+
+var (
+	lck  sync.Mutex
+	path string
+	bans = map[string]Ban{}
+)
+
+type Ban struct {
+	IP      string
+	Reason  string
+	Created time.Time
+	// Zero means the ban never expires
+	Expires time.Time `json:",omitempty"`
+}
+
+func (b Ban) active(now time.Time) bool {
+	return b.Expires.IsZero() || now.Before(b.Expires)
+}
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "bans.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &bans); err != nil {
+		return fmt.Errorf("unable to parse bans.json: %s", err)
+	}
+
+	return nil
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(bans, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Add bans an address for duration, or forever if duration is 0. Banning an already banned address replaces the old ban
+func Add(actor string, ip net.IP, reason string, duration time.Duration) error {
+	if ip == nil {
+		return fmt.Errorf("invalid address")
+	}
+
+	b := Ban{
+		IP:      ip.String(),
+		Reason:  reason,
+		Created: time.Now(),
+	}
+	if duration > 0 {
+		b.Expires = b.Created.Add(duration)
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	bans[b.IP] = b
+	audit.Log(actor, "ban", b.IP, reason)
+
+	return save()
+}
+
+func Remove(actor, ip string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if parsed := internal.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+
+	if _, ok := bans[ip]; !ok {
+		return fmt.Errorf("%s is not banned", ip)
+	}
+
+	delete(bans, ip)
+	audit.Log(actor, "unban", ip, "")
+
+	return save()
+}
+
+func Banned(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	b, ok := bans[ip.String()]
+	return ok && b.active(time.Now())
+}
+
+// List returns the bans still in force
+func List() []Ban {
+	lck.Lock()
+	defer lck.Unlock()
+
+	now := time.Now()
+
+	out := make([]Ban, 0, len(bans))
+	for _, b := range bans {
+		if b.active(now) {
+			out = append(out, b)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.Before(out[j].Created)
+	})
+
+	return out
+}
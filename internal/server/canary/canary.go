@@ -0,0 +1,306 @@
+// Package canary keeps keys, enrollment tokens and web paths that nothing legitimate ever uses. Planted somewhere an attacker would
+// look, such as a backup of the data directory or an operator's config, any use of one means the server or its configuration has leaked
+package canary
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/bans"
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// An operator key, tripped when it is offered to the server
+	KindKey = "key"
+	// An enrollment token, tripped when it is presented to enroll
+	KindToken = "token"
+	// A path on the web server, tripped when it is requested
+	KindPath = "path"
+)
+
+var (
+	lck      sync.Mutex
+	path     string
+	canaries = map[string]*Canary{}
+)
+
+type Canary struct {
+	ID   string
+	Kind string
+	// The key fingerprint, token hash or path that trips the canary
+	Match   string
+	Comment string `json:",omitempty"`
+	Creator string
+	Created time.Time
+
+	Trips    int
+	LastTrip time.Time `json:",omitempty"`
+	LastFrom string    `json:",omitempty"`
+}
+
+func hash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "canaries.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &canaries); err != nil {
+		return fmt.Errorf("unable to parse canaries.json: %s", err)
+	}
+
+	return nil
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(canaries, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Create makes a new canary. Keys and tokens are generated and returned as the secret to plant, as only their fingerprint or hash is kept.
+// Paths are given as webPath, and have no secret
+func Create(actor, kind, webPath, comment string) (string, Canary, error) {
+	c := Canary{
+		Kind:    kind,
+		Comment: comment,
+		Creator: actor,
+		Created: time.Now(),
+	}
+
+	var secret string
+
+	switch kind {
+	case KindKey:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", Canary{}, err
+		}
+
+		key, err := ssh.NewPublicKey(public)
+		if err != nil {
+			return "", Canary{}, err
+		}
+
+		secret, c.Match = string(marshalOpenSSH(private, comment)), internal.FingerprintSHA256Hex(key)
+
+	case KindToken:
+		token, err := internal.RandomString(24)
+		if err != nil {
+			return "", Canary{}, err
+		}
+
+		secret, c.Match = token, hash(token)
+
+	case KindPath:
+		webPath = strings.TrimPrefix(webPath, "/")
+		if webPath == "" {
+			return "", Canary{}, errors.New("a path canary needs a path")
+		}
+
+		c.Match = webPath
+
+	default:
+		return "", Canary{}, fmt.Errorf("unknown canary kind %q, expected key, token or path", kind)
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, existing := range canaries {
+		if existing.Kind == c.Kind && existing.Match == c.Match {
+			return "", Canary{}, fmt.Errorf("%s is already a canary", existing.ID)
+		}
+	}
+
+	c.ID = hash(c.Kind + c.Match)[:8]
+
+	canaries[c.ID] = &c
+	if err := save(); err != nil {
+		delete(canaries, c.ID)
+		return "", Canary{}, err
+	}
+
+	audit.Log(actor, "canary-create", c.ID, fmt.Sprintf("%s %q", c.Kind, c.Comment))
+
+	return secret, c, nil
+}
+
+func Delete(actor, id string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, ok := canaries[id]; !ok {
+		return fmt.Errorf("canary %q not found", id)
+	}
+
+	delete(canaries, id)
+	audit.Log(actor, "canary-delete", id, "")
+
+	return save()
+}
+
+func List() []Canary {
+	lck.Lock()
+	defer lck.Unlock()
+
+	out := make([]Canary, 0, len(canaries))
+	for _, c := range canaries {
+		out = append(out, *c)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.Before(out[j].Created)
+	})
+
+	return out
+}
+
+// Tokens is whether there are token canaries, so logins are still asked for a token when no real ones exist
+func Tokens() bool {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, c := range canaries {
+		if c.Kind == KindToken {
+			return true
+		}
+	}
+	return false
+}
+
+// TripKey reports whether key is a canary, raising the alarm if it is
+func TripKey(key ssh.PublicKey, remote string) bool {
+	return trip(KindKey, internal.FingerprintSHA256Hex(key), remote)
+}
+
+// TripToken reports whether an enrollment token is a canary, raising the alarm if it is
+func TripToken(secret, remote string) bool {
+	return trip(KindToken, hash(strings.TrimSpace(secret)), remote)
+}
+
+// TripPath reports whether a web server path is a canary, raising the alarm if it is
+func TripPath(webPath, remote string) bool {
+	return trip(KindPath, strings.TrimPrefix(webPath, "/"), remote)
+}
+
+func trip(kind, match, remote string) bool {
+	lck.Lock()
+
+	var c *Canary
+	for _, candidate := range canaries {
+		if candidate.Kind == kind && candidate.Match == match {
+			c = candidate
+			break
+		}
+	}
+
+	if c == nil {
+		lck.Unlock()
+		return false
+	}
+
+	c.Trips++
+	c.LastTrip = time.Now()
+	c.LastFrom = remote
+	tripped := *c
+
+	if err := save(); err != nil {
+		log.Printf("Unable to save canaries: %s", err)
+	}
+	lck.Unlock()
+
+	description := fmt.Sprintf("%s canary %s (%q) used from %s", tripped.Kind, tripped.ID, tripped.Comment, remote)
+
+	log.Printf("[WARNING] %s, this server or its configuration may have leaked", description)
+	audit.Log(remote, "canary-tripped", tripped.ID, description)
+	observers.Alerts.Notify(observers.Alert{
+		Kind:      "canary",
+		Message:   description,
+		Timestamp: tripped.LastTrip,
+		Sequence:  sequence.Next(),
+	})
+
+	if err := bans.Add("canary", internal.HostIP(remote), "used canary "+tripped.ID, 0); err != nil {
+		log.Printf("Unable to ban %s: %s", remote, err)
+	}
+
+	return true
+}
+
+// marshalOpenSSH writes an ed25519 key in the openssh-key-v1 format, as openssh will not load the PKCS8 keys the rest of rssh uses
+// and a canary key has to look like any other key an operator would have
+func marshalOpenSSH(private ed25519.PrivateKey, comment string) []byte {
+	public := ssh.Marshal(struct {
+		Type string
+		Key  []byte
+	}{ssh.KeyAlgoED25519, private.Public().(ed25519.PublicKey)})
+
+	// Only there to tell a wrong passphrase from a corrupt key, so any value does
+	check := make([]byte, 4)
+	rand.Read(check)
+
+	keys := append(check, check...)
+
+	keys = append(keys, ssh.Marshal(struct {
+		Type    string
+		Public  []byte
+		Private []byte
+		Comment string
+	}{ssh.KeyAlgoED25519, private.Public().(ed25519.PublicKey), private, comment})...)
+
+	for i := byte(1); len(keys)%8 != 0; i++ {
+		keys = append(keys, i)
+	}
+
+	body := ssh.Marshal(struct {
+		Cipher     string
+		KDF        string
+		KDFOptions string
+		Keys       uint32
+		Public     []byte
+		Private    []byte
+	}{"none", "none", "", 1, public, keys})
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), body...),
+	})
+}
@@ -0,0 +1,37 @@
+package canary
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMarshalOpenSSH(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := marshalOpenSSH(private, "laptop")
+
+	if block, _ := pem.Decode(encoded); block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		t.Fatalf("not an openssh private key:\n%s", encoded)
+	}
+
+	parsed, err := ssh.ParseRawPrivateKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, ok := parsed.(*ed25519.PrivateKey)
+	if !ok {
+		t.Fatalf("expected an ed25519 key, got %T", parsed)
+	}
+
+	if !key.Equal(private) {
+		t.Error("key changed when written out")
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/NHAS/reverse_ssh/internal"
 	"github.com/NHAS/reverse_ssh/pkg/trie"
 	"golang.org/x/crypto/ssh"
 )
@@ -18,6 +19,9 @@ var (
 	uniqueIdToAllAliases = map[string][]string{}
 	aliases              = map[string]map[string]bool{}
 
+	// Clients that have not been asked yet have no entry here, and are assumed to be able to do everything
+	capabilities = map[string]map[string]bool{}
+
 	Autocomplete = trie.NewTrie()
 
 	usernameRegex = regexp.MustCompile(`[^\w-]`)
@@ -35,13 +39,36 @@ func Add(conn *ssh.ServerConn) (string, string, error) {
 	lock.Lock()
 	defer lock.Unlock()
 
-	idString, err := internal.RandomString(20)
-	if err != nil {
+	if err := checkLimits(conn); err != nil {
 		return "", "", err
 	}
 
 	username := NormaliseHostname(conn.User())
 
+	base := ID(conn.Permissions.Extensions["pubkey-fp"], username)
+
+	clone, err := checkClone(conn, base)
+	// A self test's client is gone again in a moment, there is nothing worth remembering about it
+	if conn.Permissions.Extensions["selftest"] != "true" {
+		seen(base, conn.Permissions.Extensions["pubkey-fp"], username, conn.RemoteAddr().String(), clone)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	// An identity connecting while it is already connected is given an id of its own so neither connection is lost
+	idString := base
+	for n := 2; clients[idString] != nil; n++ {
+		idString = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	if clone {
+		conn.Permissions.Extensions["clone-of"] = base
+		if limits.Clones == ClonesQuarantine {
+			quarantined[idString] = true
+		}
+	}
+
 	addAlias(idString, username)
 	addAlias(idString, conn.RemoteAddr().String())
 	addAlias(idString, conn.Permissions.Extensions["pubkey-fp"])
@@ -49,6 +76,7 @@ func Add(conn *ssh.ServerConn) (string, string, error) {
 		addAlias(idString, conn.Permissions.Extensions["comment"])
 	}
 	clients[idString] = conn
+	connected[idString] = live{record: base, since: time.Now()}
 
 	Autocomplete.Add(idString)
 	for _, v := range uniqueIdToAllAliases[idString] {
@@ -82,6 +110,10 @@ func Search(filter string) (out map[string]*ssh.ServerConn, err error) {
 	defer lock.RUnlock()
 
 	for id, conn := range clients {
+		if quarantined[id] {
+			continue
+		}
+
 		if filter == "" {
 			out[id] = conn
 			continue
@@ -126,12 +158,18 @@ func Get(identifier string) (ssh.Conn, error) {
 	defer lock.RUnlock()
 
 	if m, ok := clients[identifier]; ok {
+		if quarantined[identifier] {
+			return nil, quarantineError(identifier, m)
+		}
 		return m, nil
 	}
 
 	if m, ok := aliases[identifier]; ok {
 		if len(m) == 1 {
 			for k := range m {
+				if quarantined[k] {
+					return nil, quarantineError(k, clients[k])
+				}
 				return clients[k], nil
 			}
 		}
@@ -154,6 +192,58 @@ func Get(identifier string) (ssh.Conn, error) {
 	return nil, fmt.Errorf("%s not found", identifier)
 }
 
+func SetCapabilities(uniqueId string, supported []string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := clients[uniqueId]; !ok {
+		return
+	}
+
+	capabilities[uniqueId] = make(map[string]bool)
+	for _, c := range supported {
+		capabilities[uniqueId][c] = true
+	}
+}
+
+// GetCapabilities returns the sorted list of features a client reported, ok is false if it has not been asked yet
+func GetCapabilities(uniqueId string) (out []string, ok bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	c, ok := capabilities[uniqueId]
+	if !ok {
+		return nil, false
+	}
+
+	for capability := range c {
+		out = append(out, capability)
+	}
+
+	sort.Strings(out)
+
+	return out, true
+}
+
+func HasCapability(uniqueId, capability string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	c, ok := capabilities[uniqueId]
+	if !ok {
+		return true
+	}
+
+	return c[capability]
+}
+
+func GetAliases(uniqueId string) []string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return append([]string{}, uniqueIdToAllAliases[uniqueId]...)
+}
+
 func Remove(uniqueId string) {
 	lock.Lock()
 	defer lock.Unlock()
@@ -165,6 +255,11 @@ func Remove(uniqueId string) {
 
 	Autocomplete.Remove(uniqueId)
 	delete(clients, uniqueId)
+	delete(capabilities, uniqueId)
+	delete(protocols, uniqueId)
+	delete(quarantined, uniqueId)
+	gone(uniqueId)
+	forgetCompression(uniqueId)
 
 	if currentAliases, ok := uniqueIdToAllAliases[uniqueId]; ok {
 
@@ -180,3 +275,45 @@ func Remove(uniqueId string) {
 	}
 
 }
+
+// Rekey swaps the key fingerprint a connected client is known by, so lookups by either the old or new fingerprint never see a half updated client
+func Rekey(uniqueId, fingerprint string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	conn, ok := clients[uniqueId]
+	if !ok {
+		return fmt.Errorf("%s not found", uniqueId)
+	}
+
+	old := conn.Permissions.Extensions["pubkey-fp"]
+
+	current := uniqueIdToAllAliases[uniqueId]
+	for i, alias := range current {
+		if alias != old {
+			continue
+		}
+
+		if len(aliases[alias]) <= 1 {
+			Autocomplete.Remove(alias)
+			delete(aliases, alias)
+		}
+		delete(aliases[alias], uniqueId)
+
+		uniqueIdToAllAliases[uniqueId] = append(current[:i:i], current[i+1:]...)
+		break
+	}
+
+	addAlias(uniqueId, fingerprint)
+	Autocomplete.Add(fingerprint)
+
+	base := uniqueId
+	if original := conn.Permissions.Extensions["clone-of"]; original != "" {
+		base = original
+	}
+	rekeyed(base, fingerprint, NormaliseHostname(conn.User()))
+
+	conn.Permissions.Extensions["pubkey-fp"] = fingerprint
+
+	return nil
+}
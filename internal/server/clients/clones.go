@@ -0,0 +1,119 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+)
+
+// What to do with a client whose identity is already connected from another address, as happens when a client binary or the
+// machine it runs on is copied
+const (
+	// Give the copy an id of its own and note it in the audit log
+	ClonesSuffix = "suffix"
+	// As suffix, and raise an alert
+	ClonesAlert = "alert"
+	// As alert, and nothing can use the copy until an admin releases it
+	ClonesQuarantine = "quarantine"
+	// Turn the copy away
+	ClonesRefuse = "refuse"
+)
+
+var ClonePolicies = []string{ClonesSuffix, ClonesAlert, ClonesQuarantine, ClonesRefuse}
+
+// ErrClone is wrapped by the error Add returns when a clone is refused
+var ErrClone = errors.New("client is already connected from another address")
+
+// Guarded by lock, along with the clients themselves
+var quarantined = map[string]bool{}
+
+func CheckClonePolicy(policy string) error {
+	for _, p := range ClonePolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown clone policy %q, expected one of %q", policy, ClonePolicies)
+}
+
+// checkClone decides whether conn is a copy of the identity base, which is only so while base is connected from another address. A
+// connection from an address it is already connected from is the client reconnecting before its old connection was seen to drop.
+// Called with lock held
+func checkClone(conn *ssh.ServerConn, base string) (bool, error) {
+	source := internal.HostIP(conn.RemoteAddr().String())
+
+	elsewhere := ""
+	for id, existing := range clients {
+		if id != base && !strings.HasPrefix(id, base+"-") {
+			continue
+		}
+
+		if source != nil && source.Equal(internal.HostIP(existing.RemoteAddr().String())) {
+			return false, nil
+		}
+		elsewhere = existing.RemoteAddr().String()
+	}
+
+	if elsewhere == "" {
+		return false, nil
+	}
+
+	if limits.Clones == ClonesRefuse {
+		refusals.Clones++
+		return true, fmt.Errorf("%w, %s is connected from %s", ErrClone, base, elsewhere)
+	}
+
+	return true, nil
+}
+
+func quarantineError(id string, conn *ssh.ServerConn) error {
+	return fmt.Errorf("%s is quarantined as a clone of %s, an admin has to release it with clones release first", id, conn.Permissions.Extensions["clone-of"])
+}
+
+// Quarantined is whether id is a clone waiting to be released
+func Quarantined(id string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return quarantined[id]
+}
+
+// Release lets a quarantined clone be used
+func Release(id string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !quarantined[id] {
+		return fmt.Errorf("%s is not quarantined", id)
+	}
+
+	delete(quarantined, id)
+	return nil
+}
+
+type Clone struct {
+	ID, Of, Address string
+	Quarantined     bool
+}
+
+// Clones lists the connected clients that are copies of another
+func Clones() (out []Clone) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	for id, conn := range clients {
+		if original := conn.Permissions.Extensions["clone-of"]; original != "" {
+			out = append(out, Clone{ID: id, Of: original, Address: conn.RemoteAddr().String(), Quarantined: quarantined[id]})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+
+	return out
+}
@@ -0,0 +1,97 @@
+package clients
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type stubConn struct {
+	ssh.Conn
+	user   string
+	remote net.Addr
+}
+
+func (s stubConn) User() string {
+	return s.user
+}
+
+func (s stubConn) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+func (s stubConn) ClientVersion() []byte {
+	return []byte("SSH-v2.4-linux_amd64")
+}
+
+func connFrom(address string) *ssh.ServerConn {
+	addr, _ := net.ResolveTCPAddr("tcp", address)
+	return &ssh.ServerConn{
+		Conn:        stubConn{user: "web01", remote: addr},
+		Permissions: &ssh.Permissions{Extensions: map[string]string{"pubkey-fp": "fp"}},
+	}
+}
+
+func TestClones(t *testing.T) {
+	recordsPath = ""
+	SetLimits(Limits{IPv4Prefix: 32, IPv6Prefix: 64, Clones: ClonesQuarantine})
+	defer SetLimits(Limits{IPv4Prefix: 32, IPv6Prefix: 64})
+
+	original, _, err := Add(connFrom("10.0.0.5:1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove(original)
+
+	if original != ID("fp", "web01") {
+		t.Errorf("expected the first connection to have the identity's id, got %q", original)
+	}
+
+	// The same address is the client reconnecting before its old connection timed out
+	reconnect, _, err := Add(connFrom("10.0.0.5:1001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove(reconnect)
+
+	if reconnect != original+"-2" || Quarantined(reconnect) || len(Clones()) != 0 {
+		t.Errorf("a reconnect from the same address should only be suffixed, got %q quarantined %t", reconnect, Quarantined(reconnect))
+	}
+
+	clone, _, err := Add(connFrom("10.0.9.9:1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove(clone)
+
+	if !Quarantined(clone) {
+		t.Fatalf("expected %s to be quarantined", clone)
+	}
+
+	if _, err := Get(clone); err == nil {
+		t.Error("a quarantined clone should not be usable")
+	}
+
+	if matches, _ := Search(""); matches[clone] != nil {
+		t.Error("a quarantined clone should not be found by search")
+	}
+
+	if err := Release(clone); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Get(clone); err != nil {
+		t.Errorf("a released clone should be usable: %s", err)
+	}
+
+	SetLimits(Limits{IPv4Prefix: 32, IPv6Prefix: 64, Clones: ClonesRefuse})
+	if _, _, err := Add(connFrom("10.0.7.7:1000")); !errors.Is(err, ErrClone) {
+		t.Errorf("expected the clone to be refused, got %v", err)
+	}
+
+	if GetRefusals().Clones != 1 {
+		t.Errorf("expected one refused clone, got %d", GetRefusals().Clones)
+	}
+}
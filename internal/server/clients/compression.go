@@ -0,0 +1,131 @@
+package clients
+
+import (
+	"io"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/compress"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"golang.org/x/crypto/ssh"
+)
+
+// Traffic is what went over a client's compressed channels, before and after compression
+type Traffic struct {
+	Raw, Wire uint64
+}
+
+func (t Traffic) Ratio() float64 {
+	if t.Wire == 0 {
+		return 0
+	}
+	return float64(t.Raw) / float64(t.Wire)
+}
+
+var (
+	compressionLock sync.Mutex
+	// Clients that have every channel the server opens to them compressed, rather than only those that ask for it
+	compressed = map[string]bool{}
+	traffic    = map[string]Traffic{}
+)
+
+func SetCompression(uniqueId string, on bool) {
+	compressionLock.Lock()
+	defer compressionLock.Unlock()
+
+	if on {
+		compressed[uniqueId] = true
+		return
+	}
+	delete(compressed, uniqueId)
+}
+
+func Compressed(uniqueId string) bool {
+	compressionLock.Lock()
+	defer compressionLock.Unlock()
+
+	return compressed[uniqueId]
+}
+
+func GetTraffic(uniqueId string) Traffic {
+	compressionLock.Lock()
+	defer compressionLock.Unlock()
+
+	return traffic[uniqueId]
+}
+
+func forgetCompression(uniqueId string) {
+	compressionLock.Lock()
+	defer compressionLock.Unlock()
+
+	delete(compressed, uniqueId)
+	delete(traffic, uniqueId)
+}
+
+type countedStream struct {
+	*compress.Stream
+	uniqueId string
+	once     sync.Once
+}
+
+func (c *countedStream) Close() error {
+	c.once.Do(func() {
+		raw, wire := c.Stats()
+
+		compressionLock.Lock()
+		t := traffic[c.uniqueId]
+		t.Raw += raw
+		t.Wire += wire
+		traffic[c.uniqueId] = t
+		compressionLock.Unlock()
+	})
+
+	return c.Stream.Close()
+}
+
+// Dial has the client connect to a destination for the server, over a compressed channel if asked to or the client has compression turned on
+func Dial(uniqueId string, sc ssh.Conn, destination internal.ChannelOpenDirectMsg, compressStream bool) (io.ReadWriteCloser, error) {
+	if err := lockdown.Check(lockdown.Proxies); err != nil {
+		return nil, err
+	}
+
+	compressStream = compressStream || Compressed(uniqueId)
+
+	channelType := "direct-tcpip"
+	if compressStream {
+		if err := Require(uniqueId, "compress", "compression"); err != nil {
+			return nil, err
+		}
+		channelType = compress.ChannelType
+	}
+
+	channel, requests, err := sc.OpenChannel(channelType, ssh.Marshal(destination))
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(requests)
+
+	done, err := lockdown.Begin(lockdown.Proxies, channel)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	if !compressStream {
+		return &tracked{ReadWriteCloser: channel, done: done}, nil
+	}
+
+	return &tracked{ReadWriteCloser: &countedStream{Stream: compress.NewStream(channel), uniqueId: uniqueId}, done: done}, nil
+}
+
+// tracked lets lockdown know when a connection through a client ends
+type tracked struct {
+	io.ReadWriteCloser
+	done func()
+	once sync.Once
+}
+
+func (t *tracked) Close() error {
+	t.once.Do(t.done)
+	return t.ReadWriteCloser.Close()
+}
@@ -0,0 +1,140 @@
+package clients
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Term is one condition of a find query, written as field=glob or field~text
+type Term struct {
+	Field string
+	// '=' matches the whole field against a glob, '~' looks for text anywhere in it ignoring case
+	Op    byte
+	Value string
+}
+
+var QueryFields = []string{"id", "hostname", "address", "key", "comment", "version", "os", "tag", "note"}
+
+func ParseQuery(terms []string) ([]Term, error) {
+	var out []Term
+	for _, term := range terms {
+		i := strings.IndexAny(term, "=~")
+		if i < 1 {
+			return nil, fmt.Errorf("'%s' is not a condition, expected field=glob or field~text", term)
+		}
+
+		t := Term{Field: strings.ToLower(term[:i]), Op: term[i], Value: term[i+1:]}
+
+		known := false
+		for _, f := range QueryFields {
+			known = known || f == t.Field
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown field '%s', expected one of %s", t.Field, strings.Join(QueryFields, ", "))
+		}
+
+		if _, err := filepath.Match(t.Value, ""); t.Op == '=' && err != nil {
+			return nil, fmt.Errorf("'%s' is not a well formed glob", t.Value)
+		}
+
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func (t Term) matches(attributes map[string][]string) bool {
+	for _, value := range attributes[t.Field] {
+		if t.Op == '~' && strings.Contains(strings.ToLower(value), strings.ToLower(t.Value)) {
+			return true
+		}
+
+		if match, _ := filepath.Match(t.Value, value); t.Op == '=' && match {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(terms []Term, attributes map[string][]string) bool {
+	for _, t := range terms {
+		if !t.matches(attributes) {
+			return false
+		}
+	}
+	return true
+}
+
+// OS is the operating system a client was built for, from the end of its version such as SSH-v2.4-linux_amd64
+func OS(version string) string {
+	build := version[strings.LastIndex(version, "-")+1:]
+	if i := strings.IndexByte(build, '_'); i != -1 {
+		return build[:i]
+	}
+	return ""
+}
+
+func recordAttributes(r *Record) map[string][]string {
+	a := map[string][]string{
+		"id":       {r.ID},
+		"hostname": {r.Hostname},
+		"address":  {r.LastAddress},
+		"key":      {r.Fingerprint},
+	}
+
+	a["tag"] = r.Tags
+
+	for _, n := range r.Notes {
+		a["note"] = append(a["note"], n.Text)
+	}
+
+	return a
+}
+
+// Find returns the connected clients every term matches and, when offline is set, the records of clients that are not connected which match
+func Find(terms []Term, offline bool) (map[string]*ssh.ServerConn, []Record) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	found := map[string]*ssh.ServerConn{}
+	for id, conn := range clients {
+		if quarantined[id] {
+			continue
+		}
+
+		a := map[string][]string{}
+		if r, err := recordOf(id); err == nil {
+			a = recordAttributes(r)
+		}
+
+		a["id"] = append(a["id"], id)
+		a["hostname"] = []string{NormaliseHostname(conn.User())}
+		a["address"] = []string{conn.RemoteAddr().String()}
+		a["key"] = []string{conn.Permissions.Extensions["pubkey-fp"]}
+		a["comment"] = []string{conn.Permissions.Extensions["comment"]}
+		a["version"] = []string{string(conn.ClientVersion())}
+		a["os"] = []string{OS(string(conn.ClientVersion()))}
+
+		if matchesAll(terms, a) {
+			found[id] = conn
+		}
+	}
+
+	var gone []Record
+	if offline {
+		for _, r := range records {
+			if !online(r.ID) && matchesAll(terms, recordAttributes(r)) {
+				gone = append(gone, *r)
+			}
+		}
+
+		sort.Slice(gone, func(i, j int) bool {
+			return gone[i].LastSeen.After(gone[j].LastSeen)
+		})
+	}
+
+	return found, gone
+}
@@ -0,0 +1,78 @@
+package clients
+
+import (
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	terms, err := ParseQuery([]string{"note~domain controller", "hostname=web*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(terms) != 2 || terms[0] != (Term{Field: "note", Op: '~', Value: "domain controller"}) || terms[1] != (Term{Field: "hostname", Op: '=', Value: "web*"}) {
+		t.Errorf("unexpected terms: %+v", terms)
+	}
+
+	for _, bad := range []string{"domain", "~text", "colour=red", "id=[", "=web"} {
+		if _, err := ParseQuery([]string{bad}); err == nil {
+			t.Errorf("expected '%s' to be refused", bad)
+		}
+	}
+}
+
+func TestNotesAndFind(t *testing.T) {
+	lock.Lock()
+	recordsPath = ""
+	records = map[string]*Record{}
+	seen(ID("fp", "dc01"), "fp", "dc01", "10.0.0.9:1000", false)
+	lock.Unlock()
+
+	id, _, err := Add(connFrom("10.0.0.5:1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove(id)
+
+	if err := AddNote(id, "alice", "Primary Domain Controller for CORP"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddNote(ID("fp", "dc01"), "alice", "old domain controller"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddNote(id, "alice", "  "); err == nil {
+		t.Error("an empty note should be refused")
+	}
+
+	terms, _ := ParseQuery([]string{"note~domain controller"})
+
+	connected, offline := Find(terms, false)
+	if len(connected) != 1 || connected[id] == nil || len(offline) != 0 {
+		t.Errorf("expected only the connected client, got %v and %+v", connected, offline)
+	}
+
+	_, offline = Find(terms, true)
+	if len(offline) != 1 || offline[0].Hostname != "dc01" {
+		t.Errorf("expected the offline client to match too, got %+v", offline)
+	}
+
+	terms, _ = ParseQuery([]string{"note~domain controller", "version=*linux*", "os=linux", "address=10.0.0.5:*"})
+	if connected, _ := Find(terms, false); len(connected) != 1 {
+		t.Errorf("expected every condition to match, got %v", connected)
+	}
+
+	if removed, err := DeleteNote(id, 1); err != nil || removed.Text != "Primary Domain Controller for CORP" {
+		t.Errorf("unexpected note removed %+v: %v", removed, err)
+	}
+
+	if _, err := DeleteNote(id, 1); err == nil {
+		t.Error("deleting a note that does not exist should fail")
+	}
+
+	terms, _ = ParseQuery([]string{"note~domain controller"})
+	if connected, _ := Find(terms, false); len(connected) != 0 {
+		t.Errorf("expected nothing to match once the note was removed, got %v", connected)
+	}
+}
@@ -0,0 +1,94 @@
+package clients
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Snapshot returns every client record, with the time connected clients have spent connected so far, ordered by id
+func Snapshot() []Record {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	out := make([]Record, 0, len(records))
+	for _, r := range records {
+		copied := *r
+		for _, l := range connected {
+			if l.record == r.ID {
+				copied.ConnectedFor += time.Since(l.since)
+			}
+		}
+		out = append(out, copied)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+
+	return out
+}
+
+// Import merges records from another server, or an earlier snapshot of this one. Clients already known keep their history, gaining any
+// tags, notes and earlier ids they did not have, so importing the same snapshot twice changes nothing
+func Import(imported []Record) (added, updated int, err error) {
+	for i, r := range imported {
+		if r.Hostname == "" || r.Fingerprint == "" {
+			return 0, 0, fmt.Errorf("record %d has no hostname or fingerprint", i+1)
+		}
+
+		if r.ID != ID(r.Fingerprint, r.Hostname) {
+			return 0, 0, fmt.Errorf("record %d has id %s, which does not match its fingerprint and hostname", i+1, r.ID)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, r := range imported {
+		existing, ok := records[r.ID]
+		if !ok {
+			copied := r
+			records[r.ID] = &copied
+			added++
+			continue
+		}
+
+		if r.FirstSeen.Before(existing.FirstSeen) {
+			existing.FirstSeen = r.FirstSeen
+		}
+
+		if r.LastSeen.After(existing.LastSeen) {
+			existing.LastSeen = r.LastSeen
+			existing.LastAddress = r.LastAddress
+		}
+
+		existing.Previous = union(existing.Previous, r.Previous)
+		existing.Tags = union(existing.Tags, r.Tags)
+
+		for _, note := range r.Notes {
+			if !hasNote(existing.Notes, note) {
+				existing.Notes = append(existing.Notes, note)
+			}
+		}
+
+		sort.SliceStable(existing.Notes, func(i, j int) bool {
+			return existing.Notes[i].At.Before(existing.Notes[j].At)
+		})
+
+		updated++
+	}
+
+	saveRecords()
+
+	return added, updated, nil
+}
+
+func hasNote(notes []Note, n Note) bool {
+	for _, existing := range notes {
+		if existing.Text == n.Text && existing.By == n.By && existing.At.Equal(n.At) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package clients
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestImport(t *testing.T) {
+	lock.Lock()
+	recordsPath = ""
+	records = map[string]*Record{}
+	seen(ID("fp", "web01"), "fp", "web01", "10.0.0.5:1000", false)
+	lock.Unlock()
+
+	local := ID("fp", "web01")
+	if err := AddTags(local, []string{"dmz", "web"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddTags(local, []string{"not a tag"}); err == nil {
+		t.Error("a tag with spaces should be refused")
+	}
+
+	at := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	snapshot := []Record{
+		{
+			ID: local, Hostname: "web01", Fingerprint: "fp",
+			FirstSeen: at, LastSeen: at,
+			Tags:  []string{"prod", "web"},
+			Notes: []Note{{Text: "payroll frontend", By: "alice", At: at}},
+		},
+		{ID: ID("fp", "db01"), Hostname: "db01", Fingerprint: "fp", FirstSeen: at, LastSeen: at},
+	}
+
+	for n := 0; n < 2; n++ {
+		added, updated, err := Import(snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if added+updated != 2 {
+			t.Errorf("expected both records to be imported, got %d added %d updated", added, updated)
+		}
+	}
+
+	r, _ := GetRecord(local)
+	if !reflect.DeepEqual(r.Tags, []string{"dmz", "prod", "web"}) || len(r.Notes) != 1 || !r.FirstSeen.Equal(at) || r.LastAddress != "10.0.0.5:1000" {
+		t.Errorf("unexpected merged record: %+v", r)
+	}
+
+	if _, ok := GetRecord(ID("fp", "db01")); !ok {
+		t.Error("expected the new client to be added")
+	}
+
+	if err := RemoveTag(local, "dmz"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Import([]Record{{ID: "forged", Hostname: "web01", Fingerprint: "fp"}}); err == nil {
+		t.Error("a record whose id does not match its identity should be refused")
+	}
+
+	if got := len(Snapshot()); got != 2 {
+		t.Errorf("expected 2 records in the snapshot, got %d", got)
+	}
+}
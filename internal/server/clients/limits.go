@@ -0,0 +1,101 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrLimit is wrapped by the error Add returns when a client is refused because of the limits
+var ErrLimit = errors.New("client limit reached")
+
+// Limits caps how many clients can be connected at once, zero meaning no limit
+type Limits struct {
+	Total int
+	// Clients from the one source, where addresses in the same subnet of these prefix lengths are one source
+	PerSource              int
+	IPv4Prefix, IPv6Prefix int
+
+	// What to do with clones, one of ClonePolicies
+	Clones string
+}
+
+// Refusals counts the clients turned away by each limit
+type Refusals struct {
+	Total, PerSource, Clones uint64
+}
+
+// Guarded by lock, along with the clients themselves
+var (
+	limits   = Limits{IPv4Prefix: 32, IPv6Prefix: 64, Clones: ClonesAlert}
+	refusals Refusals
+)
+
+func SetLimits(l Limits) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	limits = l
+	if limits.Clones == "" {
+		limits.Clones = ClonesAlert
+	}
+}
+
+func GetLimits() Limits {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return limits
+}
+
+func GetRefusals() Refusals {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return refusals
+}
+
+func sameSource(a, b net.IP, l Limits) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	mask := net.CIDRMask(l.IPv6Prefix, 128)
+	if a4, b4 := a.To4(), b.To4(); a4 != nil || b4 != nil {
+		if a4 == nil || b4 == nil {
+			return false
+		}
+		a, b, mask = a4, b4, net.CIDRMask(l.IPv4Prefix, 32)
+	}
+
+	return a.Mask(mask).Equal(b.Mask(mask))
+}
+
+// checkLimits is called with lock held
+func checkLimits(conn *ssh.ServerConn) error {
+	if limits.Total > 0 && len(clients) >= limits.Total {
+		refusals.Total++
+		return fmt.Errorf("%w, the server allows %d clients at once", ErrLimit, limits.Total)
+	}
+
+	if limits.PerSource > 0 {
+		source := internal.HostIP(conn.RemoteAddr().String())
+
+		count := 0
+		for _, c := range clients {
+			if sameSource(source, internal.HostIP(c.RemoteAddr().String()), limits) {
+				count++
+			}
+		}
+
+		if count >= limits.PerSource {
+			refusals.PerSource++
+			return fmt.Errorf("%w, the server allows %d clients from each source and %s already has that many", ErrLimit, limits.PerSource, source)
+		}
+	}
+
+	return nil
+}
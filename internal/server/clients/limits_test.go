@@ -0,0 +1,30 @@
+package clients
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSameSource(t *testing.T) {
+	l := Limits{IPv4Prefix: 24, IPv6Prefix: 64}
+
+	for _, c := range []struct {
+		a, b string
+		same bool
+	}{
+		{"10.0.0.1", "10.0.0.200", true},
+		{"10.0.0.1", "10.0.1.1", false},
+		{"10.0.0.1", "::ffff:10.0.0.2", true},
+		{"2001:db8::1", "2001:db8::ffff:1", true},
+		{"2001:db8::1", "2001:db8:0:1::1", false},
+		{"10.0.0.1", "2001:db8::1", false},
+	} {
+		if got := sameSource(net.ParseIP(c.a), net.ParseIP(c.b), l); got != c.same {
+			t.Errorf("%s and %s: expected same source %t, got %t", c.a, c.b, c.same, got)
+		}
+	}
+
+	if !sameSource(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.1"), Limits{IPv4Prefix: 32, IPv6Prefix: 128}) {
+		t.Error("an address should be the same source as itself")
+	}
+}
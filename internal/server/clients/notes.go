@@ -0,0 +1,70 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Note is free text operators keep against a client, such as what the machine is or who owns it
+type Note struct {
+	Text string
+	By   string
+	At   time.Time
+}
+
+// recordOf returns the record for a connected client's id or a record id. Called with lock held
+func recordOf(id string) (*Record, error) {
+	if l, ok := connected[id]; ok {
+		id = l.record
+	}
+
+	r, ok := records[id]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", id)
+	}
+	return r, nil
+}
+
+// AddNote keeps a note against a client, connected or not
+func AddNote(id, by, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return errors.New("a note needs some text")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	r, err := recordOf(id)
+	if err != nil {
+		return err
+	}
+
+	r.Notes = append(r.Notes, Note{Text: text, By: by, At: time.Now()})
+	saveRecords()
+
+	return nil
+}
+
+// DeleteNote removes a client's note by its position, counting from 1
+func DeleteNote(id string, n int) (Note, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	r, err := recordOf(id)
+	if err != nil {
+		return Note{}, err
+	}
+
+	if n < 1 || n > len(r.Notes) {
+		return Note{}, fmt.Errorf("%s has %d notes, there is no note %d", r.ID, len(r.Notes), n)
+	}
+
+	removed := r.Notes[n-1]
+	r.Notes = append(r.Notes[:n-1:n-1], r.Notes[n:]...)
+	saveRecords()
+
+	return removed, nil
+}
@@ -0,0 +1,79 @@
+package clients
+
+import (
+	"fmt"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+)
+
+// What every client could do before clients reported their capabilities, so protocol 0 clients are assumed to have these and
+// nothing added since
+var legacyCapabilities = []string{"shell", "exec", "forward", "transfer"}
+
+var protocols = map[string]uint32{}
+
+// Negotiate asks a newly connected client which protocol it speaks and what it was built with, and records the answer. A client
+// too old to say is recorded as protocol 0 with the features every client had then
+func Negotiate(uniqueId string, conn ssh.Conn) error {
+	protocol, supported, err := query(conn)
+
+	SetCapabilities(uniqueId, supported)
+
+	lock.Lock()
+	if _, ok := clients[uniqueId]; ok {
+		protocols[uniqueId] = protocol
+	}
+	lock.Unlock()
+
+	return err
+}
+
+func query(conn ssh.Conn) (uint32, []string, error) {
+	ok, reply, err := conn.SendRequest("query-capabilities", true, ssh.Marshal(internal.Hello{Protocol: internal.ProtocolVersion}))
+	if err != nil {
+		return 0, legacyCapabilities, err
+	}
+
+	if !ok {
+		return 0, legacyCapabilities, nil
+	}
+
+	var features internal.Features
+	if err := ssh.Unmarshal(reply, &features); err == nil {
+		return features.Protocol, features.Capabilities, nil
+	}
+
+	// Protocol 1 clients dont know about Hello and only send the list
+	var legacy struct {
+		Capabilities []string
+	}
+	if err := ssh.Unmarshal(reply, &legacy); err != nil {
+		return 0, legacyCapabilities, fmt.Errorf("undecodable capabilities list: %s", err)
+	}
+
+	return 1, legacy.Capabilities, nil
+}
+
+// GetProtocol returns the protocol a client speaks, ok is false if it has not been asked yet
+func GetProtocol(uniqueId string) (protocol uint32, ok bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	protocol, ok = protocols[uniqueId]
+	return
+}
+
+// Require says why a client cannot be asked to do something it lacks the capability for, as sending it anyway would only get a
+// refusal the operator cannot make sense of. It is nil when the client can
+func Require(uniqueId, capability, what string) error {
+	if HasCapability(uniqueId, capability) {
+		return nil
+	}
+
+	if protocol, ok := GetProtocol(uniqueId); ok && protocol < internal.ProtocolVersion {
+		return fmt.Errorf("%s does not support %s, it speaks protocol %d and this server speaks %d. Rebuild the client with link", uniqueId, what, protocol, internal.ProtocolVersion)
+	}
+
+	return fmt.Errorf("%s does not support %s, it was built without it", uniqueId, what)
+}
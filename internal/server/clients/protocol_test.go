@@ -0,0 +1,58 @@
+package clients
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+)
+
+// answers query-capabilities the way a client of some protocol would
+type fakeClient struct {
+	ssh.Conn
+	reply func(payload []byte) (bool, []byte)
+}
+
+func (f *fakeClient) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	ok, reply := f.reply(payload)
+	return ok, reply, nil
+}
+
+func TestQuery(t *testing.T) {
+	caps := []string{"exec", "scan", "shell"}
+
+	tests := []struct {
+		name     string
+		reply    func([]byte) (bool, []byte)
+		protocol uint32
+		caps     []string
+	}{
+		{"predates capabilities", func([]byte) (bool, []byte) { return false, nil }, 0, legacyCapabilities},
+		{"only sends the list", func([]byte) (bool, []byte) {
+			return true, ssh.Marshal(struct{ Capabilities []string }{caps})
+		}, 1, caps},
+		{"answers hello", func(payload []byte) (bool, []byte) {
+			var hello internal.Hello
+			if err := ssh.Unmarshal(payload, &hello); err != nil || hello.Protocol != internal.ProtocolVersion {
+				return false, nil
+			}
+			return true, ssh.Marshal(internal.Features{Capabilities: caps, Protocol: internal.ProtocolVersion})
+		}, internal.ProtocolVersion, caps},
+		{"newer than us", func([]byte) (bool, []byte) {
+			return true, ssh.Marshal(internal.Features{Capabilities: caps, Protocol: internal.ProtocolVersion + 1, Rest: []byte("later")})
+		}, internal.ProtocolVersion + 1, caps},
+	}
+
+	for _, test := range tests {
+		protocol, got, err := query(&fakeClient{reply: test.reply})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if protocol != test.protocol || !reflect.DeepEqual(got, test.caps) {
+			t.Errorf("%s: expected protocol %d with %v, got %d with %v", test.name, test.protocol, test.caps, protocol, got)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package clients
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Record is kept for every identity, key fingerprint and hostname, that has connected so a machine is known across reconnects
+// and server restarts
+type Record struct {
+	ID          string
+	Hostname    string
+	Fingerprint string
+
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	LastAddress string
+
+	// Ids this machine had before, under another key with the same hostname (reimaged, or the client reinstalled) or before its key was rotated
+	Previous []string `json:",omitempty"`
+
+	// Times this identity connected while it was already connected
+	Clones int `json:",omitempty"`
+
+	Connections int
+	// Time spent connected, not counting any connection still open
+	ConnectedFor time.Duration
+
+	// Shells, jumps and commands run on the client
+	Sessions int
+	// The most recent sessions, oldest first
+	Recent []Session `json:",omitempty"`
+
+	Tags  []string `json:",omitempty"`
+	Notes []Note   `json:",omitempty"`
+}
+
+type Session struct {
+	Kind string
+	By   string
+	At   time.Time
+}
+
+const recentSessions = 20
+
+type live struct {
+	record string
+	since  time.Time
+}
+
+// Guarded by lock, along with the clients themselves
+var (
+	recordsPath string
+	records     = map[string]*Record{}
+	// Which record each connected client is, and since when
+	connected = map[string]live{}
+)
+
+// ID is the id a client is known by, the same every time the same key connects from the same host
+func ID(fingerprint, hostname string) string {
+	h := sha256.Sum256([]byte(fingerprint + "\x00" + hostname))
+	return hex.EncodeToString(h[:10])
+}
+
+func Start(datadir string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	recordsPath = filepath.Join(datadir, "clients.json")
+
+	b, err := os.ReadFile(recordsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &records); err != nil {
+		return fmt.Errorf("unable to parse clients.json: %s", err)
+	}
+
+	return nil
+}
+
+// saveRecords is called with lock held
+func saveRecords() {
+	if recordsPath == "" {
+		return
+	}
+
+	b, err := json.MarshalIndent(records, "", "    ")
+	if err != nil {
+		log.Printf("Unable to save client records: %s", err)
+		return
+	}
+
+	if err := os.WriteFile(recordsPath, b, 0600); err != nil {
+		log.Printf("Unable to save client records: %s", err)
+	}
+}
+
+// seen records a connection, linking a new identity to the ids its hostname has had before. Called with lock held
+func seen(id, fingerprint, hostname, address string, clone bool) {
+	now := time.Now()
+
+	r, ok := records[id]
+	if !ok {
+		r = &Record{
+			ID:          id,
+			Hostname:    hostname,
+			Fingerprint: fingerprint,
+			FirstSeen:   now,
+		}
+
+		// Hostnames are not unique, so an identity that is still connected is another machine rather than what this one used to be
+		for _, other := range records {
+			if other.Hostname == hostname && other.Fingerprint != fingerprint && clients[other.ID] == nil {
+				r.Previous = append(r.Previous, other.ID)
+			}
+		}
+		sort.Strings(r.Previous)
+
+		records[id] = r
+	}
+
+	r.LastSeen = now
+	r.LastAddress = address
+	r.Connections++
+	if clone {
+		r.Clones++
+	}
+
+	saveRecords()
+}
+
+// gone adds the time a client was connected to its record. Called with lock held
+func gone(id string) {
+	l, ok := connected[id]
+	if !ok {
+		return
+	}
+	delete(connected, id)
+
+	if r, ok := records[l.record]; ok {
+		r.LastSeen = time.Now()
+		r.ConnectedFor += r.LastSeen.Sub(l.since)
+		saveRecords()
+	}
+}
+
+// RecordSession notes that an operator ran a shell, jump or command on a connected client
+func RecordSession(id, kind, by string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	l, ok := connected[id]
+	if !ok {
+		return
+	}
+
+	r, ok := records[l.record]
+	if !ok {
+		return
+	}
+
+	r.Sessions++
+	r.Recent = append(r.Recent, Session{Kind: kind, By: by, At: time.Now()})
+	if len(r.Recent) > recentSessions {
+		r.Recent = append([]Session{}, r.Recent[len(r.Recent)-recentSessions:]...)
+	}
+
+	saveRecords()
+}
+
+// rekeyed links the identity a client will have after its key is rotated to the one it has now. Called with lock held
+func rekeyed(oldID, fingerprint, hostname string) {
+	id := ID(fingerprint, hostname)
+
+	r, ok := records[id]
+	if !ok {
+		now := time.Now()
+		r = &Record{
+			ID:          id,
+			Hostname:    hostname,
+			Fingerprint: fingerprint,
+			FirstSeen:   now,
+			LastSeen:    now,
+		}
+		records[id] = r
+	}
+
+	if old, ok := records[oldID]; ok {
+		r.LastAddress = old.LastAddress
+		r.Previous = append(r.Previous, old.Previous...)
+	}
+	r.Previous = append(r.Previous, oldID)
+
+	saveRecords()
+}
+
+// GetRecord returns what is known about an identity, by the id it derives
+func GetRecord(id string) (Record, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	r, ok := records[id]
+	if !ok {
+		return Record{}, false
+	}
+	return *r, true
+}
+
+func (r *Record) matches(filter string) bool {
+	for _, attribute := range []string{r.ID, r.Hostname, r.Fingerprint, r.LastAddress} {
+		if match, _ := filepath.Match(filter, attribute); match {
+			return true
+		}
+	}
+	return false
+}
+
+func online(record string) bool {
+	for _, l := range connected {
+		if l.record == record {
+			return true
+		}
+	}
+	return false
+}
+
+// Offline returns the records matching filter that have no connected client, most recently seen first
+func Offline(filter string) (out []Record, err error) {
+	filter = filter + "*"
+	if _, err := filepath.Match(filter, ""); err != nil {
+		return nil, fmt.Errorf("filter is not well formed")
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	for _, r := range records {
+		if !online(r.ID) && r.matches(filter) {
+			out = append(out, *r)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].LastSeen.After(out[j].LastSeen)
+	})
+
+	return out, nil
+}
+
+// FindRecord looks up a client's record by the id of a connected client, a record id, or a hostname, fingerprint or address only one record has.
+// Since is when the client connected, and zero if it is offline
+func FindRecord(identifier string) (r Record, since time.Time, err error) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	if l, ok := connected[identifier]; ok {
+		if r, ok := records[l.record]; ok {
+			return *r, l.since, nil
+		}
+	}
+
+	found, ok := records[identifier]
+	if !ok {
+		var matching []string
+		for _, candidate := range records {
+			if candidate.matches(identifier) {
+				found = candidate
+				matching = append(matching, fmt.Sprintf("%s (%s %s)", candidate.ID, candidate.Hostname, candidate.LastAddress))
+			}
+		}
+
+		if len(matching) == 0 {
+			return Record{}, time.Time{}, fmt.Errorf("no client has been seen matching '%s'", identifier)
+		}
+
+		if len(matching) > 1 {
+			sort.Strings(matching)
+			return Record{}, time.Time{}, fmt.Errorf("%d clients match '%s'\n%s", len(matching), identifier, strings.Join(matching, "\n"))
+		}
+	}
+
+	for _, l := range connected {
+		if l.record == found.ID && (since.IsZero() || l.since.Before(since)) {
+			since = l.since
+		}
+	}
+
+	return *found, since, nil
+}
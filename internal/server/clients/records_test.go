@@ -0,0 +1,109 @@
+package clients
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestID(t *testing.T) {
+	a := ID("fp1", "host")
+	if a != ID("fp1", "host") {
+		t.Fatal("the same identity should always have the same id")
+	}
+
+	if len(a) != 20 {
+		t.Errorf("expected a 20 character id, got %q", a)
+	}
+
+	for _, other := range []string{ID("fp2", "host"), ID("fp1", "host2"), ID("fp1h", "ost")} {
+		if other == a {
+			t.Errorf("different identities should have different ids, got %q for both", a)
+		}
+	}
+}
+
+func TestRecords(t *testing.T) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	recordsPath = ""
+	records = map[string]*Record{}
+
+	first := ID("fp1", "web01")
+	seen(first, "fp1", "web01", "10.0.0.5:1234", false)
+	seen(first, "fp1", "web01", "10.0.0.5:1235", true)
+
+	if r := records[first]; r.Clones != 1 || r.LastAddress != "10.0.0.5:1235" || len(r.Previous) != 0 {
+		t.Errorf("unexpected record after reconnecting: %+v", r)
+	}
+
+	// Sharing a key with other hosts is normal for clients built together, so is not a previous identity
+	seen(ID("fp1", "web02"), "fp1", "web02", "10.0.0.6:1234", false)
+	if r := records[ID("fp1", "web02")]; len(r.Previous) != 0 {
+		t.Errorf("a different host with the same key should not be linked: %+v", r)
+	}
+
+	reimaged := ID("fp2", "web01")
+	seen(reimaged, "fp2", "web01", "10.0.0.5:1234", false)
+	if r := records[reimaged]; !reflect.DeepEqual(r.Previous, []string{first}) {
+		t.Errorf("expected the reimaged machine to be linked to %s: %+v", first, r)
+	}
+
+	rotatedTo := ID("fp3", "web01")
+	rekeyed(reimaged, "fp3", "web01")
+	if r := records[rotatedTo]; !reflect.DeepEqual(r.Previous, []string{first, reimaged}) {
+		t.Errorf("expected the rotated key to carry the history: %+v", r)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	lock.Lock()
+	recordsPath = ""
+	records = map[string]*Record{}
+	lock.Unlock()
+
+	id, _, err := Add(connFrom("10.0.0.5:1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < recentSessions+5; i++ {
+		RecordSession(id, "exec", "alice")
+	}
+
+	if offline, _ := Offline(""); len(offline) != 0 {
+		t.Errorf("a connected client should not be offline: %+v", offline)
+	}
+
+	r, since, err := FindRecord("web01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if since.IsZero() || r.ID != id || r.Sessions != recentSessions+5 || len(r.Recent) != recentSessions {
+		t.Errorf("unexpected record while connected: %+v since %s", r, since)
+	}
+
+	Remove(id)
+
+	offline, err := Offline("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(offline) != 1 || offline[0].ID != id || offline[0].Connections != 1 || offline[0].ConnectedFor <= 0 {
+		t.Errorf("expected the client to be kept once it disconnected: %+v", offline)
+	}
+
+	if _, since, err := FindRecord(id); err != nil || !since.IsZero() {
+		t.Errorf("expected to find the offline client by id, got since %s err %v", since, err)
+	}
+
+	lock.Lock()
+	seen(ID("fp2", "web01"), "fp2", "web01", "10.0.0.6:1000", false)
+	lock.Unlock()
+
+	if _, _, err := FindRecord("web01"); err == nil {
+		t.Error("a hostname two clients have had should be ambiguous")
+	}
+}
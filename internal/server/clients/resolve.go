@@ -0,0 +1,106 @@
+package clients
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/pkg/command"
+	"golang.org/x/crypto/ssh"
+)
+
+// Resolve turns a target an operator gave into the connected clients it names. A target is taken, in order, as
+//   - an id, which names only that client even when others have ids starting with it
+//   - an alias, the hostname, address, key or comment of every client that has it
+//   - conditions in the form find takes, such as tag=web, all of which must match when there are several separated by commas
+//   - a glob or prefix of ids and aliases, as Search matches
+func Resolve(target string) (map[string]*ssh.ServerConn, error) {
+	if target == "" {
+		return nil, command.Errorf(command.Usage, "No target given")
+	}
+
+	found, err := exactly(target)
+	if err != nil || len(found) > 0 {
+		return found, err
+	}
+
+	if isQuery(target) {
+		terms, err := ParseQuery(strings.Split(target, ","))
+		if err != nil {
+			return nil, command.Errorf(command.Usage, "%s", err)
+		}
+
+		found, _ = Find(terms, false)
+	} else {
+		found, err = Search(target)
+		if err != nil {
+			return nil, command.Errorf(command.Usage, "%s", err)
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, command.Errorf(command.NotFound, "No clients matched '%s'", target)
+	}
+
+	return found, nil
+}
+
+// ResolveOne is Resolve for the commands that can only act on a single client
+func ResolveOne(target string) (string, *ssh.ServerConn, error) {
+	found, err := Resolve(target)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(found) > 1 {
+		return "", nil, Ambiguous(target)
+	}
+
+	for id, conn := range found {
+		return id, conn, nil
+	}
+
+	return "", nil, nil
+}
+
+// Ambiguous is the error for a target that names more than one client where only one can be used
+func Ambiguous(target string) error {
+	return fmt.Errorf("'%s' matches multiple clients please choose a more specific identifier", target)
+}
+
+func exactly(target string) (map[string]*ssh.ServerConn, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	found := map[string]*ssh.ServerConn{}
+	if conn, ok := clients[target]; ok {
+		if quarantined[target] {
+			return nil, quarantineError(target, conn)
+		}
+
+		found[target] = conn
+		return found, nil
+	}
+
+	for id := range aliases[target] {
+		if conn, ok := clients[id]; ok && !quarantined[id] {
+			found[id] = conn
+		}
+	}
+
+	return found, nil
+}
+
+// isQuery is whether target starts with a field of find followed by = or ~
+func isQuery(target string) bool {
+	i := strings.IndexAny(target, "=~")
+	if i < 1 {
+		return false
+	}
+
+	for _, f := range QueryFields {
+		if strings.ToLower(target[:i]) == f {
+			return true
+		}
+	}
+	return false
+}
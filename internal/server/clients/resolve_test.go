@@ -0,0 +1,83 @@
+package clients
+
+import (
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/pkg/command"
+)
+
+func TestResolve(t *testing.T) {
+	lock.Lock()
+	recordsPath = ""
+	records = map[string]*Record{}
+	lock.Unlock()
+
+	first, _, err := Add(connFrom("10.0.0.5:1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove(first)
+
+	second, _, err := Add(connFrom("10.0.0.5:1001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove(second)
+
+	if err := AddTags(first, []string{"prod"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		target   string
+		expected []string
+	}{
+		// The second connection's id starts with the first's, naming the first in full must not take both
+		{first, []string{first}},
+		{second, []string{second}},
+		{"web01", []string{first, second}},
+		{"10.0.0.5:1001", []string{second}},
+		{first[:4], []string{first, second}},
+		// Tags are kept against the identity, which both connections share
+		{"tag=prod", []string{first, second}},
+		{"address=10.0.0.5:1001,tag=prod", []string{second}},
+	} {
+		found, err := Resolve(c.target)
+		if err != nil {
+			t.Errorf("resolving %q: %s", c.target, err)
+			continue
+		}
+
+		if len(found) != len(c.expected) {
+			t.Errorf("expected %q to resolve to %v, got %v", c.target, c.expected, found)
+			continue
+		}
+
+		for _, id := range c.expected {
+			if found[id] == nil {
+				t.Errorf("expected %q to resolve to %v, got %v", c.target, c.expected, found)
+			}
+		}
+	}
+
+	for target, category := range map[string]command.Category{
+		"":           command.Usage,
+		"db01":       command.NotFound,
+		"tag=dev":    command.NotFound,
+		"colour=red": command.NotFound,
+		"web[":       command.Usage,
+		"hostname=[": command.Usage,
+	} {
+		if _, err := Resolve(target); command.CategoryOf(err) != category {
+			t.Errorf("expected resolving %q to fail as %s, got %v", target, category, err)
+		}
+	}
+
+	if _, _, err := ResolveOne("web01"); err == nil {
+		t.Error("expected a target naming two clients to be refused where only one can be used")
+	}
+
+	if id, _, err := ResolveOne(first); err != nil || id != first {
+		t.Errorf("expected %s, got %q: %v", first, id, err)
+	}
+}
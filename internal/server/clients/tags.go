@@ -0,0 +1,67 @@
+package clients
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+var tagRegex = regexp.MustCompile(`^[\w.:-]+$`)
+
+// AddTags labels a client, connected or not, so groups of clients can be found together
+func AddTags(id string, tags []string) error {
+	for _, tag := range tags {
+		if !tagRegex.MatchString(tag) {
+			return fmt.Errorf("'%s' is not a valid tag, tags are letters, numbers and . : - _", tag)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	r, err := recordOf(id)
+	if err != nil {
+		return err
+	}
+
+	r.Tags = union(r.Tags, tags)
+	saveRecords()
+
+	return nil
+}
+
+func RemoveTag(id, tag string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	r, err := recordOf(id)
+	if err != nil {
+		return err
+	}
+
+	for i, existing := range r.Tags {
+		if existing == tag {
+			r.Tags = append(r.Tags[:i:i], r.Tags[i+1:]...)
+			saveRecords()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is not tagged '%s'", r.ID, tag)
+}
+
+// union returns the sorted set of both lists
+func union(a, b []string) []string {
+	set := map[string]bool{}
+	for _, v := range append(append([]string{}, a...), b...) {
+		set[v] = true
+	}
+
+	out := make([]string, 0, len(set))
+	for v := range set {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+
+	return out
+}
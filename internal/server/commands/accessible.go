@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal/i18n"
+	"github.com/NHAS/reverse_ssh/internal/server/preferences"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type accessible struct {
+}
+
+func (a *accessible) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", a.Help(false))
+		return nil
+	}
+
+	on, off := line.IsSet("on"), line.IsSet("off")
+	if on && off {
+		return errors.New("Cannot specify on and off at the same time")
+	}
+
+	if !on && !off {
+		state := "off"
+		if console.User.Accessible {
+			state = "on"
+		}
+		fmt.Fprintln(tty, i18n.T(i18n.Of(tty), "Accessible output is "+state))
+		return nil
+	}
+
+	err := preferences.Update(console.User.Operator(), func(p *preferences.Preferences) {
+		p.Accessible = on
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to save preference: %s", err)
+	}
+
+	console.User.Accessible = on
+
+	message := "Accessible output is off"
+	if on {
+		message = "Accessible output is on, tables are written as labelled lines and the window title is left alone"
+	}
+	fmt.Fprintln(tty, i18n.T(i18n.Of(tty), message))
+
+	return nil
+}
+
+func (a *accessible) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (a *accessible) Help(explain bool) string {
+	if explain {
+		return "Plain output for screen readers"
+	}
+
+	return terminal.MakeHelpText(
+		"accessible [OPTIONS]",
+		"Accessible output writes tables and client lists as labelled lines, \"Column: value\", without box drawing characters, and stops setting the window title.",
+		"The setting is kept against your key, so it applies to every session you open. With no options the current state is shown",
+		"\t--on\tUse accessible output",
+		"\t--off\tGo back to tables and columns",
+	)
+}
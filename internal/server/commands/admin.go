@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"github.com/NHAS/reverse_ssh/internal/server/selftest"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/pkg/table"
+)
+
+type adminCommand struct {
+}
+
+func (a *adminCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", a.Help(false))
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can turn parts of the server on or off")
+	}
+
+	if len(line.Arguments) == 0 {
+		t, _ := table.NewTable("Subsystems", "Subsystem", "State", "Running")
+		for _, subsystem := range lockdown.Subsystems {
+			state := "enabled"
+			if s, ok := lockdown.Disabled(subsystem); ok {
+				state = fmt.Sprintf("DISABLED by %s for %s", s.By, time.Since(s.Since).Round(time.Second))
+			}
+
+			t.AddValues(subsystem, state, strconv.Itoa(lockdown.Active(subsystem)))
+		}
+		return printTable(tty, line, &t)
+	}
+
+	if line.Arguments[0].Value() == "selftest" {
+		return a.selftest(tty, line)
+	}
+
+	if len(line.Arguments) != 2 {
+		return terminal.Errorf(terminal.Usage, "%s", a.Help(false))
+	}
+
+	subsystem := line.Arguments[1].Value()
+
+	switch line.Arguments[0].Value() {
+	case "disable":
+		killed, err := lockdown.Disable(console.User.ConnectionDetails, subsystem, line.IsSet("kill"))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tty, "Disabled %s", subsystem)
+		if line.IsSet("kill") {
+			fmt.Fprintf(tty, ", closed %d running", killed)
+		}
+		fmt.Fprintln(tty)
+		return nil
+
+	case "enable":
+		if err := lockdown.Enable(console.User.ConnectionDetails, subsystem); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tty, "Enabled %s\n", subsystem)
+		return nil
+	}
+
+	return fmt.Errorf("Unknown action '%s'", line.Arguments[0].Value())
+}
+
+func (a *adminCommand) selftest(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	fmt.Fprintf(tty, "Connecting a loopback client (%s)\n", selftest.Hostname)
+
+	results := selftest.Run()
+
+	failed := 0
+	t, _ := table.NewTable("Self Test", "Subsystem", "Result", "Detail")
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			t.AddValues(r.Subsystem, "FAIL", r.Err.Error())
+			continue
+		}
+		t.AddValues(r.Subsystem, "ok", r.Took.Round(time.Millisecond).String())
+	}
+	printTable(tty, line, &t)
+
+	summary := fmt.Sprintf("%d of %d passed", len(results)-failed, len(results))
+	audit.Log(console.User.ConnectionDetails, "selftest", "", summary)
+
+	if failed > 0 {
+		return fmt.Errorf("Self test failed, %s", summary)
+	}
+
+	fmt.Fprintln(tty, "Self test passed")
+	return nil
+}
+
+func (a *adminCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (a *adminCommand) Help(explain bool) string {
+	if explain {
+		return "Turn whole parts of the server off for everyone"
+	}
+
+	return terminal.MakeHelpText(append([]string{
+		"admin [disable|enable] [OPTIONS] <exec|proxies|transfers>",
+		"admin selftest",
+		"Disabling a part of the server refuses anything new in it for every operator and client straight away, and stays in force across restarts until it is enabled again.",
+		"With no arguments, shows what is disabled and how much is running in each.",
+		"\texec\tCommands run on clients with exec",
+		"\tproxies\tConnections relayed to or from clients: ssh -J, forwards, tcp, vpn and proxy connections",
+		"\ttransfers\tFiles clients download from the server",
+		"\t--kill\tAlso close everything already running in it",
+		"selftest connects a client from inside the server process over loopback, through the same handling real clients get, and runs a shell, a 1MB transfer and a forward through it.",
+		"Each step is reported as it passes or fails, a quick check that an upgraded server still works end to end.",
+	}, tableHelp...)...)
+}
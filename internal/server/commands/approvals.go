@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/approvals"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// Commands that can never be held for approval, otherwise a user could lock everyone out of approving anything
+var neverGated = map[string]bool{
+	"approvals": true,
+	"help":      true,
+	"exit":      true,
+}
+
+// approvalGate holds a command run by a non admin until an admin approves it, if that command has been marked as needing approval
+type approvalGate struct {
+	terminal.Command
+
+	name string
+}
+
+func (g *approvalGate) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if console.User.Role == internal.RoleAdmin || line.IsSet("h") || !approvals.Required(g.name) {
+		return g.Command.Run(tty, line)
+	}
+
+	r, err := approvals.Submit(console.User.ConnectionDetails, g.name, line.RawLine, approvals.DefaultExpiry)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(tty, "'%s' needs admin approval, queued as %s. Waiting until %s...\n", g.name, r.ID, r.Expires.Format(time.Kitchen))
+
+	// Lines run for nobody, such as those queued for a client, have no connection to end the wait, only a decision or expiry can
+	var disconnected chan struct{}
+	if conn := console.User.ServerConnection; conn != nil {
+		disconnected = make(chan struct{})
+		go func() {
+			conn.Wait()
+			close(disconnected)
+		}()
+	}
+
+	err = r.Wait(disconnected)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(tty, "Approved, running\n")
+
+	return g.Command.Run(tty, line)
+}
+
+func (g *approvalGate) Unwrap() terminal.Command {
+	return g.Command
+}
+
+func gateCommands(m map[string]terminal.Command) map[string]terminal.Command {
+	gated := map[string]terminal.Command{}
+	for name, command := range m {
+		if !neverGated[name] {
+			command = &approvalGate{Command: command, name: name}
+		}
+		gated[name] = command
+	}
+	return gated
+}
+
+type approvalsCommand struct {
+}
+
+func (a *approvalsCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", a.Help(false))
+		return nil
+	}
+
+	if len(line.Arguments) == 0 || line.Arguments[0].Value() == "ls" {
+		fmt.Fprintf(tty, "Commands needing approval: %s\n", strings.Join(approvals.RequiredCommands(), ", "))
+
+		pending := approvals.Pending()
+		if len(pending) == 0 {
+			fmt.Fprintf(tty, "No pending requests\n")
+			return nil
+		}
+
+		for _, r := range pending {
+			fmt.Fprintf(tty, "%s %s (expires %s): %s\n", r.ID, r.User, r.Expires.Format(time.Kitchen), r.Line)
+		}
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can decide on approvals")
+	}
+
+	if len(line.Arguments) != 2 {
+		return terminal.Errorf(terminal.Usage, "%s", a.Help(false))
+	}
+
+	target := line.Arguments[1].Value()
+
+	switch line.Arguments[0].Value() {
+	case "approve", "deny":
+		approve := line.Arguments[0].Value() == "approve"
+
+		r, err := approvals.Decide(target, console.User.ConnectionDetails, approve)
+		if err != nil {
+			return err
+		}
+
+		decision := "Denied"
+		if approve {
+			decision = "Approved"
+		}
+
+		fmt.Fprintf(tty, "%s %s from %s: %s\n", decision, r.ID, r.User, r.Line)
+		return nil
+
+	case "require", "unrequire":
+		if neverGated[target] {
+			return fmt.Errorf("'%s' cannot need approval", target)
+		}
+
+		if _, ok := helpFor(target); !ok {
+			return terminal.Errorf(terminal.NotFound, "Unknown command '%s'", target)
+		}
+
+		return approvals.SetRequired(target, line.Arguments[0].Value() == "require")
+	}
+
+	return fmt.Errorf("Unknown action '%s'", line.Arguments[0].Value())
+}
+
+func (a *approvalsCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (a *approvalsCommand) Help(explain bool) string {
+	if explain {
+		return "Approve or deny high risk commands queued by operators"
+	}
+
+	return terminal.MakeHelpText(
+		"approvals [ls|approve|deny|require|unrequire] [id|command]",
+		"Commands marked as needing approval are held when run by a non admin until an admin approves them, or they expire after "+approvals.DefaultExpiry.String()+".",
+		"Every request and decision is written to the audit log.",
+		"\tls\tList commands needing approval and pending requests (default)",
+		"\tapprove\tLet a pending request run",
+		"\tdeny\tRefuse a pending request",
+		"\trequire\tMark a command as needing approval",
+		"\tunrequire\tStop a command needing approval",
+	)
+}
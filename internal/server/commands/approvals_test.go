@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/approvals"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+)
+
+// A queued line runs as the operator that queued it, with no connection, the same as RunQueued
+func TestQueuedGatedCommand(t *testing.T) {
+	user := &internal.User{Role: internal.RoleOperator, ConnectionDetails: "operator@127.0.0.1"}
+
+	shell := terminal.NewShell(For(user), "")
+	shell.Context = NewConsole(user, logger.NewLog("test"), t.TempDir())
+
+	var output bytes.Buffer
+	tty := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(""), &output}
+
+	go func() {
+		for i := 0; i < 500; i++ {
+			for _, r := range approvals.Pending() {
+				if r.User == user.ConnectionDetails {
+					approvals.Decide(r.ID, "admin@127.0.0.1", false)
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	err := shell.Execute(tty, "persist --method cron web01")
+	if err == nil || !strings.Contains(err.Error(), approvals.ErrDenied.Error()) {
+		t.Fatalf("expected the queued persist to wait for approval and be denied, got %v (output %q)", err, output.String())
+	}
+}
@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// auditedCommand writes the detail of errors to the audit log, the operator is only shown their message
+type auditedCommand struct {
+	terminal.Command
+
+	name string
+}
+
+func (a *auditedCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	err := a.Command.Run(tty, line)
+	if detail := terminal.DetailOf(err); detail != "" {
+		audit.Log(consoleOf(tty).User.ConnectionDetails, "command-"+string(terminal.CategoryOf(err)), a.name, fmt.Sprintf("%s: %s", err, detail))
+	}
+
+	return err
+}
+
+func (a *auditedCommand) Unwrap() terminal.Command {
+	return a.Command
+}
+
+func auditCommands(m map[string]terminal.Command) map[string]terminal.Command {
+	audited := map[string]terminal.Command{}
+	for name, command := range m {
+		audited[name] = &auditedCommand{Command: command, name: name}
+	}
+	return audited
+}
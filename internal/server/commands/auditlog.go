@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/hostkey"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type auditCommand struct {
+}
+
+func (a *auditCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") || len(line.Arguments) != 1 || line.Arguments[0].Value() != "verify" {
+		fmt.Fprintf(tty, "%s", a.Help(false))
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can verify the audit log")
+	}
+
+	signer := hostkey.Current()
+	if signer == nil {
+		return fmt.Errorf("The server key is not loaded")
+	}
+
+	report, err := audit.Verify(signer.PublicKey())
+	if err != nil {
+		return fmt.Errorf("Unable to verify the audit log: %s", err)
+	}
+
+	fmt.Fprintf(tty, "%d entries, %d signed checkpoints\n", report.Entries, report.Checkpoints)
+	if report.Unchained > 0 {
+		fmt.Fprintf(tty, "%d entries are from before the log was chained and cannot be checked\n", report.Unchained)
+	}
+	if report.Unsigned > 0 {
+		fmt.Fprintf(tty, "%d entries since the last checkpoint\n", report.Unsigned)
+	}
+
+	for _, problem := range report.Problems {
+		fmt.Fprintf(tty, "%s\n", problem)
+	}
+
+	if len(report.Problems) > 0 {
+		return terminal.Errorf(terminal.Failed, "The audit log has been tampered with, %d problems found", len(report.Problems))
+	}
+
+	fmt.Fprintf(tty, "The audit log is intact\n")
+	return nil
+}
+
+func (a *auditCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (a *auditCommand) Help(explain bool) string {
+	if explain {
+		return "Check the audit log has not been changed or truncated"
+	}
+
+	return terminal.MakeHelpText(
+		"audit verify",
+		"Follows the hash chain through every entry of the audit log and checks the signed checkpoints against the server key",
+	)
+}
@@ -0,0 +1,186 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/bans"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type bansCommand struct {
+}
+
+func (b *bansCommand) Subcommands() map[string]terminal.Command {
+	return map[string]terminal.Command{
+		"ls":  &banList{},
+		"add": &banAdd{},
+		"rm":  &banRemove{},
+	}
+}
+
+func (b *bansCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if ran, err := terminal.RunSubcommand(b, tty, line); ran {
+		return err
+	}
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", b.Help(false))
+		return nil
+	}
+
+	if len(line.Arguments) > 0 {
+		return terminal.Errorf(terminal.Usage, "Unknown action '%s', see bans -h", line.Arguments[0].Value())
+	}
+
+	return (&banList{}).Run(tty, line)
+}
+
+func (b *bansCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (b *bansCommand) Help(explain bool) string {
+	if explain {
+		return "Manage addresses the server refuses new logins from"
+	}
+
+	return terminal.MakeHelpText(
+		"bans [ls|add|rm] [OPTIONS] <ip>",
+		"Banned addresses can only log in with keys already in authorized_keys, authorized_controllee_keys or authorized_proxy_keys, so an operator sharing an address with an attacker is not locked out. Visitors to the honeypot (server --honeypot) are banned automatically.",
+		"\tls\tList bans in force (default)",
+		"\tadd\tBan an address, see bans add -h",
+		"\trm\tLift a ban",
+	)
+}
+
+type banList struct {
+}
+
+func (b *banList) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", b.Help(false))
+		return nil
+	}
+
+	list := bans.List()
+	if len(list) == 0 {
+		fmt.Fprintf(tty, "No banned addresses\n")
+		return nil
+	}
+
+	for _, ban := range list {
+		until := "forever"
+		if !ban.Expires.IsZero() {
+			until = "until " + ban.Expires.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tty, "%s %s: %s\n", ban.IP, until, ban.Reason)
+	}
+	return nil
+}
+
+func (b *banList) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (b *banList) Help(explain bool) string {
+	if explain {
+		return "List bans in force"
+	}
+
+	return terminal.MakeHelpText("bans ls")
+}
+
+type banAdd struct {
+}
+
+func (b *banAdd) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", b.Help(false))
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can change bans")
+	}
+
+	args := line.Positional
+	if len(args) != 1 {
+		return terminal.Errorf(terminal.Usage, "%s", b.Help(false))
+	}
+
+	ip := internal.ParseIP(args[0].Value())
+	if ip == nil {
+		return terminal.Errorf(terminal.Usage, "'%s' is not an IP address", args[0].Value())
+	}
+
+	duration, err := line.GetArgDuration("for")
+	if err != nil && err != terminal.ErrFlagNotSet {
+		return err
+	}
+
+	reason := line.GetArgStringDefault("reason", "banned by "+console.User.ConnectionDetails)
+
+	return bans.Add(console.User.ConnectionDetails, ip, reason, duration)
+}
+
+func (b *banAdd) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (b *banAdd) Help(explain bool) string {
+	if explain {
+		return "Ban an address"
+	}
+
+	return b.Flags().Help()
+}
+
+func (b *banAdd) Flags() *terminal.FlagSet {
+	return &terminal.FlagSet{
+		Usage: []string{"bans add [OPTIONS] <ip>"},
+		Flags: []terminal.FlagSpec{
+			{Name: "for", Type: terminal.FlagDuration, Description: "How long to ban for, i.e 24h (default forever)"},
+			{Name: "reason", Type: terminal.FlagString, Description: "Why the address is banned"},
+		},
+	}
+}
+
+type banRemove struct {
+}
+
+func (b *banRemove) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", b.Help(false))
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can change bans")
+	}
+
+	if len(line.Arguments) != 1 {
+		return terminal.Errorf(terminal.Usage, "%s", b.Help(false))
+	}
+
+	return bans.Remove(console.User.ConnectionDetails, line.Arguments[0].Value())
+}
+
+func (b *banRemove) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (b *banRemove) Help(explain bool) string {
+	if explain {
+		return "Lift a ban"
+	}
+
+	return terminal.MakeHelpText("bans rm <ip>")
+}
@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/canary"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type canaries struct {
+}
+
+func (c *canaries) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", c.Help(false))
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can see or change canaries")
+	}
+
+	if len(line.Arguments) == 0 || line.Arguments[0].Value() == "ls" {
+		list := canary.List()
+		if len(list) == 0 {
+			fmt.Fprintf(tty, "No canaries\n")
+			return nil
+		}
+
+		for _, can := range list {
+			what := can.Kind
+			if can.Kind == canary.KindPath {
+				what += " /" + can.Match
+			}
+
+			state := "never used"
+			if can.Trips > 0 {
+				state = fmt.Sprintf("USED %d times, last from %s at %s", can.Trips, can.LastFrom, can.LastTrip.Format(time.RFC3339))
+			}
+
+			fmt.Fprintf(tty, "%s %s comment: %q created by %s (%s)\n", can.ID, what, can.Comment, can.Creator, state)
+		}
+		return nil
+	}
+
+	switch line.Arguments[0].Value() {
+	case "create":
+		if len(line.Arguments) < 2 {
+			return terminal.Errorf(terminal.Usage, "%s", c.Help(false))
+		}
+
+		kind, webPath := line.Arguments[1].Value(), ""
+		if kind == canary.KindPath {
+			if len(line.Arguments) < 3 {
+				return errors.New("A path canary needs a path, i.e canary create path /backup.tar.gz")
+			}
+			webPath = line.Arguments[2].Value()
+		}
+
+		comment, _ := line.GetArgString("comment")
+
+		secret, can, err := canary.Create(console.User.ConnectionDetails, kind, webPath, comment)
+		if err != nil {
+			return err
+		}
+
+		switch can.Kind {
+		case canary.KindKey:
+			fmt.Fprintf(tty, "Created canary %s, plant this private key where only an attacker would use it. It will not be shown again:\n%s", can.ID, secret)
+		case canary.KindToken:
+			fmt.Fprintf(tty, "Created canary %s, plant this enrollment token where only an attacker would use it. It will not be shown again:\n%s\n", can.ID, secret)
+		case canary.KindPath:
+			fmt.Fprintf(tty, "Created canary %s, requests for /%s will raise the alarm\n", can.ID, can.Match)
+		}
+		return nil
+
+	case "rm":
+		if len(line.Arguments) < 2 {
+			return terminal.Errorf(terminal.Usage, "%s", c.Help(false))
+		}
+
+		return canary.Delete(console.User.ConnectionDetails, line.Arguments[len(line.Arguments)-1].Value())
+	}
+
+	return fmt.Errorf("Unknown action '%s'", line.Arguments[0].Value())
+}
+
+func (c *canaries) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (c *canaries) Help(explain bool) string {
+	if explain {
+		return "Manage keys, tokens and web paths that raise the alarm when used"
+	}
+
+	return terminal.MakeHelpText(
+		"canary [ls|create|rm] [OPTIONS] <key|token|path> <id>",
+		"Nothing legitimate uses a canary, so any use means the server, its data directory or an operator's configuration has leaked.",
+		"Using one is written to the audit log, sent to webhooks as an alert and bans the address it came from.",
+		"\tls\tList canaries and whether they have been used (default)",
+		"\tcreate key\tGenerate an operator private key",
+		"\tcreate token\tGenerate an enrollment token",
+		"\tcreate path <path>\tWatch for requests to a path on the web server",
+		"\trm\tDelete a canary",
+		"\t--comment\tWhere the canary was planted, to tell them apart",
+	)
+}
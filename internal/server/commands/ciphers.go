@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/kex"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/pkg/table"
+	"golang.org/x/crypto/ssh"
+)
+
+type ciphers struct {
+}
+
+func orAEAD(mac string) string {
+	if mac == "" {
+		return "(aead)"
+	}
+	return mac
+}
+
+func (c *ciphers) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", c.Help(false))
+		return nil
+	}
+
+	var (
+		filter  string
+		matched map[string]*ssh.ServerConn
+	)
+	if len(line.Arguments) > 0 {
+		filter = line.Arguments[len(line.Arguments)-1].Value()
+
+		// Clients can be matched by any of their aliases, everyone else by address or username
+		var err error
+		matched, err = clients.Search(filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	t, _ := table.NewTable("Negotiated Algorithms", "Connection", "Type", "Kex", "Host Key", "Cipher (in/out)", "MAC (in/out)")
+	for _, conn := range kex.Live() {
+		addr := conn.Conn.RemoteAddr().String()
+		if filter != "" && filter != addr && filter != conn.Conn.User() && !matchesConn(matched, conn) {
+			continue
+		}
+
+		a := conn.Algorithms
+
+		kex := a.KeyExchange
+		if internal.IsHybridKeyExchange(kex) {
+			kex += " (post-quantum)"
+		}
+
+		t.AddValues(conn.Conn.User()+"@"+addr, conn.Type, kex, a.HostKey, a.CipherIn+" / "+a.CipherOut, orAEAD(a.MACIn)+" / "+orAEAD(a.MACOut))
+	}
+	return printTable(tty, line, &t)
+}
+
+func matchesConn(matched map[string]*ssh.ServerConn, conn kex.Connection) bool {
+	for _, sc := range matched {
+		if sc == conn.Conn {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ciphers) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (c *ciphers) Help(explain bool) string {
+	if explain {
+		return "Show the SSH algorithms each live connection negotiated"
+	}
+
+	return terminal.MakeHelpText(append([]string{
+		"ciphers [OPTIONS] [remote_id|address|username]",
+		"Lists the key exchange, host key, cipher and MAC of every connection to the server, or only those matching the filter.",
+		"The server's choices come from algorithms.json in the data directory, see the README.",
+	}, tableHelp...)...)
+}
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type clones struct {
+}
+
+func (c *clones) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", c.Help(false))
+		return nil
+	}
+
+	if len(line.Arguments) == 0 || line.Arguments[0].Value() == "ls" {
+		list := clients.Clones()
+		if len(list) == 0 {
+			fmt.Fprintf(tty, "No clones connected, policy is %s\n", clients.GetLimits().Clones)
+			return nil
+		}
+
+		for _, clone := range list {
+			state := ""
+			if clone.Quarantined {
+				state = " QUARANTINED"
+			}
+			fmt.Fprintf(tty, "%s clone of %s from %s%s\n", clone.ID, clone.Of, clone.Address, state)
+		}
+		return nil
+	}
+
+	switch line.Arguments[0].Value() {
+	case "release":
+		if console.User.Role != internal.RoleAdmin {
+			return terminal.Errorf(terminal.Permission, "Only admins can release quarantined clones")
+		}
+
+		if len(line.Arguments) != 2 {
+			return terminal.Errorf(terminal.Usage, "%s", c.Help(false))
+		}
+
+		id := line.Arguments[1].Value()
+		if err := clients.Release(id); err != nil {
+			return err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "clone-release", id, "")
+		fmt.Fprintf(tty, "Released %s\n", id)
+		return nil
+	}
+
+	return fmt.Errorf("Unknown action '%s'", line.Arguments[0].Value())
+}
+
+func quarantinedClones() (out []clients.Clone) {
+	for _, clone := range clients.Clones() {
+		if clone.Quarantined {
+			out = append(out, clone)
+		}
+	}
+	return out
+}
+
+func (c *clones) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) == 2 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (c *clones) Help(explain bool) string {
+	if explain {
+		return "List clients connected from a second address, and release quarantined ones"
+	}
+
+	return terminal.MakeHelpText(
+		"clones [ls|release] <id>",
+		"A clone is a client whose key and hostname are already connected from another address, a copied binary or vm. What happens to them is set with the server's --clone-policy.",
+		"Quarantined clones are left out of ls and cannot be used until they are released.",
+		"\tls\tList connected clones (default)",
+		"\trelease\tLet a quarantined clone be used",
+	)
+}
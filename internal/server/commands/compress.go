@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/identity"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type compression struct {
+}
+
+func (c *compression) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", c.Help(false))
+		return nil
+	}
+
+	target := ""
+	if len(line.Arguments) > 0 {
+		target = line.Arguments[len(line.Arguments)-1].Value()
+	}
+
+	if line.IsSet("l") {
+		foundClients, err := clients.Search(target)
+		if err != nil {
+			return err
+		}
+
+		if len(foundClients) == 0 {
+			fmt.Fprintln(tty, "No clients")
+			return nil
+		}
+
+		var ids []string
+		for id := range foundClients {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			state := "off"
+			if clients.Compressed(id) {
+				state = "on"
+			}
+
+			t := clients.GetTraffic(id)
+			if t.Wire == 0 {
+				fmt.Fprintf(tty, "%s %s, nothing compressed yet\n", id, state)
+				continue
+			}
+
+			fmt.Fprintf(tty, "%s %s, %d bytes sent as %d (%.2fx)\n", id, state, t.Raw, t.Wire, t.Ratio())
+		}
+		return nil
+	}
+
+	on, off := line.IsSet("on"), line.IsSet("off")
+	if on == off || target == "" {
+		fmt.Fprintf(tty, "%s", c.Help(false))
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can change client compression")
+	}
+
+	foundClients, err := resolve(tty, target)
+	if err != nil {
+		return err
+	}
+
+	state := "off"
+	if on {
+		state = "on"
+	}
+
+	for id, sc := range foundClients {
+		if err := clients.Require(id, "compress", "compression"); on && err != nil {
+			fmt.Fprintf(tty, "%s\n", err)
+			continue
+		}
+
+		clients.SetCompression(id, on)
+
+		err := identity.SetFlag(sc.Permissions.Extensions["pubkey-fp"], "compress", on)
+		if err != nil {
+			fmt.Fprintf(tty, "%s: changed for this connection only, %s\n", id, err)
+		} else {
+			fmt.Fprintf(tty, "%s: compression %s\n", id, state)
+		}
+
+		audit.Log(console.User.ConnectionDetails, "compression", id, state)
+	}
+
+	return nil
+}
+
+func (c *compression) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (c *compression) Help(explain bool) string {
+	if explain {
+		return "Compress connections the server opens through clients"
+	}
+
+	return terminal.MakeHelpText(
+		"compress [OPTIONS] <remote_id>",
+		"Turns compression on or off for every connection the server opens through a client, such as tcp and named forwards, and records it against the client's key so it stays on after reconnecting.",
+		"Individual connections can also ask for it with tcp --compress, fwd --compress and vpn --compress.",
+		"\t-l\tList clients with their compression and how much it has saved, optionally filtered by remote_id",
+		"\t--on\tCompress connections through the client",
+		"\t--off\tOnly compress connections that ask for it",
+	)
+}
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/client/config"
+	"github.com/NHAS/reverse_ssh/internal/server/webserver"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type configureBinary struct {
+}
+
+// listFlag reads a comma separated flag value
+func listFlag(line terminal.ParsedLine, flag string) (out []string) {
+	value, _ := line.GetArgString(flag)
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func (cb *configureBinary) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") || len(line.Arguments) < 1 {
+		fmt.Fprintf(tty, "%s", cb.Help(false))
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can configure clients")
+	}
+
+	name := line.Arguments[len(line.Arguments)-1].Value()
+
+	f, err := webserver.Get(name)
+	if err != nil {
+		return err
+	}
+
+	signer, err := loadServerKey(console.DataDir)
+	if err != nil {
+		return err
+	}
+
+	c := config.Config{
+		Destinations: listFlag(line, "destination"),
+		Fingerprints: listFlag(line, "fingerprint"),
+	}
+
+	c.Proxy, _ = line.GetArgString("proxy")
+
+	if len(c.Destinations) == 0 {
+		c.Destinations = strings.Split(f.CallbackAddress, ",")
+	}
+
+	if len(c.Fingerprints) == 0 {
+		c.Fingerprints = []string{internal.FingerprintSHA256Hex(signer.PublicKey())}
+	}
+
+	contents, err := config.Seal(c, signer)
+	if err != nil {
+		return err
+	}
+
+	if err := webserver.Configure(name, contents, strings.Join(c.Destinations, ",")); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(tty, "%s now calls back to %s\n", name, strings.Join(c.Destinations, ", "))
+	fmt.Fprintf(tty, "Accepting server keys: %s\n", strings.Join(c.Fingerprints, ", "))
+	if c.Proxy != "" {
+		fmt.Fprintf(tty, "Through proxy: %s\n", c.Proxy)
+	}
+
+	return nil
+}
+
+func (cb *configureBinary) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.WebServerFileIds}
+}
+
+func (cb *configureBinary) Help(explain bool) string {
+	if explain {
+		return "Change where a built client calls back to without rebuilding it"
+	}
+
+	return terminal.MakeHelpText(
+		"configure-binary [OPTIONS] <link name>",
+		"Writes a configuration section signed with this server's key into a client made by link. When it starts the client checks the signature against the fingerprint it was built with and, if it matches, uses the configuration in place of what was built in.",
+		"Command line arguments given to the client still take priority. Clients compressed with upx or built with garble cannot be configured.",
+		"\t--destination\tCallback addresses to try in turn, comma separated (defaults to the current callback address)",
+		"\t--fingerprint\tServer key fingerprints to accept, comma separated (defaults to this server's key)",
+		"\t--proxy\tHTTP connect proxy to use",
+	)
+}
@@ -0,0 +1,253 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"github.com/NHAS/reverse_ssh/internal/server/vault"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/pkg/diff"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Neither side of a diff is read past this, diff is for configuration and command output rather than whole disks
+const maxDiffSize = 4 * 1024 * 1024
+
+type diffCommand struct {
+}
+
+func (d *diffCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") || len(line.Arguments) < 4 {
+		return terminal.Errorf(terminal.Usage, "%s", d.Help(false))
+	}
+
+	kind := line.Arguments[0].Value()
+	if kind != "exec" && kind != "file" {
+		return terminal.Errorf(terminal.Usage, "%s", d.Help(false))
+	}
+
+	// Whatever follows the clients is the command, or the path of the file, as it was typed
+	what := strings.TrimSpace(line.RawLine[line.Arguments[2].End():])
+
+	var fetch func(id string, client *ssh.ServerConn) ([]byte, error)
+	switch kind {
+	case "exec":
+		if err := lockdown.Check(lockdown.Exec); err != nil {
+			return err
+		}
+
+		command, err := vault.Resolve(console.User.ConnectionDetails, console.User.Role, what)
+		if err != nil {
+			return err
+		}
+
+		request := ssh.Marshal(&struct{ Cmd string }{command})
+		fetch = func(id string, client *ssh.ServerConn) ([]byte, error) {
+			var output bytes.Buffer
+			err := execOn(id, client, console.User.ConnectionDetails, request, nil, &capped{w: &output})
+			return output.Bytes(), err
+		}
+
+	case "file":
+		if err := lockdown.Check(lockdown.Proxies); err != nil {
+			return err
+		}
+
+		fetch = func(id string, client *ssh.ServerConn) ([]byte, error) {
+			return readFile(id, client, console.User.ConnectionDetails, what)
+		}
+	}
+
+	var (
+		ids     [2]string
+		names   [2]string
+		conns   [2]*ssh.ServerConn
+		outputs [2][]byte
+		errs    [2]error
+	)
+	for i, target := range []string{line.Arguments[1].Value(), line.Arguments[2].Value()} {
+		id, conn, err := resolveOne(tty, target)
+		if err != nil {
+			return err
+		}
+		ids[i], names[i], conns[i] = id, id+" ("+conn.User()+")", conn
+	}
+
+	var wait sync.WaitGroup
+	for i := range conns {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			outputs[i], errs[i] = fetch(ids[i], conns[i])
+		}(i)
+	}
+	wait.Wait()
+
+	verb := "run"
+	if kind == "file" {
+		verb = "read"
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return terminal.Errorf(terminal.Failed, "Unable to %s %s on %s: %s", verb, what, names[i], err)
+		}
+	}
+
+	if bytes.IndexByte(outputs[0], 0) != -1 || bytes.IndexByte(outputs[1], 0) != -1 {
+		if bytes.Equal(outputs[0], outputs[1]) {
+			fmt.Fprintf(tty, "No differences\n")
+		} else {
+			fmt.Fprintf(tty, "Binary output differs\n")
+		}
+		return nil
+	}
+
+	unified := diff.Unified(names[0], names[1], diff.Lines(string(outputs[0])), diff.Lines(string(outputs[1])), 3)
+	if unified == "" {
+		fmt.Fprintf(tty, "No differences\n")
+		return nil
+	}
+
+	// Only coloured on a terminal, so a diff written to a file or through exec can be applied as it is
+	term, ok := tty.(*terminal.Terminal)
+	if !ok || term.Plain() {
+		fmt.Fprint(tty, unified)
+		return nil
+	}
+
+	for _, l := range diff.Lines(unified) {
+		var colour []byte
+		switch {
+		case strings.HasPrefix(l, "@@"):
+			colour = term.Escape.Cyan
+		case strings.HasPrefix(l, "+"):
+			colour = term.Escape.Green
+		case strings.HasPrefix(l, "-"):
+			colour = term.Escape.Red
+		}
+
+		if colour == nil {
+			fmt.Fprintf(tty, "%s\n", l)
+			continue
+		}
+		fmt.Fprintf(tty, "%s%s%s\n", colour, l, term.Escape.Reset)
+	}
+
+	return nil
+}
+
+// openSFTP starts the sftp subsystem on a client, counted against the lockdown subsystem given. end ends it
+func openSFTP(client *ssh.ServerConn, subsystem string) (c *sftp.Client, end func(), err error) {
+	channel, requests, err := client.OpenChannel("session", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	go ssh.DiscardRequests(requests)
+
+	done, err := lockdown.Begin(subsystem, channel)
+	if err != nil {
+		channel.Close()
+		return nil, nil, err
+	}
+
+	end = func() {
+		if c != nil {
+			c.Close()
+		}
+		channel.Close()
+		done()
+	}
+
+	ok, err := channel.SendRequest("subsystem", true, ssh.Marshal(&struct{ Name string }{"sftp"}))
+	if err == nil && !ok {
+		err = fmt.Errorf("client refused sftp, it may have been built without file transfers")
+	}
+	if err != nil {
+		end()
+		return nil, nil, err
+	}
+
+	if c, err = sftp.NewClientPipe(channel, channel); err != nil {
+		end()
+		return nil, nil, err
+	}
+
+	return c, end, nil
+}
+
+// readFile takes a file from a client over sftp, as an operator copying it out through the server would
+func readFile(id string, client *ssh.ServerConn, by, path string) ([]byte, error) {
+	c, end, err := openSFTP(client, lockdown.Proxies)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	clients.RecordSession(id, "diff "+path, by)
+
+	f, err := c.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var contents bytes.Buffer
+	if _, err := io.Copy(&capped{w: &contents}, f); err != nil {
+		return nil, err
+	}
+
+	return contents.Bytes(), nil
+}
+
+// capped stops keeping what is written to it past maxDiffSize, while still taking it all so the other end is not stalled
+type capped struct {
+	w       io.Writer
+	written int
+}
+
+func (c *capped) Write(p []byte) (int, error) {
+	keep := p
+	if left := maxDiffSize - c.written; len(keep) > left {
+		keep = keep[:left]
+	}
+
+	n, err := c.w.Write(keep)
+	c.written += n
+	if err != nil {
+		return n, err
+	}
+
+	return len(p), nil
+}
+
+func (d *diffCommand) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) >= 1 && len(line.Arguments) <= 3 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (d *diffCommand) Help(explain bool) string {
+	if explain {
+		return "Compare the output of a command, or a file, on two clients"
+	}
+
+	return terminal.MakeHelpText(
+		"diff exec <client> <client> <command...>",
+		"diff file <client> <client> <path>",
+		"Runs the command, or reads the file, on both clients at once and shows how they differ as a unified diff, e.g",
+		"\tdiff file web01 web02 /etc/ssh/sshd_config",
+		"\tdiff exec web01 web02 dpkg -l",
+		"Reading files needs the clients to have been built with file transfers",
+	)
+}
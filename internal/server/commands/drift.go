@@ -0,0 +1,193 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/facts"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"github.com/NHAS/reverse_ssh/internal/server/webhooks"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type drift struct {
+}
+
+func (d *drift) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	args := positional(line, "notify")
+
+	if line.IsSet("h") || len(args) == 0 {
+		return terminal.Errorf(terminal.Usage, "%s", d.Help(false))
+	}
+
+	if args[0].Value() == "rules" {
+		return d.rules(tty, line, args[1:])
+	}
+
+	if len(args) != 1 {
+		return terminal.Errorf(terminal.Usage, "%s", d.Help(false))
+	}
+
+	target := args[0].Value()
+	if line.IsSet("now") {
+		if err := lockdown.Check(lockdown.Exec); err != nil {
+			return err
+		}
+
+		id, conn, err := resolveOne(tty, target)
+		if err != nil {
+			return err
+		}
+		target = id
+
+		r, _, err := clients.FindRecord(id)
+		if err != nil {
+			return terminal.Errorf(terminal.NotFound, "%s", err)
+		}
+
+		if _, err := facts.Record(r.ID, facts.Collect(id, conn)); err != nil {
+			return err
+		}
+	}
+
+	r, _, err := clients.FindRecord(target)
+	if err != nil {
+		return terminal.Errorf(terminal.NotFound, "%s", err)
+	}
+
+	previous, latest, ok := facts.Latest(r.ID)
+	if !ok {
+		return terminal.Errorf(terminal.NotFound, "No facts have been taken from %s yet, take them with drift --now %s", r.ID, r.ID)
+	}
+
+	for _, fact := range facts.Names() {
+		if reason, failed := latest.Failed[fact]; failed {
+			fmt.Fprintf(tty, "Unable to list %s at %s: %s\n", fact, latest.Taken.Format(time.RFC3339), reason)
+		}
+	}
+
+	if previous.Taken.IsZero() {
+		fmt.Fprintf(tty, "Only one snapshot of %s (%s) has been taken, at %s, there is nothing to compare it with yet\n", r.ID, r.Hostname, latest.Taken.Format(time.RFC3339))
+		return nil
+	}
+
+	changes := facts.Compare(previous, latest)
+	if len(changes) == 0 {
+		fmt.Fprintf(tty, "No changes to %s (%s) between %s and %s\n", r.ID, r.Hostname, previous.Taken.Format(time.RFC3339), latest.Taken.Format(time.RFC3339))
+		return nil
+	}
+
+	fmt.Fprintf(tty, "Changes to %s (%s) between %s and %s\n", r.ID, r.Hostname, previous.Taken.Format(time.RFC3339), latest.Taken.Format(time.RFC3339))
+	for _, c := range changes {
+		fmt.Fprintf(tty, "%s:\n", c.Fact)
+		for _, l := range c.Added {
+			fmt.Fprintf(tty, "\t+ %s\n", l)
+		}
+		for _, l := range c.Removed {
+			fmt.Fprintf(tty, "\t- %s\n", l)
+		}
+	}
+
+	return nil
+}
+
+// rules adds, removes and lists the rules that raise alerts when the facts of a client change
+func (d *drift) rules(tty io.ReadWriter, line terminal.ParsedLine, args []terminal.Argument) error {
+	console := consoleOf(tty)
+
+	if len(args) == 0 || args[0].Value() == "ls" {
+		rules := facts.Rules()
+		if len(rules) == 0 {
+			fmt.Fprintf(tty, "No drift rules\n")
+			return nil
+		}
+
+		for _, r := range rules {
+			what := "any change to " + r.Fact
+			if r.Match != "" {
+				what = fmt.Sprintf("changes to %s containing %q", r.Fact, r.Match)
+			}
+
+			to := "every webhook"
+			if r.Notify != "" {
+				to = "webhooks matching " + r.Notify
+			}
+
+			fmt.Fprintf(tty, "%s alerts %s on %s (added by %s)\n", r.ID, to, what, r.Creator)
+		}
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can change drift rules")
+	}
+
+	switch args[0].Value() {
+	case "add":
+		if len(args) < 2 || len(args) > 3 {
+			return terminal.Errorf(terminal.Usage, "%s", d.Help(false))
+		}
+
+		notify, _ := line.GetArgString("notify")
+		if notify != "" && !webhooks.Matching(notify) {
+			return terminal.Errorf(terminal.NotFound, "No webhook matches '%s', add one with webhook --on", notify)
+		}
+
+		match := ""
+		if len(args) == 3 {
+			match = args[2].Value()
+		}
+
+		r, err := facts.AddRule(console.User.ConnectionDetails, args[1].Value(), match, notify)
+		if err != nil {
+			return terminal.Errorf(terminal.Usage, "%s", err)
+		}
+
+		fmt.Fprintf(tty, "Added drift rule %s\n", r.ID)
+		return nil
+
+	case "rm":
+		if len(args) != 2 {
+			return terminal.Errorf(terminal.Usage, "%s", d.Help(false))
+		}
+
+		if err := facts.DeleteRule(console.User.ConnectionDetails, args[1].Value()); err != nil {
+			return terminal.Errorf(terminal.NotFound, "%s", err)
+		}
+
+		fmt.Fprintf(tty, "Removed drift rule %s\n", args[1].Value())
+		return nil
+	}
+
+	return terminal.Errorf(terminal.Usage, "%s", d.Help(false))
+}
+
+func (d *drift) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (d *drift) Help(explain bool) string {
+	if explain {
+		return "Show how the packages, listening ports and users of a client have changed"
+	}
+
+	return terminal.MakeHelpText(
+		"drift [--now] <client>",
+		"drift rules [ls]",
+		"drift rules add <fact> [text] [--notify webhook]",
+		"drift rules rm <id>",
+		"The "+strings.Join(facts.Names(), ", ")+" of every connected client are taken every "+facts.Every.String()+", drift shows what changed between the last two times",
+		"\t--now\tTake them again now, then show what changed since the time before",
+		"Rules raise an alert on the webhooks when a fact changes on any client, or only when a line added or removed contains text, e.g",
+		"\tdrift rules add ports :4444",
+		"\tdrift rules add users --notify slack",
+	)
+}
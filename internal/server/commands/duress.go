@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// Commands that never touch clients, so they can run as normal for a duress login
+var duressSafe = map[string]bool{
+	"help":    true,
+	"exit":    true,
+	"version": true,
+	"set":     true,
+	"unset":   true,
+	"if":      true,
+	// What it times is still a decoy, and it hides the slow log from duress logins itself
+	"time": true,
+}
+
+// decoy stands in for a command when the user logged in with a duress key, answering as if the server had no clients
+type decoy struct {
+	terminal.Command
+	name string
+}
+
+func (d *decoy) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", d.Help(false))
+		return nil
+	}
+
+	switch d.name {
+	case "ls":
+		return fmt.Errorf("No RSSH clients connected")
+	case "who":
+		fmt.Fprintf(tty, "%s\n", console.User.ConnectionDetails)
+		return nil
+	case "foreach":
+		// Looping over an empty fleet does nothing
+		return nil
+	case "jobs":
+		if len(line.Arguments) == 0 {
+			fmt.Fprintf(tty, "No jobs\n")
+			return nil
+		}
+	case "sessions":
+		if len(line.Arguments) == 0 {
+			fmt.Fprintf(tty, "No sessions, start one with connect\n")
+			return nil
+		}
+	}
+
+	if len(line.Arguments) == 0 {
+		fmt.Fprintf(tty, "%s", d.Help(false))
+		return nil
+	}
+
+	return terminal.Errorf(terminal.NotFound, "No clients matched '%s'", line.Arguments[len(line.Arguments)-1].Value())
+}
+
+func (d *decoy) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (d *decoy) Unwrap() terminal.Command {
+	return d.Command
+}
+
+func duressCommands(m map[string]terminal.Command) map[string]terminal.Command {
+	decoys := map[string]terminal.Command{}
+	for name, command := range m {
+		if !duressSafe[name] {
+			command = &decoy{Command: command, name: name}
+		}
+		decoys[name] = command
+	}
+	return decoys
+}
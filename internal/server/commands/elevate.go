@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/vault"
+)
+
+// Matched against the last line of output from the client, sudo, su, doas and runas respectively
+var elevationPrompts = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\[sudo\] password for [^:\n]*: ?$`),
+	regexp.MustCompile(`(?i)^(\S+'s )?password: ?$`),
+	regexp.MustCompile(`(?i)^doas \([^)]*\) password: ?$`),
+	regexp.MustCompile(`(?i)^enter the password for [^:\n]*: ?$`),
+}
+
+// elevator sits between the operator and a session, offering to answer password prompts with a vault secret
+type elevator struct {
+	sync.Mutex
+
+	secretName string
+	session    io.Writer
+	tty        io.ReadWriter
+
+	user, role, target string
+
+	pending  bool
+	lastLine []byte
+
+	// Redacted from anything shown to the operator, should a remote echo it back
+	used [][]byte
+}
+
+func newElevator(secretName string, session io.Writer, tty io.ReadWriter, user *internal.User, target string) *elevator {
+	return &elevator{
+		secretName: secretName,
+		session:    session,
+		tty:        tty,
+		user:       user.ConnectionDetails,
+		role:       user.Role,
+		target:     target,
+	}
+}
+
+func (e *elevator) Write(b []byte) (int, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	out := b
+	for _, secret := range e.used {
+		out = bytes.ReplaceAll(out, secret, []byte("********"))
+	}
+
+	if _, err := e.tty.Write(out); err != nil {
+		return 0, err
+	}
+
+	if i := bytes.LastIndexByte(b, '\n'); i != -1 {
+		e.lastLine = append(e.lastLine[:0], b[i+1:]...)
+	} else {
+		e.lastLine = append(e.lastLine, b...)
+	}
+
+	if len(e.lastLine) > 256 {
+		e.lastLine = e.lastLine[len(e.lastLine)-256:]
+	}
+
+	if !e.pending {
+		line := bytes.TrimRight(e.lastLine, "\r")
+		for _, prompt := range elevationPrompts {
+			if prompt.Match(line) {
+				e.pending = true
+				fmt.Fprintf(e.tty, "\r\n[rssh] Elevation prompt detected, answer with vault secret '%s'? [y/N] ", e.secretName)
+				break
+			}
+		}
+	}
+
+	return len(b), nil
+}
+
+func (e *elevator) Read(b []byte) (int, error) {
+	for {
+		n, err := e.tty.Read(b)
+		if n == 0 || err != nil {
+			return n, err
+		}
+
+		e.Lock()
+		if !e.pending {
+			e.Unlock()
+			return n, nil
+		}
+
+		// The first key pressed after the question is the answer, and is not passed on
+		e.pending = false
+		answer := b[0]
+		e.answer(answer == 'y' || answer == 'Y')
+		e.Unlock()
+
+		copy(b, b[1:n])
+		if n-1 > 0 {
+			return n - 1, nil
+		}
+	}
+}
+
+// e.Lock must be held
+func (e *elevator) answer(confirmed bool) {
+	if !confirmed {
+		fmt.Fprint(e.tty, "n\r\n")
+		return
+	}
+
+	fmt.Fprint(e.tty, "y\r\n")
+
+	secret, err := vault.Get(e.user, e.role, e.secretName)
+	if err != nil {
+		fmt.Fprintf(e.tty, "[rssh] Unable to read secret: %s\r\n", err)
+		return
+	}
+
+	e.used = append(e.used, secret)
+
+	_, err = e.session.Write(append(append([]byte{}, secret...), '\r'))
+	if err != nil {
+		fmt.Fprintf(e.tty, "[rssh] Unable to send secret: %s\r\n", err)
+	}
+
+	audit.Log(e.user, "elevate", e.target, fmt.Sprintf("answered elevation prompt with vault secret %q", e.secretName))
+}
@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// How many bytes of a file each printf line writes, keeping lines well under what any shell will take
+const printfChunk = 512
+
+type encode struct {
+}
+
+// ReadsInput as encode turns a file given with < into text
+func (e *encode) ReadsInput() {}
+
+func (e *encode) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", e.Help(false))
+		return nil
+	}
+
+	args := positional(line, "printf")
+
+	src := terminal.InputOf(tty)
+	switch {
+	case src != nil && len(args) == 0:
+	case src == nil && len(args) == 1:
+		f, err := os.Open(filepath.Join(console.DataDir, "downloads", filepath.Join("/", args[0].Value())))
+		if err != nil {
+			return terminal.Errorf(terminal.NotFound, "%s is not in the downloads directory", args[0].Value())
+		}
+		defer f.Close()
+
+		src = f
+	default:
+		return terminal.Errorf(terminal.Usage, "%s", e.Help(false))
+	}
+
+	if line.IsSet("gzip") {
+		src = gzipped(src)
+	}
+
+	out := bufio.NewWriter(tty)
+	defer out.Flush()
+
+	if line.IsSet("printf") {
+		target, err := line.GetArgString("printf")
+		if err != nil {
+			return terminal.Errorf(terminal.Usage, "--printf needs the path to write to on the target")
+		}
+
+		return printfScript(out, src, target)
+	}
+
+	w := base64.NewEncoder(base64.StdEncoding, &wrapped{w: out, width: 76})
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	_, err := out.WriteString("\n")
+	return err
+}
+
+// gzipped compresses r as it is read
+func gzipped(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, r)
+		if err == nil {
+			err = gz.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// shellQuote single quotes s for sh, where nothing inside single quotes is special but the closing quote
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// printfScript writes sh commands that recreate what r holds at target using only the printf and : builtins, so it goes through
+// anything that passes text to a shell. Bytes other than the plainest characters are written as octal escapes, a dash among them
+// as printf would take one at the start for an option
+func printfScript(w io.Writer, r io.Reader, target string) error {
+	quoted := shellQuote(target)
+	if _, err := fmt.Fprintf(w, ": > %s\n", quoted); err != nil {
+		return err
+	}
+
+	var (
+		chunk = make([]byte, printfChunk)
+		sb    strings.Builder
+	)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			sb.Reset()
+			for _, b := range chunk[:n] {
+				if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte(" ._,:/=+", b) != -1 {
+					sb.WriteByte(b)
+					continue
+				}
+				fmt.Fprintf(&sb, "\\%03o", b)
+			}
+
+			if _, err := fmt.Fprintf(w, "printf '%s' >> %s\n", sb.String(), quoted); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// wrapped breaks what is written to it into lines of width
+type wrapped struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+func (l *wrapped) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if l.col == l.width {
+			if _, err := l.w.Write([]byte("\n")); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+
+		n := l.width - l.col
+		if n > len(p) {
+			n = len(p)
+		}
+
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+
+		l.col += n
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+func (e *encode) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (e *encode) Help(explain bool) string {
+	if explain {
+		return "Turn a file into text that can be pasted into a shell"
+	}
+
+	return terminal.MakeHelpText(
+		"encode [OPTIONS] <file in downloads>",
+		"encode [OPTIONS] < file",
+		"Prints a file from downloads/ in the data directory, or one in output/ given with <, as base64. Decode it on the target with base64 -d, or certutil -decode on windows",
+		"\t--gzip\tCompress it first, decode with base64 -d | gunzip",
+		"\t--printf\tInstead print sh commands that write it to this path using only printf, for targets with nothing else",
+	)
+}
+
+type decode struct {
+}
+
+// ReadsInput as decode reads base64 from a file given with <
+func (d *decode) ReadsInput() {}
+
+func (d *decode) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", d.Help(false))
+		return nil
+	}
+
+	// What comes out is usually binary, which only makes a mess of a terminal
+	if _, ok := tty.(*terminal.Terminal); ok {
+		return terminal.Errorf(terminal.Usage, "decode writes the decoded file, redirect it with > file")
+	}
+
+	var src io.Reader
+	if input := terminal.InputOf(tty); input != nil {
+		src = input
+	} else if len(line.Arguments) > 0 {
+		src = strings.NewReader(strings.Join(line.ArgumentsAsStrings(), ""))
+	} else {
+		return terminal.Errorf(terminal.Usage, "%s", d.Help(false))
+	}
+
+	// Line breaks and spaces are left by whatever the text was copied out of
+	decoded := base64.NewDecoder(base64.StdEncoding, &withoutSpace{r: src})
+
+	var r io.Reader = decoded
+	if line.IsSet("gzip") {
+		gz, err := gzip.NewReader(decoded)
+		if err != nil {
+			return terminal.Errorf(terminal.Failed, "Not gzipped base64: %s", err)
+		}
+		defer gz.Close()
+
+		r = gz
+	}
+
+	if _, err := io.Copy(tty, r); err != nil {
+		return terminal.Errorf(terminal.Failed, "Unable to decode: %s", err)
+	}
+
+	return nil
+}
+
+// withoutSpace drops whitespace as it is read
+type withoutSpace struct {
+	r io.Reader
+}
+
+func (w *withoutSpace) Read(p []byte) (int, error) {
+	for {
+		n, err := w.r.Read(p)
+
+		kept := 0
+		for _, b := range p[:n] {
+			if b != ' ' && b != '\n' && b != '\r' && b != '\t' {
+				p[kept] = b
+				kept++
+			}
+		}
+
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+func (d *decode) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (d *decode) Help(explain bool) string {
+	if explain {
+		return "Turn base64 copied out of a shell back into a file"
+	}
+
+	return terminal.MakeHelpText(
+		"decode [OPTIONS] < file > decoded",
+		"decode [OPTIONS] <base64> > decoded",
+		"Decodes base64, such as the output of exec --raw host base64 file saved with > file, writing it to a file in output/",
+		"\t--gzip\tThe file was gzipped before it was encoded, as with gzip -c file | base64",
+	)
+}
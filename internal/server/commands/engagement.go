@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/engagements"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type engagement struct {
+}
+
+// parseWhen takes either an absolute time or a duration from now, as typing out RFC3339 for "in two weeks" is painful
+func parseWhen(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(d), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse time %q, use RFC3339, YYYY-MM-DD or a duration from now like 72h", value)
+}
+
+func (e *engagement) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", e.Help(false))
+		return nil
+	}
+
+	if len(line.Arguments) == 0 || line.Arguments[0].Value() == "ls" {
+		list := engagements.List()
+		if len(list) == 0 {
+			fmt.Fprintf(tty, "No engagements\n")
+			return nil
+		}
+
+		now := time.Now()
+		for _, en := range list {
+			state := "active"
+			switch {
+			case en.Archived != "":
+				state = "ended, archived to " + en.Archived
+			case now.Before(en.Start):
+				state = "not started"
+			case !en.Active(now):
+				state = "ended"
+			}
+
+			fmt.Fprintf(tty, "%s %s to %s self-remove: %t (%s)\n", en.Name, en.Start.Format(time.RFC3339), en.End.Format(time.RFC3339), en.SelfRemove, state)
+		}
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can change engagements")
+	}
+
+	if len(line.Arguments) < 2 {
+		return terminal.Errorf(terminal.Usage, "%s", e.Help(false))
+	}
+
+	// Flag values are also arguments, so the name is always last
+	name := line.Arguments[len(line.Arguments)-1].Value()
+
+	switch line.Arguments[0].Value() {
+	case "create":
+		start := time.Now()
+		if value, err := line.GetArgString("start"); err == nil {
+			start, err = parseWhen(value)
+			if err != nil {
+				return err
+			}
+		}
+
+		value, err := line.GetArgString("end")
+		if err != nil {
+			return errors.New("an engagement needs an --end")
+		}
+
+		end, err := parseWhen(value)
+		if err != nil {
+			return err
+		}
+
+		err = engagements.Create(console.User.ConnectionDetails, engagements.Engagement{
+			Name:       name,
+			Start:      start,
+			End:        end,
+			SelfRemove: line.IsSet("self-remove"),
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tty, "Created %s, tag clients with: link --engagement %s\n", name, name)
+		return nil
+
+	case "rm":
+		return engagements.Delete(console.User.ConnectionDetails, name)
+	}
+
+	return fmt.Errorf("Unknown action '%s'", line.Arguments[0].Value())
+}
+
+func (e *engagement) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (e *engagement) Help(explain bool) string {
+	if explain {
+		return "Bound clients to the time window of an engagement"
+	}
+
+	return terminal.MakeHelpText(
+		"engagement [ls|create|rm] [OPTIONS] <name>",
+		"Clients built with link --engagement are refused outside the engagement window.",
+		"When an engagement ends its connected clients are disconnected, or told to remove their persistence and themselves with --self-remove, and the audit and watch logs covering it are archived under datadir/archives.",
+		"\tls\tList engagements (default)",
+		"\tcreate\tCreate an engagement",
+		"\trm\tDelete an engagement, its clients are no longer restricted",
+		"\t--start\tWhen the engagement starts, RFC3339, YYYY-MM-DD or a duration from now (default now)",
+		"\t--end\tWhen the engagement ends, same formats as --start",
+		"\t--self-remove\tTell clients to remove themselves once the engagement ends",
+	)
+}
@@ -1,11 +1,17 @@
 package commands
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/jobs"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"github.com/NHAS/reverse_ssh/internal/server/vault"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
 	"golang.org/x/crypto/ssh"
@@ -14,32 +20,59 @@ import (
 type exec struct {
 }
 
+// Input sent to several clients is read in full first so each can be sent all of it, this is as much as is held
+const maxSharedInput = 64 << 20
+
+// ReadsInput as exec sends a file given with < to the command's stdin
+func (e *exec) ReadsInput() {}
+
 func (e *exec) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
 	if line.IsSet("h") {
 		fmt.Fprintf(tty, "%s", e.Help(false))
 		return nil
 	}
 
-	if len(line.Arguments) < 2 {
+	args := positional(line, "parallel", "batch")
+	if len(args) < 2 {
 		return fmt.Errorf("Not enough arguments supplied. Needs at least, host|filter command...")
 	}
 
-	filter := ""
-	command := ""
-	if len(line.ArgumentsAsStrings()) > 0 {
-		filter = line.ArgumentsAsStrings()[0]
-		command = line.RawLine[line.Arguments[0].End():]
-	}
+	filter := args[0].Value()
+	command := line.RawLine[args[0].End():]
 
 	command = strings.TrimSpace(command)
 
-	matchingClients, err := clients.Search(filter)
+	parallel, batch, err := fanOut(line)
 	if err != nil {
 		return err
 	}
 
-	if len(matchingClients) == 0 {
-		return fmt.Errorf("Unable to find match for '" + filter + "'\n")
+	stdin := terminal.InputOf(tty)
+	if line.IsSet("stdin") {
+		if stdin != nil {
+			return terminal.Errorf(terminal.Usage, "Use either --stdin or < file, not both")
+		}
+
+		if _, ok := tty.(*terminal.Terminal); ok {
+			return terminal.Errorf(terminal.Usage, "--stdin sends what is piped into ssh, at the console use < file")
+		}
+
+		if !(line.IsSet("y") || line.IsSet("q") || line.IsSet("raw")) {
+			return terminal.Errorf(terminal.Usage, "--stdin needs -y, the confirmation would be read from the input")
+		}
+
+		stdin = tty
+	}
+
+	if err := lockdown.Check(lockdown.Exec); err != nil {
+		return err
+	}
+
+	matchingClients, err := resolve(tty, filter)
+	if err != nil {
+		return err
 	}
 
 	if !(line.IsSet("q") || line.IsSet("raw")) {
@@ -69,52 +102,397 @@ func (e *exec) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 		}
 	}
 
+	command, err = vault.Resolve(console.User.ConnectionDetails, console.User.Role, command)
+	if err != nil {
+		return err
+	}
+
 	var c struct {
 		Cmd string
 	}
 	c.Cmd = command
 
-	commandByte := ssh.Marshal(&c)
+	ids := make([]string, 0, len(matchingClients))
+	for id := range matchingClients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	input, err := sharedInput(stdin, len(ids))
+	if err != nil {
+		return err
+	}
+
+	run := &fanOutRun{
+		tty:     tty,
+		quiet:   line.IsSet("q"),
+		labels:  !(line.IsSet("q") || line.IsSet("raw")),
+		by:      console.User.ConnectionDetails,
+		command: ssh.Marshal(&c),
+		input:   input,
+		clients: matchingClients,
+		job:     console.Job,
+		total:   len(ids),
+		abort:   make(chan struct{}),
+		over:    make(chan struct{}),
+	}
+
+	// On a terminal a key stops exec starting on any more clients, how the larger runs are kept from going too far wrong
+	if term, ok := tty.(*terminal.Terminal); ok && len(ids) > 1 {
+		term.EnableRaw()
+		defer term.DisableRaw()
+
+		run.tty = crlf{tty}
+		fmt.Fprintf(run.tty, "Running on %d clients, press q to stop starting on more\n", len(ids))
 
-	for id, client := range matchingClients {
+		go func() {
+			b := make([]byte, 1)
+			for {
+				if _, err := tty.Read(b); err != nil {
+					return
+				}
+
+				select {
+				case <-run.over:
+					return
+				default:
+				}
+
+				if b[0] == 'q' || b[0] == 0x1b || b[0] == 0x03 {
+					run.stop()
+					return
+				}
+			}
+		}()
+	}
+	defer close(run.over)
+
+	if batch > 0 {
+		for start := 0; start < len(ids); start += batch {
+			end := start + batch
+			if end > len(ids) {
+				end = len(ids)
+			}
 
-		if !(line.IsSet("q") || line.IsSet("raw")) {
-			fmt.Fprint(tty, "\n\n")
-			fmt.Fprintf(tty, "%s (%s) output:\n", id, client.User()+"@"+client.RemoteAddr().String())
+			failedBefore := run.failedCount()
+			run.many(ids[start:end], batch)
+
+			if run.failedCount() > failedBefore && end < len(ids) {
+				run.stop()
+				run.printf("Stopping, %d clients in the last batch failed\n", run.failedCount()-failedBefore)
+				break
+			}
 		}
+	} else {
+		run.many(ids, parallel)
+	}
+
+	return run.summary()
+}
+
+// sharedInput gives each client in turn a reader of stdin. One client reads it as it arrives, several are each sent a copy
+func sharedInput(stdin io.Reader, clients int) (func() io.Reader, error) {
+	if stdin == nil {
+		return nil, nil
+	}
+
+	if clients == 1 {
+		return func() io.Reader { return stdin }, nil
+	}
+
+	b, err := io.ReadAll(io.LimitReader(stdin, maxSharedInput+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > maxSharedInput {
+		return nil, terminal.Errorf(terminal.Usage, "The input is over %s, too much to send to several clients at once. Send it to one at a time", byteSize(maxSharedInput))
+	}
+
+	return func() io.Reader { return bytes.NewReader(b) }, nil
+}
+
+// fanOut reads how many clients exec runs on at once, the values of --parallel and --batch come before the filter
+func fanOut(line terminal.ParsedLine) (parallel, batch int, err error) {
+	parallel = 1
 
-		newChan, r, err := client.OpenChannel("session", nil)
-		if err != nil && !line.IsSet("q") {
-			fmt.Fprintf(tty, "Failed: %s\n", err)
+	for flag, to := range map[string]*int{"parallel": &parallel, "batch": &batch} {
+		if !line.IsSet(flag) {
 			continue
 		}
-		go ssh.DiscardRequests(r)
 
-		response, err := newChan.SendRequest("exec", true, commandByte)
-		if err != nil && !line.IsSet("q") {
-			fmt.Fprintf(tty, "Failed: %s\n", err)
-			continue
+		n, err := line.GetArgInt(flag)
+		if err != nil || n < 1 {
+			return 0, 0, terminal.Errorf(terminal.Usage, "--%s needs a number of clients, such as --%s 10", flag, flag)
 		}
+		*to = n
+	}
 
-		if !response && !line.IsSet("q") {
-			fmt.Fprintf(tty, "Failed: client refused\n")
-			continue
+	if line.IsSet("parallel") && line.IsSet("batch") {
+		return 0, 0, terminal.Errorf(terminal.Usage, "Use either --parallel or --batch, not both")
+	}
+
+	return parallel, batch, nil
+}
+
+// positional is the arguments of a line without the values given to flags, which the parser keeps among them
+func positional(line terminal.ParsedLine, flags ...string) []terminal.Argument {
+	values := map[int]bool{}
+	for _, flag := range flags {
+		if args, err := line.GetArgs(flag); err == nil && len(args) > 0 {
+			values[args[0].Start()] = true
+		}
+	}
+
+	var out []terminal.Argument
+	for _, arg := range line.Arguments {
+		if !values[arg.Start()] {
+			out = append(out, arg)
 		}
+	}
+	return out
+}
 
-		if line.IsSet("q") {
-			io.Copy(io.Discard, newChan)
-			continue
+// fanOutRun is an exec across several clients, keeping count of how it is going
+type fanOutRun struct {
+	tty           io.Writer
+	quiet, labels bool
+	by            string
+	command       []byte
+	// What each client is sent on stdin, nil if there is nothing to send
+	input   func() io.Reader
+	clients map[string]*ssh.ServerConn
+	job     *jobs.Output
+
+	lck    sync.Mutex
+	total  int
+	done   int
+	failed []string
+	err    error
+
+	abort     chan struct{}
+	abortOnce sync.Once
+	// Closed once the run is over, so the key reader stops acting on keys meant for the console
+	over chan struct{}
+}
+
+func (r *fanOutRun) stop() {
+	r.abortOnce.Do(func() { close(r.abort) })
+}
+
+func (r *fanOutRun) stopped() bool {
+	var job <-chan struct{}
+	if r.job != nil {
+		job = r.job.Stopped()
+	}
+
+	select {
+	case <-r.abort:
+		return true
+	case <-job:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *fanOutRun) failedCount() int {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	return len(r.failed)
+}
+
+func (r *fanOutRun) printf(format string, args ...interface{}) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	if !r.quiet {
+		fmt.Fprintf(r.tty, format, args...)
+	}
+}
+
+// many runs on ids, at most at a time at once. Output is shown as it arrives when running on one client at a time, otherwise
+// a client at a time as each finishes so it is never mixed together
+func (r *fanOutRun) many(ids []string, at int) {
+	var (
+		wait    sync.WaitGroup
+		running = make(chan struct{}, at)
+	)
+
+	for _, id := range ids {
+		running <- struct{}{}
+		if r.stopped() {
+			<-running
+			break
 		}
 
-		io.Copy(tty, newChan)
+		wait.Add(1)
+
+		go func(id string) {
+			defer func() {
+				<-running
+				wait.Done()
+			}()
+
+			var (
+				output bytes.Buffer
+				out    io.Writer = &output
+			)
+			if at == 1 {
+				out = lockedWriter{&r.lck, r.tty}
+			}
+
+			err := r.one(id, out)
+
+			r.lck.Lock()
+			defer r.lck.Unlock()
+
+			if !r.quiet {
+				r.tty.Write(output.Bytes())
+			}
+
+			r.done++
+			if err != nil {
+				r.failed = append(r.failed, id)
+				if !r.quiet {
+					fmt.Fprintf(r.tty, "Failed: %s\n", err)
+				}
+
+				if terminal.CategoryOf(err) == terminal.Permission {
+					r.err = err
+					r.stop()
+				}
+			}
+
+			if r.job != nil {
+				r.job.Progress(r.done, len(r.failed), r.total)
+			}
+
+			if r.total > 1 && r.labels {
+				fmt.Fprintf(r.tty, "[%d/%d done, %d failed]\n", r.done, r.total, len(r.failed))
+			}
+		}(id)
+	}
+
+	wait.Wait()
+}
+
+// one runs the command on a single client, writing what it outputs to out
+func (r *fanOutRun) one(id string, out io.Writer) error {
+	client := r.clients[id]
+
+	if r.labels {
+		fmt.Fprint(out, "\n\n")
+		fmt.Fprintf(out, "%s (%s) output:\n", id, client.User()+"@"+client.RemoteAddr().String())
+	}
+
+	if r.quiet {
+		out = io.Discard
+	}
+
+	var stdin io.Reader
+	if r.input != nil {
+		stdin = r.input()
+	}
+
+	return execOn(id, client, r.by, r.command, stdin, out)
+}
+
+// execOn runs a command, marshalled as an exec request, on one client for by, copying what it outputs to out. Anything from stdin
+// is sent to the command's input, which is closed once stdin is read to the end
+func execOn(id string, client *ssh.ServerConn, by string, command []byte, stdin io.Reader, out io.Writer) error {
+	if stdin != nil {
+		if err := clients.Require(id, "exec-stdin", "input to exec"); err != nil {
+			return err
+		}
+	}
+
+	newChan, requests, err := client.OpenChannel("session", nil)
+	if err != nil {
+		return err
+	}
+	go ssh.DiscardRequests(requests)
+
+	done, err := lockdown.Begin(lockdown.Exec, newChan)
+	if err != nil {
+		newChan.Close()
+		return terminal.Errorf(terminal.Permission, "%s", err)
+	}
+	defer done()
+
+	response, err := newChan.SendRequest("exec", true, command)
+	if err != nil {
+		newChan.Close()
+		return err
+	}
+
+	if !response {
 		newChan.Close()
+		return fmt.Errorf("client refused")
 	}
 
-	fmt.Fprint(tty, "\n")
+	clients.RecordSession(id, "exec", by)
+
+	if stdin != nil {
+		go func() {
+			io.Copy(newChan, stdin)
+			newChan.CloseWrite()
+		}()
+	}
 
+	io.Copy(out, newChan)
+	newChan.Close()
 	return nil
 }
 
+// summary says how the run went once it is over, failing if it stopped before running on every client
+func (r *fanOutRun) summary() error {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	if !r.quiet {
+		fmt.Fprint(r.tty, "\n")
+	}
+
+	if r.total > 1 && len(r.failed) > 0 && !r.quiet {
+		fmt.Fprintf(r.tty, "Failed on %d of %d clients: %s\n", len(r.failed), r.total, strings.Join(r.failed, ", "))
+	}
+
+	if r.done < r.total {
+		return terminal.Errorf(terminal.Failed, "Stopped after running on %d of %d clients", r.done, r.total)
+	}
+
+	return nil
+}
+
+// lockedWriter streams output while nothing else is written alongside it
+type lockedWriter struct {
+	lck *sync.Mutex
+	w   io.Writer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+	return l.w.Write(p)
+}
+
+// crlf adds the carriage returns a terminal in raw mode no longer adds itself
+type crlf struct {
+	io.Writer
+}
+
+func (c crlf) Write(p []byte) (int, error) {
+	if _, err := c.Writer.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func (e *exec) Expect(line terminal.ParsedLine) []string {
 	return []string{autocomplete.RemoteId}
 }
@@ -130,5 +508,11 @@ func (e *exec) Help(explain bool) string {
 		"\t-q\tQuiet, no output (will also remove confirmation prompt)",
 		"\t-y\tNo confirmation prompt",
 		"\t--raw\tDo not label output blocks with the client they came from",
+		"\t--parallel N\tRun on up to N clients at once, each client's output is shown as it finishes",
+		"\t--batch N\tRun on N clients at a time, waiting for each batch to finish and stopping if any client in it failed",
+		"\t--stdin\tSend what is piped into ssh to the command's input, needs -y",
+		"Options go before the filter. Press q, escape or ^C while it runs on several clients to stop it starting on more, in a job use jobs stop <id>",
+		"The command may reference vault secrets as vault:name, these are filled in just before it is sent",
+		"A file in the output directory given with < file is sent to the command's input, exec host sort < data.txt",
 	)
 }
@@ -0,0 +1,338 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/evidence"
+	"github.com/NHAS/reverse_ssh/internal/server/hostkey"
+	"github.com/NHAS/reverse_ssh/internal/server/persistence"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"golang.org/x/crypto/ssh"
+)
+
+const exportNamespace = "rssh-export"
+
+type export struct {
+}
+
+type exportManifest struct {
+	Created   time.Time
+	From      time.Time
+	To        time.Time
+	Client    string `json:",omitempty"`
+	ServerKey string
+	Files     []exportedFile
+}
+
+type exportedFile struct {
+	Name   string
+	Size   int
+	SHA256 string
+}
+
+type exportedClient struct {
+	ID          string
+	Hostname    string
+	Address     string
+	Version     string
+	Fingerprint string
+	Comment     string `json:",omitempty"`
+	Engagement  string `json:",omitempty"`
+}
+
+type transfer struct {
+	Path     string
+	Size     int64
+	SHA256   string
+	Modified time.Time
+}
+
+func (e *export) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", e.Help(false))
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can export evidence")
+	}
+
+	var from time.Time
+	to := time.Now()
+
+	if value, err := line.GetArgString("from"); err == nil {
+		if from, err = parseAgo(value); err != nil {
+			return err
+		}
+	}
+
+	if value, err := line.GetArgString("to"); err == nil {
+		if to, err = parseAgo(value); err != nil {
+			return err
+		}
+	}
+
+	if !to.After(from) {
+		return errors.New("--to must be after --from")
+	}
+
+	filter, _ := line.GetArgString("client")
+
+	signer, err := e.serverKey(console.DataDir)
+	if err != nil {
+		return err
+	}
+
+	matching := func(entry []byte) bool {
+		return filter == "" || bytes.Contains(entry, []byte(filter))
+	}
+
+	auditLines, err := evidence.LinesWithin(filepath.Join(console.DataDir, "audit.log"), from, to, evidence.AuditTimestamp)
+	if err != nil {
+		return fmt.Errorf("unable to read audit log: %s", err)
+	}
+
+	watchLines, err := evidence.LinesWithin(filepath.Join(console.DataDir, "watch.log"), from, to, evidence.WatchTimestamp)
+	if err != nil {
+		return fmt.Errorf("unable to read watch log: %s", err)
+	}
+
+	connected, err := e.clients(filter)
+	if err != nil {
+		return err
+	}
+
+	persisted, err := e.persistence(filter, from, to)
+	if err != nil {
+		return err
+	}
+
+	transfers, err := e.transfers(console.DataDir, from, to)
+	if err != nil {
+		return err
+	}
+
+	files := []evidence.File{
+		{Name: "audit.log", Content: filterLines(auditLines, matching)},
+		{Name: "watch.log", Content: filterLines(watchLines, matching)},
+		{Name: "clients.json", Content: connected},
+		{Name: "persistence.json", Content: persisted},
+		{Name: "transfers.json", Content: transfers},
+	}
+
+	manifest := exportManifest{
+		Created:   time.Now(),
+		From:      from,
+		To:        to,
+		Client:    filter,
+		ServerKey: internal.FingerprintSHA256Hex(signer.PublicKey()),
+	}
+	for _, f := range files {
+		sum := sha256.Sum256(f.Content)
+		manifest.Files = append(manifest.Files, exportedFile{Name: f.Name, Size: len(f.Content), SHA256: hex.EncodeToString(sum[:])})
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	exports := filepath.Join(console.DataDir, "exports")
+	if err := os.MkdirAll(exports, 0700); err != nil {
+		return err
+	}
+
+	path := filepath.Join(exports, "export-"+manifest.Created.Format("20060102-150405")+".tar.gz")
+	err = evidence.WriteArchive(path, append([]evidence.File{{Name: "manifest.json", Content: manifestBytes}}, files...))
+	if err != nil {
+		return fmt.Errorf("unable to write archive: %s", err)
+	}
+
+	archive, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	signature, err := evidence.Sign(signer, exportNamespace, archive)
+	if err != nil {
+		return fmt.Errorf("unable to sign archive: %s", err)
+	}
+
+	if err := os.WriteFile(path+".sig", signature, 0600); err != nil {
+		return err
+	}
+
+	audit.Log(console.User.ConnectionDetails, "export", path, fmt.Sprintf("from %s to %s client %q", from.Format(time.RFC3339), to.Format(time.RFC3339), filter))
+
+	fmt.Fprintf(tty, "Wrote %s\nSignature %s.sig, verify with:\n", path, path)
+	fmt.Fprintf(tty, "\techo \"rssh %s\" > allowed_signers\n", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))))
+	fmt.Fprintf(tty, "\tssh-keygen -Y verify -f allowed_signers -I rssh -n %s -s %s.sig < %s\n", exportNamespace, filepath.Base(path), filepath.Base(path))
+
+	return nil
+}
+
+// parseAgo is parseWhen looking backwards, since a leading - would be read as a flag
+func parseAgo(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return parseWhen(value)
+}
+
+func (e *export) serverKey(datadir string) (ssh.Signer, error) {
+	return loadServerKey(datadir)
+}
+
+// loadServerKey is the key the running server signs with, or the key file in datadir when there is no server
+func loadServerKey(datadir string) (ssh.Signer, error) {
+	if signer := hostkey.Current(); signer != nil {
+		return signer, nil
+	}
+
+	b, err := os.ReadFile(hostkey.Path(datadir))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read server key for signing: %s", err)
+	}
+
+	return ssh.ParsePrivateKey(b)
+}
+
+func filterLines(content []byte, keep func([]byte) bool) []byte {
+	var out []byte
+	for _, l := range bytes.SplitAfter(content, []byte("\n")) {
+		if len(l) > 0 && keep(l) {
+			out = append(out, l...)
+		}
+	}
+	return out
+}
+
+func (e *export) clients(filter string) ([]byte, error) {
+	found, err := clients.Search(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []exportedClient{}
+	for id, sc := range found {
+		out = append(out, exportedClient{
+			ID:          id,
+			Hostname:    clients.NormaliseHostname(sc.User()),
+			Address:     sc.RemoteAddr().String(),
+			Version:     string(sc.ClientVersion()),
+			Fingerprint: sc.Permissions.Extensions["pubkey-fp"],
+			Comment:     sc.Permissions.Extensions["comment"],
+			Engagement:  sc.Permissions.Extensions["engagement"],
+		})
+	}
+
+	return json.MarshalIndent(out, "", "    ")
+}
+
+func (e *export) persistence(filter string, from, to time.Time) ([]byte, error) {
+	records, err := persistence.All()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read persistence records: %s", err)
+	}
+
+	out := []persistence.Record{}
+	for _, r := range records {
+		if r.Installed.Before(from) || r.Installed.After(to) {
+			continue
+		}
+
+		if filter != "" && !strings.Contains(r.Hostname, filter) && !strings.Contains(r.Fingerprint, filter) {
+			continue
+		}
+
+		out = append(out, r)
+	}
+
+	return json.MarshalIndent(out, "", "    ")
+}
+
+// transfers lists what was in the downloads directory offered to clients during the time range
+func (e *export) transfers(datadir string, from, to time.Time) ([]byte, error) {
+	root := filepath.Join(datadir, "downloads")
+
+	out := []transfer{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if info.ModTime().Before(from) || info.ModTime().After(to) {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		out = append(out, transfer{Path: rel, Size: info.Size(), SHA256: hex.EncodeToString(h.Sum(nil)), Modified: info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list downloads: %s", err)
+	}
+
+	return json.MarshalIndent(out, "", "    ")
+}
+
+func (e *export) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (e *export) Help(explain bool) string {
+	if explain {
+		return "Package logs and client records for a time range into a signed archive"
+	}
+
+	return terminal.MakeHelpText(
+		"export [OPTIONS]",
+		"Writes a timestamped archive to datadir/exports with the audit and watch log slices, connected clients, persistence records and files offered to clients for the time range.",
+		"The archive has a manifest of sha256 hashes and is signed with the server key, the signature is written alongside as an ssh-keygen compatible .sig file.",
+		"\t--from\tStart of the time range, RFC3339, YYYY-MM-DD or how long ago such as 24h (default everything)",
+		"\t--to\tEnd of the time range, same formats as --from (default now)",
+		"\t--client\tOnly include log lines and records mentioning this client id, hostname or fingerprint",
+	)
+}
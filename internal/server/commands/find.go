@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type find struct {
+}
+
+func (f *find) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", f.Help(false))
+		return nil
+	}
+
+	if len(line.Arguments) == 0 {
+		return terminal.Errorf(terminal.Usage, "%s", f.Help(false))
+	}
+
+	terms, err := clients.ParseQuery(line.ArgumentsAsStrings())
+	if err != nil {
+		return err
+	}
+
+	connected, offline := clients.Find(terms, line.IsSet("all"))
+	if len(connected) == 0 && len(offline) == 0 {
+		return terminal.Errorf(terminal.NotFound, "No clients matched")
+	}
+
+	printClients(tty, connected, offline, line)
+	return nil
+}
+
+func (f *find) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (f *find) Help(explain bool) string {
+	if explain {
+		return "Find clients by their notes, hostname, address, key or version"
+	}
+
+	return terminal.MakeHelpText(append([]string{
+		"find [OPTIONS] <field=glob|field~text>...",
+		"Lists the clients every condition matches. = matches the whole field against a glob, ~ looks for text anywhere in it ignoring case.",
+		"Fields are " + strings.Join(clients.QueryFields, ", ") + ", e.g find note~\"domain controller\" hostname=dc*",
+		"\t--all\tAlso search clients that are not connected now",
+		"\t-t\tPrint all attributes in pretty table",
+	}, tableHelp...)...)
+}
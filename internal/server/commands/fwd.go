@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/forwards"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type fwd struct {
+}
+
+func (f *fwd) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", f.Help(false))
+		return nil
+	}
+
+	if line.IsSet("l") || line.IsSet("list") {
+		all, listeners := forwards.List(), forwards.Listeners()
+		if len(all) == 0 && len(listeners) == 0 {
+			fmt.Fprintln(tty, "No forwards")
+			return nil
+		}
+
+		for _, forward := range all {
+			users := "everyone"
+			if len(forward.Users) > 0 {
+				users = strings.Join(forward.Users, ", ")
+			}
+
+			destinations := "anywhere"
+			if len(forward.Destinations) > 0 {
+				var rules []string
+				for _, r := range forward.Destinations {
+					rules = append(rules, r.String())
+				}
+				destinations = strings.Join(rules, ", ")
+			}
+
+			fmt.Fprintf(tty, "%s via %s (%s, created by %s, %d connections, %d denied)\n", forward.Name, forward.Hostname, clientState(forward.Client, forward.Lost), forward.Creator, forward.Connections, forward.Denied)
+			fmt.Fprintf(tty, "\tusers: %s\n\tdestinations: %s\n", users, destinations)
+		}
+
+		if len(listeners) > 0 {
+			fmt.Fprintln(tty, "Listeners reopened when their client reconnects:")
+			for _, l := range listeners {
+				fmt.Fprintf(tty, "\t%s on %s (%s, created by %s)\n", l.RemoteForwardRequest.String(), l.Hostname, clientState(l.Client, l.Lost), l.Creator)
+			}
+		}
+		return nil
+	}
+
+	if line.IsSet("remove") {
+		name, err := line.GetArgString("remove")
+		if err != nil {
+			return err
+		}
+
+		for _, forward := range forwards.List() {
+			if forward.Name == name && forward.Creator != console.User.ConnectionDetails && console.User.Role != internal.RoleAdmin {
+				return terminal.Errorf(terminal.Permission, "Only admins can remove forwards made by someone else")
+			}
+		}
+
+		if err := forwards.Remove(name); err != nil {
+			return err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "forward-remove", name, "")
+		fmt.Fprintf(tty, "Removed %s\n", name)
+		return nil
+	}
+
+	if !line.IsSet("add") || len(line.Arguments) < 1 {
+		fmt.Fprintf(tty, "%s", f.Help(false))
+		return nil
+	}
+
+	name, err := line.GetArgString("add")
+	if err != nil {
+		return err
+	}
+
+	forward := forwards.Forward{
+		Name:     name,
+		Creator:  console.User.ConnectionDetails,
+		Compress: line.IsSet("compress"),
+	}
+
+	if line.IsSet("users") {
+		users, err := line.GetArgString("users")
+		if err != nil {
+			return err
+		}
+		forward.Users = strings.Split(users, ",")
+	}
+
+	if line.IsSet("to") {
+		rules, err := line.GetArgString("to")
+		if err != nil {
+			return err
+		}
+
+		for _, rule := range strings.Split(rules, ",") {
+			r, err := forwards.ParseRule(rule)
+			if err != nil {
+				return err
+			}
+			forward.Destinations = append(forward.Destinations, r)
+		}
+	}
+
+	target := line.Arguments[len(line.Arguments)-1].Value()
+
+	id, sc, err := resolveOne(tty, target)
+	if err != nil {
+		return err
+	}
+	forward.Client, forward.Identity = id, forwards.IdentityOf(sc)
+
+	if err := clients.Require(forward.Client, "forward", "forwarding"); err != nil {
+		return err
+	}
+
+	if err := forwards.Add(forward); err != nil {
+		return err
+	}
+
+	audit.Log(console.User.ConnectionDetails, "forward-add", name, fmt.Sprintf("through %s users %q destinations %q", forward.Client, forward.Users, forward.Destinations))
+
+	fmt.Fprintf(tty, "Added %s, use it with: ssh -L <local port>:%s%s<host>:<port> <this server>\n", name, name, forwards.Separator)
+
+	return nil
+}
+
+func clientState(id string, lost time.Time) string {
+	if id == "" {
+		return "disconnected for " + time.Since(lost).Round(time.Second).String()
+	}
+	return id
+}
+
+func (f *fwd) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (f *fwd) Help(explain bool) string {
+	if explain {
+		return "Manage named forwards with access lists"
+	}
+
+	return terminal.MakeHelpText(
+		"fwd [OPTIONS] <remote_id>",
+		"Creates a named forward through a client that only the listed users can use, and only to the listed destinations. Connections are checked by the server as they are made.",
+		"Forwards, and ports opened on clients with listen, follow the client's hostname and key so they come back by themselves when the client reconnects.",
+		"Use a forward by putting its name in front of the destination: ssh -L 8080:<name>/10.0.0.5:80 rssh.server",
+		"\t-l or --list\tList forwards and their access lists",
+		"\t--add\tName of the forward to add",
+		"\t--users\tComma separated ssh usernames or authorized_keys comments allowed to use it (default everyone)",
+		"\t--to\tComma separated destinations it may reach, host:port where the host is an ip, cidr or hostname glob and the port is a number, low-high or * (default anywhere)",
+		"\t--compress\tCompress connections between the server and client",
+		"\t--remove\tName of the forward to remove",
+	)
+}
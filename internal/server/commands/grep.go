@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// Longest line grep will read, exec output from a client can go a long way without a newline
+const maxGrepLine = 1 << 20
+
+type grep struct {
+}
+
+// ReadsInput as grep filters what is piped into it with |, or a file given with <
+func (g *grep) ReadsInput() {}
+
+func (g *grep) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", g.Help(false))
+		return nil
+	}
+
+	if len(line.Positional) != 1 {
+		return terminal.Errorf(terminal.Usage, "%s", g.Help(false))
+	}
+
+	input := terminal.InputOf(tty)
+	if input == nil {
+		return terminal.Errorf(terminal.Usage, "grep filters the output of another command, e.g ls | grep web, or a file with grep web < file")
+	}
+
+	expression := line.Positional[0].Value()
+	if line.IsSet("i") {
+		expression = "(?i)" + expression
+	}
+
+	re, err := regexp.Compile(expression)
+	if err != nil {
+		return terminal.Errorf(terminal.Usage, "%q is not a regular expression: %s", line.Positional[0].Value(), err)
+	}
+
+	invert := line.IsSet("v")
+
+	// Matches are only coloured when the operator will see them, so filtering into a file keeps the lines as they were
+	var highlight, reset []byte
+	if term := terminal.TerminalOf(tty); term != nil && !term.Plain() && !term.Dumb() && !invert {
+		highlight, reset = term.Escape.Red, term.Escape.Reset
+	}
+
+	sc := bufio.NewScanner(input)
+	sc.Buffer(make([]byte, 4096), maxGrepLine)
+	for sc.Scan() {
+		l := strings.TrimRight(sc.Text(), "\r")
+
+		if re.MatchString(l) == invert {
+			continue
+		}
+
+		if highlight != nil {
+			l = colourMatches(re, l, highlight, reset)
+		}
+
+		// Written a line at a time so matches show as they come in, on a long exec they would be no use at the end
+		if _, err := io.WriteString(tty, l+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return sc.Err()
+}
+
+// colourMatches wraps every match of re in l with the colour
+func colourMatches(re *regexp.Regexp, l string, colour, reset []byte) string {
+	var (
+		sb   strings.Builder
+		last int
+	)
+	for _, m := range re.FindAllStringIndex(l, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+
+		sb.WriteString(l[last:m[0]])
+		sb.Write(colour)
+		sb.WriteString(l[m[0]:m[1]])
+		sb.Write(reset)
+		last = m[1]
+	}
+	sb.WriteString(l[last:])
+
+	return sb.String()
+}
+
+func (g *grep) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (g *grep) Help(explain bool) string {
+	if explain {
+		return "Show only the lines of a command's output that match"
+	}
+
+	return g.Flags().Help()
+}
+
+func (g *grep) Flags() *terminal.FlagSet {
+	return &terminal.FlagSet{
+		Usage: []string{
+			"<command> | grep [OPTIONS] <regex>",
+			"grep [OPTIONS] <regex> < file",
+			"Matches each line against a regular expression, quote it if it has spaces. Matches are highlighted on a terminal",
+		},
+		Flags: []terminal.FlagSpec{
+			{Name: "v", Aliases: []string{"invert-match"}, Description: "Show the lines that don't match instead"},
+			{Name: "i", Aliases: []string{"ignore-case"}, Description: "Ignore case"},
+		},
+		Notes: []string{
+			"\tls --all | grep -v offline",
+			"\texec -y tag=prod uname -a | grep -i 'ubuntu|debian'",
+		},
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"sort"
 
+	"github.com/NHAS/reverse_ssh/internal/i18n"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
 	"github.com/NHAS/reverse_ssh/pkg/table"
@@ -14,42 +15,58 @@ type help struct {
 }
 
 func (h *help) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	lang := i18n.Of(tty)
+
 	if len(line.Arguments) < 1 {
 
-		t, err := table.NewTable("Commands", "Function", "Purpose")
+		t, err := table.NewTable(i18n.T(lang, "Commands"), i18n.T(lang, "Function"), i18n.T(lang, "Purpose"))
 		if err != nil {
 			return err
 		}
 
-		keys := []string{}
-		for funcName := range allCommands {
-			keys = append(keys, funcName)
-		}
-
+		keys := commandNames()
 		sort.Strings(keys)
 
 		for _, k := range keys {
-			hf := allCommands[k].Help
+			c, ok := helpFor(k)
+			if !ok {
+				// Unregistered since the names were listed
+				continue
+			}
+			hf := c.Help
 
-			err = t.AddValues(k, hf(true))
+			err = t.AddValues(k, i18n.Text(lang, hf(true)))
 			if err != nil {
 				return err
 			}
 		}
 
-		t.Fprint(tty)
-
-		return nil
+		return printTable(tty, line, &t)
 	}
 
-	l, ok := allCommands[line.Arguments[0].Value()]
+	l, ok := helpFor(line.Arguments[0].Value())
 	if !ok {
 		return fmt.Errorf("Command %s not found", line.Arguments[0].Value())
 	}
 
-	fmt.Fprintf(tty, "\ndescription:\n%s\n", l.Help(true))
+	// help bans add is the help for bans' add subcommand
+	path := line.Arguments[0].Value()
+	for _, arg := range line.Arguments[1:] {
+		t, ok := l.(terminal.Tree)
+		if !ok {
+			break
+		}
+
+		sub, ok := t.Subcommands()[arg.Value()]
+		if !ok {
+			return terminal.Errorf(terminal.NotFound, "%s has no subcommand %s", path, arg.Value())
+		}
+		l, path = sub, path+" "+arg.Value()
+	}
+
+	fmt.Fprintf(tty, "\n%s\n%s\n", i18n.T(lang, "description:"), i18n.Text(lang, l.Help(true)))
 
-	fmt.Fprintf(tty, "\nusage:\n%s\n", l.Help(false))
+	fmt.Fprintf(tty, "\n%s\n%s\n", i18n.T(lang, "usage:"), i18n.Text(lang, l.Help(false)))
 
 	return nil
 }
@@ -66,8 +83,8 @@ func (h *help) Help(explain bool) string {
 		return "Get help for commands, or display all commands"
 	}
 
-	return terminal.MakeHelpText(
-		"help",
-		"help <functions>",
-	)
+	return terminal.MakeHelpText(append([]string{
+		"help [OPTIONS]",
+		"help <functions> [subcommand]",
+	}, tableHelp...)...)
 }
@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type history struct {
+}
+
+func (h *history) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", h.Help(false))
+		return nil
+	}
+
+	if len(line.Arguments) != 1 {
+		return terminal.Errorf(terminal.Usage, "%s", h.Help(false))
+	}
+
+	r, since, err := clients.FindRecord(line.Arguments[0].Value())
+	if err != nil {
+		return err
+	}
+
+	total := r.ConnectedFor
+	state := "offline, last seen " + lastSeen(r)
+	if !since.IsZero() {
+		total += time.Since(since)
+		state = fmt.Sprintf("connected since %s", since.Format(time.RFC3339))
+	}
+
+	fmt.Fprintf(tty, "%s %s %s\n", r.ID, r.Fingerprint, r.Hostname)
+	fmt.Fprintf(tty, "\t%s, from %s\n", state, r.LastAddress)
+	fmt.Fprintf(tty, "\tfirst seen %s, connected %d times for %s in total\n", r.FirstSeen.Format(time.RFC3339), r.Connections, total.Round(time.Second))
+
+	if r.Clones > 0 {
+		fmt.Fprintf(tty, "\tconnected %d times while already connected\n", r.Clones)
+	}
+
+	if len(r.Previous) > 0 {
+		fmt.Fprintf(tty, "\tpreviously %s\n", strings.Join(r.Previous, ", "))
+	}
+
+	fmt.Fprintf(tty, "\t%d sessions\n", r.Sessions)
+	for _, s := range r.Recent {
+		fmt.Fprintf(tty, "\t\t%s %s by %s\n", s.At.Format(time.RFC3339), s.Kind, s.By)
+	}
+
+	return nil
+}
+
+func (h *history) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (h *history) Help(explain bool) string {
+	if explain {
+		return "Show what is known about a client, whether or not it is connected"
+	}
+
+	return terminal.MakeHelpText(
+		"history <client>",
+		"Client is an id, or a hostname, key fingerprint or address only one client has had.",
+		"Shows when the client was first and last seen, how long it has been connected in total, and the most recent shells, jumps and commands run on it.",
+	)
+}
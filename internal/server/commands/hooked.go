@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal/server/middleware"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// hookedCommand runs the middleware command hooks around every run of a command
+type hookedCommand struct {
+	terminal.Command
+
+	name string
+}
+
+func (h *hookedCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if !middleware.Enabled() {
+		return h.Command.Run(tty, line)
+	}
+
+	c := &middleware.Command{Session: middleware.Of(consoleOf(tty).User), Name: h.name, Line: line.RawLine, Annotations: map[string]string{}}
+	if err := middleware.BeforeCommand(c); err != nil {
+		return err
+	}
+
+	err := h.Command.Run(tty, line)
+	middleware.AfterCommand(c, err)
+
+	return err
+}
+
+func (h *hookedCommand) Unwrap() terminal.Command {
+	return h.Command
+}
+
+func hookCommands(m map[string]terminal.Command) map[string]terminal.Command {
+	hooked := map[string]terminal.Command{}
+	for name, command := range m {
+		hooked[name] = &hookedCommand{Command: command, name: name}
+	}
+	return hooked
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type info struct {
+}
+
+func (i *info) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") || len(line.Arguments) != 1 {
+		fmt.Fprintf(tty, "%s", i.Help(false))
+		return nil
+	}
+
+	id, sc, err := resolveOne(tty, line.Arguments[0].Value())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(tty, "ID: %s\n", id)
+	fmt.Fprintf(tty, "Hostname: %s\n", clients.NormaliseHostname(sc.User()))
+	fmt.Fprintf(tty, "Address: %s\n", sc.RemoteAddr().String())
+	fmt.Fprintf(tty, "Version: %s\n", sc.ClientVersion())
+	fmt.Fprintf(tty, "Public key: %s\n", sc.Permissions.Extensions["pubkey-fp"])
+	if sc.Permissions.Extensions["comment"] != "" {
+		fmt.Fprintf(tty, "Comment: %s\n", sc.Permissions.Extensions["comment"])
+	}
+	fmt.Fprintf(tty, "Aliases: %s\n", strings.Join(clients.GetAliases(id), ", "))
+
+	if r, _, err := clients.FindRecord(id); err == nil {
+		if len(r.Tags) > 0 {
+			fmt.Fprintf(tty, "Tags: %s\n", strings.Join(r.Tags, ", "))
+		}
+
+		if len(r.Notes) > 0 {
+			fmt.Fprintf(tty, "Notes:\n")
+			printNotes(tty, r.Notes, "\t")
+		}
+	}
+
+	memoryOnly := "unknown (client does not support it)"
+	if enabled, err := queryMemoryOnly(sc); err == nil {
+		memoryOnly = fmt.Sprintf("%t", enabled)
+	}
+	fmt.Fprintf(tty, "Memory only: %s\n", memoryOnly)
+
+	if protocol, ok := clients.GetProtocol(id); ok {
+		switch {
+		case protocol == 0:
+			fmt.Fprintf(tty, "Protocol: 0 (client does not report its capabilities, these are assumed)\n")
+		case protocol < internal.ProtocolVersion:
+			fmt.Fprintf(tty, "Protocol: %d (older than the server's %d)\n", protocol, internal.ProtocolVersion)
+		default:
+			fmt.Fprintf(tty, "Protocol: %d\n", protocol)
+		}
+	}
+
+	capabilities, ok := clients.GetCapabilities(id)
+	if !ok {
+		fmt.Fprintf(tty, "Capabilities: unknown (client has not been asked yet)\n")
+		return nil
+	}
+	fmt.Fprintf(tty, "Capabilities: %s\n", strings.Join(capabilities, ", "))
+
+	return nil
+}
+
+func (i *info) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (i *info) Help(explain bool) string {
+	if explain {
+		return "Show details about a connected client"
+	}
+
+	return terminal.MakeHelpText(
+		"info <remote_id>",
+		"Shows the identifiers, version and capabilities the client reported when it connected",
+	)
+}
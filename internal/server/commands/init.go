@@ -1,44 +1,316 @@
 package commands
 
 import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/jobs"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"github.com/NHAS/reverse_ssh/pkg/trie"
 )
 
-// This is used for help, so we can generate the nice table
+// This is used for help, so we can generate the nice table, and is what every console runs
 // I would prefer if we could do some sort of autoregistration process for these
 var allCommands = map[string]terminal.Command{
-	"ls":      &list{},
-	"help":    &help{},
-	"kill":    &kill{},
-	"connect": &connect{},
-	"exit":    &exit{},
-	"link":    &link{},
-	"exec":    &exec{},
-	"who":     &who{},
-	"watch":   &watch{},
-	"listen":  &listen{},
-	"webhook": &webhook{},
-	"version": &version{},
-}
-
-func CreateCommands(user *internal.User, log logger.Logger, datadir string) map[string]terminal.Command {
-
-	var o = map[string]terminal.Command{
-		"ls":      &list{},
-		"help":    &help{},
-		"kill":    Kill(log),
-		"connect": Connect(user, log),
-		"exit":    &exit{},
-		"link":    &link{},
-		"exec":    &exec{},
-		"who":     &who{},
-		"watch":   Watch(datadir),
-		"listen":  Listen(log),
-		"webhook": &webhook{},
-		"version": &version{},
-	}
-
-	return o
+	"ls":         &list{},
+	"help":       &help{},
+	"kill":       &kill{},
+	"connect":    &connect{},
+	"exit":       &exit{},
+	"link":       &link{},
+	"exec":       &exec{},
+	"who":        &who{},
+	"watch":      &watch{},
+	"listen":     &listen{},
+	"webhook":    &webhook{},
+	"version":    &version{},
+	"info":       &info{},
+	"memoryonly": &memoryOnly{},
+	"persist":    &persist{},
+	"scan":       &scan{},
+	"neighbors":  &neighbours{},
+	"routes":     &routes{},
+	"map":        &networkMap{},
+	"vault":      &vaultCommand{},
+	"approvals":  &approvalsCommand{},
+	"engagement": &engagement{},
+	"export":     &export{},
+	"audit":      &auditCommand{},
+	"ciphers":    &ciphers{},
+	"tokens":     &joinTokens{},
+	"rotate":     &rotate{},
+	"bans":       &bansCommand{},
+	"set":        &set{},
+	"unset":      &unset{},
+	"if":         &ifCommand{},
+	"foreach":    &foreach{},
+
+	"configure-binary": &configureBinary{},
+	"tcp":              &tcp{},
+	"vpn":              &vpnCommand{},
+	"fwd":              &fwd{},
+	"compress":         &compression{},
+	"canary":           &canaries{},
+	"admin":            &adminCommand{},
+	"clones":           &clones{},
+	"history":          &history{},
+	"note":             &note{},
+	"find":             &find{},
+	"tag":              &tag{},
+	"inventory":        &inventory{},
+	"accessible":       &accessible{},
+	"lang":             &lang{},
+	"queue":            &queueCommand{},
+	"results":          &results{},
+	"jobs":             &jobsCommand{},
+	"sessions":         &sessionsCommand{},
+	"split":            &split{},
+	"notifyme":         &notifyMe{},
+	"macro":            &macroCommand{},
+	"diff":             &diffCommand{},
+	"sync":             &syncCommand{},
+	"tools":            &toolsCommand{},
+	"deploy":           &deploy{},
+	"undeploy":         &undeploy{},
+	"encode":           &encode{},
+	"decode":           &decode{},
+	"grep":             &grep{},
+	"drift":            &drift{},
+	"replay":           &replay{},
+	"time":             &timeCommand{},
+}
+
+// Every console shares the same commands, which find who they are running for through the output they are given. Duress
+// logins are given decoys in place of anything touching clients
+var (
+	consoleCommands       = wrap(allCommands, false)
+	duressConsoleCommands = wrap(allCommands, true)
+)
+
+// wrap decorates commands with approvals or duress decoys, then auditing, hooks, timing, tracing and recovering from panics
+func wrap(m map[string]terminal.Command, duress bool) map[string]terminal.Command {
+	if duress {
+		m = duressCommands(m)
+	} else {
+		m = gateCommands(m)
+	}
+
+	return recoverCommands(traceCommands(timeCommands(hookCommands(auditCommands(m)))))
+}
+
+var (
+	registryLock sync.RWMutex
+
+	// Commands added with Register, for normal and duress logins
+	registered = map[string]registration{}
+
+	// Names of every command, for tab completion
+	names = trie.NewTrie(commandNames()...)
+)
+
+type registration struct {
+	command        *perConsole
+	normal, duress terminal.Command
+}
+
+// perConsole makes a registered command for each console the first time it is run there, so it can keep state for the session
+// as commands written against pkg/command expect to
+type perConsole struct {
+	new func(user *internal.User) terminal.Command
+
+	// Only used for help and completion, which dont depend on who the command is for
+	help terminal.Command
+}
+
+func (p *perConsole) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	return consoleOf(tty).made(p).Run(tty, line)
+}
+
+func (p *perConsole) Expect(line terminal.ParsedLine) []string {
+	return p.help.Expect(line)
+}
+
+func (p *perConsole) Help(explain bool) string {
+	return p.help.Help(explain)
+}
+
+// Console is who a console runs commands for, set as the Context of the console's shell
+type Console struct {
+	User    *internal.User
+	Log     logger.Logger
+	DataDir string
+
+	lock      sync.Mutex
+	instances map[*perConsole]terminal.Command
+	// The ids of the clients last listed, in the order they were numbered for #n
+	handles []string
+	// How long the last command run took, for {took} in the prompt
+	took time.Duration
+
+	// Set for the console of a background job, commands report how far they have got and check whether to stop through it
+	Job *jobs.Output
+
+	// The macro being recorded with macro record, lines typed go to it until macro stop
+	recording *recording
+}
+
+func NewConsole(user *internal.User, log logger.Logger, datadir string) *Console {
+	return &Console{User: user, Log: log, DataDir: datadir, instances: map[*perConsole]terminal.Command{}}
+}
+
+// forJob makes the console a background job started from c runs with, for the same user and with the same #n handles
+func (c *Console) forJob(job *jobs.Output) *Console {
+	j := NewConsole(c.User, c.Log, c.DataDir)
+	j.Job = job
+
+	c.lock.Lock()
+	j.handles = c.handles
+	c.lock.Unlock()
+
+	return j
+}
+
+func (c *Console) made(p *perConsole) terminal.Command {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	command, ok := c.instances[p]
+	if !ok {
+		command = p.new(c.User)
+		c.instances[p] = command
+	}
+	return command
+}
+
+// consoleOf finds the console a command is running for from the output it was given
+func consoleOf(tty io.ReadWriter) *Console {
+	if shell := terminal.ShellOf(tty); shell != nil {
+		if c, ok := shell.Context.(*Console); ok {
+			return c
+		}
+	}
+
+	// Commands only run from shells with a Console, this stops anything else from taking the server down with it
+	return NewConsole(&internal.User{}, logger.NewLog("console"), "")
+}
+
+type commandSet struct {
+	duress bool
+}
+
+func (s commandSet) Lookup(name string) (terminal.Command, bool) {
+	m := consoleCommands
+	if s.duress {
+		m = duressConsoleCommands
+	}
+
+	if c, ok := m[name]; ok {
+		return c, true
+	}
+
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	r, ok := registered[name]
+	if !ok {
+		return nil, false
+	}
+
+	if s.duress {
+		return r.duress, true
+	}
+	return r.normal, true
+}
+
+// For gives the commands of user's consoles, which also run any commands registered or unregistered while they are open
+func For(user *internal.User) terminal.Commands {
+	return commandSet{duress: user.Duress}
+}
+
+// Names is the names of every command, kept up to date as commands are registered and unregistered
+func Names() *trie.Trie {
+	return names
+}
+
+// Register adds a command to every console, those already open included, making it with new for each console the first time
+// it is run there
+func Register(name string, new func(user *internal.User) terminal.Command) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n\"'`$>") || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "#") {
+		return fmt.Errorf("%q cannot be used as a command name", name)
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := allCommands[name]; ok {
+		return fmt.Errorf("there is already a %s command", name)
+	}
+
+	if _, ok := registered[name]; ok {
+		return fmt.Errorf("there is already a %s command", name)
+	}
+
+	command := &perConsole{new: new, help: new(&internal.User{})}
+	registered[name] = registration{
+		command: command,
+		normal:  wrap(map[string]terminal.Command{name: command}, false)[name],
+		duress:  wrap(map[string]terminal.Command{name: command}, true)[name],
+	}
+	names.Add(name)
+
+	return nil
+}
+
+// Unregister takes a command added with Register away from every console, lines already running it carry on until they finish.
+// The server's own commands cannot be taken away
+func Unregister(name string) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registered[name]; !ok {
+		return fmt.Errorf("%s is not a registered command", name)
+	}
+
+	delete(registered, name)
+	names.Remove(name)
+
+	return nil
+}
+
+// helpFor finds the command help describes for name, built in or registered
+func helpFor(name string) (terminal.Command, bool) {
+	if c, ok := allCommands[name]; ok {
+		return c, true
+	}
+
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	r, ok := registered[name]
+	if !ok {
+		return nil, false
+	}
+	return r.command, true
+}
+
+// commandNames lists every command, built in or registered
+func commandNames() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	out := []string{}
+	for name := range allCommands {
+		out = append(out, name)
+	}
+
+	for name := range registered {
+		out = append(out, name)
+	}
+
+	return out
 }
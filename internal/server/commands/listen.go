@@ -9,11 +9,11 @@ import (
 
 	"github.com/NHAS/reverse_ssh/internal"
 	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/forwards"
 	"github.com/NHAS/reverse_ssh/internal/server/multiplexer"
 	"github.com/NHAS/reverse_ssh/internal/server/observers"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
-	"github.com/NHAS/reverse_ssh/pkg/logger"
 	"github.com/NHAS/reverse_ssh/pkg/observer"
 	"golang.org/x/crypto/ssh"
 )
@@ -26,7 +26,6 @@ type autostartEntry struct {
 var autoStartServerPort = map[internal.RemoteForwardRequest]autostartEntry{}
 
 type listen struct {
-	log logger.Logger
 }
 
 func (l *listen) server(tty io.ReadWriter, line terminal.ParsedLine, onAddrs, offAddrs []string) error {
@@ -64,6 +63,7 @@ func (l *listen) server(tty io.ReadWriter, line terminal.ParsedLine, onAddrs, of
 }
 
 func (l *listen) client(tty io.ReadWriter, line terminal.ParsedLine, onAddrs, offAddrs []string) error {
+	console := consoleOf(tty)
 
 	auto := line.IsSet("auto")
 	if line.IsSet("l") && auto {
@@ -87,7 +87,7 @@ func (l *listen) client(tty io.ReadWriter, line terminal.ParsedLine, onAddrs, of
 	}
 
 	if len(foundClients) == 0 && !auto {
-		return fmt.Errorf("No clients matched '%s'", specifier)
+		return terminal.Errorf(terminal.NotFound, "No clients matched '%s'", specifier)
 	}
 
 	if line.IsSet("l") {
@@ -155,10 +155,18 @@ func (l *listen) client(tty io.ReadWriter, line terminal.ParsedLine, onAddrs, of
 			if err != nil {
 				applied--
 				fmt.Fprintln(tty, "error starting port on: ", c, ": ", err)
+				continue
+			}
+
+			// Auto started ports are already opened again by their observer whenever a client connects
+			if !auto {
+				if err := forwards.AddListener(console.User.ConnectionDetails, c, sc, r); err != nil {
+					fmt.Fprintln(tty, "started port on: ", c, " but could not record it to reopen on reconnect: ", err)
+				}
 			}
 		}
 
-		fmt.Fprintf(tty, "started %s:%d on %d clients (total %d)\n", r.BindAddr, r.BindPort, applied, len(foundClients))
+		fmt.Fprintf(tty, "started %s on %d clients (total %d)\n", r.String(), applied, len(foundClients))
 
 		if auto {
 			var entry autostartEntry
@@ -170,6 +178,11 @@ func (l *listen) client(tty io.ReadWriter, line terminal.ParsedLine, onAddrs, of
 					return
 				}
 
+				if !clients.HasCapability(c.ID, "forward") {
+					console.Log.Warning("not auto starting server port on %s, client was built without forwarding", c.ID)
+					return
+				}
+
 				client, err := clients.Get(c.ID)
 				if err != nil {
 					return
@@ -177,12 +190,12 @@ func (l *listen) client(tty io.ReadWriter, line terminal.ParsedLine, onAddrs, of
 
 				result, message, err := client.SendRequest("tcpip-forward", true, b)
 				if !result {
-					l.log.Warning("failed to start server tcpip-forward on client: %s: %s", c.ID, message)
+					console.Log.Warning("failed to start server tcpip-forward on client: %s: %s", c.ID, message)
 					return
 				}
 
 				if err != nil {
-					l.log.Warning("error auto starting port on: %s: %s", c.ID, err)
+					console.Log.Warning("error auto starting port on: %s: %s", c.ID, err)
 					return
 				}
 
@@ -230,10 +243,15 @@ func (l *listen) client(tty io.ReadWriter, line terminal.ParsedLine, onAddrs, of
 			if err != nil {
 				applied--
 				fmt.Fprintln(tty, "error stop port on: ", c, ": ", err)
+				continue
+			}
+
+			if err := forwards.RemoveListener(sc, r); err != nil {
+				fmt.Fprintln(tty, "stopped port on: ", c, " but it may be reopened on reconnect: ", err)
 			}
 		}
 
-		fmt.Fprintf(tty, "stopped %s:%d on %d clients\n", r.BindAddr, r.BindPort, applied)
+		fmt.Fprintf(tty, "stopped %s on %d clients\n", r.String(), applied)
 
 		if auto {
 			if _, ok := autoStartServerPort[r]; ok {
@@ -312,9 +330,3 @@ func (w *listen) Help(explain bool) string {
 		"\t-l\tList all enabled addresses",
 	)
 }
-
-func Listen(log logger.Logger) *listen {
-	return &listen{
-		log: log,
-	}
-}
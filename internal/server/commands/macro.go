@@ -0,0 +1,303 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/macros"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/pkg/table"
+)
+
+// The variable a macro refers to the client it is played against with
+const macroTarget = "target"
+
+type recording struct {
+	name  string
+	lines []string
+
+	// What $target was before recording set it, so it can be put back
+	previous string
+	wasSet   bool
+}
+
+// Typed is given every line the operator types at the console, which is kept while they are recording a macro. macro commands
+// themselves are left out
+func (c *Console) Typed(line string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.recording == nil {
+		return
+	}
+
+	parsed := terminal.ParseLine(line, 0)
+	if parsed.Command == nil || strings.HasPrefix(parsed.Command.Value(), "#") || parsed.Command.Value() == "macro" {
+		return
+	}
+
+	c.recording.lines = append(c.recording.lines, line)
+}
+
+type macroCommand struct {
+}
+
+// Compound so the lines of a macro are run with variables expanded as they are played, not when macro was typed
+func (mc *macroCommand) Compound() {}
+
+func (mc *macroCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") && len(line.Arguments) == 0 {
+		fmt.Fprintf(tty, "%s", mc.Help(false))
+		return nil
+	}
+
+	shell := terminal.ShellOf(tty)
+	if shell == nil {
+		return errNoShell
+	}
+
+	words, rest, err := shell.Words(line, 1)
+	if err != nil {
+		return terminal.Errorf(terminal.Usage, "%s", mc.Help(false))
+	}
+
+	args := strings.Fields(shell.Expand(rest))
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	operator := console.User.Operator()
+	admin := console.User.Role == internal.RoleAdmin
+
+	switch words[0] {
+	case "record":
+		if name == "" {
+			return terminal.Errorf(terminal.Usage, "%s", mc.Help(false))
+		}
+
+		return mc.record(tty, console, shell, name, args[1:])
+
+	case "stop", "cancel":
+		console.lock.Lock()
+		r := console.recording
+		console.recording = nil
+		console.lock.Unlock()
+
+		if r == nil {
+			return fmt.Errorf("No macro is being recorded")
+		}
+
+		if r.wasSet {
+			shell.SetVariable(macroTarget, r.previous)
+		} else {
+			shell.UnsetVariable(macroTarget)
+		}
+
+		if words[0] == "cancel" {
+			fmt.Fprintf(tty, "Stopped recording, macro %s was not saved\n", r.name)
+			return nil
+		}
+
+		err := macros.Save(macros.Macro{
+			Name:     r.name,
+			Owner:    operator,
+			By:       console.User.ConnectionDetails,
+			Lines:    r.lines,
+			Recorded: time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to save macro %s: %s", r.name, err)
+		}
+
+		fmt.Fprintf(tty, "Saved macro %s, %d commands. Play it with: macro play %s <clients>\n", r.name, len(r.lines), r.name)
+		return nil
+
+	case "ls":
+		found := macros.List(operator, admin)
+		if len(found) == 0 {
+			fmt.Fprintf(tty, "No macros, record one with: macro record <name> <client>\n")
+			return nil
+		}
+
+		t, _ := table.NewTable("Macros", "Name", "Commands", "Recorded By", "Recorded", "Shared")
+		for _, m := range found {
+			shared := "no"
+			if m.Shared {
+				shared = "yes"
+			}
+			t.AddValues(m.Name, strconv.Itoa(len(m.Lines)), m.By, m.Recorded.Format(time.RFC3339), shared)
+		}
+		return printTable(tty, line, &t)
+
+	case "show":
+		m, err := macros.Get(operator, name)
+		if err != nil {
+			return terminal.Errorf(terminal.NotFound, "%s: %s", name, err)
+		}
+
+		for _, l := range m.Lines {
+			fmt.Fprintf(tty, "%s\n", l)
+		}
+		return nil
+
+	case "play":
+		m, err := macros.Get(operator, name)
+		if err != nil {
+			return terminal.Errorf(terminal.NotFound, "%s: %s", name, err)
+		}
+
+		return mc.play(tty, shell, m, strings.Join(args[1:], " "))
+
+	case "share", "unshare":
+		if err := macros.Share(operator, name, words[0] == "share", admin); err != nil {
+			return terminal.Errorf(terminal.Permission, "%s: %s", name, err)
+		}
+
+		if words[0] == "share" {
+			fmt.Fprintf(tty, "Every operator can now play %s\n", name)
+		} else {
+			fmt.Fprintf(tty, "Only you can play %s now\n", name)
+		}
+		return nil
+
+	case "rm":
+		if err := macros.Delete(operator, name, admin); err != nil {
+			return terminal.Errorf(terminal.Permission, "%s: %s", name, err)
+		}
+
+		fmt.Fprintf(tty, "Removed %s\n", name)
+		return nil
+	}
+
+	return terminal.Errorf(terminal.Usage, "%s", mc.Help(false))
+}
+
+func (mc *macroCommand) record(tty io.ReadWriter, console *Console, shell *terminal.Shell, name string, target []string) error {
+	if _, ok := tty.(*terminal.Terminal); !ok {
+		return fmt.Errorf("Macros can only be recorded from an interactive console")
+	}
+
+	console.lock.Lock()
+	current := console.recording
+	console.lock.Unlock()
+
+	if current != nil {
+		return fmt.Errorf("Already recording macro %s, finish it with macro stop first", current.name)
+	}
+
+	r := &recording{name: name}
+	r.previous, r.wasSet = shell.Variable(macroTarget)
+
+	if len(target) > 0 {
+		id, _, err := resolveOne(tty, strings.Join(target, " "))
+		if err != nil {
+			return err
+		}
+
+		if err := shell.SetVariable(macroTarget, id); err != nil {
+			return err
+		}
+	}
+
+	console.lock.Lock()
+	console.recording = r
+	console.lock.Unlock()
+
+	fmt.Fprintf(tty, "Recording macro %s. Refer to the client it is played against as $%s, then finish with macro stop or macro cancel\n", name, macroTarget)
+	return nil
+}
+
+// uses reports whether any line of the macro refers to $target
+func uses(m macros.Macro) bool {
+	for _, l := range m.Lines {
+		if strings.Contains(l, "$"+macroTarget) || strings.Contains(l, "${"+macroTarget+"}") {
+			return true
+		}
+	}
+	return false
+}
+
+// play runs the lines of a macro in order, once for each client matching filter with $target set to it. A client stops at the
+// first line that fails, as a script would, and the rest carry on
+func (mc *macroCommand) play(tty io.ReadWriter, shell *terminal.Shell, m macros.Macro, filter string) error {
+	run := func() error {
+		for _, l := range m.Lines {
+			if err := shell.Execute(tty, l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if filter == "" {
+		if uses(m) {
+			return terminal.Errorf(terminal.Usage, "Macro %s is played against clients: macro play %s <clients>", m.Name, m.Name)
+		}
+		return run()
+	}
+
+	found, err := resolve(tty, filter)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	previous, wasSet := shell.Variable(macroTarget)
+	defer func() {
+		if wasSet {
+			shell.SetVariable(macroTarget, previous)
+		} else {
+			shell.UnsetVariable(macroTarget)
+		}
+	}()
+
+	for _, id := range ids {
+		if err := shell.SetVariable(macroTarget, id); err != nil {
+			return err
+		}
+
+		err := run()
+		if err == io.EOF {
+			return err
+		}
+
+		if err != nil {
+			fmt.Fprintf(tty, "%s: %s\n", id, err)
+		}
+	}
+
+	return nil
+}
+
+func (mc *macroCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (mc *macroCommand) Help(explain bool) string {
+	if explain {
+		return "Record console commands and play them against other clients"
+	}
+
+	return terminal.MakeHelpText(append([]string{
+		"macro record <name> [client]",
+		"macro stop|cancel",
+		"macro play <name> [clients]",
+		"macro ls|show|share|unshare|rm [name]",
+		"While recording, every command typed is kept, refer to the client with $target, which is set to the client given to record. Playing runs the commands again with $target set to each matching client. Shared macros can be played by every operator",
+		"\tmacro record triage web01",
+		"\tmacro play triage tag=prod",
+	}, tableHelp...)...)
+}
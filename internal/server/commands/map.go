@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/netmap"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type networkMap struct {
+}
+
+func (m *networkMap) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", m.Help(false))
+		return nil
+	}
+
+	// Without a target every client is mapped
+	foundClients, err := clients.Search("")
+	if len(line.Arguments) > 0 {
+		foundClients, err = resolve(tty, line.Arguments[len(line.Arguments)-1].Value())
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(foundClients) == 0 {
+		return terminal.Errorf(terminal.NotFound, "No RSSH clients connected")
+	}
+
+	ids := []string{}
+	for id := range foundClients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var (
+		networks []netmap.ClientNetwork
+		failures = map[string]string{}
+	)
+	for _, id := range ids {
+		sc := foundClients[id]
+
+		cn := netmap.ClientNetwork{ID: id, Hostname: clients.NormaliseHostname(sc.User())}
+
+		// Each table is optional, a client that can only tell us about its interfaces is still worth drawing
+		var errs []string
+		if cn.Interfaces, err = queryInterfaces(sc); err != nil {
+			errs = append(errs, "interfaces: "+err.Error())
+		}
+		if cn.Routes, err = queryRoutes(sc); err != nil {
+			errs = append(errs, "routes: "+err.Error())
+		}
+		if cn.Neighbours, err = queryNeighbours(sc); err != nil {
+			errs = append(errs, "neighbours: "+err.Error())
+		}
+
+		if len(errs) > 0 {
+			failures[id] = strings.Join(errs, ", ")
+		}
+
+		networks = append(networks, cn)
+	}
+
+	g := netmap.Build(networks)
+
+	switch {
+	case line.IsSet("json"):
+		b, err := json.MarshalIndent(struct {
+			netmap.Graph
+			Errors map[string]string `json:",omitempty"`
+		}{g, failures}, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tty, "%s\n", b)
+
+	case line.IsSet("dot"):
+		for _, id := range ids {
+			if f, ok := failures[id]; ok {
+				fmt.Fprintf(tty, "// %s: %s\n", id, f)
+			}
+		}
+		fmt.Fprint(tty, g.DOT())
+
+	default:
+		labels := map[string]string{}
+		for _, n := range g.Nodes {
+			labels[n.ID] = n.Label
+		}
+
+		for _, id := range ids {
+			fmt.Fprintf(tty, "%s (%s)\n", id, labels["client:"+id])
+			for _, e := range g.Edges {
+				if e.From != "client:"+id {
+					continue
+				}
+
+				if e.Kind == netmap.Routed {
+					fmt.Fprintf(tty, "\t%s via %s\n", labels[e.To], e.Via)
+					continue
+				}
+				fmt.Fprintf(tty, "\t%s on %s\n", labels[e.To], e.Via)
+			}
+
+			if f, ok := failures[id]; ok {
+				fmt.Fprintf(tty, "\tincomplete, %s\n", f)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *networkMap) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (m *networkMap) Help(explain bool) string {
+	if explain {
+		return "Show which networks each client can reach"
+	}
+
+	return terminal.MakeHelpText(
+		"map [OPTIONS] [filter]",
+		"Collects interfaces, routes and neighbours from every client (or those matching filter) and merges them into a graph of reachable subnets.",
+		"Default and host routes, loopback and link local networks are left out.",
+		"\t--dot\tPrint as a graphviz digraph",
+		"\t--json\tPrint as json nodes and edges",
+	)
+}
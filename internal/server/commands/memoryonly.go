@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"golang.org/x/crypto/ssh"
+)
+
+type memoryOnly struct {
+}
+
+type memoryOnlyState struct {
+	Enabled bool
+}
+
+func queryMemoryOnly(sc ssh.Conn) (bool, error) {
+	return sendMemoryOnly(sc, nil)
+}
+
+func sendMemoryOnly(sc ssh.Conn, payload []byte) (bool, error) {
+	result, message, err := sc.SendRequest("memory-only", true, payload)
+	if err != nil {
+		return false, err
+	}
+
+	if !result {
+		return false, errors.New("client does not support memory only mode: " + string(message))
+	}
+
+	var state memoryOnlyState
+	err = ssh.Unmarshal(message, &state)
+	if err != nil {
+		return false, fmt.Errorf("client sent an incompatible message: %s", err)
+	}
+
+	return state.Enabled, nil
+}
+
+func (m *memoryOnly) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") || len(line.Arguments) != 1 {
+		fmt.Fprintf(tty, "%s", m.Help(false))
+		return nil
+	}
+
+	on := line.IsSet("on")
+	off := line.IsSet("off")
+
+	if on && off {
+		return errors.New("Cannot specify on and off at the same time")
+	}
+
+	foundClients, err := resolve(tty, line.Arguments[0].Value())
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	if on || off {
+		payload = ssh.Marshal(memoryOnlyState{Enabled: on})
+	}
+
+	ids := []string{}
+	for id := range foundClients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		enabled, err := sendMemoryOnly(foundClients[id], payload)
+		if err != nil {
+			fmt.Fprintf(tty, "%s failed: %s\n", id, err)
+			continue
+		}
+
+		fmt.Fprintf(tty, "%s memory only: %t\n", id, enabled)
+	}
+
+	return nil
+}
+
+func (m *memoryOnly) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (m *memoryOnly) Help(explain bool) string {
+	if explain {
+		return "View or change whether clients avoid writing to disk"
+	}
+
+	return terminal.MakeHelpText(
+		"memoryonly [OPTIONS] <remote_id|glob pattern>",
+		"In memory only mode a client will not write downloaded executables to disk (memfd on linux, refused elsewhere), install services or log.",
+		"With no options the current state is shown",
+		"\t--on\tEnable memory only mode",
+		"\t--off\tDisable memory only mode",
+	)
+}
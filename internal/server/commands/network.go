@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/pkg/table"
+	"golang.org/x/crypto/ssh"
+)
+
+func queryNetwork(sc ssh.Conn, requestType string) ([]byte, error) {
+	ok, message, err := sc.SendRequest(requestType, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		if len(message) == 0 {
+			return nil, errors.New("client does not support this query")
+		}
+		return nil, errors.New(string(message))
+	}
+
+	return message, nil
+}
+
+func queryInterfaces(sc ssh.Conn) ([]internal.Interface, error) {
+	message, err := queryNetwork(sc, "query-interfaces")
+	if err != nil {
+		return nil, err
+	}
+
+	return internal.UnmarshalInterfaces(message)
+}
+
+func queryNeighbours(sc ssh.Conn) ([]internal.Neighbour, error) {
+	message, err := queryNetwork(sc, "query-neighbours")
+	if err != nil {
+		return nil, err
+	}
+
+	return internal.UnmarshalNeighbours(message)
+}
+
+func queryRoutes(sc ssh.Conn) ([]internal.Route, error) {
+	message, err := queryNetwork(sc, "query-routes")
+	if err != nil {
+		return nil, err
+	}
+
+	return internal.UnmarshalRoutes(message)
+}
+
+func singleClient(tty io.ReadWriter, line terminal.ParsedLine) (string, ssh.Conn, error) {
+	return resolveOne(tty, line.Arguments[len(line.Arguments)-1].Value())
+}
+
+type neighbours struct {
+}
+
+func (n *neighbours) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") || len(line.Arguments) < 1 {
+		fmt.Fprintf(tty, "%s", n.Help(false))
+		return nil
+	}
+
+	id, sc, err := singleClient(tty, line)
+	if err != nil {
+		return err
+	}
+
+	entries, err := queryNeighbours(sc)
+	if err != nil {
+		return err
+	}
+
+	if line.IsSet("json") {
+		b, err := json.MarshalIndent(entries, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tty, "%s\n", b)
+		return nil
+	}
+
+	t, _ := table.NewTable("Neighbours of "+id, "IP", "MAC", "Interface", "State")
+	for _, e := range entries {
+		t.AddValues(e.IP, e.MAC, e.Interface, e.State)
+	}
+	return printTable(tty, line, &t)
+}
+
+func (n *neighbours) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (n *neighbours) Help(explain bool) string {
+	if explain {
+		return "Show a client's ARP/neighbour cache"
+	}
+
+	return terminal.MakeHelpText(append([]string{
+		"neighbors [OPTIONS] <remote_id>",
+		"Shows hosts the client has recently talked to on its local networks",
+	}, tableHelp...)...)
+}
+
+type routes struct {
+}
+
+func (r *routes) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") || len(line.Arguments) < 1 {
+		fmt.Fprintf(tty, "%s", r.Help(false))
+		return nil
+	}
+
+	id, sc, err := singleClient(tty, line)
+	if err != nil {
+		return err
+	}
+
+	entries, err := queryRoutes(sc)
+	if err != nil {
+		return err
+	}
+
+	if line.IsSet("json") {
+		b, err := json.MarshalIndent(entries, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tty, "%s\n", b)
+		return nil
+	}
+
+	t, _ := table.NewTable("Routes of "+id, "Destination", "Gateway", "Interface", "Metric")
+	for _, e := range entries {
+		t.AddValues(e.Destination, e.Gateway, e.Interface, strconv.FormatUint(uint64(e.Metric), 10))
+	}
+	return printTable(tty, line, &t)
+}
+
+func (r *routes) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (r *routes) Help(explain bool) string {
+	if explain {
+		return "Show a client's routing table"
+	}
+
+	return terminal.MakeHelpText(append([]string{
+		"routes [OPTIONS] <remote_id>",
+		"Shows the networks the client can route to and through which gateway",
+	}, tableHelp...)...)
+}
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type note struct {
+}
+
+func (n *note) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", n.Help(false))
+		return nil
+	}
+
+	if len(line.Arguments) == 0 {
+		return terminal.Errorf(terminal.Usage, "%s", n.Help(false))
+	}
+
+	if line.IsSet("rm") {
+		if len(line.Arguments) < 2 {
+			return terminal.Errorf(terminal.Usage, "%s", n.Help(false))
+		}
+
+		i, err := line.GetArgInt("rm")
+		if err != nil {
+			return err
+		}
+
+		r, _, err := clients.FindRecord(line.Arguments[len(line.Arguments)-1].Value())
+		if err != nil {
+			return err
+		}
+
+		removed, err := clients.DeleteNote(r.ID, i)
+		if err != nil {
+			return err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "note-delete", r.ID, removed.Text)
+		fmt.Fprintf(tty, "Removed note %d from %s\n", i, r.ID)
+		return nil
+	}
+
+	r, _, err := clients.FindRecord(line.Arguments[0].Value())
+	if err != nil {
+		return err
+	}
+
+	if len(line.Arguments) == 1 {
+		if len(r.Notes) == 0 {
+			fmt.Fprintf(tty, "%s has no notes\n", r.ID)
+			return nil
+		}
+
+		printNotes(tty, r.Notes, "")
+		return nil
+	}
+
+	text := strings.Join(line.ArgumentsAsStrings()[1:], " ")
+	if err := clients.AddNote(r.ID, console.User.ConnectionDetails, text); err != nil {
+		return err
+	}
+
+	audit.Log(console.User.ConnectionDetails, "note-add", r.ID, text)
+	fmt.Fprintf(tty, "Noted against %s\n", r.ID)
+	return nil
+}
+
+func printNotes(tty io.Writer, notes []clients.Note, indent string) {
+	for i, note := range notes {
+		fmt.Fprintf(tty, "%s%d. %s (%s, %s)\n", indent, i+1, note.Text, note.By, note.At.Format(time.RFC3339))
+	}
+}
+
+func (n *note) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (n *note) Help(explain bool) string {
+	if explain {
+		return "Keep notes against a client, such as what the machine is or who owns it"
+	}
+
+	return terminal.MakeHelpText(
+		"note <client> [text]",
+		"With text, adds it as a note on the client. Without, lists the client's notes.",
+		"Notes are kept while the client is offline, shown by info and searched with find note~\"text\".",
+		"\t--rm <number> <client>\tRemove a note by its number",
+	)
+}
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// Matching is done on text, colours and cursor movement are taken out of the output first
+var escapeSequence = regexp.MustCompile("\x1b(\\[[0-9;?]*[ -/]*[@-~]|\\][^\x07]*\x07|[()][0-9A-Za-z]|[=>78DEMc])")
+
+// notifier watches what a session outputs while nobody is looking at it, alerting the operator the first time it matches
+type notifier struct {
+	sync.Mutex
+
+	pattern *regexp.Regexp
+	bell    bool
+	alert   func()
+
+	matched bool
+	// The end of the output so far, so a match split between writes is still found
+	tail []byte
+}
+
+const notifyTail = 1024
+
+// check is given output the session wrote while detached, and alerts the operator when it first matches
+func (n *notifier) check(b []byte) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.matched {
+		return
+	}
+
+	n.tail = append(n.tail, b...)
+	if len(n.tail) > notifyTail {
+		n.tail = append(n.tail[:0], n.tail[len(n.tail)-notifyTail:]...)
+	}
+
+	if n.pattern.Match(escapeSequence.ReplaceAll(n.tail, nil)) {
+		n.matched = true
+		n.tail = nil
+		go n.alert()
+	}
+}
+
+// seen is called once the operator is looking at the session again, the next match alerts them again
+func (n *notifier) seen() {
+	n.Lock()
+	defer n.Unlock()
+
+	n.matched = false
+	n.tail = nil
+}
+
+func (n *notifier) hasMatched() bool {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.matched
+}
+
+// sessionMarks is put before the prompt while any of the operator's sessions have matched their notifyme pattern, such as [2!]
+func sessionMarks(user *internal.User) string {
+	var matched []string
+	for _, s := range sessionsOf(user) {
+		if n := s.screen.watching(); n != nil && n.hasMatched() {
+			matched = append(matched, strconv.Itoa(s.n))
+		}
+	}
+
+	if len(matched) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(matched, ",") + "!] "
+}
+
+type notifyMe struct {
+}
+
+func (nm *notifyMe) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", nm.Help(false))
+		return nil
+	}
+
+	if line.IsSet("off") {
+		number, err := line.GetArgString("off")
+		if err != nil {
+			return terminal.Errorf(terminal.Usage, "%s", nm.Help(false))
+		}
+
+		s, err := notifySession(console.User, number)
+		if err != nil {
+			return err
+		}
+
+		s.screen.watch(nil)
+		fmt.Fprintf(tty, "No longer watching session %d\n", s.n)
+		return nil
+	}
+
+	if len(line.Arguments) == 0 {
+		found := false
+		for _, s := range sessionsOf(console.User) {
+			if n := s.screen.watching(); n != nil {
+				found = true
+
+				state := ""
+				if n.hasMatched() {
+					state = ", matched"
+				}
+				if n.bell {
+					state += ", with a bell"
+				}
+				fmt.Fprintf(tty, "%d %s (%s) '%s'%s\n", s.n, s.hostname, s.id, n.pattern, state)
+			}
+		}
+
+		if !found {
+			fmt.Fprintf(tty, "No sessions are being watched\n")
+		}
+		return nil
+	}
+
+	if len(line.Arguments) > 2 {
+		return terminal.Errorf(terminal.Usage, "%s", nm.Help(false))
+	}
+
+	number := ""
+	if len(line.Arguments) == 2 {
+		number = line.Arguments[0].Value()
+	}
+
+	s, err := notifySession(console.User, number)
+	if err != nil {
+		return err
+	}
+
+	pattern, err := regexp.Compile(line.Arguments[len(line.Arguments)-1].Value())
+	if err != nil {
+		return terminal.Errorf(terminal.Usage, "Invalid pattern: %s", err)
+	}
+
+	term, _ := tty.(*terminal.Terminal)
+
+	n := &notifier{pattern: pattern, bell: line.IsSet("bell")}
+	n.alert = func() {
+		if term == nil {
+			return
+		}
+
+		term.SetPrompt(Prompt(console))
+
+		bell := ""
+		if n.bell {
+			bell = "\a"
+		}
+
+		// Attached to another session, nothing can be written without getting in its way
+		if term.Raw() {
+			if bell != "" {
+				term.Write([]byte(bell))
+			}
+			return
+		}
+
+		fmt.Fprintf(term, "%s[rssh] Session %d on %s (%s) matched '%s', see it with: sessions %d\n", bell, s.n, s.hostname, s.id, pattern, s.n)
+	}
+
+	s.screen.watch(n)
+	fmt.Fprintf(tty, "Watching session %d on %s (%s) for '%s'\n", s.n, s.hostname, s.id, pattern)
+	return nil
+}
+
+// notifySession is the session numbered, or when number is empty the one the operator was last in
+func notifySession(user *internal.User, number string) (*liveSession, error) {
+	if number != "" {
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, terminal.Errorf(terminal.Usage, "'%s' is not a session number", number)
+		}
+
+		s := sessionNumbered(user, n)
+		if s == nil {
+			return nil, terminal.Errorf(terminal.NotFound, "No session %d", n)
+		}
+		return s, nil
+	}
+
+	var last *liveSession
+	for _, s := range sessionsOf(user) {
+		if last == nil || s.screen.hiddenSince().After(last.screen.hiddenSince()) {
+			last = s
+		}
+	}
+
+	if last == nil {
+		return nil, terminal.Errorf(terminal.NotFound, "No sessions, start one with connect")
+	}
+	return last, nil
+}
+
+func (nm *notifyMe) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (nm *notifyMe) Help(explain bool) string {
+	if explain {
+		return "Alert you when a detached session outputs something that matches a pattern"
+	}
+
+	return terminal.MakeHelpText(
+		"notifyme [OPTIONS] [session number] <pattern>",
+		"Without a session number, watches the session you detached from last. The pattern is a regular expression",
+		"\t--bell\tRing the terminal bell as well",
+		"\t--off\tStop watching the session numbered",
+	)
+}
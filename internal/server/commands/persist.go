@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/persistence"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type persist struct {
+}
+
+func printChanges(tty io.Writer, changes string) {
+	for _, change := range strings.Split(changes, "\n") {
+		fmt.Fprintf(tty, "\t%s\n", strings.ReplaceAll(strings.TrimRight(change, "\t"), "\t", " "))
+	}
+}
+
+func (p *persist) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") || len(line.Arguments) < 1 {
+		fmt.Fprintf(tty, "%s", p.Help(false))
+		return nil
+	}
+
+	target, err := persistTarget(line)
+	if err != nil {
+		return err
+	}
+
+	id, sc, err := resolveOne(tty, target)
+	if err != nil {
+		return err
+	}
+
+	method, _ := line.GetArgString("method")
+
+	if line.IsSet("l") {
+		records, err := persistence.List(sc, method)
+		if err != nil {
+			return err
+		}
+
+		if len(records) == 0 {
+			fmt.Fprintf(tty, "No persistence recorded for %s\n", id)
+		}
+
+		for _, r := range records {
+			fmt.Fprintf(tty, "%s %s (installed %s)\n", r.Method, r.Name, r.Installed.Format(time.RFC3339))
+			printChanges(tty, r.Changes)
+		}
+		return nil
+	}
+
+	if line.IsSet("remove") {
+		removals, err := persistence.Remove(console.User.ConnectionDetails, id, sc, method)
+		for _, r := range removals {
+			switch {
+			case r.Remaining == "":
+				fmt.Fprintf(tty, "Removed %s %s\n", r.Method, r.Name)
+			case r.Remaining == r.Changes:
+				fmt.Fprintf(tty, "Removing %s %s failed: %s\n", r.Method, r.Name, r.Err)
+			default:
+				fmt.Fprintf(tty, "Partially removed %s %s: %s\n", r.Method, r.Name, r.Err)
+			}
+		}
+
+		if err == persistence.ErrNoRecords {
+			return fmt.Errorf("No persistence recorded for %s", id)
+		}
+		return err
+	}
+
+	switch method {
+	case "systemd", "cron", "registry", "task":
+	case "":
+		return errors.New("No persistence method specified, use --method systemd|cron|registry|task")
+	default:
+		return fmt.Errorf("Unknown persistence method '%s'", method)
+	}
+
+	if err := clients.Require(id, "persist", "persistence"); err != nil {
+		return err
+	}
+
+	name, err := line.GetArgString("name")
+	if err != nil {
+		name = "rssh"
+	}
+
+	path, _ := line.GetArgString("path")
+
+	r, err := persistence.Install(console.User.ConnectionDetails, id, sc, method, name, path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(tty, "Installed %s persistence as '%s', changes made:\n", method, name)
+	printChanges(tty, r.Changes)
+
+	return nil
+}
+
+// persistTarget is the one client on the line, wherever it is among the flags. Flag values are also arguments, so they are left out
+func persistTarget(line terminal.ParsedLine) (string, error) {
+	args := positional(line, "method", "name", "path")
+	if len(args) != 1 {
+		return "", terminal.Errorf(terminal.Usage, "persist takes one client, see persist -h")
+	}
+	return args[0].Value(), nil
+}
+
+func (p *persist) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (p *persist) Help(explain bool) string {
+	if explain {
+		return "Install or remove persistence on a client"
+	}
+
+	return terminal.MakeHelpText(
+		"persist [OPTIONS] <remote_id>",
+		"Copies the client binary and registers it to start on boot/logon. Every change is recorded on the server so it can be fully reverted.",
+		"Installs and removals are written to the audit log.",
+		"\t--method\tsystemd or cron (unix), registry or task (windows)",
+		"\t--name\tName of the service, cron entry, run key value or task (default rssh)",
+		"\t--path\tWhere to copy the client binary to (default depends on method)",
+		"\t--remove\tRevert all recorded persistence for this client, or only the given --method",
+		"\t-l\tList recorded persistence for this client",
+	)
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+func TestPersistTarget(t *testing.T) {
+	for _, l := range []string{
+		"persist --method systemd web01",
+		"persist web01 --method systemd",
+		"persist --name rssh web01 --method cron --path /tmp/x",
+		"persist --remove web01",
+		"persist -l web01 --method cron",
+	} {
+		target, err := persistTarget(terminal.ParseLine(l, 0))
+		if err != nil || target != "web01" {
+			t.Fatalf("expected %q to target web01, got %q, %v", l, target, err)
+		}
+	}
+
+	for _, l := range []string{
+		"persist web01 web02 --method cron",
+		"persist --method cron",
+	} {
+		if target, err := persistTarget(terminal.ParseLine(l, 0)); err == nil {
+			t.Fatalf("expected %q to be refused, got %q", l, target)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+)
+
+const DefaultPrompt = "catcher$ "
+
+func serverName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "rssh"
+	}
+	return name
+}
+
+func connectedClients(user *internal.User) int {
+	if user.Duress {
+		return 0
+	}
+
+	found, _ := clients.Search("")
+	return len(found)
+}
+
+// took is how long a command took, to the millisecond when short and to a tenth of a second otherwise
+func took(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// Prompt fills in the operators prompt template, {user} {role} {server} {clients} and {took} are replaced with the ssh username,
+// role, server hostname, number of connected clients and how long the last command took. Sessions that have matched their notifyme
+// pattern are marked before it
+func Prompt(console *Console) string {
+	user := console.User
+	if user.Prompt == "" {
+		return sessionMarks(user) + DefaultPrompt
+	}
+
+	console.lock.Lock()
+	last := console.took
+	console.lock.Unlock()
+
+	return sessionMarks(user) + strings.NewReplacer(
+		"{user}", user.ServerConnection.User(),
+		"{role}", user.Role,
+		"{server}", serverName(),
+		"{clients}", fmt.Sprintf("%d", connectedClients(user)),
+		"{took}", took(last),
+	).Replace(user.Prompt)
+}
+
+// Title is the window title for an operators terminal, attached is the client they are connected to if any
+func Title(user *internal.User, attached string) string {
+	title := fmt.Sprintf("%s@%s", user.ServerConnection.User(), serverName())
+	if attached != "" {
+		title += ": " + attached
+	}
+	return title
+}
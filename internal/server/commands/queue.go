@@ -0,0 +1,264 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/queue"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+)
+
+// The commands that can wait for a client, none of them need a terminal or anyone to answer them
+var queueable = map[string]bool{
+	"exec":       true,
+	"tag":        true,
+	"note":       true,
+	"persist":    true,
+	"memoryonly": true,
+	"compress":   true,
+	"rotate":     true,
+}
+
+type queueCommand struct {
+}
+
+// Compound so the line is kept as it was typed, with $current expanded when it is run rather than when it is queued
+func (q *queueCommand) Compound() {}
+
+func (q *queueCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") && len(line.Arguments) == 0 {
+		fmt.Fprintf(tty, "%s", q.Help(false))
+		return nil
+	}
+
+	shell := terminal.ShellOf(tty)
+	if shell == nil {
+		return errNoShell
+	}
+
+	words, rest, err := shell.Words(line, 1)
+	if err != nil {
+		return terminal.Errorf(terminal.Usage, "%s", q.Help(false))
+	}
+
+	switch words[0] {
+	case "ls":
+		return q.list(tty, strings.TrimSpace(shell.Expand(rest)))
+
+	case "rm":
+		args, _, err := shell.Words(line, 3)
+		if err != nil {
+			return terminal.Errorf(terminal.Usage, "%s", q.Help(false))
+		}
+
+		r, _, err := clients.FindRecord(args[1])
+		if err != nil {
+			return terminal.Errorf(terminal.NotFound, "%s", err)
+		}
+
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return terminal.Errorf(terminal.Usage, "%s", q.Help(false))
+		}
+
+		e, err := queue.Cancel(console.User.ConnectionDetails, r.ID, n)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tty, "No longer waiting to run %q on %s\n", e.Line, r.ID)
+		return nil
+	}
+
+	if rest == "" {
+		return terminal.Errorf(terminal.Usage, "%s", q.Help(false))
+	}
+
+	r, since, err := clients.FindRecord(words[0])
+	if err != nil {
+		return terminal.Errorf(terminal.NotFound, "%s", err)
+	}
+
+	if !since.IsZero() {
+		return fmt.Errorf("%s is connected, run the command now instead", r.ID)
+	}
+
+	parsed := terminal.ParseLine(rest, 0)
+	if parsed.Command == nil || !queueable[parsed.Command.Value()] {
+		return terminal.Errorf(terminal.Usage, "Only %s can be queued", strings.Join(queueableNames(), ", "))
+	}
+
+	if err := unattended(parsed); err != nil {
+		return err
+	}
+
+	err = queue.Add(r.ID, queue.Entry{Line: rest, By: console.User.ConnectionDetails, Role: console.User.Role, Queued: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(tty, "Queued for %s, %d commands waiting for it to connect\n", r.ID, len(queue.Pending(r.ID)))
+	return nil
+}
+
+func (q *queueCommand) list(tty io.ReadWriter, target string) error {
+	records := queue.Records()
+	if target != "" {
+		r, _, err := clients.FindRecord(target)
+		if err != nil {
+			return terminal.Errorf(terminal.NotFound, "%s", err)
+		}
+		records = []string{r.ID}
+	}
+	sort.Strings(records)
+
+	waiting := 0
+	for _, record := range records {
+		for i, e := range queue.Pending(record) {
+			fmt.Fprintf(tty, "%s %d: %s (queued by %s at %s)\n", record, i+1, e.Line, e.By, e.Queued.Format(time.RFC3339))
+			waiting++
+		}
+	}
+
+	if waiting == 0 {
+		fmt.Fprintf(tty, "No commands waiting\n")
+	}
+	return nil
+}
+
+func (q *queueCommand) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (q *queueCommand) Help(explain bool) string {
+	if explain {
+		return "Queue commands for an offline client to run when it reconnects"
+	}
+
+	return terminal.MakeHelpText(
+		"queue <client> <command...>",
+		"queue ls [client]",
+		"queue rm <client> <n>",
+		"The command is run as you, in the order it was queued, once the client connects again. $current is the client at that point, e.g",
+		"\tqueue web01 exec -y $current uname -a",
+		"Only "+strings.Join(queueableNames(), ", ")+" can be queued, see what they did with results <client>",
+	)
+}
+
+// unattended refuses lines that would stop to ask a question nobody is there to answer, as exec does before it runs
+func unattended(parsed terminal.ParsedLine) error {
+	if parsed.Command.Value() == "exec" && !(parsed.IsSet("y") || parsed.IsSet("q") || parsed.IsSet("raw")) {
+		return terminal.Errorf(terminal.Usage, "exec is not asked about before it runs unattended, add -y to say that is what you want")
+	}
+	return nil
+}
+
+func queueableNames() []string {
+	names := make([]string, 0, len(queueable))
+	for name := range queueable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type results struct {
+}
+
+func (r *results) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") || len(line.Arguments) != 1 {
+		return terminal.Errorf(terminal.Usage, "%s", r.Help(false))
+	}
+
+	record, _, err := clients.FindRecord(line.Arguments[0].Value())
+	if err != nil {
+		return terminal.Errorf(terminal.NotFound, "%s", err)
+	}
+
+	ran := queue.Results(record.ID)
+	if len(ran) == 0 {
+		fmt.Fprintf(tty, "No queued commands have run on %s\n", record.ID)
+		return nil
+	}
+
+	for _, result := range ran {
+		outcome := "ok"
+		if result.Error != "" {
+			outcome = "failed: " + result.Error
+		}
+
+		fmt.Fprintf(tty, "%s %s (queued by %s), %s\n", result.Ran.Format(time.RFC3339), result.Line, result.By, outcome)
+		if result.Output != "" {
+			fmt.Fprintf(tty, "%s\n", strings.TrimRight(result.Output, "\n"))
+		}
+	}
+	return nil
+}
+
+func (r *results) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (r *results) Help(explain bool) string {
+	if explain {
+		return "Show what commands queued for a client did"
+	}
+
+	return terminal.MakeHelpText(
+		"results <client>",
+		"Lists the commands queued with queue that have run on the client, oldest first, with their output",
+	)
+}
+
+// RunQueued runs the commands queued for a client that has just connected, as the operators that queued them. They are taken
+// off the queue before they run, so a server stopping partway through never runs one twice
+func RunQueued(id, datadir string) {
+	r, _, err := clients.FindRecord(id)
+	if err != nil {
+		return
+	}
+
+	log := logger.NewLog("queue")
+	for _, e := range queue.Take(r.ID) {
+		user := &internal.User{Role: e.Role, ConnectionDetails: e.By}
+
+		shell := terminal.NewShell(For(user), "")
+		shell.Context = NewConsole(user, log, datadir)
+		shell.SetVariable("current", id)
+
+		// Nothing to read, nobody is there to answer
+		var output bytes.Buffer
+		tty := struct {
+			io.Reader
+			io.Writer
+		}{strings.NewReader(""), &output}
+
+		result := queue.Result{Entry: e, Ran: time.Now()}
+		if err := shell.Execute(tty, e.Line); err != nil {
+			result.Error = err.Error()
+		}
+		result.Output = output.String()
+
+		log.Info("Ran %q queued by %s on %s", e.Line, e.By, id)
+		if err := queue.Record(r.ID, result); err != nil {
+			log.Warning("Unable to keep the result of %q: %s", e.Line, err)
+		}
+	}
+}
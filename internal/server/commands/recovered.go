@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/crashes"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// recoveredCommand turns a panic in a command, or in anything it is wrapped with, into an error for the line that ran it
+type recoveredCommand struct {
+	terminal.Command
+
+	name string
+}
+
+func (r *recoveredCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) (err error) {
+	defer crashes.Catch(func() crashes.Report {
+		report := crashes.Report{
+			Kind:     "command",
+			Operator: consoleOf(tty).User.ConnectionDetails,
+			Command:  r.name,
+			Line:     line.RawLine,
+		}
+
+		// The first argument naming a connected client is most likely the one the command was working on
+		for _, arg := range line.Arguments {
+			if id, _, err := clients.ResolveOne(arg.Value()); err == nil {
+				report.Client = id
+				break
+			}
+		}
+
+		return report
+	}, func(report crashes.Report) {
+		err = terminal.Errorf(terminal.Failed, "%s crashed (%s), the server is still running and the crash was written to crashes.log", r.name, report.Panic)
+	})
+
+	return r.Command.Run(tty, line)
+}
+
+// Expect is run on every tab press, a panic there would end the operator's session rather than a line
+func (r *recoveredCommand) Expect(line terminal.ParsedLine) (completions []string) {
+	defer crashes.Catch(func() crashes.Report {
+		return crashes.Report{Kind: "completion", Command: r.name, Line: line.RawLine}
+	}, func(crashes.Report) {
+		completions = nil
+	})
+
+	return r.Command.Expect(line)
+}
+
+func (r *recoveredCommand) Unwrap() terminal.Command {
+	return r.Command
+}
+
+func recoverCommands(m map[string]terminal.Command) map[string]terminal.Command {
+	recovered := map[string]terminal.Command{}
+	for name, command := range m {
+		recovered[name] = &recoveredCommand{Command: command, name: name}
+	}
+	return recovered
+}
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/recordings"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/pkg/table"
+)
+
+const (
+	// How far the arrow keys move through a recording
+	seekStep = 5.0
+
+	minSpeed = 0.25
+	maxSpeed = 16
+)
+
+type replay struct {
+}
+
+func (r *replay) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+	args := positional(line, "speed", "idle")
+
+	if line.IsSet("h") || len(args) > 1 {
+		return terminal.Errorf(terminal.Usage, "%s", r.Help(false))
+	}
+
+	if len(args) == 0 || args[0].Value() == "ls" {
+		list, err := recordings.List(console.DataDir)
+		if err != nil {
+			return err
+		}
+
+		if len(list) == 0 {
+			fmt.Fprintf(tty, "No recordings, put asciinema .cast files in %s to play them back\n", console.DataDir+"/recordings")
+			return nil
+		}
+
+		t, _ := table.NewTable("Recordings", "Name", "Modified", "Size")
+		for _, rec := range list {
+			t.AddValues(rec.Name, rec.Modified.Format("2006/01/02 15:04:05"), byteSize(rec.Size))
+		}
+		return printTable(tty, line, &t)
+	}
+
+	cast, err := recordings.Open(console.DataDir, args[0].Value())
+	if err != nil {
+		return terminal.Errorf(terminal.NotFound, "%s", err)
+	}
+
+	p := &player{cast: cast, out: tty, speed: 1, idle: cast.IdleTimeLimit}
+	if s, err := line.GetArgString("speed"); err == nil {
+		p.speed, err = strconv.ParseFloat(s, 64)
+		if err != nil || p.speed < minSpeed || p.speed > maxSpeed {
+			return terminal.Errorf(terminal.Usage, "--speed must be a number from %v to %v", minSpeed, maxSpeed)
+		}
+	}
+
+	if idle, err := line.GetArgString("idle"); err == nil {
+		p.idle, err = strconv.ParseFloat(idle, 64)
+		if err != nil || p.idle < 0 {
+			return terminal.Errorf(terminal.Usage, "--idle must be a number of seconds")
+		}
+	}
+
+	var (
+		keys = make(chan []byte)
+		over = make(chan struct{})
+	)
+	defer close(over)
+
+	term, isTerm := tty.(*terminal.Terminal)
+	if isTerm && term.Dumb() {
+		return terminal.Errorf(terminal.Usage, "Recordings are drawn with escape sequences, which this terminal does not understand")
+	}
+
+	if isTerm {
+		fmt.Fprintf(tty, "Playing %s (%dx%d, %s), space pauses, + and - change the speed, the arrow keys go back or forward %vs, q stops\n",
+			args[0].Value(), cast.Width, cast.Height, cast.Duration().Round(time.Second), seekStep)
+
+		term.EnableRaw()
+		defer term.DisableRaw()
+
+		go func() {
+			for {
+				b := make([]byte, 8)
+				n, err := tty.Read(b)
+				if err != nil {
+					return
+				}
+
+				select {
+				case keys <- b[:n]:
+				case <-over:
+					return
+				}
+			}
+		}()
+	}
+
+	p.play(keys)
+
+	// Leave the terminal as it was found whatever the recording did last
+	fmt.Fprintf(tty, "\x1b[0m\x1b[?25h\r\nPlayed %s of %s\r\n", time.Duration(p.at*float64(time.Second)).Round(time.Second), args[0].Value())
+
+	return nil
+}
+
+// player writes the events of a cast out with the pauses between them, moving through it as keys are pressed
+type player struct {
+	cast  *recordings.Cast
+	out   io.Writer
+	speed float64
+	// Gaps longer than this many seconds are cut short, nothing is when it is 0
+	idle float64
+
+	// Where in the recording playback is, in seconds, and the next event to write
+	at   float64
+	next int
+}
+
+func (p *player) play(keys <-chan []byte) {
+	paused := false
+	for p.next < len(p.cast.Events) {
+		var (
+			timer   *time.Timer
+			wait    <-chan time.Time
+			started = time.Now()
+			gap     = p.cast.Events[p.next].Time - p.at
+		)
+
+		if p.idle > 0 && gap > p.idle {
+			// Skip through the rest of the pause, so a seek from here does not count it
+			p.at += gap - p.idle
+			gap = p.idle
+		}
+
+		if !paused {
+			timer = time.NewTimer(time.Duration(gap / p.speed * float64(time.Second)))
+			wait = timer.C
+		}
+
+		select {
+		case <-wait:
+			p.seek(p.cast.Events[p.next].Time)
+
+		case key := <-keys:
+			if timer != nil {
+				timer.Stop()
+			}
+
+			if !paused {
+				p.at += time.Since(started).Seconds() * p.speed
+				if p.at > p.cast.Events[p.next].Time {
+					p.at = p.cast.Events[p.next].Time
+				}
+			}
+
+			switch {
+			case len(key) == 0:
+			case key[0] == 'q' || key[0] == 0x03 || (key[0] == 0x1b && len(key) == 1):
+				return
+			case key[0] == ' ':
+				paused = !paused
+			case key[0] == '+' || key[0] == '=':
+				if p.speed < maxSpeed {
+					p.speed *= 2
+				}
+			case key[0] == '-':
+				if p.speed > minSpeed {
+					p.speed /= 2
+				}
+			case string(key) == "\x1b[C" || key[0] == 'l':
+				p.seek(p.at + seekStep)
+			case string(key) == "\x1b[D" || key[0] == 'h':
+				p.seek(p.at - seekStep)
+			}
+		}
+	}
+}
+
+// seek moves to a point in the recording, drawing everything up to it at once. Going back clears the screen and draws
+// the recording again from the start, as the screen cannot be wound back
+func (p *player) seek(to float64) {
+	if end := p.cast.Duration().Seconds(); to > end {
+		to = end
+	}
+
+	if to < 0 {
+		to = 0
+	}
+
+	if to < p.at {
+		io.WriteString(p.out, "\x1b[0m\x1b[2J\x1b[H")
+		p.next = 0
+	}
+
+	for p.next < len(p.cast.Events) && p.cast.Events[p.next].Time <= to {
+		io.WriteString(p.out, p.cast.Events[p.next].Data)
+		p.next++
+	}
+
+	p.at = to
+}
+
+func (r *replay) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (r *replay) Help(explain bool) string {
+	if explain {
+		return "Play back a recorded terminal session"
+	}
+
+	return terminal.MakeHelpText(append([]string{
+		"replay [ls]",
+		"replay [--speed n] [--idle seconds] <recording>",
+		"Recordings are asciinema .cast files in the recordings directory of the data directory",
+		"\t--speed\tPlay back this many times faster, from 0.25 to 16",
+		"\t--idle\tCut pauses longer than this many seconds short, the recording may set its own",
+		"While playing space pauses, + and - double or halve the speed, the left and right arrows go back or forward 5 seconds, and q stops",
+	}, tableHelp...)...)
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"golang.org/x/crypto/ssh"
+)
+
+// resolve is how commands find the clients a target names, so every command takes the same targets. On top of everything
+// clients.Resolve understands is #n, the nth client of the last listing on this console, and $current, the last client a
+// command was run against alone, which is kept as an ordinary shell variable
+func resolve(tty io.ReadWriter, target string) (map[string]*ssh.ServerConn, error) {
+	target, err := consoleOf(tty).handle(target)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := clients.Resolve(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(found) == 1 {
+		for id := range found {
+			setCurrent(tty, id)
+		}
+	}
+
+	return found, nil
+}
+
+// resolveOne is resolve for the commands that can only act on a single client. On the console the operator is asked to pick one
+// when the target names several, anywhere else that is an error
+func resolveOne(tty io.ReadWriter, target string) (string, *ssh.ServerConn, error) {
+	found, err := resolve(tty, target)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	if len(ids) == 1 {
+		return ids[0], found[ids[0]], nil
+	}
+
+	term, ok := tty.(*terminal.Terminal)
+	if !ok {
+		return "", nil, clients.Ambiguous(target)
+	}
+
+	options := make([]string, 0, len(ids))
+	for _, id := range ids {
+		options = append(options, fmt.Sprintf("%s %s %s", id, clients.NormaliseHostname(found[id].User()), found[id].RemoteAddr()))
+	}
+
+	picked, err := term.Pick(fmt.Sprintf("'%s' matches %d clients, pick one:", target, len(ids)), options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	setCurrent(tty, ids[picked])
+	return ids[picked], found[ids[picked]], nil
+}
+
+func setCurrent(tty io.ReadWriter, id string) {
+	if shell := terminal.ShellOf(tty); shell != nil {
+		shell.SetVariable("current", id)
+	}
+}
+
+func (c *Console) setHandles(ids []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.handles = ids
+}
+
+// handle is the id a #n target stands for, other targets are returned as they are
+func (c *Console) handle(target string) (string, error) {
+	if !strings.HasPrefix(target, "#") {
+		return target, nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n, err := strconv.Atoi(target[1:])
+	if err != nil || n < 1 {
+		return "", terminal.Errorf(terminal.Usage, "'%s' is not a handle, expected # and the number ls gave a client", target)
+	}
+
+	if n > len(c.handles) {
+		return "", terminal.Errorf(terminal.NotFound, "There is no %s, ls numbers clients for this console", target)
+	}
+
+	return c.handles[n-1], nil
+}
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/identity"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type rotate struct {
+}
+
+func (r *rotate) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") || len(line.Arguments) < 1 {
+		fmt.Fprintf(tty, "%s", r.Help(false))
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can rotate client keys")
+	}
+
+	target := line.Arguments[len(line.Arguments)-1].Value()
+
+	foundClients, err := resolve(tty, target)
+	if err != nil {
+		return err
+	}
+
+	for id, sc := range foundClients {
+		if err := clients.Require(id, "rotate-key", "key rotation"); err != nil {
+			fmt.Fprintf(tty, "%s\n", err)
+			continue
+		}
+
+		fingerprint, err := identity.Rotate(console.User.ConnectionDetails, id, sc)
+		if err != nil {
+			fmt.Fprintf(tty, "%s: %s\n", id, err)
+			continue
+		}
+
+		fmt.Fprintf(tty, "%s: now %s\n", id, fingerprint)
+
+		if line.IsSet("reconnect") {
+			sc.Close()
+		}
+	}
+
+	return nil
+}
+
+func (r *rotate) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (r *rotate) Help(explain bool) string {
+	if explain {
+		return "Replace the key a client authenticates with"
+	}
+
+	return terminal.MakeHelpText(
+		"rotate [OPTIONS] <remote_id>",
+		"Has each matching client generate a new key, which replaces its old key in authorized_controllee_keys. The client stays connected and uses the new key from its next handshake.",
+		"\t--reconnect\tDisconnect the client afterwards, so it handshakes with the new key straight away",
+	)
+}
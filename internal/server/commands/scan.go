@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"golang.org/x/crypto/ssh"
+)
+
+type scan struct {
+}
+
+func (s *scan) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") || len(line.Arguments) == 0 {
+		fmt.Fprintf(tty, "%s", s.Help(false))
+		return nil
+	}
+
+	args, err := scanArgs(line)
+	if err != nil {
+		return err
+	}
+
+	id, sc, err := resolveOne(tty, args[0])
+	if err != nil {
+		return err
+	}
+
+	rate := 100
+	if line.IsSet("rate") {
+		rate, err = line.GetArgInt("rate")
+		if err != nil || rate < 1 {
+			return terminal.Errorf(terminal.Usage, "--rate needs a positive number of connections per second")
+		}
+	}
+
+	timeout := time.Second
+	if line.IsSet("timeout") {
+		timeout, err = line.GetArgDuration("timeout")
+		if err != nil || timeout < time.Millisecond {
+			return terminal.Errorf(terminal.Usage, "--timeout needs a duration of at least 1ms, e.g 500ms")
+		}
+	}
+
+	req := struct {
+		Network string
+		Ports   string
+		Rate    uint32
+		Timeout uint32
+	}{
+		Network: args[1],
+		Ports:   args[2],
+		Rate:    uint32(rate),
+		Timeout: uint32(timeout / time.Millisecond),
+	}
+
+	if err := clients.Require(id, "scan", "scanning"); err != nil {
+		return err
+	}
+
+	results, r, err := sc.OpenChannel("scan", ssh.Marshal(&req))
+	if err != nil {
+		return fmt.Errorf("Unable to start scan: %s", err)
+	}
+	go ssh.DiscardRequests(r)
+
+	fmt.Fprintf(tty, "Scanning %s ports %s from %s\n", req.Network, req.Ports, id)
+
+	io.Copy(tty, results)
+	results.Close()
+
+	fmt.Fprintf(tty, "Scan finished\n")
+
+	return nil
+}
+
+// scanArgs is the client, network and ports, wherever they are among the flags
+func scanArgs(line terminal.ParsedLine) ([]string, error) {
+	args := line.Positional
+	if len(args) != 3 {
+		return nil, terminal.Errorf(terminal.Usage, "scan takes a client, a network and ports, see scan -h")
+	}
+
+	return []string{args[0].Value(), args[1].Value(), args[2].Value()}, nil
+}
+
+func (s *scan) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (s *scan) Help(explain bool) string {
+	if explain {
+		return "TCP connect scan from a client"
+	}
+
+	return s.Flags().Help()
+}
+
+func (s *scan) Flags() *terminal.FlagSet {
+	return &terminal.FlagSet{
+		Usage: []string{
+			"scan [OPTIONS] <remote_id> <ip|cidr> <ports>",
+			"Has the client attempt tcp connections from its vantage point, printing open ports as they are found. At most a /16 (or equivalent) can be scanned at once.",
+			"Ports are a comma seperated list of ports or ranges, e.g 22,80,8000-8100",
+		},
+		Flags: []terminal.FlagSpec{
+			{Name: "rate", Type: terminal.FlagInt, Description: "Connection attempts per second (default 100)"},
+			{Name: "timeout", Type: terminal.FlagDuration, Description: "How long to wait for each connection (default 1s)"},
+		},
+	}
+}
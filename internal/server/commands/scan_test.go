@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// validated is l checked as the console checks it before scan runs
+func validated(t *testing.T, l string) terminal.ParsedLine {
+	line := terminal.ParseLine(l, 0)
+	if err := (&scan{}).Flags().Validate(&line); err != nil {
+		t.Fatalf("%q: %s", l, err)
+	}
+	return line
+}
+
+func TestScanArgs(t *testing.T) {
+	for _, l := range []string{
+		"scan web01 10.0.0.0/24 22",
+		"scan --rate 50 --timeout 2s web01 10.0.0.0/24 22",
+		"scan web01 --rate 50 10.0.0.0/24 --timeout 2s 22",
+		"scan web01 10.0.0.0/24 22 --rate 50",
+		"scan web01 10.0.0.0/24 22 --rate 50 --timeout 2s",
+	} {
+		args, err := scanArgs(validated(t, l))
+		if err != nil || strings.Join(args, " ") != "web01 10.0.0.0/24 22" {
+			t.Fatalf("expected %q to scan 10.0.0.0/24 port 22 from web01, got %q, %v", l, args, err)
+		}
+	}
+
+	for _, l := range []string{
+		"scan web01 10.0.0.0/24 --rate 50",
+		"scan web01 10.0.0.0/24 22 80",
+	} {
+		if args, err := scanArgs(validated(t, l)); err == nil {
+			t.Fatalf("expected %q to be refused, got %q", l, args)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type ifCommand struct {
+}
+
+func (i *ifCommand) Compound() {}
+
+func (i *ifCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") && len(line.Arguments) == 0 {
+		fmt.Fprintf(tty, "%s", i.Help(false))
+		return nil
+	}
+
+	shell := terminal.ShellOf(tty)
+	if shell == nil {
+		return errNoShell
+	}
+
+	words, command, err := shell.Words(line, 3)
+	if err != nil || command == "" {
+		fmt.Fprintf(tty, "%s", i.Help(false))
+		return nil
+	}
+
+	var matched bool
+	switch words[1] {
+	case "==":
+		matched = words[0] == words[2]
+	case "!=":
+		matched = words[0] != words[2]
+	default:
+		return fmt.Errorf("Unknown comparison '%s', use == or !=", words[1])
+	}
+
+	if !matched {
+		return nil
+	}
+
+	return shell.Execute(tty, command)
+}
+
+func (i *ifCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (i *ifCommand) Help(explain bool) string {
+	if explain {
+		return "Run a command only if a comparison holds"
+	}
+
+	return terminal.MakeHelpText(
+		"if <value> ==|!= <value> <command...>",
+		"Both values have variables expanded, quote them if they may be empty or contain spaces. For example:",
+		"\tif \"$os\" == linux exec -y $host uptime",
+	)
+}
+
+type foreach struct {
+}
+
+func (f *foreach) Compound() {}
+
+func (f *foreach) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") && len(line.Arguments) == 0 {
+		fmt.Fprintf(tty, "%s", f.Help(false))
+		return nil
+	}
+
+	shell := terminal.ShellOf(tty)
+	if shell == nil {
+		return errNoShell
+	}
+
+	words, command, err := shell.Words(line, 3)
+	if err != nil || words[1] != "in" || command == "" {
+		fmt.Fprintf(tty, "%s", f.Help(false))
+		return nil
+	}
+
+	name := words[0]
+	filter := strings.TrimSuffix(strings.TrimPrefix(words[2], "("), ")")
+
+	foundClients, err := resolve(tty, filter)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(foundClients))
+	for id := range foundClients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	previous, wasSet := shell.Variable(name)
+	defer func() {
+		if wasSet {
+			shell.SetVariable(name, previous)
+		} else {
+			shell.UnsetVariable(name)
+		}
+	}()
+
+	for _, id := range ids {
+		if err := shell.SetVariable(name, id); err != nil {
+			return err
+		}
+
+		err := shell.Execute(tty, command)
+		if err == io.EOF {
+			return err
+		}
+
+		if err != nil {
+			fmt.Fprintf(tty, "%s: %s\n", id, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *foreach) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (f *foreach) Help(explain bool) string {
+	if explain {
+		return "Run a command once for every matching client"
+	}
+
+	return terminal.MakeHelpText(
+		"foreach <name> in (<filter>) <command...>",
+		"Sets $name to the id of each client matching the filter in turn, then runs the command. Redirection belongs to the command, so use >> to collect output from every client. For example:",
+		"\tforeach c in (web*) exec -y $c uptime >> uptime.txt",
+	)
+}
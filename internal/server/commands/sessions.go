@@ -0,0 +1,499 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"golang.org/x/crypto/ssh"
+)
+
+// How much of what a detached session outputs is kept, and shown when it is switched back to
+const detachedBacklog = 64 * 1024
+
+// errDetached is returned by attach when the operator detached from their sessions with ~d, leaving them running
+var errDetached = errors.New("detached")
+
+const sessionKeys = "~d detach, ~1-~9 switch to that session, ~n the next one, ~l list them, ~~ a literal ~"
+
+// liveSession is a shell opened with connect, which keeps running while the operator is detached from it
+type liveSession struct {
+	n        int
+	id       string
+	hostname string
+	started  time.Time
+
+	target  ssh.Conn
+	channel ssh.Channel
+	screen  *sessionScreen
+
+	// Keys are read through, and output written to, the elevator when there is one
+	input  io.Reader
+	output io.Writer
+
+	done chan struct{}
+}
+
+// sessionScreen is the operator's terminal while the session is attached, and a backlog of its output while it is not
+type sessionScreen struct {
+	sync.Mutex
+
+	in      io.Reader
+	tty     io.Writer
+	backlog []byte
+
+	// Set with notifyme
+	notify *notifier
+	hidden time.Time
+}
+
+func (s *sessionScreen) Read(b []byte) (int, error) {
+	return s.in.Read(b)
+}
+
+func (s *sessionScreen) Write(b []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.tty != nil {
+		return s.tty.Write(b)
+	}
+
+	if s.notify != nil {
+		s.notify.check(b)
+	}
+
+	s.backlog = append(s.backlog, b...)
+	if len(s.backlog) > detachedBacklog {
+		s.backlog = append(s.backlog[:0], s.backlog[len(s.backlog)-detachedBacklog:]...)
+	}
+
+	return len(b), nil
+}
+
+// show sends output to tty again, starting with what was missed
+func (s *sessionScreen) show(tty io.Writer) {
+	s.Lock()
+	defer s.Unlock()
+
+	tty.Write(s.backlog)
+	s.backlog = nil
+	s.tty = tty
+
+	if s.notify != nil {
+		s.notify.seen()
+	}
+}
+
+func (s *sessionScreen) hide() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.tty = nil
+	s.hidden = time.Now()
+}
+
+// hiddenSince is when the operator last left the session, zero if they never have
+func (s *sessionScreen) hiddenSince() time.Time {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.hidden
+}
+
+func (s *sessionScreen) watch(n *notifier) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.notify = n
+}
+
+func (s *sessionScreen) watching() *notifier {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.notify
+}
+
+func (s *sessionScreen) waiting() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.backlog)
+}
+
+// openSession starts a shell on target for the operator at term, it is not passed its output until it is given to keep
+func openSession(console *Console, term *terminal.Terminal, id string, target ssh.Conn, pty internal.PtyReq, shell string) (*liveSession, error) {
+	//Attempt to connect to remote host and send inital pty request and screen size
+	// If we cant, report and error to the clients terminal
+	channel, err := createSession(target, pty, shell)
+	if err != nil {
+		console.Log.Error("Creating session failed: %s", err)
+		return nil, err
+	}
+
+	console.Log.Info("Connected to %s", target.RemoteAddr().String())
+	clients.RecordSession(id, "shell", console.User.ConnectionDetails)
+
+	screen := &sessionScreen{in: term}
+	return &liveSession{
+		id:       id,
+		hostname: clients.NormaliseHostname(target.User()),
+		started:  time.Now(),
+		target:   target,
+		channel:  channel,
+		screen:   screen,
+		input:    screen,
+		output:   screen,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+var (
+	liveLock sync.Mutex
+	live     = map[*internal.User][]*liveSession{}
+)
+
+// keep numbers a new session with the lowest number the operator isn't using, and starts passing on its output. Sessions are
+// closed when the operator disconnects, nobody could come back to them
+func keep(user *internal.User, s *liveSession) {
+	liveLock.Lock()
+	defer liveLock.Unlock()
+
+	sessions, watching := live[user]
+
+	s.n = 1
+	for _, other := range sessions {
+		if other.n == s.n {
+			s.n++
+		}
+	}
+
+	sessions = append(sessions, s)
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].n < sessions[j].n
+	})
+	live[user] = sessions
+
+	go func() {
+		io.Copy(s.output, s.channel)
+		s.channel.Close()
+		forget(user, s)
+		close(s.done)
+	}()
+
+	if !watching && user.ServerConnection != nil {
+		go func() {
+			user.ServerConnection.Wait()
+			for _, s := range sessionsOf(user) {
+				s.channel.Close()
+			}
+		}()
+	}
+}
+
+func forget(user *internal.User, s *liveSession) {
+	liveLock.Lock()
+	defer liveLock.Unlock()
+
+	sessions := live[user]
+	for i, other := range sessions {
+		if other == s {
+			live[user] = append(sessions[:i:i], sessions[i+1:]...)
+			break
+		}
+	}
+}
+
+func sessionsOf(user *internal.User) []*liveSession {
+	liveLock.Lock()
+	defer liveLock.Unlock()
+
+	return append([]*liveSession{}, live[user]...)
+}
+
+func sessionNumbered(user *internal.User, n int) *liveSession {
+	for _, s := range sessionsOf(user) {
+		if s.n == n {
+			return s
+		}
+	}
+	return nil
+}
+
+// after is the session following current, going back round to the first
+func after(user *internal.User, current *liveSession) *liveSession {
+	sessions := sessionsOf(user)
+	for _, s := range sessions {
+		if s.n > current.n {
+			return s
+		}
+	}
+
+	if len(sessions) > 0 {
+		return sessions[0]
+	}
+	return nil
+}
+
+// sessionKeyReader finds the ~ escapes in what the operator types, which like ssh's are only noticed at the start of a line. Only
+// the keys in escapes are taken, ~ followed by anything else is passed on as it was typed
+type sessionKeyReader struct {
+	escapes            string
+	lineStart, escaped bool
+}
+
+// keys returns what is passed on to the session, and the escape typed if there was one. Anything typed after an escape comes
+// back with the next call
+func (k *sessionKeyReader) keys(b []byte) (pass []byte, escape byte, rest []byte) {
+	for i, c := range b {
+		if k.escaped {
+			k.escaped = false
+			switch c {
+			case '~':
+				pass = append(pass, '~')
+			default:
+				if strings.IndexByte(k.escapes, c) != -1 {
+					return pass, c, b[i+1:]
+				}
+				pass = append(pass, '~', c)
+			}
+			k.lineStart = c == '\r' || c == '\n'
+			continue
+		}
+
+		if k.lineStart && c == '~' {
+			k.escaped = true
+			continue
+		}
+
+		pass = append(pass, c)
+		k.lineStart = c == '\r' || c == '\n'
+	}
+
+	return pass, 0, nil
+}
+
+func windowChange(channel ssh.Channel, columns, rows uint32) {
+	channel.SendRequest("window-change", false, ssh.Marshal(struct {
+		Columns, Rows, Width, Height uint32
+	}{columns, rows, 0, 0}))
+}
+
+func listSessions(tty io.Writer, user *internal.User, current *liveSession, newline string) {
+	for _, s := range sessionsOf(user) {
+		mark := " "
+		if s == current {
+			mark = "*"
+		}
+
+		waiting := ""
+		if n := s.screen.waiting(); n > 0 {
+			waiting = ", " + internal.FormatBytes(uint64(n)) + " of output waiting"
+		}
+
+		if n := s.screen.watching(); n != nil && n.hasMatched() {
+			waiting += fmt.Sprintf(", matched '%s'", n.pattern)
+		}
+
+		fmt.Fprintf(tty, "%s%d %s (%s) started %s ago%s%s", mark, s.n, s.hostname, s.id, time.Since(s.started).Round(time.Second), waiting, newline)
+	}
+}
+
+// attach puts term on s, where the operator can switch between their sessions with ~ escapes. It returns errDetached once they
+// detach, or nil when the session they are on ends
+func attach(term *terminal.Terminal, user *internal.User, s *liveSession) error {
+	var (
+		current  = s
+		switched = make(chan *liveSession)
+		detached = make(chan struct{})
+		finished = make(chan struct{})
+	)
+	defer close(finished)
+
+	show := func(s *liveSession) {
+		term.SetTitle(Title(user, clients.NormaliseHostname(s.target.User())))
+		s.screen.show(term)
+
+		// So full screen programs redraw for the terminal as it is now
+		if user.Pty != nil {
+			windowChange(s.channel, user.Pty.Columns, user.Pty.Rows)
+		}
+	}
+	show(current)
+
+	go func() {
+		var (
+			escapes = sessionKeyReader{escapes: "dnl123456789", lineStart: true}
+			on      = current
+			buf     = make([]byte, 4096)
+		)
+
+		for {
+			n, err := on.input.Read(buf)
+
+			select {
+			case <-finished:
+				// The session ended while this was waiting for a key, which belongs to the console now
+				return
+			default:
+			}
+
+			if err != nil {
+				on.screen.hide()
+				close(detached)
+				return
+			}
+
+			rest := buf[:n]
+			for len(rest) > 0 {
+				var (
+					pass   []byte
+					escape byte
+				)
+				pass, escape, rest = escapes.keys(rest)
+				if len(pass) > 0 {
+					on.channel.Write(pass)
+				}
+
+				var next *liveSession
+				switch {
+				case escape == 0:
+					continue
+				case escape == 'd':
+					on.screen.hide()
+					close(detached)
+					return
+				case escape == 'l':
+					fmt.Fprint(term, "\r\n")
+					listSessions(term, user, on, "\r\n")
+					fmt.Fprintf(term, "[rssh] %s\r\n", sessionKeys)
+					continue
+				case escape == 'n':
+					next = after(user, on)
+				default:
+					number, _ := strconv.Atoi(string(escape))
+					if next = sessionNumbered(user, number); next == nil {
+						fmt.Fprintf(term, "\r\n[rssh] No session %d\r\n", number)
+						continue
+					}
+				}
+
+				if next == nil || next == on {
+					continue
+				}
+
+				on.screen.hide()
+				fmt.Fprintf(term, "\r\n[rssh] Switched to session %d, %s (%s)\r\n", next.n, next.hostname, next.id)
+				on = next
+				escapes.lineStart = true
+
+				select {
+				case switched <- on:
+				case <-finished:
+					return
+				}
+				show(on)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case r := <-user.ShellRequests:
+			if r == nil {
+				current.screen.hide()
+				return errDetached
+			}
+
+			response, err := internal.SendRequest(*r, current.channel)
+			if err != nil && r.WantReply {
+				r.Reply(false, nil)
+				continue
+			}
+
+			if r.WantReply {
+				r.Reply(response, nil)
+			}
+
+			if r.Type == "window-change" && user.Pty != nil {
+				w, h := internal.ParseDims(r.Payload)
+				user.Pty.Columns, user.Pty.Rows = w, h
+			}
+		case current = <-switched:
+		case <-detached:
+			return errDetached
+		case <-current.done:
+			return nil
+		}
+	}
+}
+
+type sessionsCommand struct {
+}
+
+func (sc *sessionsCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if len(line.Arguments) == 0 {
+		if len(sessionsOf(console.User)) == 0 {
+			fmt.Fprintf(tty, "No sessions, start one with connect\n")
+			return nil
+		}
+
+		listSessions(tty, console.User, nil, "\n")
+		return nil
+	}
+
+	if console.User.Pty == nil {
+		return fmt.Errorf("Attaching to a session requires a pty")
+	}
+
+	term, ok := tty.(*terminal.Terminal)
+	if !ok {
+		return fmt.Errorf("sessions can only attach from the terminal")
+	}
+
+	number, err := strconv.Atoi(line.Arguments[0].Value())
+	if err != nil {
+		return terminal.Errorf(terminal.Usage, "%s", sc.Help(false))
+	}
+
+	s := sessionNumbered(console.User, number)
+	if s == nil {
+		return terminal.Errorf(terminal.NotFound, "No session %d", number)
+	}
+
+	term.EnableRaw()
+	defer term.DisableRaw()
+
+	console.Log.Info("Attached to session %d on %s", s.n, s.target.RemoteAddr())
+	if err := attach(term, console.User, s); err == errDetached {
+		return nil
+	}
+
+	return fmt.Errorf("Session has terminated.")
+}
+
+func (sc *sessionsCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (sc *sessionsCommand) Help(explain bool) string {
+	if explain {
+		return "List the shells started with connect, or attach to one"
+	}
+
+	return terminal.MakeHelpText(
+		"sessions [number]",
+		"While in a session, at the start of a line: "+sessionKeys,
+	)
+}
@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/toolpacks"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Printed by the last line of a script, so a script that stopped part way is told apart from one that finished
+const scriptDone = "rssh-script-done"
+
+// toolFiles is how deploy and undeploy change files on a client
+type toolFiles interface {
+	exists(p string) bool
+	mkdirAll(dir string) error
+	// write puts f at target and checks the checksum of what the client ended up with
+	write(p toolpacks.Pack, f toolpacks.File, target string) error
+	// remove deletes a file, it is not an error if it is already gone
+	remove(p string) error
+	// removeEmpty deletes a directory if nothing is left in it
+	removeEmpty(dir string)
+}
+
+// openToolFiles uses sftp where the client has it. A unix client built without file transfers is written to by sending printf
+// commands to sh instead, the same as encode --printf, which is slower but needs nothing else on the client
+func openToolFiles(id string, conn *ssh.ServerConn, by, subsystem string) (t toolFiles, end func(), err error) {
+	if clients.HasCapability(id, "transfer") {
+		c, end, err := openSFTP(conn, subsystem)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sftpFiles{c}, end, nil
+	}
+
+	if clients.OS(string(conn.ClientVersion())) == "windows" {
+		return nil, nil, fmt.Errorf("%s was built without file transfers, and windows has no sh to write files with instead", id)
+	}
+
+	if err := clients.Require(id, "exec-stdin", "writing files without file transfers"); err != nil {
+		return nil, nil, err
+	}
+
+	return &shellFiles{id: id, conn: conn, by: by}, func() {}, nil
+}
+
+type sftpFiles struct {
+	c *sftp.Client
+}
+
+func (s sftpFiles) exists(p string) bool {
+	_, err := s.c.Stat(p)
+	return err == nil
+}
+
+func (s sftpFiles) mkdirAll(dir string) error {
+	return s.c.MkdirAll(dir)
+}
+
+func (s sftpFiles) write(p toolpacks.Pack, f toolpacks.File, target string) error {
+	return sendTool(s.c, p, f, target)
+}
+
+func (s sftpFiles) remove(p string) error {
+	if err := s.c.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s sftpFiles) removeEmpty(dir string) {
+	s.c.RemoveDirectory(dir)
+}
+
+// shellFiles changes files on a client by running scripts in sh through exec
+type shellFiles struct {
+	id   string
+	conn *ssh.ServerConn
+	by   string
+}
+
+// run sends script to sh on the client, returning what it printed before the end. It fails if the script stopped before then
+func (s *shellFiles) run(script io.Reader) (string, error) {
+	var output bytes.Buffer
+
+	body := io.MultiReader(strings.NewReader("set -e\n"), script, strings.NewReader("echo "+scriptDone+"\n"))
+	if err := execOn(s.id, s.conn, s.by, ssh.Marshal(&internal.ShellStruct{Cmd: "sh"}), body, &output); err != nil {
+		return "", err
+	}
+
+	printed := strings.TrimSpace(output.String())
+	if !strings.HasSuffix(printed, scriptDone) {
+		lines := strings.Split(printed, "\n")
+		return "", fmt.Errorf("sh on the client stopped: %s", strings.TrimSpace(lines[len(lines)-1]))
+	}
+
+	return strings.TrimSuffix(printed, scriptDone), nil
+}
+
+func (s *shellFiles) exists(p string) bool {
+	printed, err := s.run(strings.NewReader(fmt.Sprintf("[ ! -e %s ] || echo exists\n", shellQuote(p))))
+	return err == nil && strings.TrimSpace(printed) == "exists"
+}
+
+func (s *shellFiles) mkdirAll(dir string) error {
+	_, err := s.run(strings.NewReader(fmt.Sprintf("mkdir -p %s\n", shellQuote(dir))))
+	return err
+}
+
+func (s *shellFiles) write(p toolpacks.Pack, f toolpacks.File, target string) error {
+	src, err := toolpacks.Open(p, f)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	sent := sha256.New()
+
+	var (
+		pr, pw  = io.Pipe()
+		written = make(chan error, 1)
+	)
+	go func() {
+		err := printfScript(pw, io.TeeReader(src, sent), target)
+		if err == nil {
+			// sha256sum is in coreutils and busybox, shasum on macOS and the BSDs
+			_, err = fmt.Fprintf(pw, "chmod %o %[2]s\n(sha256sum %[2]s || shasum -a 256 %[2]s || echo unchecked) 2>/dev/null\n", f.Mode, shellQuote(target))
+		}
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	printed, err := s.run(pr)
+	// Stops the script being written if the client went away before reading all of it
+	pr.Close()
+	if scriptErr := <-written; err == nil {
+		err = scriptErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(sent.Sum(nil)) != f.SHA256 {
+		return errors.New("the server's copy has changed since the pack was added")
+	}
+
+	fields := strings.Fields(printed)
+	if len(fields) == 1 && fields[0] == "unchecked" {
+		return errors.New("written, but the client has neither sha256sum nor shasum to check it with")
+	}
+	if len(fields) == 0 || fields[0] != f.SHA256 {
+		return errors.New("the client's copy does not match the checksum")
+	}
+
+	return nil
+}
+
+func (s *shellFiles) remove(p string) error {
+	_, err := s.run(strings.NewReader(fmt.Sprintf("rm -f %s\n", shellQuote(p))))
+	return err
+}
+
+func (s *shellFiles) removeEmpty(dir string) {
+	s.run(strings.NewReader(fmt.Sprintf("rmdir %s 2>/dev/null || true\n", shellQuote(dir))))
+}
@@ -0,0 +1,378 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/internal/terminal/vt"
+)
+
+const splitKeys = "~o the other pane, ~d leave the split with both still running, ~~ a literal ~"
+
+// Output arriving this close together is drawn at once
+const splitRedraw = 15 * time.Millisecond
+
+// A pane of the split view, one session drawn into part of the operator's terminal. row and column are its top left corner, from 1
+type pane struct {
+	s           *liveSession
+	screen      *vt.Screen
+	row, column int
+
+	redraw chan<- struct{}
+}
+
+func (p *pane) Write(b []byte) (int, error) {
+	p.screen.Write(b)
+
+	select {
+	case p.redraw <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+type split struct {
+}
+
+func (sp *split) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if console.User.Pty == nil {
+		return fmt.Errorf("Split requires a pty")
+	}
+
+	term, ok := tty.(*terminal.Terminal)
+	if !ok || term.Dumb() {
+		return fmt.Errorf("split can only be used from a terminal that understands escape sequences")
+	}
+
+	if len(line.Arguments) != 2 {
+		return terminal.Errorf(terminal.Usage, "%s", sp.Help(false))
+	}
+
+	stacked := line.IsSet("stacked")
+	if _, _, ok := splitLayout(int(console.User.Pty.Columns), int(console.User.Pty.Rows), stacked); !ok {
+		return fmt.Errorf("The terminal is too small to split")
+	}
+
+	var sessions [2]*liveSession
+	for i, arg := range line.Arguments {
+		// A number is a session already running, anything else a client to start one on
+		if n, err := strconv.Atoi(arg.Value()); err == nil {
+			if sessions[i] = sessionNumbered(console.User, n); sessions[i] == nil {
+				return terminal.Errorf(terminal.NotFound, "No session %d", n)
+			}
+			continue
+		}
+
+		id, target, err := resolveOne(tty, arg.Value())
+		if err != nil {
+			return err
+		}
+
+		s, err := openSession(console, term, id, target, *console.User.Pty, "")
+		if err != nil {
+			return err
+		}
+		keep(console.User, s)
+		sessions[i] = s
+	}
+
+	if sessions[0] == sessions[1] {
+		return fmt.Errorf("Split needs two different sessions")
+	}
+
+	term.EnableRaw()
+	defer term.DisableRaw()
+
+	if splitView(term, console.User, sessions, stacked) == errDetached {
+		fmt.Fprintf(term, "[rssh] Left the split, both sessions are still running. See them with: sessions\r\n")
+		return nil
+	}
+
+	return fmt.Errorf("Session has terminated.")
+}
+
+// splitLayout places the two panes in a terminal of width by height, leaving a line between them and the bottom line for the
+// status. Each is given as column, row, width and height
+func splitLayout(width, height int, stacked bool) (first, second [4]int, ok bool) {
+	if stacked {
+		top := (height - 2) / 2
+		first = [4]int{1, 1, width, top}
+		second = [4]int{1, top + 2, width, height - 2 - top}
+	} else {
+		left := (width - 1) / 2
+		first = [4]int{1, 1, left, height - 1}
+		second = [4]int{left + 2, 1, width - left - 1, height - 1}
+	}
+
+	return first, second, first[2] >= 10 && first[3] >= 2 && second[2] >= 10 && second[3] >= 2
+}
+
+// splitView draws both sessions into term until the operator leaves with ~d, returning errDetached, or one of them ends
+func splitView(term *terminal.Terminal, user *internal.User, sessions [2]*liveSession, stacked bool) error {
+	var (
+		drawLock sync.Mutex
+		focus    = 0
+		panes    [2]*pane
+		frame    = true
+
+		redraw   = make(chan struct{}, 1)
+		switched = make(chan int)
+		detached = make(chan struct{})
+		finished = make(chan struct{})
+	)
+
+	place := func(width, height int) {
+		first, second, _ := splitLayout(width, height, stacked)
+		for i, at := range [2][4]int{first, second} {
+			panes[i].column, panes[i].row = at[0], at[1]
+			panes[i].screen.Resize(at[2], at[3])
+			windowChange(panes[i].s.channel, uint32(at[2]), uint32(at[3]))
+		}
+	}
+
+	for i, s := range sessions {
+		panes[i] = &pane{s: s, screen: vt.New(1, 1), redraw: redraw}
+	}
+	place(int(user.Pty.Columns), int(user.Pty.Rows))
+
+	term.Write([]byte("\x1b[?1049h\x1b[2J"))
+	term.SetTitle(Title(user, sessions[0].hostname+" | "+sessions[1].hostname))
+	for _, p := range panes {
+		p.s.screen.show(p)
+	}
+
+	draw := func() {
+		drawLock.Lock()
+		defer drawLock.Unlock()
+
+		select {
+		case <-finished:
+			return
+		default:
+		}
+
+		var out bytes.Buffer
+		out.WriteString("\x1b[?25l")
+		if frame {
+			frame = false
+			splitFrame(&out, panes, focus, int(user.Pty.Columns), int(user.Pty.Rows), stacked)
+		}
+
+		for _, p := range panes {
+			p.screen.Render(&out, p.row, p.column)
+		}
+
+		on := panes[focus]
+		x, y, visible := on.screen.Cursor()
+		fmt.Fprintf(&out, "\x1b[%d;%dH", on.row+y, on.column+x)
+		if visible {
+			out.WriteString("\x1b[?25h")
+		}
+
+		term.Write(out.Bytes())
+	}
+
+	go func() {
+		for {
+			select {
+			case <-redraw:
+				time.Sleep(splitRedraw)
+				draw()
+			case <-finished:
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		drawLock.Lock()
+		close(finished)
+		drawLock.Unlock()
+
+		for _, p := range panes {
+			p.s.screen.hide()
+			windowChange(p.s.channel, user.Pty.Columns, user.Pty.Rows)
+		}
+		term.Write([]byte("\x1b[0m\x1b[?25h\x1b[?1049l"))
+	}()
+
+	// Keys go to the pane with focus, which only this changes
+	go func() {
+		var (
+			escapes = sessionKeyReader{escapes: "do", lineStart: true}
+			on      = 0
+			buf     = make([]byte, 4096)
+		)
+
+		for {
+			n, err := panes[on].s.input.Read(buf)
+
+			select {
+			case <-finished:
+				return
+			default:
+			}
+
+			if err != nil {
+				close(detached)
+				return
+			}
+
+			rest := buf[:n]
+			for len(rest) > 0 {
+				var (
+					pass   []byte
+					escape byte
+				)
+				pass, escape, rest = escapes.keys(rest)
+				if len(pass) > 0 {
+					panes[on].s.channel.Write(pass)
+				}
+
+				switch escape {
+				case 'd':
+					close(detached)
+					return
+				case 'o':
+					on = 1 - on
+					escapes.lineStart = true
+
+					select {
+					case switched <- on:
+					case <-finished:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	redraw <- struct{}{}
+	for {
+		select {
+		case r := <-user.ShellRequests:
+			if r == nil {
+				return errDetached
+			}
+
+			if r.Type == "window-change" {
+				w, h := internal.ParseDims(r.Payload)
+				if r.WantReply {
+					r.Reply(true, nil)
+				}
+
+				drawLock.Lock()
+				user.Pty.Columns, user.Pty.Rows = w, h
+				place(int(w), int(h))
+				frame = true
+				term.Write([]byte("\x1b[2J"))
+				drawLock.Unlock()
+
+				for _, p := range panes {
+					p.screen.Touch()
+				}
+			} else {
+				response, err := internal.SendRequest(*r, panes[focus].s.channel)
+				if r.WantReply {
+					r.Reply(response && err == nil, nil)
+				}
+			}
+		case on := <-switched:
+			drawLock.Lock()
+			focus, frame = on, true
+			drawLock.Unlock()
+		case <-detached:
+			return errDetached
+		case <-panes[0].s.done:
+			return nil
+		case <-panes[1].s.done:
+			return nil
+		}
+
+		select {
+		case redraw <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// splitFrame draws the line between the panes and the status line naming them, the one with focus is highlighted
+func splitFrame(out *bytes.Buffer, panes [2]*pane, focus, width, height int, stacked bool) {
+	if stacked {
+		fmt.Fprintf(out, "\x1b[%d;1H\x1b[0m%s", panes[1].row-1, strings.Repeat("─", width))
+	} else {
+		for row := 1; row < height; row++ {
+			fmt.Fprintf(out, "\x1b[%d;%dH\x1b[0m│", row, panes[1].column-1)
+		}
+	}
+
+	status := ""
+	for i, p := range panes {
+		name := fmt.Sprintf(" %d %s (%s) ", p.s.n, p.s.hostname, p.s.id)
+		if i == focus {
+			name = "\x1b[7m" + name + "\x1b[0m"
+		}
+		status += name
+	}
+	status += " " + splitKeys
+
+	fmt.Fprintf(out, "\x1b[%d;1H\x1b[0m\x1b[2K%s", height, truncateStatus(status, width))
+}
+
+// truncateStatus cuts the status line to width columns, not counting the escape sequences in it
+func truncateStatus(status string, width int) string {
+	var (
+		out     strings.Builder
+		columns int
+		inEsc   bool
+	)
+
+	for _, r := range status {
+		switch {
+		case r == 0x1b:
+			inEsc = true
+		case inEsc:
+			if r >= 0x40 && r <= 0x7e && r != '[' {
+				inEsc = false
+			}
+		default:
+			if columns == width {
+				continue
+			}
+			columns++
+		}
+		out.WriteRune(r)
+	}
+
+	return out.String() + "\x1b[0m"
+}
+
+func (sp *split) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 2 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (sp *split) Help(explain bool) string {
+	if explain {
+		return "Show two sessions side by side, typing into one at a time"
+	}
+
+	return terminal.MakeHelpText(
+		"split [OPTIONS] <session number or "+autocomplete.RemoteId+"> <session number or "+autocomplete.RemoteId+">",
+		"Clients are given a new session, which keeps running after the split like those started with connect",
+		"\t--stacked\tPut one session above the other rather than side by side",
+		"At the start of a line: "+splitKeys,
+	)
+}
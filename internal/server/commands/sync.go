@@ -0,0 +1,343 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"github.com/NHAS/reverse_ssh/internal/server/manifests"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/pkg/delta"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+type syncCommand struct {
+}
+
+// syncFile is a file in the directory being synced, signed once and sent to every client
+type syncFile struct {
+	rel  string
+	path string
+	info fs.FileInfo
+	sig  delta.Signature
+}
+
+type syncResult struct {
+	files, changed int
+	sent, total    int64
+}
+
+func (s *syncCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", s.Help(false))
+		return nil
+	}
+
+	args := positional(line, "parallel")
+	if len(args) < 3 {
+		return terminal.Errorf(terminal.Usage, "%s", s.Help(false))
+	}
+
+	parallel, batch, err := fanOut(line)
+	if err != nil {
+		return err
+	}
+	if batch > 0 {
+		return terminal.Errorf(terminal.Usage, "sync takes --parallel, not --batch")
+	}
+
+	if err := lockdown.Check(lockdown.Transfers); err != nil {
+		return err
+	}
+
+	// Only what the server already offers clients from downloads/ can be pushed to them
+	local := filepath.Join(console.DataDir, "downloads", filepath.Join("/", args[1].Value()))
+	remote := strings.TrimSpace(line.RawLine[args[1].End():])
+
+	files, err := syncFiles(local)
+	if os.IsNotExist(err) {
+		return terminal.Errorf(terminal.NotFound, "%s is not in the downloads directory", args[1].Value())
+	}
+	if err != nil {
+		return err
+	}
+
+	matchingClients, err := resolve(tty, args[0].Value())
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(matchingClients))
+	for id := range matchingClients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var (
+		wg      sync.WaitGroup
+		lck     sync.Mutex
+		failed  int
+		workers = make(chan struct{}, parallel)
+	)
+
+	for _, id := range ids {
+		wg.Add(1)
+		workers <- struct{}{}
+
+		go func(id string, conn *ssh.ServerConn) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			r, err := syncTo(id, conn, console.User.ConnectionDetails, files, remote, line.IsSet("full"))
+
+			lck.Lock()
+			defer lck.Unlock()
+
+			if err != nil {
+				failed++
+				fmt.Fprintf(tty, "%s: %s\n", id, err)
+				return
+			}
+
+			audit.Log(console.User.ConnectionDetails, "sync", id, fmt.Sprintf("%s to %s, %d of %d files changed, %d of %d bytes sent", args[1].Value(), remote, r.changed, r.files, r.sent, r.total))
+			fmt.Fprintf(tty, "%s: %d of %d files changed, sent %s of %s\n", id, r.changed, r.files, byteSize(r.sent), byteSize(r.total))
+		}(id, matchingClients[id])
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		return terminal.Errorf(terminal.Failed, "Sync failed on %d of %d clients", failed, len(ids))
+	}
+
+	return nil
+}
+
+// syncFiles signs every regular file under root, anything else such as a link is left out
+func syncFiles(root string) ([]syncFile, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", filepath.Base(root))
+	}
+
+	var files []syncFile
+	err = filepath.Walk(root, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		sig, err := delta.Sign(f)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, syncFile{rel: filepath.ToSlash(rel), path: p, info: info, sig: sig})
+		return nil
+	})
+
+	return files, err
+}
+
+// syncTo brings remote on one client up to date with files. Where the manifest says what the client has, and the client's copy
+// has not been touched since, only the blocks that differ are written
+func syncTo(id string, conn *ssh.ServerConn, by string, files []syncFile, remote string, full bool) (r syncResult, err error) {
+	if err := clients.Require(id, "transfer", "file transfers"); err != nil {
+		return r, err
+	}
+
+	fingerprint := conn.Permissions.Extensions["pubkey-fp"]
+	manifest, err := manifests.Load(fingerprint, remote)
+	if err != nil {
+		return r, err
+	}
+
+	c, end, err := openSFTP(conn, lockdown.Transfers)
+	if err != nil {
+		return r, err
+	}
+	defer end()
+
+	clients.RecordSession(id, "sync "+remote, by)
+
+	// Whatever was written is kept even if a later file fails, the next sync carries on from it
+	defer func() {
+		if saveErr := manifests.Save(fingerprint, remote, manifest); saveErr != nil && err == nil {
+			err = fmt.Errorf("unable to save the manifest, the next sync will send everything again: %s", saveErr)
+		}
+	}()
+
+	for _, f := range files {
+		r.files++
+		r.total += f.sig.Size
+
+		target := path.Join(remote, f.rel)
+		cached, known := manifest[f.rel]
+		if known && !full {
+			if st, err := c.Lstat(target); err != nil || st.Size() != cached.Size || !st.ModTime().Equal(cached.Modified) {
+				known = false
+			}
+		} else {
+			known = false
+		}
+
+		if known && cached.SHA256 == f.sig.SHA256 && cached.Mode == f.info.Mode().Perm() {
+			continue
+		}
+
+		// Forgotten until written, so a sync that fails part way through does not trust a half written file
+		delete(manifest, f.rel)
+		r.changed++
+
+		ranges := delta.Whole(f.sig.Size)
+		if known {
+			ranges = delta.Changed(cached.Signature, f.sig)
+		}
+
+		sent, err := writeRanges(c, f, target, ranges, !known)
+		r.sent += sent
+		if err != nil {
+			return r, fmt.Errorf("%s: %s", target, err)
+		}
+
+		st, err := c.Lstat(target)
+		if err != nil {
+			return r, fmt.Errorf("%s: %s", target, err)
+		}
+
+		manifest[f.rel] = manifests.File{Signature: f.sig, Mode: f.info.Mode().Perm(), Modified: st.ModTime()}
+	}
+
+	return r, nil
+}
+
+// writeRanges writes the parts of a local file given by ranges into target, starting it afresh when whole is set
+func writeRanges(c *sftp.Client, f syncFile, target string, ranges []delta.Range, whole bool) (int64, error) {
+	if err := c.MkdirAll(path.Dir(target)); err != nil {
+		return 0, err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if whole {
+		flags |= os.O_TRUNC
+	}
+
+	dst, err := c.OpenFile(target, flags)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	src, err := os.Open(f.path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	var sent int64
+	for _, rng := range ranges {
+		n, err := io.Copy(&offsetWriter{w: dst, offset: rng.Offset}, io.NewSectionReader(src, rng.Offset, rng.Length))
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+		if n != rng.Length {
+			return sent, errors.New("changed while it was being sent")
+		}
+	}
+
+	if err := dst.Truncate(f.sig.Size); err != nil {
+		return sent, err
+	}
+
+	if err := dst.Chmod(f.info.Mode().Perm()); err != nil {
+		return sent, err
+	}
+
+	// Closed before the time is set, so nothing the client does on close moves it on again
+	if err := dst.Close(); err != nil {
+		return sent, err
+	}
+
+	return sent, c.Chtimes(target, f.info.ModTime(), f.info.ModTime())
+}
+
+// offsetWriter writes at successive offsets of a file, as an io.Copy into the middle of it needs
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (o *offsetWriter) Write(b []byte) (int, error) {
+	n, err := o.w.WriteAt(b, o.offset)
+	o.offset += int64(n)
+	return n, err
+}
+
+// byteSize reads a byte count the way people do
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func (s *syncCommand) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (s *syncCommand) Help(explain bool) string {
+	if explain {
+		return "Push a directory to clients, sending only what changed since the last push"
+	}
+
+	return terminal.MakeHelpText(
+		"sync [OPTIONS] <clients> <directory> <remote directory>",
+		"Copies a directory from downloads/ in the data directory to the same place on every matching client. The server keeps a manifest of what it left on each client, so a file that was pushed before only has its changed 64KB blocks sent again. Files on the client that were changed by anything else are sent whole",
+		"\t--parallel\tNumber of clients to sync at once, one by default",
+		"\t--full\tIgnore the manifest and send every file whole",
+	)
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/pkg/table"
+)
+
+// tableHelp documents the flags printTable takes, for the help of commands that print tables
+var tableHelp = []string{
+	"\t-w\tPrint tables at their full width instead of fitting them to the terminal",
+	"\t--csv\tPrint as csv",
+	"\t--json\tPrint as json",
+}
+
+// printTable writes t the way the operator asked for it. Tables are fitted to the terminal unless -w is given, and --csv or
+// --json print the same rows for scripts instead
+func printTable(tty io.Writer, line terminal.ParsedLine, t *table.Table) error {
+	switch {
+	case line.IsSet("csv"):
+		return t.WriteCSV(tty)
+	case line.IsSet("json"):
+		return t.WriteJSON(tty)
+	case line.IsSet("w"):
+		t.FprintWide(tty)
+	default:
+		t.Fprint(tty)
+	}
+	return nil
+}
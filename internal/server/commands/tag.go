@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type tag struct {
+}
+
+func (t *tag) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", t.Help(false))
+		return nil
+	}
+
+	if len(line.Arguments) == 0 {
+		return terminal.Errorf(terminal.Usage, "%s", t.Help(false))
+	}
+
+	if line.IsSet("rm") {
+		name, err := line.GetArgString("rm")
+		if err != nil || len(line.Arguments) < 2 {
+			return terminal.Errorf(terminal.Usage, "%s", t.Help(false))
+		}
+
+		r, _, err := clients.FindRecord(line.Arguments[len(line.Arguments)-1].Value())
+		if err != nil {
+			return err
+		}
+
+		if err := clients.RemoveTag(r.ID, name); err != nil {
+			return err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "tag-remove", r.ID, name)
+		fmt.Fprintf(tty, "Removed tag %s from %s\n", name, r.ID)
+		return nil
+	}
+
+	r, _, err := clients.FindRecord(line.Arguments[0].Value())
+	if err != nil {
+		return err
+	}
+
+	if len(line.Arguments) == 1 {
+		if len(r.Tags) == 0 {
+			fmt.Fprintf(tty, "%s has no tags\n", r.ID)
+			return nil
+		}
+
+		fmt.Fprintf(tty, "%s\n", strings.Join(r.Tags, " "))
+		return nil
+	}
+
+	tags := line.ArgumentsAsStrings()[1:]
+	if err := clients.AddTags(r.ID, tags); err != nil {
+		return err
+	}
+
+	audit.Log(console.User.ConnectionDetails, "tag-add", r.ID, strings.Join(tags, " "))
+	fmt.Fprintf(tty, "Tagged %s\n", r.ID)
+	return nil
+}
+
+func (t *tag) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (t *tag) Help(explain bool) string {
+	if explain {
+		return "Label clients so groups of them can be found together"
+	}
+
+	return terminal.MakeHelpText(
+		"tag <client> [tags...]",
+		"With tags, adds them to the client. Without, lists the client's tags. Tags are kept while the client is offline and searched with find tag=<tag>.",
+		"\t--rm <tag> <client>\tRemove a tag",
+	)
+}
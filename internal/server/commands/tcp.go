@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+)
+
+type tcp struct {
+}
+
+func (t *tcp) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") || len(line.Arguments) != 2 {
+		fmt.Fprintf(tty, "%s", t.Help(false))
+		return nil
+	}
+
+	client := line.Arguments[0].Value()
+	address := line.Arguments[1].Value()
+
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a host:port: %s", address, err)
+	}
+
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a valid port", portString)
+	}
+
+	id, target, err := resolveOne(tty, client)
+	if err != nil {
+		return err
+	}
+
+	if err := clients.Require(id, "forward", "forwarding"); err != nil {
+		return err
+	}
+
+	stream, err := clients.Dial(id, target, internal.ChannelOpenDirectMsg{
+		Raddr: host,
+		Rport: uint32(port),
+		Laddr: "127.0.0.1",
+	}, line.IsSet("compress"))
+	if err != nil {
+		return terminal.Errorf(terminal.Transport, "Unable to connect to %s from %s: %s", address, id, err).Because(err)
+	}
+	defer stream.Close()
+
+	term, interactive := tty.(*terminal.Terminal)
+	if !interactive {
+		// Without a console there is no line editing to do, so this behaves like netcat. The end of input isnt passed on, as the client
+		// closes the whole connection when it sees it and the reply would be lost
+		go io.Copy(stream, tty)
+
+		io.Copy(tty, stream)
+		return nil
+	}
+
+	lineEnding := "\r\n"
+	if line.IsSet("lf") {
+		lineEnding = "\n"
+	}
+
+	fmt.Fprintf(term, "Connected to %s through %s, Ctrl+C or Ctrl+D to disconnect\n", address, id)
+
+	closed := make(chan struct{})
+	disconnected := make(chan struct{})
+	defer close(disconnected)
+
+	go func() {
+		defer close(closed)
+		io.Copy(term, stream)
+
+		select {
+		case <-disconnected:
+		default:
+			// The read below cant be interrupted, so the operator has to hit enter to get back to the console
+			fmt.Fprintf(term, "\nConnection closed by %s, press enter to continue\n", address)
+		}
+	}()
+
+	for {
+		input, err := term.ReadLineWithPrompt(address + "> ")
+		if err != nil {
+			if errors.Is(err, terminal.ErrCtrlC) || errors.Is(err, terminal.ErrCtrlD) {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case <-closed:
+			return nil
+		default:
+		}
+
+		if _, err := io.WriteString(stream, input+lineEnding); err != nil {
+			return terminal.Errorf(terminal.Transport, "Connection to %s closed: %s", address, err).Because(err)
+		}
+	}
+}
+
+func (t *tcp) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (t *tcp) Help(explain bool) string {
+	if explain {
+		return "Open a raw tcp connection through a client"
+	}
+
+	return terminal.MakeHelpText(
+		"tcp [OPTIONS] <remote_id> <host:port>",
+		"Connects from the client to host:port and relays what you type to it a line at a time, for talking to services such as redis or smtp without setting up a forward.",
+		"When run with ssh exec (ssh server tcp <remote_id> <host:port>) input and output are passed through untouched, like netcat.",
+		"\t--lf\tEnd lines with \\n rather than \\r\\n",
+		"\t--compress\tCompress the connection between the server and client, always on for clients with compression turned on",
+	)
+}
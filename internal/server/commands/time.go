@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/timings"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/pkg/table"
+)
+
+type timeCommand struct {
+}
+
+// Compound so the line being timed runs exactly as typed
+func (t *timeCommand) Compound() {}
+
+func (t *timeCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	shell := terminal.ShellOf(tty)
+	if shell == nil {
+		return errNoShell
+	}
+
+	_, rest, err := shell.Words(line, 0)
+	if err != nil || rest == "" {
+		return terminal.Errorf(terminal.Usage, "%s", t.Help(false))
+	}
+
+	words := strings.Fields(rest)
+	switch words[0] {
+	case "-h", "--help":
+		fmt.Fprintf(tty, "%s", t.Help(false))
+		return nil
+
+	case "--slow":
+		n := 20
+		if len(words) > 1 {
+			n, err = strconv.Atoi(words[1])
+			if err != nil || n < 1 {
+				return terminal.Errorf(terminal.Usage, "%q is not a number of commands", words[1])
+			}
+		}
+
+		var slow []timings.Run
+		// A duress login sees a server nobody has used
+		if !console.User.Duress {
+			slow, err = timings.Slow(n)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(slow) == 0 {
+			fmt.Fprintf(tty, "No commands have taken longer than %s\n", timings.Threshold())
+			return nil
+		}
+
+		for _, r := range slow {
+			fmt.Fprintf(tty, "%s took %s, %s ran %q with %d clients connected", r.Finished.Format("2006/01/02 15:04:05"), took(r.Took), r.Operator, r.Line, r.Clients)
+			if r.Job != "" {
+				fmt.Fprintf(tty, " in job %s", r.Job)
+			}
+			if r.Error != "" {
+				fmt.Fprintf(tty, ", it failed: %s", r.Error)
+			}
+			fmt.Fprintln(tty)
+		}
+		return nil
+
+	case "--stats":
+		var stats []timings.Stat
+		if !console.User.Duress {
+			stats = timings.Stats()
+		}
+
+		if len(stats) == 0 {
+			fmt.Fprintf(tty, "No commands have been run\n")
+			return nil
+		}
+
+		tb, _ := table.NewTable("Command Timings", "Command", "Runs", "Average", "Slowest")
+		for _, s := range stats {
+			tb.AddValues(s.Command, strconv.Itoa(s.Runs), took(s.Total/time.Duration(s.Runs)), took(s.Slowest))
+		}
+		return printTable(tty, line, &tb)
+	}
+
+	started := time.Now()
+	err = shell.Execute(tty, rest)
+	fmt.Fprintf(tty, "Took %s\n", took(time.Since(started)))
+
+	return err
+}
+
+func (t *timeCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (t *timeCommand) Help(explain bool) string {
+	if explain {
+		return "Show how long a command takes, and which commands have been slow"
+	}
+
+	return terminal.MakeHelpText(
+		"time <command>",
+		"time --slow [n]",
+		"time --stats [-w|--csv|--json]",
+		"\t--slow\tShow the last n commands, 20 by default, that took longer than the slow command threshold, and who ran them",
+		"\t--stats\tShow how many times each command has run since the server started, and how long it took",
+		"Add {took} to the prompt to see how long every command takes",
+	)
+}
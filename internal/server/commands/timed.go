@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/timings"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// timedCommand measures every run of a command, for {took} in the prompt and the slow command log
+type timedCommand struct {
+	terminal.Command
+
+	name string
+}
+
+func (t *timedCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	started := time.Now()
+	err := t.Command.Run(tty, line)
+	took := time.Since(started)
+
+	console.lock.Lock()
+	console.took = took
+	console.lock.Unlock()
+
+	// What time runs is measured on its own, logging both would show every slow line twice
+	if t.name == "time" {
+		return err
+	}
+
+	run := timings.Run{
+		Finished: time.Now(),
+		Operator: console.User.ConnectionDetails,
+		Command:  t.name,
+		Line:     line.RawLine,
+		Took:     took,
+	}
+
+	// Only counted when it will be logged, it means searching every client
+	if threshold := timings.Threshold(); threshold > 0 && took >= threshold {
+		run.Clients = connectedClients(console.User)
+	}
+
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	if console.Job != nil {
+		run.Job = console.Job.ID()
+	}
+
+	if err := timings.Observe(run); err != nil {
+		log.Println("Unable to write to slow.log:", err)
+	}
+
+	return err
+}
+
+func (t *timedCommand) Unwrap() terminal.Command {
+	return t.Command
+}
+
+func timeCommands(m map[string]terminal.Command) map[string]terminal.Command {
+	timed := map[string]terminal.Command{}
+	for name, command := range m {
+		timed[name] = &timedCommand{Command: command, name: name}
+	}
+	return timed
+}
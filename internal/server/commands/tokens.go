@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/engagements"
+	"github.com/NHAS/reverse_ssh/internal/server/tokens"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type joinTokens struct {
+}
+
+func (t *joinTokens) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", t.Help(false))
+		return nil
+	}
+
+	if len(line.Arguments) == 0 || line.Arguments[0].Value() == "ls" {
+		list := tokens.List()
+		if len(list) == 0 {
+			fmt.Fprintf(tty, "No enrollment tokens\n")
+			return nil
+		}
+
+		now := time.Now()
+		for _, tok := range list {
+			state := "usable"
+			switch {
+			case tok.Used >= tok.Uses:
+				state = "used up"
+			case !now.Before(tok.Expires):
+				state = "expired"
+			}
+
+			fmt.Fprintf(tty, "%s used %d/%d expires %s engagement: %q comment: %q (%s)\n", tok.ID, tok.Used, tok.Uses, tok.Expires.Format(time.RFC3339), tok.Engagement, tok.Comment, state)
+		}
+		return nil
+	}
+
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can change enrollment tokens")
+	}
+
+	switch line.Arguments[0].Value() {
+	case "create":
+		uses, err := line.GetArgInt("uses")
+		if err == terminal.ErrFlagNotSet {
+			uses = 1
+		} else if err != nil {
+			return err
+		}
+
+		lifetime, err := line.GetArgDuration("expires")
+		if err == terminal.ErrFlagNotSet {
+			lifetime = 24 * time.Hour
+		} else if err != nil {
+			return err
+		}
+
+		engagement, _ := line.GetArgString("engagement")
+		if engagement != "" {
+			if _, ok := engagements.Get(engagement); !ok {
+				return fmt.Errorf("engagement %q not found", engagement)
+			}
+		}
+
+		comment, _ := line.GetArgString("comment")
+
+		secret, tok, err := tokens.Create(console.User.ConnectionDetails, uses, lifetime, engagement, comment)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tty, "Created token %s, it will not be shown again:\n%s\n", tok.ID, secret)
+		fmt.Fprintf(tty, "Enroll clients with: client --token %s <server>, or link --token %s\n", secret, secret)
+		return nil
+
+	case "rm":
+		if len(line.Arguments) < 2 {
+			return terminal.Errorf(terminal.Usage, "%s", t.Help(false))
+		}
+
+		return tokens.Delete(console.User.ConnectionDetails, line.Arguments[len(line.Arguments)-1].Value())
+	}
+
+	return fmt.Errorf("Unknown action '%s'", line.Arguments[0].Value())
+}
+
+func (t *joinTokens) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (t *joinTokens) Help(explain bool) string {
+	if explain {
+		return "Manage short lived tokens clients can enroll with"
+	}
+
+	return terminal.MakeHelpText(
+		"tokens [ls|create|rm] [OPTIONS] <id>",
+		"A client started with --token, or built with link --token, generates its own key and presents the token to have that key added to authorized_controllee_keys.",
+		"Each enrollment burns one use of the token.",
+		"\tls\tList tokens (default)",
+		"\tcreate\tCreate a token, the secret is only shown once",
+		"\trm\tDelete a token, clients already enrolled with it are unaffected",
+		"\t--uses\tHow many clients can enroll with the token (default 1)",
+		"\t--expires\tHow long the token is valid for (default 24h)",
+		"\t--engagement\tTag clients enrolled with the token with this engagement",
+		"\t--comment\tComment written next to enrolled keys",
+	)
+}
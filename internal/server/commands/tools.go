@@ -0,0 +1,473 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"github.com/NHAS/reverse_ssh/internal/server/toolpacks"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/pkg/table"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// The OSes a pack can be given a target directory for when it is added
+var toolTargets = []string{"linux", "windows", "darwin"}
+
+type toolsCommand struct {
+}
+
+func (t *toolsCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	args := positional(line, toolTargets...)
+	if line.IsSet("h") || len(args) == 0 {
+		fmt.Fprintf(tty, "%s", t.Help(false))
+		return nil
+	}
+
+	switch args[0].Value() {
+	case "add":
+		if len(args) < 3 {
+			return terminal.Errorf(terminal.Usage, "%s", t.Help(false))
+		}
+
+		// The files are read from wherever the server can see, which is not something every operator should have
+		if console.User.Role != internal.RoleAdmin {
+			return terminal.Errorf(terminal.Permission, "Only admins can add tool packs")
+		}
+
+		targets := map[string]string{}
+		for _, goos := range toolTargets {
+			if target, err := line.GetArgString(goos); err == nil {
+				targets[goos] = target
+			}
+		}
+
+		var sources []string
+		for _, a := range args[2:] {
+			sources = append(sources, a.Value())
+		}
+
+		p, err := toolpacks.Add(args[1].Value(), console.User.ConnectionDetails, sources, targets)
+		if err != nil {
+			return fmt.Errorf("Unable to add tool pack %s: %s", args[1].Value(), err)
+		}
+
+		audit.Log(console.User.ConnectionDetails, "tools-add", p.Name, fmt.Sprintf("%d files, %d bytes", len(p.Files), p.Size()))
+		fmt.Fprintf(tty, "Added %s, %d files (%s). Deploy it with: deploy <clients> %s\n", p.Name, len(p.Files), byteSize(p.Size()), p.Name)
+		return nil
+
+	case "ls":
+		packs := toolpacks.List()
+		if len(packs) == 0 {
+			fmt.Fprintf(tty, "No tool packs, add one with: tools add <name> <files>\n")
+			return nil
+		}
+
+		tb, _ := table.NewTable("Tool Packs", "Name", "Files", "Size", "Deployed To", "Added By", "Added")
+		for _, p := range packs {
+			tb.AddValues(p.Name, strconv.Itoa(len(p.Files)), byteSize(p.Size()), strconv.Itoa(len(toolpacks.Deployments(p.Name))), p.By, p.Added.Format(time.RFC3339))
+		}
+		return printTable(tty, line, &tb)
+
+	case "show":
+		if len(args) != 2 {
+			return terminal.Errorf(terminal.Usage, "%s", t.Help(false))
+		}
+
+		p, err := toolpacks.Get(args[1].Value())
+		if err != nil {
+			return terminal.Errorf(terminal.NotFound, "%s: %s", args[1].Value(), err)
+		}
+
+		tb, _ := table.NewTable("Files of "+p.Name, "Name", "Mode", "Size", "SHA256")
+		for _, f := range p.Files {
+			tb.AddValues(f.Name, f.Mode.String(), byteSize(f.Size), f.SHA256)
+		}
+
+		// Scripts asking for csv or json only want the rows
+		if err := printTable(tty, line, &tb); err != nil || line.IsSet("csv") || line.IsSet("json") {
+			return err
+		}
+
+		for _, goos := range toolTargets {
+			fmt.Fprintf(tty, "Target on %s: %s\n", goos, p.Target(goos))
+		}
+
+		for _, d := range toolpacks.Deployments(p.Name) {
+			fmt.Fprintf(tty, "Deployed to %s (%s) in %s by %s %s\n", d.Hostname, d.Fingerprint, d.Directory, d.By, d.Deployed.Format(time.RFC3339))
+		}
+		return nil
+
+	case "rm":
+		if len(args) != 2 {
+			return terminal.Errorf(terminal.Usage, "%s", t.Help(false))
+		}
+
+		if console.User.Role != internal.RoleAdmin {
+			return terminal.Errorf(terminal.Permission, "Only admins can remove tool packs")
+		}
+
+		if err := toolpacks.Remove(args[1].Value()); err != nil {
+			if err == toolpacks.ErrNotFound {
+				return terminal.Errorf(terminal.NotFound, "%s: %s", args[1].Value(), err)
+			}
+			return err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "tools-rm", args[1].Value(), "")
+		fmt.Fprintf(tty, "Removed %s\n", args[1].Value())
+		return nil
+	}
+
+	return terminal.Errorf(terminal.Usage, "%s", t.Help(false))
+}
+
+func (t *toolsCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (t *toolsCommand) Help(explain bool) string {
+	if explain {
+		return "Keep named packs of tools on the server to deploy to clients"
+	}
+
+	return terminal.MakeHelpText(append([]string{
+		"tools add [OPTIONS] <name> <files or directories...>",
+		"tools ls|show|rm [name]",
+		"Copies files from the server into a pack, keeping the checksum of each. Directories are added with everything in them. A pack is deployed into a directory of its own, by default /tmp/<name>, or C:/Windows/Temp/<name> on windows",
+		"\t--linux\tDirectory to deploy the pack to on linux clients",
+		"\t--windows\tDirectory to deploy the pack to on windows clients",
+		"\t--darwin\tDirectory to deploy the pack to on macOS clients",
+	}, tableHelp...)...)
+}
+
+// eachClient runs do against every client matching filter, parallel at a time, printing what each returned or the error it failed
+// with. It returns an error if any failed
+func eachClient(tty io.ReadWriter, line terminal.ParsedLine, filter, doing string, do func(id string, conn *ssh.ServerConn) (string, error)) error {
+	parallel, batch, err := fanOut(line)
+	if err != nil {
+		return err
+	}
+	if batch > 0 {
+		return terminal.Errorf(terminal.Usage, "%s takes --parallel, not --batch", doing)
+	}
+
+	matchingClients, err := resolve(tty, filter)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(matchingClients))
+	for id := range matchingClients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var (
+		wg      sync.WaitGroup
+		lck     sync.Mutex
+		failed  int
+		workers = make(chan struct{}, parallel)
+	)
+
+	for _, id := range ids {
+		wg.Add(1)
+		workers <- struct{}{}
+
+		go func(id string, conn *ssh.ServerConn) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			result, err := do(id, conn)
+
+			lck.Lock()
+			defer lck.Unlock()
+
+			if err != nil {
+				failed++
+				fmt.Fprintf(tty, "%s: %s\n", id, err)
+				return
+			}
+
+			fmt.Fprintf(tty, "%s: %s\n", id, result)
+		}(id, matchingClients[id])
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		return terminal.Errorf(terminal.Failed, "%s failed on %d of %d clients", doing, failed, len(ids))
+	}
+
+	return nil
+}
+
+type deploy struct {
+}
+
+func (d *deploy) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	args := positional(line, "parallel")
+	if line.IsSet("h") || len(args) != 2 {
+		fmt.Fprintf(tty, "%s", d.Help(false))
+		return nil
+	}
+
+	if err := lockdown.Check(lockdown.Transfers); err != nil {
+		return err
+	}
+
+	p, err := toolpacks.Get(args[1].Value())
+	if err != nil {
+		return terminal.Errorf(terminal.NotFound, "%s: %s", args[1].Value(), err)
+	}
+
+	return eachClient(tty, line, args[0].Value(), "Deploy", func(id string, conn *ssh.ServerConn) (string, error) {
+		deployment, err := deployTo(id, conn, console.User.ConnectionDetails, p)
+		if err != nil {
+			return "", err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "deploy", id, fmt.Sprintf("%s to %s, %d files", p.Name, deployment.Directory, len(deployment.Files)))
+		return fmt.Sprintf("deployed %s to %s, %d files checked", p.Name, deployment.Directory, len(deployment.Files)), nil
+	})
+}
+
+// deployTo writes every file of p to a client and records what it wrote, even when it fails part way, so undeploy can clean up
+func deployTo(id string, conn *ssh.ServerConn, by string, p toolpacks.Pack) (d toolpacks.Deployment, err error) {
+	d = toolpacks.Deployment{
+		Pack:        p.Name,
+		Fingerprint: conn.Permissions.Extensions["pubkey-fp"],
+		Hostname:    clients.NormaliseHostname(conn.User()),
+		Directory:   p.Target(clients.OS(string(conn.ClientVersion()))),
+		Deployed:    time.Now(),
+		By:          by,
+	}
+
+	// Deploying again, to put back a file that went missing, keeps the directory the first deploy made to be removed later
+	previous, err := toolpacks.Deployed(d.Fingerprint, p.Name)
+	if err == nil {
+		d.Created = previous.Created
+	}
+
+	files, end, err := openToolFiles(id, conn, by, lockdown.Transfers)
+	if err != nil {
+		return d, err
+	}
+	defer end()
+
+	clients.RecordSession(id, "deploy "+p.Name, by)
+
+	if !files.exists(d.Directory) {
+		d.Created = true
+	}
+
+	defer func() {
+		if recordErr := toolpacks.Record(d); recordErr != nil && err == nil {
+			err = fmt.Errorf("deployed, but unable to record it for undeploy: %s", recordErr)
+		}
+	}()
+
+	if err := files.mkdirAll(d.Directory); err != nil {
+		return d, fmt.Errorf("%s: %s", d.Directory, err)
+	}
+
+	for _, f := range p.Files {
+		target := path.Join(d.Directory, f.Name)
+		if path.Dir(target) != d.Directory {
+			if err := files.mkdirAll(path.Dir(target)); err != nil {
+				return d, fmt.Errorf("%s: %s", path.Dir(target), err)
+			}
+		}
+
+		// Kept before it is written, as a file that fails half way through still needs removing
+		d.Files = append(d.Files, target)
+		if err := files.write(p, f, target); err != nil {
+			return d, fmt.Errorf("%s: %s", target, err)
+		}
+	}
+
+	return d, nil
+}
+
+// sendTool writes one file of a pack to target and reads it back, so the checksum taken when the pack was added is checked
+// against what the server sent and what the client ended up with
+func sendTool(c *sftp.Client, p toolpacks.Pack, f toolpacks.File, target string) error {
+	src, err := toolpacks.Open(p, f)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := c.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	sent := sha256.New()
+	if _, err := io.Copy(dst, io.TeeReader(src, sent)); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(sent.Sum(nil)) != f.SHA256 {
+		return errors.New("the server's copy has changed since the pack was added")
+	}
+
+	if err := dst.Chmod(f.Mode); err != nil {
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	written, err := c.Open(target)
+	if err != nil {
+		return err
+	}
+	defer written.Close()
+
+	received := sha256.New()
+	if _, err := io.Copy(received, written); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(received.Sum(nil)) != f.SHA256 {
+		return errors.New("the client's copy does not match the checksum")
+	}
+
+	return nil
+}
+
+func (d *deploy) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (d *deploy) Help(explain bool) string {
+	if explain {
+		return "Put a tool pack on clients"
+	}
+
+	return terminal.MakeHelpText(
+		"deploy [OPTIONS] <clients> <pack>",
+		"Writes every file of a pack to its target directory on each matching client, then checks each against the checksum taken when the pack was added. What was written is recorded, so undeploy can remove it. Unix clients built without file transfers are sent the files as printf commands run by sh, slowly",
+		"\t--parallel\tNumber of clients to deploy to at once, one by default",
+	)
+}
+
+type undeploy struct {
+}
+
+func (u *undeploy) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	args := positional(line, "parallel")
+	if line.IsSet("h") || len(args) != 2 {
+		fmt.Fprintf(tty, "%s", u.Help(false))
+		return nil
+	}
+
+	if err := lockdown.Check(lockdown.Transfers); err != nil {
+		return err
+	}
+
+	pack := args[1].Value()
+	return eachClient(tty, line, args[0].Value(), "Undeploy", func(id string, conn *ssh.ServerConn) (string, error) {
+		removed, err := undeployFrom(id, conn, console.User.ConnectionDetails, pack)
+		if err != nil {
+			return "", err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "undeploy", id, fmt.Sprintf("%s, %d files removed", pack, removed))
+		return fmt.Sprintf("removed %d files of %s", removed, pack), nil
+	})
+}
+
+// undeployFrom removes what deploying pack wrote to a client, along with the directories it made once they are empty. Files that
+// could not be removed stay recorded, so undeploy can be tried again
+func undeployFrom(id string, conn *ssh.ServerConn, by, pack string) (removed int, err error) {
+	d, err := toolpacks.Deployed(conn.Permissions.Extensions["pubkey-fp"], pack)
+	if err != nil {
+		return 0, terminal.Errorf(terminal.NotFound, "%s", err)
+	}
+
+	files, end, err := openToolFiles(id, conn, by, lockdown.Transfers)
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+
+	clients.RecordSession(id, "undeploy "+pack, by)
+
+	var (
+		remaining []string
+		firstErr  error
+	)
+	for _, f := range d.Files {
+		if err := files.remove(f); err != nil {
+			remaining = append(remaining, f)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %s", f, err)
+			}
+			continue
+		}
+		removed++
+	}
+
+	// Directories with anything else put in them since are left, they are no longer only the pack's
+	for _, dir := range d.Directories() {
+		files.removeEmpty(dir)
+	}
+
+	d.Files = remaining
+	if len(remaining) == 0 {
+		d.Created = false
+	}
+
+	if err := toolpacks.Record(d); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return removed, firstErr
+}
+
+func (u *undeploy) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (u *undeploy) Help(explain bool) string {
+	if explain {
+		return "Remove a tool pack from clients"
+	}
+
+	return terminal.MakeHelpText(
+		"undeploy [OPTIONS] <clients> <pack>",
+		"Removes the files deploy wrote to each matching client, and the directories it made if nothing else has been put in them",
+		"\t--parallel\tNumber of clients to undeploy from at once, one by default",
+	)
+}
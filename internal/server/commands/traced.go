@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal/server/tracing"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+// tracedCommand records a span for every run of a command under the users connection
+type tracedCommand struct {
+	terminal.Command
+
+	name string
+}
+
+func (t *tracedCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if !tracing.Enabled() {
+		return t.Command.Run(tty, line)
+	}
+
+	span := tracing.New("command "+t.name, tracing.Of(consoleOf(tty).User.ServerConnection)).Set("rssh.command", t.name).Set("rssh.line", line.RawLine)
+	defer span.End()
+
+	err := t.Command.Run(tty, line)
+	span.Error(err)
+
+	return err
+}
+
+func (t *tracedCommand) Unwrap() terminal.Command {
+	return t.Command
+}
+
+func traceCommands(m map[string]terminal.Command) map[string]terminal.Command {
+	traced := map[string]terminal.Command{}
+	for name, command := range m {
+		traced[name] = &tracedCommand{Command: command, name: name}
+	}
+	return traced
+}
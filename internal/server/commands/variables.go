@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+var errNoShell = errors.New("This command only works from the console or an exec script")
+
+type set struct {
+}
+
+func (s *set) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", s.Help(false))
+		return nil
+	}
+
+	shell := terminal.ShellOf(tty)
+	if shell == nil {
+		return errNoShell
+	}
+
+	if len(line.Arguments) == 0 {
+		variables := shell.Variables()
+		if len(variables) == 0 {
+			fmt.Fprintf(tty, "No variables set\n")
+			return nil
+		}
+
+		for _, v := range variables {
+			fmt.Fprintf(tty, "%s\n", v)
+		}
+		return nil
+	}
+
+	if len(line.Arguments) != 1 {
+		return errors.New("set takes a single name=value, quote values that contain spaces")
+	}
+
+	assignment := line.Arguments[0].Value()
+
+	equals := strings.IndexByte(assignment, '=')
+	if equals == -1 {
+		return fmt.Errorf("expected name=value, got '%s'", assignment)
+	}
+
+	return shell.SetVariable(assignment[:equals], assignment[equals+1:])
+}
+
+func (s *set) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (s *set) Help(explain bool) string {
+	if explain {
+		return "Set a variable for use in later commands"
+	}
+
+	return terminal.MakeHelpText(
+		"set [name=value]",
+		"Variables are expanded anywhere in a command line with $name or ${name}, an unset variable expands to nothing. Text in single quotes and \\$ are left alone.",
+		"Variables last until the console or exec script ends. With no arguments every variable is listed.",
+	)
+}
+
+type unset struct {
+}
+
+func (u *unset) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	if line.IsSet("h") || len(line.Arguments) == 0 {
+		fmt.Fprintf(tty, "%s", u.Help(false))
+		return nil
+	}
+
+	shell := terminal.ShellOf(tty)
+	if shell == nil {
+		return errNoShell
+	}
+
+	for _, name := range line.ArgumentsAsStrings() {
+		shell.UnsetVariable(name)
+	}
+
+	return nil
+}
+
+func (u *unset) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (u *unset) Help(explain bool) string {
+	if explain {
+		return "Remove variables"
+	}
+
+	return terminal.MakeHelpText("unset <name>...")
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/vault"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+)
+
+type vaultCommand struct {
+}
+
+func (v *vaultCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") || len(line.Arguments) < 1 {
+		fmt.Fprintf(tty, "%s", v.Help(false))
+		return nil
+	}
+
+	action := line.Arguments[0].Value()
+	if action != "ls" && console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can change the vault")
+	}
+
+	switch action {
+	case "ls":
+		entries, err := vault.Entries(console.User.Role)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			fmt.Fprintf(tty, "%s", e.Name)
+			if len(e.Roles) > 0 {
+				fmt.Fprintf(tty, " (%s)", strings.Join(e.Roles, ", "))
+			}
+			fmt.Fprintf(tty, "\n")
+		}
+		return nil
+
+	case "set":
+		// Flag values are arguments too, so the name is always last
+		if len(line.Arguments) < 2 {
+			return errors.New("vault set [--engagement name] [--role role] <name>")
+		}
+		name := line.Arguments[len(line.Arguments)-1].Value()
+
+		if engagement, err := line.GetArgString("engagement"); err == nil {
+			name = engagement + "/" + name
+		}
+
+		var roles []string
+		if roleList, err := line.GetArgString("role"); err == nil {
+			roles = strings.Split(roleList, ",")
+		}
+		for _, r := range roles {
+			if !internal.ValidRole(r) {
+				return fmt.Errorf("Unknown role '%s'", r)
+			}
+		}
+
+		if !vault.ValidName(name) {
+			return fmt.Errorf("Invalid name '%s', use letters, numbers, _ . and -", name)
+		}
+
+		term, ok := tty.(*terminal.Terminal)
+		if !ok {
+			return errors.New("Setting a secret needs the interactive terminal so it isnt echoed")
+		}
+
+		secret, err := term.ReadPassword("Secret: ")
+		if err != nil {
+			return err
+		}
+
+		if secret == "" {
+			return errors.New("Not storing an empty secret")
+		}
+
+		err = vault.Set(name, []byte(secret), roles)
+		if err != nil {
+			return err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "vault-set", name, "roles: "+strings.Join(roles, ","))
+		fmt.Fprintf(tty, "Stored %s, use it as vault:%s\n", name, name)
+		return nil
+
+	case "rm":
+		if len(line.Arguments) != 2 {
+			return errors.New("vault rm <name>")
+		}
+		name := line.Arguments[1].Value()
+
+		err := vault.Delete(name)
+		if err != nil {
+			return err
+		}
+
+		audit.Log(console.User.ConnectionDetails, "vault-rm", name, "")
+		fmt.Fprintf(tty, "Removed %s\n", name)
+		return nil
+	}
+
+	return fmt.Errorf("Unknown vault action '%s'", action)
+}
+
+func (v *vaultCommand) Expect(line terminal.ParsedLine) []string {
+	return nil
+}
+
+func (v *vaultCommand) Help(explain bool) string {
+	if explain {
+		return "Manage secrets stored encrypted on the server"
+	}
+
+	return terminal.MakeHelpText(
+		"vault ls|set|rm [OPTIONS] [name]",
+		"Secrets are encrypted with a key in vault.key in the data directory, or derived from RSSH_VAULT_PASSPHRASE if the server was started with it set.",
+		"Values are never shown. Commands that send text to clients (exec, connect --shell) replace vault:name with the secret when they run, and connect --elevate can answer password prompts with one.",
+		"Only admins can set or remove secrets, other roles can only use secrets shared with them. Every use is audited.",
+		"\tls\tList secrets you can use",
+		"\tset\tPrompt for and store a secret",
+		"\t\t--engagement\tScope the secret to an engagement, it is then referenced as vault:engagement/name",
+		"\t\t--role\tComma seperated non admin roles allowed to use the secret",
+		"\trm\tRemove a secret",
+	)
+}
@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/gateway"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/internal/vpn"
+)
+
+type vpnCommand struct {
+}
+
+func (v *vpnCommand) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	if line.IsSet("h") {
+		fmt.Fprintf(tty, "%s", v.Help(false))
+		return nil
+	}
+
+	if line.IsSet("l") {
+		gateways := gateway.List()
+		if len(gateways) == 0 {
+			fmt.Fprintln(tty, "No vpn gateways")
+			return nil
+		}
+
+		for _, g := range gateways {
+			fmt.Fprintf(tty, "%s via %s (mtu %d, compression %t, up %s)\n", g.Device, g.Client, g.Options.MTU, g.Options.Compress, time.Since(g.Started).Round(time.Second))
+		}
+		return nil
+	}
+
+	// Routing the server's traffic affects everyone on it, not just this session
+	if console.User.Role != internal.RoleAdmin {
+		return terminal.Errorf(terminal.Permission, "Only admins can manage vpn gateways")
+	}
+
+	if line.IsSet("stop") {
+		device, err := line.GetArgString("stop")
+		if err != nil {
+			return err
+		}
+
+		if err := gateway.Stop(console.User.ConnectionDetails, device); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tty, "Stopped %s\n", device)
+		return nil
+	}
+
+	if len(line.Arguments) < 1 {
+		fmt.Fprintf(tty, "%s", v.Help(false))
+		return nil
+	}
+
+	opts := vpn.Options{
+		MTU:      vpn.DefaultMTU,
+		Compress: line.IsSet("compress"),
+	}
+
+	if line.IsSet("mtu") {
+		mtu, err := line.GetArgInt("mtu")
+		if err != nil {
+			return err
+		}
+		// Checked before the conversion, a value past uint32 would otherwise wrap around to one that looks valid
+		if mtu < vpn.MinMTU || mtu > vpn.MaxMTU {
+			return terminal.Errorf(terminal.Usage, "--mtu must be between %d and %d", vpn.MinMTU, vpn.MaxMTU)
+		}
+		opts.MTU = uint32(mtu)
+	}
+
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	device := "tun0"
+	if line.IsSet("dev") {
+		var err error
+		device, err = line.GetArgString("dev")
+		if err != nil {
+			return err
+		}
+	}
+
+	target := line.Arguments[len(line.Arguments)-1].Value()
+
+	id, sc, err := resolveOne(tty, target)
+	if err != nil {
+		return err
+	}
+
+	if err := clients.Require(id, "vpn", "vpn mode"); err != nil {
+		return err
+	}
+
+	if err := gateway.Start(console.User.ConnectionDetails, device, id, sc, opts); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(tty, "%s is now routed through %s, add routes for the networks you want to reach (ip route add <network> dev %s)\n", device, id, device)
+
+	return nil
+}
+
+func (v *vpnCommand) Expect(line terminal.ParsedLine) []string {
+	return []string{autocomplete.RemoteId}
+}
+
+func (v *vpnCommand) Help(explain bool) string {
+	if explain {
+		return "Route a tun device on the server through a client"
+	}
+
+	return terminal.MakeHelpText(
+		"vpn [OPTIONS] <remote_id>",
+		"Attaches to a tun device on the server and sends everything routed to it out of the client, as if the server were on the client's network. Only one client can be attached to a device at a time.",
+		"The device is created if the server has CAP_NET_ADMIN, otherwise create it beforehand with 'ip tuntap add dev tun0 mode tun user <server user>'.",
+		"\t-l\tList running vpn gateways",
+		"\t--dev\tTun device to use (default tun0)",
+		"\t--mtu\tMTU of the tunnel, between 1280 and 65535 (default 1500)",
+		"\t--compress\tCompress packets, which helps with slow links carrying plain text",
+		"\t--stop\tDetach the client from a device, e.g --stop tun0",
+	)
+}
@@ -2,32 +2,34 @@ package commands
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
+	"time"
 
+	"github.com/NHAS/reverse_ssh/internal"
 	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/internal/server/watches"
+	"github.com/NHAS/reverse_ssh/internal/server/webhooks"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/pkg/observer"
 )
 
 type watch struct {
-	datadir string
 }
 
 func (w *watch) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
 
 	if line.IsSet("h") || line.IsSet("help") {
-		return errors.New(w.Help(false))
+		return terminal.Errorf(terminal.Usage, "%s", w.Help(false))
 	}
 
 	if line.IsSet("a") {
 
-		f, err := os.Open(filepath.Join(w.datadir, "watch.log"))
+		f, err := os.Open(filepath.Join(console.DataDir, "watch.log"))
 		if err != nil {
 			log.Println("unable to open watch.log:", err)
 			return err
@@ -41,21 +43,20 @@ func (w *watch) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 		return sc.Err()
 	}
 
-	if numberOfLinesStr, err := line.GetArgString("l"); err == nil {
-
-		f, err := os.Open(filepath.Join(w.datadir, "watch.log"))
+	if line.IsSet("l") {
+		numberOfLines, err := line.GetArgInt("l")
 		if err != nil {
-			log.Println("unable to open watch.log:", err)
 			return err
 		}
-		defer f.Close()
 
-		info, err := f.Stat()
+		f, err := os.Open(filepath.Join(console.DataDir, "watch.log"))
 		if err != nil {
+			log.Println("unable to open watch.log:", err)
 			return err
 		}
+		defer f.Close()
 
-		numberOfLines, err := strconv.Atoi(numberOfLinesStr)
+		info, err := f.Stat()
 		if err != nil {
 			return err
 		}
@@ -116,6 +117,10 @@ func (w *watch) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 		return nil
 	}
 
+	if len(line.Arguments) > 0 {
+		return w.rules(tty, line)
+	}
+
 	messages := make(chan string)
 
 	observerId := observers.ConnectionState.Register(func(m observer.Message) {
@@ -158,6 +163,89 @@ func (w *watch) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 	return nil
 }
 
+// rules adds, removes and lists the rules the server checks against the connected clients
+func (w *watch) rules(tty io.ReadWriter, line terminal.ParsedLine) error {
+	console := consoleOf(tty)
+
+	switch line.Arguments[0].Value() {
+	case "ls":
+		rules := watches.List()
+		if len(rules) == 0 {
+			fmt.Fprintf(tty, "No watch rules\n")
+			return nil
+		}
+
+		for _, r := range rules {
+			state := "does not hold"
+			if r.Holds {
+				state = "holds"
+			}
+
+			if r.Failing != "" {
+				state += ", unable to check: " + r.Failing
+			}
+
+			to := "every webhook"
+			if r.Notify != "" {
+				to = "webhooks matching " + r.Notify
+			}
+
+			fmt.Fprintf(tty, "%s %q every %s, %s since %s, notifies %s (added by %s)\n", r.ID, r.Expression, r.Every, state, r.Changed.Format(time.RFC3339), to, r.Creator)
+		}
+		return nil
+
+	case "add":
+		if console.User.Role != internal.RoleAdmin {
+			return terminal.Errorf(terminal.Permission, "Only admins can add watch rules")
+		}
+
+		if len(line.Arguments) < 2 {
+			return terminal.Errorf(terminal.Usage, "%s", w.Help(false))
+		}
+
+		notify, _ := line.GetArgString("notify")
+		if notify != "" && !webhooks.Matching(notify) {
+			return terminal.Errorf(terminal.NotFound, "No webhook matches '%s', add one with webhook --on", notify)
+		}
+
+		every, err := line.GetArgDuration("every")
+		if err != nil && err != terminal.ErrFlagNotSet {
+			return err
+		}
+
+		r, err := watches.Add(console.User.ConnectionDetails, line.Arguments[1].Value(), notify, every)
+		if err != nil {
+			return err
+		}
+
+		state := "does not hold"
+		if r.Holds {
+			state = "holds"
+		}
+
+		fmt.Fprintf(tty, "Watching %s as %s, it %s now and an alert is raised when that changes\n", r.Expression, r.ID, state)
+		return nil
+
+	case "rm":
+		if console.User.Role != internal.RoleAdmin {
+			return terminal.Errorf(terminal.Permission, "Only admins can remove watch rules")
+		}
+
+		if len(line.Arguments) != 2 {
+			return terminal.Errorf(terminal.Usage, "%s", w.Help(false))
+		}
+
+		if err := watches.Delete(console.User.ConnectionDetails, line.Arguments[1].Value()); err != nil {
+			return terminal.Errorf(terminal.NotFound, "%s", err)
+		}
+
+		fmt.Fprintf(tty, "Stopped watching %s\n", line.Arguments[1].Value())
+		return nil
+	}
+
+	return terminal.Errorf(terminal.Usage, "%s", w.Help(false))
+}
+
 func (W *watch) Expect(line terminal.ParsedLine) []string {
 	return nil
 }
@@ -173,10 +261,12 @@ func (w *watch) Help(explain bool) string {
 		"Defaultly waits for new connection events",
 		"\t-a\tLists all previous connection events",
 		"\t-l\tList previous n number of connection events, e.g watch -l 10 shows last 10 connections",
+		"watch add <rule> [--notify webhook] [--every duration]",
+		"watch rm <id>",
+		"watch ls",
+		"Rules are checked against the connected clients, raising an alert on the webhooks whenever whether a rule holds changes.",
+		"A rule is count(<target>) compared with a number by one of < <= > >= == !=, targets are the same as any command takes, e.g watch add \"count(os=windows) < 5\" --notify slack",
+		"\t--notify\tOnly alert the webhooks whose url contains this",
+		"\t--every\tHow often the rule is checked, 1m unless given and at least 10s",
 	)
 }
-
-func Watch(datadir string) *watch {
-
-	return &watch{datadir: datadir}
-}
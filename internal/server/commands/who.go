@@ -13,15 +13,37 @@ type who struct {
 
 func (w *who) Run(tty io.ReadWriter, line terminal.ParsedLine) error {
 
-	users := internal.ListUsers()
+	if !line.IsSet("v") {
+		users := internal.ListUsers()
 
-	for _, user := range users {
-		fmt.Fprintf(tty, "%s\n", user)
+		for _, user := range users {
+			fmt.Fprintf(tty, "%s\n", user)
+		}
+
+		return nil
+	}
+
+	for _, user := range internal.GetUsers() {
+		used := user.Usage()
+
+		fmt.Fprintf(tty, "%s (%s, key %q)\n", user.ConnectionDetails, user.Role, user.KeyComment)
+		fmt.Fprintf(tty, "\tsessions %d/%s forwards %d/%s transferred today %s/%s\n",
+			used.Sessions, limit(user.Quota.Sessions),
+			used.Forwards, limit(user.Quota.Forwards),
+			internal.FormatBytes(used.Transferred), transferLimit(user.Quota.TransferPerDay),
+		)
 	}
 
 	return nil
 }
 
+func transferLimit(n uint64) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return internal.FormatBytes(n)
+}
+
 func (w *who) Expect(line terminal.ParsedLine) []string {
 	return nil
 }
@@ -31,5 +53,8 @@ func (w *who) Help(explain bool) string {
 		return "List users connected to the RSSH server"
 	}
 
-	return terminal.MakeHelpText("who")
+	return terminal.MakeHelpText(
+		"who [OPTIONS]",
+		"\t-v\tShow each user's role, key and what they are using of their quota (shared by everyone logged in with the same key)",
+	)
 }
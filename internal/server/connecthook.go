@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/pkg/observer"
+)
+
+const connectHookTimeout = 30 * time.Second
+
+// Events waiting for the connect hook past this are dropped, so a hook that hangs cannot hold up clients connecting
+const connectHookQueue = 256
+
+// ClientEvent is what the connect hook is given on stdin when a client connects or disconnects, the ClientState webhooks are
+// sent along with what the server remembers of the client
+type ClientEvent struct {
+	observers.ClientState
+
+	OS           string
+	Fingerprint  string
+	Capabilities []string `json:",omitempty"`
+	Tags         []string `json:",omitempty"`
+	FirstSeen    time.Time
+	Connections  int
+}
+
+func clientEvent(c observers.ClientState) ClientEvent {
+	event := ClientEvent{ClientState: c, OS: clients.OS(c.Version)}
+
+	if r, ok := clients.GetRecord(c.ID); ok {
+		event.Fingerprint = r.Fingerprint
+		event.Tags = r.Tags
+		event.FirstSeen = r.FirstSeen
+		event.Connections = r.Connections
+	}
+
+	// Only known while the client is connected
+	event.Capabilities, _ = clients.GetCapabilities(c.ID)
+
+	return event
+}
+
+// connectHook runs program once for every client connecting or disconnecting, one at a time so it sees them in the order they happened
+func connectHook(program string) observer.Target {
+	events := make(chan ClientEvent, connectHookQueue)
+
+	go func() {
+		for event := range events {
+			runConnectHook(program, event)
+		}
+	}()
+
+	return func(m observer.Message) {
+		event := clientEvent(m.(observers.ClientState))
+
+		select {
+		case events <- event:
+		default:
+			log.Printf("Connect hook is too far behind, not running it for %s %s\n", event.ID, event.Status)
+		}
+	}
+}
+
+func runConnectHook(program string, event ClientEvent) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Connect hook: ", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectHookTimeout)
+	defer cancel()
+
+	var output bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, program)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// So simple hooks can act on the event without parsing json
+	cmd.Env = append(os.Environ(), "RSSH_EVENT="+event.Status, "RSSH_CLIENT_ID="+event.ID, "RSSH_CLIENT_HOSTNAME="+event.HostName)
+
+	err = cmd.Run()
+	if output.Len() > 0 {
+		log.Printf("Connect hook: %s", strings.TrimSpace(output.String()))
+	}
+
+	if ctx.Err() != nil {
+		log.Printf("Connect hook timed out after %s for %s %s\n", connectHookTimeout, event.ID, event.Status)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Connect hook failed for %s %s: %s\n", event.ID, event.Status, err)
+	}
+}
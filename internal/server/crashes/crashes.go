@@ -0,0 +1,141 @@
+// Package crashes keeps a panic to the command or channel it happened in. It is recovered and written to crashes.log with its
+// stack and what was running, then logged and sent to webhooks as an alert, so a bug in one command cannot stop the server for
+// every operator and client on it
+package crashes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// Report is one recovered panic
+type Report struct {
+	Time time.Time
+	// What crashed, "command" or the type of the channel being handled
+	Kind     string
+	Operator string `json:",omitempty"`
+	Client   string `json:",omitempty"`
+	Command  string `json:",omitempty"`
+	Line     string `json:",omitempty"`
+	Panic    string
+	Stack    string
+	// Set as it is written to crashes.log
+	Sequence sequence.Stamp
+}
+
+func (r Report) what() string {
+	if r.Command != "" {
+		return "command " + r.Command
+	}
+	return r.Kind + " channel"
+}
+
+var (
+	lck  sync.Mutex
+	path string
+)
+
+// Start writes crashes to crashes.log in datadir, or only logs and alerts on them when datadir is empty
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = ""
+	if datadir != "" {
+		path = filepath.Join(datadir, "crashes.log")
+	}
+	return nil
+}
+
+// Catch recovers a panic, and must be deferred itself for it to. When there was one the report made by describe is recorded and
+// given to then, which can turn it into an error for whoever was waiting. describe is only called after a panic, so it can look up
+// things that would be too slow to find every time
+func Catch(describe func() Report, then func(Report)) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	r := describe()
+	r.Time = time.Now()
+	r.Panic = fmt.Sprint(p)
+	r.Stack = string(debug.Stack())
+
+	if err := record(&r); err != nil {
+		log.Println("Unable to write to crashes.log:", err)
+	}
+
+	if then != nil {
+		then(r)
+	}
+}
+
+func record(r *Report) error {
+	log.Printf("[ERROR] %s panicked, the server is still running: %s (operator %q, client %q, line %q)\n%s", r.what(), r.Panic, r.Operator, r.Client, r.Line, r.Stack)
+
+	r.Sequence = sequence.Next()
+	observers.Alerts.Notify(observers.Alert{
+		Kind:      "crash",
+		Message:   fmt.Sprintf("%s panicked: %s", r.what(), r.Panic),
+		Timestamp: r.Time,
+		Sequence:  r.Sequence,
+	})
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// Channels wraps each of handlers so a panic in one ends only the channel it was handling. client is the id of the client the
+// connection is from, empty for operators
+func Channels(client string, handlers map[string]internal.ChannelHandler) map[string]internal.ChannelHandler {
+	wrapped := map[string]internal.ChannelHandler{}
+	for channelType, handler := range handlers {
+		channelType, handler := channelType, handler
+		wrapped[channelType] = func(user *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
+			defer Catch(func() Report {
+				r := Report{Kind: channelType, Client: client}
+				if user != nil {
+					r.Operator = user.ConnectionDetails
+				}
+				return r
+			}, func(Report) {
+				// Refused if the handler crashed before answering it, so the other end isn't left waiting. Rejecting an accepted channel does nothing
+				newChannel.Reject(ssh.ConnectionFailed, "the server crashed handling this channel")
+			})
+
+			handler(user, newChannel, l)
+		}
+	}
+
+	return wrapped
+}
@@ -0,0 +1,103 @@
+package crashes
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+func crashing(then func(Report)) {
+	defer Catch(func() Report {
+		return Report{Kind: "command", Command: "broken", Line: "broken --now", Operator: "root@127.0.0.1"}
+	}, then)
+
+	var m map[string]int
+	m["x"] = 1
+}
+
+func TestCatch(t *testing.T) {
+	dir := t.TempDir()
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer Start("")
+
+	var caught Report
+	crashing(func(r Report) { caught = r })
+
+	if !strings.Contains(caught.Panic, "nil map") || !strings.Contains(caught.Stack, "crashing") {
+		t.Fatalf("expected the panic and its stack, got %+v", caught)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "crashes.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var logged []Report
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var r Report
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		logged = append(logged, r)
+	}
+
+	if len(logged) != 1 || logged[0].Line != "broken --now" || logged[0].Operator != "root@127.0.0.1" || logged[0].Stack == "" {
+		t.Fatalf("expected the crash written to crashes.log, got %+v", logged)
+	}
+
+	called := false
+	func() {
+		defer Catch(func() Report { called = true; return Report{} }, func(Report) { called = true })
+	}()
+	if called {
+		t.Fatal("expected nothing to be done without a panic")
+	}
+
+	Start("")
+	crashing(nil)
+	if _, err := os.Stat("crashes.log"); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written with no datadir, got %v", err)
+	}
+}
+
+type unanswered struct {
+	ssh.NewChannel
+	rejected bool
+}
+
+func (u *unanswered) Reject(reason ssh.RejectionReason, message string) error {
+	u.rejected = true
+	return nil
+}
+
+func TestChannels(t *testing.T) {
+	if err := Start(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer Start("")
+
+	handlers := Channels("client01", map[string]internal.ChannelHandler{
+		"session": func(user *internal.User, newChannel ssh.NewChannel, log logger.Logger) {
+			panic("handler bug")
+		},
+	})
+
+	channel := &unanswered{}
+	handlers["session"](nil, channel, logger.NewLog("test"))
+
+	if !channel.rejected {
+		t.Fatal("expected the channel the handler crashed on to be rejected")
+	}
+}
@@ -0,0 +1,286 @@
+// Package engagements bounds clients to a time window, once an engagement ends its clients are refused (and optionally
+// told to remove themselves) and the logs covering it are archived
+package engagements
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/evidence"
+	"github.com/NHAS/reverse_ssh/internal/server/persistence"
+	"golang.org/x/crypto/ssh"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+var (
+	lck         sync.Mutex
+	dataDir     string
+	engagements = map[string]*Engagement{}
+)
+
+type Engagement struct {
+	Name       string
+	Start      time.Time
+	End        time.Time
+	SelfRemove bool
+	// Path of the archive made when the engagement ended, empty until then
+	Archived string
+}
+
+func (e Engagement) Active(t time.Time) bool {
+	return !t.Before(e.Start) && t.Before(e.End)
+}
+
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	dataDir = datadir
+
+	b, err := os.ReadFile(filepath.Join(dataDir, "engagements.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(b, &engagements); err != nil {
+			return fmt.Errorf("unable to parse engagements.json: %s", err)
+		}
+	}
+
+	go func() {
+		for {
+			expire()
+			time.Sleep(30 * time.Second)
+		}
+	}()
+
+	return nil
+}
+
+func save() error {
+	b, err := json.MarshalIndent(engagements, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dataDir, "engagements.json"), b, 0600)
+}
+
+func Create(actor string, e Engagement) error {
+	if !ValidName(e.Name) {
+		return fmt.Errorf("invalid engagement name %q", e.Name)
+	}
+
+	if !e.End.After(e.Start) {
+		return errors.New("engagement must end after it starts")
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, ok := engagements[e.Name]; ok {
+		return fmt.Errorf("engagement %q already exists", e.Name)
+	}
+
+	e.Archived = ""
+	engagements[e.Name] = &e
+
+	if err := save(); err != nil {
+		delete(engagements, e.Name)
+		return err
+	}
+
+	audit.Log(actor, "engagement-create", e.Name, fmt.Sprintf("%s to %s self-remove: %t", e.Start.Format(time.RFC3339), e.End.Format(time.RFC3339), e.SelfRemove))
+
+	return nil
+}
+
+func Delete(actor, name string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, ok := engagements[name]; !ok {
+		return fmt.Errorf("engagement %q not found", name)
+	}
+
+	delete(engagements, name)
+
+	audit.Log(actor, "engagement-delete", name, "")
+
+	return save()
+}
+
+func Get(name string) (Engagement, bool) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	e, ok := engagements[name]
+	if !ok {
+		return Engagement{}, false
+	}
+
+	return *e, true
+}
+
+func List() []Engagement {
+	lck.Lock()
+	defer lck.Unlock()
+
+	out := make([]Engagement, 0, len(engagements))
+	for _, e := range engagements {
+		out = append(out, *e)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Start.Before(out[j].Start)
+	})
+
+	return out
+}
+
+// Admit decides whether a newly connected client may stay, clients that arent tagged with an engagement always can.
+// A client refused after its engagement ended is told to remove itself if the engagement asks for that
+func Admit(id string, sc *ssh.ServerConn) (bool, error) {
+	name := sc.Permissions.Extensions["engagement"]
+	if name == "" {
+		return true, nil
+	}
+
+	e, ok := Get(name)
+	if !ok {
+		// Deleting an engagement lifts its restrictions rather than locking its clients out
+		return true, nil
+	}
+
+	now := time.Now()
+	if e.Active(now) {
+		return true, nil
+	}
+
+	if now.Before(e.Start) {
+		return false, fmt.Errorf("engagement %q has not started yet (starts %s)", name, e.Start.Format(time.RFC3339))
+	}
+
+	if e.SelfRemove {
+		retire(e, id, sc)
+	}
+
+	return false, fmt.Errorf("engagement %q ended %s", name, e.End.Format(time.RFC3339))
+}
+
+func retire(e Engagement, id string, sc *ssh.ServerConn) {
+	actor := "engagement:" + e.Name
+
+	_, err := persistence.Remove(actor, id, sc, "")
+	if err != nil && err != persistence.ErrNoRecords {
+		log.Printf("Engagement %s unable to remove persistence from %s: %s", e.Name, id, err)
+	}
+
+	ok, message, err := sc.SendRequest("self-remove", true, nil)
+	switch {
+	case err != nil:
+		audit.Log(actor, "self-remove", id, "failed: "+err.Error())
+	case !ok:
+		audit.Log(actor, "self-remove", id, "client reported: "+string(message))
+	default:
+		audit.Log(actor, "self-remove", id, "")
+	}
+}
+
+func expire() {
+	now := time.Now()
+
+	var ended []Engagement
+	for _, e := range List() {
+		if e.Archived == "" && !now.Before(e.End) {
+			ended = append(ended, e)
+		}
+	}
+
+	if len(ended) == 0 {
+		return
+	}
+
+	connected, err := clients.Search("")
+	if err != nil {
+		log.Println("Unable to list clients for engagement expiry: ", err)
+	}
+
+	for _, e := range ended {
+		for id, sc := range connected {
+			if sc.Permissions.Extensions["engagement"] != e.Name {
+				continue
+			}
+
+			if e.SelfRemove {
+				retire(e, id, sc)
+			}
+
+			sc.Close()
+		}
+
+		archive, err := archiveLogs(e)
+		if err != nil {
+			log.Printf("Unable to archive engagement %s: %s", e.Name, err)
+			continue
+		}
+
+		lck.Lock()
+		if current, ok := engagements[e.Name]; ok {
+			current.Archived = archive
+			if err := save(); err != nil {
+				log.Println("Unable to save engagements: ", err)
+			}
+		}
+		lck.Unlock()
+
+		audit.Log("engagement:"+e.Name, "engagement-end", e.Name, "archived to "+archive)
+	}
+}
+
+// archiveLogs bundles the audit and watch log lines that fall within the engagement window into datadir/archives
+func archiveLogs(e Engagement) (string, error) {
+	archives := filepath.Join(dataDir, "archives")
+	if err := os.MkdirAll(archives, 0700); err != nil {
+		return "", err
+	}
+
+	description, err := json.MarshalIndent(e, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	auditLines, err := evidence.LinesWithin(filepath.Join(dataDir, "audit.log"), e.Start, e.End, evidence.AuditTimestamp)
+	if err != nil {
+		return "", err
+	}
+
+	watchLines, err := evidence.LinesWithin(filepath.Join(dataDir, "watch.log"), e.Start, e.End, evidence.WatchTimestamp)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(archives, fmt.Sprintf("%s-%s.tar.gz", e.Name, time.Now().Format("20060102-150405")))
+
+	return path, evidence.WriteArchive(path, []evidence.File{
+		{Name: "engagement.json", Content: description},
+		{Name: "audit.log", Content: auditLines},
+		{Name: "watch.log", Content: watchLines},
+	})
+}
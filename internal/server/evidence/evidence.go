@@ -0,0 +1,100 @@
+// Package evidence slices the servers logs by time and bundles them into archives
+package evidence
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+)
+
+type File struct {
+	Name    string
+	Content []byte
+}
+
+// Timestamp extracts when a log line was written, returning false for lines it doesnt understand
+type Timestamp func(line string) (time.Time, bool)
+
+func AuditTimestamp(line string) (time.Time, bool) {
+	var entry audit.Entry
+	if json.Unmarshal([]byte(line), &entry) != nil {
+		return time.Time{}, false
+	}
+	return entry.Timestamp, true
+}
+
+func WatchTimestamp(line string) (time.Time, bool) {
+	if len(line) < 19 {
+		return time.Time{}, false
+	}
+
+	t, err := time.ParseInLocation("2006/01/02 15:04:05", line[:19], time.Local)
+	return t, err == nil
+}
+
+// LinesWithin returns the lines of a log written between start and end inclusive, a missing log is just empty
+func LinesWithin(path string, start, end time.Time, timestamp Timestamp) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		t, ok := timestamp(scanner.Text())
+		if !ok || t.Before(start) || t.After(end) {
+			continue
+		}
+
+		out = append(out, scanner.Bytes()...)
+		out = append(out, '\n')
+	}
+
+	return out, scanner.Err()
+}
+
+// WriteArchive writes files in order to a new gzipped tarball, it will not overwrite an existing one
+func WriteArchive(path string, files []File) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	now := time.Now()
+	for _, file := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    file.Name,
+			Mode:    0600,
+			Size:    int64(len(file.Content)),
+			ModTime: now,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := tw.Write(file.Content); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
@@ -0,0 +1,71 @@
+package evidence
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLinesWithin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	log := `{"Timestamp":"2024-01-01T00:00:00Z","Action":"before"}
+{"Timestamp":"2024-01-02T00:00:00Z","Action":"during"}
+not json
+{"Timestamp":"2024-01-04T00:00:00Z","Action":"after"}
+`
+	if err := os.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	out, err := LinesWithin(path, start, start.Add(24*time.Hour), AuditTimestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "{\"Timestamp\":\"2024-01-02T00:00:00Z\",\"Action\":\"during\"}\n" {
+		t.Fatalf("unexpected lines %q", out)
+	}
+
+	out, err = LinesWithin(filepath.Join(t.TempDir(), "missing.log"), start, start, AuditTimestamp)
+	if err != nil || len(out) != 0 {
+		t.Fatalf("missing log should be empty, got %q %v", out, err)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("evidence")
+	sig, err := Sign(signer, "rssh-export", bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Verify(signer.PublicKey(), "rssh-export", bytes.NewReader(message), sig); err != nil {
+		t.Fatalf("valid signature rejected: %s", err)
+	}
+
+	if Verify(signer.PublicKey(), "rssh-export", bytes.NewReader([]byte("tampered")), sig) == nil {
+		t.Fatal("signature over a different message was accepted")
+	}
+
+	if Verify(signer.PublicKey(), "other", bytes.NewReader(message), sig) == nil {
+		t.Fatal("signature for a different namespace was accepted")
+	}
+}
@@ -0,0 +1,126 @@
+package evidence
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Signatures use the SSHSIG format (PROTOCOL.sshsig in openssh) so they can be checked with ssh-keygen -Y verify
+const sshsigMagic = "SSHSIG"
+
+type sshsigSigned struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+type sshsigBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+func sshsigData(namespace string, message io.Reader) ([]byte, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(sshsigMagic), ssh.Marshal(sshsigSigned{
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Hash:          h.Sum(nil),
+	})...), nil
+}
+
+// Sign produces an armored SSHSIG signature of message
+func Sign(signer ssh.Signer, namespace string, message io.Reader) ([]byte, error) {
+	data, err := sshsigData(namespace, message)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig *ssh.Signature
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// SSHSIG doesnt allow plain sha1 ssh-rsa signatures
+		sig, err = algorithmSigner.SignWithAlgorithm(nil, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(nil, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte(sshsigMagic), ssh.Marshal(sshsigBlob{
+		Version:       1,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+
+	var armored bytes.Buffer
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString("-----END SSH SIGNATURE-----\n")
+
+	return armored.Bytes(), nil
+}
+
+// Verify checks an armored SSHSIG signature made by Sign against the key that made it
+func Verify(key ssh.PublicKey, namespace string, message io.Reader, armored []byte) error {
+	armored = bytes.TrimSpace(armored)
+	if !bytes.HasPrefix(armored, []byte("-----BEGIN SSH SIGNATURE-----")) || !bytes.HasSuffix(armored, []byte("-----END SSH SIGNATURE-----")) {
+		return errors.New("not an armored ssh signature")
+	}
+
+	encoded := bytes.TrimSuffix(bytes.TrimPrefix(armored, []byte("-----BEGIN SSH SIGNATURE-----")), []byte("-----END SSH SIGNATURE-----"))
+	blob, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(encoded), nil)))
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sshsigMagic)) {
+		return errors.New("signature is missing the SSHSIG preamble")
+	}
+
+	var parsed sshsigBlob
+	if err := ssh.Unmarshal(blob[len(sshsigMagic):], &parsed); err != nil {
+		return err
+	}
+
+	if parsed.Namespace != namespace {
+		return errors.New("signature is for a different namespace")
+	}
+
+	if !bytes.Equal(parsed.PublicKey, key.Marshal()) {
+		return errors.New("signature was made by a different key")
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(parsed.Signature, &sig); err != nil {
+		return err
+	}
+
+	data, err := sshsigData(namespace, message)
+	if err != nil {
+		return err
+	}
+
+	return key.Verify(data, &sig)
+}
@@ -0,0 +1,452 @@
+// Package facts snapshots what is installed on and listening on clients every so often, keeping the last two snapshots of each
+// client so that what changed between them can be shown, and raising alerts when a change matches a rule
+package facts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// How long a snapshot is kept as current before the client is snapshotted again
+	Every = 6 * time.Hour
+	// Output of a fact past this is dropped, it is still compared with what was kept last time
+	MaxOutput = 1024 * 1024
+	// How many clients are snapshotted at once
+	parallel = 8
+)
+
+// Fact is something about a client worth noticing change, and the commands that list it one item to a line
+type Fact struct {
+	Name    string
+	Linux   string
+	Windows string
+}
+
+var Facts = []Fact{
+	{
+		Name:    "packages",
+		Linux:   `sh -c "dpkg-query -W -f='${Package} ${Version}\\n' 2>/dev/null || rpm -qa 2>/dev/null || apk info -v 2>/dev/null"`,
+		Windows: `powershell -NoProfile -Command "Get-Package | ForEach-Object { $_.Name + ' ' + $_.Version }"`,
+	},
+	{
+		Name:    "ports",
+		Linux:   `sh -c "ss -Hltnu 2>/dev/null | awk '{print $1, $5}' || netstat -ltnu 2>/dev/null | awk 'NR>2 {print $1, $4}'"`,
+		Windows: `powershell -NoProfile -Command "Get-NetTCPConnection -State Listen | ForEach-Object { 'tcp ' + $_.LocalAddress + ':' + $_.LocalPort }"`,
+	},
+	{
+		Name:    "users",
+		Linux:   `cut -d: -f1 /etc/passwd`,
+		Windows: `powershell -NoProfile -Command "Get-LocalUser | ForEach-Object { $_.Name }"`,
+	},
+}
+
+func Names() []string {
+	names := make([]string, 0, len(Facts))
+	for _, f := range Facts {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func known(fact string) bool {
+	for _, f := range Facts {
+		if f.Name == fact {
+			return true
+		}
+	}
+	return false
+}
+
+type Snapshot struct {
+	Taken time.Time
+	// The lines of each fact, sorted and without duplicates
+	Facts map[string][]string
+	// Facts that could not be listed, and why. They are left out of comparisons rather than seen as emptied
+	Failed map[string]string `json:",omitempty"`
+}
+
+// Change is how a fact differs between two snapshots
+type Change struct {
+	Fact           string
+	Added, Removed []string
+}
+
+// Rule raises an alert when a fact changes on any client, or only when a line added or removed contains Match
+type Rule struct {
+	ID    string
+	Fact  string
+	Match string `json:",omitempty"`
+	// Only webhooks whose url contains this are sent the alert, every webhook is when it is empty
+	Notify  string `json:",omitempty"`
+	Creator string
+	Created time.Time
+}
+
+type history struct {
+	Previous *Snapshot `json:",omitempty"`
+	Latest   *Snapshot `json:",omitempty"`
+}
+
+type store struct {
+	Snapshots map[string]*history
+	Rules     map[string]*Rule
+}
+
+var (
+	lck  sync.Mutex
+	path string
+	s    = store{Snapshots: map[string]*history{}, Rules: map[string]*Rule{}}
+
+	sweeping sync.Once
+)
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "facts.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return fmt.Errorf("unable to parse facts.json: %s", err)
+		}
+	}
+
+	if s.Snapshots == nil {
+		s.Snapshots = map[string]*history{}
+	}
+
+	if s.Rules == nil {
+		s.Rules = map[string]*Rule{}
+	}
+
+	sweeping.Do(func() {
+		go func() {
+			for range time.NewTicker(time.Minute).C {
+				Sweep(time.Now())
+			}
+		}()
+	})
+
+	return nil
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Sweep snapshots every connected client whose latest snapshot is older than Every
+func Sweep(now time.Time) {
+	connected, err := clients.Search("")
+	if err != nil {
+		return
+	}
+
+	var (
+		wait    sync.WaitGroup
+		running = make(chan struct{}, parallel)
+	)
+	for id, conn := range connected {
+		r, _, err := clients.FindRecord(id)
+		if err != nil {
+			continue
+		}
+
+		if _, latest, ok := Latest(r.ID); ok && now.Sub(latest.Taken) < Every {
+			continue
+		}
+
+		running <- struct{}{}
+		wait.Add(1)
+		go func(id, record string, conn *ssh.ServerConn) {
+			defer func() {
+				<-running
+				wait.Done()
+			}()
+
+			if _, err := Record(record, Collect(id, conn)); err != nil {
+				log.Printf("Unable to keep the facts of %s: %s", id, err)
+			}
+		}(id, r.ID, conn)
+	}
+	wait.Wait()
+}
+
+// Collect lists every fact on a connected client
+func Collect(id string, conn *ssh.ServerConn) Snapshot {
+	snapshot := Snapshot{Taken: time.Now(), Facts: map[string][]string{}, Failed: map[string]string{}}
+
+	clients.RecordSession(id, "facts", "server")
+
+	windows := clients.OS(string(conn.ClientVersion())) == "windows"
+	for _, f := range Facts {
+		command := f.Linux
+		if windows {
+			command = f.Windows
+		}
+
+		output, err := run(id, conn, command)
+		if err != nil {
+			snapshot.Failed[f.Name] = err.Error()
+			continue
+		}
+
+		snapshot.Facts[f.Name] = lines(output)
+	}
+
+	return snapshot
+}
+
+// run is exec on a client for the server itself, so a lockdown of exec stops it along with everything else
+func run(id string, conn ssh.Conn, command string) ([]byte, error) {
+	channel, requests, err := conn.OpenChannel("session", nil)
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(requests)
+
+	done, err := lockdown.Begin(lockdown.Exec, channel)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+	defer done()
+	defer channel.Close()
+
+	ok, err := channel.SendRequest("exec", true, ssh.Marshal(&internal.ShellStruct{Cmd: command}))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("client refused to run %q", command)
+	}
+
+	var output bytes.Buffer
+	if _, err := io.Copy(&output, io.LimitReader(channel, MaxOutput)); err != nil {
+		return nil, err
+	}
+
+	return output.Bytes(), nil
+}
+
+func lines(output []byte) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, line := range strings.Split(strings.ReplaceAll(string(output), "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || seen[line] {
+			continue
+		}
+		seen[line] = true
+		out = append(out, line)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Record keeps a snapshot as the latest for a client record, returning how it differs from the one before and raising an alert
+// for each change a rule matches
+func Record(record string, snapshot Snapshot) ([]Change, error) {
+	lck.Lock()
+
+	h, ok := s.Snapshots[record]
+	if !ok {
+		h = &history{}
+		s.Snapshots[record] = h
+	}
+
+	var changes []Change
+	if h.Latest != nil {
+		changes = Compare(*h.Latest, snapshot)
+	}
+
+	h.Previous, h.Latest = h.Latest, &snapshot
+
+	var alerts []observers.Alert
+	for _, rule := range s.Rules {
+		for _, c := range changes {
+			if matched := rule.matches(c); len(matched) > 0 {
+				alerts = append(alerts, observers.Alert{
+					Kind:      "drift",
+					Message:   fmt.Sprintf("%s of %s changed: %s (rule %s)", c.Fact, record, strings.Join(matched, ", "), rule.ID),
+					Timestamp: snapshot.Taken,
+					Sequence:  sequence.Next(),
+					Notify:    rule.Notify,
+				})
+			}
+		}
+	}
+
+	err := save()
+	lck.Unlock()
+
+	for _, a := range alerts {
+		log.Printf("[WARNING] drift: %s", a.Message)
+		audit.Log("server", "drift", record, a.Message)
+		observers.Alerts.Notify(a)
+	}
+
+	return changes, err
+}
+
+// matches is the lines of a change a rule is interested in, each marked with whether it was added or removed
+func (r *Rule) matches(c Change) (out []string) {
+	if r.Fact != c.Fact {
+		return nil
+	}
+
+	for _, line := range c.Added {
+		if strings.Contains(line, r.Match) {
+			out = append(out, "+"+line)
+		}
+	}
+
+	for _, line := range c.Removed {
+		if strings.Contains(line, r.Match) {
+			out = append(out, "-"+line)
+		}
+	}
+	return out
+}
+
+// Compare gives what was added to and removed from each fact between two snapshots. Facts either snapshot failed to list are skipped
+func Compare(before, after Snapshot) []Change {
+	var changes []Change
+	for _, f := range Facts {
+		if _, failed := before.Failed[f.Name]; failed {
+			continue
+		}
+		if _, failed := after.Failed[f.Name]; failed {
+			continue
+		}
+
+		had := map[string]bool{}
+		for _, line := range before.Facts[f.Name] {
+			had[line] = true
+		}
+
+		c := Change{Fact: f.Name}
+		for _, line := range after.Facts[f.Name] {
+			if !had[line] {
+				c.Added = append(c.Added, line)
+			}
+			delete(had, line)
+		}
+
+		for _, line := range before.Facts[f.Name] {
+			if had[line] {
+				c.Removed = append(c.Removed, line)
+			}
+		}
+
+		if len(c.Added) > 0 || len(c.Removed) > 0 {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// Latest is the two most recent snapshots of a client record, previous is empty if only one has been taken
+func Latest(record string) (previous, latest Snapshot, ok bool) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	h, ok := s.Snapshots[record]
+	if !ok || h.Latest == nil {
+		return Snapshot{}, Snapshot{}, false
+	}
+
+	if h.Previous != nil {
+		previous = *h.Previous
+	}
+	return previous, *h.Latest, true
+}
+
+func AddRule(actor, fact, match, notify string) (Rule, error) {
+	if !known(fact) {
+		return Rule{}, fmt.Errorf("unknown fact %q, expected one of %s", fact, strings.Join(Names(), ", "))
+	}
+
+	id, err := internal.RandomString(4)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	r := Rule{ID: id, Fact: fact, Match: match, Notify: notify, Creator: actor, Created: time.Now()}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	s.Rules[id] = &r
+	if err := save(); err != nil {
+		delete(s.Rules, id)
+		return Rule{}, err
+	}
+
+	audit.Log(actor, "drift-rule-add", id, strings.TrimSpace(fact+" "+match))
+
+	return r, nil
+}
+
+func DeleteRule(actor, id string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, ok := s.Rules[id]; !ok {
+		return fmt.Errorf("drift rule %q not found", id)
+	}
+
+	delete(s.Rules, id)
+	audit.Log(actor, "drift-rule-delete", id, "")
+
+	return save()
+}
+
+func Rules() []Rule {
+	lck.Lock()
+	defer lck.Unlock()
+
+	out := make([]Rule, 0, len(s.Rules))
+	for _, r := range s.Rules {
+		out = append(out, *r)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.Before(out[j].Created)
+	})
+
+	return out
+}
@@ -0,0 +1,102 @@
+package facts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/pkg/observer"
+)
+
+func TestLines(t *testing.T) {
+	if l := lines([]byte("sshd\r\n\nroot\n  root \nbin\n")); !reflect.DeepEqual(l, []string{"bin", "root", "sshd"}) {
+		t.Errorf("expected sorted lines without blanks or repeats, got %q", l)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	before := Snapshot{Facts: map[string][]string{
+		"packages": {"nginx 1.18", "openssl 1.1"},
+		"users":    {"root"},
+		"ports":    {"tcp 0.0.0.0:22"},
+	}}
+
+	after := Snapshot{
+		Facts: map[string][]string{
+			"packages": {"nginx 1.18", "openssl 3.0"},
+			"users":    {"root"},
+		},
+		Failed: map[string]string{"ports": "client refused"},
+	}
+
+	expected := []Change{{Fact: "packages", Added: []string{"openssl 3.0"}, Removed: []string{"openssl 1.1"}}}
+	if changes := Compare(before, after); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected only packages to change, as ports could not be listed, got %+v", changes)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	if err := Start(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { path, s = "", store{Snapshots: map[string]*history{}, Rules: map[string]*Rule{}} }()
+
+	alerts := make(chan observers.Alert, 10)
+	id := observers.Alerts.Register(func(m observer.Message) {
+		alerts <- m.(observers.Alert)
+	})
+	defer observers.Alerts.Deregister(id)
+
+	if _, err := AddRule("alice", "hostname", "", ""); err == nil {
+		t.Error("expected a rule for an unknown fact to be refused")
+	}
+
+	if _, err := AddRule("alice", "users", "", "slack"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AddRule("alice", "ports", ":4444", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	first := Snapshot{Taken: time.Now(), Facts: map[string][]string{"users": {"root"}, "ports": {"tcp 0.0.0.0:22"}}}
+	if changes, err := Record("web01", first); err != nil || len(changes) != 0 {
+		t.Fatalf("expected nothing to compare the first snapshot with, got %+v: %v", changes, err)
+	}
+
+	second := Snapshot{Taken: time.Now(), Facts: map[string][]string{"users": {"root"}, "ports": {"tcp 0.0.0.0:22", "tcp 0.0.0.0:8080"}}}
+	if changes, _ := Record("web01", second); len(changes) != 1 {
+		t.Errorf("expected the new port to be a change, got %+v", changes)
+	}
+
+	select {
+	case a := <-alerts:
+		t.Errorf("expected no alert for a port the rule does not match, got %+v", a)
+	default:
+	}
+
+	third := Snapshot{Taken: time.Now(), Facts: map[string][]string{"users": {"backdoor", "root"}, "ports": {"tcp 0.0.0.0:22", "tcp 0.0.0.0:4444"}}}
+	if _, err := Record("web01", third); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case a := <-alerts:
+			got[a.Notify] = a.Message
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected an alert for each rule the changes matched")
+		}
+	}
+
+	if len(got) != 2 || got["slack"] == "" || got[""] == "" {
+		t.Errorf("unexpected alerts: %+v", got)
+	}
+
+	previous, latest, ok := Latest("web01")
+	if !ok || !reflect.DeepEqual(previous.Facts, second.Facts) || !reflect.DeepEqual(latest.Facts, third.Facts) {
+		t.Errorf("expected the last two snapshots to be kept, got %+v and %+v", previous, latest)
+	}
+}
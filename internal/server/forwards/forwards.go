@@ -0,0 +1,386 @@
+// Package forwards holds named forwards, which give operators a way into a client's network that the server can police, and the
+// listeners opened on clients with listen. Both are tied to the client's identity rather than its id, so they are put back when
+// the client reconnects and survive server restarts
+package forwards
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"golang.org/x/crypto/ssh"
+)
+
+// Separator splits the forward name from the destination in a direct-tcpip host, e.g ssh -L 8080:web/10.0.0.5:80 rssh.server
+const Separator = "/"
+
+// Identity is what stays the same when a client reconnects, the same pairing persistence records use
+type Identity struct {
+	Hostname    string
+	Fingerprint string
+}
+
+func IdentityOf(sc *ssh.ServerConn) Identity {
+	return Identity{
+		Hostname:    clients.NormaliseHostname(sc.User()),
+		Fingerprint: sc.Permissions.Extensions["pubkey-fp"],
+	}
+}
+
+type Forward struct {
+	Name string
+	Identity
+
+	// Users who may use the forward, matched against the ssh username and the key comment. Empty allows everyone
+	Users []string
+	// Destinations the forward may reach. Empty allows everything
+	Destinations []Rule
+
+	// Compress connections between the server and client
+	Compress bool `json:",omitempty"`
+
+	Creator string
+	Created time.Time
+
+	Connections uint64
+	Denied      uint64
+
+	// Id of the client while it is connected
+	Client string `json:"-"`
+	// When the client went away, if it has
+	Lost time.Time `json:"-"`
+}
+
+// Listener is a port opened on a client that relays connections back to the server's own port
+type Listener struct {
+	Identity
+	internal.RemoteForwardRequest
+
+	Creator string
+	Created time.Time
+
+	Client string    `json:"-"`
+	Lost   time.Time `json:"-"`
+}
+
+type state struct {
+	Forwards  []*Forward
+	Listeners []*Listener
+}
+
+var (
+	lck     sync.Mutex
+	path    string
+	current state
+
+	ErrNotFound = errors.New("no such forward")
+)
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "forwards.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &current); err != nil {
+		return fmt.Errorf("unable to parse forwards.json: %s", err)
+	}
+
+	// Nothing is connected yet, so everything loaded is waiting on its client
+	now := time.Now()
+	for _, f := range current.Forwards {
+		f.Lost = now
+	}
+	for _, l := range current.Listeners {
+		l.Lost = now
+	}
+
+	return nil
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(current, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Allows decides whether the user may reach the destination with the forward
+func (f *Forward) Allows(usernames []string, host string, port uint16) error {
+	if len(f.Users) > 0 && !f.permitsUser(usernames) {
+		return fmt.Errorf("you are not allowed to use the forward '%s'", f.Name)
+	}
+
+	if len(f.Destinations) == 0 {
+		return nil
+	}
+
+	for _, rule := range f.Destinations {
+		if rule.Matches(host, port) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("the forward '%s' does not allow connections to %s", f.Name, net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
+
+func (f *Forward) permitsUser(usernames []string) bool {
+	for _, allowed := range f.Users {
+		for _, u := range usernames {
+			if u != "" && allowed == u {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func find(name string) (int, *Forward) {
+	for i, f := range current.Forwards {
+		if f.Name == name {
+			return i, f
+		}
+	}
+	return -1, nil
+}
+
+// Add records a forward through the connected client id
+func Add(f Forward) error {
+	if f.Name == "" || strings.ContainsAny(f.Name, Separator+": ") {
+		return fmt.Errorf("'%s' is not a valid forward name, it cannot be empty or contain '%s', ':' or spaces", f.Name, Separator)
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, existing := find(f.Name); existing != nil {
+		return fmt.Errorf("a forward called '%s' already exists", f.Name)
+	}
+
+	f.Created = time.Now()
+	current.Forwards = append(current.Forwards, &f)
+
+	return save()
+}
+
+func Remove(name string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	i, f := find(name)
+	if f == nil {
+		return fmt.Errorf("no forward called '%s'", name)
+	}
+
+	current.Forwards = append(current.Forwards[:i], current.Forwards[i+1:]...)
+	return save()
+}
+
+// Use checks a connection against the forward's access list and counts it, returning the client to connect through
+func Use(name string, usernames []string, host string, port uint16) (client string, compressed bool, err error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	_, f := find(name)
+	if f == nil {
+		return "", false, ErrNotFound
+	}
+
+	if err := f.Allows(usernames, host, port); err != nil {
+		f.Denied++
+		return "", false, err
+	}
+
+	if f.Client == "" {
+		return "", false, fmt.Errorf("the client for '%s' (%s) has been disconnected since %s", f.Name, f.Hostname, f.Lost.Format(time.RFC3339))
+	}
+
+	f.Connections++
+	return f.Client, f.Compress, nil
+}
+
+// List returns copies of the forwards sorted by name
+func List() (out []Forward) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, f := range current.Forwards {
+		out = append(out, *f)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// AddListener records a listener opened on the connected client id, so it can be opened again whenever the client comes back
+func AddListener(actor, id string, sc *ssh.ServerConn, r internal.RemoteForwardRequest) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	identity := IdentityOf(sc)
+	for _, l := range current.Listeners {
+		if l.Identity == identity && l.RemoteForwardRequest == r {
+			l.Client = id
+			return nil
+		}
+	}
+
+	current.Listeners = append(current.Listeners, &Listener{
+		Identity:             identity,
+		RemoteForwardRequest: r,
+		Creator:              actor,
+		Created:              time.Now(),
+		Client:               id,
+	})
+
+	return save()
+}
+
+func RemoveListener(sc *ssh.ServerConn, r internal.RemoteForwardRequest) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	identity := IdentityOf(sc)
+	for i, l := range current.Listeners {
+		if l.Identity == identity && l.RemoteForwardRequest == r {
+			current.Listeners = append(current.Listeners[:i], current.Listeners[i+1:]...)
+			return save()
+		}
+	}
+
+	return nil
+}
+
+func Listeners() (out []Listener) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, l := range current.Listeners {
+		out = append(out, *l)
+	}
+
+	return out
+}
+
+// Connected attaches the forwards of a client that has come back to its new id, and reopens its listeners
+func Connected(id string, sc *ssh.ServerConn) {
+	forwards, listeners := attach(id, IdentityOf(sc))
+
+	for _, f := range forwards {
+		audit.Log("server", "forward-restored", f.Name, fmt.Sprintf("through %s after %s without its client", id, since(f.Lost)))
+	}
+
+	for _, l := range listeners {
+		ok, message, err := sc.SendRequest("tcpip-forward", true, ssh.Marshal(&l.RemoteForwardRequest))
+		if err == nil && !ok {
+			err = errors.New(string(message))
+		}
+
+		if err != nil {
+			audit.Log("server", "listener-failed", id, fmt.Sprintf("unable to reopen %s: %s", l.RemoteForwardRequest.String(), err))
+			continue
+		}
+
+		audit.Log("server", "listener-restored", id, fmt.Sprintf("%s after %s without its client", l.RemoteForwardRequest.String(), since(l.Lost)))
+	}
+}
+
+// Disconnected marks everything going through the client as waiting for it to come back
+func Disconnected(id string) {
+	now := time.Now()
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, f := range current.Forwards {
+		if f.Client == id {
+			f.Client, f.Lost = "", now
+			audit.Log("server", "forward-lost", f.Name, "client "+id+" disconnected")
+		}
+	}
+
+	for _, l := range current.Listeners {
+		if l.Client == id {
+			l.Client, l.Lost = "", now
+		}
+	}
+}
+
+// attach returns copies of what was reattached, as they were before reattaching
+func attach(id string, identity Identity) (forwards []Forward, listeners []Listener) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, f := range current.Forwards {
+		if f.Identity == identity && f.Client == "" {
+			forwards = append(forwards, *f)
+			f.Client, f.Lost = id, time.Time{}
+		}
+	}
+
+	for _, l := range current.Listeners {
+		if l.Identity == identity && l.Client == "" {
+			listeners = append(listeners, *l)
+			l.Client, l.Lost = id, time.Time{}
+		}
+	}
+
+	return forwards, listeners
+}
+
+func since(t time.Time) time.Duration {
+	return time.Since(t).Round(time.Second)
+}
+
+// Rekey moves forwards to a client's new key, as a client whose key is rotated is still the same install
+func Rekey(oldFingerprint, newFingerprint string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	changed := false
+	for _, f := range current.Forwards {
+		if f.Fingerprint == oldFingerprint {
+			f.Fingerprint, changed = newFingerprint, true
+		}
+	}
+
+	for _, l := range current.Listeners {
+		if l.Fingerprint == oldFingerprint {
+			l.Fingerprint, changed = newFingerprint, true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return save()
+}
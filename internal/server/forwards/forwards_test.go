@@ -0,0 +1,135 @@
+package forwards
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	cases := []struct {
+		rule  string
+		host  string
+		port  uint16
+		match bool
+	}{
+		{"10.0.0.0/8:22", "10.1.2.3", 22, true},
+		{"10.0.0.0/8:22", "10.1.2.3", 23, false},
+		{"10.0.0.0/8:22", "192.168.0.1", 22, false},
+		{"10.0.0.5:*", "10.0.0.5", 8443, true},
+		{"10.0.0.5:8000-8100", "10.0.0.5", 8080, true},
+		{"10.0.0.5:8000-8100", "10.0.0.5", 8101, false},
+		{"[fd00::1]:443", "fd00::1", 443, true},
+		{"[fd00::]/8:443", "fd12::1", 443, true},
+		{"*.corp.internal:443", "Intranet.corp.internal", 443, true},
+		{"*.corp.internal:443", "10.0.0.1", 443, false},
+		{"*:*", "example.com", 80, true},
+		// Ip rules cant vouch for where a hostname resolves to
+		{"10.0.0.0/8:*", "intranet", 80, false},
+	}
+
+	for _, c := range cases {
+		r, err := ParseRule(c.rule)
+		if err != nil {
+			t.Fatalf("%s: %s", c.rule, err)
+		}
+
+		if r.Matches(c.host, c.port) != c.match {
+			t.Errorf("%s matching %s:%d should be %t", c.rule, c.host, c.port, c.match)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.1", "10.0.0.1:http", "10.0.0.1:90-80", "fd00::1:443", ":80", "[fd00::1:80", "[a:*"} {
+		if _, err := ParseRule(bad); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/8:22", "10.0.0.5:*", "[fd00::1]:8000-8100", "*.corp.internal:443"} {
+		r, err := ParseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r.String() != s {
+			t.Errorf("expected %s got %s", s, r.String())
+		}
+	}
+}
+
+func TestUse(t *testing.T) {
+	rule, _ := ParseRule("10.0.0.0/8:80")
+	if err := Add(Forward{Name: "web", Client: "abc", Users: []string{"alice"}, Destinations: []Rule{rule}}); err != nil {
+		t.Fatal(err)
+	}
+	defer Remove("web")
+
+	if client, _, err := Use("web", []string{"root", "alice"}, "10.0.0.5", 80); err != nil || client != "abc" {
+		t.Errorf("alice should be able to use the forward: %v", err)
+	}
+
+	if _, _, err := Use("web", []string{"bob"}, "10.0.0.5", 80); err == nil {
+		t.Error("bob is not on the access list")
+	}
+
+	if _, _, err := Use("web", []string{"alice"}, "10.0.0.5", 443); err == nil {
+		t.Error("port 443 is not allowed")
+	}
+
+	if _, _, err := Use("missing", nil, "10.0.0.5", 80); err != ErrNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	if f := List()[0]; f.Connections != 1 || f.Denied != 2 {
+		t.Errorf("expected 1 connection and 2 denied, got %d and %d", f.Connections, f.Denied)
+	}
+
+	if err := Add(Forward{Name: "a/b"}); err == nil {
+		t.Error("names containing the separator should be rejected")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	path = filepath.Join(t.TempDir(), "forwards.json")
+	defer func() {
+		path = ""
+		current = state{}
+	}()
+
+	identity := Identity{Hostname: "dummy.machine", Fingerprint: "abcd"}
+	rule, _ := ParseRule("10.0.0.0/8:*")
+
+	if err := Add(Forward{Name: "db", Identity: identity, Client: "first", Destinations: []Rule{rule}}); err != nil {
+		t.Fatal(err)
+	}
+
+	Disconnected("first")
+	if _, _, err := Use("db", nil, "10.0.0.1", 5432); err == nil {
+		t.Error("forwards should not be usable while their client is away")
+	}
+
+	if forwards, _ := attach("someone-else", Identity{Hostname: "dummy.machine", Fingerprint: "other"}); len(forwards) != 0 {
+		t.Error("a different key should not pick up the forward")
+	}
+
+	forwards, _ := attach("second", identity)
+	if len(forwards) != 1 || forwards[0].Lost.IsZero() {
+		t.Fatalf("expected the forward to be reattached with when it was lost, got %+v", forwards)
+	}
+
+	if client, _, err := Use("db", nil, "10.0.0.1", 5432); err != nil || client != "second" {
+		t.Errorf("expected the forward to go through the new id, got %q %v", client, err)
+	}
+
+	// What was saved should load back, waiting on the client
+	current = state{}
+	if err := Start(filepath.Dir(path)); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := List()
+	if len(loaded) != 1 || loaded[0].Identity != identity || loaded[0].Client != "" || loaded[0].Destinations[0].String() != "10.0.0.0/8:*" {
+		t.Errorf("forward did not survive a restart: %+v", loaded)
+	}
+}
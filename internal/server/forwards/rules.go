@@ -0,0 +1,148 @@
+package forwards
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Rule matches a destination host and port
+type Rule struct {
+	network *net.IPNet
+	host    string
+
+	low, high uint16
+}
+
+// ParseRule parses host:port, where the host is an ip, a cidr or a hostname glob, and the port is a number, a low-high range or *.
+// Hostname rules only ever match hostnames, and ip rules only match ips, as the client does the lookup so the server cant know
+// where a hostname will end up
+func ParseRule(s string) (Rule, error) {
+	host, port, err := splitRule(s)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	var r Rule
+
+	switch {
+	case port == "*":
+		r.low, r.high = 0, 65535
+	case strings.Contains(port, "-"):
+		parts := strings.SplitN(port, "-", 2)
+		low, err1 := strconv.ParseUint(parts[0], 10, 16)
+		high, err2 := strconv.ParseUint(parts[1], 10, 16)
+		if err1 != nil || err2 != nil || low > high {
+			return Rule{}, fmt.Errorf("'%s' is not a valid port range", port)
+		}
+		r.low, r.high = uint16(low), uint16(high)
+	default:
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return Rule{}, fmt.Errorf("'%s' is not a valid port", port)
+		}
+		r.low, r.high = uint16(p), uint16(p)
+	}
+
+	if _, network, err := net.ParseCIDR(host); err == nil {
+		r.network = network
+		return r, nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+
+		r.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return r, nil
+	}
+
+	if _, err := filepath.Match(host, ""); err != nil {
+		return Rule{}, fmt.Errorf("'%s' is not a valid host pattern", host)
+	}
+	r.host = strings.ToLower(host)
+
+	return r, nil
+}
+
+// splitRule is net.SplitHostPort, but also allows cidrs with ipv6 in brackets ([fd00::]/8:443) and * for either part
+func splitRule(s string) (host, port string, err error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("'%s' is missing a port, use host:* for any port", s)
+	}
+
+	host, port = s[:i], s[i+1:]
+	if host == "" || port == "" {
+		return "", "", fmt.Errorf("'%s' needs both a host and a port", s)
+	}
+
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 {
+			return "", "", fmt.Errorf("'%s' has an unclosed bracket", s)
+		}
+		host = host[1:end] + host[end+1:]
+	} else if strings.Contains(host, ":") && !strings.Contains(host, "/") {
+		return "", "", fmt.Errorf("ipv6 addresses must be in brackets, e.g [%s]:%s", host, port)
+	}
+
+	return host, port, nil
+}
+
+func (r Rule) Matches(host string, port uint16) bool {
+	if port < r.low || port > r.high {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return r.network != nil && r.network.Contains(ip)
+	}
+
+	if r.host == "" {
+		return false
+	}
+
+	matched, _ := filepath.Match(r.host, strings.ToLower(host))
+	return matched
+}
+
+func (r Rule) String() string {
+	var port string
+	switch {
+	case r.low == 0 && r.high == 65535:
+		port = "*"
+	case r.low == r.high:
+		port = strconv.Itoa(int(r.low))
+	default:
+		port = fmt.Sprintf("%d-%d", r.low, r.high)
+	}
+
+	host := r.host
+	if r.network != nil {
+		host = r.network.String()
+		if ones, bits := r.network.Mask.Size(); ones == bits {
+			host = r.network.IP.String()
+		}
+
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+	}
+
+	return host + ":" + port
+}
+
+func (r Rule) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rule) UnmarshalText(text []byte) (err error) {
+	*r, err = ParseRule(string(text))
+	return err
+}
@@ -0,0 +1,176 @@
+// Package gateway routes a tun device on the server through a client, making the client a VPN gateway for the server's network
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"github.com/NHAS/reverse_ssh/internal/vpn"
+	"golang.org/x/crypto/ssh"
+)
+
+type Gateway struct {
+	Device  string
+	Client  string
+	Options vpn.Options
+	Started time.Time
+
+	device  *os.File
+	channel ssh.Channel
+	// Tells lockdown the tunnel has ended
+	done func()
+}
+
+var (
+	lck      sync.Mutex
+	gateways = map[string]*Gateway{}
+)
+
+// Start attaches the device to the client's network until either end goes away or Stop is called
+func Start(actor, device, clientId string, sc ssh.Conn, opts vpn.Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	if g, ok := gateways[device]; ok {
+		return fmt.Errorf("%s is already routed through %s", device, g.Client)
+	}
+
+	tun, err := openTun(device)
+	if err != nil {
+		return err
+	}
+
+	if err := prepareTun(device, opts.MTU); err != nil {
+		tun.Close()
+		return err
+	}
+
+	channel, requests, err := sc.OpenChannel(vpn.ChannelType, ssh.Marshal(opts))
+	if err != nil {
+		tun.Close()
+		return fmt.Errorf("client refused the vpn: %s", err)
+	}
+	go ssh.DiscardRequests(requests)
+
+	done, err := lockdown.Begin(lockdown.Proxies, channel)
+	if err != nil {
+		channel.Close()
+		tun.Close()
+		return err
+	}
+
+	g := &Gateway{
+		Device:  device,
+		Client:  clientId,
+		Options: opts,
+		Started: time.Now(),
+		device:  tun,
+		channel: channel,
+		done:    done,
+	}
+	gateways[device] = g
+
+	audit.Log(actor, "vpn-start", device, fmt.Sprintf("through %s mtu %d compression %t", clientId, opts.MTU, opts.Compress))
+
+	go g.run()
+
+	return nil
+}
+
+func (g *Gateway) run() {
+	conn := vpn.NewConn(g.channel, g.Options)
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+
+		packet := make([]byte, g.Options.MTU)
+		for {
+			n, err := g.device.Read(packet)
+			if err != nil {
+				return
+			}
+
+			if err := conn.WritePacket(packet[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+
+		for {
+			packet, err := conn.ReadPacket()
+			if err != nil {
+				return
+			}
+
+			// The kernel rejects malformed packets, which shouldnt bring the whole tunnel down
+			if _, err := g.device.Write(packet); errors.Is(err, os.ErrClosed) {
+				return
+			}
+		}
+	}()
+
+	<-done
+
+	g.close()
+	g.done()
+
+	lck.Lock()
+	if gateways[g.Device] == g {
+		delete(gateways, g.Device)
+		audit.Log("server", "vpn-stop", g.Device, "tunnel through "+g.Client+" ended")
+	}
+	lck.Unlock()
+}
+
+func (g *Gateway) close() {
+	g.channel.Close()
+	g.device.Close()
+}
+
+func Stop(actor, device string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	g, ok := gateways[device]
+	if !ok {
+		return fmt.Errorf("%s is not routed through a client", device)
+	}
+
+	delete(gateways, device)
+	g.close()
+
+	audit.Log(actor, "vpn-stop", device, "through "+g.Client)
+
+	return nil
+}
+
+// List returns the running gateways sorted by device
+func List() (out []Gateway) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, g := range gateways {
+		out = append(out, *g)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Device < out[j].Device
+	})
+
+	return out
+}
@@ -0,0 +1,78 @@
+//go:build linux
+// +build linux
+
+package gateway
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openTun attaches to the named layer 3 tun device, creating it if the server is allowed to. A device made beforehand with
+// "ip tuntap add dev tun0 mode tun user <server user>" can be attached to without any privileges
+func openTun(name string) (*os.File, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open /dev/net/tun: %s", err)
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("'%s' is not a valid device name", name)
+	}
+
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("unable to attach to %s: %s", name, err)
+	}
+
+	// Non blocking so the runtime poller can interrupt reads when the device is closed
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// prepareTun makes sure the device is up with the MTU the tunnel was agreed with, only touching it if it needs changing as that takes CAP_NET_ADMIN
+func prepareTun(name string, mtu uint32) error {
+	sock, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(sock)
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.IoctlIfreq(sock, unix.SIOCGIFMTU, ifr); err != nil {
+		return err
+	}
+
+	if current := ifr.Uint32(); current != mtu {
+		ifr.SetUint32(mtu)
+		if err := unix.IoctlIfreq(sock, unix.SIOCSIFMTU, ifr); err != nil {
+			return fmt.Errorf("%s has an mtu of %d and it could not be changed to %d (%s), use --mtu %d or 'ip link set %s mtu %d'", name, current, mtu, err, current, name, mtu)
+		}
+	}
+
+	if err := unix.IoctlIfreq(sock, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+
+	if flags := ifr.Uint16(); flags&unix.IFF_UP == 0 {
+		ifr.SetUint16(flags | unix.IFF_UP)
+		if err := unix.IoctlIfreq(sock, unix.SIOCSIFFLAGS, ifr); err != nil {
+			return fmt.Errorf("%s is down and could not be brought up (%s), use 'ip link set %s up'", name, err, name)
+		}
+	}
+
+	return nil
+}
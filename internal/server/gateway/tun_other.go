@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package gateway
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("vpn gateways are only supported on linux servers")
+
+func openTun(name string) (*os.File, error) {
+	return nil, errUnsupported
+}
+
+func prepareTun(name string, mtu uint32) error {
+	return errUnsupported
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/commands"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+)
+
+// AdminSubsystem runs console commands for scripts, taking one AdminRequest a line as json and answering each with an AdminResponse
+const AdminSubsystem = "admin-json"
+
+// Requests longer than this end the session, nothing typed at a console comes close
+const maxAdminRequest = 1024 * 1024
+
+// AdminRequest is a line of the console to run, several lines are run as a script as exec runs them. ID is anything the script
+// wants to match the response to the request with
+type AdminRequest struct {
+	ID   string
+	Line string
+}
+
+// AdminResponse says how a request went. Output is everything the commands wrote, Error and Category are set when one of them
+// failed, Category is one of failed, usage, not-found, permission or transport
+type AdminResponse struct {
+	ID       string
+	OK       bool
+	Output   string
+	Error    string `json:",omitempty"`
+	Category string `json:",omitempty"`
+}
+
+// lockedBuffer collects the output of a request, commands running on several clients at once write to it together
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// admin answers requests until the operator closes the channel. Every request runs in the same shell, so variables set by one are
+// there for the next
+func admin(connection io.ReadWriter, user *internal.User, log logger.Logger, datadir string) {
+	c := commands.For(user)
+
+	shell := terminal.NewShell(c, filepath.Join(datadir, "output"))
+	shell.Context = commands.NewConsole(user, log, datadir)
+
+	encoder := json.NewEncoder(connection)
+
+	scanner := bufio.NewScanner(connection)
+	scanner.Buffer(make([]byte, 64*1024), maxAdminRequest)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var request AdminRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			encoder.Encode(AdminResponse{Error: "Request is not json: " + err.Error(), Category: string(terminal.Usage)})
+			continue
+		}
+
+		if err := encoder.Encode(adminRun(shell, c, user, request)); err != nil {
+			log.Warning("Unable to answer admin request: %s", err)
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Warning("Admin session ended: %s", err)
+	}
+}
+
+func adminRun(shell *terminal.Shell, c terminal.Commands, user *internal.User, request AdminRequest) AdminResponse {
+	response := AdminResponse{ID: request.ID}
+
+	script := strings.Split(request.Line, "\n")
+	if !knownCommand(c, script) {
+		response.Error = "Unknown RSSH command"
+		response.Category = string(terminal.NotFound)
+		return response
+	}
+
+	// Nothing is read from the script, commands that ask a question are answered as if the operator had closed the console
+	var output lockedBuffer
+	tty := terminal.WithUser(struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(""), &output}, user)
+
+	for _, line := range script {
+		if err := shell.Execute(tty, strings.TrimSuffix(line, "\r")); err != nil {
+			response.Output = output.String()
+			response.Error = err.Error()
+			response.Category = string(terminal.CategoryOf(err))
+			return response
+		}
+	}
+
+	response.OK = true
+	response.Output = output.String()
+	return response
+}
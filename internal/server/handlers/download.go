@@ -6,12 +6,18 @@ import (
 	"path"
 
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
 	"github.com/NHAS/reverse_ssh/pkg/logger"
 	"golang.org/x/crypto/ssh"
 )
 
 func Download(dataDir string) func(user *internal.User, newChannel ssh.NewChannel, log logger.Logger) {
 	return func(user *internal.User, newChannel ssh.NewChannel, log logger.Logger) {
+		if err := lockdown.Check(lockdown.Transfers); err != nil {
+			newChannel.Reject(ssh.Prohibited, err.Error())
+			return
+		}
+
 		downloadPath := path.Join("/", string(newChannel.ExtraData()))
 		//Has to be done in two steps, doing Join("./downloads/", path) leads to path traversal (thanks go)
 		downloadPath = path.Join(dataDir, "downloads", downloadPath)
@@ -40,6 +46,12 @@ func Download(dataDir string) func(user *internal.User, newChannel ssh.NewChanne
 		defer c.Close()
 		go ssh.DiscardRequests(r)
 
+		done, err := lockdown.Begin(lockdown.Transfers, c)
+		if err != nil {
+			return
+		}
+		defer done()
+
 		_, err = io.Copy(c, f)
 		if err != nil {
 			log.Warning("failed to copy to remote client: %s", err)
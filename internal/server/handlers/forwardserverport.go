@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"errors"
-	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
@@ -100,7 +100,7 @@ func ServerPortForward(clientId string) func(_ *internal.User, newChannel ssh.Ne
 
 		currentRemoteForwardsLck.Lock()
 		remoteForwards[clientId] = connection
-		currentRemoteForwards[clientId] = fmt.Sprintf("%s:%d", drtMsg.Raddr, drtMsg.Rport)
+		currentRemoteForwards[clientId] = net.JoinHostPort(drtMsg.Raddr, strconv.Itoa(int(drtMsg.Rport)))
 		currentRemoteForwardsLck.Unlock()
 
 		multiplexer.ServerMultiplexer.QueueConn(channelToConn(connection, drtMsg))
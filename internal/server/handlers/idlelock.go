@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Output written while locked is held back so it isnt shown to whoever is at the console, past this it is dropped
+const maxHeldOutput = 1024 * 1024
+
+const lockedMessage = "Console locked after inactivity.\r\nEnter your passphrase, or press enter to unlock with your forwarded ssh agent.\r\n"
+
+// idleLock sits between an operator session and its terminal, blanking the screen and swallowing input after the session has been idle
+// until the operator proves they are still the one at the console. The session itself, including any client shell it is attached to, stays up
+type idleLock struct {
+	io.ReadWriter
+
+	user  *internal.User
+	after time.Duration
+
+	mu       sync.Mutex
+	last     time.Time
+	locked   bool
+	attempt  []byte
+	held     bytes.Buffer
+	dropped  bool
+	closed   chan bool
+	closeOne sync.Once
+}
+
+func newIdleLock(rw io.ReadWriter, user *internal.User) *idleLock {
+	l := &idleLock{
+		ReadWriter: rw,
+		user:       user,
+		after:      user.LockAfter,
+		last:       time.Now(),
+		closed:     make(chan bool),
+	}
+
+	go l.watch()
+
+	return l
+}
+
+func (l *idleLock) watch() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-l.closed:
+			return
+		case <-tick.C:
+		}
+
+		l.mu.Lock()
+		if !l.locked && time.Since(l.last) >= l.after {
+			l.locked = true
+			l.attempt = l.attempt[:0]
+			l.ReadWriter.Write(append(l.blank(), lockedMessage...))
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (l *idleLock) Close() {
+	l.closeOne.Do(func() {
+		close(l.closed)
+	})
+}
+
+func (l *idleLock) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.locked {
+		return l.ReadWriter.Write(b)
+	}
+
+	if l.held.Len()+len(b) > maxHeldOutput {
+		l.dropped = true
+	} else {
+		l.held.Write(b)
+	}
+
+	return len(b), nil
+}
+
+func (l *idleLock) Read(b []byte) (int, error) {
+	for {
+		n, err := l.ReadWriter.Read(b)
+
+		l.mu.Lock()
+		l.last = time.Now()
+
+		if !l.locked {
+			l.mu.Unlock()
+			return n, err
+		}
+
+		unlocked := l.input(b[:n])
+		l.mu.Unlock()
+
+		if err != nil {
+			return 0, err
+		}
+
+		if unlocked {
+			// Ctrl+L makes the terminal, or the shell the operator is attached to, redraw over the blanked screen
+			b[0] = 12
+			return 1, nil
+		}
+	}
+}
+
+// input takes keys typed while locked, l.mu must be held
+func (l *idleLock) input(keys []byte) bool {
+	for _, k := range keys {
+		switch k {
+		case '\r', '\n':
+			attempt := string(l.attempt)
+			l.attempt = l.attempt[:0]
+
+			if l.check(attempt) {
+				l.unlock()
+				return true
+			}
+
+			l.ReadWriter.Write([]byte("Unable to unlock\r\n"))
+		case 127, 8:
+			if len(l.attempt) > 0 {
+				l.attempt = l.attempt[:len(l.attempt)-1]
+			}
+		case 3, 21:
+			l.attempt = l.attempt[:0]
+		default:
+			if k >= 0x20 {
+				l.attempt = append(l.attempt, k)
+			}
+		}
+	}
+
+	return false
+}
+
+func (l *idleLock) check(passphrase string) bool {
+	if passphrase != "" {
+		return l.user.LockPassphrase != "" && bcrypt.CompareHashAndPassword([]byte(l.user.LockPassphrase), []byte(passphrase)) == nil
+	}
+
+	return l.agentUnlock()
+}
+
+// agentUnlock asks the operators forwarded agent to sign with the key they logged in with, for a security key this needs a touch
+func (l *idleLock) agentUnlock() bool {
+	if !l.user.AgentForwarded || l.user.PublicKey == nil {
+		return false
+	}
+
+	channel, requests, err := l.user.ServerConnection.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return false
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return false
+	}
+
+	signature, err := agent.NewClient(channel).Sign(l.user.PublicKey, challenge)
+	if err != nil {
+		return false
+	}
+
+	return l.user.PublicKey.Verify(challenge, signature) == nil
+}
+
+// blank clears the screen, a dumb terminal cannot be cleared so what is on it is scrolled out of sight instead
+func (l *idleLock) blank() []byte {
+	if l.user.Pty == nil || !l.user.Pty.Dumb() {
+		return []byte("\x1b[2J\x1b[H")
+	}
+
+	rows := int(l.user.Pty.Rows)
+	if rows == 0 {
+		rows = 24
+	}
+	return bytes.Repeat([]byte("\r\n"), rows)
+}
+
+// unlock restores the screen, l.mu must be held
+func (l *idleLock) unlock() {
+	l.locked = false
+	l.last = time.Now()
+
+	l.ReadWriter.Write(l.blank())
+	l.ReadWriter.Write(l.held.Bytes())
+	if l.dropped {
+		l.ReadWriter.Write([]byte("\r\n[some output while locked was dropped]\r\n"))
+	}
+
+	l.held.Reset()
+	l.dropped = false
+}
@@ -6,14 +6,17 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"strings"
 
 	"github.com/NHAS/reverse_ssh/internal"
 	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/forwards"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
 	"github.com/NHAS/reverse_ssh/pkg/logger"
 	"golang.org/x/crypto/ssh"
 )
 
-func LocalForward(_ *internal.User, newChannel ssh.NewChannel, log logger.Logger) {
+func LocalForward(user *internal.User, newChannel ssh.NewChannel, log logger.Logger) {
 	proxyTarget := newChannel.ExtraData()
 
 	var drtMsg internal.ChannelOpenDirectMsg
@@ -35,27 +38,32 @@ func LocalForward(_ *internal.User, newChannel ssh.NewChannel, log logger.Logger
 		drtMsg.Raddr = strconv.FormatInt(value, 10)
 	}
 
-	foundClients, err := clients.Search(drtMsg.Raddr)
-	if err != nil {
-		newChannel.Reject(ssh.Prohibited, err.Error())
+	if user.Duress {
+		newChannel.Reject(ssh.ConnectionFailed, fmt.Sprintf("\n\nNo clients matched '%s'\n", drtMsg.Raddr))
 		return
 	}
 
-	if len(foundClients) == 0 {
-		newChannel.Reject(ssh.ConnectionFailed, fmt.Sprintf("\n\nNo clients matched '%s'\n", drtMsg.Raddr))
+	if err := lockdown.Check(lockdown.Proxies); err != nil {
+		newChannel.Reject(ssh.Prohibited, fmt.Sprintf("\n\n%s\n", err))
+		return
+	}
+
+	stop, err := user.StartForward()
+	if err != nil {
+		newChannel.Reject(ssh.ResourceShortage, fmt.Sprintf("\n\n%s\n", err))
 		return
 	}
+	defer stop()
 
-	if len(foundClients) > 1 {
-		newChannel.Reject(ssh.ConnectionFailed, fmt.Sprintf("\n\n'%s' matches multiple clients please choose a more specific identifier\n", drtMsg.Raddr))
+	if strings.Contains(drtMsg.Raddr, forwards.Separator) {
+		namedForward(user, newChannel, drtMsg, log)
 		return
 	}
 
-	var target ssh.Conn
-	//Horrible way of getting the first element of a map in go
-	for k := range foundClients {
-		target = foundClients[k]
-		break
+	id, target, err := clients.ResolveOne(drtMsg.Raddr)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, fmt.Sprintf("\n\n%s\n", err))
+		return
 	}
 
 	targetConnection, targetRequests, err := target.OpenChannel("jump", nil)
@@ -66,6 +74,8 @@ func LocalForward(_ *internal.User, newChannel ssh.NewChannel, log logger.Logger
 	defer targetConnection.Close()
 	go ssh.DiscardRequests(targetRequests)
 
+	clients.RecordSession(id, "jump", user.ConnectionDetails)
+
 	connection, requests, err := newChannel.Accept()
 	if err != nil {
 		newChannel.Reject(ssh.ConnectionFailed, err.Error())
@@ -74,9 +84,15 @@ func LocalForward(_ *internal.User, newChannel ssh.NewChannel, log logger.Logger
 	defer connection.Close()
 	go ssh.DiscardRequests(requests)
 
+	done, err := lockdown.Begin(lockdown.Proxies, connection)
+	if err != nil {
+		return
+	}
+	defer done()
+
 	go func() {
-		io.Copy(connection, targetConnection)
+		io.Copy(user.Metered(connection), targetConnection)
 		connection.Close()
 	}()
-	io.Copy(targetConnection, connection)
+	io.Copy(user.Metered(targetConnection), connection)
 }
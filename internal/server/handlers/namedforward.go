@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/forwards"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// namedForward connects through a forward made with the fwd command, ssh -L 8080:<name>/<host>:<port>, after checking its access list
+func namedForward(user *internal.User, newChannel ssh.NewChannel, drtMsg internal.ChannelOpenDirectMsg, log logger.Logger) {
+	name, host := drtMsg.Raddr, ""
+	if i := strings.Index(drtMsg.Raddr, forwards.Separator); i >= 0 {
+		name, host = drtMsg.Raddr[:i], drtMsg.Raddr[i+1:]
+	}
+	if ip := internal.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+
+	destination := net.JoinHostPort(host, strconv.Itoa(int(drtMsg.Rport)))
+
+	if host == "" || drtMsg.Rport > 65535 {
+		newChannel.Reject(ssh.ConnectionFailed, fmt.Sprintf("\n\n'%s' is not a destination, use <forward>%s<host>\n", drtMsg.Raddr, forwards.Separator))
+		return
+	}
+
+	clientId, compressed, err := forwards.Use(name, []string{user.ServerConnection.User(), user.KeyComment}, host, uint16(drtMsg.Rport))
+	if err != nil {
+		if err != forwards.ErrNotFound {
+			audit.Log(user.ConnectionDetails, "forward-denied", name, destination)
+		}
+		newChannel.Reject(ssh.Prohibited, fmt.Sprintf("\n\n%s\n", err))
+		return
+	}
+
+	target, err := clients.Get(clientId)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, fmt.Sprintf("\n\nThe client for forward '%s' (%s) is not connected\n", name, clientId))
+		return
+	}
+
+	targetConnection, err := clients.Dial(clientId, target, internal.ChannelOpenDirectMsg{
+		Raddr: host,
+		Rport: drtMsg.Rport,
+		Laddr: drtMsg.Laddr,
+		Lport: drtMsg.Lport,
+	}, compressed)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer targetConnection.Close()
+
+	connection, requests, err := newChannel.Accept()
+	if err != nil {
+		log.Warning("Unable to accept forward to %s through %s: %s", destination, name, err)
+		return
+	}
+	defer connection.Close()
+	go ssh.DiscardRequests(requests)
+
+	log.Info("%s connected to %s through forward %s", user.ConnectionDetails, destination, name)
+
+	go func() {
+		io.Copy(user.Metered(connection), targetConnection)
+		connection.Close()
+	}()
+	io.Copy(user.Metered(targetConnection), connection)
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
 	"github.com/NHAS/reverse_ssh/pkg/logger"
 	"golang.org/x/crypto/ssh"
 )
@@ -61,6 +62,11 @@ func RemoteDynamicForward(sshConn ssh.Conn, reqs <-chan *ssh.Request, log logger
 						}
 						return
 					}
+					if err := lockdown.Check(lockdown.Proxies); err != nil {
+						proxyCon.Close()
+						continue
+					}
+
 					go handleData(rf, proxyCon, sshConn)
 				}
 
@@ -118,6 +124,13 @@ func handleData(rf internal.RemoteForwardRequest, proxyCon net.Conn, sshConn ssh
 
 	go ssh.DiscardRequests(reqs)
 
+	done, err := lockdown.Begin(lockdown.Proxies, destination)
+	if err != nil {
+		destination.Close()
+		proxyCon.Close()
+		return err
+	}
+
 	go func() {
 		defer destination.Close()
 		defer proxyCon.Close()
@@ -125,6 +138,7 @@ func handleData(rf internal.RemoteForwardRequest, proxyCon net.Conn, sshConn ssh
 		io.Copy(destination, proxyCon)
 	}()
 	go func() {
+		defer done()
 		defer destination.Close()
 		defer proxyCon.Close()
 
@@ -3,14 +3,19 @@ package handlers
 import (
 	"fmt"
 	"io"
+	"path/filepath"
+	"strings"
 
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/i18n"
 	"github.com/NHAS/reverse_ssh/internal/server/clients"
 	"github.com/NHAS/reverse_ssh/internal/server/commands"
+	"github.com/NHAS/reverse_ssh/internal/server/preferences"
 	"github.com/NHAS/reverse_ssh/internal/server/webserver"
 	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
 	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"github.com/NHAS/reverse_ssh/pkg/trie"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -33,6 +38,8 @@ func Session(datadir string) internal.ChannelHandler {
 
 		user.ShellRequests = requests
 
+		envRank := 0
+
 		for req := range requests {
 			log.Info("Session got request: %q", req.Type)
 			switch req.Type {
@@ -47,36 +54,78 @@ func Session(datadir string) internal.ChannelHandler {
 					return
 				}
 
-				line := terminal.ParseLine(command.Cmd, 0)
-				if line.Command != nil {
-					c := commands.CreateCommands(user, log, datadir)
+				// Several lines are run as a script, sharing variables and stopping at the first line that fails
+				script := strings.Split(command.Cmd, "\n")
+
+				c := commands.For(user)
+				if !knownCommand(c, script) {
+					req.Reply(false, []byte("Unknown RSSH command"))
+					return
+				}
 
-					if m, ok := c[line.Command.Value()]; ok {
+				req.Reply(true, nil)
 
-						req.Reply(true, nil)
-						err := m.Run(connection, line)
-						if err != nil {
-							fmt.Fprintf(connection, "%s", err.Error())
-							return
-						}
+				shell := terminal.NewShell(c, filepath.Join(datadir, "output"))
+				shell.Context = commands.NewConsole(user, log, datadir)
+				output := terminal.WithUser(connection, user)
+				for _, line := range script {
+					err := shell.Execute(output, strings.TrimSuffix(line, "\r"))
+					if err != nil {
+						fmt.Fprintf(connection, "%s", i18n.Text(user.Lang, err.Error()))
 						return
 					}
 				}
-				req.Reply(false, []byte("Unknown RSSH command"))
+				return
+			case "subsystem":
+				var subsystem struct {
+					Name string
+				}
+				if ssh.Unmarshal(req.Payload, &subsystem) != nil || subsystem.Name != AdminSubsystem {
+					req.Reply(false, nil)
+					continue
+				}
+
+				req.Reply(true, nil)
+				admin(connection, user, log, datadir)
 				return
 			case "shell":
 				// We only accept the default shell
 				// (i.e. no command in the Payload)
 				req.Reply(len(req.Payload) == 0, nil)
 
-				term := terminal.NewAdvancedTerminal(connection, user, "catcher$ ")
+				var console io.ReadWriter = connection
+				if user.LockAfter > 0 {
+					lock := newIdleLock(connection, user)
+					defer lock.Close()
+
+					console = lock
+				}
+
+				operator := commands.NewConsole(user, log, datadir)
+
+				term := terminal.NewAdvancedTerminal(console, user, commands.Prompt(operator))
+				term.OutputDir = filepath.Join(datadir, "output")
+				term.PromptCallback = func() string {
+					return commands.Prompt(operator)
+				}
+				term.TitleCallback = func() string {
+					return commands.Title(user, "")
+				}
+				term.LineCallback = operator.Typed
 
-				term.SetSize(int(user.Pty.Columns), int(user.Pty.Rows))
+				if user.Pty != nil {
+					term.SetSize(int(user.Pty.Columns), int(user.Pty.Rows))
+				}
 
-				term.AddValueAutoComplete(autocomplete.RemoteId, clients.Autocomplete)
+				if user.Duress {
+					term.AddValueAutoComplete(autocomplete.RemoteId, trie.NewTrie())
+				} else {
+					term.AddValueAutoComplete(autocomplete.RemoteId, clients.Autocomplete)
+				}
 				term.AddValueAutoComplete(autocomplete.WebServerFileIds, webserver.Autocomplete)
 
-				term.AddCommands(commands.CreateCommands(user, log, datadir))
+				term.UseCommands(commands.For(user), commands.Names())
+				term.Context = operator
 
 				err := term.Run()
 				if err != nil && err != io.EOF {
@@ -96,6 +145,30 @@ func Session(datadir string) internal.ChannelHandler {
 				}
 				user.Pty = &pty
 
+				req.Reply(true, nil)
+			case "env":
+				var env struct {
+					Name  string
+					Value string
+				}
+				if ssh.Unmarshal(req.Payload, &env) != nil {
+					req.Reply(false, nil)
+					continue
+				}
+
+				rank := map[string]int{"LANG": 1, "LC_MESSAGES": 2, "LC_ALL": 3}[env.Name]
+				lang, ok := i18n.Parse(env.Value)
+
+				// A language picked with the lang command wins over whatever the ssh client happens to send, and LC_ALL over LC_MESSAGES over LANG
+				ok = ok && rank > 0 && preferences.Get(user.Operator()).Lang == ""
+				if ok && rank >= envRank {
+					user.Lang = lang
+					envRank = rank
+				}
+				req.Reply(ok, nil)
+			case "auth-agent-req@openssh.com":
+				// Only used to unlock an idle console, the agent is never offered to clients
+				user.AgentForwarded = true
 				req.Reply(true, nil)
 			default:
 				log.Warning("Unsupported request %s", req.Type)
@@ -106,3 +179,18 @@ func Session(datadir string) internal.ChannelHandler {
 		}
 	}
 }
+
+// knownCommand checks the first command of an exec request exists, so a request for a command the server doesnt have can be refused outright
+func knownCommand(c terminal.Commands, script []string) bool {
+	for _, line := range script {
+		parsed := terminal.ParseLine(line, 0)
+		if parsed.Command == nil || strings.HasPrefix(parsed.Command.Value(), "#") {
+			continue
+		}
+
+		_, ok := c.Lookup(parsed.Command.Value())
+		return ok
+	}
+
+	return false
+}
@@ -0,0 +1,178 @@
+// Package honeypot serves a fake shell to connections that failed to authenticate as an rssh client or operator,
+// recording what they try into the audit log and banning them once they leave
+package honeypot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/bans"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"golang.org/x/crypto/ssh"
+)
+
+// How long addresses that logged in to the honeypot are banned for
+const BanDuration = 24 * time.Hour
+
+const hostname = "ubuntu"
+
+// Credentials records a login attempt, every attempt succeeds so attackers go on to show what they wanted access for
+func Credentials(conn ssh.ConnMetadata, password []byte) *ssh.Permissions {
+	audit.Log(conn.RemoteAddr().String(), "honeypot-login", conn.User(), fmt.Sprintf("password %q client %q", password, conn.ClientVersion()))
+
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			"type": "honeypot",
+		},
+	}
+}
+
+func Serve(sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+	remote := sshConn.RemoteAddr().String()
+	log.Printf("Honeypot login from %s as %q", remote, sshConn.User())
+
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		sshConn.Wait()
+
+		if err := bans.Add("honeypot", internal.HostIP(remote), fmt.Sprintf("logged in to the honeypot as %q", sshConn.User()), BanDuration); err != nil {
+			log.Printf("Unable to ban honeypot visitor %s: %s", remote, err)
+		}
+	}()
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			audit.Log(remote, "honeypot-channel", newChannel.ChannelType(), fmt.Sprintf("%q", newChannel.ExtraData()))
+			newChannel.Reject(ssh.Prohibited, "administratively prohibited")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go session(sshConn.User(), remote, channel, requests)
+	}
+}
+
+func session(user, remote string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	var pty *internal.PtyReq
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			p, err := internal.ParsePtyReq(req.Payload)
+			if err == nil {
+				pty = &p
+			}
+			req.Reply(true, nil)
+
+		case "env", "window-change":
+			req.Reply(true, nil)
+
+		case "exec":
+			var command struct {
+				Cmd string
+			}
+			ssh.Unmarshal(req.Payload, &command)
+			req.Reply(true, nil)
+
+			audit.Log(remote, "honeypot-command", user, command.Cmd)
+			fmt.Fprint(channel, respond(user, command.Cmd))
+
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+
+		case "shell":
+			req.Reply(true, nil)
+
+			go ssh.DiscardRequests(requests)
+			shell(user, remote, channel, pty)
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+
+		default:
+			audit.Log(remote, "honeypot-request", user, req.Type)
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func shell(user, remote string, channel ssh.Channel, pty *internal.PtyReq) {
+	prompt := fmt.Sprintf("%s@%s:~$ ", user, hostname)
+	if user == "root" {
+		prompt = fmt.Sprintf("root@%s:~# ", hostname)
+	}
+
+	term := terminal.NewTerminal(channel, prompt)
+	if pty != nil {
+		term.SetSize(int(pty.Columns), int(pty.Rows))
+	}
+
+	fmt.Fprintf(term, "Welcome to Ubuntu 22.04.3 LTS (GNU/Linux 5.15.0-91-generic x86_64)\n\nLast login: %s from 10.0.0.1\n", time.Now().Add(-26*time.Hour).Format("Mon Jan  2 15:04:05 2006"))
+
+	for {
+		line, err := term.ReadLine()
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		audit.Log(remote, "honeypot-command", user, line)
+
+		if line == "exit" || line == "logout" {
+			return
+		}
+
+		fmt.Fprint(term, respond(user, line))
+	}
+}
+
+// respond gives just enough of a real system back that a visitor keeps going
+func respond(user, line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	home := "/home/" + user
+	if user == "root" {
+		home = "/root"
+	}
+
+	switch fields[0] {
+	case "whoami":
+		return user + "\n"
+	case "id":
+		if user == "root" {
+			return "uid=0(root) gid=0(root) groups=0(root)\n"
+		}
+		return fmt.Sprintf("uid=1000(%s) gid=1000(%s) groups=1000(%s)\n", user, user, user)
+	case "pwd":
+		return home + "\n"
+	case "hostname":
+		return hostname + "\n"
+	case "uname":
+		if len(fields) > 1 {
+			return "Linux " + hostname + " 5.15.0-91-generic #101-Ubuntu SMP Tue Nov 14 13:30:08 UTC 2023 x86_64 x86_64 x86_64 GNU/Linux\n"
+		}
+		return "Linux\n"
+	case "ls", "cd", "export", "unset", "history", "clear":
+		return ""
+	case "echo":
+		return strings.Join(fields[1:], " ") + "\n"
+	}
+
+	return fmt.Sprintf("-bash: %s: command not found\n", fields[0])
+}
@@ -0,0 +1,215 @@
+// Package hostkey loads the key the server proves itself with. It is kept in the data directory by default, or held by an ssh agent
+// fronting a PKCS#11 token or TPM so that signing happens on the device and the private key is never on disk
+package hostkey
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	lock    sync.RWMutex
+	current ssh.Signer
+)
+
+// Path is where the server key is kept in dataDir. FIPS mode has an ecdsa key of its own, as ed25519 is not approved there
+func Path(dataDir string) string {
+	if internal.FIPS() {
+		return filepath.Join(dataDir, "id_ecdsa")
+	}
+	return filepath.Join(dataDir, "id_ed25519")
+}
+
+// Load reads the key file at path, generating one first if there isnt one. An encrypted key is asked for its passphrase on the terminal
+func Load(path string) (ssh.Signer, error) {
+	return load(path, "")
+}
+
+func load(path, passphrase string) (ssh.Signer, error) {
+
+	//If we have already created a private key (or there is one in the current directory) dont overwrite/create another one
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+
+		privateKeyPem, err := internal.GeneratePrivateKey()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to generate private key, and no private key specified: %s", err)
+		}
+
+		err = ioutil.WriteFile(path, privateKeyPem, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to write private key to disk: %s", err)
+		}
+	}
+
+	privateBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load private key (%s): %s", path, err)
+	}
+
+	private, err := parse(privateBytes, passphrase)
+	if err == ErrEncrypted {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse private key: %s", err)
+	}
+
+	return private, nil
+}
+
+// Open loads the key uri names:
+//
+//	empty                        the key file in dataDir, made if it doesnt exist
+//	file:/path/to/key            a key file, which has to exist
+//	agent:[/path/to/socket]      a key held by the ssh agent at the socket, $SSH_AUTH_SOCK if none is given
+//
+// An agent with several keys needs ?fingerprint=SHA256:... to say which one, as ssh-keygen -l prints it. Encrypted key files are unlocked
+// with the passphrase from the passphrase source, as Passphrase takes it
+func Open(uri, passphrase, dataDir string) (ssh.Signer, error) {
+	if uri == "" {
+		return load(Path(dataDir), passphrase)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("host key %s: %s", uri, err)
+	}
+
+	// file:relative and agent:relative are opaque, file:/absolute and file:///absolute have a path
+	path := u.Opaque
+	if path == "" {
+		path = u.Path
+	}
+
+	switch u.Scheme {
+	case "file":
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("host key %s: %s", uri, err)
+		}
+		return load(path, passphrase)
+
+	case "agent":
+		socket := path
+		if socket == "" {
+			socket = os.Getenv("SSH_AUTH_SOCK")
+			if socket == "" {
+				return nil, errors.New("host key agent: no socket given and SSH_AUTH_SOCK is not set")
+			}
+		}
+
+		return openAgent(socket, fingerprintOf(u))
+
+	case "pkcs11", "tpm":
+		return nil, fmt.Errorf("host key %s: %s keys are used through an ssh agent holding them, such as ssh-agent after ssh-add -s <module> or ssh-tpm-agent, give agent:<socket> instead", uri, u.Scheme)
+	}
+
+	return nil, fmt.Errorf("host key %s: unknown scheme %q, expected file or agent", uri, u.Scheme)
+}
+
+// fingerprintOf is the ?fingerprint= of an agent uri. Fingerprints are base64, so a + is taken as it is rather than as a space
+func fingerprintOf(u *url.URL) string {
+	return strings.ReplaceAll(u.Query().Get("fingerprint"), " ", "+")
+}
+
+func openAgent(socket, fingerprint string) (ssh.Signer, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the ssh agent at %s: %s", socket, err)
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the keys the ssh agent at %s holds: %s", socket, err)
+	}
+
+	var matched []*agent.Key
+	for _, k := range keys {
+		if fingerprint == "" || ssh.FingerprintSHA256(k) == fingerprint {
+			matched = append(matched, k)
+		}
+	}
+
+	switch {
+	case len(matched) == 0 && fingerprint != "":
+		return nil, fmt.Errorf("the ssh agent at %s does not hold %s", socket, fingerprint)
+	case len(matched) == 0:
+		return nil, fmt.Errorf("the ssh agent at %s holds no keys", socket)
+	case len(matched) > 1:
+		var fingerprints []string
+		for _, k := range matched {
+			fingerprints = append(fingerprints, ssh.FingerprintSHA256(k))
+		}
+		return nil, fmt.Errorf("the ssh agent at %s holds %d keys, choose one with ?fingerprint= (%s)", socket, len(matched), strings.Join(fingerprints, ", "))
+	}
+
+	key, err := ssh.ParsePublicKey(matched[0].Marshal())
+	if err != nil {
+		return nil, err
+	}
+
+	return &agentSigner{socket: socket, key: key}, nil
+}
+
+// agentSigner asks the agent for every signature over a connection of its own, so an agent that restarts, or a token that is
+// pulled out and put back, doesnt need the server restarted
+type agentSigner struct {
+	socket string
+	key    ssh.PublicKey
+}
+
+func (a *agentSigner) PublicKey() ssh.PublicKey {
+	return a.key
+}
+
+func (a *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return a.SignWithAlgorithm(rand, data, "")
+}
+
+// SignWithAlgorithm lets rsa keys sign with sha2, as the hardened algorithm profiles require
+func (a *agentSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.KeyAlgoRSASHA256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512:
+		flags = agent.SignatureFlagRsaSha512
+	}
+
+	conn, err := net.Dial("unix", a.socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the ssh agent holding the host key: %s", err)
+	}
+	defer conn.Close()
+
+	return agent.NewClient(conn).SignWithFlags(a.key, data, flags)
+}
+
+// Set makes s the key Current returns, the server sets it once it has loaded its key
+func Set(s ssh.Signer) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	current = s
+}
+
+// Current is the key the running server signs with, nil before it has started
+func Current() ssh.Signer {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return current
+}
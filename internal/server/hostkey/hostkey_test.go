@@ -0,0 +1,116 @@
+package hostkey
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveAgent runs an agent holding keys on a socket in a temporary directory
+func serveAgent(t *testing.T, keys ...interface{}) string {
+	keyring := agent.NewKeyring()
+	for _, k := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: k}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	return socket
+}
+
+func TestAgent(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := serveAgent(t, edKey, rsaKey)
+
+	if _, err := Open("agent:"+socket, "", ""); err == nil || !strings.Contains(err.Error(), "holds 2 keys") {
+		t.Fatalf("expected an agent with two keys to need a fingerprint, got %v", err)
+	}
+
+	rsaPublic, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := Open("agent:"+socket+"?fingerprint="+ssh.FingerprintSHA256(rsaPublic), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(signer.PublicKey().Marshal()) != string(rsaPublic.Marshal()) {
+		t.Fatal("expected the key chosen by fingerprint")
+	}
+
+	sig, err := signer.(ssh.AlgorithmSigner).SignWithAlgorithm(rand.Reader, []byte("data"), ssh.KeyAlgoRSASHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sig.Format != ssh.KeyAlgoRSASHA512 {
+		t.Errorf("expected a %s signature, got %s", ssh.KeyAlgoRSASHA512, sig.Format)
+	}
+
+	if err := rsaPublic.Verify([]byte("data"), sig); err != nil {
+		t.Errorf("signature from the agent did not verify: %s", err)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", serveAgent(t, edKey))
+	if _, err := Open("agent:", "", ""); err != nil {
+		t.Errorf("expected SSH_AUTH_SOCK to be used when no socket is given: %s", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	generated, err := Open("", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Open("file:"+Path(dir), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(generated.PublicKey().Marshal()) != string(loaded.PublicKey().Marshal()) {
+		t.Error("expected file: to load the key generated in the data directory")
+	}
+
+	for _, uri := range []string{"file:" + filepath.Join(dir, "missing"), "pkcs11:token=rssh", "nonsense:x"} {
+		if _, err := Open(uri, "", dir); err == nil {
+			t.Errorf("expected %s to be refused", uri)
+		}
+	}
+}
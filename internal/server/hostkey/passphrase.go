@@ -0,0 +1,27 @@
+package hostkey
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readPassphrase asks on the terminal with echo turned off where the platform lets us
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	restore, err := noEcho(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to turn off echo to read the passphrase: %s", err)
+	}
+	defer restore()
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package hostkey
+
+import "errors"
+
+// Without a way to tell, there is never a terminal to ask at and a passphrase source has to be given
+func isTerminal() bool {
+	return false
+}
+
+func noEcho(fd int) (func(), error) {
+	return nil, errors.New("not supported on this platform")
+}
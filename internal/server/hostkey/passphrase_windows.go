@@ -0,0 +1,25 @@
+package hostkey
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+func isTerminal() bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(os.Stdin.Fd()), &mode) == nil
+}
+
+func noEcho(fd int) (func(), error) {
+	var old uint32
+	if err := windows.GetConsoleMode(windows.Handle(fd), &old); err != nil {
+		return nil, err
+	}
+
+	if err := windows.SetConsoleMode(windows.Handle(fd), (old&^windows.ENABLE_ECHO_INPUT)|windows.ENABLE_PROCESSED_INPUT|windows.ENABLE_LINE_INPUT); err != nil {
+		return nil, err
+	}
+
+	return func() { windows.SetConsoleMode(windows.Handle(fd), old) }, nil
+}
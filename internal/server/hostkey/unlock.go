@@ -0,0 +1,392 @@
+package hostkey
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Keys encrypted by Encrypt are kept as this pem block, ssh-keygen -p encrypted openssh keys are read as well
+const encryptedBlock = "RSSH ENCRYPTED PRIVATE KEY"
+
+// scrypt parameters for new keys, old keys keep the ones they were encrypted with in their headers
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+const unlockProgramTimeout = 30 * time.Second
+
+// Signed by an agent key to derive a passphrase from it, the key has to sign deterministically so the passphrase is the same every time
+const agentChallenge = "rssh host key passphrase"
+
+// ErrEncrypted is returned for an encrypted key when there is no passphrase source and no terminal to ask at
+var ErrEncrypted = errors.New("the server key is encrypted, give --host-key-passphrase to unlock it (prompt, env:NAME, file:/path, agent:[socket][?fingerprint=...] or exec:/path/to/program)")
+
+// Passphrase gets the passphrase a key is unlocked with from source:
+//
+//	prompt                       asked for on the terminal
+//	env:NAME                     the environment variable NAME
+//	file:/path                   the first line of a file
+//	agent:[/path/to/socket]      derived from a signature by a key an ssh agent holds, ed25519 or rsa as they sign deterministically
+//	exec:/path/to/program        what a program prints, such as one asking a KMS to decrypt the passphrase
+//
+// An empty source prompts when there is a terminal
+func Passphrase(source string) ([]byte, error) {
+	if source == "" {
+		if !isTerminal() {
+			return nil, ErrEncrypted
+		}
+		source = "prompt"
+	}
+
+	kind, rest := source, ""
+	if i := strings.IndexByte(source, ':'); i != -1 {
+		kind, rest = source[:i], source[i+1:]
+	}
+
+	switch kind {
+	case "prompt":
+		return readPassphrase("Server key passphrase: ")
+
+	case "env":
+		p, ok := os.LookupEnv(rest)
+		if !ok || p == "" {
+			return nil, fmt.Errorf("host key passphrase: %s is not set", rest)
+		}
+		return []byte(p), nil
+
+	case "file":
+		f, err := os.Open(rest)
+		if err != nil {
+			return nil, fmt.Errorf("host key passphrase: %s", err)
+		}
+		defer f.Close()
+
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line == "" {
+			return nil, fmt.Errorf("host key passphrase: %s is empty (%v)", rest, err)
+		}
+		return []byte(line), nil
+
+	case "agent":
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("host key passphrase: %s", err)
+		}
+
+		socket := u.Opaque
+		if socket == "" {
+			socket = u.Path
+		}
+		if socket == "" {
+			socket = os.Getenv("SSH_AUTH_SOCK")
+		}
+
+		return agentPassphrase(socket, fingerprintOf(u))
+
+	case "exec":
+		return programPassphrase(rest)
+	}
+
+	return nil, fmt.Errorf("host key passphrase: unknown source %q, expected prompt, env, file, agent or exec", kind)
+}
+
+func agentPassphrase(socket, fingerprint string) ([]byte, error) {
+	if socket == "" {
+		return nil, errors.New("host key passphrase: no agent socket given and SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the ssh agent at %s: %s", socket, err)
+	}
+	defer conn.Close()
+
+	client := agent.NewClient(conn)
+	keys, err := client.List()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the keys the ssh agent at %s holds: %s", socket, err)
+	}
+
+	var chosen *agent.Key
+	for _, k := range keys {
+		if fingerprint != "" && ssh.FingerprintSHA256(k) != fingerprint {
+			continue
+		}
+
+		if k.Type() != ssh.KeyAlgoED25519 && k.Type() != ssh.KeyAlgoRSA {
+			if fingerprint != "" {
+				return nil, fmt.Errorf("%s is a %s key, only ed25519 and rsa keys sign the same way every time", fingerprint, k.Type())
+			}
+			continue
+		}
+
+		if chosen != nil {
+			return nil, fmt.Errorf("the ssh agent at %s holds several ed25519 or rsa keys, choose one with ?fingerprint=", socket)
+		}
+		chosen = k
+	}
+
+	if chosen == nil {
+		return nil, fmt.Errorf("the ssh agent at %s holds no ed25519 or rsa key to unlock the server key with", socket)
+	}
+
+	var flags agent.SignatureFlags
+	if chosen.Type() == ssh.KeyAlgoRSA {
+		flags = agent.SignatureFlagRsaSha512
+	}
+
+	sig, err := client.SignWithFlags(chosen, []byte(agentChallenge+"\x00"+ssh.FingerprintSHA256(chosen)), flags)
+	if err != nil {
+		return nil, fmt.Errorf("the ssh agent refused to sign: %s", err)
+	}
+
+	h := sha256.Sum256(sig.Blob)
+	return []byte(base64.RawStdEncoding.EncodeToString(h[:])), nil
+}
+
+func programPassphrase(program string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), unlockProgramTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, program)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("host key passphrase program timed out after %s", unlockProgramTimeout)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("host key passphrase program failed: %s %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	p := bytes.TrimRight(stdout.Bytes(), "\r\n")
+	if len(p) == 0 {
+		return nil, errors.New("host key passphrase program printed nothing")
+	}
+	return p, nil
+}
+
+func deriveKey(passphrase, salt []byte, n, r, p int) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, n, r, p, 32)
+}
+
+// encrypt seals a pkcs8 key with the passphrase
+func encrypt(pkcs8, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key, err := deriveKey(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type: encryptedBlock,
+		Headers: map[string]string{
+			"KDF":  "scrypt",
+			"Salt": hex.EncodeToString(salt),
+			"N":    strconv.Itoa(scryptN),
+			"R":    strconv.Itoa(scryptR),
+			"P":    strconv.Itoa(scryptP),
+		},
+		Bytes: gcm.Seal(nonce, nonce, pkcs8, nil),
+	}), nil
+}
+
+func decrypt(b *pem.Block, passphrase []byte) (ssh.Signer, error) {
+	if b.Headers["KDF"] != "scrypt" {
+		return nil, fmt.Errorf("server key is encrypted with an unknown kdf %q", b.Headers["KDF"])
+	}
+
+	salt, err := hex.DecodeString(b.Headers["Salt"])
+	if err != nil {
+		return nil, fmt.Errorf("server key has a bad salt: %s", err)
+	}
+
+	var params [3]int
+	for i, name := range []string{"N", "R", "P"} {
+		params[i], err = strconv.Atoi(b.Headers[name])
+		if err != nil {
+			return nil, fmt.Errorf("server key has a bad scrypt %s: %s", name, err)
+		}
+	}
+
+	key, err := deriveKey(passphrase, salt, params[0], params[1], params[2])
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b.Bytes) < gcm.NonceSize() {
+		return nil, errors.New("server key is truncated")
+	}
+
+	pkcs8, err := gcm.Open(nil, b.Bytes[:gcm.NonceSize()], b.Bytes[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase for the server key")
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(private)
+}
+
+// parse reads a key file, unlocking it with the passphrase from source if it is encrypted
+func parse(contents []byte, source string) (ssh.Signer, error) {
+	if b, _ := pem.Decode(contents); b != nil && b.Type == encryptedBlock {
+		passphrase, err := Passphrase(source)
+		if err != nil {
+			return nil, err
+		}
+		return decrypt(b, passphrase)
+	}
+
+	private, err := ssh.ParsePrivateKey(contents)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		passphrase, err := Passphrase(source)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.ParsePrivateKeyWithPassphrase(contents, passphrase)
+	}
+
+	return private, err
+}
+
+// Encrypt rewrites the plaintext key file at path encrypted with the passphrase from source. A prompted passphrase is asked for twice
+func Encrypt(path, source string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if b, _ := pem.Decode(contents); b != nil && b.Type == encryptedBlock {
+		return errors.New("the server key is already encrypted")
+	}
+
+	raw, err := ssh.ParseRawPrivateKey(contents)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return errors.New("the server key is already encrypted by ssh-keygen, remove its passphrase with ssh-keygen -p first")
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse private key: %s", err)
+	}
+
+	// openssh format ed25519 keys are parsed to a pointer, which pkcs8 doesnt take
+	if k, ok := raw.(*ed25519.PrivateKey); ok {
+		raw = *k
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(raw)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := newPassphrase(source)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := encrypt(pkcs8, passphrase)
+	if err != nil {
+		return err
+	}
+
+	// Checked before the plaintext key is overwritten, so a mistake here cannot lose the key
+	b, _ := pem.Decode(encrypted)
+	if _, err := decrypt(b, passphrase); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, encrypted, 0600)
+}
+
+func newPassphrase(source string) ([]byte, error) {
+	if source != "" && source != "prompt" {
+		return Passphrase(source)
+	}
+
+	if !isTerminal() {
+		return nil, errors.New("there is no terminal to ask for a passphrase at, give --host-key-passphrase")
+	}
+
+	first, err := readPassphrase("New server key passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(first) == 0 {
+		return nil, errors.New("the passphrase is empty")
+	}
+
+	second, err := readPassphrase("Again: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(first, second) {
+		return nil, errors.New("the passphrases do not match")
+	}
+
+	return first, nil
+}
@@ -0,0 +1,102 @@
+package hostkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) {
+	dir := t.TempDir()
+
+	plain, err := Open("", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("RSSH_TEST_PASSPHRASE", "correct horse")
+	if err := Encrypt(Path(dir), "env:RSSH_TEST_PASSPHRASE"); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(Path(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(contents, []byte(encryptedBlock)) {
+		t.Fatal("expected the key file to be encrypted")
+	}
+
+	if _, err := Open("", "", dir); err != ErrEncrypted {
+		t.Fatalf("expected an encrypted key with no passphrase or terminal to be refused, got %v", err)
+	}
+
+	unlocked, err := Open("", "env:RSSH_TEST_PASSPHRASE", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(plain.PublicKey().Marshal(), unlocked.PublicKey().Marshal()) {
+		t.Error("expected the unlocked key to be the key that was encrypted")
+	}
+
+	passphraseFile := filepath.Join(dir, "passphrase")
+	os.WriteFile(passphraseFile, []byte("wrong\n"), 0600)
+	if _, err := Open("", "file:"+passphraseFile, dir); err == nil {
+		t.Error("expected the wrong passphrase to be refused")
+	}
+
+	if err := Encrypt(Path(dir), "env:RSSH_TEST_PASSPHRASE"); err == nil {
+		t.Error("expected an encrypted key not to be encrypted again")
+	}
+}
+
+func TestAgentPassphrase(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	socket := serveAgent(t, edKey)
+
+	first, err := Passphrase("agent:" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := Passphrase("agent:" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Fatal("expected an agent key to give the same passphrase every time")
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Passphrase("agent:" + serveAgent(t, ecKey)); err == nil {
+		t.Error("expected an agent with only an ecdsa key, which signs differently every time, to be refused")
+	}
+}
+
+func TestProgramPassphrase(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "kms")
+	if err := os.WriteFile(program, []byte("#!/bin/sh\necho from-kms\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Passphrase("exec:" + program)
+	if err != nil {
+		t.Skip("unable to run a shell script here: ", err)
+	}
+
+	if string(p) != "from-kms" {
+		t.Errorf("expected what the program printed, got %q", p)
+	}
+}
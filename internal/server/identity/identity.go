@@ -0,0 +1,249 @@
+// Package identity owns the client keys in authorized_controllee_keys that are changed at runtime, enrolling new keys and rotating
+// the key of a connected client in place
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/forwards"
+	"github.com/NHAS/reverse_ssh/internal/server/persistence"
+	"github.com/NHAS/reverse_ssh/internal/server/toolpacks"
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrNotRecorded = errors.New("client key is not in authorized_controllee_keys, was it connected in insecure mode?")
+
+var (
+	lck  sync.Mutex
+	dir  string
+	path string
+)
+
+func Start(datadir string) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	dir = datadir
+	path = filepath.Join(datadir, "authorized_controllee_keys")
+}
+
+// unused refuses a key already trusted by any of the key files. The server will not start with a key in both authorized_keys and
+// authorized_controllee_keys, and a key two clients share lets either pass as the other
+func unused(key ssh.PublicKey) error {
+	for _, name := range []string{"authorized_keys", "authorized_proxy_keys", "authorized_controllee_keys"} {
+		found, err := contains(filepath.Join(dir, name), key)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			return fmt.Errorf("key %s is already in %s", internal.FingerprintSHA1Hex(key), name)
+		}
+	}
+
+	return nil
+}
+
+func contains(file string, key ssh.PublicKey) (bool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	want := key.Marshal()
+	for len(b) > 0 {
+		// Lines that are not keys are skipped, the error is only for running out of them
+		existing, _, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			break
+		}
+
+		if bytes.Equal(existing.Marshal(), want) {
+			return true, nil
+		}
+		b = rest
+	}
+
+	return false, nil
+}
+
+func keyLine(options []string, key ssh.PublicKey, comment string) string {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if len(options) > 0 {
+		line = strings.Join(options, ",") + " " + line
+	}
+	if comment != "" {
+		line += " " + comment
+	}
+
+	return line + "\n"
+}
+
+// Enroll adds a client key with the given options, i.e engagement="name". A key that is already trusted for anything is refused
+func Enroll(key ssh.PublicKey, options []string, comment string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if err := unused(key); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("cant open authorized controllee keys file: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(keyLine(options, key, comment)); err != nil {
+		return fmt.Errorf("cant write enrolled key: %s", err)
+	}
+
+	return nil
+}
+
+// replace swaps every entry for the key with fingerprint old to key, keeping their options and comments. Like Enroll it refuses a
+// key that is already trusted, which a client could otherwise rotate to
+func replace(old string, key ssh.PublicKey) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if err := unused(key); err != nil {
+		return err
+	}
+
+	return rewriteLocked(old, func(options []string, existing ssh.PublicKey, comment string) string {
+		return keyLine(options, key, comment)
+	})
+}
+
+// SetFlag adds or removes an option without a value, such as compress, from every entry for the key with the fingerprint
+func SetFlag(fingerprint, flag string, on bool) error {
+	return rewrite(fingerprint, func(options []string, key ssh.PublicKey, comment string) string {
+		var kept []string
+		for _, o := range options {
+			if o != flag {
+				kept = append(kept, o)
+			}
+		}
+
+		if on {
+			kept = append(kept, flag)
+		}
+
+		return keyLine(kept, key, comment)
+	})
+}
+
+// rewrite replaces every entry for the key with the fingerprint with what change returns
+func rewrite(fingerprint string, change func(options []string, key ssh.PublicKey, comment string) string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return rewriteLocked(fingerprint, change)
+}
+
+func rewriteLocked(fingerprint string, change func(options []string, key ssh.PublicKey, comment string) string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	var (
+		out   strings.Builder
+		found bool
+	)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		existing, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil || internal.FingerprintSHA1Hex(existing) != fingerprint {
+			out.WriteString(line + "\n")
+			continue
+		}
+
+		found = true
+		out.WriteString(change(options, existing, comment))
+	}
+	f.Close()
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrNotRecorded
+	}
+
+	// Write then rename so a crash never leaves the server with neither key trusted
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(out.String()), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Rotate has a connected client generate a new key, then trusts that key instead of the one it connected with.
+// The client keeps this connection and uses the new key from its next handshake
+func Rotate(actor, id string, sc *ssh.ServerConn) (string, error) {
+	old := sc.Permissions.Extensions["pubkey-fp"]
+
+	ok, reply, err := sc.SendRequest("rotate-key", true, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		if len(reply) == 0 {
+			return "", errors.New("client does not support key rotation")
+		}
+		return "", errors.New(string(reply))
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(reply)
+	if err != nil {
+		return "", fmt.Errorf("client sent an invalid key: %s", err)
+	}
+
+	// The client offers its old key as a fallback, so failing here leaves it connectable
+	if err := replace(old, key); err != nil {
+		return "", fmt.Errorf("refused the key the client rotated to: %s", err)
+	}
+
+	fingerprint := internal.FingerprintSHA1Hex(key)
+
+	if err := clients.Rekey(id, fingerprint); err != nil {
+		return "", err
+	}
+
+	if err := persistence.Rekey(old, fingerprint); err != nil {
+		return "", fmt.Errorf("rotated key, but could not move persistence records: %s", err)
+	}
+
+	if err := forwards.Rekey(old, fingerprint); err != nil {
+		return "", fmt.Errorf("rotated key, but could not move forwards: %s", err)
+	}
+
+	if err := toolpacks.Rekey(old, fingerprint); err != nil {
+		return "", fmt.Errorf("rotated key, but could not move tool pack deployments: %s", err)
+	}
+
+	audit.Log(actor, "rotate-key", id, fmt.Sprintf("%s -> %s", old, fingerprint))
+
+	return fingerprint, nil
+}
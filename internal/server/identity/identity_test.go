@@ -0,0 +1,104 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+)
+
+func newKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// startWith starts in a new datadir with operator and proxy already trusted
+func startWith(t *testing.T, operator, proxy ssh.PublicKey) string {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "authorized_keys"), []byte("# operators\n"+keyLine([]string{`role="operator"`}, operator, "op")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "authorized_proxy_keys"), []byte(keyLine(nil, proxy, "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	Start(dir)
+	return dir
+}
+
+func TestEnroll(t *testing.T) {
+	operator, proxy, client := newKey(t), newKey(t), newKey(t)
+	dir := startWith(t, operator, proxy)
+
+	if err := Enroll(client, nil, "client"); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		key  ssh.PublicKey
+		file string
+	}{
+		{operator, "authorized_keys"},
+		{proxy, "authorized_proxy_keys"},
+		{client, "authorized_controllee_keys"},
+	}
+
+	for _, c := range checks {
+		if err := Enroll(c.key, nil, ""); err == nil || !strings.Contains(err.Error(), c.file) {
+			t.Fatalf("expected a key in %s to be refused, got %v", c.file, err)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "authorized_controllee_keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(b), "\n") != 1 {
+		t.Fatalf("expected only the first enrollment to be written, got:\n%s", b)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	operator, proxy, client, other := newKey(t), newKey(t), newKey(t), newKey(t)
+	dir := startWith(t, operator, proxy)
+
+	for _, k := range []ssh.PublicKey{client, other} {
+		if err := Enroll(k, []string{"compress"}, "c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := internal.FingerprintSHA1Hex(client)
+	for _, k := range []ssh.PublicKey{operator, proxy, other, client} {
+		if err := replace(old, k); err == nil {
+			t.Fatalf("expected rotating to %s to be refused", internal.FingerprintSHA1Hex(k))
+		}
+	}
+
+	rotated := newKey(t)
+	if err := replace(old, rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "authorized_controllee_keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := keyLine([]string{"compress"}, rotated, "c") + keyLine([]string{"compress"}, other, "c"); string(b) != want {
+		t.Fatalf("expected only the client's key to change, got:\n%s", b)
+	}
+}
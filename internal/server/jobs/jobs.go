@@ -0,0 +1,332 @@
+// Package jobs keeps console commands that run in the background, such as an exec across every client or a scan, along with
+// everything they output. Output is written to a file as it arrives, so a job can be looked at while it runs and after the
+// operator that started it has disconnected
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+)
+
+const (
+	// Output past this is dropped, the job carries on running
+	MaxOutput = 16 * 1024 * 1024
+	// Finished jobs older than the most recent of these are removed, along with their output
+	MaxJobs = 100
+)
+
+const (
+	Running = "running"
+	Done    = "done"
+	Failed  = "failed"
+	// The server stopped while the job was running
+	Interrupted = "interrupted"
+)
+
+type Job struct {
+	ID   string
+	Line string
+	// Owner is the login key of the operator that started the job, By is the connection they started it from
+	Owner string
+	By    string
+
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+	State    string
+	Error    string `json:",omitempty"`
+
+	// How far through its clients a job running on several has got
+	Done   int `json:",omitempty"`
+	Failed int `json:",omitempty"`
+	Total  int `json:",omitempty"`
+}
+
+var (
+	lck     sync.Mutex
+	path    string
+	outputs string
+	jobs    = map[string]*Job{}
+	running = map[string]*Output{}
+)
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "jobs.json")
+	outputs = filepath.Join(datadir, "jobs")
+
+	if err := os.MkdirAll(outputs, 0700); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &jobs); err != nil {
+		return fmt.Errorf("unable to parse jobs.json: %s", err)
+	}
+
+	for _, j := range jobs {
+		if j.State == Running {
+			j.State = Interrupted
+		}
+	}
+
+	return save()
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(jobs, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+func outputPath(id string) string {
+	return filepath.Join(outputs, id+".log")
+}
+
+// Output is a running job, written to by whatever the job is running and finished once it has returned
+type Output struct {
+	id string
+
+	lck     sync.Mutex
+	f       *os.File
+	written int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (o *Output) Write(p []byte) (int, error) {
+	o.lck.Lock()
+	defer o.lck.Unlock()
+
+	if o.written >= MaxOutput {
+		return len(p), nil
+	}
+
+	keep := p
+	if o.written+len(keep) > MaxOutput {
+		keep = keep[:MaxOutput-o.written]
+	}
+
+	n, err := o.f.Write(keep)
+	o.written += n
+	if err != nil {
+		return n, err
+	}
+
+	if o.written >= MaxOutput {
+		o.f.WriteString("\n[output cut off]\n")
+	}
+
+	return len(p), nil
+}
+
+// Progress records how many of the clients a job is running on it has finished with, and how many of those failed
+func (o *Output) Progress(done, failed, total int) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if j, ok := jobs[o.id]; ok {
+		j.Done, j.Failed, j.Total = done, failed, total
+	}
+}
+
+// ID is the id of the job the output is for
+func (o *Output) ID() string {
+	return o.id
+}
+
+// Stopped is closed once the job has been asked to stop, what it is running should start on nothing new after that
+func (o *Output) Stopped() <-chan struct{} {
+	return o.stop
+}
+
+// Finish marks the job as done, or failed if err is set
+func (o *Output) Finish(err error) {
+	o.lck.Lock()
+	o.f.Close()
+	o.lck.Unlock()
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	delete(running, o.id)
+
+	j, ok := jobs[o.id]
+	if !ok {
+		return
+	}
+
+	j.Finished = time.Now()
+	j.State = Done
+	if err != nil {
+		j.State = Failed
+		j.Error = err.Error()
+	}
+
+	save()
+
+	audit.Log(j.By, "job-"+j.State, j.ID, j.Line)
+}
+
+// Begin records a new job for line, giving where its output should be written
+func Begin(owner, by, line string) (Job, *Output, error) {
+	id, err := internal.RandomString(4)
+	if err != nil {
+		return Job{}, nil, err
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	if path == "" {
+		return Job{}, nil, fmt.Errorf("jobs have not been started")
+	}
+
+	f, err := os.OpenFile(outputPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return Job{}, nil, err
+	}
+
+	j := &Job{ID: id, Line: line, Owner: owner, By: by, Started: time.Now(), State: Running}
+	jobs[id] = j
+	if err := save(); err != nil {
+		delete(jobs, id)
+		f.Close()
+		os.Remove(outputPath(id))
+		return Job{}, nil, err
+	}
+
+	prune()
+
+	audit.Log(by, "job-start", id, line)
+
+	o := &Output{id: id, f: f, stop: make(chan struct{})}
+	running[id] = o
+
+	return *j, o, nil
+}
+
+// prune removes the oldest finished jobs past MaxJobs, it is called with lck held
+func prune() {
+	if len(jobs) <= MaxJobs {
+		return
+	}
+
+	var finished []*Job
+	for _, j := range jobs {
+		if j.State != Running {
+			finished = append(finished, j)
+		}
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Started.Before(finished[j].Started)
+	})
+
+	for i := 0; i < len(finished) && len(jobs) > MaxJobs; i++ {
+		delete(jobs, finished[i].ID)
+		os.Remove(outputPath(finished[i].ID))
+	}
+
+	save()
+}
+
+// List is every job, oldest first
+func List() []Job {
+	lck.Lock()
+	defer lck.Unlock()
+
+	out := make([]Job, 0, len(jobs))
+	for _, j := range jobs {
+		out = append(out, *j)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Started.Before(out[j].Started)
+	})
+
+	return out
+}
+
+func Get(id string) (Job, bool) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	j, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// Read is everything a job has output so far
+func Read(id string) ([]byte, error) {
+	if _, ok := Get(id); !ok {
+		return nil, fmt.Errorf("job %q not found", id)
+	}
+
+	return os.ReadFile(outputPath(id))
+}
+
+// Stop asks a running job to stop, it finishes what it has already started
+func Stop(actor, id string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	o, ok := running[id]
+	if !ok {
+		return fmt.Errorf("job %s is not running", id)
+	}
+
+	o.stopOnce.Do(func() { close(o.stop) })
+
+	audit.Log(actor, "job-stop", id, jobs[id].Line)
+
+	return nil
+}
+
+// Delete removes a finished job and its output
+func Delete(actor, id string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	j, ok := jobs[id]
+	if !ok {
+		return fmt.Errorf("job %q not found", id)
+	}
+
+	if j.State == Running {
+		return fmt.Errorf("job %s is still running", id)
+	}
+
+	delete(jobs, id)
+	os.Remove(outputPath(id))
+
+	audit.Log(actor, "job-delete", id, j.Line)
+
+	return save()
+}
@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJobs(t *testing.T) {
+	dir := t.TempDir()
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { path, jobs, running = "", map[string]*Job{}, map[string]*Output{} }()
+
+	finished, out, err := Begin("key", "alice@10.0.0.2:51234", "exec -y * uptime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte("up 3 days\n"))
+	out.Finish(nil)
+
+	failed, out, err := Begin("key", "alice@10.0.0.2:51234", "scan web01 10.0.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte("10.0.0.1:22\n"))
+	out.Finish(errors.New("client went away"))
+
+	interrupted, out, err := Begin("key", "alice@10.0.0.2:51234", "exec -y * sleep 60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Progress(2, 1, 10)
+
+	if j, _ := Get(interrupted.ID); j.Done != 2 || j.Failed != 1 || j.Total != 10 {
+		t.Errorf("expected the progress of the job to be kept, got %+v", j)
+	}
+
+	if err := Stop("alice", interrupted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-out.Stopped():
+	default:
+		t.Error("expected the job to be told to stop")
+	}
+
+	if err := Stop("alice", finished.ID); err == nil {
+		t.Error("expected a finished job not to be stopped")
+	}
+
+	if err := Delete("alice", interrupted.ID); err == nil {
+		t.Error("expected a running job not to be deleted")
+	}
+
+	// Reloaded from disk, as the server would after a restart
+	jobs, running = map[string]*Job{}, map[string]*Output{}
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, state := range map[string]string{finished.ID: Done, failed.ID: Failed, interrupted.ID: Interrupted} {
+		if j, ok := Get(id); !ok || j.State != state {
+			t.Errorf("expected job %s to be %s, got %+v", id, state, j)
+		}
+	}
+
+	if b, err := Read(finished.ID); err != nil || string(b) != "up 3 days\n" {
+		t.Errorf("expected the output of the job to be kept, got %q: %v", b, err)
+	}
+
+	if j, _ := Get(failed.ID); j.Error != "client went away" {
+		t.Errorf("expected why the job failed to be kept, got %q", j.Error)
+	}
+
+	if l := List(); len(l) != 3 || l[0].ID != finished.ID {
+		t.Errorf("expected every job oldest first, got %+v", l)
+	}
+
+	if err := Delete("alice", finished.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Read(finished.ID); err == nil {
+		t.Error("expected a deleted job to have no output")
+	}
+}
+
+func TestOutputCutOff(t *testing.T) {
+	if err := Start(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { path, jobs, running = "", map[string]*Job{}, map[string]*Output{} }()
+
+	j, out, err := Begin("key", "alice", "exec -y * yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := []byte(strings.Repeat("y\n", 1024))
+	for written := 0; written <= MaxOutput; written += len(chunk) {
+		if n, err := out.Write(chunk); err != nil || n != len(chunk) {
+			t.Fatalf("expected writes to carry on past the limit, got %d: %v", n, err)
+		}
+	}
+	out.Finish(nil)
+
+	b, _ := Read(j.ID)
+	if len(b) > MaxOutput+len("\n[output cut off]\n") || !strings.HasSuffix(string(b), "[output cut off]\n") {
+		t.Errorf("expected long output to be cut off, got %d bytes", len(b))
+	}
+}
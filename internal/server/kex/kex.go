@@ -0,0 +1,242 @@
+// Package kex works out which algorithms each connection negotiated. x/crypto/ssh doesnt expose this, so the first
+// (unencrypted) KEXINIT in each direction is read off the wire and negotiated the same way the handshake does
+package kex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Only the version line and first packet are inspected, anything bigger than this is not a KEXINIT we care about
+const maxSniff = 64 * 1024
+
+type Algorithms struct {
+	KeyExchange string
+	HostKey     string
+	CipherIn    string
+	CipherOut   string
+	MACIn       string
+	MACOut      string
+}
+
+type kexInit struct {
+	Cookie                  [16]byte `sshtype:"20"`
+	KexAlgos                []string
+	ServerHostKeyAlgos      []string
+	CiphersClientServer     []string
+	CiphersServerClient     []string
+	MACsClientServer        []string
+	MACsServerClient        []string
+	CompressionClientServer []string
+	CompressionServerClient []string
+	LanguagesClientServer   []string
+	LanguagesServerClient   []string
+	FirstKexFollows         bool
+	Reserved                uint32
+}
+
+// sniffer collects one direction of the stream until it has the peers KEXINIT
+type sniffer struct {
+	buf  []byte
+	done bool
+	init *kexInit
+}
+
+func (s *sniffer) feed(b []byte) {
+	if s.done {
+		return
+	}
+
+	s.buf = append(s.buf, b...)
+
+	// Skip the identification string (and any banner lines before it)
+	start := 0
+	for {
+		end := bytes.Index(s.buf[start:], []byte("\n"))
+		if end == -1 {
+			s.giveUp()
+			return
+		}
+
+		line := s.buf[start : start+end]
+		start += end + 1
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			break
+		}
+	}
+
+	packet := s.buf[start:]
+	if len(packet) < 5 {
+		s.giveUp()
+		return
+	}
+
+	length := binary.BigEndian.Uint32(packet)
+	padding := uint32(packet[4])
+	if length > maxSniff || padding+1 > length {
+		s.done = true
+		return
+	}
+
+	if uint32(len(packet)-4) < length {
+		s.giveUp()
+		return
+	}
+
+	var init kexInit
+	if err := ssh.Unmarshal(packet[5:4+length-padding], &init); err == nil {
+		s.init = &init
+	}
+
+	s.done = true
+	s.buf = nil
+}
+
+// giveUp stops waiting once far more has been read than any KEXINIT needs
+func (s *sniffer) giveUp() {
+	if len(s.buf) > maxSniff {
+		s.done = true
+		s.buf = nil
+	}
+}
+
+// Conn records the KEXINIT each side sent while passing everything through untouched
+type Conn struct {
+	net.Conn
+
+	lck      sync.Mutex
+	in, out  sniffer
+	isServer bool
+}
+
+func Watch(c net.Conn, isServer bool) *Conn {
+	return &Conn{Conn: c, isServer: isServer}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.lck.Lock()
+		c.in.feed(b[:n])
+		c.lck.Unlock()
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.lck.Lock()
+	c.out.feed(b)
+	c.lck.Unlock()
+
+	return c.Conn.Write(b)
+}
+
+var aead = map[string]bool{
+	"aes128-gcm@openssh.com":        true,
+	"aes256-gcm@openssh.com":        true,
+	"chacha20-poly1305@openssh.com": true,
+}
+
+func firstCommon(client, server []string) string {
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c
+			}
+		}
+	}
+	return ""
+}
+
+// Negotiated returns what was agreed on from our side of the connection, In being what the peer sends us
+func (c *Conn) Negotiated() (Algorithms, bool) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	if c.in.init == nil || c.out.init == nil {
+		return Algorithms{}, false
+	}
+
+	client, server := c.in.init, c.out.init
+	if !c.isServer {
+		client, server = server, client
+	}
+
+	a := Algorithms{
+		KeyExchange: firstCommon(client.KexAlgos, server.KexAlgos),
+		HostKey:     firstCommon(client.ServerHostKeyAlgos, server.ServerHostKeyAlgos),
+	}
+
+	c2sCipher, s2cCipher := firstCommon(client.CiphersClientServer, server.CiphersClientServer), firstCommon(client.CiphersServerClient, server.CiphersServerClient)
+	c2sMAC, s2cMAC := firstCommon(client.MACsClientServer, server.MACsClientServer), firstCommon(client.MACsServerClient, server.MACsServerClient)
+
+	if c.isServer {
+		a.CipherIn, a.CipherOut, a.MACIn, a.MACOut = c2sCipher, s2cCipher, c2sMAC, s2cMAC
+	} else {
+		a.CipherIn, a.CipherOut, a.MACIn, a.MACOut = s2cCipher, c2sCipher, s2cMAC, c2sMAC
+	}
+
+	// AEAD ciphers carry their own integrity, whatever MAC was agreed on is never used
+	if aead[a.CipherIn] {
+		a.MACIn = ""
+	}
+
+	if aead[a.CipherOut] {
+		a.MACOut = ""
+	}
+
+	return a, true
+}
+
+type Connection struct {
+	Type       string
+	Conn       *ssh.ServerConn
+	Algorithms Algorithms
+}
+
+var (
+	lck  sync.Mutex
+	live = map[*ssh.ServerConn]tracked{}
+)
+
+type tracked struct {
+	connType string
+	watch    *Conn
+}
+
+// Track remembers a connection until it closes so its algorithms can be listed
+func Track(connType string, sc *ssh.ServerConn, watch *Conn) {
+	lck.Lock()
+	live[sc] = tracked{connType: connType, watch: watch}
+	lck.Unlock()
+
+	go func() {
+		sc.Wait()
+
+		lck.Lock()
+		delete(live, sc)
+		lck.Unlock()
+	}()
+}
+
+func Live() []Connection {
+	lck.Lock()
+	defer lck.Unlock()
+
+	var out []Connection
+	for sc, t := range live {
+		a, _ := t.watch.Negotiated()
+		out = append(out, Connection{Type: t.connType, Conn: sc, Algorithms: a})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Conn.RemoteAddr().String() < out[j].Conn.RemoteAddr().String()
+	})
+
+	return out
+}
@@ -0,0 +1,82 @@
+package kex
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNegotiated(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.KeyExchanges = []string{"ecdh-sha2-nistp256", "curve25519-sha256"}
+	serverConfig.Ciphers = []string{"aes256-ctr", "aes128-gcm@openssh.com"}
+	serverConfig.MACs = []string{"hmac-sha2-256"}
+	serverConfig.AddHostKey(signer)
+
+	clientConfig := &ssh.ClientConfig{
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	clientConfig.KeyExchanges = []string{"curve25519-sha256", "ecdh-sha2-nistp256"}
+	clientConfig.Ciphers = []string{"aes128-gcm@openssh.com", "aes256-ctr"}
+
+	// net.Pipe is unbuffered, and both sides of the handshake write their version before reading
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		c, err := ssh.Dial("tcp", l.Addr().String(), clientConfig)
+		if err == nil {
+			c.Close()
+		}
+		errs <- err
+	}()
+
+	serverSide, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watched := Watch(serverSide, true)
+
+	sc, _, _, err := ssh.NewServerConn(watched, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	a, ok := watched.Negotiated()
+	if !ok {
+		t.Fatal("did not capture both KEXINITs")
+	}
+
+	// The client's preference wins
+	expected := Algorithms{
+		KeyExchange: "curve25519-sha256",
+		HostKey:     ssh.KeyAlgoED25519,
+		CipherIn:    "aes128-gcm@openssh.com",
+		CipherOut:   "aes128-gcm@openssh.com",
+	}
+	if a != expected {
+		t.Fatalf("expected %+v got %+v", expected, a)
+	}
+}
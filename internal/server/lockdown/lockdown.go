@@ -0,0 +1,184 @@
+// Package lockdown turns off whole parts of the server for every operator and client at once, for when the thing being
+// responded to is the server itself. What is turned off stays off across restarts until an admin turns it back on
+package lockdown
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+)
+
+const (
+	// Commands run on clients with exec
+	Exec = "exec"
+	// Connections relayed through the server to or from clients: jumps, forwards, tcp, vpn and proxy connections
+	Proxies = "proxies"
+	// Files clients download from the server
+	Transfers = "transfers"
+)
+
+var Subsystems = []string{Exec, Proxies, Transfers}
+
+type State struct {
+	By    string
+	Since time.Time
+}
+
+var (
+	lck      sync.Mutex
+	path     string
+	disabled = map[string]State{}
+	// What is in flight in each subsystem, so it can be cut off
+	active = map[string]map[*io.Closer]bool{}
+)
+
+func known(subsystem string) error {
+	for _, s := range Subsystems {
+		if s == subsystem {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown subsystem %q, expected one of %q", subsystem, Subsystems)
+}
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "lockdown.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &disabled); err != nil {
+		return fmt.Errorf("unable to parse lockdown.json: %s", err)
+	}
+
+	return nil
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(disabled, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Disable refuses anything new in the subsystem, and if kill is set closes everything already running in it, returning how many were closed
+func Disable(actor, subsystem string, kill bool) (int, error) {
+	if err := known(subsystem); err != nil {
+		return 0, err
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, ok := disabled[subsystem]; !ok {
+		disabled[subsystem] = State{By: actor, Since: time.Now()}
+		if err := save(); err != nil {
+			delete(disabled, subsystem)
+			return 0, err
+		}
+	}
+
+	killed := 0
+	if kill {
+		for c := range active[subsystem] {
+			(*c).Close()
+			killed++
+		}
+		delete(active, subsystem)
+	}
+
+	audit.Log(actor, "lockdown-disable", subsystem, fmt.Sprintf("killed %d", killed))
+
+	return killed, nil
+}
+
+func Enable(actor, subsystem string) error {
+	if err := known(subsystem); err != nil {
+		return err
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, ok := disabled[subsystem]; !ok {
+		return fmt.Errorf("%s is not disabled", subsystem)
+	}
+
+	delete(disabled, subsystem)
+	audit.Log(actor, "lockdown-enable", subsystem, "")
+
+	return save()
+}
+
+// Disabled returns who turned the subsystem off and when, if it is off
+func Disabled(subsystem string) (State, bool) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	s, ok := disabled[subsystem]
+	return s, ok
+}
+
+// Active is how many operations are running in the subsystem
+func Active(subsystem string) int {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return len(active[subsystem])
+}
+
+func refused(subsystem string, s State) error {
+	return fmt.Errorf("%s has been disabled on this server by %s", subsystem, s.By)
+}
+
+// Check returns an error if the subsystem has been turned off
+func Check(subsystem string) error {
+	if s, ok := Disabled(subsystem); ok {
+		return refused(subsystem, s)
+	}
+	return nil
+}
+
+// Begin records an operation in the subsystem, which is closed with c if the subsystem is disabled with kill. Call the returned func once it ends
+func Begin(subsystem string, c io.Closer) (func(), error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if s, ok := disabled[subsystem]; ok {
+		return nil, refused(subsystem, s)
+	}
+
+	if active[subsystem] == nil {
+		active[subsystem] = map[*io.Closer]bool{}
+	}
+
+	key := &c
+	active[subsystem][key] = true
+
+	return func() {
+		lck.Lock()
+		defer lck.Unlock()
+
+		delete(active[subsystem], key)
+	}, nil
+}
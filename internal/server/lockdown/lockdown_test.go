@@ -0,0 +1,63 @@
+package lockdown
+
+import (
+	"testing"
+)
+
+type closer struct {
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestDisable(t *testing.T) {
+	running, finished := &closer{}, &closer{}
+
+	doneRunning, err := Begin(Proxies, running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doneRunning()
+
+	doneFinished, err := Begin(Proxies, finished)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doneFinished()
+
+	if _, err := Begin(Exec, &closer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	killed, err := Disable("test", Proxies, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if killed != 1 || !running.closed || finished.closed {
+		t.Errorf("only the running proxy should be closed, closed %d", killed)
+	}
+
+	if _, err := Begin(Proxies, &closer{}); err == nil {
+		t.Error("a disabled subsystem accepted something new")
+	}
+
+	if err := Check(Exec); err != nil || Active(Exec) != 1 {
+		t.Error("disabling proxies affected exec")
+	}
+
+	if err := Enable("test", Proxies); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Check(Proxies); err != nil {
+		t.Errorf("enabled subsystem still refused: %s", err)
+	}
+
+	if _, err := Disable("test", "shells", false); err == nil {
+		t.Error("unknown subsystem was disabled")
+	}
+}
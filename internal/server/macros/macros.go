@@ -0,0 +1,179 @@
+// Package macros keeps sequences of console commands operators recorded, to be played again later against other clients. A
+// macro belongs to whoever recorded it, and once shared can be played by every operator
+package macros
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("no macro with that name")
+	ErrNotOwner = errors.New("that macro belongs to someone else")
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+type Macro struct {
+	Name string
+	// The login key of the operator that recorded it, and who they were when they did
+	Owner string
+	By    string
+
+	Shared bool
+	// Lines as they were typed, with variables such as $target left to be filled in when it is played
+	Lines    []string
+	Recorded time.Time
+}
+
+var (
+	lck    sync.Mutex
+	path   string
+	macros = map[string]Macro{}
+)
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "macros.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &macros); err != nil {
+		return fmt.Errorf("unable to parse macros.json: %s", err)
+	}
+
+	return nil
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(macros, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Save keeps a macro, replacing one of the same name if the same operator recorded it
+func Save(m Macro) error {
+	if !validName.MatchString(m.Name) {
+		return fmt.Errorf("macro names are letters, numbers, '.', '_' and '-'")
+	}
+
+	if len(m.Lines) == 0 {
+		return fmt.Errorf("nothing was recorded")
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	old, existed := macros[m.Name]
+	if existed && old.Owner != m.Owner {
+		return ErrNotOwner
+	}
+
+	macros[m.Name] = m
+	if err := save(); err != nil {
+		if existed {
+			macros[m.Name] = old
+		} else {
+			delete(macros, m.Name)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the macro named if the operator recorded it or it has been shared
+func Get(operator, name string) (Macro, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	m, ok := macros[name]
+	if !ok || (m.Owner != operator && !m.Shared) {
+		return Macro{}, ErrNotFound
+	}
+
+	return m, nil
+}
+
+// List returns the macros the operator can play sorted by name, all of them for everyone when all is set
+func List(operator string, all bool) (out []Macro) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, m := range macros {
+		if all || m.Owner == operator || m.Shared {
+			out = append(out, m)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Share lets every operator play a macro, or stops them. Only its owner can, unless override is set
+func Share(operator, name string, shared, override bool) error {
+	return change(operator, name, override, func(m *Macro) bool {
+		m.Shared = shared
+		return true
+	})
+}
+
+// Delete removes a macro, only its owner can unless override is set
+func Delete(operator, name string, override bool) error {
+	return change(operator, name, override, func(m *Macro) bool {
+		return false
+	})
+}
+
+// change applies f to the macro, removing it if f returns false
+func change(operator, name string, override bool, f func(*Macro) bool) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	old, ok := macros[name]
+	if !ok || (old.Owner != operator && !old.Shared && !override) {
+		return ErrNotFound
+	}
+
+	if old.Owner != operator && !override {
+		return ErrNotOwner
+	}
+
+	m := old
+	if f(&m) {
+		macros[name] = m
+	} else {
+		delete(macros, name)
+	}
+
+	if err := save(); err != nil {
+		macros[name] = old
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package macros
+
+import (
+	"testing"
+)
+
+func TestMacros(t *testing.T) {
+	dir := t.TempDir()
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { path = "" }()
+
+	m := Macro{Name: "triage", Owner: "alice", Lines: []string{"info $target", "exec -y $target uptime"}}
+	if err := Save(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Get("bob", "triage"); err != ErrNotFound {
+		t.Errorf("expected an unshared macro to be hidden from others, got %v", err)
+	}
+
+	if err := Save(Macro{Name: "triage", Owner: "bob", Lines: []string{"ls"}}); err != ErrNotOwner {
+		t.Errorf("expected someone else's macro not to be replaced, got %v", err)
+	}
+
+	if err := Share("alice", "triage", true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reloaded from disk, as the server would after a restart
+	macros = map[string]Macro{}
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Get("bob", "triage")
+	if err != nil || len(got.Lines) != 2 || got.Lines[1] != "exec -y $target uptime" {
+		t.Fatalf("expected the shared macro to be played by others, got %+v: %v", got, err)
+	}
+
+	if err := Delete("bob", "triage", false); err != ErrNotOwner {
+		t.Errorf("expected only the owner to delete a shared macro, got %v", err)
+	}
+
+	if err := Delete("bob", "triage", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(List("alice", true)) != 0 {
+		t.Error("expected the macro to be gone")
+	}
+
+	if err := Save(Macro{Name: "bad name", Owner: "alice", Lines: []string{"ls"}}); err == nil {
+		t.Error("expected a name with a space to be refused")
+	}
+}
@@ -0,0 +1,109 @@
+// Package manifests remembers what sync last left in each directory it wrote to on a client, so the next sync of a slightly changed
+// directory compares against it and sends only the blocks that changed. Each client has a file of its own under sync/ in the data
+// directory, named by its key fingerprint so it follows the client across reconnects
+package manifests
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/pkg/delta"
+)
+
+// File is a file as sync left it on a client
+type File struct {
+	delta.Signature
+	Mode os.FileMode
+
+	// What the client said the file was modified at once it was written. If the client has a different time, or size, something
+	// else has written to the file since and what is here cannot be trusted
+	Modified time.Time
+}
+
+// Manifest is every file sync wrote under one directory of a client, by their paths relative to it
+type Manifest map[string]File
+
+var (
+	lck sync.Mutex
+	dir string
+
+	validFingerprint = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+)
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	dir = filepath.Join(datadir, "sync")
+	return os.MkdirAll(dir, 0700)
+}
+
+func load(fingerprint string) (map[string]Manifest, error) {
+	if !validFingerprint.MatchString(fingerprint) {
+		return nil, fmt.Errorf("invalid client fingerprint %q", fingerprint)
+	}
+
+	all := map[string]Manifest{}
+	if dir == "" {
+		return all, nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, fingerprint+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return all, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, fmt.Errorf("unable to parse the sync manifest of %s: %s", fingerprint, err)
+	}
+
+	return all, nil
+}
+
+// Load returns what was last synced to remote on the client with fingerprint, which is empty if nothing has been
+func Load(fingerprint, remote string) (Manifest, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	all, err := load(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	if m, ok := all[remote]; ok {
+		return m, nil
+	}
+	return Manifest{}, nil
+}
+
+// Save records what was synced to remote on the client with fingerprint, leaving what it holds for its other directories alone
+func Save(fingerprint, remote string, m Manifest) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	all, err := load(fingerprint)
+	if err != nil {
+		return err
+	}
+
+	if dir == "" {
+		return nil
+	}
+
+	all[remote] = m
+
+	b, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, fingerprint+".json"), b, 0600)
+}
@@ -0,0 +1,46 @@
+package manifests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/pkg/delta"
+)
+
+func TestManifests(t *testing.T) {
+	if err := Start(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { dir = "" }()
+
+	const fingerprint = "0f6ffecb15d75574e5e9"
+
+	m, err := Load(fingerprint, "/opt/kit")
+	if err != nil || len(m) != 0 {
+		t.Fatalf("expected nothing synced yet, got %v: %v", m, err)
+	}
+
+	written := time.Unix(1700000000, 0)
+	m["bin/tool"] = File{Signature: delta.Signature{Size: 3, SHA256: "abc", Blocks: []string{"abc"}}, Mode: 0755, Modified: written}
+	if err := Save(fingerprint, "/opt/kit", m); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Save(fingerprint, "/tmp/other", Manifest{"a": File{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(fingerprint, "/opt/kit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, ok := got["bin/tool"]
+	if !ok || f.Mode != 0755 || !f.Modified.Equal(written) || f.Blocks[0] != "abc" {
+		t.Fatalf("expected the saved file back alongside the other directory, got %+v", got)
+	}
+
+	if _, err := Load("../../etc/passwd", "/opt/kit"); err == nil {
+		t.Error("expected a fingerprint that is not hex to be refused")
+	}
+}
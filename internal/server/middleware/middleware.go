@@ -0,0 +1,149 @@
+// Package middleware runs hooks around operator sessions and the console commands run in them. Hooks are run in the order they
+// were added, and any of the before hooks can refuse a session or a command by returning an error, which the operator is shown.
+// They can also annotate a session or command, annotations made before are seen by every later hook, after hooks included.
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/pkg/command"
+)
+
+// Session is an operator login, once its key has been accepted
+type Session struct {
+	command.Session
+
+	// Kept for as long as the operator is logged in and logged by the server as the session starts. Only BeforeSession hooks
+	// should change them, the commands of a session can run at once from more than one channel
+	Annotations map[string]string
+}
+
+// Command is one run of a console command, Line is the line as the command is given it
+type Command struct {
+	Session *Session
+
+	Name string
+	Line string
+
+	Annotations map[string]string
+}
+
+// Hook is any of the four, those left nil are skipped
+type Hook struct {
+	Name string
+
+	// BeforeSession refuses the login with an error, the connection is then closed without any channels being opened on it
+	BeforeSession func(s *Session) error
+	// AfterSession is called as the operator disconnects, for sessions every BeforeSession let through
+	AfterSession func(s *Session)
+
+	// BeforeCommand refuses to run the command with an error
+	BeforeCommand func(c *Command) error
+	// AfterCommand is given what the command returned, for commands every BeforeCommand let through
+	AfterCommand func(c *Command, err error)
+}
+
+var (
+	lock  sync.RWMutex
+	hooks []Hook
+)
+
+// Use adds hook to the end of the chain, it is not safe to call once the server is taking logins
+func Use(hook Hook) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if hook.Name == "" {
+		return errors.New("hooks must have a name")
+	}
+
+	for _, h := range hooks {
+		if h.Name == hook.Name {
+			return fmt.Errorf("there is already a hook named %s", hook.Name)
+		}
+	}
+
+	hooks = append(hooks, hook)
+	return nil
+}
+
+// Enabled is whether any hooks have been added, so commands need not be wrapped when there are none
+func Enabled() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return len(hooks) > 0
+}
+
+func chain() []Hook {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return hooks
+}
+
+// Of describes the session of user, sharing its annotations
+func Of(user *internal.User) *Session {
+	user.Lock()
+	defer user.Unlock()
+
+	if user.Annotations == nil {
+		user.Annotations = map[string]string{}
+	}
+
+	return &Session{
+		Session:     command.Session{Operator: user.Operator(), Role: user.Role, Connection: user.ConnectionDetails},
+		Annotations: user.Annotations,
+	}
+}
+
+// BeforeSession runs the before session hooks until one refuses the session
+func BeforeSession(s *Session) error {
+	for _, h := range chain() {
+		if h.BeforeSession == nil {
+			continue
+		}
+
+		if err := h.BeforeSession(s); err != nil {
+			return fmt.Errorf("refused by %s: %w", h.Name, err)
+		}
+	}
+	return nil
+}
+
+// AfterSession runs the after session hooks, last added first
+func AfterSession(s *Session) {
+	hooks := chain()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].AfterSession != nil {
+			hooks[i].AfterSession(s)
+		}
+	}
+}
+
+// BeforeCommand runs the before command hooks until one refuses the command
+func BeforeCommand(c *Command) error {
+	for _, h := range chain() {
+		if h.BeforeCommand == nil {
+			continue
+		}
+
+		if err := h.BeforeCommand(c); err != nil {
+			return fmt.Errorf("%s refused by %s: %w", c.Name, h.Name, err)
+		}
+	}
+	return nil
+}
+
+// AfterCommand runs the after command hooks, last added first
+func AfterCommand(c *Command, err error) {
+	hooks := chain()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].AfterCommand != nil {
+			hooks[i].AfterCommand(c, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	defer func() { hooks = nil }()
+
+	var calls []string
+	record := func(name string) Hook {
+		return Hook{
+			Name: name,
+			BeforeCommand: func(c *Command) error {
+				calls = append(calls, "before "+name)
+				if c.Name == name {
+					return errors.New("not this one")
+				}
+				c.Annotations[name] = "seen"
+				return nil
+			},
+			AfterCommand: func(c *Command, err error) {
+				calls = append(calls, "after "+name)
+			},
+		}
+	}
+
+	if Enabled() {
+		t.Fatal("enabled without any hooks")
+	}
+
+	for _, name := range []string{"first", "second"} {
+		if err := Use(record(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if Use(record("first")) == nil || Use(Hook{}) == nil {
+		t.Fatal("expected hooks without a name or with the name of another to be refused")
+	}
+
+	c := &Command{Session: &Session{}, Name: "ls", Annotations: map[string]string{}}
+	if err := BeforeCommand(c); err != nil {
+		t.Fatal(err)
+	}
+	AfterCommand(c, nil)
+
+	if strings.Join(calls, ", ") != "before first, before second, after second, after first" {
+		t.Errorf("hooks ran out of order: %v", calls)
+	}
+
+	if len(c.Annotations) != 2 {
+		t.Errorf("expected both hooks to annotate the command, got %v", c.Annotations)
+	}
+
+	calls = nil
+	err := BeforeCommand(&Command{Session: &Session{}, Name: "first", Annotations: map[string]string{}})
+	if err == nil || err.Error() != "first refused by first: not this one" {
+		t.Errorf("expected the command to be refused by the first hook, got %v", err)
+	}
+
+	if len(calls) != 1 {
+		t.Errorf("expected the chain to stop at the hook that refused, got %v", calls)
+	}
+}
@@ -0,0 +1,174 @@
+// Package netmap merges the interface, route and neighbour tables reported by clients into a graph of which client can reach which network
+package netmap
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+const (
+	KindClient = "client"
+	KindSubnet = "subnet"
+	KindHost   = "host"
+
+	// Edge kinds
+	Direct    = "direct"
+	Routed    = "routed"
+	Neighbour = "neighbour"
+)
+
+type ClientNetwork struct {
+	ID       string
+	Hostname string
+
+	Interfaces []internal.Interface
+	Routes     []internal.Route
+	Neighbours []internal.Neighbour
+}
+
+type Node struct {
+	ID    string
+	Kind  string
+	Label string
+}
+
+type Edge struct {
+	From string
+	To   string
+	Kind string
+	// Interface for direct and neighbour edges, gateway for routed ones
+	Via string
+}
+
+type Graph struct {
+	Nodes []Node
+	Edges []Edge
+}
+
+// Networks that say nothing about what a client can reach
+func uninteresting(n *net.IPNet) bool {
+	ones, bits := n.Mask.Size()
+	return ones == 0 || ones == bits || n.IP.IsLoopback() || n.IP.IsLinkLocalUnicast() || n.IP.IsMulticast() || n.IP.IsInterfaceLocalMulticast()
+}
+
+func Build(networks []ClientNetwork) Graph {
+	nodes := map[string]Node{}
+	edges := map[[2]string]Edge{}
+
+	addEdge := func(e Edge) {
+		key := [2]string{e.From, e.To}
+		// Being directly attached trumps being routed to
+		if existing, ok := edges[key]; ok && existing.Kind == Direct {
+			return
+		}
+		edges[key] = e
+	}
+
+	addSubnet := func(n *net.IPNet) string {
+		id := "subnet:" + n.String()
+		nodes[id] = Node{ID: id, Kind: KindSubnet, Label: n.String()}
+		return id
+	}
+
+	for _, c := range networks {
+		clientID := "client:" + c.ID
+		nodes[clientID] = Node{ID: clientID, Kind: KindClient, Label: c.Hostname}
+
+		var attached []*net.IPNet
+
+		for _, iface := range c.Interfaces {
+			for _, a := range iface.Addresses {
+				_, n, err := net.ParseCIDR(a)
+				if err != nil || uninteresting(n) {
+					continue
+				}
+
+				attached = append(attached, n)
+				addEdge(Edge{From: clientID, To: addSubnet(n), Kind: Direct, Via: iface.Name})
+			}
+		}
+
+		for _, r := range c.Routes {
+			_, n, err := net.ParseCIDR(r.Destination)
+			if err != nil || uninteresting(n) {
+				continue
+			}
+
+			gw := net.ParseIP(r.Gateway)
+			if gw == nil || gw.IsUnspecified() {
+				attached = append(attached, n)
+				addEdge(Edge{From: clientID, To: addSubnet(n), Kind: Direct, Via: r.Interface})
+				continue
+			}
+
+			addEdge(Edge{From: clientID, To: addSubnet(n), Kind: Routed, Via: r.Gateway})
+		}
+
+		for _, nb := range c.Neighbours {
+			ip := net.ParseIP(nb.IP)
+			if ip == nil || nb.MAC == "" || nb.State == "incomplete" || nb.State == "failed" {
+				continue
+			}
+
+			for _, n := range attached {
+				if n.Contains(ip) {
+					hostID := "host:" + ip.String()
+					nodes[hostID] = Node{ID: hostID, Kind: KindHost, Label: ip.String()}
+					addEdge(Edge{From: "subnet:" + n.String(), To: hostID, Kind: Neighbour, Via: nb.Interface})
+					break
+				}
+			}
+		}
+	}
+
+	var g Graph
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, n)
+	}
+	for _, e := range edges {
+		g.Edges = append(g.Edges, e)
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+
+	return g
+}
+
+func (g Graph) DOT() string {
+	var sb strings.Builder
+
+	sb.WriteString("digraph rssh {\n")
+	for _, n := range g.Nodes {
+		shape := "ellipse"
+		switch n.Kind {
+		case KindClient:
+			shape = "box"
+		case KindHost:
+			shape = "point"
+		}
+
+		fmt.Fprintf(&sb, "\t%q [label=%q shape=%s];\n", n.ID, n.Label, shape)
+	}
+
+	for _, e := range g.Edges {
+		style := "solid"
+		if e.Kind == Routed {
+			style = "dashed"
+		}
+
+		fmt.Fprintf(&sb, "\t%q -> %q [label=%q style=%s];\n", e.From, e.To, e.Via, style)
+	}
+	sb.WriteString("}\n")
+
+	return sb.String()
+}
@@ -0,0 +1,61 @@
+package netmap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+func TestBuild(t *testing.T) {
+	g := Build([]ClientNetwork{
+		{
+			ID:       "a",
+			Hostname: "web01",
+			Interfaces: []internal.Interface{
+				{Name: "lo", Addresses: []string{"127.0.0.1/8"}},
+				{Name: "eth0", Addresses: []string{"10.0.0.5/24", "fe80::1/64"}},
+			},
+			Routes: []internal.Route{
+				{Destination: "0.0.0.0/0", Gateway: "10.0.0.1", Interface: "eth0"},
+				{Destination: "10.0.0.0/24", Gateway: "0.0.0.0", Interface: "eth0"},
+				{Destination: "172.16.0.0/16", Gateway: "10.0.0.254", Interface: "eth0"},
+			},
+			Neighbours: []internal.Neighbour{
+				{IP: "10.0.0.1", MAC: "aa:bb:cc:dd:ee:ff", Interface: "eth0", State: "reachable"},
+				{IP: "10.0.0.9", Interface: "eth0", State: "incomplete"},
+			},
+		},
+		{
+			ID:         "b",
+			Hostname:   "db01",
+			Interfaces: []internal.Interface{{Name: "eth0", Addresses: []string{"10.0.0.6/24"}}},
+		},
+	})
+
+	expectedEdges := []Edge{
+		{From: "client:a", To: "subnet:10.0.0.0/24", Kind: Direct, Via: "eth0"},
+		{From: "client:a", To: "subnet:172.16.0.0/16", Kind: Routed, Via: "10.0.0.254"},
+		{From: "client:b", To: "subnet:10.0.0.0/24", Kind: Direct, Via: "eth0"},
+		{From: "subnet:10.0.0.0/24", To: "host:10.0.0.1", Kind: Neighbour, Via: "eth0"},
+	}
+
+	if len(g.Edges) != len(expectedEdges) {
+		t.Fatalf("expected %d edges got %d: %+v", len(expectedEdges), len(g.Edges), g.Edges)
+	}
+
+	for i := range expectedEdges {
+		if g.Edges[i] != expectedEdges[i] {
+			t.Fatalf("edge %d expected %+v got %+v", i, expectedEdges[i], g.Edges[i])
+		}
+	}
+
+	if len(g.Nodes) != 5 {
+		t.Fatalf("expected 5 nodes got %d: %+v", len(g.Nodes), g.Nodes)
+	}
+
+	dot := g.DOT()
+	if !strings.Contains(dot, `"client:a" -> "subnet:172.16.0.0/16" [label="10.0.0.254" style=dashed];`) {
+		t.Fatalf("routed edge missing from dot output:\n%s", dot)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
 	"github.com/NHAS/reverse_ssh/pkg/observer"
 )
 
@@ -15,6 +16,7 @@ type ClientState struct {
 	HostName  string
 	Version   string
 	Timestamp time.Time
+	Sequence  sequence.Stamp
 }
 
 func (cs ClientState) Summary() string {
@@ -26,3 +28,24 @@ func (cs ClientState) Json() ([]byte, error) {
 }
 
 var ConnectionState = observer.New(ClientState{})
+
+// Alert is something operators should hear about that isnt a client connecting or disconnecting
+type Alert struct {
+	Kind      string
+	Message   string
+	Timestamp time.Time
+	Sequence  sequence.Stamp
+
+	// Only webhooks whose url contains this are sent the alert, every webhook is when it is empty
+	Notify string `json:",omitempty"`
+}
+
+func (a Alert) Summary() string {
+	return fmt.Sprintf("%s: %s", a.Kind, a.Message)
+}
+
+func (a Alert) Json() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+var Alerts = observer.New(Alert{})
@@ -0,0 +1,332 @@
+// Package persistence asks clients to install or remove persistence, keeping the record of what they changed on the server
+// so removal still works after the client has restarted
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrNoRecords = errors.New("no persistence recorded for this client")
+	ErrBusy      = errors.New("persistence is already being changed on this client, try again when that finishes")
+)
+
+var (
+	lck  sync.Mutex
+	path string
+
+	// Clients with an install or removal in flight
+	busy = map[string]bool{}
+)
+
+// What a client told us it changed
+type Record struct {
+	Hostname    string
+	Fingerprint string
+	Method      string
+	Name        string
+	Changes     string
+	Installed   time.Time
+}
+
+type request struct {
+	Method  string
+	Name    string
+	Path    string
+	Remove  bool
+	Changes string
+}
+
+type result struct {
+	Changes string
+	Message string
+}
+
+type Removal struct {
+	Record
+	// Changes that could not be reverted, these stay recorded so removal can be retried
+	Remaining string
+	Err       error
+}
+
+func Start(datadir string) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "persistence.json")
+}
+
+func readRecords() ([]Record, error) {
+	var records []Record
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &records)
+	return records, err
+}
+
+func writeRecords(records []Record) error {
+	b, err := json.MarshalIndent(records, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+func matches(r Record, sc *ssh.ServerConn, method string) bool {
+	return r.Hostname == clients.NormaliseHostname(sc.User()) && r.Fingerprint == sc.Permissions.Extensions["pubkey-fp"] && (method == "" || r.Method == method)
+}
+
+func send(sc ssh.Conn, req request) (result, error) {
+	var res result
+
+	ok, message, err := sc.SendRequest("persist", true, ssh.Marshal(req))
+	if err != nil {
+		return res, err
+	}
+
+	if !ok {
+		if len(message) == 0 {
+			return res, errors.New("client does not support persistence")
+		}
+		return res, errors.New(string(message))
+	}
+
+	err = ssh.Unmarshal(message, &res)
+	if err != nil {
+		return res, fmt.Errorf("client sent an incompatible message: %s", err)
+	}
+
+	return res, nil
+}
+
+// All returns the persistence recorded for every client
+func All() ([]Record, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return readRecords()
+}
+
+// Rekey moves the records made under one client key to another, as a client whose key is rotated is still the same install
+func Rekey(oldFingerprint, newFingerprint string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	records, err := readRecords()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for i := range records {
+		if records[i].Fingerprint == oldFingerprint {
+			records[i].Fingerprint = newFingerprint
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return writeRecords(records)
+}
+
+// List returns the persistence recorded for a client, optionally only of one method
+func List(sc *ssh.ServerConn, method string) ([]Record, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	records, err := readRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Record
+	for _, r := range records {
+		if matches(r, sc, method) {
+			out = append(out, r)
+		}
+	}
+
+	return out, nil
+}
+
+func Install(actor, id string, sc *ssh.ServerConn, method, name, installPath string) (Record, error) {
+	if err := claim(sc); err != nil {
+		return Record{}, err
+	}
+	defer release(sc)
+
+	return install(actor, id, sc, method, name, installPath)
+}
+
+// Ensure installs persistence on a client built to persist itself, unless the client already has persistence of that method recorded
+func Ensure(actor, id string, sc *ssh.ServerConn, method, name string) (r Record, installed bool, err error) {
+	if err := claim(sc); err != nil {
+		return Record{}, false, err
+	}
+	defer release(sc)
+
+	existing, err := List(sc, method)
+	if err != nil {
+		return Record{}, false, fmt.Errorf("unable to read persistence records: %s", err)
+	}
+
+	if len(existing) > 0 {
+		return existing[0], false, nil
+	}
+
+	r, err = install(actor, id, sc, method, name, "")
+	return r, err == nil, err
+}
+
+func install(actor, id string, sc *ssh.ServerConn, method, name, installPath string) (Record, error) {
+	res, err := send(sc, request{Method: method, Name: name, Path: installPath})
+	if err != nil {
+		audit.Log(actor, "persist", id, fmt.Sprintf("%s %s failed: %s", method, name, err))
+		return Record{}, err
+	}
+
+	r := Record{
+		Hostname:    clients.NormaliseHostname(sc.User()),
+		Fingerprint: sc.Permissions.Extensions["pubkey-fp"],
+		Method:      method,
+		Name:        name,
+		Changes:     res.Changes,
+		Installed:   time.Now(),
+	}
+
+	audit.Log(actor, "persist", id, fmt.Sprintf("%s %s installed: %q", method, name, res.Changes))
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	// Read again, the records may have changed for other clients while this one was installing
+	records, err := readRecords()
+	if err != nil {
+		return r, fmt.Errorf("installed but unable to read persistence records: %s", err)
+	}
+
+	return r, writeRecords(append(records, r))
+}
+
+// Remove reverts everything recorded for a client, or only one method if given
+func Remove(actor, id string, sc *ssh.ServerConn, method string) ([]Removal, error) {
+	if err := claim(sc); err != nil {
+		return nil, err
+	}
+	defer release(sc)
+
+	matched, err := List(sc, method)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read persistence records: %s", err)
+	}
+
+	if len(matched) == 0 {
+		return nil, ErrNoRecords
+	}
+
+	var removals []Removal
+	for _, r := range matched {
+		removal := Removal{Record: r}
+
+		res, err := send(sc, request{Remove: true, Changes: r.Changes})
+		switch {
+		case err != nil:
+			removal.Err = err
+			removal.Remaining = r.Changes
+			audit.Log(actor, "persist-remove", id, fmt.Sprintf("%s %s failed: %s", r.Method, r.Name, err))
+
+		case res.Changes != "":
+			removal.Err = errors.New(res.Message)
+			removal.Remaining = res.Changes
+			audit.Log(actor, "persist-remove", id, fmt.Sprintf("%s %s partial, remaining: %q error: %s", r.Method, r.Name, res.Changes, res.Message))
+
+		default:
+			audit.Log(actor, "persist-remove", id, fmt.Sprintf("%s %s reverted: %q", r.Method, r.Name, r.Changes))
+		}
+
+		removals = append(removals, removal)
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	records, err := readRecords()
+	if err != nil {
+		return removals, fmt.Errorf("unable to read persistence records: %s", err)
+	}
+
+	var kept []Record
+	for _, r := range records {
+		removal, ok := removed(removals, r)
+		if !ok {
+			kept = append(kept, r)
+			continue
+		}
+
+		if removal.Remaining != "" {
+			r.Changes = removal.Remaining
+			kept = append(kept, r)
+		}
+	}
+
+	return removals, writeRecords(kept)
+}
+
+// removed finds the removal made of r, if any
+func removed(removals []Removal, r Record) (Removal, bool) {
+	for _, removal := range removals {
+		if removal.Hostname == r.Hostname && removal.Fingerprint == r.Fingerprint && removal.Method == r.Method &&
+			removal.Name == r.Name && removal.Changes == r.Changes && removal.Installed.Equal(r.Installed) {
+			return removal, true
+		}
+	}
+
+	return Removal{}, false
+}
+
+// claim marks a client as having its persistence changed, so only one install or removal is sent to it at a time while lck is
+// free for everyone else
+func claim(sc *ssh.ServerConn) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	k := clientKey(sc)
+	if busy[k] {
+		return ErrBusy
+	}
+
+	busy[k] = true
+	return nil
+}
+
+func release(sc *ssh.ServerConn) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	delete(busy, clientKey(sc))
+}
+
+func clientKey(sc *ssh.ServerConn) string {
+	return clients.NormaliseHostname(sc.User()) + " " + sc.Permissions.Extensions["pubkey-fp"]
+}
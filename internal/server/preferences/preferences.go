@@ -0,0 +1,94 @@
+// Package preferences keeps the settings each operator chooses for their own console, against their login key so they follow
+// the operator across sessions and server restarts
+package preferences
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type Preferences struct {
+	// Plain output for screen readers: labelled lines instead of tables and columns, and no escape sequences beyond what the line editor needs
+	Accessible bool `json:",omitempty"`
+
+	// Language help and messages are shown in, set with the lang command. Empty uses whatever the operator's ssh client asks for
+	Lang string `json:",omitempty"`
+}
+
+var (
+	lck         sync.Mutex
+	path        string
+	preferences = map[string]Preferences{}
+)
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "preferences.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &preferences); err != nil {
+		return fmt.Errorf("unable to parse preferences.json: %s", err)
+	}
+
+	return nil
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(preferences, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Get returns an operator's preferences, the defaults if they have never set any
+func Get(operator string) Preferences {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return preferences[operator]
+}
+
+// Update changes an operator's preferences and saves them
+func Update(operator string, change func(*Preferences)) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	p := preferences[operator]
+	change(&p)
+
+	old, existed := preferences[operator]
+	if p == (Preferences{}) {
+		delete(preferences, operator)
+	} else {
+		preferences[operator] = p
+	}
+
+	if err := save(); err != nil {
+		if existed {
+			preferences[operator] = old
+		} else {
+			delete(preferences, operator)
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package preferences
+
+import (
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if Get("alice").Accessible {
+		t.Fatal("preferences should default to off")
+	}
+
+	if err := Update("alice", func(p *Preferences) { p.Accessible = true }); err != nil {
+		t.Fatal(err)
+	}
+
+	preferences = map[string]Preferences{}
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if !Get("alice").Accessible || Get("bob").Accessible {
+		t.Errorf("expected only alice to have accessible output after a restart, got %+v", preferences)
+	}
+
+	if err := Update("alice", func(p *Preferences) { p.Accessible = false }); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := preferences["alice"]; ok {
+		t.Error("preferences back at their defaults should not be kept")
+	}
+}
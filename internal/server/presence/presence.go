@@ -0,0 +1,107 @@
+// Package presence checks that an operator touched their security key to log in. The server never sees the flags on the login
+// signature itself, golang.org/x/crypto verifies it without passing it on, so the forwarded agent is asked to sign a challenge with
+// the same key straight after and the flags on that signature are checked instead
+package presence
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Flags set by the authenticator on sk- signatures, see openssh PROTOCOL.u2f
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+)
+
+// Requirement is what a security key must show for a login, as set by touch-required or verify-required in authorized_keys
+type Requirement string
+
+const (
+	None  Requirement = ""
+	Touch Requirement = "touch"
+	// Verify needs a touch and a PIN or biometric
+	Verify Requirement = "verify"
+)
+
+// Timeout is how long the operator has to touch their key
+const Timeout = time.Minute
+
+var ErrNoAgent = errors.New("this key must be touched to log in, connect with agent forwarding (ssh -A) and the key added to the agent so the server can ask for it")
+
+// IsSecurityKey reports whether keyType is backed by a FIDO2 authenticator
+func IsSecurityKey(keyType string) bool {
+	return strings.HasPrefix(keyType, "sk-")
+}
+
+// Check reports whether sig, made by key, shows what r needs. sig must already have been verified
+func Check(key ssh.PublicKey, sig *ssh.Signature, r Requirement) error {
+	if r == None {
+		return nil
+	}
+
+	if !IsSecurityKey(key.Type()) {
+		return fmt.Errorf("%s keys cannot show they were touched", key.Type())
+	}
+
+	var fields struct {
+		Flags   byte
+		Counter uint32
+	}
+	if err := ssh.Unmarshal(sig.Rest, &fields); err != nil {
+		return fmt.Errorf("signature has no authenticator flags: %s", err)
+	}
+
+	if fields.Flags&FlagUserPresent == 0 {
+		return errors.New("the security key was not touched")
+	}
+
+	if r == Verify && fields.Flags&FlagUserVerified == 0 {
+		return errors.New("the security key was touched but not unlocked with its PIN or biometric")
+	}
+
+	return nil
+}
+
+// Challenge has the agent forwarded over conn sign a random challenge with key, and checks the signature shows what r needs
+func Challenge(conn ssh.Conn, key ssh.PublicKey, r Requirement) error {
+	if r == None {
+		return nil
+	}
+
+	channel, requests, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return ErrNoAgent
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	// Closing the channel ends a signature nobody is going to touch the key for
+	timer := time.AfterFunc(Timeout, func() { channel.Close() })
+	defer timer.Stop()
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	// Marked as ours, so the signature is no use as anything else
+	challenge := append([]byte("rssh-presence@"), nonce...)
+
+	sig, err := agent.NewClient(channel).Sign(key, challenge)
+	if err != nil {
+		return fmt.Errorf("the agent did not sign with the login key: %s", err)
+	}
+
+	if err := key.Verify(challenge, sig); err != nil {
+		return err
+	}
+
+	return Check(key, sig, r)
+}
@@ -0,0 +1,182 @@
+package presence
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// securityKey signs as an sk-ssh-ed25519 authenticator would, setting flags on every signature
+type securityKey struct {
+	agent.Agent
+
+	priv  ed25519.PrivateKey
+	pub   ssh.PublicKey
+	flags byte
+}
+
+func newSecurityKey(t *testing.T, flags byte) *securityKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, pub, "ssh:"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &securityKey{Agent: agent.NewKeyring(), priv: priv, pub: key, flags: flags}
+}
+
+func (s *securityKey) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	app := sha256.Sum256([]byte("ssh:"))
+	msg := sha256.Sum256(data)
+
+	signed := ssh.Marshal(struct {
+		ApplicationDigest []byte `ssh:"rest"`
+		Flags             byte
+		Counter           uint32
+		MessageDigest     []byte `ssh:"rest"`
+	}{app[:], s.flags, 7, msg[:]})
+
+	return &ssh.Signature{
+		Format: ssh.KeyAlgoSKED25519,
+		Blob:   ed25519.Sign(s.priv, signed),
+		Rest: ssh.Marshal(struct {
+			Flags   byte
+			Counter uint32
+		}{s.flags, 7}),
+	}, nil
+}
+
+func TestCheck(t *testing.T) {
+	checks := []struct {
+		flags byte
+		r     Requirement
+		err   string
+	}{
+		{0, None, ""},
+		{0, Touch, "not touched"},
+		{FlagUserPresent, Touch, ""},
+		{FlagUserPresent, Verify, "PIN or biometric"},
+		{FlagUserVerified, Verify, "not touched"},
+		{FlagUserPresent | FlagUserVerified, Verify, ""},
+	}
+
+	for _, c := range checks {
+		k := newSecurityKey(t, c.flags)
+
+		sig, _ := k.Sign(k.pub, []byte("login"))
+		if err := k.pub.Verify([]byte("login"), sig); err != nil {
+			t.Fatalf("test signature does not verify: %s", err)
+		}
+
+		err := Check(k.pub, sig, c.r)
+		if c.err == "" && err != nil {
+			t.Fatalf("flags %#x, %q: expected no error, got %s", c.flags, c.r, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Fatalf("flags %#x, %q: expected %q, got %v", c.flags, c.r, c.err, err)
+		}
+	}
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	plain, _ := ssh.NewPublicKey(pub)
+	if err := Check(plain, &ssh.Signature{}, Touch); err == nil {
+		t.Fatal("expected a key without an authenticator to be refused when a touch is required")
+	}
+}
+
+// pair connects an ssh client and server over loopback, the client serving agent channels from a if it is not nil
+func pair(t *testing.T, a agent.Agent) ssh.Conn {
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+
+		conn, chans, reqs, err := ssh.NewClientConn(c, "", &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go ssh.DiscardRequests(reqs)
+
+		for newChannel := range chans {
+			if a == nil || newChannel.ChannelType() != "auth-agent@openssh.com" {
+				newChannel.Reject(ssh.Prohibited, "no agent")
+				continue
+			}
+
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				agent.ServeAgent(a, channel)
+				channel.Close()
+			}()
+		}
+	}()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _, reqs, err := ssh.NewServerConn(c, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go ssh.DiscardRequests(reqs)
+
+	return conn
+}
+
+func TestChallenge(t *testing.T) {
+	touched := newSecurityKey(t, FlagUserPresent)
+	if err := Challenge(pair(t, touched), touched.pub, Touch); err != nil {
+		t.Fatalf("expected a touched key to be let in, got %s", err)
+	}
+
+	untouched := newSecurityKey(t, 0)
+	if err := Challenge(pair(t, untouched), untouched.pub, Touch); err == nil || !strings.Contains(err.Error(), "not touched") {
+		t.Fatalf("expected a key made with no-touch-required to be refused, got %v", err)
+	}
+
+	if err := Challenge(pair(t, nil), touched.pub, Touch); err != ErrNoAgent {
+		t.Fatalf("expected a login without an agent to be refused, got %v", err)
+	}
+
+	if err := Challenge(nil, touched.pub, None); err != nil {
+		t.Fatalf("expected nothing to be asked of keys without a requirement, got %s", err)
+	}
+}
@@ -0,0 +1,193 @@
+// Package queue keeps console commands waiting for clients that are offline, along with what they output once the client
+// reconnected and they were run. Both are kept against the client's record so they survive the client changing address
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+)
+
+const (
+	// Output is cut off past this, a queued command is meant to be something like a tag or an exec of a short command
+	MaxOutput = 64 * 1024
+	// Results older than the most recent of these are dropped
+	MaxResults = 50
+)
+
+// Entry is a command line waiting for a client to connect
+type Entry struct {
+	Line string
+	// Who queued it and with what role, it is run as them
+	By     string
+	Role   string
+	Queued time.Time
+}
+
+// Result is what a queued command did once it was run
+type Result struct {
+	Entry
+
+	Ran    time.Time
+	Output string
+	Error  string `json:",omitempty"`
+}
+
+type store struct {
+	Pending map[string][]Entry
+	Results map[string][]Result
+}
+
+var (
+	lck  sync.Mutex
+	path string
+	s    = store{Pending: map[string][]Entry{}, Results: map[string][]Result{}}
+)
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "queue.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("unable to parse queue.json: %s", err)
+	}
+
+	if s.Pending == nil {
+		s.Pending = map[string][]Entry{}
+	}
+
+	if s.Results == nil {
+		s.Results = map[string][]Result{}
+	}
+
+	return nil
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Add puts a line at the end of the queue of a client record
+func Add(record string, e Entry) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	s.Pending[record] = append(s.Pending[record], e)
+	if err := save(); err != nil {
+		s.Pending[record] = s.Pending[record][:len(s.Pending[record])-1]
+		return err
+	}
+
+	audit.Log(e.By, "queue-add", record, e.Line)
+	return nil
+}
+
+// Pending is the queue of a client record, in the order it will be run
+func Pending(record string) []Entry {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return append([]Entry(nil), s.Pending[record]...)
+}
+
+// Records are the client records that have commands waiting
+func Records() []string {
+	lck.Lock()
+	defer lck.Unlock()
+
+	var out []string
+	for record := range s.Pending {
+		out = append(out, record)
+	}
+	return out
+}
+
+// Cancel removes the nth, counting from 1, command queued for a client record
+func Cancel(actor, record string, n int) (Entry, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	pending := s.Pending[record]
+	if n < 1 || n > len(pending) {
+		return Entry{}, fmt.Errorf("%s has %d queued commands, there is no %d", record, len(pending), n)
+	}
+
+	e := pending[n-1]
+	s.Pending[record] = append(pending[:n-1:n-1], pending[n:]...)
+	if len(s.Pending[record]) == 0 {
+		delete(s.Pending, record)
+	}
+
+	if err := save(); err != nil {
+		return Entry{}, err
+	}
+
+	audit.Log(actor, "queue-cancel", record, e.Line)
+	return e, nil
+}
+
+// Take empties the queue of a client record, returning what was in it so it can be run
+func Take(record string) []Entry {
+	lck.Lock()
+	defer lck.Unlock()
+
+	taken := s.Pending[record]
+	if len(taken) == 0 {
+		return nil
+	}
+
+	delete(s.Pending, record)
+	save()
+
+	return taken
+}
+
+// Record keeps the result of a queued command, dropping the oldest past MaxResults
+func Record(record string, r Result) error {
+	if len(r.Output) > MaxOutput {
+		r.Output = r.Output[:MaxOutput] + "\n[output cut off]"
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	results := append(s.Results[record], r)
+	if len(results) > MaxResults {
+		results = results[len(results)-MaxResults:]
+	}
+	s.Results[record] = results
+
+	return save()
+}
+
+// Results is what the queued commands of a client record did, oldest first
+func Results(record string) []Result {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return append([]Result(nil), s.Results[record]...)
+}
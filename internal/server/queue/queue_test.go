@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { path = "" }()
+
+	for _, line := range []string{"tag $current first", "exec -y $current uname", "tag $current last"} {
+		if err := Add("web01", Entry{Line: line, By: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if e, err := Cancel("alice", "web01", 2); err != nil || e.Line != "exec -y $current uname" {
+		t.Errorf("expected the second command to be cancelled, got %+v: %v", e, err)
+	}
+
+	if _, err := Cancel("alice", "web01", 3); err == nil {
+		t.Error("expected cancelling a command past the end of the queue to fail")
+	}
+
+	// Reloaded from disk, as the server would after a restart
+	s = store{}
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	taken := Take("web01")
+	if len(taken) != 2 || taken[0].Line != "tag $current first" || taken[1].Line != "tag $current last" {
+		t.Fatalf("expected the queue in the order it was added, got %+v", taken)
+	}
+
+	if len(Take("web01")) != 0 || len(Records()) != 0 {
+		t.Error("expected nothing left once the queue was taken")
+	}
+
+	if err := Record("web01", Result{Entry: taken[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < MaxResults; i++ {
+		if err := Record("web01", Result{Entry: taken[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := Results("web01")
+	if len(results) != MaxResults || results[0].Line != "tag $current last" {
+		t.Errorf("expected only the most recent %d results to be kept, got %d starting with %+v", MaxResults, len(results), results[0].Entry)
+	}
+}
+
+func TestOutputCutOff(t *testing.T) {
+	s = store{Pending: map[string][]Entry{}, Results: map[string][]Result{}}
+
+	if err := Record("web01", Result{Output: strings.Repeat("a", MaxOutput+1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := Results("web01")[0].Output; len(out) > MaxOutput+len("\n[output cut off]") || !strings.HasSuffix(out, "[output cut off]") {
+		t.Errorf("expected long output to be cut off, got %d bytes", len(out))
+	}
+}
@@ -0,0 +1,183 @@
+// Package recordings reads the asciinema cast files kept in the recordings directory of the data directory, so they can be
+// played back in a console. Both the newline delimited v2 format and the single document v1 format are understood
+package recordings
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const Extension = ".cast"
+
+type Header struct {
+	Version   int
+	Width     int
+	Height    int
+	Timestamp int64  `json:",omitempty"`
+	Title     string `json:",omitempty"`
+	// Pauses longer than this many seconds are shortened to it when played back, as asciinema does
+	IdleTimeLimit float64 `json:"idle_time_limit,omitempty"`
+}
+
+// Event is output written to the terminal, Time seconds after the recording started
+type Event struct {
+	Time float64
+	Data string
+}
+
+type Cast struct {
+	Header
+	Events []Event
+}
+
+// Duration is how long the recording takes to play back at normal speed
+func (c *Cast) Duration() time.Duration {
+	if len(c.Events) == 0 {
+		return 0
+	}
+	return time.Duration(c.Events[len(c.Events)-1].Time * float64(time.Second))
+}
+
+type Recording struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+func dir(datadir string) string {
+	return filepath.Join(datadir, "recordings")
+}
+
+// List is every recording, newest first
+func List(datadir string) ([]Recording, error) {
+	entries, err := os.ReadDir(dir(datadir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var out []Recording
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), Extension) {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		out = append(out, Recording{Name: strings.TrimSuffix(e.Name(), Extension), Size: info.Size(), Modified: info.ModTime()})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Modified.After(out[j].Modified)
+	})
+
+	return out, nil
+}
+
+// Open reads a recording by its name, with or without the .cast on the end
+func Open(datadir, name string) (*Cast, error) {
+	name = strings.TrimSuffix(name, Extension)
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("%q is not the name of a recording", name)
+	}
+
+	f, err := os.Open(filepath.Join(dir(datadir), name+Extension))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no recording named %q", name)
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse reads a cast, keeping only what was written to the terminal
+func Parse(r io.Reader) (*Cast, error) {
+	reader := bufio.NewReader(r)
+
+	first, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var c Cast
+	if err := json.Unmarshal(first, &c.Header); err == nil && c.Version == 2 {
+		return &c, parseEvents(&c, reader)
+	}
+
+	// Version 1 is one document, possibly spread over many lines, with every event in it
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var v1 struct {
+		Header
+		Stdout [][2]interface{} `json:"stdout"`
+	}
+	if err := json.Unmarshal(append(first, rest...), &v1); err != nil || v1.Version != 1 {
+		return nil, fmt.Errorf("not an asciinema cast file")
+	}
+
+	c.Header = v1.Header
+	elapsed := 0.0
+	for _, frame := range v1.Stdout {
+		delay, ok := frame[0].(float64)
+		data, isString := frame[1].(string)
+		if !ok || !isString {
+			return nil, fmt.Errorf("malformed frame in cast file")
+		}
+
+		elapsed += delay
+		c.Events = append(c.Events, Event{Time: elapsed, Data: data})
+	}
+
+	return &c, nil
+}
+
+func parseEvents(c *Cast, reader *bufio.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for n := 2; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var event [3]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return fmt.Errorf("line %d of cast file: %s", n, err)
+		}
+
+		at, ok := event[0].(float64)
+		kind, _ := event[1].(string)
+		data, isString := event[2].(string)
+		if !ok || !isString {
+			return fmt.Errorf("line %d of cast file is not an event", n)
+		}
+
+		// Input and markers are not shown
+		if kind != "o" {
+			continue
+		}
+
+		c.Events = append(c.Events, Event{Time: at, Data: data})
+	}
+
+	return scanner.Err()
+}
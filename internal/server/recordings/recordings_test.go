@@ -0,0 +1,88 @@
+package recordings
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const v2 = `{"version": 2, "width": 80, "height": 24, "title": "web01 review", "idle_time_limit": 2}
+[0.5, "o", "$ "]
+[0.7, "i", "l"]
+[1.25, "o", "ls\r\n"]
+
+[3.0, "o", "file\r\n"]
+`
+
+const v1 = `{
+  "version": 1,
+  "width": 80,
+  "height": 24,
+  "stdout": [
+    [0.5, "$ "],
+    [0.75, "ls\r\n"]
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(v2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Width != 80 || c.Title != "web01 review" || c.IdleTimeLimit != 2 {
+		t.Errorf("unexpected header: %+v", c.Header)
+	}
+
+	if len(c.Events) != 3 || c.Events[1].Data != "ls\r\n" || c.Duration() != 3*time.Second {
+		t.Errorf("expected only the output events, got %+v", c.Events)
+	}
+
+	c, err = Parse(strings.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 1 times are delays since the frame before
+	if len(c.Events) != 2 || c.Events[1].Time != 1.25 {
+		t.Errorf("unexpected v1 events: %+v", c.Events)
+	}
+
+	for _, bad := range []string{"", "not json", `{"version": 3}`, `{"version": 2}` + "\n[1, \"o\"]"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to be refused", bad)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	datadir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(datadir, "recordings"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(datadir, "recordings", "review.cast"), []byte(v2), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(filepath.Join(datadir, "secret"), []byte(v2), 0600)
+
+	for _, name := range []string{"review", "review.cast"} {
+		if _, err := Open(datadir, name); err != nil {
+			t.Errorf("expected %q to open: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"../secret", "missing", "", ".cast"} {
+		if _, err := Open(datadir, name); err == nil {
+			t.Errorf("expected %q not to open", name)
+		}
+	}
+
+	list, err := List(datadir)
+	if err != nil || len(list) != 1 || list[0].Name != "review" {
+		t.Errorf("expected the one recording to be listed, got %+v: %v", list, err)
+	}
+}
@@ -0,0 +1,80 @@
+package selftest
+
+import (
+	"io"
+	"net"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type directTCPIP struct {
+	Host     string
+	Port     uint32
+	OrigHost string
+	OrigPort uint32
+}
+
+// serveChannels is the loopback client answering what the server opens, its shell and commands echo back whatever they are sent
+func serveChannels(chans <-chan ssh.NewChannel) {
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go session(newChannel)
+		case "direct-tcpip":
+			go forward(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "the selftest client only has sessions and forwards")
+		}
+	}
+}
+
+func session(newChannel ssh.NewChannel) {
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	for req := range reqs {
+		switch req.Type {
+		case "shell", "exec":
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+
+			io.Copy(ch, ch)
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+		default:
+			req.Reply(req.Type == "pty-req" || req.Type == "env", nil)
+		}
+	}
+}
+
+func forward(newChannel ssh.NewChannel) {
+	var target directTCPIP
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "undecodable forward")
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		io.Copy(conn, ch)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(ch, conn)
+}
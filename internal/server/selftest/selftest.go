@@ -0,0 +1,326 @@
+// Package selftest checks a running server end to end with a client of its own. The client is connected over loopback
+// to the same connection handler real clients reach, then a shell, a transfer and a forward are run through it like an operator would
+package selftest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"golang.org/x/crypto/ssh"
+)
+
+// Hostname is what the loopback client calls itself, so it is obvious in ls while a test runs
+const Hostname = "rssh-selftest"
+
+const (
+	stepTimeout  = 10 * time.Second
+	transferSize = 1 << 20
+)
+
+var (
+	lock    sync.Mutex
+	serve   func(net.Conn)
+	trusted = map[string]bool{}
+)
+
+// Result is how one part of the server fared
+type Result struct {
+	Subsystem string
+	Err       error
+	Took      time.Duration
+}
+
+// Listen is given what the ssh server does with a new connection, self tests cannot run until it is
+func Listen(handler func(net.Conn)) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	serve = handler
+}
+
+// Trusted reports whether key belongs to the loopback client of a test that is running, it is let in as a client without being in authorized_controllee_keys
+func Trusted(key ssh.PublicKey) bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return trusted[internal.FingerprintSHA256Hex(key)]
+}
+
+// Run connects a loopback client and puts it through a shell, a transfer and a forward, stopping at the first part that fails
+// as the rest depend on it
+func Run() []Result {
+	lock.Lock()
+	handler := serve
+	lock.Unlock()
+
+	if handler == nil {
+		return []Result{{Subsystem: "handshake", Err: errors.New("the ssh server has not started")}}
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return []Result{{Subsystem: "handshake", Err: err}}
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return []Result{{Subsystem: "handshake", Err: err}}
+	}
+
+	fingerprint := internal.FingerprintSHA256Hex(signer.PublicKey())
+
+	lock.Lock()
+	trusted[fingerprint] = true
+	lock.Unlock()
+
+	defer func() {
+		lock.Lock()
+		delete(trusted, fingerprint)
+		lock.Unlock()
+	}()
+
+	c := &loopback{signer: signer}
+	defer c.Close()
+
+	var results []Result
+	for _, step := range []struct {
+		subsystem string
+		run       func() error
+	}{
+		{"handshake", func() error { return c.connect(handler) }},
+		{"session", c.session},
+		{"transfer", c.transfer},
+		{"forward", c.forward},
+		{"disconnect", c.disconnect},
+	} {
+		start := time.Now()
+		err := within(step.run)
+		results = append(results, Result{Subsystem: step.subsystem, Err: err, Took: time.Since(start)})
+
+		if err != nil {
+			break
+		}
+	}
+
+	return results
+}
+
+func within(f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(stepTimeout):
+		return fmt.Errorf("timed out after %s", stepTimeout)
+	}
+}
+
+// loopback is both ends of the test, the client connection and the server's view of it
+type loopback struct {
+	signer ssh.Signer
+
+	client ssh.Conn
+	server *ssh.ServerConn
+	id     string
+}
+
+func (l *loopback) Close() {
+	if l.client != nil {
+		l.client.Close()
+	}
+}
+
+func (l *loopback) connect(handler func(net.Conn)) error {
+	// A pipe would be simpler, but both ends of ssh write their version before reading and an unbuffered pipe leaves them waiting on each other
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			handler(conn)
+		}
+	}()
+
+	clientSide, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return err
+	}
+
+	config := &ssh.ClientConfig{
+		User:            "selftest." + Hostname,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(l.signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		ClientVersion:   "SSH-" + internal.Version + "-selftest",
+	}
+
+	conn, chans, reqs, err := ssh.NewClientConn(clientSide, "selftest", config)
+	if err != nil {
+		clientSide.Close()
+		return fmt.Errorf("server refused the loopback client: %s", err)
+	}
+
+	go ssh.DiscardRequests(reqs)
+	go serveChannels(chans)
+
+	l.client = conn
+
+	fingerprint := internal.FingerprintSHA1Hex(l.signer.PublicKey())
+	return poll("the server did not take the loopback client on as a client, see the server log", func() (bool, error) {
+		found, err := clients.Search(fingerprint)
+		for id, sc := range found {
+			if sc.Permissions.Extensions["pubkey-fp"] == fingerprint {
+				l.id, l.server = id, sc
+				return true, nil
+			}
+		}
+		return false, err
+	})
+}
+
+func (l *loopback) session() error {
+	ch, reqs, err := l.server.OpenChannel("session", nil)
+	if err != nil {
+		return fmt.Errorf("unable to open session: %s", err)
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	if ok, err := ch.SendRequest("shell", true, nil); err != nil || !ok {
+		return fmt.Errorf("shell request refused: %v", err)
+	}
+
+	line := []byte("rssh selftest\n")
+	if _, err := ch.Write(line); err != nil {
+		return err
+	}
+
+	echoed := make([]byte, len(line))
+	if _, err := io.ReadFull(ch, echoed); err != nil {
+		return fmt.Errorf("no answer from the shell: %s", err)
+	}
+
+	if !bytes.Equal(echoed, line) {
+		return fmt.Errorf("shell answered %q, expected %q", echoed, line)
+	}
+
+	return nil
+}
+
+func (l *loopback) transfer() error {
+	ch, reqs, err := l.server.OpenChannel("session", nil)
+	if err != nil {
+		return fmt.Errorf("unable to open session: %s", err)
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	if ok, err := ch.SendRequest("exec", true, ssh.Marshal(struct{ Command string }{"cat"})); err != nil || !ok {
+		return fmt.Errorf("exec request refused: %v", err)
+	}
+
+	return roundTrip(ch, ch.CloseWrite)
+}
+
+func (l *loopback) forward() error {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("unable to listen for the forward: %s", err)
+	}
+	defer target.Close()
+
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	addr := target.Addr().(*net.TCPAddr)
+	ch, reqs, err := l.server.OpenChannel("direct-tcpip", ssh.Marshal(directTCPIP{
+		Host:     addr.IP.String(),
+		Port:     uint32(addr.Port),
+		OrigHost: "127.0.0.1",
+		OrigPort: 0,
+	}))
+	if err != nil {
+		return fmt.Errorf("unable to open forward: %s", err)
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	return roundTrip(ch, ch.CloseWrite)
+}
+
+func (l *loopback) disconnect() error {
+	l.client.Close()
+	l.client = nil
+
+	return poll("the loopback client was still listed after disconnecting", func() (bool, error) {
+		found, err := clients.Search(l.id)
+		_, listed := found[l.id]
+		return !listed, err
+	})
+}
+
+// poll checks done until it is true, giving up with failed well before the step times out
+func poll(failed string, done func() (bool, error)) error {
+	for deadline := time.Now().Add(stepTimeout / 2); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+	}
+	return errors.New(failed)
+}
+
+// roundTrip sends random data through rw and checks the same comes back
+func roundTrip(rw io.ReadWriter, closeWrite func() error) error {
+	sent := make([]byte, transferSize)
+	if _, err := rand.Read(sent); err != nil {
+		return err
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(sent)
+		if err == nil {
+			err = closeWrite()
+		}
+		writeErr <- err
+	}()
+
+	received := make([]byte, transferSize)
+	if _, err := io.ReadFull(rw, received); err != nil {
+		return fmt.Errorf("only some of %d bytes came back: %s", transferSize, err)
+	}
+
+	if err := <-writeErr; err != nil {
+		return err
+	}
+
+	if sha256.Sum256(sent) != sha256.Sum256(received) {
+		return errors.New("data came back changed")
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+// Package sequence orders everything the server records, the audit log, watch log, alerts and slow commands, so a timeline
+// across operators, sessions and transfers can be put back together exactly. Each record is given the next number in one sequence
+// that keeps counting across restarts, and a hybrid logical clock reading that never goes backwards when the wall clock does
+package sequence
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Numbers are handed out from blocks of this many, only the end of the block is written to disk. A restart skips what was left
+// of the block, so numbers are never reused
+const block = 1024
+
+// Stamp places a record in the order the server saw things happen
+type Stamp struct {
+	Seq uint64
+	// The hybrid clock, the latest wall clock seen in unix nanoseconds and how many stamps were taken since it last moved forward.
+	// Ordered by Wall then Logical it agrees with Seq while the server runs, even if the system clock is stepped back. It is only
+	// saved once a block, so across a restart with the clock behind it is Seq that keeps the order
+	Wall    int64
+	Logical uint32
+}
+
+// Time is the wall clock part of the stamp
+func (s Stamp) Time() time.Time {
+	return time.Unix(0, s.Wall)
+}
+
+// Before compares two stamps by their hybrid clock
+func (s Stamp) Before(o Stamp) bool {
+	if s.Wall != o.Wall {
+		return s.Wall < o.Wall
+	}
+	return s.Logical < o.Logical
+}
+
+func (s Stamp) String() string {
+	return fmt.Sprintf("seq=%d hlc=%d.%d", s.Seq, s.Wall, s.Logical)
+}
+
+type saved struct {
+	Seq  uint64
+	Wall int64
+}
+
+var (
+	lck  sync.Mutex
+	path string
+
+	last     Stamp
+	reserved uint64
+)
+
+// Start carries the sequence on from where the server last left it in datadir
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "sequence.json")
+	reserved = 0
+
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		var s saved
+		if err := json.Unmarshal(b, &s); err != nil {
+			return fmt.Errorf("unable to parse sequence.json: %s", err)
+		}
+
+		// Anything stamped before Start keeps its place ahead of what follows
+		if s.Seq > last.Seq {
+			last.Seq = s.Seq
+		}
+		if s.Wall > last.Wall {
+			last.Wall, last.Logical = s.Wall, 0
+		}
+	}
+
+	return reserve()
+}
+
+// reserve writes down the end of the next block of numbers, lck must be held
+func reserve() error {
+	reserved = last.Seq + block
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(saved{Seq: reserved, Wall: last.Wall})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Next stamps a record
+func Next() Stamp {
+	lck.Lock()
+	defer lck.Unlock()
+
+	now := time.Now().UnixNano()
+	if now > last.Wall {
+		last.Wall, last.Logical = now, 0
+	} else {
+		last.Logical++
+	}
+
+	last.Seq++
+	if last.Seq > reserved {
+		if err := reserve(); err != nil {
+			log.Println("unable to save the sequence, numbers may be reused after a restart:", err)
+		}
+	}
+
+	return last
+}
@@ -0,0 +1,36 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	dir := t.TempDir()
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { path = "" }()
+
+	// As if the clock had been stepped back an hour
+	last.Wall = time.Now().Add(time.Hour).UnixNano()
+
+	previous := Next()
+	for i := 0; i < 3*block; i++ {
+		s := Next()
+		if s.Seq != previous.Seq+1 || !previous.Before(s) {
+			t.Fatalf("expected %s to follow %s", s, previous)
+		}
+		previous = s
+	}
+
+	// Restarted, numbers carry on past anything handed out before and the clock does not fall back to the system one
+	last = Stamp{}
+	if err := Start(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := Next(); s.Seq <= previous.Seq || s.Wall < previous.Wall-int64(time.Hour) {
+		t.Fatalf("expected %s after the restart to follow %s", s, previous)
+	}
+}
@@ -1,91 +1,314 @@
 package server
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
+	"net"
+	"net/http"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/replay"
+	"github.com/NHAS/reverse_ssh/internal/server/approvals"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/bans"
+	"github.com/NHAS/reverse_ssh/internal/server/canary"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/crashes"
+	"github.com/NHAS/reverse_ssh/internal/server/engagements"
+	"github.com/NHAS/reverse_ssh/internal/server/forwards"
+	"github.com/NHAS/reverse_ssh/internal/server/hostkey"
+	"github.com/NHAS/reverse_ssh/internal/server/lockdown"
+	"github.com/NHAS/reverse_ssh/internal/server/macros"
+	"github.com/NHAS/reverse_ssh/internal/server/manifests"
 	"github.com/NHAS/reverse_ssh/internal/server/multiplexer"
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/internal/server/identity"
+	"github.com/NHAS/reverse_ssh/internal/server/persistence"
+	"github.com/NHAS/reverse_ssh/internal/server/preferences"
+	"github.com/NHAS/reverse_ssh/internal/server/facts"
+	"github.com/NHAS/reverse_ssh/internal/server/jobs"
+	"github.com/NHAS/reverse_ssh/internal/server/queue"
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
+	"github.com/NHAS/reverse_ssh/internal/server/tokens"
+	"github.com/NHAS/reverse_ssh/internal/server/timings"
+	"github.com/NHAS/reverse_ssh/internal/server/toolpacks"
+	"github.com/NHAS/reverse_ssh/internal/server/tracing"
+	"github.com/NHAS/reverse_ssh/internal/server/vault"
+	"github.com/NHAS/reverse_ssh/internal/server/watchdog"
 	"github.com/NHAS/reverse_ssh/internal/server/webhooks"
+	"github.com/NHAS/reverse_ssh/internal/server/watches"
 	"github.com/NHAS/reverse_ssh/internal/server/webserver"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
 	"github.com/NHAS/reverse_ssh/pkg/mux"
 	"golang.org/x/crypto/ssh"
 )
 
+// ServerKeyPath is where the server key is kept in dataDir
+func ServerKeyPath(dataDir string) string {
+	return hostkey.Path(dataDir)
+}
+
+// CreateOrLoadServerKeys reads the server key file, generating it first if there isnt one
 func CreateOrLoadServerKeys(privateKeyPath string) (ssh.Signer, error) {
+	return hostkey.Load(privateKeyPath)
+}
 
-	//If we have already created a private key (or there is one in the current directory) dont overwrite/create another one
-	if _, err := os.Stat(privateKeyPath); os.IsNotExist(err) {
+// Config is everything the server is started with, the rssh binary fills it in from its command line
+type Config struct {
+	ListenAddress string
+	DataDir       string
 
-		privateKeyPem, err := internal.GeneratePrivateKey()
-		if err != nil {
-			return nil, fmt.Errorf("Unable to generate private key, and no private key specified: %s", err)
-		}
+	// Address clients built by the link command call back to, the listen address if empty
+	ExternalAddress string
+	TLSCertPath     string
+	TLSKeyPath      string
+	// OTLP/HTTP endpoint spans are exported to, tracing is off if empty
+	Collector string
+	// Decides logins along with the key files, if set
+	Authenticator Authenticator
+	// Where the server key is kept, as hostkey.Open takes it. The key file in DataDir if empty
+	HostKey string
+	// Where the passphrase for an encrypted key file comes from, as hostkey.Passphrase takes it. Asked for on the terminal if empty
+	HostKeyPassphrase string
+	// Program run with a ClientEvent as json on stdin whenever a client connects or disconnects, if set
+	ConnectHook string
 
-		err = ioutil.WriteFile(privateKeyPath, privateKeyPem, 0600)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to write private key to disk: %s", err)
-		}
-	}
+	Insecure         bool
+	Webserver        bool
+	TLS              bool
+	OpenProxy        bool
+	Honeypot         bool
+	StrictWebsockets bool
+	// Stops console pickers asking the operator's terminal to report the mouse
+	NoMouse bool
+	// Refuses to start unless the server was built with FIPS validated crypto
+	FIPS bool
 
-	privateBytes, err := ioutil.ReadFile(privateKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to load private key (%s): %s", privateKeyPath, err)
-	}
+	Limits clients.Limits
+	// Seconds between keepalives, 0 turns them off
+	Timeout int
+	// Commands running for longer are written to slow.log, timings.DefaultThreshold if 0 and never if below 0
+	SlowCommand time.Duration
+	// Connections with a write or channel accept stuck for longer are closed, watchdog.DefaultDeadline if 0 and never if below 0
+	Watchdog time.Duration
+}
 
-	private, err := ssh.ParsePrivateKey(privateBytes)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to parse private key: %s", err)
-	}
+// Server is a started server. Only one server can be started in a process as what it knows of clients and operators is kept in package variables
+type Server struct {
+	listener *mux.Multiplexer
+	addr     net.Addr
+	hostKey  ssh.Signer
+
+	watchLog    string
+	connectHook string
 
-	return private, nil
+	stopOnce sync.Once
+	stopped  chan struct{}
 }
 
-func Run(addr, dataDir, connectBackAddress, TLSCertPath, TLSKeyPath string, insecure, enabledWebserver, enabletTLS, openproxy bool, timeout int) {
+var (
+	startLock sync.Mutex
+	started   bool
+)
+
+// Start loads everything from the data directory and starts listening, returning once the server is ready for connections
+func Start(config Config) (*Server, error) {
+	startLock.Lock()
+	defer startLock.Unlock()
+
+	if started {
+		return nil, errors.New("a server has already been started in this process")
+	}
+
+	upgrades := replay.NewVerifier()
+
 	c := mux.MultiplexerConfig{
 		SSH:               true,
-		HTTP:              enabledWebserver,
-		TLS:               enabletTLS,
-		TLSCertPath:       TLSCertPath,
-		TLSKeyPath:        TLSKeyPath,
-		AutoTLSCommonName: connectBackAddress,
-		TcpKeepAlive:      timeout,
+		HTTP:              config.Webserver,
+		TLS:               config.TLS,
+		TLSCertPath:       config.TLSCertPath,
+		TLSKeyPath:        config.TLSKeyPath,
+		AutoTLSCommonName: config.ExternalAddress,
+		TcpKeepAlive:      config.Timeout,
+		WebsocketHandshake: func(r *http.Request) error {
+			err := upgrades.Verify(r, time.Now())
+			if err == replay.ErrUnsigned && !config.StrictWebsockets {
+				// Clients from before upgrades were signed
+				return nil
+			}
+
+			if err != nil {
+				log.Printf("Refused websocket upgrade from %s: %s", r.RemoteAddr, err)
+			}
+			return err
+		},
 	}
 
-	privateKeyPath := filepath.Join(dataDir, "id_ed25519")
+	dataDir := config.DataDir
 	configPath := filepath.Join(dataDir, "config.json")
 
 	log.Println("Version: ", internal.Version)
-	var err error
-	multiplexer.ServerMultiplexer, err = mux.ListenWithConfig("tcp", addr, c)
-	if err != nil {
-		log.Fatalf("Failed to listen on %s (%s)", addr, err)
+
+	if config.FIPS {
+		if err := internal.RequireFIPS(); err != nil {
+			return nil, err
+		}
 	}
-	defer multiplexer.ServerMultiplexer.Close()
 
-	log.Printf("Listening on %s\n", addr)
+	if internal.FIPS() {
+		log.Println("FIPS mode, only FIPS approved algorithms will be negotiated")
+	}
 
-	private, err := CreateOrLoadServerKeys(privateKeyPath)
+	private, err := hostkey.Open(config.HostKey, config.HostKeyPassphrase, dataDir)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	hostkey.Set(private)
 
-	log.Printf("Loading private key from: %s\n", privateKeyPath)
+	keySource := config.HostKey
+	if keySource == "" {
+		keySource = hostkey.Path(dataDir)
+	}
+	log.Printf("Loading private key from: %s\n", keySource)
 
 	log.Println("Server key fingerprint: ", internal.FingerprintSHA256Hex(private.PublicKey()))
 
-	if enabledWebserver {
-		if len(connectBackAddress) == 0 {
-			connectBackAddress = addr
+	tracing.Start(config.Collector)
+
+	if err := sequence.Start(dataDir); err != nil {
+		return nil, err
+	}
+
+	audit.Start(filepath.Join(dataDir, "audit.log"), hostkey.Current())
+	vault.Start(dataDir)
+	persistence.Start(dataDir)
+	identity.Start(dataDir)
+
+	for _, start := range []func(string) error{
+		crashes.Start, approvals.Start, engagements.Start, tokens.Start, bans.Start, forwards.Start, canary.Start, lockdown.Start, clients.Start, preferences.Start, macros.Start, watches.Start, queue.Start, jobs.Start, facts.Start, timings.Start, manifests.Start, toolpacks.Start,
+	} {
+		if err := start(dataDir); err != nil {
+			return nil, err
 		}
-		go webserver.Start(multiplexer.ServerMultiplexer.HTTP(), connectBackAddress, "../", dataDir, private.PublicKey())
+	}
 
+	terminal.SetMouse(!config.NoMouse)
+
+	switch {
+	case config.SlowCommand < 0:
+		timings.SetThreshold(0)
+	case config.SlowCommand > 0:
+		timings.SetThreshold(config.SlowCommand)
+	}
+
+	switch {
+	case config.Watchdog < 0:
+		watchdog.SetDeadline(0)
+	case config.Watchdog > 0:
+		watchdog.SetDeadline(config.Watchdog)
+	}
+
+	clients.SetLimits(config.Limits)
+	if config.Limits.Total > 0 || config.Limits.PerSource > 0 {
+		log.Printf("Limiting clients to %d in total and %d per source (/%d ipv4, /%d ipv6), 0 is unlimited\n", config.Limits.Total, config.Limits.PerSource, config.Limits.IPv4Prefix, config.Limits.IPv6Prefix)
+	}
+	log.Printf("Clients connecting from a second address are handled with the %s clone policy\n", clients.GetLimits().Clones)
+
+	sshConfig, err := sshConfig(private, dataDir, config.Insecure, config.OpenProxy, config.Honeypot, config.Authenticator)
+	if err != nil {
+		return nil, err
+	}
+
+	multiplexer.ServerMultiplexer, err = mux.ListenWithConfig("tcp", config.ListenAddress, c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on %s (%s)", config.ListenAddress, err)
+	}
+
+	s := &Server{
+		listener: multiplexer.ServerMultiplexer,
+		addr:     multiplexer.ServerMultiplexer.SSH().Addr(),
+		hostKey:  private,
+		watchLog: observers.ConnectionState.Register(watchLog(dataDir)),
+		stopped:  make(chan struct{}),
+	}
+
+	if config.ConnectHook != "" {
+		s.connectHook = observers.ConnectionState.Register(connectHook(config.ConnectHook))
+		log.Printf("Running %s whenever a client connects or disconnects\n", config.ConnectHook)
+	}
+
+	log.Printf("Listening on %s\n", s.Addr())
+
+	if config.Webserver {
+		connectBackAddress := config.ExternalAddress
+		if len(connectBackAddress) == 0 {
+			connectBackAddress = s.Addr().String()
+		}
+		go webserver.Start(s.listener.HTTP(), connectBackAddress, "../", dataDir, private.PublicKey())
 	}
 
 	go webhooks.StartWebhooks(configPath)
 
-	StartSSHServer(multiplexer.ServerMultiplexer.SSH(), private, insecure, openproxy, dataDir, timeout)
+	go func() {
+		serveSSH(s.listener.SSH(), sshConfig, config.Timeout, dataDir)
+		s.Stop()
+	}()
+
+	started = true
+	return s, nil
+}
+
+// Addr is the address the server first listened on, with the port picked if it was started on port 0
+func (s *Server) Addr() net.Addr {
+	return s.addr
+}
+
+// HostKey is the key the server proves itself with, clients are built to expect it
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// Stop closes every listener and disconnects every client and operator. The server cannot be started again in the same process
+func (s *Server) Stop() error {
+	s.stopOnce.Do(func() {
+		s.listener.Close()
+		observers.ConnectionState.Deregister(s.watchLog)
+		if s.connectHook != "" {
+			observers.ConnectionState.Deregister(s.connectHook)
+		}
+
+		for _, user := range internal.GetUsers() {
+			if user.ServerConnection != nil {
+				user.ServerConnection.Close()
+			}
+		}
+
+		connected, _ := clients.Search("")
+		for _, conn := range connected {
+			conn.Close()
+		}
+
+		close(s.stopped)
+	})
+
+	return nil
+}
+
+// Wait blocks until the server is stopped
+func (s *Server) Wait() {
+	<-s.stopped
+}
+
+// Run starts the server and serves until it is stopped, exiting the process if it cannot start
+func Run(config Config) {
+	s, err := Start(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s.Wait()
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -9,22 +10,57 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/bans"
+	"github.com/NHAS/reverse_ssh/internal/server/canary"
 	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/commands"
+	"github.com/NHAS/reverse_ssh/internal/server/crashes"
+	"github.com/NHAS/reverse_ssh/internal/server/engagements"
+	"github.com/NHAS/reverse_ssh/internal/server/forwards"
 	"github.com/NHAS/reverse_ssh/internal/server/handlers"
+	"github.com/NHAS/reverse_ssh/internal/server/honeypot"
+	"github.com/NHAS/reverse_ssh/internal/server/kex"
+	"github.com/NHAS/reverse_ssh/internal/server/middleware"
 	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/internal/server/preferences"
+	"github.com/NHAS/reverse_ssh/internal/server/presence"
+	"github.com/NHAS/reverse_ssh/internal/server/selftest"
+	"github.com/NHAS/reverse_ssh/internal/server/persistence"
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
+	"github.com/NHAS/reverse_ssh/internal/server/tokens"
+	"github.com/NHAS/reverse_ssh/internal/server/tracing"
+	"github.com/NHAS/reverse_ssh/internal/server/watchdog"
 	"github.com/NHAS/reverse_ssh/pkg/logger"
 	"github.com/NHAS/reverse_ssh/pkg/observer"
 	"golang.org/x/crypto/ssh"
 )
 
 type Options struct {
-	AllowList []*net.IPNet
-	DenyList  []*net.IPNet
-	Comment   string
+	AllowList  []*net.IPNet
+	DenyList   []*net.IPNet
+	Comment    string
+	Role       string
+	Engagement string
+	Duress     bool
+	Prompt     string
+	Persist    string
+	Compress   bool
+
+	LockAfter      time.Duration
+	LockPassphrase string
+
+	// What a security key must show at login, set by touch-required or verify-required
+	Presence presence.Requirement
+
+	Quota internal.Quota
 }
 
 func readPubKeys(path string) (m map[string]Options, err error) {
@@ -50,8 +86,94 @@ func readPubKeys(path string) (m map[string]Options, err error) {
 		var opts Options
 		opts.Comment = comment
 
+		opts.Role = internal.RoleAdmin
+
 		for _, o := range options {
+			if o == "duress" {
+				opts.Duress = true
+				continue
+			}
+
+			if o == "compress" {
+				opts.Compress = true
+				continue
+			}
+
+			if o == "touch-required" || o == "verify-required" {
+				if !presence.IsSecurityKey(pubKey.Type()) {
+					return m, fmt.Errorf("%s is only for security keys (sk-), not %s. %s line %d", o, pubKey.Type(), path, i+1)
+				}
+
+				if o == "verify-required" {
+					opts.Presence = presence.Verify
+				} else if opts.Presence == presence.None {
+					opts.Presence = presence.Touch
+				}
+				continue
+			}
+
+			// Prompts can easily contain =, so cant be split like the other options
+			if strings.HasPrefix(o, "prompt=") {
+				opts.Prompt = strings.Trim(strings.TrimPrefix(o, "prompt="), "\"")
+				continue
+			}
+
+			if strings.HasPrefix(o, "lock-passphrase=") {
+				opts.LockPassphrase = strings.Trim(strings.TrimPrefix(o, "lock-passphrase="), "\"")
+				continue
+			}
+
 			parts := strings.Split(o, "=")
+			if len(parts) == 2 && parts[0] == "role" {
+				role := strings.Trim(parts[1], "\"")
+				if !internal.ValidRole(role) {
+					return m, fmt.Errorf("unknown role %q. %s line %d", role, path, i+1)
+				}
+
+				opts.Role = role
+				continue
+			}
+
+			if len(parts) == 2 && parts[0] == "lock-after" {
+				opts.LockAfter, err = time.ParseDuration(strings.Trim(parts[1], "\""))
+				if err != nil {
+					return m, fmt.Errorf("invalid lock-after %q. %s line %d", parts[1], path, i+1)
+				}
+				continue
+			}
+
+			if len(parts) == 2 && (parts[0] == "max-sessions" || parts[0] == "max-forwards") {
+				n, err := strconv.Atoi(strings.Trim(parts[1], "\""))
+				if err != nil || n < 0 {
+					return m, fmt.Errorf("invalid %s %q. %s line %d", parts[0], parts[1], path, i+1)
+				}
+
+				if parts[0] == "max-sessions" {
+					opts.Quota.Sessions = n
+				} else {
+					opts.Quota.Forwards = n
+				}
+				continue
+			}
+
+			if len(parts) == 2 && parts[0] == "max-transfer" {
+				opts.Quota.TransferPerDay, err = internal.ParseBytes(strings.Trim(parts[1], "\""))
+				if err != nil {
+					return m, fmt.Errorf("invalid max-transfer %q. %s line %d", parts[1], path, i+1)
+				}
+				continue
+			}
+
+			if len(parts) == 2 && parts[0] == "engagement" {
+				opts.Engagement = strings.Trim(parts[1], "\"")
+				continue
+			}
+
+			if len(parts) == 2 && parts[0] == "persist" {
+				opts.Persist = strings.Trim(parts[1], "\"")
+				continue
+			}
+
 			if len(parts) == 2 && parts[0] == "from" {
 				list := strings.Trim(parts[1], "\"")
 
@@ -102,8 +224,8 @@ func ParseDirective(address string) (cidr []*net.IPNet, err error) {
 		return
 	}
 
-	ip := net.ParseIP(address)
-	if ip == nil {
+	ip := internal.ParseIP(address)
+	if ip != nil {
 		var newcidr net.IPNet
 		newcidr.IP = ip
 		newcidr.Mask = net.CIDRMask(32, 32)
@@ -140,7 +262,96 @@ func ParseDirective(address string) (cidr []*net.IPNet, err error) {
 	return
 }
 
-func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, openproxy bool, dataDir string, timeout int) {
+// loadAlgorithmPolicy reads which algorithms the server negotiates, no file means the hardened defaults
+func loadAlgorithmPolicy(path string) (internal.AlgorithmPolicy, error) {
+	var policy internal.AlgorithmPolicy
+
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return policy, err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(b, &policy); err != nil {
+			return policy, fmt.Errorf("unable to parse %s: %s", path, err)
+		}
+	}
+
+	policy, err = policy.Resolve()
+	if err != nil {
+		return policy, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return policy, nil
+}
+
+func hostKeyAllowed(policy internal.AlgorithmPolicy, key ssh.PublicKey) bool {
+	if key.Type() == ssh.KeyAlgoRSA {
+		// RSA keys can sign with any of these, so one being allowed is enough
+		return policy.AllowsHostKey(ssh.KeyAlgoRSASHA512) || policy.AllowsHostKey(ssh.KeyAlgoRSASHA256) || policy.AllowsHostKey(ssh.KeyAlgoRSA)
+	}
+
+	return policy.AllowsHostKey(key.Type())
+}
+
+// userPermissions are given to operators, key is nil for those an authenticator logged in with keyboard-interactive
+func userPermissions(key ssh.PublicKey, comment, role, metadata string) *ssh.Permissions {
+	perms := &ssh.Permissions{
+		Extensions: map[string]string{
+			"comment":       comment,
+			"type":          "user",
+			"role":          role,
+			"key-type":      "keyboard-interactive",
+			"auth-metadata": metadata,
+		},
+	}
+
+	if key != nil {
+		// Record the public key used for authentication.
+		perms.Extensions["pubkey-fp"] = internal.FingerprintSHA1Hex(key)
+		perms.Extensions["key-type"] = key.Type()
+		perms.Extensions["pubkey"] = string(ssh.MarshalAuthorizedKey(key))
+	}
+
+	return perms
+}
+
+// offeredKeys remembers the key each handshake last tried, so a client that then presents an enrollment token can have that key enrolled
+type offeredKeys struct {
+	sync.Mutex
+	keys map[string]offeredKey
+}
+
+type offeredKey struct {
+	key  ssh.PublicKey
+	seen time.Time
+}
+
+func (o *offeredKeys) offer(conn ssh.ConnMetadata, key ssh.PublicKey) {
+	o.Lock()
+	defer o.Unlock()
+
+	for id, k := range o.keys {
+		if time.Since(k.seen) > time.Minute {
+			delete(o.keys, id)
+		}
+	}
+
+	o.keys[string(conn.SessionID())] = offeredKey{key: key, seen: time.Now()}
+}
+
+func (o *offeredKeys) take(conn ssh.ConnMetadata) ssh.PublicKey {
+	o.Lock()
+	defer o.Unlock()
+
+	k := o.keys[string(conn.SessionID())]
+	delete(o.keys, string(conn.SessionID()))
+
+	return k.key
+}
+
+// sshConfig loads the key files and algorithm policy from dataDir, and decides who gets in as what
+func sshConfig(privateKey ssh.Signer, dataDir string, insecure, openproxy, honeypotMode bool, auth Authenticator) (*ssh.ServerConfig, error) {
 	//Taken from the server example, authorized keys are required for controllers
 	authorizedKeysPath := filepath.Join(dataDir, "authorized_keys")
 	authorizedControlleeKeysPath := filepath.Join(dataDir, "authorized_controllee_keys")
@@ -149,7 +360,7 @@ func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, o
 	log.Printf("Loading authorized keys from: %s\n", authorizedKeysPath)
 	authorizedControllers, err := readPubKeys(authorizedKeysPath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	if _, err := os.Stat("downloads"); err != nil && os.IsNotExist(err) {
@@ -160,7 +371,7 @@ func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, o
 	clients, err := readPubKeys(authorizedControlleeKeysPath)
 	if err != nil {
 		if !insecure {
-			log.Fatal(err)
+			return nil, err
 		} else {
 			log.Println(err)
 		}
@@ -168,18 +379,46 @@ func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, o
 
 	for key := range clients {
 		if _, ok := authorizedControllers[key]; ok {
-			log.Fatalf("[ERROR] Key %s is present in both authorized_controllee_keys and authorized_keys. It should only be in one.", strings.TrimSpace(key))
+			return nil, fmt.Errorf("[ERROR] Key %s is present in both authorized_controllee_keys and authorized_keys. It should only be in one.", strings.TrimSpace(key))
 		}
 	}
 
+	policy, err := loadAlgorithmPolicy(filepath.Join(dataDir, "algorithms.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !hostKeyAllowed(policy, privateKey.PublicKey()) {
+		return nil, fmt.Errorf("The server key (%s) is not allowed by the %s algorithm profile", privateKey.PublicKey().Type(), policy.Profile)
+	}
+
 	// In the latest version of crypto/ssh (after Go 1.3), the SSH server type has been removed
 	// in favour of an SSH connection type. A ssh.ServerConn is created by passing an existing
 	// net.Conn and a ssh.ServerConfig to ssh.NewServerConn, in effect, upgrading the net.Conn
 	// into an ssh.ServerConn
+	offered := &offeredKeys{keys: map[string]offeredKey{}}
+
 	config := &ssh.ServerConfig{
 		ServerVersion: "SSH-2.0-OpenSSH_8.0",
 		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 
+			offered.offer(conn, key)
+
+			if selftest.Trusted(key) {
+				return &ssh.Permissions{
+					Extensions: map[string]string{
+						"comment":   "selftest",
+						"pubkey-fp": internal.FingerprintSHA1Hex(key),
+						"type":      "client",
+						"selftest":  "true",
+					},
+				}, nil
+			}
+
+			if canary.TripKey(key, conn.RemoteAddr().String()) {
+				return nil, fmt.Errorf("not authorized %q", conn.User())
+			}
+
 			authorizedKeysMap, err := readPubKeys(authorizedKeysPath)
 			if err != nil {
 				log.Println("Reloading authorized_keys failed: ", err)
@@ -195,7 +434,7 @@ func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, o
 				log.Println("Reloading authorized_proxy_keys failed: ", err)
 			}
 
-			remoteIp := getIP(conn.RemoteAddr().String())
+			remoteIp := internal.HostIP(conn.RemoteAddr().String())
 
 			if remoteIp == nil {
 				return nil, fmt.Errorf("not authorized %q, could not parse IP address %s", conn.User(), conn.RemoteAddr())
@@ -206,6 +445,10 @@ func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, o
 
 			if opt, ok := authorizedKeysMap[string(ssh.MarshalAuthorizedKey(key))]; ok {
 
+				if !policy.AllowsOperatorKey(key.Type()) {
+					return nil, fmt.Errorf("not authorized %q (%s keys are not allowed for operators)", conn.User(), key.Type())
+				}
+
 				for _, deny := range opt.DenyList {
 					if deny.Contains(remoteIp) {
 						return nil, fmt.Errorf("not authorized %q (deny list)", conn.User())
@@ -224,27 +467,77 @@ func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, o
 					return nil, fmt.Errorf("not authorized %q (not on allow list)", conn.User())
 				}
 
-				return &ssh.Permissions{
-					// Record the public key used for authentication.
-					Extensions: map[string]string{
-						"comment":   opt.Comment,
-						"pubkey-fp": internal.FingerprintSHA1Hex(key),
-						"type":      "user",
-					},
-				}, nil
+				role, metadata := opt.Role, ""
+				if auth != nil {
+					role, metadata, err = askAuthHook(auth, conn, key, true, opt.Role)
+					if err != nil {
+						return nil, fmt.Errorf("not authorized %q (%s)", conn.User(), err)
+					}
+				}
+
+				perms := userPermissions(key, opt.Comment, role, metadata)
+				if opt.Duress {
+					perms.Extensions["duress"] = "true"
+				}
+				perms.Extensions["prompt"] = opt.Prompt
+				if opt.LockAfter > 0 {
+					perms.Extensions["lock-after"] = opt.LockAfter.String()
+					perms.Extensions["lock-passphrase"] = opt.LockPassphrase
+				}
+				perms.Extensions["presence"] = string(opt.Presence)
+				perms.Extensions["max-sessions"] = strconv.Itoa(opt.Quota.Sessions)
+				perms.Extensions["max-forwards"] = strconv.Itoa(opt.Quota.Forwards)
+				perms.Extensions["max-transfer"] = strconv.FormatUint(opt.Quota.TransferPerDay, 10)
 
+				return perms, nil
 			}
 
-			if opt, ok := authorizedControllees[string(ssh.MarshalAuthorizedKey(key))]; insecure || ok {
+			opt, isControllee := authorizedControllees[string(ssh.MarshalAuthorizedKey(key))]
+			_, isProxy := authorizedProxiers[string(ssh.MarshalAuthorizedKey(key))]
 
-				return &ssh.Permissions{
+			if auth != nil && !insecure {
+				isControllee, err = auth.CheckClientKey(authRequest(conn, key, isControllee, ""))
+				if err != nil {
+					log.Printf("Authenticator refused client %q from %s: %s", conn.User(), conn.RemoteAddr(), err)
+					isControllee = false
+				}
+			}
+
+			// Banned addresses may be shared with real operators and clients (NAT), so they keep the keys already trusted and lose everything else
+			if bans.Banned(remoteIp) && !isControllee && !isProxy {
+				return nil, fmt.Errorf("not authorized %q (banned)", conn.User())
+			}
+
+			// Keys the server doesnt know of at all may still be operators the auth hook knows about, unless insecure mode has made every unknown key a client
+			if auth != nil && !insecure && !isControllee && !isProxy && policy.AllowsOperatorKey(key.Type()) {
+				role, metadata, err := askAuthHook(auth, conn, key, false, "")
+				if err == nil {
+					return userPermissions(key, "", role, metadata), nil
+				}
+
+				log.Printf("Auth hook refused %q from %s: %s", conn.User(), conn.RemoteAddr(), err)
+			}
+
+			if insecure || isControllee {
+				if !policy.AllowsClientKey(key.Type()) {
+					return nil, fmt.Errorf("not authorized %q (%s keys are not allowed for clients in FIPS mode)", conn.User(), key.Type())
+				}
+
+				perms := &ssh.Permissions{
 					// Record the public key used for authentication.
 					Extensions: map[string]string{
-						"comment":   opt.Comment,
-						"pubkey-fp": internal.FingerprintSHA1Hex(key),
-						"type":      "client",
+						"comment":    opt.Comment,
+						"pubkey-fp":  internal.FingerprintSHA1Hex(key),
+						"type":       "client",
+						"engagement": opt.Engagement,
+						"persist":    opt.Persist,
 					},
-				}, nil
+				}
+				if opt.Compress {
+					perms.Extensions["compress"] = "true"
+				}
+
+				return perms, nil
 			}
 
 			if opt, ok := authorizedProxiers[string(ssh.MarshalAuthorizedKey(key))]; insecure || openproxy || ok {
@@ -261,11 +554,83 @@ func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, o
 
 			return nil, fmt.Errorf("not authorized %q, potentially you might want to enabled -insecure mode", conn.User())
 		},
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			if bans.Banned(internal.HostIP(conn.RemoteAddr().String())) {
+				return nil, fmt.Errorf("not authorized %q (banned)", conn.User())
+			}
+
+			if auth != nil {
+				response, err := auth.KeyboardInteractive(conn, challenge)
+				if err != nil {
+					return nil, fmt.Errorf("not authorized %q (%s)", conn.User(), err)
+				}
+
+				if response.Allow {
+					role, metadata, err := decided(response, "")
+					if err != nil {
+						return nil, fmt.Errorf("not authorized %q (%s)", conn.User(), err)
+					}
+
+					return userPermissions(nil, conn.User(), role, metadata), nil
+				}
+			}
+
+			if !tokens.Available() && !canary.Tokens() {
+				return nil, fmt.Errorf("not authorized %q, no enrollment tokens available", conn.User())
+			}
+
+			answers, err := challenge("", "", []string{"Enrollment token: "}, []bool{false})
+			if err != nil || len(answers) != 1 {
+				return nil, fmt.Errorf("not authorized %q, no enrollment token given", conn.User())
+			}
+
+			if canary.TripToken(answers[0], conn.RemoteAddr().String()) {
+				return nil, fmt.Errorf("not authorized %q, %s", conn.User(), tokens.ErrInvalid)
+			}
+
+			t, err := tokens.Redeem(answers[0], offered.take(conn), conn.RemoteAddr().String())
+			if err != nil {
+				return nil, fmt.Errorf("not authorized %q, %s", conn.User(), err)
+			}
+
+			log.Printf("Enrolled new client %q from %s with token %s\n", conn.User(), conn.RemoteAddr(), t.ID)
+
+			// Refuse this connection anyway, the client reconnects with the enrolled key and so proves it actually holds it
+			return nil, fmt.Errorf("enrolled %q, reconnect with the enrolled key", conn.User())
+		},
+	}
+
+	policy.Apply(&config.Config)
+	log.Printf("Using the %s SSH algorithm profile\n", policy.Profile)
+
+	// rssh clients never try passwords, so anyone who does is sent to the honeypot
+	if honeypotMode {
+		config.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if bans.Banned(internal.HostIP(conn.RemoteAddr().String())) {
+				return nil, fmt.Errorf("not authorized %q (banned)", conn.User())
+			}
+
+			return honeypot.Credentials(conn, password), nil
+		}
+		log.Println("Password logins are sent to the honeypot")
+	}
+
+	if policy.Profile == internal.ProfilePostQuantum && !policy.PostQuantum() {
+		log.Println("[WARNING] This build has no post-quantum key exchange, only classical key exchanges will be offered")
+	}
+
+	if auth != nil {
+		config.BannerCallback = auth.Banner
 	}
 
 	config.AddHostKey(privateKey)
 
-	observers.ConnectionState.Register(func(m observer.Message) {
+	return config, nil
+}
+
+// watchLog keeps the record of clients coming and going that watch shows
+func watchLog(dataDir string) observer.Target {
+	return func(m observer.Message) {
 
 		c := m.(observers.ClientState)
 
@@ -280,16 +645,26 @@ func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, o
 		}
 		defer f.Close()
 
-		if _, err := f.WriteString(fmt.Sprintf("%s %s %s (%s %s) %s %s\n", c.Timestamp.Format("2006/01/02 15:04:05"), arrowDirection, c.HostName, c.IP, c.ID, c.Version, c.Status)); err != nil {
+		if _, err := f.WriteString(fmt.Sprintf("%s %s %s (%s %s) %s %s %s\n", c.Timestamp.Format("2006/01/02 15:04:05"), arrowDirection, c.HostName, c.IP, c.ID, c.Version, c.Status, c.Sequence)); err != nil {
 			log.Println(err)
 		}
+	}
+}
 
+// serveSSH accepts connections until sshListener is closed
+func serveSSH(sshListener net.Listener, config *ssh.ServerConfig, timeout int, dataDir string) {
+	selftest.Listen(func(c net.Conn) {
+		acceptConn(c, config, timeout, dataDir)
 	})
 
 	// Accept all connections
 	for {
 		conn, err := sshListener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
 			log.Printf("Failed to accept incoming connection (%s)", err)
 			continue
 		}
@@ -298,30 +673,66 @@ func StartSSHServer(sshListener net.Listener, privateKey ssh.Signer, insecure, o
 	}
 }
 
-func getIP(ip string) net.IP {
-	for i := len(ip) - 1; i > 0; i-- {
-		if ip[i] == ':' {
-			return net.ParseIP(strings.Trim(strings.Trim(ip[:i], "]"), "["))
-		}
+func cloneDetected(remote, action, id, description string, alert bool) {
+	audit.Log(remote, action, id, description)
+	if alert {
+		observers.Alerts.Notify(observers.Alert{
+			Kind:      "clone",
+			Message:   description,
+			Timestamp: time.Now(),
+			Sequence:  sequence.Next(),
+		})
+	}
+}
+
+// annotations formats session annotations for the log, sorted so the same session always logs the same way
+func annotations(m map[string]string) string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
 	}
+	sort.Strings(pairs)
 
-	return nil
+	return strings.Join(pairs, " ")
 }
 
 func acceptConn(c net.Conn, config *ssh.ServerConfig, timeout int, dataDir string) {
 
+	watch := kex.Watch(c, true)
+	supervised := watchdog.Wrap(watch)
+
 	//Initially set the timeout high, so people who type in their ssh key password can actually use rssh
-	realConn := &internal.TimeoutConn{c, time.Duration(timeout) * time.Minute}
+	realConn := &internal.TimeoutConn{supervised, time.Duration(timeout) * time.Minute}
+
+	span := tracing.New("ssh.connection", nil).Set("net.peer.addr", c.RemoteAddr().String())
+	handshake := tracing.New("ssh.handshake", span)
 
 	// Before use, a handshake must be performed on the incoming net.Conn.
 	sshConn, chans, reqs, err := ssh.NewServerConn(realConn, config)
+	handshake.Error(err)
+	handshake.End()
 	if err != nil {
+		span.Error(err)
+		span.End()
+
 		log.Printf("Failed to handshake (%s)", err.Error())
 		return
 	}
 
+	span.Set("ssh.user", sshConn.User()).Set("ssh.client_version", string(sshConn.ClientVersion())).Set("rssh.type", sshConn.Permissions.Extensions["type"])
+	tracing.Bind(sshConn, span)
+	kex.Track(sshConn.Permissions.Extensions["type"], sshConn, watch)
+	go func() {
+		sshConn.Wait()
+		tracing.Unbind(sshConn)
+		span.End()
+	}()
+
 	clientLog := logger.NewLog(sshConn.RemoteAddr().String())
 
+	supervisor := supervised.Supervise(sshConn, sshConn.Permissions.Extensions["type"]+" "+sshConn.User())
+	chans = supervisor.Channels(chans)
+
 	if timeout > 0 {
 		//If we are using timeouts
 		//Set the actual timeout much lower to whatever the user specifies it as (defaults to 5 second keepalive, 10 second timeout)
@@ -341,6 +752,9 @@ func acceptConn(c net.Conn, config *ssh.ServerConfig, timeout int, dataDir strin
 	}
 
 	switch sshConn.Permissions.Extensions["type"] {
+	case "honeypot":
+		go honeypot.Serve(sshConn, chans, reqs)
+
 	case "user":
 		user, err := internal.CreateUser(sshConn)
 		if err != nil {
@@ -348,20 +762,88 @@ func acceptConn(c net.Conn, config *ssh.ServerConfig, timeout int, dataDir strin
 			log.Println(err)
 			return
 		}
+		user.Role = sshConn.Permissions.Extensions["role"]
+		user.Duress = sshConn.Permissions.Extensions["duress"] == "true"
+		user.Prompt = sshConn.Permissions.Extensions["prompt"]
+		user.PublicKey, _, _, _, _ = ssh.ParseAuthorizedKey([]byte(sshConn.Permissions.Extensions["pubkey"]))
+		user.KeyComment = sshConn.Permissions.Extensions["comment"]
+		user.LockAfter, _ = time.ParseDuration(sshConn.Permissions.Extensions["lock-after"])
+		user.LockPassphrase = sshConn.Permissions.Extensions["lock-passphrase"]
+		user.Quota.Sessions, _ = strconv.Atoi(sshConn.Permissions.Extensions["max-sessions"])
+		user.Quota.Forwards, _ = strconv.Atoi(sshConn.Permissions.Extensions["max-forwards"])
+		user.Quota.TransferPerDay, _ = strconv.ParseUint(sshConn.Permissions.Extensions["max-transfer"], 10, 64)
+
+		saved := preferences.Get(user.Operator())
+		user.Accessible = saved.Accessible
+		user.Lang = saved.Lang
+
+		if user.Duress {
+			// Nothing on the session itself can hint that this was noticed
+			audit.Log(user.ConnectionDetails, "duress-login", sshConn.Permissions.Extensions["comment"], string(sshConn.ClientVersion()))
+			observers.Alerts.Notify(observers.Alert{
+				Kind:      "duress",
+				Message:   fmt.Sprintf("Duress key %q used to log in from %s", sshConn.Permissions.Extensions["comment"], sshConn.RemoteAddr()),
+				Timestamp: time.Now(),
+				Sequence:  sequence.Next(),
+			})
+		}
+
+		refuse := func(action string, err error) {
+			clientLog.Warning("Refusing %s: %s", user.ConnectionDetails, err)
+			audit.Log(user.ConnectionDetails, action, sshConn.Permissions.Extensions["comment"], err.Error())
+
+			// Closing straight away would leave them with nothing to go on, rejecting whatever they open shows them why
+			go ssh.DiscardRequests(reqs)
+			go func() {
+				for newChannel := range chans {
+					newChannel.Reject(ssh.ResourceShortage, fmt.Sprintf("\n\n%s\n", err))
+				}
+			}()
+			time.AfterFunc(10*time.Second, func() {
+				internal.DeleteUser(user)
+			})
+		}
+
+		if err := presence.Challenge(sshConn, user.PublicKey, presence.Requirement(sshConn.Permissions.Extensions["presence"])); err != nil {
+			refuse("presence-refused", err)
+			return
+		}
+
+		if err := user.StartSession(); err != nil {
+			refuse("quota-refused", err)
+			return
+		}
+
+		session := middleware.Of(user)
+		if err := middleware.BeforeSession(session); err != nil {
+			refuse("hook-refused", err)
+			return
+		}
+
+		release := supervisor.Release(func() {
+			middleware.AfterSession(session)
+			internal.DeleteUser(user)
+		})
 
 		// Since we're handling a shell, local and remote forward, so we expect
 		// channel type of "session" or "direct-tcpip"
 		go func() {
-			err = internal.RegisterChannelCallbacks(user, chans, clientLog, map[string]internal.ChannelHandler{
+			err = internal.RegisterChannelCallbacks(user, chans, clientLog, tracing.Channels(sshConn, crashes.Channels("", map[string]internal.ChannelHandler{
 				"session":      handlers.Session(dataDir),
 				"direct-tcpip": handlers.LocalForward,
-			})
+			})))
 			clientLog.Info("User disconnected: %s", err.Error())
 
-			internal.DeleteUser(user)
+			release()
 		}()
 
-		clientLog.Info("New User SSH connection, version %s", sshConn.ClientVersion())
+		clientLog.Info("New User SSH connection, version %s, %s key", sshConn.ClientVersion(), sshConn.Permissions.Extensions["key-type"])
+		if metadata := sshConn.Permissions.Extensions["auth-metadata"]; metadata != "" {
+			clientLog.Info("Auth hook metadata for %s: %s", user.ConnectionDetails, metadata)
+		}
+		if len(session.Annotations) > 0 {
+			clientLog.Info("Session annotations for %s: %s", user.ConnectionDetails, annotations(session.Annotations))
+		}
 
 		// Discard all global out-of-band Requests, except for the tcpip-forward
 		go ssh.DiscardRequests(reqs)
@@ -370,22 +852,42 @@ func acceptConn(c net.Conn, config *ssh.ServerConfig, timeout int, dataDir strin
 
 		id, username, err := clients.Add(sshConn)
 		if err != nil {
-			clientLog.Error("Unable to add new client %s", err)
+			if errors.Is(err, clients.ErrLimit) || errors.Is(err, clients.ErrClone) {
+				clientLog.Warning("Refusing client: %s", err)
+				span.Set("rssh.refused", err.Error())
+				// Tells the client why, so it waits longer before trying again rather than looking like a network fault
+				sshConn.SendRequest("rejected", true, []byte(err.Error()))
+			} else {
+				clientLog.Error("Unable to add new client %s", err)
+			}
+
+			if errors.Is(err, clients.ErrClone) {
+				cloneDetected(sshConn.RemoteAddr().String(), "client-clone-refused", clients.ID(sshConn.Permissions.Extensions["pubkey-fp"], clients.NormaliseHostname(sshConn.User())), err.Error(), true)
+			}
 
 			sshConn.Close()
 			return
 		}
+		span.Set("rssh.client_id", id)
 
-		go func() {
-			go ssh.DiscardRequests(reqs)
+		if original := sshConn.Permissions.Extensions["clone-of"]; original != "" {
+			description := fmt.Sprintf("client %s connected from %s while already connected from elsewhere, given id %s", original, sshConn.RemoteAddr(), id)
+			if clients.Quarantined(id) {
+				description += " and quarantined"
+			}
 
-			err = internal.RegisterChannelCallbacks(nil, chans, clientLog, map[string]internal.ChannelHandler{
-				"rssh-download":   handlers.Download(dataDir),
-				"forwarded-tcpip": handlers.ServerPortForward(id),
-			})
+			clientLog.Warning("Possible clone: %s", description)
+			cloneDetected(sshConn.RemoteAddr().String(), "client-clone", id, description, clients.GetLimits().Clones != clients.ClonesSuffix)
+		}
 
-			clientLog.Info("SSH client disconnected")
+		// Also run by the watchdog should the connection be closed as stuck and not get this far itself
+		release := supervisor.Release(func() {
 			clients.Remove(id)
+			forwards.Disconnected(id)
+
+			if sshConn.Permissions.Extensions["selftest"] == "true" {
+				return
+			}
 
 			observers.ConnectionState.Notify(observers.ClientState{
 				Status:    "disconnected",
@@ -394,11 +896,57 @@ func acceptConn(c net.Conn, config *ssh.ServerConfig, timeout int, dataDir strin
 				HostName:  username,
 				Version:   string(sshConn.ClientVersion()),
 				Timestamp: time.Now(),
+				Sequence:  sequence.Next(),
 			})
+		})
+
+		go func() {
+			go ssh.DiscardRequests(reqs)
+
+			err = internal.RegisterChannelCallbacks(nil, chans, clientLog, tracing.Channels(sshConn, crashes.Channels(id, map[string]internal.ChannelHandler{
+				"rssh-download":   handlers.Download(dataDir),
+				"forwarded-tcpip": handlers.ServerPortForward(id),
+			})))
+
+			clientLog.Info("SSH client disconnected")
+			release()
 		}()
 
 		clientLog.Info("New controllable connection with id %s", id)
 
+		if err := clients.Negotiate(id, sshConn); err != nil {
+			clientLog.Warning("Unable to ask the client what it supports: %s", err)
+		}
+
+		clients.SetCompression(id, sshConn.Permissions.Extensions["compress"] == "true")
+
+		if ok, err := engagements.Admit(id, sshConn); !ok {
+			clientLog.Warning("Refusing client %s: %s", id, err)
+			sshConn.Close()
+			return
+		}
+
+		go forwards.Connected(id, sshConn)
+
+		// Clients built with link --persist install it themselves the first time they connect
+		if method := sshConn.Permissions.Extensions["persist"]; method != "" && clients.HasCapability(id, "persist") {
+			go func() {
+				r, installed, err := persistence.Ensure("link", id, sshConn, method, "rssh")
+				if err != nil {
+					clientLog.Warning("Unable to install %s persistence: %s", method, err)
+				} else if installed {
+					clientLog.Info("Installed %s persistence: %q", method, r.Changes)
+				}
+			}()
+		}
+
+		// Self tests would otherwise set off webhooks and fill watch.log every time they run
+		if sshConn.Permissions.Extensions["selftest"] == "true" {
+			return
+		}
+
+		go commands.RunQueued(id, dataDir)
+
 		observers.ConnectionState.Notify(observers.ClientState{
 			Status:    "connected",
 			ID:        id,
@@ -406,6 +954,7 @@ func acceptConn(c net.Conn, config *ssh.ServerConfig, timeout int, dataDir strin
 			HostName:  username,
 			Version:   string(sshConn.ClientVersion()),
 			Timestamp: time.Now(),
+			Sequence:  sequence.Next(),
 		})
 
 	case "proxy":
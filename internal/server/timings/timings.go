@@ -0,0 +1,165 @@
+// Package timings keeps how long console commands take. Every run counts towards the totals of its command, and runs slower
+// than the threshold are written to slow.log with who ran them and what was connected at the time
+package timings
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
+)
+
+const DefaultThreshold = 30 * time.Second
+
+// Run is one finished command
+type Run struct {
+	Finished time.Time
+	Operator string
+	Command  string
+	Line     string
+	Took     time.Duration
+	Error    string `json:",omitempty"`
+	// Set when the command ran as part of a background job
+	Job string `json:",omitempty"`
+	// How many clients were connected when it finished, as a command across the fleet is slower the bigger it is
+	Clients int
+	// Set as it is written to slow.log
+	Sequence sequence.Stamp
+}
+
+// Stat is the totals of every run of a command since the server started
+type Stat struct {
+	Command string
+	Runs    int
+	Total   time.Duration
+	Slowest time.Duration
+}
+
+var (
+	lck       sync.Mutex
+	path      string
+	threshold = DefaultThreshold
+	stats     = map[string]*Stat{}
+)
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "slow.log")
+	return nil
+}
+
+// SetThreshold sets how long a command runs for before it is logged as slow, 0 stops logging them
+func SetThreshold(d time.Duration) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	threshold = d
+}
+
+func Threshold() time.Duration {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return threshold
+}
+
+// Observe counts a run towards its command's totals, writing it to slow.log if it took at least the threshold
+func Observe(r Run) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	s, ok := stats[r.Command]
+	if !ok {
+		s = &Stat{Command: r.Command}
+		stats[r.Command] = s
+	}
+
+	s.Runs++
+	s.Total += r.Took
+	if r.Took > s.Slowest {
+		s.Slowest = r.Took
+	}
+
+	if threshold <= 0 || r.Took < threshold {
+		return nil
+	}
+
+	log.Printf("[WARNING] %s took %s to run %q with %d clients connected", r.Operator, r.Took.Round(time.Millisecond), r.Line, r.Clients)
+
+	if path == "" {
+		return nil
+	}
+
+	r.Sequence = sequence.Next()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// Stats is the totals of every command run since the server started, the slowest on average first
+func Stats() []Stat {
+	lck.Lock()
+	defer lck.Unlock()
+
+	out := make([]Stat, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, *s)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Total/time.Duration(out[i].Runs) > out[j].Total/time.Duration(out[j].Runs)
+	})
+
+	return out
+}
+
+// Slow is the last n runs written to slow.log, oldest first
+func Slow(n int) ([]Run, error) {
+	lck.Lock()
+	p := path
+	lck.Unlock()
+
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []Run
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Run
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			continue
+		}
+
+		out = append(out, r)
+		if len(out) > n {
+			out = out[1:]
+		}
+	}
+
+	return out, scanner.Err()
+}
@@ -0,0 +1,50 @@
+package timings
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObserve(t *testing.T) {
+	if err := Start(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	SetThreshold(time.Second)
+	defer func() {
+		path, threshold, stats = "", DefaultThreshold, map[string]*Stat{}
+	}()
+
+	for i, took := range []time.Duration{100 * time.Millisecond, 3 * time.Second, 2 * time.Second} {
+		if err := Observe(Run{Operator: "alice", Command: "exec", Line: "exec web ls", Took: took, Clients: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Observe(Run{Operator: "alice", Command: "ls", Line: "ls", Took: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := Stats()
+	if len(stats) != 2 || stats[0].Command != "exec" || stats[0].Runs != 3 || stats[0].Slowest != 3*time.Second {
+		t.Errorf("expected exec to have the slowest average, got %+v", stats)
+	}
+
+	slow, err := Slow(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(slow) != 2 || slow[0].Took != 3*time.Second || slow[1].Clients != 2 {
+		t.Errorf("expected only the two runs past the threshold to be logged, got %+v", slow)
+	}
+
+	if slow, _ := Slow(1); len(slow) != 1 || slow[0].Took != 2*time.Second {
+		t.Errorf("expected only the latest slow run, got %+v", slow)
+	}
+
+	SetThreshold(0)
+	Observe(Run{Command: "exec", Took: time.Hour})
+	if slow, _ := Slow(10); len(slow) != 2 {
+		t.Errorf("expected nothing to be logged with the threshold off, got %+v", slow)
+	}
+}
@@ -0,0 +1,208 @@
+// Package tokens lets clients enroll with a short lived token instead of a key baked in at build time. A client presents
+// the token over keyboard-interactive, and the key it offered is enrolled as a client
+package tokens
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/identity"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrInvalid = errors.New("invalid or expired enrollment token")
+	ErrNoKey   = errors.New("client did not offer a key to enroll")
+)
+
+var (
+	lck    sync.Mutex
+	path   string
+	tokens = map[string]*Token{}
+)
+
+type Token struct {
+	// ID is the start of the hash, the token itself is only ever shown once when created
+	ID         string
+	Hash       string
+	Uses       int
+	Used       int
+	Created    time.Time
+	Expires    time.Time
+	Engagement string `json:",omitempty"`
+	Comment    string `json:",omitempty"`
+}
+
+func (t Token) usable(now time.Time) bool {
+	return t.Used < t.Uses && now.Before(t.Expires)
+}
+
+func hash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "tokens.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return fmt.Errorf("unable to parse tokens.json: %s", err)
+	}
+
+	return nil
+}
+
+func save() error {
+	b, err := json.MarshalIndent(tokens, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Create makes a new token, returning the secret the client must present
+func Create(actor string, uses int, lifetime time.Duration, engagement, comment string) (string, Token, error) {
+	if uses < 1 {
+		return "", Token{}, errors.New("a token must have at least one use")
+	}
+
+	if lifetime <= 0 {
+		return "", Token{}, errors.New("a token must have a positive lifetime")
+	}
+
+	secret, err := internal.RandomString(24)
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	t := Token{
+		Hash:       hash(secret),
+		Uses:       uses,
+		Created:    time.Now(),
+		Expires:    time.Now().Add(lifetime),
+		Engagement: engagement,
+		Comment:    comment,
+	}
+	t.ID = t.Hash[:8]
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	tokens[t.Hash] = &t
+	if err := save(); err != nil {
+		delete(tokens, t.Hash)
+		return "", Token{}, err
+	}
+
+	audit.Log(actor, "token-create", t.ID, fmt.Sprintf("uses %d expires %s engagement %q", uses, t.Expires.Format(time.RFC3339), engagement))
+
+	return secret, t, nil
+}
+
+// Available is whether any token could currently be redeemed, so logins arent prompted for one when none exist
+func Available() bool {
+	lck.Lock()
+	defer lck.Unlock()
+
+	now := time.Now()
+	for _, t := range tokens {
+		if t.usable(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redeem burns one use of a token and enrolls key as a client, the returned token says what the client was enrolled with
+func Redeem(secret string, key ssh.PublicKey, remote string) (Token, error) {
+	if key == nil {
+		return Token{}, ErrNoKey
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	t, ok := tokens[hash(strings.TrimSpace(secret))]
+	if !ok || !t.usable(time.Now()) {
+		audit.Log(remote, "token-redeem", "", "rejected: "+ErrInvalid.Error())
+		return Token{}, ErrInvalid
+	}
+
+	comment := t.Comment
+	if comment == "" {
+		comment = "token-" + t.ID
+	}
+
+	var options []string
+	if t.Engagement != "" {
+		options = append(options, fmt.Sprintf("engagement=%q", t.Engagement))
+	}
+
+	if err := identity.Enroll(key, options, comment); err != nil {
+		audit.Log(remote, "token-redeem", t.ID, "rejected: "+err.Error())
+		return Token{}, err
+	}
+
+	t.Used++
+	if err := save(); err != nil {
+		return Token{}, err
+	}
+
+	audit.Log(remote, "token-redeem", t.ID, fmt.Sprintf("enrolled %s (%d of %d uses)", internal.FingerprintSHA256Hex(key), t.Used, t.Uses))
+
+	return *t, nil
+}
+
+func Delete(actor, id string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for h, t := range tokens {
+		if t.ID == id {
+			delete(tokens, h)
+			audit.Log(actor, "token-delete", id, "")
+			return save()
+		}
+	}
+
+	return fmt.Errorf("token %q not found", id)
+}
+
+func List() []Token {
+	lck.Lock()
+	defer lck.Unlock()
+
+	out := make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		out = append(out, *t)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.Before(out[j].Created)
+	})
+
+	return out
+}
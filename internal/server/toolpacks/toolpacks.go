@@ -0,0 +1,428 @@
+// Package toolpacks keeps named bundles of files on the server, so the same tools can be dropped onto any client by name and
+// removed again afterwards. Each pack's files are copied under tools/ in the data directory when it is added, along with their
+// checksums, and every deployment is recorded by client key so undeploy knows exactly what to clean up after the client restarts
+package toolpacks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("no tool pack with that name")
+	ErrNotDeployed = errors.New("that tool pack is not deployed to this client")
+)
+
+// A leading letter or number keeps names such as . and .. from being taken as directories on the server or client
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+// DefaultTargets is the directory a pack's own directory is made in on clients of each OS, when it was not added with one.
+// Anything not listed uses the "" entry
+var DefaultTargets = map[string]string{
+	"windows": "C:/Windows/Temp",
+	"":        "/tmp",
+}
+
+// File is one file of a pack, Name is its path within the pack with / between directories
+type File struct {
+	Name   string
+	Size   int64
+	SHA256 string
+	Mode   os.FileMode
+}
+
+type Pack struct {
+	Name  string
+	Files []File
+	// The directory the pack goes in on clients, by OS as reported in their version. An OS not in here uses DefaultTargets
+	Targets map[string]string
+
+	Added time.Time
+	By    string
+}
+
+// Deployment is a pack put on a client, with what deploying it wrote
+type Deployment struct {
+	Pack        string
+	Fingerprint string
+	Hostname    string
+	Directory   string
+	// Paths on the client of the files written, which undeploy removes
+	Files []string
+	// Whether deploy made Directory, in which case undeploy removes it too
+	Created bool
+
+	Deployed time.Time
+	By       string
+}
+
+type state struct {
+	Packs       map[string]Pack
+	Deployments []Deployment
+}
+
+var (
+	lck     sync.Mutex
+	dir     string
+	current = state{Packs: map[string]Pack{}}
+)
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	dir = filepath.Join(datadir, "tools")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "tools.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(b, &current); err != nil {
+		return fmt.Errorf("unable to parse tools.json: %s", err)
+	}
+
+	if current.Packs == nil {
+		current.Packs = map[string]Pack{}
+	}
+
+	return nil
+}
+
+func save() error {
+	if dir == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(current, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "tools.json"), b, 0600)
+}
+
+// Target is the directory p is deployed to on a client that runs goos
+func (p Pack) Target(goos string) string {
+	target, ok := p.Targets[goos]
+	if !ok {
+		base, ok := DefaultTargets[goos]
+		if !ok {
+			base = DefaultTargets[""]
+		}
+		target = path.Join(base, p.Name)
+	}
+
+	return target
+}
+
+// Size is the total size of the files in p
+func (p Pack) Size() (n int64) {
+	for _, f := range p.Files {
+		n += f.Size
+	}
+	return
+}
+
+// Add copies the files and directories at sources on the server into a new pack. A directory is added with everything under it,
+// keeping its own name, and anything that is not a regular file, such as a link, is left out
+func Add(name, by string, sources []string, targets map[string]string) (Pack, error) {
+	if !validName.MatchString(name) {
+		return Pack{}, fmt.Errorf("tool pack names are letters, numbers, '.', '_' and '-', starting with a letter or number")
+	}
+
+	if len(sources) == 0 {
+		return Pack{}, fmt.Errorf("a tool pack needs at least one file")
+	}
+
+	for goos, target := range targets {
+		// Given as they would be typed on the client, but sftp takes / on windows as well
+		target = strings.ReplaceAll(target, `\`, "/")
+		if !path.IsAbs(target) && !(len(target) > 2 && target[1] == ':' && target[2] == '/') {
+			return Pack{}, fmt.Errorf("the %s target %q is not an absolute path", goos, targets[goos])
+		}
+		targets[goos] = path.Clean(target)
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, ok := current.Packs[name]; ok {
+		return Pack{}, fmt.Errorf("there is already a tool pack named %s", name)
+	}
+
+	copies := map[string]string{}
+	for _, source := range sources {
+		if err := collect(source, copies); err != nil {
+			return Pack{}, err
+		}
+	}
+
+	staging, err := os.MkdirTemp(dir, "."+name)
+	if err != nil {
+		return Pack{}, err
+	}
+	defer os.RemoveAll(staging)
+
+	p := Pack{Name: name, Targets: targets, Added: time.Now(), By: by}
+	for rel, source := range copies {
+		f, err := store(source, filepath.Join(staging, filepath.FromSlash(rel)))
+		if err != nil {
+			return Pack{}, fmt.Errorf("unable to copy %s: %s", source, err)
+		}
+
+		f.Name = rel
+		p.Files = append(p.Files, f)
+	}
+
+	sort.Slice(p.Files, func(i, j int) bool {
+		return p.Files[i].Name < p.Files[j].Name
+	})
+
+	if err := os.Rename(staging, filepath.Join(dir, name)); err != nil {
+		return Pack{}, err
+	}
+
+	current.Packs[name] = p
+	if err := save(); err != nil {
+		delete(current.Packs, name)
+		os.RemoveAll(filepath.Join(dir, name))
+		return Pack{}, err
+	}
+
+	return p, nil
+}
+
+// collect finds the files under source, by the path they will have in the pack
+func collect(source string, into map[string]string) error {
+	parent := filepath.Dir(filepath.Clean(source))
+
+	return filepath.Walk(source, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(parent, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if existing, ok := into[rel]; ok {
+			return fmt.Errorf("%s and %s would both be %s in the pack", existing, p, rel)
+		}
+		into[rel] = p
+		return nil
+	})
+}
+
+func store(source, destination string) (File, error) {
+	src, err := os.Open(source)
+	if err != nil {
+		return File{}, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return File{}, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destination), 0700); err != nil {
+		return File{}, err
+	}
+
+	dst, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return File{}, err
+	}
+	defer dst.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, h), src)
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{Size: n, SHA256: hex.EncodeToString(h.Sum(nil)), Mode: info.Mode().Perm()}, dst.Close()
+}
+
+// Get returns the pack called name
+func Get(name string) (Pack, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	p, ok := current.Packs[name]
+	if !ok {
+		return Pack{}, ErrNotFound
+	}
+	return p, nil
+}
+
+// List returns every pack sorted by name
+func List() (out []Pack) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, p := range current.Packs {
+		out = append(out, p)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Open opens the server's copy of a file in p, to be sent to a client
+func Open(p Pack, f File) (*os.File, error) {
+	return os.Open(filepath.Join(dir, p.Name, filepath.FromSlash(f.Name)))
+}
+
+// Remove deletes a pack and the server's copies of its files. A pack still deployed somewhere is kept, so undeploy can still
+// clean it up
+func Remove(name string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, ok := current.Packs[name]; !ok {
+		return ErrNotFound
+	}
+
+	deployed := 0
+	for _, d := range current.Deployments {
+		if d.Pack == name {
+			deployed++
+		}
+	}
+	if deployed > 0 {
+		return fmt.Errorf("%s is still deployed to %d clients, undeploy it first", name, deployed)
+	}
+
+	old := current.Packs[name]
+	delete(current.Packs, name)
+	if err := save(); err != nil {
+		current.Packs[name] = old
+		return err
+	}
+
+	return os.RemoveAll(filepath.Join(dir, name))
+}
+
+// Deployments returns where a pack is deployed, or every deployment when pack is empty
+func Deployments(pack string) (out []Deployment) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, d := range current.Deployments {
+		if pack == "" || d.Pack == pack {
+			out = append(out, d)
+		}
+	}
+	return
+}
+
+// Deployed returns the deployment of pack to the client with fingerprint
+func Deployed(fingerprint, pack string) (Deployment, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	for _, d := range current.Deployments {
+		if d.Fingerprint == fingerprint && d.Pack == pack {
+			return d, nil
+		}
+	}
+	return Deployment{}, ErrNotDeployed
+}
+
+// Record keeps d, replacing any earlier deployment of the same pack to the same client. A deployment with no files left to
+// clean up is forgotten
+func Record(d Deployment) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	previous := current.Deployments
+	kept := make([]Deployment, 0, len(previous)+1)
+	for _, existing := range previous {
+		if existing.Fingerprint != d.Fingerprint || existing.Pack != d.Pack {
+			kept = append(kept, existing)
+		}
+	}
+
+	if len(d.Files) > 0 || d.Created {
+		kept = append(kept, d)
+	}
+
+	current.Deployments = kept
+	if err := save(); err != nil {
+		current.Deployments = previous
+		return err
+	}
+
+	return nil
+}
+
+// Rekey moves deployments to a client's new key, as a client whose key is rotated is still the same install
+func Rekey(oldFingerprint, newFingerprint string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	changed := false
+	for i := range current.Deployments {
+		if current.Deployments[i].Fingerprint == oldFingerprint {
+			current.Deployments[i].Fingerprint, changed = newFingerprint, true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return save()
+}
+
+// Directories lists the directories between files and their deployment directory, deepest first, which is the order they
+// can be removed in once the files are gone
+func (d Deployment) Directories() (out []string) {
+	seen := map[string]bool{}
+	for _, f := range d.Files {
+		for p := path.Dir(f); len(p) > len(d.Directory) && strings.HasPrefix(p, d.Directory); p = path.Dir(p) {
+			if !seen[p] {
+				seen[p] = true
+				out = append(out, p)
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Count(out[i], "/") > strings.Count(out[j], "/")
+	})
+
+	if d.Created {
+		out = append(out, d.Directory)
+	}
+	return
+}
@@ -0,0 +1,118 @@
+package toolpacks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestToolPacks(t *testing.T) {
+	datadir := t.TempDir()
+	if err := Start(datadir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { dir, current = "", state{Packs: map[string]Pack{}} }()
+
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "kit", "x64"), 0700)
+	os.WriteFile(filepath.Join(src, "kit", "x64", "tool.exe"), []byte("tool"), 0755)
+	os.WriteFile(filepath.Join(src, "readme.txt"), []byte("read me"), 0644)
+
+	p, err := Add("mimi", "admin", []string{filepath.Join(src, "kit"), filepath.Join(src, "readme.txt")}, map[string]string{"windows": `C:\Tools\mimi`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Files) != 2 || p.Files[0].Name != "kit/x64/tool.exe" || p.Files[1].Name != "readme.txt" || p.Files[0].Mode != 0755 {
+		t.Fatalf("expected the directory and file to keep their names, got %+v", p.Files)
+	}
+
+	if h := sha256.Sum256([]byte("tool")); p.Files[0].SHA256 != hex.EncodeToString(h[:]) {
+		t.Fatalf("expected the sha256 of tool.exe, got %q", p.Files[0].SHA256)
+	}
+
+	if p.Target("windows") != "C:/Tools/mimi" || p.Target("linux") != "/tmp/mimi" || p.Target("freebsd") != "/tmp/mimi" {
+		t.Fatalf("unexpected targets %q %q %q", p.Target("windows"), p.Target("linux"), p.Target("freebsd"))
+	}
+
+	f, err := Open(p, p.Files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(f)
+	f.Close()
+	if string(b) != "read me" {
+		t.Fatalf("expected the server's copy of readme.txt, got %q", b)
+	}
+
+	if _, err := Add("mimi", "admin", []string{filepath.Join(src, "readme.txt")}, nil); err == nil {
+		t.Error("expected a second pack with the same name to be refused")
+	}
+
+	if _, err := Add("../escape", "admin", []string{filepath.Join(src, "readme.txt")}, nil); err == nil {
+		t.Error("expected a name with a path in it to be refused")
+	}
+
+	for _, name := range []string{".", "..", "...", ".hidden", "-rf", "_"} {
+		if _, err := Add(name, "admin", []string{filepath.Join(src, "readme.txt")}, nil); err == nil {
+			t.Errorf("expected the name %q to be refused", name)
+		}
+	}
+
+	if _, err := Add("v1.2_x-64", "admin", []string{filepath.Join(src, "readme.txt")}, nil); err != nil {
+		t.Errorf("expected a name with dots inside it to be allowed, got %s", err)
+	}
+
+	if _, err := Add("relative", "admin", []string{filepath.Join(src, "readme.txt")}, map[string]string{"linux": "tools"}); err == nil {
+		t.Error("expected a relative target to be refused")
+	}
+
+	d := Deployment{Pack: "mimi", Fingerprint: "aa", Directory: "/tmp/mimi", Files: []string{"/tmp/mimi/kit/x64/tool.exe", "/tmp/mimi/readme.txt"}, Created: true}
+	if err := Record(d); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Remove("mimi"); err == nil {
+		t.Error("expected a deployed pack to be kept")
+	}
+
+	if err := Rekey("aa", "bb"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read back as the server would after a restart
+	current = state{}
+	if err := Start(datadir); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Deployed("bb", "mimi")
+	if err != nil {
+		t.Fatalf("expected the deployment to follow the new key: %s", err)
+	}
+
+	if dirs := got.Directories(); !reflect.DeepEqual(dirs, []string{"/tmp/mimi/kit/x64", "/tmp/mimi/kit", "/tmp/mimi"}) {
+		t.Fatalf("expected the directories deepest first, got %q", dirs)
+	}
+
+	got.Files, got.Created = nil, false
+	if err := Record(got); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Deployed("bb", "mimi"); err != ErrNotDeployed {
+		t.Fatalf("expected a deployment with nothing left to be forgotten, got %v", err)
+	}
+
+	if err := Remove("mimi"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(datadir, "tools", "mimi")); !os.IsNotExist(err) {
+		t.Error("expected the server's copies to be removed with the pack")
+	}
+}
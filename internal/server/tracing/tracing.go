@@ -0,0 +1,311 @@
+// Package tracing records spans for connection setup, channels and commands and exports them over OTLP/HTTP as json,
+// which any OpenTelemetry collector accepts without pulling the whole sdk into the server
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	batchSize     = 256
+	flushInterval = 5 * time.Second
+)
+
+var (
+	queue    chan *Span
+	endpoint string
+
+	connLock    sync.Mutex
+	connections = map[ssh.Conn]*Span{}
+)
+
+type Span struct {
+	traceID  string
+	spanID   string
+	parentID string
+
+	name  string
+	start time.Time
+	end   time.Time
+
+	lck        sync.Mutex
+	attributes map[string]string
+	err        error
+}
+
+// Start configures where spans are exported to, an empty collector leaves tracing disabled and every span a no-op.
+// The collector is the base url (e.g http://localhost:4318), spans are posted to its /v1/traces
+func Start(collector string) {
+	if collector == "" {
+		return
+	}
+
+	endpoint = strings.TrimSuffix(collector, "/") + "/v1/traces"
+	queue = make(chan *Span, 4*batchSize)
+
+	go export()
+
+	log.Println("Exporting traces to: ", endpoint)
+}
+
+func Enabled() bool {
+	return queue != nil
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// New starts a span, a nil parent starts a new trace. Spans are safe to use (and do nothing) when tracing is disabled
+func New(name string, parent *Span) *Span {
+	if !Enabled() {
+		return nil
+	}
+
+	s := &Span{
+		spanID:     randomID(8),
+		name:       name,
+		start:      time.Now(),
+		attributes: map[string]string{},
+	}
+
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+
+	return s
+}
+
+func (s *Span) Set(key, value string) *Span {
+	if s == nil {
+		return s
+	}
+
+	s.lck.Lock()
+	s.attributes[key] = value
+	s.lck.Unlock()
+
+	return s
+}
+
+// Error marks the span as failed, nil errors are ignored so the result of a call can be passed straight in
+func (s *Span) Error(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.lck.Lock()
+	s.err = err
+	s.lck.Unlock()
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.lck.Lock()
+	if !s.end.IsZero() {
+		s.lck.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.lck.Unlock()
+
+	select {
+	case queue <- s:
+	default:
+		// Never block a connection on a slow collector
+	}
+}
+
+// Bind associates a span with a connection so work done over that connection (channels, commands) is traced under it
+func Bind(conn ssh.Conn, s *Span) {
+	if s == nil {
+		return
+	}
+
+	connLock.Lock()
+	connections[conn] = s
+	connLock.Unlock()
+}
+
+func Unbind(conn ssh.Conn) {
+	connLock.Lock()
+	delete(connections, conn)
+	connLock.Unlock()
+}
+
+func Of(conn ssh.Conn) *Span {
+	connLock.Lock()
+	defer connLock.Unlock()
+
+	return connections[conn]
+}
+
+// Channels wraps channel handlers so each opened channel gets a span under the connection's span
+func Channels(conn ssh.Conn, handlers map[string]internal.ChannelHandler) map[string]internal.ChannelHandler {
+	if !Enabled() {
+		return handlers
+	}
+
+	wrapped := map[string]internal.ChannelHandler{}
+	for channelType, handler := range handlers {
+		channelType, handler := channelType, handler
+		wrapped[channelType] = func(user *internal.User, newChannel ssh.NewChannel, l logger.Logger) {
+			span := New("ssh.channel "+channelType, Of(conn)).Set("ssh.channel.type", channelType)
+			defer span.End()
+
+			handler(user, newChannel, l)
+		}
+	}
+
+	return wrapped
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func attributes(m map[string]string) []otlpAttribute {
+	var out []otlpAttribute
+	for k, v := range m {
+		out = append(out, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	return out
+}
+
+func encode(spans []*Span) ([]byte, error) {
+	var converted []otlpSpan
+	for _, s := range spans {
+		s.lck.Lock()
+		o := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        attributes(s.attributes),
+			Status:            otlpStatus{Code: 1}, // STATUS_CODE_OK
+		}
+		if s.parentID == "" {
+			o.Kind = 2 // SPAN_KIND_SERVER, the root of a trace is always an incoming connection
+		}
+		if s.err != nil {
+			o.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		s.lck.Unlock()
+
+		converted = append(converted, o)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": attributes(map[string]string{
+						"service.name":    "reverse_ssh",
+						"service.version": internal.Version,
+					}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/NHAS/reverse_ssh"},
+						"spans": converted,
+					},
+				},
+			},
+		},
+	})
+}
+
+func send(spans []*Span) error {
+	body, err := encode(spans)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+func export() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := send(batch); err != nil {
+			log.Printf("Unable to export %d spans: %s", len(batch), err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case s := <-queue:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
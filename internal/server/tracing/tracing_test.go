@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestDisabledSpansAreNoops(t *testing.T) {
+	if Enabled() {
+		t.Skip("tracing already started")
+	}
+
+	span := New("nothing", nil)
+	span.Set("key", "value").Error(errors.New("ignored"))
+	span.End()
+
+	if span != nil {
+		t.Fatal("disabled tracing should not create spans")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	Start("http://127.0.0.1:0/")
+
+	root := New("ssh.connection", nil).Set("ssh.user", "test")
+	child := New("command ls", root)
+	child.Error(errors.New("failed"))
+	child.End()
+	root.End()
+
+	b, err := encode([]*Span{root, child})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan
+			}
+		}
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := decoded.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans got %d", len(spans))
+	}
+
+	if len(spans[0].TraceID) != 32 || len(spans[0].SpanID) != 16 || spans[0].Kind != 2 {
+		t.Fatalf("bad root span %+v", spans[0])
+	}
+
+	if spans[1].TraceID != spans[0].TraceID || spans[1].ParentSpanID != spans[0].SpanID || spans[1].Kind != 1 {
+		t.Fatalf("child span not linked to root %+v", spans[1])
+	}
+
+	if spans[1].Status.Code != 2 || spans[1].Status.Message != "failed" {
+		t.Fatalf("child span error not recorded %+v", spans[1].Status)
+	}
+
+	if len(spans[0].Attributes) != 1 || spans[0].Attributes[0].Key != "ssh.user" {
+		t.Fatalf("root span attributes wrong %+v", spans[0].Attributes)
+	}
+}
@@ -0,0 +1,295 @@
+// Package vault keeps operator secrets encrypted at rest in the data directory
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	ErrNotFound = errors.New("no secret with that name")
+	ErrNotReady = errors.New("vault has not been started")
+	ErrDenied   = errors.New("your role is not allowed to use that secret")
+)
+
+// References to secrets in commands look like vault:name or vault:engagement/name
+var referencePattern = regexp.MustCompile(`vault:([A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)?)`)
+
+var (
+	lck     sync.Mutex
+	datadir string
+)
+
+type sealed struct {
+	Nonce      []byte
+	Ciphertext []byte
+
+	// Roles other than admin allowed to use this secret
+	Roles []string `json:",omitempty"`
+}
+
+type Entry struct {
+	Name  string
+	Roles []string
+}
+
+// Secrets are scoped to an engagement by prefixing the name with it, e.g client-a/db
+func ValidName(name string) bool {
+	return referencePattern.FindString("vault:"+name) == "vault:"+name
+}
+
+func allowed(role string, entry sealed) bool {
+	if role == internal.RoleAdmin {
+		return true
+	}
+
+	for _, r := range entry.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type store struct {
+	// Only used when the key is derived from RSSH_VAULT_PASSPHRASE
+	Salt    []byte `json:",omitempty"`
+	Secrets map[string]sealed
+}
+
+func Start(dir string) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	datadir = dir
+}
+
+func load() (*store, error) {
+	if datadir == "" {
+		return nil, ErrNotReady
+	}
+
+	s := &store{Secrets: make(map[string]sealed)}
+
+	b, err := os.ReadFile(filepath.Join(datadir, "vault.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, fmt.Errorf("vault file is corrupt: %s", err)
+	}
+
+	if s.Secrets == nil {
+		s.Secrets = make(map[string]sealed)
+	}
+
+	return s, nil
+}
+
+func (s *store) save() error {
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(datadir, "vault.json"), b, 0600)
+}
+
+// A passphrase in the environment keeps the key off disk entirely, otherwise a random key is kept beside the vault
+func (s *store) key() ([]byte, error) {
+	if passphrase := os.Getenv("RSSH_VAULT_PASSPHRASE"); passphrase != "" {
+		if s.Salt == nil {
+			s.Salt = make([]byte, 16)
+			if _, err := rand.Read(s.Salt); err != nil {
+				return nil, err
+			}
+		}
+
+		return scrypt.Key([]byte(passphrase), s.Salt, 1<<15, 8, 1, 32)
+	}
+
+	keyPath := filepath.Join(datadir, "vault.key")
+
+	key, err := os.ReadFile(keyPath)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, errors.New("vault.key is not 32 bytes")
+		}
+		return key, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return key, os.WriteFile(keyPath, key, 0600)
+}
+
+func (s *store) aead() (cipher.AEAD, error) {
+	key, err := s.key()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func Set(name string, secret []byte, roles []string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("invalid secret name %q, use letters, numbers, _ . - and optionally one / for the engagement", name)
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	s, err := load()
+	if err != nil {
+		return err
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	// The name is bound in so ciphertexts cant be shuffled between entries
+	s.Secrets[name] = sealed{Nonce: nonce, Ciphertext: aead.Seal(nil, nonce, secret, []byte(name)), Roles: roles}
+
+	return s.save()
+}
+
+// Get decrypts a secret for user, every attempt is audited whether or not it succeeds
+func Get(user, role, name string) ([]byte, error) {
+	secret, err := get(role, name)
+	if err != nil {
+		audit.Log(user, "vault-access", name, "denied: "+err.Error())
+		return nil, err
+	}
+
+	audit.Log(user, "vault-access", name, "")
+	return secret, nil
+}
+
+func get(role, name string) ([]byte, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	s, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := s.Secrets[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if !allowed(role, entry) {
+		return nil, ErrDenied
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := aead.Open(nil, entry.Nonce, entry.Ciphertext, []byte(name))
+	if err != nil {
+		return nil, errors.New("unable to decrypt secret, wrong key or passphrase")
+	}
+
+	return secret, nil
+}
+
+// Resolve replaces vault:name references in text with the secrets they name
+func Resolve(user, role, text string) (string, error) {
+	var resolveErr error
+	resolved := referencePattern.ReplaceAllStringFunc(text, func(ref string) string {
+		if resolveErr != nil {
+			return ref
+		}
+
+		secret, err := Get(user, role, ref[len("vault:"):])
+		if err != nil {
+			resolveErr = fmt.Errorf("%s: %s", ref, err)
+			return ref
+		}
+		return string(secret)
+	})
+
+	return resolved, resolveErr
+}
+
+func Delete(name string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	s, err := load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.Secrets[name]; !ok {
+		return ErrNotFound
+	}
+
+	delete(s.Secrets, name)
+
+	return s.save()
+}
+
+// Entries lists the secrets role can use, values are not included
+func Entries(role string) ([]Entry, error) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	s, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for name, entry := range s.Secrets {
+		if allowed(role, entry) {
+			entries = append(entries, Entry{Name: name, Roles: entry.Roles})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	return entries, nil
+}
@@ -0,0 +1,312 @@
+// Package watchdog closes connections that have stopped making progress. A handler left waiting to accept a channel, or writing
+// to a peer that has stopped reading, would otherwise hold its connection and everything registered for it until the server
+// restarts, as keepalives only notice a peer that has gone away and are off when --timeout is 0
+package watchdog
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
+	"golang.org/x/crypto/ssh"
+)
+
+const DefaultDeadline = 5 * time.Minute
+
+// Grace is how long a closed connection has to clean up after itself before the watchdog releases it instead
+const Grace = 10 * time.Second
+
+var (
+	lck      sync.Mutex
+	deadline = DefaultDeadline
+	closed   int
+)
+
+// SetDeadline sets how long a channel accept or write can be stuck for before its connection is closed, 0 turns the watchdog off
+func SetDeadline(d time.Duration) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	deadline = d
+}
+
+func Deadline() time.Duration {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return deadline
+}
+
+// Closed is how many connections the watchdog has closed since the server started
+func Closed() int {
+	lck.Lock()
+	defer lck.Unlock()
+
+	return closed
+}
+
+// Conn is a net.Conn that keeps when the write it is in started, so a Supervisor can tell when one is stuck
+type Conn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	writing time.Time
+}
+
+func Wrap(c net.Conn) *Conn {
+	return &Conn{Conn: c}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.writing = time.Now()
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.writing = time.Time{}
+		c.mu.Unlock()
+	}()
+
+	return c.Conn.Write(b)
+}
+
+// Supervisor watches one connection, closing it when a write or channel accept on it is stuck for longer than the deadline
+type Supervisor struct {
+	conn *Conn
+	ssh  ssh.Conn
+	// Who is on the other end, for the log and alert
+	what string
+
+	mu      sync.Mutex
+	accepts map[*channel]time.Time
+	// When each write to an accepted channel that has not returned started
+	writes    map[int]time.Time
+	nextWrite int
+	releases  []*release
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// Supervise starts watching sshConn, which must have been made over c, until it closes
+func (c *Conn) Supervise(sshConn ssh.Conn, what string) *Supervisor {
+	s := &Supervisor{
+		conn:    c,
+		ssh:     sshConn,
+		what:    what,
+		accepts: map[*channel]time.Time{},
+		writes:  map[int]time.Time{},
+		stop:    make(chan struct{}),
+	}
+
+	go func() {
+		sshConn.Wait()
+		s.stopOnce.Do(func() { close(s.stop) })
+	}()
+
+	go s.watch()
+
+	return s
+}
+
+func (s *Supervisor) watch() {
+	// A connection is closed within a quarter of the deadline of it becoming stuck, however often the deadline is changed
+	for {
+		every := Deadline() / 4
+		if every <= 0 || every > time.Minute {
+			every = time.Minute
+		}
+		if every < time.Second {
+			every = time.Second
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(every):
+		}
+
+		if reason := s.stuck(time.Now(), Deadline()); reason != "" {
+			s.kill(reason)
+			return
+		}
+	}
+}
+
+// stuck describes what on the connection has been stuck since before deadline ago, or is empty if nothing is
+func (s *Supervisor) stuck(now time.Time, deadline time.Duration) string {
+	if deadline <= 0 {
+		return ""
+	}
+
+	s.conn.mu.Lock()
+	writing := s.conn.writing
+	s.conn.mu.Unlock()
+
+	if !writing.IsZero() && now.Sub(writing) > deadline {
+		return fmt.Sprintf("a write has been blocked for %s", now.Sub(writing).Round(time.Second))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, since := range s.writes {
+		if now.Sub(since) > deadline {
+			return fmt.Sprintf("a write to a channel has waited %s for the peer to read", now.Sub(since).Round(time.Second))
+		}
+	}
+
+	for c, since := range s.accepts {
+		if now.Sub(since) > deadline {
+			return fmt.Sprintf("a %s channel has waited %s to be accepted", c.ChannelType(), now.Sub(since).Round(time.Second))
+		}
+	}
+
+	return ""
+}
+
+// kill closes the connection, then releases what was registered for it if its own clean up has not done so by the end of Grace
+func (s *Supervisor) kill(reason string) {
+	lck.Lock()
+	closed++
+	lck.Unlock()
+
+	message := fmt.Sprintf("Closed %s (%s) as %s", s.what, s.ssh.RemoteAddr(), reason)
+	log.Println("Watchdog:", message)
+
+	observers.Alerts.Notify(observers.Alert{
+		Kind:      "watchdog",
+		Message:   message,
+		Timestamp: time.Now(),
+		Sequence:  sequence.Next(),
+	})
+
+	// The net.Conn first, a write stuck on it would stop the ssh connection closing
+	s.conn.Conn.Close()
+	s.ssh.Close()
+
+	time.AfterFunc(Grace, func() {
+		s.mu.Lock()
+		releases := s.releases
+		s.mu.Unlock()
+
+		for _, r := range releases {
+			r.run()
+		}
+	})
+}
+
+type release struct {
+	once sync.Once
+	f    func()
+}
+
+func (r *release) run() {
+	r.once.Do(r.f)
+}
+
+// Release registers f as the clean up for what is kept for the connection, returning the function the connection's own clean up
+// should call in its place. f is run once, by that or by the watchdog when the connection it closed does not clean up in time
+func (s *Supervisor) Release(f func()) func() {
+	r := &release{f: f}
+
+	s.mu.Lock()
+	s.releases = append(s.releases, r)
+	s.mu.Unlock()
+
+	return r.run
+}
+
+// Channels passes on chans, keeping each channel until it is accepted or rejected so one that is never can be found
+func (s *Supervisor) Channels(chans <-chan ssh.NewChannel) <-chan ssh.NewChannel {
+	out := make(chan ssh.NewChannel)
+
+	go func() {
+		defer close(out)
+
+		for newChannel := range chans {
+			c := &channel{NewChannel: newChannel, s: s}
+
+			s.mu.Lock()
+			s.accepts[c] = time.Now()
+			s.mu.Unlock()
+
+			out <- c
+		}
+	}()
+
+	return out
+}
+
+type channel struct {
+	ssh.NewChannel
+	s *Supervisor
+}
+
+func (c *channel) done() {
+	c.s.mu.Lock()
+	delete(c.s.accepts, c)
+	c.s.mu.Unlock()
+}
+
+// Accept passes on the channel with its writes timed. A peer that stops reading leaves them waiting for it to open the channel
+// window, without the connection itself ever blocking
+func (c *channel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
+	defer c.done()
+
+	accepted, requests, err := c.NewChannel.Accept()
+	if err != nil {
+		return accepted, requests, err
+	}
+	return &timedChannel{Channel: accepted, s: c.s}, requests, nil
+}
+
+func (c *channel) Reject(reason ssh.RejectionReason, message string) error {
+	defer c.done()
+	return c.NewChannel.Reject(reason, message)
+}
+
+// writing keeps that a write started now, returning the function to call once it returns
+func (s *Supervisor) writing() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.nextWrite
+	s.nextWrite++
+	s.writes[n] = time.Now()
+
+	return func() {
+		s.mu.Lock()
+		delete(s.writes, n)
+		s.mu.Unlock()
+	}
+}
+
+type timedChannel struct {
+	ssh.Channel
+	s *Supervisor
+}
+
+func (t *timedChannel) Write(b []byte) (int, error) {
+	defer t.s.writing()()
+	return t.Channel.Write(b)
+}
+
+func (t *timedChannel) Stderr() io.ReadWriter {
+	return timedStderr{ReadWriter: t.Channel.Stderr(), s: t.s}
+}
+
+type timedStderr struct {
+	io.ReadWriter
+	s *Supervisor
+}
+
+func (t timedStderr) Write(b []byte) (int, error) {
+	defer t.s.writing()()
+	return t.ReadWriter.Write(b)
+}
@@ -0,0 +1,153 @@
+package watchdog
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type pendingChannel struct {
+	rejected bool
+}
+
+func (p *pendingChannel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
+	return &blockedChannel{release: make(chan struct{})}, nil, nil
+}
+
+func (p *pendingChannel) Reject(reason ssh.RejectionReason, message string) error {
+	p.rejected = true
+	return nil
+}
+
+func (p *pendingChannel) ChannelType() string { return "session" }
+
+func (p *pendingChannel) ExtraData() []byte { return nil }
+
+// blockedChannel is a channel whose peer never reads, so writes wait until released
+type blockedChannel struct {
+	ssh.Channel
+	release chan struct{}
+}
+
+func (b *blockedChannel) Write(p []byte) (int, error) {
+	<-b.release
+	return len(p), nil
+}
+
+func TestStuckWrite(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	s := &Supervisor{conn: Wrap(local), accepts: map[*channel]time.Time{}, writes: map[int]time.Time{}}
+
+	// Nothing reads from remote, so the write blocks until the pipe is closed
+	go s.conn.Write([]byte("stuck"))
+	defer local.Close()
+
+	for i := 0; ; i++ {
+		s.conn.mu.Lock()
+		writing := !s.conn.writing.IsZero()
+		s.conn.mu.Unlock()
+
+		if writing {
+			break
+		}
+		if i == 100 {
+			t.Fatal("write never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if reason := s.stuck(time.Now(), time.Minute); reason != "" {
+		t.Fatalf("expected a write that has only just started to be left alone, got %q", reason)
+	}
+
+	if reason := s.stuck(time.Now().Add(2*time.Minute), time.Minute); !strings.Contains(reason, "write has been blocked") {
+		t.Fatalf("expected the write to be stuck, got %q", reason)
+	}
+
+	if reason := s.stuck(time.Now().Add(2*time.Minute), 0); reason != "" {
+		t.Fatalf("expected nothing to be stuck with the watchdog off, got %q", reason)
+	}
+}
+
+func TestStuckAccept(t *testing.T) {
+	s := &Supervisor{conn: Wrap(nil), accepts: map[*channel]time.Time{}, writes: map[int]time.Time{}}
+
+	in := make(chan ssh.NewChannel, 2)
+	pending := &pendingChannel{}
+	in <- pending
+	in <- &pendingChannel{}
+	close(in)
+	out := s.Channels(in)
+
+	c := <-out
+
+	later := time.Now().Add(2 * time.Minute)
+	if reason := s.stuck(later, time.Minute); !strings.Contains(reason, "session channel has waited") {
+		t.Fatalf("expected the channel to be waiting, got %q", reason)
+	}
+
+	c.Reject(ssh.Prohibited, "no")
+	if !pending.rejected {
+		t.Fatal("expected the reject to be passed on")
+	}
+
+	accepted := <-out
+	ch, _, _ := accepted.Accept()
+
+	if reason := s.stuck(later, time.Minute); reason != "" {
+		t.Fatalf("expected nothing stuck once the channels were answered, got %q", reason)
+	}
+
+	written := make(chan struct{})
+	go func() {
+		ch.Write([]byte("stuck"))
+		close(written)
+	}()
+
+	for i := 0; ; i++ {
+		s.mu.Lock()
+		writing := len(s.writes) > 0
+		s.mu.Unlock()
+
+		if writing {
+			break
+		}
+		if i == 100 {
+			t.Fatal("write never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if reason := s.stuck(time.Now().Add(2*time.Minute), time.Minute); !strings.Contains(reason, "peer to read") {
+		t.Fatalf("expected the channel write to be stuck, got %q", reason)
+	}
+
+	close(ch.(*timedChannel).Channel.(*blockedChannel).release)
+	<-written
+
+	if reason := s.stuck(time.Now().Add(2*time.Minute), time.Minute); reason != "" {
+		t.Fatalf("expected nothing stuck once the write returned, got %q", reason)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	s := &Supervisor{}
+
+	runs := 0
+	release := s.Release(func() { runs++ })
+
+	release()
+	for _, r := range s.releases {
+		r.run()
+	}
+	release()
+
+	if runs != 1 {
+		t.Fatalf("expected the release to run once, ran %d times", runs)
+	}
+}
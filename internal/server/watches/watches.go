@@ -0,0 +1,342 @@
+// Package watches keeps rules about the connected clients, such as count(tag=dc) < 1, that are checked every so often. When
+// whether a rule holds changes an alert is raised, so operators hear about a foothold dropping without having to look
+package watches
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/audit"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/internal/server/sequence"
+	"github.com/NHAS/reverse_ssh/pkg/command"
+)
+
+const (
+	// How often rules are checked unless they ask otherwise
+	DefaultEvery = time.Minute
+	// Rules are not checked any faster than this, the registry is locked while clients are counted
+	MinimumEvery = 10 * time.Second
+)
+
+var comparisons = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+var (
+	lck   sync.Mutex
+	path  string
+	rules = map[string]*Rule{}
+
+	checking sync.Once
+
+	// count is how many connected clients a target names, swapped out by tests
+	count = func(target string) (int, error) {
+		found, err := clients.Resolve(target)
+		if command.CategoryOf(err) == command.NotFound {
+			return 0, nil
+		}
+		return len(found), err
+	}
+)
+
+type Rule struct {
+	ID         string
+	Expression string
+	// Only webhooks whose url contains this are sent the alert, every webhook is when it is empty
+	Notify string        `json:",omitempty"`
+	Every  time.Duration `json:",omitempty"`
+
+	Creator string
+	Created time.Time
+
+	// Whether the rule held when it was last checked, and when that last changed
+	Holds   bool
+	Checked time.Time `json:",omitempty"`
+	Changed time.Time `json:",omitempty"`
+	// Why the rule could not be checked last time, such as a tag expression that no longer parses
+	Failing string `json:",omitempty"`
+}
+
+// expression is a rule taken apart, count(target) comparison limit
+type expression struct {
+	target     string
+	comparison string
+	limit      int
+}
+
+func parse(rule string) (expression, error) {
+	rule = strings.TrimSpace(rule)
+
+	if !strings.HasPrefix(rule, "count(") {
+		return expression{}, fmt.Errorf("expected count(<target>) <comparison> <number>, got %q", rule)
+	}
+
+	end := strings.LastIndexByte(rule, ')')
+	if end == -1 {
+		return expression{}, fmt.Errorf("count( in %q is never closed", rule)
+	}
+
+	e := expression{target: strings.TrimSpace(rule[len("count("):end])}
+	if e.target == "" {
+		return expression{}, fmt.Errorf("count() in %q needs a target, such as * or tag=dc", rule)
+	}
+
+	rest := strings.TrimSpace(rule[end+1:])
+	for _, c := range comparisons {
+		if strings.HasPrefix(rest, c) {
+			e.comparison = c
+			break
+		}
+	}
+
+	if e.comparison == "" {
+		return expression{}, fmt.Errorf("expected one of %s after count(%s)", strings.Join(comparisons, " "), e.target)
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(rest[len(e.comparison):]))
+	if err != nil || limit < 0 {
+		return expression{}, fmt.Errorf("expected a number to compare the count of %s with", e.target)
+	}
+	e.limit = limit
+
+	return e, nil
+}
+
+func (e expression) holds(n int) bool {
+	switch e.comparison {
+	case "<":
+		return n < e.limit
+	case "<=":
+		return n <= e.limit
+	case ">":
+		return n > e.limit
+	case ">=":
+		return n >= e.limit
+	case "==":
+		return n == e.limit
+	default:
+		return n != e.limit
+	}
+}
+
+// check counts the clients of a rule, returning whether it holds
+func (e expression) check() (bool, int, error) {
+	n, err := count(e.target)
+	if err != nil {
+		return false, 0, err
+	}
+	return e.holds(n), n, nil
+}
+
+func Start(datadir string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	path = filepath.Join(datadir, "watches.json")
+
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(b, &rules); err != nil {
+			return fmt.Errorf("unable to parse watches.json: %s", err)
+		}
+	}
+
+	checking.Do(func() {
+		go func() {
+			for range time.NewTicker(MinimumEvery / 2).C {
+				Check(time.Now())
+			}
+		}()
+	})
+
+	return nil
+}
+
+func save() error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(rules, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Add starts watching a rule, checking it straight away so the rule starts out holding or not without raising an alert
+func Add(actor, rule, notify string, every time.Duration) (Rule, error) {
+	e, err := parse(rule)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	if every == 0 {
+		every = DefaultEvery
+	}
+
+	if every < MinimumEvery {
+		return Rule{}, fmt.Errorf("rules are checked at most every %s", MinimumEvery)
+	}
+
+	holds, _, err := e.check()
+	if err != nil {
+		return Rule{}, err
+	}
+
+	now := time.Now()
+	id := sha256.Sum256([]byte(rule + notify + now.String()))
+
+	r := Rule{
+		ID:         hex.EncodeToString(id[:])[:8],
+		Expression: rule,
+		Notify:     notify,
+		Every:      every,
+		Creator:    actor,
+		Created:    now,
+		Holds:      holds,
+		Checked:    now,
+		Changed:    now,
+	}
+
+	lck.Lock()
+	defer lck.Unlock()
+
+	rules[r.ID] = &r
+	if err := save(); err != nil {
+		delete(rules, r.ID)
+		return Rule{}, err
+	}
+
+	audit.Log(actor, "watch-add", r.ID, rule)
+
+	return r, nil
+}
+
+func Delete(actor, id string) error {
+	lck.Lock()
+	defer lck.Unlock()
+
+	if _, ok := rules[id]; !ok {
+		return fmt.Errorf("watch rule %q not found", id)
+	}
+
+	delete(rules, id)
+	audit.Log(actor, "watch-delete", id, "")
+
+	return save()
+}
+
+func List() []Rule {
+	lck.Lock()
+	defer lck.Unlock()
+
+	out := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		out = append(out, *r)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.Before(out[j].Created)
+	})
+
+	return out
+}
+
+// Check checks every rule that is due by now, raising an alert for each that has started or stopped holding
+func Check(now time.Time) {
+	lck.Lock()
+	var due []Rule
+	for _, r := range rules {
+		every := r.Every
+		if every == 0 {
+			every = DefaultEvery
+		}
+
+		if now.Sub(r.Checked) >= every {
+			due = append(due, *r)
+		}
+	}
+	lck.Unlock()
+
+	// Counted without the lock, as counting takes the lock of the registry
+	var (
+		alerts  []observers.Alert
+		changed []string
+	)
+	for i := range due {
+		r := &due[i]
+		r.Checked = now
+
+		e, err := parse(r.Expression)
+		var (
+			holds bool
+			n     int
+		)
+		if err == nil {
+			holds, n, err = e.check()
+		}
+
+		if err != nil {
+			r.Failing = err.Error()
+			continue
+		}
+		r.Failing = ""
+
+		if holds == r.Holds {
+			continue
+		}
+		r.Holds, r.Changed = holds, now
+
+		state := "no longer holds"
+		if holds {
+			state = "now holds"
+		}
+
+		changed = append(changed, r.ID)
+		alerts = append(alerts, observers.Alert{
+			Kind:      "watch",
+			Message:   fmt.Sprintf("%s %s, %d clients matched %s (rule %s)", r.Expression, state, n, e.target, r.ID),
+			Timestamp: now,
+			Sequence:  sequence.Next(),
+			Notify:    r.Notify,
+		})
+	}
+
+	lck.Lock()
+	for _, r := range due {
+		// Rules deleted while they were being checked stay deleted
+		if _, ok := rules[r.ID]; ok {
+			r := r
+			rules[r.ID] = &r
+		}
+	}
+
+	if len(due) > 0 {
+		if err := save(); err != nil {
+			log.Printf("Unable to save watch rules: %s", err)
+		}
+	}
+	lck.Unlock()
+
+	for i, a := range alerts {
+		log.Printf("[WARNING] watch: %s", a.Message)
+		audit.Log("server", "watch-changed", changed[i], a.Message)
+		observers.Alerts.Notify(a)
+	}
+}
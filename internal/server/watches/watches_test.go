@@ -0,0 +1,94 @@
+package watches
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/pkg/observer"
+)
+
+func TestParse(t *testing.T) {
+	for rule, expected := range map[string]expression{
+		"count(os=windows) < 5":  {"os=windows", "<", 5},
+		" count( tag=dc ) <= 0 ": {"tag=dc", "<=", 0},
+		"count(web(1)) != 1":     {"web(1)", "!=", 1},
+		"count(*)>=10":           {"*", ">=", 10},
+		"count(hostname=db*)==2": {"hostname=db*", "==", 2},
+	} {
+		e, err := parse(rule)
+		if err != nil || e != expected {
+			t.Errorf("expected %q to parse as %+v, got %+v: %v", rule, expected, e, err)
+		}
+	}
+
+	for _, bad := range []string{"", "os=windows < 5", "count() < 5", "count(x < 5", "count(x) 5", "count(x) < five", "count(x) < -1"} {
+		if _, err := parse(bad); err == nil {
+			t.Errorf("expected %q to be refused", bad)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	n := 6
+	count = func(target string) (int, error) {
+		return n, nil
+	}
+
+	alerts := make(chan observers.Alert, 10)
+	id := observers.Alerts.Register(func(m observer.Message) {
+		alerts <- m.(observers.Alert)
+	})
+	defer observers.Alerts.Deregister(id)
+
+	r, err := Add("alice", "count(os=windows) < 5", "slack", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Delete("alice", r.ID)
+
+	if r.Holds || r.Every != DefaultEvery {
+		t.Errorf("unexpected rule: %+v", r)
+	}
+
+	if _, err := Add("alice", "count(*) > 1", "", time.Second); err == nil {
+		t.Error("expected a rule checked too often to be refused")
+	}
+
+	start := time.Now()
+
+	n = 4
+	Check(start.Add(time.Second))
+	if List()[0].Holds {
+		t.Error("expected the rule not to be checked before it was due")
+	}
+
+	Check(start.Add(DefaultEvery))
+	select {
+	case a := <-alerts:
+		if a.Kind != "watch" || a.Notify != "slack" {
+			t.Errorf("unexpected alert: %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an alert when the rule started holding")
+	}
+
+	Check(start.Add(2 * DefaultEvery))
+	n = 7
+	Check(start.Add(3 * DefaultEvery))
+
+	select {
+	case a := <-alerts:
+		if a.Kind != "watch" {
+			t.Errorf("unexpected alert: %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an alert when the rule stopped holding")
+	}
+
+	select {
+	case a := <-alerts:
+		t.Errorf("expected only an alert for each change, also got %+v", a)
+	default:
+	}
+}
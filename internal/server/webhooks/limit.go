@@ -0,0 +1,263 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/pkg/observer"
+)
+
+var (
+	// Burst is how many messages each webhook is sent in a Window, past it messages are held back and summed up in one Digest
+	// sent once the window is over. Hundreds of clients reconnecting after a restart are then a handful of posts, not hundreds
+	Burst  = 10
+	Window = time.Minute
+)
+
+// How many posts can wait for a webhook that is slow to answer, messages past it are counted in the digest instead
+const queueLength = 32
+
+// Digest sums up the messages a webhook was not sent in a window
+type Digest struct {
+	Kind         string
+	Since, Until time.Time
+
+	Connected, Disconnected int
+	// How many alerts of each kind were held back
+	Alerts map[string]int `json:",omitempty"`
+	// The summaries of the first few messages held back
+	Examples []string `json:",omitempty"`
+}
+
+const maxExamples = 5
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, one)
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+func (d Digest) Summary() string {
+	var parts []string
+	if d.Connected > 0 {
+		parts = append(parts, plural(d.Connected, "client connected", "clients connected"))
+	}
+
+	if d.Disconnected > 0 {
+		parts = append(parts, plural(d.Disconnected, "client disconnected", "clients disconnected"))
+	}
+
+	kinds := make([]string, 0, len(d.Alerts))
+	for kind := range d.Alerts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		parts = append(parts, plural(d.Alerts[kind], kind+" alert", kind+" alerts"))
+	}
+
+	summary := strings.Join(parts, ", ")
+	if len(parts) > 1 {
+		summary = strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+	}
+
+	period := d.Until.Sub(d.Since).Round(time.Second).String()
+	if period == "1m0s" {
+		period = "minute"
+	}
+
+	return fmt.Sprintf("%s in the last %s", summary, period)
+}
+
+func (d Digest) Json() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+// target is where one webhook is in its window, and what it has been held back from
+type target struct {
+	url   string
+	queue chan []byte
+
+	sent   int
+	digest *Digest
+}
+
+var (
+	targetsLock sync.Mutex
+	targets     = map[string]*target{}
+	since       = time.Now()
+)
+
+// payload is what a webhook is posted, the message as json for programs and its summary as text for chat services
+func payload(msg observer.Message) ([]byte, error) {
+	fullBytes, err := msg.Json()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapper := struct {
+		Full string
+		Text string `json:"text"`
+	}{
+		Full: string(fullBytes),
+		Text: msg.Summary(),
+	}
+
+	return json.Marshal(wrapper)
+}
+
+// deliver queues a message for every webhook it is meant for that has not had its Burst this window, and holds it back from the rest
+func deliver(msg observer.Message) {
+	data, err := payload(msg)
+	if err != nil {
+		log.Println("Bad webhook message: ", err)
+		return
+	}
+
+	only := ""
+	if a, ok := msg.(observers.Alert); ok {
+		only = a.Notify
+	}
+
+	var urls []string
+	m.RLock()
+	for r := range recipients {
+		if strings.Contains(r, only) {
+			urls = append(urls, r)
+		}
+	}
+	m.RUnlock()
+
+	targetsLock.Lock()
+	defer targetsLock.Unlock()
+
+	for _, r := range urls {
+		t, ok := targets[r]
+		if !ok {
+			t = &target{url: r, queue: make(chan []byte, queueLength)}
+			targets[r] = t
+			go t.work()
+		}
+
+		if t.sent < Burst {
+			select {
+			case t.queue <- data:
+				t.sent++
+				continue
+			default:
+			}
+		}
+
+		t.hold(msg)
+	}
+}
+
+func (t *target) hold(msg observer.Message) {
+	if t.digest == nil {
+		t.digest = &Digest{Kind: "digest", Alerts: map[string]int{}}
+	}
+
+	switch msg := msg.(type) {
+	case observers.ClientState:
+		if msg.Status == "disconnected" {
+			t.digest.Disconnected++
+		} else {
+			t.digest.Connected++
+		}
+	case observers.Alert:
+		t.digest.Alerts[msg.Kind]++
+	}
+
+	if len(t.digest.Examples) < maxExamples {
+		t.digest.Examples = append(t.digest.Examples, msg.Summary())
+	}
+}
+
+// flush starts a new window, sending each webhook the digest of what it was held back from in the one that ended
+func flush(now time.Time) {
+	targetsLock.Lock()
+	defer targetsLock.Unlock()
+
+	for r, t := range targets {
+		m.RLock()
+		_, stillThere := recipients[r]
+		m.RUnlock()
+
+		if !stillThere {
+			close(t.queue)
+			delete(targets, r)
+			continue
+		}
+
+		t.sent = 0
+		if t.digest == nil {
+			continue
+		}
+
+		t.digest.Since, t.digest.Until = since, now
+		if len(t.digest.Alerts) == 0 {
+			t.digest.Alerts = nil
+		}
+
+		data, err := payload(*t.digest)
+		t.digest = nil
+		if err != nil {
+			continue
+		}
+
+		select {
+		case t.queue <- data:
+			t.sent++
+		default:
+			log.Printf("Webhook '%s' is too far behind to be sent its digest\n", r)
+		}
+	}
+
+	since = now
+}
+
+// work posts what is queued for a webhook one at a time, so a webhook that is slow to answer only holds up itself
+func (t *target) work() {
+	var (
+		client   *http.Client
+		checking bool
+	)
+
+	for data := range t.queue {
+		m.RLock()
+		checkTLS := recipients[t.url]
+		m.RUnlock()
+
+		if client == nil || checkTLS != checking {
+			client = &http.Client{
+				Timeout: 2 * time.Second,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: !checkTLS},
+				},
+			}
+			checking = checkTLS
+		}
+
+		resp, err := client.Post(t.url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Printf("Error sending webhook '%s': %s\n", t.url, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	if client != nil {
+		client.CloseIdleConnections()
+	}
+}
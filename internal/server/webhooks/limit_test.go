@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+)
+
+func TestDigest(t *testing.T) {
+	d := Digest{Connected: 312, Disconnected: 1, Alerts: map[string]int{"drift": 2}, Since: time.Unix(0, 0), Until: time.Unix(60, 0)}
+	if s := d.Summary(); s != "312 clients connected, 1 client disconnected and 2 drift alerts in the last minute" {
+		t.Errorf("unexpected summary %q", s)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	posts := make(chan string, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Text string `json:"text"`
+		}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &body)
+		posts <- body.Text
+	}))
+	defer server.Close()
+
+	Burst = 2
+	m.Lock()
+	recipients[server.URL] = false
+	m.Unlock()
+
+	defer func() {
+		Burst = 10
+		m.Lock()
+		delete(recipients, server.URL)
+		m.Unlock()
+		flush(time.Now())
+	}()
+
+	since = time.Now().Add(-time.Minute)
+	for _, host := range []string{"web01", "web02", "web03", "web04", "web05"} {
+		deliver(observers.ClientState{Status: "connected", HostName: host})
+	}
+	deliver(observers.Alert{Kind: "canary", Message: "tripped"})
+	deliver(observers.Alert{Kind: "drift", Message: "changed", Notify: "somewhere-else"})
+
+	wait := func() string {
+		select {
+		case p := <-posts:
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a webhook to be posted")
+		}
+		return ""
+	}
+
+	for i := 0; i < Burst; i++ {
+		wait()
+	}
+
+	select {
+	case p := <-posts:
+		t.Fatalf("expected nothing past the burst to be posted before the window ends, got %q", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	flush(since.Add(time.Minute))
+	if digest := wait(); digest != "3 clients connected and 1 canary alert in the last minute" {
+		t.Errorf("unexpected digest %q", digest)
+	}
+
+	// A new window has its own burst
+	deliver(observers.ClientState{Status: "disconnected", HostName: "web01", ID: "abc", Version: "1.0"})
+	if p := wait(); p != "web01 (abc) 1.0 disconnected" {
+		t.Errorf("expected the message to be sent as it is in a new window, got %q", p)
+	}
+}
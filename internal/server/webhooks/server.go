@@ -1,8 +1,6 @@
 package webhooks
 
 import (
-	"bytes"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,10 +8,10 @@ import (
 	"log"
 	"net"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"net/http"
 	"net/url"
 
 	"github.com/NHAS/reverse_ssh/internal/server/observers"
@@ -45,48 +43,21 @@ func StartWebhooks(config string) {
 		messages <- message
 	})
 
-	go func() {
-		for msg := range messages {
-
-			go func(msg observer.Message) {
-
-				fullBytes, err := msg.Json()
-				if err != nil {
-					log.Println("Bad webhook message: ", err)
-					return
-				}
-
-				wrapper := struct {
-					Full string
-					Text string `json:"text"`
-				}{
-					Full: string(fullBytes),
-					Text: msg.Summary(),
-				}
-
-				data, _ := json.Marshal(wrapper)
-
-				m.RLock()
-				for r, tlsCheck := range recipients {
-
-					tr := &http.Transport{
-						TLSClientConfig: &tls.Config{InsecureSkipVerify: !tlsCheck},
-					}
-
-					client := http.Client{
-						Timeout:   2 * time.Second,
-						Transport: tr,
-					}
-
-					buff := bytes.NewBuffer(data)
-					_, err := client.Post(r, "application/json", buff)
-					if err != nil {
-						log.Printf("Error sending webhook '%s': %s\n", r, err)
-					}
-				}
-				m.RUnlock()
-			}(msg)
+	observers.Alerts.Register(func(message observer.Message) {
+		messages <- message
+	})
 
+	go func() {
+		tick := time.NewTicker(Window)
+		defer tick.Stop()
+
+		for {
+			select {
+			case msg := <-messages:
+				deliver(msg)
+			case now := <-tick.C:
+				flush(now)
+			}
 		}
 	}()
 }
@@ -119,6 +90,19 @@ func Add(newUrl string, checkTLS bool) (string, error) {
 	return u.String(), nil
 }
 
+// Matching is whether any webhook url contains part, so alerts meant only for it have somewhere to go
+func Matching(part string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	for r := range recipients {
+		if strings.Contains(r, part) {
+			return true
+		}
+	}
+	return false
+}
+
 func GetAll() []string {
 	m.RLock()
 	defer m.RUnlock()
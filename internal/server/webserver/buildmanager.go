@@ -2,6 +2,7 @@ package webserver
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,8 +13,10 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/client/config"
 	"github.com/NHAS/reverse_ssh/pkg/trie"
 	"golang.org/x/crypto/ssh"
 )
@@ -27,6 +30,41 @@ type file struct {
 	FileType        string
 	Hits            int
 	Version         string
+
+	// Clients an installer script picks between, by link name
+	Variants []string `json:",omitempty"`
+
+	// Limits on who can download the file and for how long, zero values mean no limit
+	Key          string    `json:",omitempty"`
+	Expires      time.Time `json:",omitempty"`
+	MaxDownloads int       `json:",omitempty"`
+}
+
+var errUnavailable = errors.New("file is not available")
+
+// available checks a download with the given key is allowed, without counting it
+func (f file) available(key string) error {
+	if !f.Expires.IsZero() && time.Now().After(f.Expires) {
+		return errUnavailable
+	}
+
+	if f.MaxDownloads > 0 && f.Hits >= f.MaxDownloads {
+		return errUnavailable
+	}
+
+	if f.Key != "" && subtle.ConstantTimeCompare([]byte(f.Key), []byte(key)) != 1 {
+		return errUnavailable
+	}
+
+	return nil
+}
+
+// Downloads describes the download count against any limit, for listing
+func (f file) Downloads() string {
+	if f.MaxDownloads > 0 {
+		return fmt.Sprintf("%d/%d", f.Hits, f.MaxDownloads)
+	}
+	return fmt.Sprintf("%d", f.Hits)
 }
 
 const (
@@ -44,7 +82,7 @@ var (
 	cachePath string
 )
 
-func Build(goos, goarch, goarm, suppliedConnectBackAdress, fingerprint, name, comment, proxy string, shared, upx, garble, disableLibC, tls, wss, ws bool) (string, error) {
+func Build(goos, goarch, goarm, suppliedConnectBackAdress, fingerprint, name, comment, proxy, engagement, algorithms, token string, shared, upx, garble, disableLibC, tls, wss, ws, noTransfer, noForward, memoryOnly, osLog bool, key, persist string, expiry time.Duration, maxDownloads int) (string, error) {
 	if !webserverOn {
 		return "", errors.New("web server is not enabled")
 	}
@@ -128,6 +166,14 @@ func Build(goos, goarch, goarm, suppliedConnectBackAdress, fingerprint, name, co
 
 	f.Goarm = goarm
 
+	f.Key = key
+
+	if expiry > 0 {
+		f.Expires = time.Now().Add(expiry)
+	}
+
+	f.MaxDownloads = maxDownloads
+
 	f.Path = filepath.Join(cachePath, filename)
 	f.FileType = "executable"
 	f.Version = internal.Version + "_guess"
@@ -144,9 +190,18 @@ func Build(goos, goarch, goarm, suppliedConnectBackAdress, fingerprint, name, co
 
 	buildArguments = append(buildArguments, "build", "-trimpath")
 
+	var tags []string
+	if noTransfer {
+		tags = append(tags, "notransfer")
+	}
+
+	if noForward {
+		tags = append(tags, "noforward")
+	}
+
 	if shared {
 		buildArguments = append(buildArguments, "-buildmode=c-shared")
-		buildArguments = append(buildArguments, "-tags=cshared")
+		tags = append(tags, "cshared")
 		f.FileType = "shared-object"
 		if f.Goos != "windows" {
 			f.Path += ".so"
@@ -156,6 +211,10 @@ func Build(goos, goarch, goarm, suppliedConnectBackAdress, fingerprint, name, co
 
 	}
 
+	if len(tags) > 0 {
+		buildArguments = append(buildArguments, "-tags="+strings.Join(tags, ","))
+	}
+
 	newPrivateKey, err := internal.GeneratePrivateKey()
 	if err != nil {
 		return "", err
@@ -178,7 +237,24 @@ func Build(goos, goarch, goarm, suppliedConnectBackAdress, fingerprint, name, co
 		return "", err
 	}
 
-	buildArguments = append(buildArguments, fmt.Sprintf("-ldflags=-s -w -X main.destination=%s -X main.fingerprint=%s -X main.proxy=%s -X github.com/NHAS/reverse_ssh/internal.Version=%s", suppliedConnectBackAdress, fingerprint, proxy, strings.TrimSpace(f.Version)))
+	ldflags := fmt.Sprintf("-s -w -X main.destination=%s -X main.fingerprint=%s -X main.proxy=%s -X github.com/NHAS/reverse_ssh/internal.Version=%s", suppliedConnectBackAdress, fingerprint, proxy, strings.TrimSpace(f.Version))
+	if algorithms != "" {
+		ldflags += " -X main.algorithms=" + algorithms
+	}
+
+	if memoryOnly {
+		ldflags += " -X main.memoryOnly=true"
+	}
+
+	if osLog {
+		ldflags += " -X main.osLog=true"
+	}
+
+	if token != "" {
+		ldflags += " -X main.joinToken=" + token
+	}
+
+	buildArguments = append(buildArguments, "-ldflags="+ldflags)
 	buildArguments = append(buildArguments, "-o", f.Path, filepath.Join(projectRoot, "/cmd/client"))
 
 	cmd := exec.Command(buildTool, buildArguments...)
@@ -198,17 +274,17 @@ func Build(goos, goarch, goarm, suppliedConnectBackAdress, fingerprint, name, co
 	cgoOn := "0"
 	if shared {
 
-		var crossCompiler string
-		if (runtime.GOOS == "linux" || runtime.GOOS == "darwin") && f.Goos == "windows" && f.Goarch == "amd64" {
-			crossCompiler = "x86_64-w64-mingw32-gcc"
-		}
-
-		cmd.Env = append(cmd.Env, "CC="+crossCompiler)
+		cmd.Env = append(cmd.Env, "CC="+crossCompiler(f.Goos, f.Goarch))
 		cgoOn = "1"
 	}
 
 	cmd.Env = append(cmd.Env, "CGO_ENABLED="+cgoOn)
 
+	// A FIPS server builds clients with validated crypto too, on the platforms boringcrypto supports
+	if internal.FIPS() && f.Goos == "linux" && (f.Goarch == "amd64" || f.Goarch == "arm64") && !disableLibC {
+		cmd.Env = append(cmd.Env, "GOEXPERIMENT=boringcrypto", "CGO_ENABLED=1")
+	}
+
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		if strings.Contains(err.Error(), "garble") && strings.Contains(err.Error(), "x86_64-w64-mingw32-ld") && strings.Contains(err.Error(), "undefined reference to") {
@@ -240,17 +316,72 @@ func Build(goos, goarch, goarm, suppliedConnectBackAdress, fingerprint, name, co
 
 	writeCache()
 
+	url := "http://" + DefaultConnectBack + "/" + name
+	if f.Key != "" {
+		url += "?key=" + f.Key
+	}
+
+	// The built in key is never trusted, the client has to enroll its own with the token
+	if token != "" {
+		return url, nil
+	}
+
 	authorizedControlleeKeys, err := os.OpenFile(filepath.Join(cachePath, "../authorized_controllee_keys"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		return "", errors.New("cant open authorized controllee keys file: " + err.Error())
 	}
 
 	defer authorizedControlleeKeys.Close()
-	if _, err = authorizedControlleeKeys.WriteString(fmt.Sprintf("%s %s\n", publicKeyBytes[:len(publicKeyBytes)-1], comment)); err != nil {
+
+	var options []string
+	if engagement != "" {
+		options = append(options, fmt.Sprintf("engagement=%q", engagement))
+	}
+
+	if persist != "" {
+		options = append(options, fmt.Sprintf("persist=%q", persist))
+	}
+
+	keyLine := fmt.Sprintf("%s %s\n", publicKeyBytes[:len(publicKeyBytes)-1], comment)
+	if len(options) > 0 {
+		keyLine = strings.Join(options, ",") + " " + keyLine
+	}
+
+	if _, err = authorizedControlleeKeys.WriteString(keyLine); err != nil {
 		return "", errors.New("cant write newly generated key to authorized controllee keys file: " + err.Error())
 	}
 
-	return "http://" + DefaultConnectBack + "/" + name, nil
+	return url, nil
+}
+
+// AddInstaller serves install scripts under name that fetch whichever of the already built variants matches the machine they run on
+func AddInstaller(name string, variants []string, key string, expiry time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := cache[name]; ok {
+		return errors.New("this link name is already in use")
+	}
+
+	f := file{
+		CallbackAddress: DefaultConnectBack,
+		FileType:        "installer",
+		Variants:        variants,
+		Key:             key,
+		Version:         internal.Version,
+	}
+
+	if expiry > 0 {
+		f.Expires = time.Now().Add(expiry)
+	}
+
+	cache[name] = f
+
+	Autocomplete.Add(name)
+
+	writeCache()
+
+	return nil
 }
 
 func Get(key string) (file, error) {
@@ -262,11 +393,29 @@ func Get(key string) (file, error) {
 		return cacheEntry, errors.New("Unable to find cache entry: " + key)
 	}
 
-	cacheEntry.Hits++
+	return cacheEntry, nil
+}
 
-	cache[key] = cacheEntry
+// download counts a download of the file, failing if it is no longer available to the given key
+func download(name, key string) (file, error) {
+	c.Lock()
+	defer c.Unlock()
 
-	return cacheEntry, nil
+	f, ok := cache[name]
+	if !ok {
+		return f, errors.New("Unable to find cache entry: " + name)
+	}
+
+	if err := f.available(key); err != nil {
+		return f, err
+	}
+
+	f.Hits++
+	cache[name] = f
+
+	writeCache()
+
+	return f, nil
 }
 
 func List(filter string) (matchingFiles map[string]file, err error) {
@@ -322,9 +471,59 @@ func Delete(key string) error {
 
 	Autocomplete.Remove(key)
 
+	if cacheEntry.FileType == "installer" {
+		return nil
+	}
+
+	os.Remove(cacheEntry.Path + ".msi")
+
 	return os.Remove(cacheEntry.Path)
 }
 
+// Configure writes a sealed configuration section into a built client in place, so it calls back somewhere else without being rebuilt
+func Configure(name string, contents []byte, callbackAddress string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	f, ok := cache[name]
+	if !ok {
+		return errors.New("Unable to find cache entry: " + name)
+	}
+
+	if f.FileType != "executable" && f.FileType != "shared-object" {
+		return fmt.Errorf("%s is not a client, it cannot be configured", name)
+	}
+
+	executable, err := os.ReadFile(f.Path)
+	if err != nil {
+		return err
+	}
+
+	if err := config.Patch(executable, contents); err != nil {
+		return err
+	}
+
+	temp := f.Path + ".configured"
+	if err := os.WriteFile(temp, executable, 0700); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp, f.Path); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	// The installer wraps the old binary, so it has to be rebuilt next time it is asked for
+	os.Remove(f.Path + ".msi")
+
+	f.CallbackAddress = callbackAddress
+	cache[name] = f
+
+	writeCache()
+
+	return nil
+}
+
 func writeCache() {
 	content, err := json.Marshal(cache)
 	if err != nil {
@@ -400,3 +599,37 @@ func startBuildManager(cPath string) error {
 
 	return nil
 }
+
+// crossCompiler picks the C compiler cgo needs to build a shared object for another platform, or nothing if the default will do
+func crossCompiler(goos, goarch string) string {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		return ""
+	}
+
+	if goos == "windows" {
+		switch goarch {
+		case "amd64":
+			return "x86_64-w64-mingw32-gcc"
+		case "386":
+			return "i686-w64-mingw32-gcc"
+		}
+		return ""
+	}
+
+	if goos != "linux" || (runtime.GOOS == "linux" && runtime.GOARCH == goarch) {
+		return ""
+	}
+
+	switch goarch {
+	case "amd64":
+		return "x86_64-linux-gnu-gcc"
+	case "386":
+		return "i686-linux-gnu-gcc"
+	case "arm64":
+		return "aarch64-linux-gnu-gcc"
+	case "arm":
+		return "arm-linux-gnueabihf-gcc"
+	}
+
+	return ""
+}
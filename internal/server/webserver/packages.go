@@ -0,0 +1,334 @@
+package webserver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// servePackage sends a built client wrapped in an installer package, .deb for linux executables or .msi for windows ones
+func servePackage(w http.ResponseWriter, req *http.Request, name, extension, key string) {
+	f, err := Get(name)
+	if err != nil || f.FileType != "executable" {
+		writeNotFound(w)
+		return
+	}
+
+	var (
+		contents io.ReadSeeker
+		filename = name + extension
+	)
+
+	switch {
+	case extension == ".deb" && f.Goos == "linux":
+		var b bytes.Buffer
+		if err := makeDeb(&b, name, f); err != nil {
+			log.Printf("[%s] Unable to package %s as a deb: %s\n", req.RemoteAddr, name, err)
+			writeNotFound(w)
+			return
+		}
+		contents = bytes.NewReader(b.Bytes())
+
+	case extension == ".msi" && f.Goos == "windows":
+		path, err := makeMsi(name, f)
+		if err != nil {
+			log.Printf("[%s] Unable to package %s as an msi: %s\n", req.RemoteAddr, name, err)
+			writeNotFound(w)
+			return
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			http.Error(w, "Error: "+err.Error(), 501)
+			return
+		}
+		defer file.Close()
+
+		contents = file
+
+	default:
+		writeNotFound(w)
+		return
+	}
+
+	if _, err := download(name, key); err != nil {
+		writeNotFound(w)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	io.Copy(w, contents)
+}
+
+// packageName makes a link name into something dpkg and msiexec accept as a package name
+func packageName(name string) string {
+	var sb strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '.' || c == '+' {
+			sb.WriteRune(c)
+			continue
+		}
+		sb.WriteByte('-')
+	}
+
+	out := strings.Trim(sb.String(), "-.+")
+	if len(out) < 2 {
+		out = "client" + out
+	}
+
+	return out
+}
+
+func debArch(goarch, goarm string) string {
+	switch goarch {
+	case "386":
+		return "i386"
+	case "arm":
+		if goarm == "5" || goarm == "6" {
+			return "armel"
+		}
+		return "armhf"
+	case "mipsle":
+		return "mipsel"
+	case "mips64le":
+		return "mips64el"
+	}
+
+	return goarch
+}
+
+// makeDeb writes a debian package that installs the client to /usr/bin and starts it
+func makeDeb(out io.Writer, name string, f file) error {
+	binary, err := os.ReadFile(f.Path)
+	if err != nil {
+		return err
+	}
+
+	pkg := packageName(name)
+	modified := time.Now()
+
+	control := fmt.Sprintf("Package: %s\nVersion: 1.0.0\nArchitecture: %s\nMaintainer: root <root@localhost>\nInstalled-Size: %d\nDescription: %s\n",
+		pkg, debArch(f.Goarch, f.Goarm), (len(binary)+1023)/1024, pkg)
+
+	postinst := fmt.Sprintf("#!/bin/sh\n/usr/bin/%s >/dev/null 2>&1 || true\nexit 0\n", pkg)
+
+	controlTar, err := tarball(modified, []tarEntry{
+		{name: "./", dir: true},
+		{name: "./control", mode: 0644, contents: []byte(control)},
+		{name: "./postinst", mode: 0755, contents: []byte(postinst)},
+	})
+	if err != nil {
+		return err
+	}
+
+	dataTar, err := tarball(modified, []tarEntry{
+		{name: "./", dir: true},
+		{name: "./usr/", dir: true},
+		{name: "./usr/bin/", dir: true},
+		{name: "./usr/bin/" + pkg, mode: 0755, contents: binary},
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeAr(out, modified, []arMember{
+		{name: "debian-binary", contents: []byte("2.0\n")},
+		{name: "control.tar.gz", contents: controlTar},
+		{name: "data.tar.gz", contents: dataTar},
+	})
+}
+
+type tarEntry struct {
+	name     string
+	dir      bool
+	mode     int64
+	contents []byte
+}
+
+func tarball(modified time.Time, entries []tarEntry) ([]byte, error) {
+	var b bytes.Buffer
+
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:    e.name,
+			Mode:    e.mode,
+			Size:    int64(len(e.contents)),
+			ModTime: modified,
+			Uname:   "root",
+			Gname:   "root",
+			Format:  tar.FormatGNU,
+		}
+
+		hdr.Typeflag = tar.TypeReg
+		if e.dir {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+
+		if _, err := tw.Write(e.contents); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+type arMember struct {
+	name     string
+	contents []byte
+}
+
+// writeAr writes the common ar format used by debian packages, names have to fit in the 16 byte header field
+func writeAr(out io.Writer, modified time.Time, members []arMember) error {
+	if _, err := io.WriteString(out, "!<arch>\n"); err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		if len(m.name) > 16 {
+			return fmt.Errorf("ar member name %q is too long", m.name)
+		}
+
+		header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", m.name, modified.Unix(), 0, 0, 0100644, len(m.contents))
+		if _, err := io.WriteString(out, header); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(m.contents); err != nil {
+			return err
+		}
+
+		if len(m.contents)%2 != 0 {
+			if _, err := out.Write([]byte{'\n'}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+const wxsTemplate = `<?xml version="1.0" encoding="utf-8"?>
+<Wix xmlns="http://schemas.microsoft.com/wix/2006/wi">
+  <Product Id="*" Name="{{.Name}}" Language="1033" Version="1.0.0" Manufacturer="{{.Name}}" UpgradeCode="{{.UpgradeCode}}">
+    <Package InstallerVersion="200" Compressed="yes" InstallScope="perMachine"{{if .Win64}} Platform="x64"{{end}}/>
+    <Media Id="1" Cabinet="client.cab" EmbedCab="yes"/>
+    <Directory Id="TARGETDIR" Name="SourceDir">
+      <Directory Id="{{if .Win64}}ProgramFiles64Folder{{else}}ProgramFilesFolder{{end}}">
+        <Directory Id="INSTALLDIR" Name="{{.Name}}">
+          <Component Id="Client" Guid="{{.ComponentGuid}}"{{if .Win64}} Win64="yes"{{end}}>
+            <File Id="ClientExe" Name="{{.Name}}.exe" Source="{{.Source}}" KeyPath="yes"/>
+          </Component>
+        </Directory>
+      </Directory>
+    </Directory>
+    <Feature Id="Complete" Level="1">
+      <ComponentRef Id="Client"/>
+    </Feature>
+    <CustomAction Id="StartClient" FileKey="ClientExe" ExeCommand="" Return="asyncNoWait"/>
+    <InstallExecuteSequence>
+      <Custom Action="StartClient" After="InstallFinalize">NOT Installed</Custom>
+    </InstallExecuteSequence>
+  </Product>
+</Wix>
+`
+
+// guidFor derives a stable GUID from a name, so rebuilding an msi for the same link upgrades rather than installs alongside
+func guidFor(name string) string {
+	h := sha256.Sum256([]byte(name))
+	return fmt.Sprintf("%X-%X-%X-%X-%X", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// makeMsi builds a windows installer with wixl from msitools, which is cached next to the client
+func makeMsi(name string, f file) (string, error) {
+	path := f.Path + ".msi"
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	var arch string
+	switch f.Goarch {
+	case "amd64":
+		arch = "x64"
+	case "386":
+		arch = "x86"
+	default:
+		return "", errors.New("msi packages can only be made for 386 and amd64 clients")
+	}
+
+	if _, err := exec.LookPath("wixl"); err != nil {
+		return "", errors.New("wixl (msitools) could not be found in PATH")
+	}
+
+	dir, err := os.MkdirTemp("", "msi")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	pkg := packageName(name)
+
+	var wxs bytes.Buffer
+	err = template.Must(template.New("wxs").Parse(wxsTemplate)).Execute(&wxs, struct {
+		Name, UpgradeCode, ComponentGuid, Source string
+		Win64                                    bool
+	}{
+		Name:          xmlEscape(pkg),
+		UpgradeCode:   guidFor("upgrade:" + pkg),
+		ComponentGuid: guidFor("component:" + pkg),
+		Source:        xmlEscape(f.Path),
+		Win64:         arch == "x64",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	wxsPath := filepath.Join(dir, "client.wxs")
+	if err := os.WriteFile(wxsPath, wxs.Bytes(), 0600); err != nil {
+		return "", err
+	}
+
+	output, err := exec.Command("wixl", "-a", arch, "-o", path, wxsPath).CombinedOutput()
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("%s: %s", err, output)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,132 @@
+package webserver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPackageName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"AbCdEf0123":   "abcdef0123",
+		"web_client":   "web-client",
+		"-x-":          "clientx",
+		"client.v2":    "client.v2",
+		"../etc/thing": "etc-thing",
+	} {
+		if got := packageName(name); got != expected {
+			t.Errorf("%q: got %q expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	if err := (file{}).available(""); err != nil {
+		t.Errorf("unlimited file should be available: %s", err)
+	}
+
+	if err := (file{Expires: time.Now().Add(-time.Minute)}).available(""); err == nil {
+		t.Error("expired file should not be available")
+	}
+
+	if err := (file{Hits: 2, MaxDownloads: 2}).available(""); err == nil {
+		t.Error("file at its download limit should not be available")
+	}
+
+	gated := file{Key: "secret"}
+	if err := gated.available("wrong"); err == nil {
+		t.Error("gated file should not be available with the wrong key")
+	}
+
+	if err := gated.available("secret"); err != nil {
+		t.Errorf("gated file should be available with its key: %s", err)
+	}
+}
+
+func TestMakeDeb(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client")
+	if err := os.WriteFile(path, []byte("not really a client"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := makeDeb(&b, "Web01", file{Path: path, Goarch: "arm", Goarm: "7"}); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := b.Bytes()
+	if !bytes.HasPrefix(archive, []byte("!<arch>\n")) {
+		t.Fatal("package does not start with the ar magic")
+	}
+	archive = archive[8:]
+
+	members := map[string][]byte{}
+	var order []string
+	for len(archive) > 0 {
+		if len(archive) < 60 {
+			t.Fatalf("truncated ar header")
+		}
+
+		name := strings.TrimSpace(string(archive[:16]))
+		size, err := strconv.Atoi(strings.TrimSpace(string(archive[48:58])))
+		if err != nil {
+			t.Fatalf("bad size for %s: %s", name, err)
+		}
+
+		archive = archive[60:]
+		members[name] = archive[:size]
+		order = append(order, name)
+
+		archive = archive[size+size%2:]
+	}
+
+	if strings.Join(order, ",") != "debian-binary,control.tar.gz,data.tar.gz" {
+		t.Fatalf("unexpected members %v", order)
+	}
+
+	if string(members["debian-binary"]) != "2.0\n" {
+		t.Errorf("unexpected debian-binary %q", members["debian-binary"])
+	}
+
+	control := untar(t, members["control.tar.gz"])
+	if !strings.Contains(control["./control"], "Package: web01\n") || !strings.Contains(control["./control"], "Architecture: armhf\n") {
+		t.Errorf("unexpected control file %q", control["./control"])
+	}
+
+	data := untar(t, members["data.tar.gz"])
+	if data["./usr/bin/web01"] != "not really a client" {
+		t.Errorf("client is not installed to /usr/bin, got %v", data)
+	}
+}
+
+func untar(t *testing.T, contents []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+
+	return files
+}
@@ -17,6 +17,17 @@ type Args struct {
 	Name     string
 	Arch     string
 	OS       string
+	// Query is added to download urls, carrying the key for files that need one
+	Query string
+
+	// Variants are the clients an install script chooses from
+	Variants []Variant
+}
+
+type Variant struct {
+	OS   string
+	Arch string
+	Name string
 }
 
 func MakeTemplate(attributes Args, extension string) ([]byte, error) {
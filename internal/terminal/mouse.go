@@ -0,0 +1,103 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Mouse reports are asked for in the SGR encoding, mode 1006, so columns and rows past 223 are not mangled
+const (
+	mouseOn  = "\x1b[?1000h\x1b[?1006h"
+	mouseOff = "\x1b[?1000l\x1b[?1006l"
+
+	// Asks the terminal where the cursor is, it answers with ESC [ row ; column R
+	cursorRequest = "\x1b[6n"
+)
+
+const (
+	mouseLeft      = 0
+	mouseWheelUp   = 64
+	mouseWheelDown = 65
+)
+
+// TERM prefixes of terminals known to report the mouse, anything else is not asked to
+var mouseTerms = []string{"xterm", "screen", "tmux", "rxvt", "alacritty", "kitty", "foot", "wezterm", "konsole", "gnome", "vte", "putty", "st-"}
+
+var mouseDisabled int32
+
+// SetMouse turns mouse reporting in interactive views on or off for every terminal, it is on by default
+func SetMouse(on bool) {
+	var disabled int32
+	if !on {
+		disabled = 1
+	}
+	atomic.StoreInt32(&mouseDisabled, disabled)
+}
+
+// mouse reports whether the operator's terminal should be asked to report the mouse
+func (t *Terminal) mouse() bool {
+	if atomic.LoadInt32(&mouseDisabled) == 1 || t.dumb || t.Plain() || t.user == nil || t.user.Pty == nil {
+		return false
+	}
+
+	for _, prefix := range mouseTerms {
+		if strings.HasPrefix(t.user.Pty.Term, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type mouseEvent struct {
+	button int
+	// From 1, the top left of the screen
+	column, row int
+	pressed     bool
+}
+
+// csiNumbers reads ESC [ prefix n;n;... final from the start of b, giving the numbers, the final byte and what follows
+func csiNumbers(b []byte, prefix string) (numbers []int, final byte, rest []byte, ok bool) {
+	start := "\x1b[" + prefix
+	if !strings.HasPrefix(string(b), start) {
+		return nil, 0, b, false
+	}
+
+	for i := len(start); i < len(b); i++ {
+		c := b[i]
+		if c >= '0' && c <= '9' || c == ';' {
+			continue
+		}
+
+		for _, field := range strings.Split(string(b[len(start):i]), ";") {
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, 0, b, false
+			}
+			numbers = append(numbers, n)
+		}
+		return numbers, c, b[i+1:], true
+	}
+
+	return nil, 0, b, false
+}
+
+// parseMouse reads an SGR mouse report, ESC [ < button ; column ; row followed by M when pressed or m when released
+func parseMouse(b []byte) (mouseEvent, []byte, bool) {
+	n, final, rest, ok := csiNumbers(b, "<")
+	if !ok || len(n) != 3 || (final != 'M' && final != 'm') {
+		return mouseEvent{}, b, false
+	}
+
+	return mouseEvent{button: n[0], column: n[1], row: n[2], pressed: final == 'M'}, rest, true
+}
+
+// parseCursorReport reads the answer to cursorRequest
+func parseCursorReport(b []byte) (row int, rest []byte, ok bool) {
+	n, final, rest, ok := csiNumbers(b, "")
+	if !ok || len(n) != 2 || final != 'R' {
+		return 0, b, false
+	}
+
+	return n[0], rest, true
+}
@@ -0,0 +1,144 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrNotPicked is returned by Pick when the operator backs out without choosing anything
+var ErrNotPicked = errors.New("Nothing was picked")
+
+// Pick asks the operator to choose one of options, moving between them with the arrow keys and choosing with enter, or by
+// typing the number of an option. Escape, q and ^C back out of it. On a terminal that reports the mouse, the wheel moves between
+// options and clicking one chooses it. When the operator has asked for plain output the options are listed once and never redrawn,
+// only numbers are taken
+func (t *Terminal) Pick(title string, options []string) (int, error) {
+	if len(options) == 0 {
+		return -1, ErrNotPicked
+	}
+
+	t.lock.Lock()
+	wasRaw := t.raw
+	t.lock.Unlock()
+
+	t.EnableRaw()
+	if !wasRaw {
+		defer t.DisableRaw()
+	}
+
+	// A dumb terminal cannot have the list drawn over itself either
+	plain := t.Plain() || t.Dumb()
+
+	mouse := !plain && t.mouse()
+	if mouse {
+		fmt.Fprintf(t, "%s", mouseOn)
+		defer fmt.Fprintf(t, "%s", mouseOff)
+	}
+
+	selected, typed := 0, ""
+	fmt.Fprintf(t, "%s\r\n", title)
+	t.drawPicker(options, selected, plain, false)
+
+	// Clicks are only known to be on an option once the terminal says which row the list ends above
+	bottom := 0
+	if mouse {
+		fmt.Fprintf(t, "%s", cursorRequest)
+	}
+
+	buf := make([]byte, 256)
+	for {
+		n, err := t.Read(buf)
+		if err != nil {
+			return -1, err
+		}
+
+		for rest := buf[:n]; len(rest) > 0; {
+			if mouse {
+				if row, after, ok := parseCursorReport(rest); ok {
+					bottom, rest = row, after
+					continue
+				}
+
+				if event, after, ok := parseMouse(rest); ok {
+					rest = after
+
+					switch {
+					case event.button == mouseWheelUp && selected > 0:
+						selected, typed = selected-1, ""
+					case event.button == mouseWheelDown && selected < len(options)-1:
+						selected, typed = selected+1, ""
+					case event.button == mouseLeft && event.pressed && bottom > 0:
+						if clicked := event.row - (bottom - len(options)); clicked >= 0 && clicked < len(options) {
+							t.drawPicker(options, clicked, plain, true)
+							fmt.Fprintf(t, "\r\n")
+							return clicked, nil
+						}
+					}
+					continue
+				}
+			}
+
+			var key rune
+			key, rest = bytesToKey(rest, false)
+
+			switch {
+			case key == keyEnter:
+				if plain && typed == "" {
+					continue
+				}
+				fmt.Fprintf(t, "\r\n")
+				return selected, nil
+			case key == keyEscape || key == keyCtrlC || key == 'q':
+				fmt.Fprintf(t, "\r\n")
+				return -1, ErrNotPicked
+			case key == keyUp && !plain:
+				selected, typed = (selected+len(options)-1)%len(options), ""
+			case key == keyDown && !plain:
+				selected, typed = (selected+1)%len(options), ""
+			case key >= '0' && key <= '9':
+				// Numbers carry on from what was typed while they still name an option, so 12 can be typed with more than 9 options
+				if n, _ := strconv.Atoi(typed + string(key)); n >= 1 && n <= len(options) {
+					typed += string(key)
+				} else if n, _ := strconv.Atoi(string(key)); n >= 1 && n <= len(options) {
+					typed = string(key)
+				} else {
+					continue
+				}
+
+				selected, _ = strconv.Atoi(typed)
+				selected--
+				if plain {
+					fmt.Fprintf(t, "%c", key)
+				}
+			}
+		}
+
+		if !plain {
+			t.drawPicker(options, selected, plain, true)
+		}
+	}
+}
+
+func (t *Terminal) drawPicker(options []string, selected int, plain, again bool) {
+	if again {
+		// Back to the first option, so the list is drawn over itself
+		fmt.Fprintf(t, "\x1b[%dA", len(options))
+	}
+
+	if plain {
+		for i, option := range options {
+			fmt.Fprintf(t, "%d) %s\r\n", i+1, option)
+		}
+		fmt.Fprintf(t, "Number: ")
+		return
+	}
+
+	for i, option := range options {
+		marker := "  "
+		if i == selected {
+			marker = "> "
+		}
+		fmt.Fprintf(t, "\r\x1b[K%s%d) %s\r\n", marker, i+1, option)
+	}
+}
@@ -0,0 +1,273 @@
+package terminal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/pkg/command"
+)
+
+// redirectOperator finds the last unquoted op standing on its own in line, returning where it starts and ends, or -1 if there isnt one.
+// A > may be doubled as >>, a doubled < is left alone as there are no here documents
+func redirectOperator(line string, op byte) (start, end int) {
+	start, end = -1, -1
+
+	var (
+		inString        bool
+		stringDelimiter byte
+		literalNext     bool
+	)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		if literalNext {
+			literalNext = false
+			continue
+		}
+
+		switch {
+		case c == '\\' && !(inString && stringDelimiter == '\''):
+			literalNext = true
+		case inString:
+			if c == stringDelimiter {
+				inString = false
+			}
+		case c == '"' || c == '\'' || c == '`':
+			inString = true
+			stringDelimiter = c
+		case c == op && (i == 0 || line[i-1] == ' '):
+			opEnd := i + 1
+			if opEnd < len(line) && line[opEnd] == op && op == '>' {
+				opEnd++
+			}
+
+			if opEnd == len(line) || line[opEnd] == ' ' {
+				start, end = i, opEnd
+			}
+			i = opEnd - 1
+		}
+	}
+
+	return start, end
+}
+
+// lastRedirect is whichever of > or < comes last in line, where a file name being typed after it is to be completed
+func lastRedirect(line string) (start, end int) {
+	start, end = redirectOperator(line, '>')
+	if inStart, inEnd := redirectOperator(line, '<'); inStart > start {
+		return inStart, inEnd
+	}
+	return start, end
+}
+
+// splitRedirect separates a trailing "> file" or ">> file" from a command line. Anything else, such as ">file" or a > followed by more
+// than one word, is left for the command as clients often want redirection characters passed through to them
+func splitRedirect(line string) (commandLine, target string, appendTo bool) {
+	start, end, name := splitOperator(line, '>')
+	if start == -1 {
+		return line, "", false
+	}
+
+	return strings.TrimRight(line[:start], " "), name, end-start == 2
+}
+
+// splitInput separates a trailing "< file" from a command line, in the same way as splitRedirect
+func splitInput(line string) (commandLine, source string) {
+	start, _, name := splitOperator(line, '<')
+	if start == -1 {
+		return line, ""
+	}
+
+	return strings.TrimRight(line[:start], " "), name
+}
+
+func splitOperator(line string, op byte) (start, end int, name string) {
+	start, end = redirectOperator(line, op)
+	if start == -1 {
+		return -1, -1, ""
+	}
+
+	args, _ := command.ParseArguments(line, end)
+	if len(args) != 1 {
+		return -1, -1, ""
+	}
+
+	return start, end, args[0].Value()
+}
+
+// sandboxPath resolves name within dir, refusing anything that would land outside of it
+func sandboxPath(dir, name string) (string, error) {
+	if dir == "" {
+		return "", errors.New("redirection is not available")
+	}
+
+	cleaned := filepath.Clean(string(filepath.Separator) + name)
+	if cleaned == string(filepath.Separator) {
+		return "", errors.New("redirection needs a file name")
+	}
+
+	return filepath.Join(dir, cleaned), nil
+}
+
+func (s *Shell) openRedirect(name string, appendTo bool) (*os.File, error) {
+	path, err := sandboxPath(s.OutputDir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return nil, errors.New("refusing to write through a symlink")
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendTo {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	return os.OpenFile(path, flags, 0600)
+}
+
+// openInput opens a file in the output directory for "< file", so what one command wrote can be handed to another
+func (s *Shell) openInput(name string) (*os.File, error) {
+	path, err := sandboxPath(s.OutputDir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, errors.New("no such file in the output directory")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil, errors.New("refusing to read through a symlink")
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("not a file")
+	}
+
+	return os.Open(path)
+}
+
+// redirected is handed to commands instead of the terminal when their output goes to a file, or a file is redirected into them.
+// Prompts still read from the terminal, the file is only found with InputOf. It also carries the shell for commands run without a
+// terminal
+type redirected struct {
+	io.Reader
+	io.Writer
+
+	shell *Shell
+	input io.Reader
+}
+
+// InputOf returns what was redirected into a command with "< file", or nil if nothing was
+func InputOf(tty io.ReadWriter) io.Reader {
+	if r, ok := tty.(redirected); ok {
+		return r.input
+	}
+	return nil
+}
+
+// TerminalOf returns the Terminal a command's output is shown on, which it still is when only its input is redirected, or nil if the
+// output goes to a file, a pipe or an exec channel
+func TerminalOf(tty io.ReadWriter) *Terminal {
+	switch v := tty.(type) {
+	case *Terminal:
+		return v
+	case redirected:
+		if t, ok := v.Writer.(*Terminal); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// piped is the output of the command on the left of a pipe. It follows the settings of the output the pipe ends up written to, so
+// accessible output can still be searched with grep
+type piped struct {
+	io.Writer
+
+	to io.Writer
+}
+
+func (p piped) Plain() bool {
+	pl, ok := p.to.(interface{ Plain() bool })
+	return ok && pl.Plain()
+}
+
+func (p piped) Language() string {
+	l, ok := p.to.(interface{ Language() string })
+	if !ok {
+		return ""
+	}
+	return l.Language()
+}
+
+// Plain keeps accessible output when a command runs through the shell without a terminal, files are always written as normal
+func (r redirected) Plain() bool {
+	p, ok := r.Writer.(interface{ Plain() bool })
+	return ok && p.Plain()
+}
+
+func (r redirected) Language() string {
+	l, ok := r.Writer.(interface{ Language() string })
+	if !ok {
+		return ""
+	}
+	return l.Language()
+}
+
+// outputFiles lists what is in the output directory matching a partial path, for completing redirection targets
+func (s *Shell) outputFiles(partial string) (matches []string) {
+	dirPart, prefix := "", partial
+	if i := strings.LastIndex(partial, "/"); i != -1 {
+		dirPart, prefix = partial[:i+1], partial[i+1:]
+	}
+
+	dir := s.OutputDir
+	if dirPart != "" {
+		var err error
+		dir, err = sandboxPath(s.OutputDir, dirPart)
+		if err != nil {
+			return nil
+		}
+	}
+
+	if dir == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+
+		name := dirPart + e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		matches = append(matches, name)
+	}
+
+	sort.Strings(matches)
+
+	return matches
+}
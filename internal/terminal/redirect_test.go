@@ -0,0 +1,80 @@
+package terminal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitRedirect(t *testing.T) {
+	cases := []struct {
+		line, command, target string
+		appendTo              bool
+	}{
+		{"ls --json > fleet.json", "ls --json", "fleet.json", false},
+		{"exec -y all uptime >> out/uptime.txt", "exec -y all uptime", "out/uptime.txt", true},
+		{"ls > \"with space.txt\"", "ls", "with space.txt", false},
+		{"exec host echo hi >/tmp/x", "exec host echo hi >/tmp/x", "", false},
+		{"exec host \"echo hi > /tmp/x\"", "exec host \"echo hi > /tmp/x\"", "", false},
+		{"exec host echo \\> x", "exec host echo \\> x", "", false},
+		{"exec host a > b c", "exec host a > b c", "", false},
+		{"ls >", "ls >", "", false},
+		{"ls", "ls", "", false},
+	}
+
+	for _, c := range cases {
+		command, target, appendTo := splitRedirect(c.line)
+		if command != c.command || target != c.target || appendTo != c.appendTo {
+			t.Errorf("%q: got (%q, %q, %t) expected (%q, %q, %t)", c.line, command, target, appendTo, c.command, c.target, c.appendTo)
+		}
+	}
+}
+
+func TestSplitInput(t *testing.T) {
+	cases := []struct {
+		line, command, source string
+	}{
+		{"exec host sort < data.txt", "exec host sort", "data.txt"},
+		{"exec host sort < \"with space.txt\"", "exec host sort", "with space.txt"},
+		{"exec host sort <data.txt", "exec host sort <data.txt", ""},
+		{"exec host 'sort < data.txt'", "exec host 'sort < data.txt'", ""},
+		{"exec host cat << EOF", "exec host cat << EOF", ""},
+		{"exec host sort < a b", "exec host sort < a b", ""},
+		{"exec host sort", "exec host sort", ""},
+	}
+
+	for _, c := range cases {
+		command, source := splitInput(c.line)
+		if command != c.command || source != c.source {
+			t.Errorf("%q: got (%q, %q) expected (%q, %q)", c.line, command, source, c.command, c.source)
+		}
+	}
+}
+
+func TestSandboxPath(t *testing.T) {
+	dir := filepath.FromSlash("/data/output")
+
+	for name, expected := range map[string]string{
+		"fleet.json":        "/data/output/fleet.json",
+		"../../etc/passwd":  "/data/output/etc/passwd",
+		"/etc/passwd":       "/data/output/etc/passwd",
+		"a/../../b/c.txt":   "/data/output/b/c.txt",
+		"./nested/file.txt": "/data/output/nested/file.txt",
+	} {
+		path, err := sandboxPath(dir, name)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %s", name, err)
+		}
+
+		if path != filepath.FromSlash(expected) {
+			t.Errorf("%q: got %q expected %q", name, path, expected)
+		}
+	}
+
+	if _, err := sandboxPath(dir, ".."); err == nil {
+		t.Error("expected redirecting to the output directory itself to fail")
+	}
+
+	if _, err := sandboxPath("", "file"); err == nil {
+		t.Error("expected redirection without an output directory to fail")
+	}
+}
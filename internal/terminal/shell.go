@@ -0,0 +1,461 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/pkg/command"
+)
+
+// Shell runs command lines, expanding variables and handling redirection before handing them to a command. A Terminal
+// has one for its console, and one can be made on its own to run lines that arrive without a terminal, such as exec requests
+type Shell struct {
+	// OutputDir is where "> file" and ">> file" write to, redirection is refused if it is empty
+	OutputDir string
+
+	// Context is whatever the commands need to know of who they are running for, they find it with ShellOf
+	Context interface{}
+
+	functions Commands
+	variables map[string]string
+}
+
+// Commands is where a shell finds the commands it runs, it can be shared by many shells and change as they run
+type Commands interface {
+	Lookup(name string) (Command, bool)
+}
+
+// CommandMap is a fixed set of commands
+type CommandMap map[string]Command
+
+func (m CommandMap) Lookup(name string) (Command, bool) {
+	c, ok := m[name]
+	return c, ok
+}
+
+// Compound is implemented by commands such as if and foreach that run the rest of their line as another command. They get their line exactly as
+// typed, without variables expanded or redirection split off, so that both happen each time the inner command runs
+type Compound interface {
+	Compound()
+}
+
+// ReadsInput is implemented by commands that read a file redirected into them with "< file", which they find with InputOf. The shell
+// refuses input redirection for any other command, rather than leave the file unread
+type ReadsInput interface {
+	ReadsInput()
+}
+
+// Wrapper is implemented by commands that decorate another, so the shell can see what is underneath
+type Wrapper interface {
+	Unwrap() Command
+}
+
+func NewShell(c Commands, outputDir string) *Shell {
+	return &Shell{
+		OutputDir: outputDir,
+		functions: c,
+	}
+}
+
+// WithUser wraps output that has no Terminal, such as an exec channel, so commands writing to it follow the operator's settings
+// for accessible output and language as they would on a Terminal
+func WithUser(output io.ReadWriter, user *internal.User) io.ReadWriter {
+	return userOutput{ReadWriter: output, user: user}
+}
+
+type userOutput struct {
+	io.ReadWriter
+	user *internal.User
+}
+
+func (u userOutput) Plain() bool {
+	return u.user.Accessible
+}
+
+func (u userOutput) Language() string {
+	return u.user.Lang
+}
+
+func (s *Shell) command(name string) (Command, bool) {
+	if s.functions == nil {
+		return nil, false
+	}
+	return s.functions.Lookup(name)
+}
+
+// ShellOf returns the shell running a command from the output it was given, or nil if it was not run from one
+func ShellOf(tty io.ReadWriter) *Shell {
+	switch v := tty.(type) {
+	case *Terminal:
+		return &v.Shell
+	case redirected:
+		return v.shell
+	}
+
+	return nil
+}
+
+func isCompound(c Command) bool {
+	return underneath(c, func(c Command) bool {
+		_, ok := c.(Compound)
+		return ok
+	})
+}
+
+func readsInput(c Command) bool {
+	return underneath(c, func(c Command) bool {
+		_, ok := c.(ReadsInput)
+		return ok
+	})
+}
+
+// flagsOf is the FlagSet of c, or of the command it wraps, or nil if it declares none
+func flagsOf(c Command) *FlagSet {
+	for {
+		if d, ok := c.(Declared); ok {
+			return d.Flags()
+		}
+
+		w, ok := c.(Wrapper)
+		if !ok {
+			return nil
+		}
+		c = w.Unwrap()
+	}
+}
+
+// subcommandOf is the subcommand of c that line names, or c itself when it names none. Path is set on line as it is followed. A
+// Tree under a Wrapper is still followed, but the Wrapper is what runs so it sees every line
+func subcommandOf(c Command, line *ParsedLine) Command {
+	for inner := c; ; {
+		if t, ok := inner.(Tree); ok {
+			if sub := line.Descend(t); sub != Command(t) {
+				return sub
+			}
+			return c
+		}
+
+		w, ok := inner.(Wrapper)
+		if !ok {
+			return c
+		}
+		inner = w.Unwrap()
+	}
+}
+
+// subcommandsAt is the names of the subcommands that can be typed where the cursor is on line, when c or the command it wraps is a Tree
+func subcommandsAt(c Command, line *ParsedLine) ([]string, bool) {
+	for {
+		if t, ok := c.(Tree); ok {
+			return line.SubcommandsAt(t)
+		}
+
+		w, ok := c.(Wrapper)
+		if !ok {
+			return nil, false
+		}
+		c = w.Unwrap()
+	}
+}
+
+// underneath reports whether c, or any command it wraps, is what is
+func underneath(c Command, is func(Command) bool) bool {
+	for {
+		if is(c) {
+			return true
+		}
+
+		w, ok := c.(Wrapper)
+		if !ok {
+			return false
+		}
+		c = w.Unwrap()
+	}
+}
+
+// Execute runs a single command line, writing whatever it outputs to output unless it is redirected to a file. Lines starting with # are comments
+func (s *Shell) Execute(output io.ReadWriter, line string) error {
+	parsedLine := ParseLine(line, 0)
+	if parsedLine.Command == nil || strings.HasPrefix(parsedLine.Command.Value(), "#") {
+		return nil
+	}
+
+	if f, ok := s.command(parsedLine.Command.Value()); ok && isCompound(f) {
+		return f.Run(s.attach(output), parsedLine)
+	}
+
+	// Split before variables are expanded, so a | in the value of one stays as it is
+	if left, right, ok := s.splitPipe(line); ok {
+		return s.pipe(output, left, right)
+	}
+
+	return s.run(output, line, nil)
+}
+
+// run expands and runs a line that is not a pipe, with input as what was piped into it if it is the right side of one
+func (s *Shell) run(output io.ReadWriter, line string, input io.Reader) error {
+	// Either of "< in > out" or "> out < in"
+	line, source := splitInput(s.Expand(line))
+	line, target, appendTo := splitRedirect(line)
+	if source == "" {
+		line, source = splitInput(line)
+	}
+
+	parsedLine := ParseLine(line, 0)
+	if parsedLine.Command == nil {
+		return nil
+	}
+
+	f, ok := s.command(parsedLine.Command.Value())
+	if !ok {
+		return Errorf(NotFound, "Unknown command: %s", parsedLine.Command.Value())
+	}
+
+	// The flags checked and whether input can be given are the subcommand's, though f is what runs
+	leaf := subcommandOf(f, &parsedLine)
+
+	if fs := flagsOf(leaf); fs != nil {
+		if err := fs.Validate(&parsedLine); err != nil {
+			return err
+		}
+	}
+
+	if target == "" && source == "" && input == nil {
+		return f.Run(s.attach(output), parsedLine)
+	}
+
+	r := redirected{Reader: output, Writer: output, shell: s, input: input}
+
+	if source != "" {
+		if input != nil {
+			return Errorf(Usage, "%s is already given the output of a pipe, it cannot be given < %s as well", parsedLine.Command.Value(), source)
+		}
+
+		if !readsInput(leaf) {
+			return Errorf(Usage, "%s does not read input, it cannot be given < %s", parsedLine.Command.Value(), source)
+		}
+
+		file, err := s.openInput(source)
+		if err != nil {
+			return Errorf(NotFound, "Unable to read %s: %s", source, err)
+		}
+		defer file.Close()
+
+		r.input = file
+	}
+
+	if target != "" {
+		file, err := s.openRedirect(target, appendTo)
+		if err != nil {
+			return fmt.Errorf("Unable to redirect to %s: %s", target, err)
+		}
+		defer file.Close()
+
+		r.Writer = file
+	}
+
+	return f.Run(r, parsedLine)
+}
+
+// splitPipe separates the last "| command" from a line when command is one of the console's that reads input. Any other |, such as
+// one on the command line of exec, is left for the command
+func (s *Shell) splitPipe(line string) (left, right string, ok bool) {
+	start, end := redirectOperator(line, '|')
+	if start == -1 {
+		return "", "", false
+	}
+
+	left, right = strings.TrimSpace(line[:start]), strings.TrimSpace(line[end:])
+
+	parsed := ParseLine(right, 0)
+	if left == "" || parsed.Command == nil {
+		return "", "", false
+	}
+
+	f, ok := s.command(parsed.Command.Value())
+	if !ok || !readsInput(subcommandOf(f, &parsed)) {
+		return "", "", false
+	}
+
+	return left, right, true
+}
+
+// pipe runs left with what it writes streamed to right as its input, so command | grep shows matches while the command runs. An
+// error from left is returned once right has finished, the operator would otherwise only see right find nothing
+func (s *Shell) pipe(output io.ReadWriter, left, right string) error {
+	pr, pw := io.Pipe()
+
+	leftErr := make(chan error, 1)
+	go func() {
+		err := s.Execute(redirected{Reader: output, Writer: piped{Writer: pw, to: output}, shell: s}, left)
+		pw.Close()
+		leftErr <- err
+	}()
+
+	err := s.run(output, right, pr)
+	// Anything right did not read is thrown away, so left is not left blocked writing it
+	pr.Close()
+
+	if err := <-leftErr; err != nil {
+		return err
+	}
+	return err
+}
+
+// attach makes sure a command can find this shell from its output
+func (s *Shell) attach(output io.ReadWriter) io.ReadWriter {
+	if ShellOf(output) == s {
+		return output
+	}
+
+	return redirected{Reader: output, Writer: output, shell: s}
+}
+
+func validVariable(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+func (s *Shell) SetVariable(name, value string) error {
+	if !validVariable(name) {
+		return fmt.Errorf("'%s' is not a valid variable name, use letters, numbers and underscores", name)
+	}
+
+	if s.variables == nil {
+		s.variables = make(map[string]string)
+	}
+	s.variables[name] = value
+
+	return nil
+}
+
+// Clone makes a shell running the same commands for the same Context, starting with a copy of this shell's variables, so that
+// lines can carry on running in the background after the console that started them has closed
+func (s *Shell) Clone() *Shell {
+	c := NewShell(s.functions, s.OutputDir)
+	c.Context = s.Context
+
+	for name, value := range s.variables {
+		c.SetVariable(name, value)
+	}
+
+	return c
+}
+
+func (s *Shell) UnsetVariable(name string) {
+	delete(s.variables, name)
+}
+
+func (s *Shell) Variable(name string) (value string, ok bool) {
+	value, ok = s.variables[name]
+	return
+}
+
+// Variables returns every variable as name=value, sorted by name
+func (s *Shell) Variables() (out []string) {
+	for name, value := range s.variables {
+		out = append(out, name+"="+value)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// Expand replaces $name and ${name} with the value of that variable, or nothing if it is unset. Text in single quotes and escaped
+// dollars are left as they are, keeping the escape so that parsing the line afterwards still sees a literal $. A backslash in single
+// quotes escapes nothing, as when the line is parsed
+func (s *Shell) Expand(line string) string {
+	var (
+		sb          strings.Builder
+		delimiter   byte
+		literalNext bool
+	)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case literalNext:
+			literalNext = false
+		case c == '\\' && delimiter != '\'':
+			literalNext = true
+		case delimiter != 0 && c == delimiter:
+			delimiter = 0
+		case delimiter == 0 && (c == '"' || c == '\'' || c == '`'):
+			delimiter = c
+		case c == '$' && delimiter != '\'':
+			if name, consumed := variableAt(line[i+1:]); consumed > 0 {
+				sb.WriteString(s.variables[name])
+				i += consumed
+				continue
+			}
+		}
+
+		sb.WriteByte(c)
+	}
+
+	return sb.String()
+}
+
+// variableAt reads a variable name from the start of s, returning how many bytes it took up, or 0 if there is no name there
+func variableAt(s string) (name string, consumed int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end == -1 || !validVariable(s[1:end]) {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+
+	for consumed < len(s) && validVariable(s[:consumed+1]) {
+		consumed++
+	}
+
+	return s[:consumed], consumed
+}
+
+// Words expands and returns the first n words after the command of a compound line, along with the rest of the line exactly as it was typed
+func (s *Shell) Words(line ParsedLine, n int) (words []string, rest string, err error) {
+	if line.Command == nil {
+		return nil, "", errors.New("no command")
+	}
+
+	raw := line.RawLine
+	pos := line.Command.End() + 1
+
+	for len(words) < n {
+		for pos < len(raw) && raw[pos] == ' ' {
+			pos++
+		}
+
+		if pos >= len(raw) {
+			return words, "", fmt.Errorf("expected %d words, got %d", n, len(words))
+		}
+
+		_, end := command.ParseArgument(raw, pos)
+		if raw[end] != ' ' {
+			end = len(raw)
+		}
+
+		word, _ := command.ParseArgument(s.Expand(raw[pos:end]), 0)
+		words = append(words, word.Value())
+
+		pos = end
+	}
+
+	return words, strings.TrimLeft(raw[pos:], " "), nil
+}
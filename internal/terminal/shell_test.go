@@ -0,0 +1,393 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type echoCommand struct{}
+
+func (e *echoCommand) Run(output io.ReadWriter, line ParsedLine) error {
+	fmt.Fprintf(output, "%s\n", strings.Join(line.ArgumentsAsStrings(), "|"))
+	return nil
+}
+
+func (e *echoCommand) Expect(line ParsedLine) []string { return nil }
+
+func (e *echoCommand) Help(explain bool) string { return "" }
+
+type repeatCommand struct {
+	echoCommand
+}
+
+func (r *repeatCommand) Compound() {}
+
+func (r *repeatCommand) Run(output io.ReadWriter, line ParsedLine) error {
+	shell := ShellOf(output)
+
+	words, command, err := shell.Words(line, 1)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range strings.Split(words[0], ",") {
+		shell.SetVariable("i", v)
+		if err := shell.Execute(output, command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestExpand(t *testing.T) {
+	s := NewShell(nil, "")
+	s.SetVariable("host", "web01")
+	s.SetVariable("os", "linux x86")
+
+	cases := map[string]string{
+		"exec $host uptime":      "exec web01 uptime",
+		"exec ${host}-b uptime":  "exec web01-b uptime",
+		"echo \"$os\"":           "echo \"linux x86\"",
+		"echo '$host'":           "echo '$host'",
+		"echo \"it's $host\"":    "echo \"it's web01\"",
+		"echo \\$host":           "echo \\$host",
+		"echo $missing.":         "echo .",
+		"echo $ ${ ${bad-name}":  "echo $ ${ ${bad-name}",
+		"echo $host$host":        "echo web01web01",
+		"echo price$5 $host_two": "echo price$5 ",
+		"echo 'C:\\' $host":      "echo 'C:\\' web01",
+	}
+
+	for line, expected := range cases {
+		if got := s.Expand(line); got != expected {
+			t.Errorf("%q: got %q expected %q", line, got, expected)
+		}
+	}
+}
+
+func TestVariableNames(t *testing.T) {
+	s := NewShell(nil, "")
+
+	for _, name := range []string{"a", "_x", "host2", "HOST_NAME"} {
+		if err := s.SetVariable(name, "v"); err != nil {
+			t.Errorf("%q should be a valid name: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "2host", "bad-name", "a b", "$a"} {
+		if err := s.SetVariable(name, "v"); err == nil {
+			t.Errorf("%q should not be a valid name", name)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	s := NewShell(nil, "")
+	s.SetVariable("os", "linux")
+
+	words, rest, err := s.Words(ParseLine("if \"$os\" == linux exec -y $host uptime > $host.txt", 0), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(words, "|") != "linux|==|linux" {
+		t.Errorf("unexpected words %q", words)
+	}
+
+	if rest != "exec -y $host uptime > $host.txt" {
+		t.Errorf("rest should be left as typed, got %q", rest)
+	}
+
+	words, _, err = s.Words(ParseLine("if \"\" == ''", 0), 3)
+	if err != nil || len(words) != 3 || words[0] != "" || words[2] != "" {
+		t.Errorf("empty quoted words should be kept, got %q %v", words, err)
+	}
+
+	if _, _, err := s.Words(ParseLine("if a ==", 0), 3); err == nil {
+		t.Error("expected too few words to fail")
+	}
+}
+
+type catCommand struct {
+	echoCommand
+}
+
+func (c *catCommand) ReadsInput() {}
+
+func (c *catCommand) Run(output io.ReadWriter, line ParsedLine) error {
+	input := InputOf(output)
+	if input == nil {
+		return fmt.Errorf("no input")
+	}
+
+	_, err := io.Copy(output, input)
+	return err
+}
+
+func TestInputRedirect(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("b\na\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewShell(CommandMap{
+		"echo": &echoCommand{},
+		"cat":  &catCommand{},
+	}, dir)
+
+	var out bytes.Buffer
+	rw := redirected{Reader: &out, Writer: &out}
+
+	if err := s.Execute(rw, "cat < data.txt > copy.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Execute(rw, "cat > again.txt < copy.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(dir, "again.txt")); string(b) != "b\na\n" {
+		t.Fatalf("expected the input copied through both files, got %q", b)
+	}
+
+	if err := s.Execute(rw, "echo < data.txt"); CategoryOf(err) != Usage {
+		t.Errorf("expected input redirected to a command that does not read it to be refused, got %v", err)
+	}
+
+	if err := s.Execute(rw, "cat < missing.txt"); err == nil {
+		t.Error("expected a missing input file to fail")
+	}
+}
+
+func TestPipe(t *testing.T) {
+	dir := t.TempDir()
+
+	s := NewShell(CommandMap{
+		"echo": &echoCommand{},
+		"cat":  &catCommand{},
+	}, dir)
+	s.SetVariable("p", "| cat")
+
+	for line, expected := range map[string]string{
+		"echo a b | cat":       "a|b\n",
+		"echo a | cat | cat":   "a\n",
+		"echo 'x | y' | cat":   "x | y\n",
+		"echo a | echo b":      "a|||echo|b\n",
+		"echo $p":              "||cat\n",
+		"echo a || cat":        "a||||cat\n",
+		"echo a | cat > p.txt": "",
+	} {
+		var out bytes.Buffer
+		if err := s.Execute(redirected{Reader: &out, Writer: &out}, line); err != nil {
+			t.Fatalf("%q: %s", line, err)
+		}
+
+		if out.String() != expected {
+			t.Errorf("%q: expected %q, got %q", line, expected, out.String())
+		}
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(dir, "p.txt")); string(b) != "a\n" {
+		t.Errorf("expected the end of the pipe redirected to the file, got %q", b)
+	}
+
+	var out bytes.Buffer
+	rw := redirected{Reader: &out, Writer: &out}
+
+	if err := s.Execute(rw, "missing | cat"); CategoryOf(err) != NotFound {
+		t.Errorf("expected the error of the left side, got %v", err)
+	}
+
+	if err := s.Execute(rw, "echo a | cat < p.txt"); CategoryOf(err) != Usage {
+		t.Errorf("expected a pipe and < together to be refused, got %v", err)
+	}
+}
+
+type declaredCommand struct {
+	echoCommand
+}
+
+func (d *declaredCommand) Flags() *FlagSet {
+	return &FlagSet{Flags: []FlagSpec{{Name: "count", Aliases: []string{"c"}, Type: FlagInt}}}
+}
+
+func (d *declaredCommand) Run(output io.ReadWriter, line ParsedLine) error {
+	n, err := line.GetArgInt("count")
+	fmt.Fprintf(output, "%d %v\n", n, err)
+	return nil
+}
+
+func TestDeclaredFlags(t *testing.T) {
+	s := NewShell(CommandMap{
+		"count": &declaredCommand{},
+	}, "")
+
+	var out bytes.Buffer
+	rw := redirected{Reader: &out, Writer: &out}
+
+	if err := s.Execute(rw, "count -c 3"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "3 <nil>\n" {
+		t.Errorf("expected the alias given to the command as --count, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := s.Execute(rw, "count --bogus"); CategoryOf(err) != Usage || out.Len() != 0 {
+		t.Errorf("expected an unknown flag to be refused before the command ran, got %v %q", err, out.String())
+	}
+}
+
+type groupCommand struct {
+	echoCommand
+}
+
+func (g *groupCommand) Subcommands() map[string]Command {
+	return map[string]Command{"count": &declaredCommand{}}
+}
+
+func (g *groupCommand) Run(output io.ReadWriter, line ParsedLine) error {
+	if ran, err := RunSubcommand(g, output, line); ran {
+		return err
+	}
+	return g.echoCommand.Run(output, line)
+}
+
+type wrappedCommand struct {
+	Command
+	ran *int
+}
+
+func (w *wrappedCommand) Unwrap() Command { return w.Command }
+
+func (w *wrappedCommand) Run(output io.ReadWriter, line ParsedLine) error {
+	*w.ran++
+	return w.Command.Run(output, line)
+}
+
+func TestSubcommands(t *testing.T) {
+	var ran int
+	s := NewShell(CommandMap{
+		"group": &wrappedCommand{Command: &groupCommand{}, ran: &ran},
+	}, "")
+
+	var out bytes.Buffer
+	rw := redirected{Reader: &out, Writer: &out}
+
+	for line, expected := range map[string]string{
+		"group count -c 2": "2 <nil>\n",
+		"group other -c 2": "other|2\n",
+	} {
+		out.Reset()
+		if err := s.Execute(rw, line); err != nil {
+			t.Fatalf("%q: %s", line, err)
+		}
+		if out.String() != expected {
+			t.Errorf("%q: expected %q, got %q", line, expected, out.String())
+		}
+	}
+
+	if ran != 2 {
+		t.Errorf("expected the wrapper to run for every line, ran %d times", ran)
+	}
+
+	if err := s.Execute(rw, "group count --bogus"); CategoryOf(err) != Usage {
+		t.Errorf("expected the subcommand's flags to be checked, got %v", err)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	s := NewShell(CommandMap{
+		"echo":   &echoCommand{},
+		"repeat": &repeatCommand{},
+	}, "")
+	s.SetVariable("i", "outer")
+
+	var out bytes.Buffer
+	rw := redirected{Reader: &out, Writer: &out}
+
+	for _, line := range []string{
+		"# a comment",
+		"echo $i",
+		"repeat a,b echo item $i",
+		"",
+	} {
+		if err := s.Execute(rw, line); err != nil {
+			t.Fatalf("%q: %s", line, err)
+		}
+	}
+
+	if out.String() != "outer\nitem|a\nitem|b\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	if err := s.Execute(rw, "missing"); err == nil {
+		t.Error("expected an unknown command to fail")
+	}
+}
+
+func TestSharedCommands(t *testing.T) {
+	shared := CommandMap{}
+	first, second := NewShell(shared, ""), NewShell(shared, "")
+	first.Context, second.Context = "first", "second"
+
+	var out bytes.Buffer
+	rw := redirected{Reader: &out, Writer: &out}
+
+	if err := first.Execute(rw, "echo before"); err == nil {
+		t.Error("expected a command not added yet to be unknown")
+	}
+
+	shared["echo"] = &echoCommand{}
+	shared["context"] = &contextCommand{}
+	for _, s := range []*Shell{first, second} {
+		if err := s.Execute(rw, "context"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out.String() != "first\nsecond\n" {
+		t.Errorf("expected each shell to give commands its own context, got %q", out.String())
+	}
+}
+
+func TestClone(t *testing.T) {
+	s := NewShell(CommandMap{"echo": &echoCommand{}, "context": &contextCommand{}}, "")
+	s.Context = "console"
+	s.SetVariable("i", "before")
+
+	c := s.Clone()
+	s.SetVariable("i", "after")
+	c.SetVariable("j", "clone")
+
+	var out bytes.Buffer
+	rw := redirected{Reader: &out, Writer: &out}
+	for _, line := range []string{"echo $i $j", "context"} {
+		if err := c.Execute(rw, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out.String() != "before|clone\nconsole\n" {
+		t.Errorf("expected the clone to keep the variables it started with and the same context, got %q", out.String())
+	}
+
+	if _, ok := s.Variable("j"); ok {
+		t.Error("expected variables set on the clone to stay there")
+	}
+}
+
+type contextCommand struct {
+	echoCommand
+}
+
+func (c *contextCommand) Run(output io.ReadWriter, line ParsedLine) error {
+	fmt.Fprintf(output, "%v\n", ShellOf(output).Context)
+	return nil
+}
@@ -18,7 +18,9 @@ import (
 	"unicode/utf8"
 
 	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/i18n"
 	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/pkg/command"
 	"github.com/NHAS/reverse_ssh/pkg/trie"
 )
 
@@ -54,6 +56,8 @@ var vt100EscapeCodes = EscapeCodes{
 // Terminal contains the state for running a VT100 terminal that is capable of
 // reading lines of input.
 type Terminal struct {
+	Shell
+
 	user   *internal.User
 	cancel chan bool
 
@@ -64,6 +68,18 @@ type Terminal struct {
 	// and the new cursor position.
 	AutoCompleteCallback func(term *Terminal, line string, pos int, key rune) (newLine string, newPos int, ok bool)
 
+	// PromptCallback, if non-nil, is called before each line is read and
+	// replaces the prompt, so the prompt can show state that changes between commands.
+	PromptCallback func() string
+
+	// TitleCallback, if non-nil, is called before each line is read to set
+	// the window title of the terminal.
+	TitleCallback func() string
+
+	// LineCallback, if non-nil, is given each line as it was typed, before
+	// it is run.
+	LineCallback func(line string)
+
 	// Escape contains a pointer to the escape codes for this terminal.
 	// It's always a valid pointer, although the escape codes themselves
 	// may be empty if the terminal doesn't support them.
@@ -117,12 +133,16 @@ type Terminal struct {
 	autoCompletePendng                 string
 	autoCompleting                     bool
 
-	functions             map[string]Command
 	functionsAutoComplete *trie.Trie
 
 	autoCompleteValues map[string]*trie.Trie
 
 	raw bool
+
+	// dumb is set when the operator's terminal understands no escape sequences, the line is then edited with backspaces alone
+	dumb bool
+	// secret is set while a password is read, so it is not kept in the history
+	secret bool
 }
 
 func (t *Terminal) EnableRaw() {
@@ -135,6 +155,14 @@ func (t *Terminal) EnableRaw() {
 	}
 }
 
+// Raw reports whether keys and output are passed straight through, as they are while attached to a client
+func (t *Terminal) Raw() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.raw
+}
+
 func (t *Terminal) DisableRaw() {
 	t.lock.Lock()
 	defer t.lock.Unlock()
@@ -174,12 +202,23 @@ func NewAdvancedTerminal(c io.ReadWriter, user *internal.User, prompt string) *T
 		historyIndex:          -1,
 		AutoCompleteCallback:  defaultAutoComplete,
 		functionsAutoComplete: trie.NewTrie(),
-		functions:             make(map[string]Command),
+		Shell:                 Shell{functions: CommandMap{}},
 		autoCompleteValues:    make(map[string]*trie.Trie),
 	}
 
 	t.AddValueAutoComplete(autocomplete.Functions, t.functionsAutoComplete)
 
+	if user != nil && user.Pty != nil {
+		if user.Pty.Dumb() {
+			t.dumb = true
+			t.Escape = &EscapeCodes{}
+		}
+
+		if echo, ok := user.Pty.Mode(internal.ModeECHO); ok && echo == 0 {
+			t.echo = false
+		}
+	}
+
 	t.handleWindowSize()
 
 	return t
@@ -271,15 +310,34 @@ func defaultAutoComplete(term *Terminal, line string, pos int, key rune) (newLin
 		}
 		parsedLine := ParseLine(term.autoCompletePendng, term.autoCompletePos)
 
-		var matches []string
+		var (
+			matches              []string
+			completingSubcommand bool
+		)
 		if parsedLine.Command == nil {
 			matches = term.functionsAutoComplete.PrefixMatch("")
 		} else {
 			if parsedLine.Focus != nil && parsedLine.Focus.Start() == 0 {
 				matches = term.functionsAutoComplete.PrefixMatch(parsedLine.Focus.Value())
 			} else {
-				if function, ok := term.functions[parsedLine.Command.Value()]; ok {
-					expected := function.Expect(parsedLine)
+				if start, end := lastRedirect(term.autoCompletePendng); start != -1 && term.autoCompletePos > end {
+					partial := ""
+					if parsedLine.Focus != nil && parsedLine.Focus.Start() > end {
+						partial = parsedLine.Focus.Value()
+					}
+
+					matches = term.outputFiles(partial)
+				} else if function, ok := term.command(parsedLine.Command.Value()); ok {
+					names, atSubcommand := subcommandsAt(function, &parsedLine)
+					function = subcommandOf(function, &parsedLine)
+
+					var expected []string
+					if atSubcommand {
+						expected = names
+						completingSubcommand = true
+					} else {
+						expected = function.Expect(parsedLine)
+					}
 
 					if expected != nil {
 
@@ -314,7 +372,7 @@ func defaultAutoComplete(term *Terminal, line string, pos int, key rune) (newLin
 			term.resetAutoComplete()
 
 			output, newPos := buildDisplayLine(parsedLine.Focus, line, matches[0], pos)
-			if parsedLine.Focus != nil && parsedLine.Focus.Type() == (Cmd{}.Type()) {
+			if completingSubcommand || parsedLine.Focus != nil && parsedLine.Focus.Type() == (Cmd{}.Type()) {
 				output += " "
 				newPos += 1
 			}
@@ -350,8 +408,7 @@ func buildDisplayLine(focus Node, line string, match string, currentPos int) (ou
 	}
 
 	switch focus.Type() {
-	case Cmd{}.Type():
-	case Argument{}.Type():
+	case Cmd{}.Type(), Argument{}.Type():
 		output = line[:focus.Start()]
 	case Flag{}.Type():
 		output = line[:focus.End()] + " "
@@ -485,21 +542,27 @@ func bytesToKey(b []byte, pasteActive bool) (rune, []byte) {
 	return utf8.RuneError, b
 }
 
-func (t *Terminal) AddCommands(m map[string]Command) error {
+// UseCommands sets the commands of the console, with names as the command names to tab complete. Both are read as the console
+// runs, so commands added to them later show up straight away
+func (t *Terminal) UseCommands(c Commands, names *trie.Trie) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	t.functions = m
-
-	for k := range t.functions {
-		t.functionsAutoComplete.Add(k)
-	}
-
-	return nil
+	t.functions = c
+	t.functionsAutoComplete = names
+	t.autoCompleteValues[autocomplete.Functions] = names
 }
 
 func (t *Terminal) Run() error {
 	for {
+		if t.PromptCallback != nil {
+			t.SetPrompt(t.PromptCallback())
+		}
+
+		if t.TitleCallback != nil {
+			t.SetTitle(t.TitleCallback())
+		}
+
 		//This will break if the user does CTRL+D apparently we need to reset the whole terminal if a user does this.... so just exit instead
 		line, err := t.ReadLine()
 		if err != nil {
@@ -509,25 +572,44 @@ func (t *Terminal) Run() error {
 			return err
 		}
 
-		parsedLine := ParseLine(line, t.pos)
+		if t.LineCallback != nil {
+			t.LineCallback(line)
+		}
 
-		if parsedLine.Command != nil {
-			f, ok := t.functions[parsedLine.Command.Value()]
-			if !ok {
-				fmt.Fprintf(t, "Unknown command: %s\n", parsedLine.Command.Value())
-				continue
+		err = t.Execute(t, line)
+		if err != nil {
+			if err == io.EOF {
+				return err
 			}
 
-			err = f.Run(t, parsedLine)
-			if err != nil {
-				if err == io.EOF {
-					return err
-				}
+			t.printError(err)
+		}
+	}
+}
 
-				fmt.Fprintf(t, "%s\n", err)
-			}
+// printError shows a command's Error in the colour of its category. Usage errors are help text and other errors, such as connect
+// saying the session has ended, arent always failures so both are left as they are
+func (t *Terminal) printError(err error) {
+	message := i18n.Text(t.Language(), err.Error())
+
+	var colour []byte
+	if e := (*command.Error)(nil); errors.As(err, &e) {
+		switch e.Category {
+		case command.NotFound:
+			colour = t.Escape.Yellow
+		case command.Transport:
+			colour = t.Escape.Magenta
+		case command.Permission, command.Failed:
+			colour = t.Escape.Red
 		}
 	}
+
+	if colour == nil || t.Plain() {
+		fmt.Fprintf(t, "%s\n", message)
+		return
+	}
+
+	fmt.Fprintf(t, "%s%s%s\n", colour, message, t.Escape.Reset)
 }
 
 // queue appends data to the end of t.outBuf
@@ -574,6 +656,23 @@ func (t *Terminal) moveCursorToPos(pos int) {
 		right = x - t.cursorX
 	}
 
+	if t.dumb {
+		// Back is a backspace and forward is writing out again what is passed over, neither can leave the row
+		if left > 0 {
+			t.queue([]rune(strings.Repeat("\b", left)))
+		}
+
+		prompt := visualLength(t.prompt)
+		from, to := y*t.termWidth+t.cursorX-prompt, y*t.termWidth+x-prompt
+		if right > 0 && from >= 0 && to <= len(t.line) {
+			t.queue(t.line[from:to])
+		}
+
+		t.cursorX = x
+		t.cursorY = y
+		return
+	}
+
 	t.cursorX = x
 	t.cursorY = y
 	t.move(up, down, left, right)
@@ -639,6 +738,10 @@ func (t *Terminal) Read(b []byte) (n int, err error) {
 }
 
 func (t *Terminal) clearLineToRight() {
+	if t.dumb {
+		return
+	}
+
 	op := []rune{keyEscape, '[', 'K'}
 	t.queue(op)
 }
@@ -897,8 +1000,12 @@ func (t *Terminal) handleKey(key rune) (line string, ok bool) {
 	case keyCtrlU:
 		t.eraseNPreviousChars(t.pos)
 	case keyClearScreen:
-		// Erases the screen and moves the cursor to the home position.
-		t.queue([]rune("\x1b[2J\x1b[H"))
+		// Erases the screen and moves the cursor to the home position, a dumb terminal can only start a new line
+		if t.dumb {
+			t.queue([]rune("\r\n"))
+		} else {
+			t.queue([]rune("\x1b[2J\x1b[H"))
+		}
 		t.queue(t.prompt)
 		t.cursorX, t.cursorY = 0, 0
 		t.advanceCursor(visualLength(t.prompt))
@@ -1003,7 +1110,13 @@ func (t *Terminal) Write(buf []byte) (n int, err error) {
 	}
 
 	// We have a prompt and possibly user input on the screen. We
-	// have to clear it first.
+	// have to clear it first. A dumb terminal cannot, so the output
+	// goes on the line below it instead.
+	if t.dumb {
+		t.queue([]rune("\r\n"))
+		t.cursorX, t.cursorY = 0, 0
+	}
+
 	t.move(0 /* up */, 0 /* down */, t.cursorX /* left */, 0 /* right */)
 	t.cursorX = 0
 	t.clearLineToRight()
@@ -1043,14 +1156,31 @@ func (t *Terminal) ReadPassword(prompt string) (line string, err error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	oldPrompt := t.prompt
+	oldPrompt, oldEcho := t.prompt, t.echo
 	t.prompt = []rune(prompt)
 	t.echo = false
+	t.secret = true
+
+	line, err = t.readLine()
+
+	t.prompt = oldPrompt
+	t.echo = oldEcho
+	t.secret = false
+
+	return
+}
+
+// ReadLineWithPrompt temporarily changes the prompt and reads a line of input from the terminal.
+func (t *Terminal) ReadLineWithPrompt(prompt string) (line string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	oldPrompt := t.prompt
+	t.prompt = []rune(prompt)
 
 	line, err = t.readLine()
 
 	t.prompt = oldPrompt
-	t.echo = true
 
 	return
 }
@@ -1120,7 +1250,7 @@ func (t *Terminal) readLine() (line string, err error) {
 		t.c.Write(t.outBuf)
 		t.outBuf = t.outBuf[:0]
 		if lineOk {
-			if t.echo {
+			if !t.secret {
 				t.historyIndex = -1
 				line2 := strings.TrimSpace(line)
 				if line2 != "" {
@@ -1150,6 +1280,44 @@ func (t *Terminal) readLine() (line string, err error) {
 	}
 }
 
+// Plain reports whether the operator asked for accessible output, see table.Plain
+func (t *Terminal) Plain() bool {
+	return t.user != nil && t.user.Accessible
+}
+
+// Dumb reports whether the operator's terminal, going by its TERM, understands no escape sequences. Escape is empty when it is
+func (t *Terminal) Dumb() bool {
+	return t.dumb
+}
+
+// Language is the language the operator wants help and messages in, see i18n.Of
+func (t *Terminal) Language() string {
+	if t.user == nil {
+		return ""
+	}
+	return t.user.Lang
+}
+
+// SetTitle sets the window title with an OSC escape sequence. Control characters are dropped as
+// the title is often made from client controlled values, such as hostnames.
+func (t *Terminal) SetTitle(title string) {
+	if t.Plain() || t.dumb {
+		return
+	}
+
+	clean := strings.Map(func(r rune) rune {
+		if r < 0x20 || (r >= 0x7f && r < 0xa0) {
+			return -1
+		}
+		return r
+	}, title)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.c.Write([]byte("\x1b]0;" + clean + "\x07"))
+}
+
 // SetPrompt sets the prompt to be used when reading subsequent lines.
 func (t *Terminal) SetPrompt(prompt string) {
 	t.lock.Lock()
@@ -1195,6 +1363,9 @@ func (t *Terminal) SetSize(width, height int) error {
 		// If the width didn't change then nothing else needs to be
 		// done.
 		return nil
+	case t.dumb:
+		// Nothing can be redrawn
+		return nil
 	case len(t.line) == 0 && t.cursorX == 0 && t.cursorY == 0:
 		// If there is nothing on current line and no prompt printed,
 		// just do nothing
@@ -1250,6 +1421,10 @@ var ErrPasteIndicator = pasteIndicatorError{}
 // pastes. Additionally, any lines that are completely pasted will be returned
 // from ReadLine with the error set to ErrPasteIndicator.
 func (t *Terminal) SetBracketedPasteMode(on bool) {
+	if t.dumb {
+		return
+	}
+
 	if on {
 		io.WriteString(t.c, "\x1b[?2004h")
 	} else {
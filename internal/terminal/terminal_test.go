@@ -0,0 +1,171 @@
+package terminal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+func TestPrintError(t *testing.T) {
+	var out bytes.Buffer
+	user := &internal.User{}
+	term := NewAdvancedTerminal(&out, user, "> ")
+
+	for _, c := range []struct {
+		err      error
+		expected string
+	}{
+		{Errorf(NotFound, "No clients matched 'x'"), "\x1b[33mNo clients matched 'x'\x1b[0m\r\n"},
+		{Errorf(Permission, "Only admins can do that"), "\x1b[31mOnly admins can do that\x1b[0m\r\n"},
+		{Errorf(Usage, "usage: thing"), "usage: thing\r\n"},
+		{errors.New("Session has terminated."), "Session has terminated.\r\n"},
+	} {
+		out.Reset()
+		term.printError(c.err)
+		if out.String() != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, out.String())
+		}
+	}
+
+	user.Accessible = true
+
+	out.Reset()
+	term.printError(Errorf(Permission, "Only admins can do that"))
+	if out.String() != "Only admins can do that\r\n" {
+		t.Errorf("expected no colour for plain output, got %q", out.String())
+	}
+}
+
+type keys struct {
+	bytes.Buffer
+	typed [][]byte
+}
+
+// Read hands out one key press at a time, as they would arrive from an operator
+func (k *keys) Read(b []byte) (int, error) {
+	if len(k.typed) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, k.typed[0])
+	k.typed = k.typed[1:]
+	return n, nil
+}
+
+func TestPick(t *testing.T) {
+	user := &internal.User{}
+	options := []string{"first", "second", "third"}
+
+	for _, c := range []struct {
+		typed    []string
+		plain    bool
+		expected int
+		err      error
+	}{
+		{[]string{"\r"}, false, 0, nil},
+		{[]string{"\x1b[B", "\x1b[B", "\r"}, false, 2, nil},
+		{[]string{"\x1b[A", "\r"}, false, 2, nil},
+		{[]string{"2", "\r"}, false, 1, nil},
+		{[]string{"9", "3", "\r"}, false, 2, nil},
+		{[]string{"\x1b[B", "q"}, false, -1, ErrNotPicked},
+		{[]string{"\r", "\x1b[B", "2", "\r"}, true, 1, nil},
+	} {
+		input := &keys{}
+		for _, k := range c.typed {
+			input.typed = append(input.typed, []byte(k))
+		}
+
+		user.Accessible = c.plain
+		term := NewAdvancedTerminal(input, user, "> ")
+
+		picked, err := term.Pick("pick one:", options)
+		if picked != c.expected || err != c.err {
+			t.Errorf("expected typing %q to pick %d (%v), got %d (%v)", c.typed, c.expected, c.err, picked, err)
+		}
+
+		if c.plain && strings.Contains(input.String(), "\x1b") {
+			t.Errorf("expected no escape codes when the operator asked for plain output, got %q", input.String())
+		}
+	}
+}
+
+func TestDumb(t *testing.T) {
+	input := &keys{}
+	for _, k := range []string{"a", "b", "\x1b[D", "c", "\x1b[C", "d", "\r"} {
+		input.typed = append(input.typed, []byte(k))
+	}
+
+	term := NewAdvancedTerminal(input, &internal.User{Pty: &internal.PtyReq{Term: "dumb"}}, "> ")
+
+	line, err := term.ReadLine()
+	if err != nil || line != "acbd" {
+		t.Errorf("expected the line to be edited with the cursor keys, got %q (%v)", line, err)
+	}
+
+	term.printError(Errorf(Permission, "Only admins can do that"))
+	term.SetTitle("server")
+
+	if strings.Contains(input.String(), "\x1b") {
+		t.Errorf("expected no escape codes on a dumb terminal, got %q", input.String())
+	}
+
+	if !strings.Contains(input.String(), "> ab\bcb\bbd") {
+		t.Errorf("expected the cursor to be moved with backspaces and by writing the line again, got %q", input.String())
+	}
+}
+
+func TestPickMouse(t *testing.T) {
+	options := []string{"first", "second", "third"}
+
+	for _, c := range []struct {
+		typed    []string
+		term     string
+		expected int
+	}{
+		// The list ends above row 10, so the options are on rows 7 to 9
+		{[]string{"\x1b[10;1R", "\x1b[<0;5;8M"}, "xterm-256color", 1},
+		{[]string{"\x1b[10;1R", "\x1b[<0;5;3M", "\x1b[<65;5;3M\x1b[<65;5;3M", "\r"}, "xterm-256color", 2},
+		{[]string{"\x1b[<64;5;3M", "\x1b[<65;5;3M", "\r"}, "screen", 1},
+		// A click before the terminal said where the list is could be anywhere
+		{[]string{"\x1b[<0;5;8M", "\r"}, "xterm", 0},
+	} {
+		input := &keys{}
+		for _, k := range c.typed {
+			input.typed = append(input.typed, []byte(k))
+		}
+
+		term := NewAdvancedTerminal(input, &internal.User{Pty: &internal.PtyReq{Term: c.term}}, "> ")
+
+		picked, err := term.Pick("pick one:", options)
+		if picked != c.expected || err != nil {
+			t.Errorf("expected %q to pick %d, got %d (%v)", c.typed, c.expected, picked, err)
+		}
+
+		if !strings.HasPrefix(input.String(), mouseOn) || !strings.HasSuffix(input.String(), mouseOff) {
+			t.Errorf("expected mouse reporting to be turned on and back off, got %q", input.String())
+		}
+	}
+
+	input := &keys{typed: [][]byte{[]byte("\r")}}
+	if _, err := NewAdvancedTerminal(input, &internal.User{Pty: &internal.PtyReq{Term: "vt100"}}, "> ").Pick("pick one:", options); err != nil {
+		t.Fatal(err)
+	}
+
+	SetMouse(false)
+	defer SetMouse(true)
+
+	input2 := &keys{typed: [][]byte{[]byte("\r")}}
+	if _, err := NewAdvancedTerminal(input2, &internal.User{Pty: &internal.PtyReq{Term: "xterm"}}, "> ").Pick("pick one:", options); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, out := range []string{input.String(), input2.String()} {
+		if strings.Contains(out, mouseOn) {
+			t.Errorf("expected no mouse reporting on a terminal without it or with it turned off, got %q", out)
+		}
+	}
+}
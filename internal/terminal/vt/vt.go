@@ -0,0 +1,607 @@
+// Package vt keeps what a program writing to a terminal would have on screen, so it can be drawn into part of another terminal
+package vt
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Styles that have grown past this without a reset are cut down to their most recent attributes
+const maxStyle = 128
+
+// Cell is a character on the screen, with the SGR parameters it was written with. An empty Style is the default
+type Cell struct {
+	Rune  rune
+	Style string
+}
+
+type parseState int
+
+const (
+	ground parseState = iota
+	escape
+	csi
+	// OSC, DCS and the like, which are skipped to the string terminator
+	str
+	strEscape
+	// The byte after ESC ( and similar picks a character set, which is ignored
+	charset
+)
+
+// Screen is a grid of cells fed with the output of a program, it understands enough of VT100 and xterm for shells, pagers and
+// editors. Every character is taken to be one column wide
+type Screen struct {
+	mu sync.Mutex
+
+	width, height int
+	cells         [][]Cell
+	dirty         []bool
+
+	x, y     int
+	wrapNext bool
+	style    string
+	hidden   bool
+
+	// The scroll region, inclusive
+	top, bottom int
+
+	savedX, savedY int
+	savedStyle     string
+
+	// The main screen, while the program is on the alternate one
+	main [][]Cell
+
+	state   parseState
+	params  []byte
+	partial []byte
+}
+
+func New(width, height int) *Screen {
+	s := &Screen{}
+	s.Resize(width, height)
+	return s
+}
+
+func blank(width int) []Cell {
+	return make([]Cell, width)
+}
+
+// Resize changes the size of the screen keeping what fits, the cursor is kept on screen
+func (s *Screen) Resize(width, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	resize := func(cells [][]Cell) [][]Cell {
+		// Keep the bottom of the screen when it shrinks, that is where the cursor usually is
+		if len(cells) > height {
+			cells = cells[len(cells)-height:]
+		}
+
+		out := make([][]Cell, height)
+		for i := range out {
+			out[i] = blank(width)
+			if i < len(cells) {
+				copy(out[i], cells[i])
+			}
+		}
+		return out
+	}
+
+	if s.height > height {
+		s.y -= s.height - height
+	}
+
+	s.cells = resize(s.cells)
+	if s.main != nil {
+		s.main = resize(s.main)
+	}
+
+	s.width, s.height = width, height
+	s.top, s.bottom = 0, height-1
+	s.dirty = make([]bool, height)
+	s.touchAll()
+	s.clamp()
+}
+
+func (s *Screen) Size() (width, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.width, s.height
+}
+
+// Cursor is where the next character will be written, from 0
+func (s *Screen) Cursor() (x, y int, visible bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.x, s.y, !s.hidden
+}
+
+// Line is the text of row y with trailing blanks removed
+func (s *Screen) Line(y int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if y < 0 || y >= s.height {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, c := range s.cells[y] {
+		if c.Rune == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		b.WriteRune(c.Rune)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// Touch has the whole screen drawn again on the next Render
+func (s *Screen) Touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.touchAll()
+}
+
+func (s *Screen) touchAll() {
+	for i := range s.dirty {
+		s.dirty[i] = true
+	}
+}
+
+// Render writes the rows that changed since the last Render to out, as escape sequences drawing them with their top left corner
+// at row, column of the terminal out goes to, both from 1
+func (s *Screen) Render(out *bytes.Buffer, row, column int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for y, line := range s.cells {
+		if !s.dirty[y] {
+			continue
+		}
+		s.dirty[y] = false
+
+		fmt.Fprintf(out, "\x1b[%d;%dH\x1b[0m", row+y, column)
+		style := ""
+		for _, c := range line {
+			if c.Style != style {
+				style = c.Style
+				if style == "" {
+					out.WriteString("\x1b[0m")
+				} else {
+					fmt.Fprintf(out, "\x1b[0;%sm", style)
+				}
+			}
+
+			if c.Rune < ' ' {
+				out.WriteByte(' ')
+				continue
+			}
+			out.WriteRune(c.Rune)
+		}
+		out.WriteString("\x1b[0m")
+	}
+}
+
+func (s *Screen) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range b {
+		s.feed(c)
+	}
+	return len(b), nil
+}
+
+func (s *Screen) feed(c byte) {
+	switch s.state {
+	case escape:
+		s.escape(c)
+		return
+	case csi:
+		if c >= 0x40 && c <= 0x7e {
+			s.state = ground
+			s.csi(c)
+			return
+		}
+		if len(s.params) < 64 {
+			s.params = append(s.params, c)
+		}
+		return
+	case str:
+		switch c {
+		case 0x07:
+			s.state = ground
+		case 0x1b:
+			s.state = strEscape
+		}
+		return
+	case strEscape:
+		if c == '\\' {
+			s.state = ground
+		} else {
+			s.state = str
+		}
+		return
+	case charset:
+		s.state = ground
+		return
+	}
+
+	if len(s.partial) > 0 || c >= utf8.RuneSelf {
+		s.partial = append(s.partial, c)
+		if !utf8.FullRune(s.partial) {
+			return
+		}
+
+		r, _ := utf8.DecodeRune(s.partial)
+		s.partial = s.partial[:0]
+		s.print(r)
+		return
+	}
+
+	switch c {
+	case 0x1b:
+		s.state = escape
+	case '\r':
+		s.x = 0
+		s.wrapNext = false
+	case '\n', '\v', '\f':
+		s.index()
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapNext = false
+	case '\t':
+		s.x = (s.x/8 + 1) * 8
+		s.clamp()
+	default:
+		if c >= ' ' && c != 0x7f {
+			s.print(rune(c))
+		}
+	}
+}
+
+func (s *Screen) print(r rune) {
+	if s.wrapNext {
+		s.wrapNext = false
+		s.x = 0
+		s.index()
+	}
+
+	s.cells[s.y][s.x] = Cell{Rune: r, Style: s.style}
+	s.dirty[s.y] = true
+
+	if s.x == s.width-1 {
+		s.wrapNext = true
+	} else {
+		s.x++
+	}
+}
+
+func (s *Screen) clamp() {
+	if s.x < 0 {
+		s.x = 0
+	}
+	if s.x >= s.width {
+		s.x = s.width - 1
+	}
+	if s.y < 0 {
+		s.y = 0
+	}
+	if s.y >= s.height {
+		s.y = s.height - 1
+	}
+}
+
+// index moves the cursor down a line, scrolling when it is at the bottom of the scroll region
+func (s *Screen) index() {
+	if s.y == s.bottom {
+		s.scrollUp(1)
+		return
+	}
+	if s.y < s.height-1 {
+		s.y++
+	}
+}
+
+func (s *Screen) reverseIndex() {
+	if s.y == s.top {
+		s.scrollDown(1)
+		return
+	}
+	if s.y > 0 {
+		s.y--
+	}
+}
+
+// scrollUp moves the lines of the scroll region from top+n up to the top, blanking those left at the bottom
+func (s *Screen) scrollUp(n int) {
+	s.deleteLines(s.top, n)
+}
+
+func (s *Screen) scrollDown(n int) {
+	s.insertLines(s.top, n)
+}
+
+func (s *Screen) deleteLines(at, n int) {
+	if at < s.top || at > s.bottom {
+		return
+	}
+	if n > s.bottom-at+1 {
+		n = s.bottom - at + 1
+	}
+
+	copy(s.cells[at:s.bottom+1], s.cells[at+n:s.bottom+1])
+	for i := s.bottom - n + 1; i <= s.bottom; i++ {
+		s.cells[i] = blank(s.width)
+	}
+	for i := at; i <= s.bottom; i++ {
+		s.dirty[i] = true
+	}
+}
+
+func (s *Screen) insertLines(at, n int) {
+	if at < s.top || at > s.bottom {
+		return
+	}
+	if n > s.bottom-at+1 {
+		n = s.bottom - at + 1
+	}
+
+	copy(s.cells[at+n:s.bottom+1], s.cells[at:s.bottom+1-n])
+	for i := at; i < at+n; i++ {
+		s.cells[i] = blank(s.width)
+	}
+	for i := at; i <= s.bottom; i++ {
+		s.dirty[i] = true
+	}
+}
+
+func (s *Screen) erase(y, from, to int) {
+	if from < 0 {
+		from = 0
+	}
+	if to > s.width {
+		to = s.width
+	}
+
+	for x := from; x < to; x++ {
+		s.cells[y][x] = Cell{}
+	}
+	s.dirty[y] = true
+}
+
+func (s *Screen) escape(c byte) {
+	s.state = ground
+
+	switch c {
+	case '[':
+		s.state = csi
+		s.params = s.params[:0]
+	case ']', 'P', 'X', '^', '_':
+		s.state = str
+	case '(', ')', '*', '+', '#', '%':
+		s.state = charset
+	case '7':
+		s.savedX, s.savedY, s.savedStyle = s.x, s.y, s.style
+	case '8':
+		s.x, s.y, s.style = s.savedX, s.savedY, s.savedStyle
+		s.wrapNext = false
+		s.clamp()
+	case 'D':
+		s.index()
+	case 'E':
+		s.x = 0
+		s.index()
+	case 'M':
+		s.reverseIndex()
+	case 'c':
+		s.main = nil
+		s.style, s.hidden = "", false
+		s.top, s.bottom = 0, s.height-1
+		s.x, s.y, s.wrapNext = 0, 0, false
+		for y := range s.cells {
+			s.erase(y, 0, s.width)
+		}
+	}
+}
+
+func (s *Screen) csi(final byte) {
+	private := len(s.params) > 0 && s.params[0] == '?'
+	raw := string(s.params)
+	if private {
+		raw = raw[1:]
+	}
+
+	var params []int
+	if raw != "" {
+		for _, p := range strings.Split(raw, ";") {
+			n, _ := strconv.Atoi(p)
+			params = append(params, n)
+		}
+	}
+
+	// The first parameter, with zero or missing taken as 1
+	n := 1
+	if len(params) > 0 && params[0] > 0 {
+		n = params[0]
+	}
+
+	arg := func(i, def int) int {
+		if i < len(params) && params[i] > 0 {
+			return params[i]
+		}
+		return def
+	}
+
+	mode := 0
+	if len(params) > 0 {
+		mode = params[0]
+	}
+
+	if final != 'm' {
+		s.wrapNext = false
+	}
+
+	switch final {
+	case 'A':
+		s.y -= n
+	case 'B', 'e':
+		s.y += n
+	case 'C', 'a':
+		s.x += n
+	case 'D':
+		s.x -= n
+	case 'E':
+		s.x = 0
+		s.y += n
+	case 'F':
+		s.x = 0
+		s.y -= n
+	case 'G', '`':
+		s.x = n - 1
+	case 'd':
+		s.y = n - 1
+	case 'H', 'f':
+		s.y, s.x = arg(0, 1)-1, arg(1, 1)-1
+	case 'J':
+		switch mode {
+		case 0:
+			s.erase(s.y, s.x, s.width)
+			for y := s.y + 1; y < s.height; y++ {
+				s.erase(y, 0, s.width)
+			}
+		case 1:
+			s.erase(s.y, 0, s.x+1)
+			for y := 0; y < s.y; y++ {
+				s.erase(y, 0, s.width)
+			}
+		default:
+			for y := range s.cells {
+				s.erase(y, 0, s.width)
+			}
+		}
+	case 'K':
+		switch mode {
+		case 0:
+			s.erase(s.y, s.x, s.width)
+		case 1:
+			s.erase(s.y, 0, s.x+1)
+		default:
+			s.erase(s.y, 0, s.width)
+		}
+	case 'L':
+		s.insertLines(s.y, n)
+	case 'M':
+		s.deleteLines(s.y, n)
+	case 'S':
+		s.scrollUp(n)
+	case 'T':
+		s.scrollDown(n)
+	case '@':
+		line := s.cells[s.y]
+		if n > s.width-s.x {
+			n = s.width - s.x
+		}
+		copy(line[s.x+n:], line[s.x:])
+		s.erase(s.y, s.x, s.x+n)
+	case 'P':
+		line := s.cells[s.y]
+		if n > s.width-s.x {
+			n = s.width - s.x
+		}
+		copy(line[s.x:], line[s.x+n:])
+		s.erase(s.y, s.width-n, s.width)
+	case 'X':
+		s.erase(s.y, s.x, s.x+n)
+	case 'r':
+		top, bottom := arg(0, 1)-1, arg(1, s.height)-1
+		if top < bottom && bottom < s.height {
+			s.top, s.bottom = top, bottom
+			s.x, s.y = 0, 0
+		}
+	case 's':
+		s.savedX, s.savedY, s.savedStyle = s.x, s.y, s.style
+	case 'u':
+		s.x, s.y, s.style = s.savedX, s.savedY, s.savedStyle
+	case 'm':
+		s.sgr(raw)
+	case 'h', 'l':
+		if private {
+			s.privateMode(params, final == 'h')
+		}
+	}
+
+	s.clamp()
+}
+
+func (s *Screen) sgr(raw string) {
+	if raw == "" || raw == "0" {
+		s.style = ""
+		return
+	}
+
+	if strings.HasPrefix(raw, "0;") {
+		s.style = ""
+		raw = raw[2:]
+	}
+
+	if s.style == "" {
+		s.style = raw
+	} else {
+		s.style += ";" + raw
+	}
+
+	if len(s.style) > maxStyle {
+		s.style = s.style[len(s.style)-maxStyle:]
+		if i := strings.IndexByte(s.style, ';'); i != -1 {
+			s.style = s.style[i+1:]
+		}
+	}
+}
+
+func (s *Screen) privateMode(params []int, on bool) {
+	for _, p := range params {
+		switch p {
+		case 25:
+			s.hidden = !on
+		case 47, 1047, 1049:
+			if on == (s.main != nil) {
+				continue
+			}
+
+			if on {
+				if p == 1049 {
+					s.savedX, s.savedY, s.savedStyle = s.x, s.y, s.style
+				}
+				s.main = s.cells
+				s.cells = make([][]Cell, s.height)
+				for i := range s.cells {
+					s.cells[i] = blank(s.width)
+				}
+			} else {
+				s.cells, s.main = s.main, nil
+				if p == 1049 {
+					s.x, s.y, s.style = s.savedX, s.savedY, s.savedStyle
+				}
+			}
+			s.touchAll()
+		}
+	}
+}
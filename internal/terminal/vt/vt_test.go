@@ -0,0 +1,90 @@
+package vt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func lines(s *Screen) []string {
+	_, h := s.Size()
+	out := make([]string, h)
+	for y := range out {
+		out[y] = s.Line(y)
+	}
+	return out
+}
+
+func TestWrapAndScroll(t *testing.T) {
+	s := New(5, 3)
+	s.Write([]byte("abcdefg\r\nhi\r\njk\r\nl"))
+
+	got := lines(s)
+	want := []string{"hi", "jk", "l"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected %q got %q", want, got)
+	}
+
+	if x, y, _ := s.Cursor(); x != 1 || y != 2 {
+		t.Fatalf("expected the cursor at 1,2 got %d,%d", x, y)
+	}
+}
+
+func TestCursorAndErase(t *testing.T) {
+	s := New(10, 3)
+	s.Write([]byte("0123456789\x1b[2;3Hxy\x1b[1;5H\x1b[K\x1b[3;1Hend\x1b[2D\x1b[P"))
+
+	got := lines(s)
+	want := []string{"0123", "  xy", "ed"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected %q got %q", want, got)
+	}
+}
+
+func TestAlternateScreen(t *testing.T) {
+	s := New(10, 2)
+	s.Write([]byte("$ less\r\n\x1b[?1049h\x1b[Hpage one\x1b[?1049l"))
+
+	if got := lines(s); got[0] != "$ less" || got[1] != "" {
+		t.Fatalf("expected the main screen back after the alternate screen, got %q", got)
+	}
+
+	if x, y, _ := s.Cursor(); x != 0 || y != 1 {
+		t.Fatalf("expected the cursor back where it was, got %d,%d", x, y)
+	}
+}
+
+func TestScrollRegion(t *testing.T) {
+	s := New(4, 4)
+	s.Write([]byte("top\r\na\r\nb\r\nbot\x1b[2;3r\x1b[3;1H\n\nc"))
+
+	got := lines(s)
+	want := []string{"top", "", "c", "bot"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected %q got %q", want, got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	s := New(4, 2)
+	s.Write([]byte("\x1b[31mab\x1b[0mc"))
+
+	var out bytes.Buffer
+	s.Render(&out, 5, 10)
+
+	if got := out.String(); !strings.HasPrefix(got, "\x1b[5;10H\x1b[0m\x1b[0;31mab\x1b[0mc ") {
+		t.Fatalf("unexpected render %q", got)
+	}
+
+	out.Reset()
+	s.Render(&out, 5, 10)
+	if out.Len() != 0 {
+		t.Fatalf("expected nothing to be drawn when nothing changed, got %q", out.String())
+	}
+
+	s.Write([]byte("\r\nd"))
+	s.Render(&out, 5, 10)
+	if got := out.String(); !strings.HasPrefix(got, "\x1b[6;10H") || strings.Contains(got, "\x1b[5;10H") {
+		t.Fatalf("expected only the second row to be drawn, got %q", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -12,7 +13,7 @@ import (
 var ErrNilServerConnection = errors.New("The server connection was nil for the client")
 
 var lUsers sync.RWMutex
-var users map[string]bool = make(map[string]bool)
+var users map[string]*User = make(map[string]*User)
 
 type User struct {
 	sync.RWMutex
@@ -29,6 +30,54 @@ type User struct {
 
 	// So we can capture details about who is currently using the rssh server
 	ConnectionDetails string
+
+	// Set from the role= option in authorized_keys
+	Role string
+
+	// Logged in with a key marked duress in authorized_keys, they are shown an empty server
+	Duress bool
+
+	// Set from the prompt= option in authorized_keys, a template such as "{user}@{server} [{clients}]> "
+	Prompt string
+
+	// Key the user logged in with
+	PublicKey ssh.PublicKey
+
+	// Comment on that key in authorized_keys, which is usually the only thing naming who the user actually is
+	KeyComment string
+
+	// Set from the lock-after= and lock-passphrase= options in authorized_keys, the console locks after being idle this long
+	// and unlocks with the (bcrypt hashed) passphrase or a signature from the login key through a forwarded agent
+	LockAfter      time.Duration
+	LockPassphrase string
+
+	// The user asked for their ssh agent to be forwarded
+	AgentForwarded bool
+
+	// Set from the max-sessions=, max-forwards= and max-transfer= options in authorized_keys
+	Quota Quota
+
+	// Wants plain output for a screen reader, set with the accessible command and kept in the operator's preferences
+	Accessible bool
+
+	// Language help and messages are shown in, from the lang command or a LANG env request, empty for English
+	Lang string
+
+	// Added to the session by middleware hooks as it started
+	Annotations map[string]string
+
+	sessionCounted bool
+}
+
+const (
+	// Can do anything, the default for keys without a role so existing setups are unchanged
+	RoleAdmin = "admin"
+	// Everyday use, sensitive things like managing the vault are withheld
+	RoleOperator = "operator"
+)
+
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleOperator
 }
 
 func CreateUser(ServerConnection ssh.Conn) (us *User, err error) {
@@ -46,7 +95,7 @@ func CreateUser(ServerConnection ssh.Conn) (us *User, err error) {
 	lUsers.Lock()
 	defer lUsers.Unlock()
 
-	users[us.ConnectionDetails] = true
+	users[us.ConnectionDetails] = us
 
 	return
 }
@@ -63,8 +112,24 @@ func ListUsers() (userList []string) {
 	return
 }
 
+// GetUsers returns everyone connected, sorted by ConnectionDetails
+func GetUsers() (userList []*User) {
+	lUsers.RLock()
+	defer lUsers.RUnlock()
+
+	for _, user := range users {
+		userList = append(userList, user)
+	}
+
+	sort.Slice(userList, func(i, j int) bool {
+		return userList[i].ConnectionDetails < userList[j].ConnectionDetails
+	})
+	return
+}
+
 func DeleteUser(us *User) {
 	if us != nil {
+		us.endSession()
 
 		lUsers.Lock()
 		delete(users, us.ConnectionDetails)
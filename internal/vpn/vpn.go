@@ -0,0 +1,114 @@
+// Package vpn frames IP packets over an ssh channel for the vpn channel type, which the server opens to turn a client into a gateway
+// for a tun device. Unlike tun@openssh.com each packet is length prefixed, so packets survive being split or merged by the channel
+package vpn
+
+import (
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	ChannelType = "vpn"
+
+	DefaultMTU = 1500
+	// MinMTU is the smallest MTU IPv6 allows
+	MinMTU = 1280
+	MaxMTU = 65535
+)
+
+// Options are sent as the extra data of the channel open request
+type Options struct {
+	MTU      uint32
+	Compress bool
+}
+
+func (o Options) Validate() error {
+	if o.MTU < MinMTU || o.MTU > MaxMTU {
+		return fmt.Errorf("mtu must be between %d and %d", MinMTU, MaxMTU)
+	}
+	return nil
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// Conn reads and writes whole packets. Reads and writes may happen at the same time, but only one of each at a time
+type Conn struct {
+	r   io.Reader
+	w   io.Writer
+	mtu uint32
+
+	writeLock sync.Mutex
+	header    [4]byte
+}
+
+// NewConn wraps rw, compressing everything in both directions with flate if compress is set. Both ends must agree on compression
+func NewConn(rw io.ReadWriter, opts Options) *Conn {
+	c := &Conn{
+		r:   rw,
+		w:   rw,
+		mtu: opts.MTU,
+	}
+
+	if opts.Compress {
+		c.r = flate.NewReader(rw)
+		// The error is only for invalid levels
+		c.w, _ = flate.NewWriter(rw, flate.BestSpeed)
+	}
+
+	return c
+}
+
+func (c *Conn) MTU() uint32 {
+	return c.mtu
+}
+
+// ReadPacket returns the next packet, a packet bigger than the MTU is an error as the other end is not keeping to what was agreed
+func (c *Conn) ReadPacket() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length > c.mtu {
+		return nil, fmt.Errorf("packet of %d bytes is larger than the mtu (%d)", length, c.mtu)
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(c.r, packet); err != nil {
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+// WritePacket sends a single packet, flushing the compressor so the packet isnt held back waiting for more
+func (c *Conn) WritePacket(packet []byte) error {
+	if uint32(len(packet)) > c.mtu {
+		return errors.New("packet is larger than the mtu")
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	binary.BigEndian.PutUint32(c.header[:], uint32(len(packet)))
+	if _, err := c.w.Write(c.header[:]); err != nil {
+		return err
+	}
+
+	if _, err := c.w.Write(packet); err != nil {
+		return err
+	}
+
+	if f, ok := c.w.(flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package vpn
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestPacketsRoundTrip(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		a, b := net.Pipe()
+
+		opts := Options{MTU: DefaultMTU, Compress: compress}
+		sender, receiver := NewConn(a, opts), NewConn(b, opts)
+
+		packets := [][]byte{
+			bytes.Repeat([]byte{0x45}, 20),
+			bytes.Repeat([]byte("payload"), 200),
+			{},
+		}
+
+		go func() {
+			for _, p := range packets {
+				if err := sender.WritePacket(p); err != nil {
+					t.Error(err)
+				}
+			}
+			a.Close()
+		}()
+
+		for i, expected := range packets {
+			got, err := receiver.ReadPacket()
+			if err != nil {
+				t.Fatalf("compress %t packet %d: %s", compress, i, err)
+			}
+
+			if !bytes.Equal(got, expected) {
+				t.Fatalf("compress %t packet %d: got %d bytes expected %d", compress, i, len(got), len(expected))
+			}
+		}
+
+		if _, err := receiver.ReadPacket(); err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Errorf("expected the stream to end, got %v", err)
+		}
+	}
+}
+
+func TestMTU(t *testing.T) {
+	var b bytes.Buffer
+
+	small := NewConn(&b, Options{MTU: MinMTU})
+	if err := small.WritePacket(make([]byte, MinMTU+1)); err == nil {
+		t.Error("writing a packet over the mtu should fail")
+	}
+
+	if err := NewConn(&b, Options{MTU: DefaultMTU}).WritePacket(make([]byte, MinMTU+1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := small.ReadPacket(); err == nil {
+		t.Error("reading a packet over the mtu should fail")
+	}
+
+	if (Options{MTU: 576}).Validate() == nil {
+		t.Error("mtu below the ipv6 minimum should not be valid")
+	}
+}
@@ -0,0 +1,48 @@
+// Package command is what console commands are written against. The server's own commands are written with it, and a program
+// embedding the server adds commands of its own through server.Config.Commands.
+//
+// A command is made for each console session, so it can keep state for the session and check who it is running for, then Run
+// once for each line starting with its name. Output redirection, variables and scripts are handled by the console before Run is
+// called, a command only ever sees the line it is given and writes to output.
+package command
+
+import (
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+type Command interface {
+	// Expect is called as tab is pressed, with the line so far. It returns the completions for the word under the cursor, one
+	// of the Complete tokens on its own for the console to fill in, or nil for none
+	Expect(line ParsedLine) []string
+	// Run runs the command for a line starting with its name. An error is shown to the operator and stops any script the line is part of
+	Run(output io.ReadWriter, line ParsedLine) error
+	// Help gives a one line description for the help command's list with explain, otherwise the usage made with MakeHelpText
+	Help(explain bool) string
+}
+
+// Tokens Expect can return on their own in place of completions, the console completes them with what it knows of at the time
+const (
+	// Ids, hostnames and aliases of connected clients
+	CompleteClients = "<remote_id>"
+	// Console commands
+	CompleteCommands = "<functions>"
+	// Files served by the web server
+	CompleteDownloads = "<file_ids>"
+)
+
+// Roles an operator can log in with
+const (
+	RoleAdmin    = internal.RoleAdmin
+	RoleOperator = internal.RoleOperator
+)
+
+// Session is who a command was made for
+type Session struct {
+	// Who per operator state is kept against, the fingerprint of their key
+	Operator string
+	Role     string
+	// The user and address the operator logged in from
+	Connection string
+}
@@ -0,0 +1,74 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Category is what kind of failure an Error is, the console colours errors by it and the audit log records it
+type Category string
+
+const (
+	// Anything else, errors that are not an Error are taken to be this
+	Failed Category = "failed"
+	// The line was wrong, the message is usually the help text of the command
+	Usage Category = "usage"
+	// A client, file or other thing the line named does not exist
+	NotFound Category = "not-found"
+	// The operator is not allowed to do what they asked
+	Permission Category = "permission"
+	// The client or network let the command down, such as a channel that could not be opened
+	Transport Category = "transport"
+)
+
+// Error is an error the operator is shown one message for, while the audit log gets the full detail of what went wrong
+type Error struct {
+	Category Category
+
+	// Message is all the operator sees
+	Message string
+	// Detail is written to the audit log alongside the message, such as the error a client returned
+	Detail string
+
+	Err error
+}
+
+// Errorf makes an Error with a message for the operator
+func Errorf(category Category, format string, args ...interface{}) *Error {
+	return &Error{Category: category, Message: fmt.Sprintf(format, args...)}
+}
+
+// Because records err as the cause, kept from the operator and written to the audit log unless a Detail is already set
+func (e *Error) Because(err error) *Error {
+	e.Err = err
+	if e.Detail == "" && err != nil {
+		e.Detail = err.Error()
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// CategoryOf is the category of err if it is, or wraps, an Error and Failed otherwise
+func CategoryOf(err error) Category {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category
+	}
+	return Failed
+}
+
+// DetailOf is the detail of err for the audit log, empty if it has none
+func DetailOf(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Detail
+	}
+	return ""
+}
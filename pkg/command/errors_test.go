@@ -0,0 +1,33 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	cause := errors.New("administratively prohibited")
+	err := fmt.Errorf("exec: %w", Errorf(Transport, "Unable to open a session on %s", "dummy").Because(cause))
+
+	if err.Error() != "exec: Unable to open a session on dummy" {
+		t.Errorf("expected the operator to only see the message, got %q", err)
+	}
+
+	if CategoryOf(err) != Transport || DetailOf(err) != "administratively prohibited" {
+		t.Errorf("category and detail were lost when wrapped: %q %q", CategoryOf(err), DetailOf(err))
+	}
+
+	if !errors.Is(err, cause) {
+		t.Error("expected the cause to be unwrapped")
+	}
+
+	plain := errors.New("something broke")
+	if CategoryOf(plain) != Failed || DetailOf(plain) != "" {
+		t.Errorf("expected plain errors to be failures without detail, got %q %q", CategoryOf(plain), DetailOf(plain))
+	}
+
+	if e := Errorf(Permission, "no").Because(nil); e.Detail != "" || e.Err != nil {
+		t.Errorf("expected a nil cause to add nothing, got %+v", e)
+	}
+}
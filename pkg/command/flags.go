@@ -0,0 +1,180 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// FlagType is what the values given to a flag must be
+type FlagType int
+
+const (
+	// FlagSwitch is a flag that is on or off and takes no value
+	FlagSwitch FlagType = iota
+	FlagString
+	// FlagInt and FlagDuration values are checked as GetArgInt and GetArgDuration read them
+	FlagInt
+	FlagDuration
+)
+
+// FlagSpec declares one flag a command takes
+type FlagSpec struct {
+	// Name is what the command reads the flag as, without dashes. A single letter is typed as -n and anything longer as --name
+	Name string
+	// Aliases are other names the flag can be typed as, given to the command under Name
+	Aliases []string
+	Type    FlagType
+	// Arity is how many values the flag needs, treated as 1 for anything but a FlagSwitch when it is left at 0
+	Arity       int
+	Required    bool
+	Description string
+}
+
+func (s FlagSpec) arity() int {
+	if s.Type == FlagSwitch {
+		return 0
+	}
+	if s.Arity == 0 {
+		return 1
+	}
+	return s.Arity
+}
+
+// dashed is a flag as it is typed, one dash for a single character the same as the parser reads them
+func dashed(name string) string {
+	if utf8.RuneCountInString(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+// FlagSet is every flag a command takes and how it is used. A command that returns one from Flags has each line checked against
+// it by the console before Run, and can make its usage text from it with Help, so the two cannot drift apart
+type FlagSet struct {
+	// Usage is shown before the flags, such as "scan [OPTIONS] <remote_id> <ports>" and a line on what the command does
+	Usage []string
+	Flags []FlagSpec
+	// Notes are shown after the flags, such as examples
+	Notes []string
+}
+
+// Declared is implemented by commands that declare their flags with a FlagSet
+type Declared interface {
+	Flags() *FlagSet
+}
+
+// Validate checks line against the flags in fs, refusing flags that are not declared, values that do not parse and required
+// flags that are missing. Flags typed as an alias are added to line under their name, and line.Positional is set to the
+// arguments that are not a flag's values. A line asking for help with -h or --help is always left for the command
+func (fs *FlagSet) Validate(line *ParsedLine) error {
+	known := map[string]FlagSpec{}
+	for _, spec := range fs.Flags {
+		known[spec.Name] = spec
+		for _, alias := range spec.Aliases {
+			known[alias] = spec
+		}
+	}
+
+	line.Positional = positional(line, known)
+
+	if line.IsSet("h") || line.IsSet("help") {
+		return nil
+	}
+
+	for _, f := range line.FlagsOrdered {
+		if _, ok := known[f.Value()]; !ok {
+			see := "-h"
+			if line.Command != nil {
+				words := []string{line.Command.Value()}
+				for _, p := range line.Path {
+					words = append(words, p.Value())
+				}
+				see = strings.Join(append(words, "-h"), " ")
+			}
+			return Errorf(Usage, "Unknown flag %s, see %s", dashed(f.Value()), see)
+		}
+	}
+
+	for _, spec := range fs.Flags {
+		if !line.IsSet(spec.Name) {
+			for _, alias := range spec.Aliases {
+				if f, ok := line.Flags[alias]; ok {
+					line.Flags[spec.Name] = f
+					break
+				}
+			}
+		}
+
+		f, ok := line.Flags[spec.Name]
+		if !ok {
+			if spec.Required {
+				return Errorf(Usage, "%s is required", dashed(spec.Name))
+			}
+			continue
+		}
+
+		needs := spec.arity()
+		if len(f.Args) < needs {
+			if needs == 1 {
+				return Errorf(Usage, "%s needs a value", line.flagName(spec.Name))
+			}
+			return Errorf(Usage, "%s needs %d values", line.flagName(spec.Name), needs)
+		}
+
+		var err error
+		switch spec.Type {
+		case FlagInt:
+			_, err = line.GetArgInt(spec.Name)
+		case FlagDuration:
+			_, err = line.GetArgDuration(spec.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// positional is the arguments of line less the values each flag takes. The parser gives a flag every argument up to the next
+// flag, so only the first ones it needs are its own and the rest are positional. Flags that are not declared take none
+func positional(line *ParsedLine, known map[string]FlagSpec) []Argument {
+	values := map[int]bool{}
+	for _, f := range line.FlagsOrdered {
+		// A flag that is not declared is looked up as the zero FlagSpec, a switch
+		needs := known[f.Value()].arity()
+		for i := 0; i < needs && i < len(f.Args); i++ {
+			values[f.Args[i].Start()] = true
+		}
+	}
+
+	var out []Argument
+	for _, arg := range line.Arguments {
+		if !values[arg.Start()] {
+			out = append(out, arg)
+		}
+	}
+	return out
+}
+
+// Help is the usage text made from fs, in the form MakeHelpText gives
+func (fs *FlagSet) Help() string {
+	lines := append([]string{}, fs.Usage...)
+
+	for _, spec := range fs.Flags {
+		names := []string{dashed(spec.Name)}
+		for _, alias := range spec.Aliases {
+			names = append(names, dashed(alias))
+		}
+
+		description := spec.Description
+		if spec.Required {
+			description += " (required)"
+		}
+
+		lines = append(lines, fmt.Sprintf("\t%s\t%s", strings.Join(names, ", "), description))
+	}
+
+	return MakeHelpText(append(lines, fs.Notes...)...)
+}
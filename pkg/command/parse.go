@@ -0,0 +1,551 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrFlagNotSet = errors.New("Flag not set")
+
+// Node is one part of a parsed line. Start and End are byte offsets into the line, so RawLine can be sliced with them, while
+// RuneStart and RuneEnd are the same span counted in characters, for placing it on screen
+type Node interface {
+	Value() string
+	Start() int
+	End() int
+	RuneStart() int
+	RuneEnd() int
+	Type() string
+}
+
+type baseNode struct {
+	start, end         int
+	runeStart, runeEnd int
+	value              string
+}
+
+func (bn *baseNode) Value() string {
+	return bn.value
+}
+
+func (bn *baseNode) Start() int {
+	return bn.start
+}
+
+func (bn *baseNode) End() int {
+	return bn.end
+}
+
+func (bn *baseNode) RuneStart() int {
+	return bn.runeStart
+}
+
+func (bn *baseNode) RuneEnd() int {
+	return bn.runeEnd
+}
+
+// setRunes counts the node's byte span in line as characters
+func (bn *baseNode) setRunes(line string) {
+	bn.runeStart = utf8.RuneCountInString(line[:bn.start])
+	bn.runeEnd = bn.runeStart + utf8.RuneCountInString(line[bn.start:bn.end])
+}
+
+type Argument struct {
+	baseNode
+}
+
+func (a Argument) Type() string {
+	return "argument"
+}
+
+type Cmd struct {
+	baseNode
+}
+
+func (c Cmd) Type() string {
+	return "command"
+}
+
+type Flag struct {
+	baseNode
+
+	Args []Argument
+	long bool
+}
+
+func (f Flag) Type() string {
+	return "flag"
+}
+
+func (f *Flag) ArgValues() (out []string) {
+	for _, v := range f.Args {
+		out = append(out, v.Value())
+	}
+	return
+}
+
+// ParsedLine is a console line broken up. Arguments holds every argument on the line, including those following a flag that
+// are also in its Args
+type ParsedLine struct {
+	Chunks []string
+
+	FlagsOrdered []Flag
+	Flags        map[string]Flag
+
+	Arguments []Argument
+	// Positional is Arguments without the values taken by flags, set by FlagSet.Validate from how many values each flag needs
+	Positional []Argument
+	Focus      Node
+
+	Section *Flag
+
+	Command *Cmd
+	// Path is the subcommands named after Command, set by Descend. Their words are taken out of Arguments
+	Path []Cmd
+
+	RawLine string
+}
+
+func (pl *ParsedLine) Empty() bool {
+	return pl.RawLine == ""
+}
+
+func (pl *ParsedLine) ArgumentsAsStrings() (out []string) {
+	for _, v := range pl.Arguments {
+		out = append(out, v.Value())
+	}
+	return
+}
+
+func (pl *ParsedLine) IsSet(flag string) bool {
+	_, ok := pl.Flags[flag]
+	return ok
+}
+
+func (pl *ParsedLine) ExpectArgs(flag string, needs int) ([]Argument, error) {
+	f, ok := pl.Flags[flag]
+	if ok {
+		if len(f.Args) != needs {
+			return nil, fmt.Errorf("flag: %s expects %d arguments", flag, needs)
+		}
+		return f.Args, nil
+	}
+	return nil, ErrFlagNotSet
+}
+
+func (pl *ParsedLine) GetArgs(flag string) ([]Argument, error) {
+	f, ok := pl.Flags[flag]
+	if ok {
+		return f.Args, nil
+	}
+	return nil, ErrFlagNotSet
+}
+
+func (pl *ParsedLine) GetArgsString(flag string) ([]string, error) {
+	f, ok := pl.Flags[flag]
+	if ok {
+		return f.ArgValues(), nil
+	}
+	return nil, ErrFlagNotSet
+}
+
+func (pl *ParsedLine) GetArg(flag string) (Argument, error) {
+	arg, err := pl.ExpectArgs(flag, 1)
+	if err != nil {
+		return Argument{}, err
+	}
+
+	return arg[0], nil
+}
+
+// Gets a single argument, will return error if flag is not set, or if it has no contents (e.g --blah)
+func (pl *ParsedLine) GetArgString(flag string) (string, error) {
+	f, ok := pl.Flags[flag]
+	if !ok {
+		return "", ErrFlagNotSet
+	}
+
+	if len(f.Args) == 0 {
+		return "", fmt.Errorf("flag: %s expects at least 1 argument", flag)
+	}
+	return f.Args[0].Value(), nil
+
+}
+
+// GetArgStringDefault gets the single argument of a flag, or def when the flag is not set or was given nothing
+func (pl *ParsedLine) GetArgStringDefault(flag, def string) string {
+	value, err := pl.GetArgString(flag)
+	if err != nil || value == "" {
+		return def
+	}
+	return value
+}
+
+// GetArgInt gets the whole number given to a flag. It returns ErrFlagNotSet when the flag is not on the line, so callers
+// can keep their default, and a Usage error naming the flag when it has no value or one that is not a number
+func (pl *ParsedLine) GetArgInt(flag string) (int, error) {
+	value, err := pl.typedArg(flag, "a number")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, Errorf(Usage, "%s needs a number, %q is not one", pl.flagName(flag), value)
+	}
+	return n, nil
+}
+
+// GetArgDuration gets the duration given to a flag, such as 30s or 2h, the same way as GetArgInt
+func (pl *ParsedLine) GetArgDuration(flag string) (time.Duration, error) {
+	value, err := pl.typedArg(flag, "a duration, such as 30s or 5m")
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, Errorf(Usage, "%s needs a duration, such as 30s or 5m, %q is not one", pl.flagName(flag), value)
+	}
+	return d, nil
+}
+
+// GetArgBool reports whether a flag is on. A flag given alone is true, and otherwise its value is read as true, false, yes, no,
+// on, off, 1 or 0. As the parser hands a flag the arguments after it, a value that is none of those is left as an argument and
+// the flag is true. It returns false and ErrFlagNotSet when the flag is not on the line
+func (pl *ParsedLine) GetArgBool(flag string) (bool, error) {
+	f, ok := pl.Flags[flag]
+	if !ok {
+		return false, ErrFlagNotSet
+	}
+
+	if len(f.Args) == 0 {
+		return true, nil
+	}
+
+	switch strings.ToLower(f.Args[0].Value()) {
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return true, nil
+}
+
+// typedArg is the value of a flag that needs one, what it needs is only used in the error when there is none
+func (pl *ParsedLine) typedArg(flag, needs string) (string, error) {
+	f, ok := pl.Flags[flag]
+	if !ok {
+		return "", ErrFlagNotSet
+	}
+
+	if len(f.Args) == 0 || f.Args[0].Value() == "" {
+		return "", Errorf(Usage, "%s needs %s", pl.flagName(flag), needs)
+	}
+	return f.Args[0].Value(), nil
+}
+
+// flagName is a flag as it was typed, with one dash or two, for error messages
+func (pl *ParsedLine) flagName(flag string) string {
+	f, ok := pl.Flags[flag]
+	if !ok {
+		return "--" + flag
+	}
+
+	if !f.long {
+		return "-" + f.Value()
+	}
+	return "--" + f.Value()
+}
+
+func parseFlag(line string, startPos int) (f Flag, endPos int) {
+
+	f.start = startPos
+	// Where the name starts after the dashes, it is sliced from line whole so a multi-byte character in it stays together
+	name := -1
+	for f.end = startPos; f.end < len(line); f.end++ {
+		endPos = f.end
+		if line[f.end] == ' ' {
+			break
+		}
+
+		if line[f.end] == '-' && name == -1 {
+			continue
+		}
+
+		if name == -1 {
+			name = f.end
+			f.long = f.end-startPos > 1
+		}
+	}
+
+	if name != -1 {
+		f.value = line[name:f.end]
+	}
+	f.setRunes(line)
+
+	return
+}
+
+// ParseArgument reads the one word starting at startPos, quoted or escaped with a backslash as on the command line, returning it and
+// the position of the space that follows it or of the last character of line. As in sh a backslash is kept as it is inside single
+// quotes, so Windows paths can be given without doubling every one
+func ParseArgument(line string, startPos int) (arg Argument, endPos int) {
+
+	var (
+		inString        = false
+		stringDelimiter = byte(0)
+		literalNext     = false
+	)
+
+	arg.start = startPos
+
+	var sb strings.Builder
+
+	defer func() {
+		arg.value = sb.String()
+		arg.setRunes(line)
+	}()
+
+	for arg.end = startPos; arg.end < len(line); arg.end++ {
+		endPos = arg.end
+
+		if !inString && !literalNext && (line[endPos] == '"' || line[endPos] == '\'' || line[endPos] == '`') {
+
+			inString = true
+			stringDelimiter = line[endPos]
+
+			continue
+		}
+
+		if !literalNext {
+
+			if line[endPos] == '\\' && stringDelimiter != '\'' {
+				literalNext = true
+				continue
+			}
+
+			if inString && line[endPos] == stringDelimiter {
+				stringDelimiter = byte(0)
+				inString = false
+				continue
+			}
+
+			if line[endPos] == ' ' && !inString {
+				return
+			}
+		}
+
+		sb.WriteByte(line[endPos])
+
+		arg.end = endPos
+
+		if literalNext {
+			literalNext = false
+		}
+	}
+
+	return
+}
+
+// ParseArguments reads the words starting at startPos up to the next flag or the end of line
+func ParseArguments(line string, startPos int) (args []Argument, endPos int) {
+	return parseArguments(line, startPos, true)
+}
+
+func parseArguments(line string, startPos int, stopAtFlag bool) (args []Argument, endPos int) {
+
+	for endPos = startPos; endPos < len(line); endPos++ {
+
+		var arg Argument
+		arg, endPos = ParseArgument(line, endPos)
+
+		// Quotes with nothing between them are still an argument, an empty one
+		if len(arg.value) != 0 || arg.end > arg.start {
+			args = append(args, arg)
+		}
+
+		if stopAtFlag && endPos != len(line)-1 && line[endPos+1] == '-' {
+			return
+		}
+	}
+
+	return
+}
+
+// ParseLineValidFlags is ParseLine, refusing flags that are not in validFlags
+func ParseLineValidFlags(line string, cursorPosition int, validFlags map[string]bool) (pl ParsedLine, err error) {
+	pl = ParseLine(line, cursorPosition)
+
+	for flag := range pl.Flags {
+		_, ok := validFlags[flag]
+		if !ok {
+			return ParsedLine{}, fmt.Errorf("flag provided but not defined: '%s'", flag)
+		}
+	}
+
+	return pl, nil
+}
+
+// isEndOfFlags reports whether a bare -- starts at i, after which everything is an argument even if it starts with a dash
+func isEndOfFlags(line string, i int) bool {
+	return strings.HasPrefix(line[i:], "--") && (i+2 == len(line) || line[i+2] == ' ')
+}
+
+// ParseLine splits a console line into its command, flags and arguments. cursorPosition is the byte offset of the cursor in line,
+// used to set Focus and Section when completing, and can be 0 otherwise. Everything after a bare -- is an argument
+func ParseLine(line string, cursorPosition int) (pl ParsedLine) {
+
+	var capture *Flag = nil
+	pl.Flags = make(map[string]Flag)
+	pl.RawLine = line
+
+	endOfFlags := -1
+
+	for i := 0; i < len(line); i++ {
+
+		if line[i] == '-' && endOfFlags == -1 && isEndOfFlags(line, i) {
+			if capture != nil {
+				if prev, ok := pl.Flags[capture.Value()]; ok {
+					capture.Args = append(capture.Args, prev.Args...)
+				}
+
+				pl.Flags[capture.Value()] = *capture
+				pl.FlagsOrdered = append(pl.FlagsOrdered, *capture)
+				capture = nil
+			}
+
+			// Kept in Chunks, which clients run as the command's own arguments and which may need it too
+			pl.Chunks = append(pl.Chunks, "--")
+
+			endOfFlags = i
+			i++
+			continue
+		}
+
+		if line[i] == '-' && endOfFlags == -1 {
+
+			if capture != nil {
+
+				if prev, ok := pl.Flags[capture.Value()]; ok {
+					capture.Args = append(capture.Args, prev.Args...)
+				}
+
+				pl.Flags[capture.Value()] = *capture
+				pl.FlagsOrdered = append(pl.FlagsOrdered, *capture)
+			}
+
+			var newFlag Flag
+			newFlag, i = parseFlag(line, i)
+			if cursorPosition >= newFlag.start && cursorPosition <= newFlag.end {
+				pl.Focus = &newFlag
+				pl.Section = &newFlag
+			}
+
+			pl.Chunks = append(pl.Chunks, pl.RawLine[newFlag.start:newFlag.end])
+
+			//First start parsing long options --blah
+			if newFlag.long {
+				capture = &newFlag
+				continue
+			}
+
+			//Start short option parsing -l or -ltab = -l -t -a -b
+
+			//For a single option, its not ambigous for what option we're capturing an arg for
+			if utf8.RuneCountInString(newFlag.Value()) == 1 {
+				capture = &newFlag
+				continue
+			}
+
+			//Most of the time its ambigous with multiple options in one flag, e.g -aft what arg goes with what option?
+			capture = nil
+			for _, c := range newFlag.Value() {
+				//Due to embedded struct this has to be like this
+				var f Flag
+				f.start = newFlag.start
+				f.end = i
+				f.value = string(c)
+				f.setRunes(line)
+
+				pl.Flags[f.Value()] = f
+				pl.FlagsOrdered = append(pl.FlagsOrdered, f)
+			}
+			continue
+
+		}
+
+		var args []Argument
+		args, i = parseArguments(line, i, endOfFlags == -1)
+
+		for m, arg := range args {
+			pl.Chunks = append(pl.Chunks, arg.value)
+
+			if cursorPosition >= arg.start && cursorPosition <= arg.end {
+				pl.Focus = &args[m]
+
+				pl.Section = capture
+			}
+		}
+
+		if pl.Command == nil && len(args) > 0 && capture == nil {
+			pl.Command = &Cmd{baseNode: args[0].baseNode}
+
+			if cursorPosition >= pl.Command.start && cursorPosition <= pl.Command.end {
+				pl.Focus = pl.Command
+			}
+
+			args = args[1:]
+		}
+
+		pl.Arguments = append(pl.Arguments, args...)
+
+		if capture != nil {
+			capture.Args = args
+			continue
+		}
+
+	}
+
+	if capture != nil {
+		if prev, ok := pl.Flags[capture.Value()]; ok {
+			capture.Args = append(capture.Args, prev.Args...)
+		}
+		pl.Flags[capture.Value()] = *capture
+		pl.FlagsOrdered = append(pl.FlagsOrdered, *capture)
+	}
+
+	var closestLeft *Flag
+
+	for i := len(pl.FlagsOrdered) - 1; i >= 0; i-- {
+		if cursorPosition >= pl.FlagsOrdered[i].start && cursorPosition <= pl.FlagsOrdered[i].end {
+			pl.Section = &pl.FlagsOrdered[i]
+			break
+		}
+
+		if pl.FlagsOrdered[i].end > cursorPosition {
+			continue
+		}
+
+		closestLeft = &pl.FlagsOrdered[i]
+		break
+	}
+
+	if pl.Section == nil && closestLeft != nil && (endOfFlags == -1 || cursorPosition < endOfFlags) {
+		pl.Section = closestLeft
+	}
+
+	return
+
+}
+
+// MakeHelpText joins the lines of a usage message, as returned by Help(false)
+func MakeHelpText(lines ...string) (s string) {
+	for _, v := range lines {
+		s += v + "\n"
+	}
+
+	return s
+}
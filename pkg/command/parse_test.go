@@ -0,0 +1,573 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBasicValid(t *testing.T) {
+	line := ParseLine("toaster --long_arg test -t a", 0)
+
+	if line.Command == nil {
+		t.Fatal("Line command is nil")
+	}
+
+	if line.Command.Value() != "toaster" {
+		t.Fatalf("Expected 'toaster' as command, got %s", line.Command.Value())
+	}
+
+	if len(line.Arguments) != 2 {
+		t.Fatalf("Parsed line should have 2 arguments, has %d", len(line.Arguments))
+	}
+
+	c, err := line.GetArgString("long_arg")
+	if err != nil {
+		t.Fatalf("Did not expect to get an error here: %s", err)
+	}
+
+	if c != "test" {
+		t.Fatalf("Expected --long_arg to have value 'test', has %s", c)
+	}
+
+	c, err = line.GetArgString("t")
+	if err != nil {
+		t.Fatalf("Did not expect to get an error here: %s", err)
+	}
+
+	if c != "a" {
+		t.Fatalf("Expected -t to have value 'a', has %s", c)
+	}
+
+	if line.Focus.Type() != line.Command.Type() && line.Focus.Value() == line.Command.Value() {
+		t.Fatalf("Cursor is set to focus on command, however focus variable is not set to command: focus: %v", line.Focus)
+	}
+
+}
+
+func TestNoFlags(t *testing.T) {
+	line := ParseLine("kill 127.0.0.1:49962", 0)
+
+	fmt.Println(line)
+
+	if len(line.Arguments) != 1 {
+		t.Fatalf("Expected one argument, got %d", len(line.Arguments))
+	}
+
+	if line.Arguments[0].Value() != "127.0.0.1:49962" {
+		t.Fatalf("Expected argument to be '127.0.0.1:49962', got %s", line.Arguments[0].Value())
+	}
+}
+
+func TestNoCommand(t *testing.T) {
+	line := ParseLine("--long_arg test -t a", 0)
+
+	if line.Command != nil {
+		t.Fatalf("If line has no command, command should be nil, is instead focused on %+v", line.Command)
+	}
+}
+
+func TestMixedArgs(t *testing.T) {
+	line := ParseLine("toaster --long_arg 1 2 3 4 -t a -t abcd --noot", 0)
+
+	c, err := line.GetArgs("long_arg")
+	if err != nil {
+		t.Fatalf("Did not expect to get an error here: %s", err)
+	}
+
+	if len(c) != 4 {
+		t.Fatalf("long_args should have 4 args: has: %d args", len(c))
+	}
+	fmt.Println(line)
+
+	if !line.IsSet("noot") {
+		t.Fatalf("Expect to noot to be set")
+	}
+
+	c, err = line.GetArgs("t")
+	if err != nil {
+		t.Fatalf("Did not expect to get an error here: %s", err)
+	}
+
+	if len(c) != 2 {
+
+		t.Fatalf("-t should have two arguments (concat) has %d", len(c))
+
+	}
+
+}
+
+func TestHelperFunctionsErrors(t *testing.T) {
+	line := ParseLine("lala --long_arg test -t a --zero -m", 0)
+
+	_, err := line.GetArgString("long_arg")
+	if err != nil {
+		t.Fatalf("Getting single string arg on 'long_arg' should work: %s", err)
+	}
+
+	_, err = line.GetArgString("t")
+	if err != nil {
+		t.Fatalf("Getting single string arg on 't' should work: %s", err)
+	}
+
+	_, err = line.GetArgString("zero")
+	if err == nil {
+		t.Fatalf("Expected error, 'zero' has no arg, yet we are still nil")
+	}
+
+	_, err = line.GetArgString("m")
+	if err == nil {
+		t.Fatalf("Expected error, 'm' has no arg, yet we are still nil")
+	}
+
+	e, err := line.ExpectArgs("long_arg", 1)
+	if err != nil {
+		t.Fatalf("Long arg supplies correct number of expected args, should not error: %s", err)
+	}
+
+	if len(e) != 1 || e[0].Value() != "test" {
+		t.Fatal("Output args was not correct from expect")
+	}
+
+	_, err = line.ExpectArgs("long_arg", 2)
+	if err == nil {
+		t.Fatal("long_args has < 2 arguments, should fail", err)
+	}
+
+	_, err = line.GetArg("bleep")
+	if err != ErrFlagNotSet {
+		t.Fatal("Error should be ErrFlagNotSet")
+	}
+
+}
+
+func TestStrings(t *testing.T) {
+	line := ParseLine("lala --long_arg \"test ''``-t a\" --zero -m", 0)
+
+	_, err := line.GetArgString("long_arg")
+	if err != nil {
+		t.Fatalf("Getting single string arg on 'long_arg' should work: %s", err)
+	}
+
+	_, err = line.GetArgString("zero")
+	if err == nil {
+		t.Fatalf("Expected error, 'zero' has no arg, yet we are still nil")
+	}
+
+	_, err = line.GetArgString("m")
+	if err == nil {
+		t.Fatalf("Expected error, 'm' has no arg, yet we are still nil")
+	}
+
+	e, err := line.ExpectArgs("long_arg", 1)
+	if err != nil {
+		t.Fatalf("Long arg supplies correct number of expected args, should not error: %s", err)
+	}
+
+	if len(e) != 1 || e[0].Value() != "test ''``-t a" {
+		t.Fatal("String was not handled correct as the value is incorrect")
+	}
+
+	if line.Chunks[0] != "lala" || line.Chunks[0] != line.Command.Value() {
+		t.Fatal("First chunk should be command")
+	}
+
+	if line.Chunks[1] != "--long_arg" {
+		t.Fatal("Next chunk should be flag")
+	}
+
+	if line.Chunks[2] != "test ''``-t a" {
+		t.Fatal("Next chunk should be argument string")
+	}
+}
+
+func TestQuotedArguments(t *testing.T) {
+	line := ParseLine(`connect "my host name"`, 0)
+	if len(line.Arguments) != 1 || line.Arguments[0].Value() != "my host name" {
+		t.Fatalf("Expected one argument 'my host name', got %q", line.ArgumentsAsStrings())
+	}
+
+	// Offsets are into the line as typed, quotes included, so completion replaces the whole of it
+	if line.Arguments[0].Start() != 8 || line.Arguments[0].End() != 22 {
+		t.Fatalf("Expected the argument to span 8 to 22, got %d to %d", line.Arguments[0].Start(), line.Arguments[0].End())
+	}
+
+	line = ParseLine("rc -c 'echo hello world'", 0)
+	c, err := line.GetArgString("c")
+	if err != nil {
+		t.Fatalf("Did not expect to get an error here: %s", err)
+	}
+
+	if c != "echo hello world" {
+		t.Fatalf("Expected -c to have value 'echo hello world', has %q", c)
+	}
+
+	line = ParseLine(`exec "web 01" uptime`, 0)
+	if rest := line.RawLine[line.Arguments[0].End():]; rest != " uptime" {
+		t.Fatalf("Expected the rest of the line after the quoted argument to be ' uptime', got %q", rest)
+	}
+
+	line = ParseLine(`set a "" b`, 0)
+	if args := line.ArgumentsAsStrings(); len(args) != 3 || args[1] != "" {
+		t.Fatalf("Expected an empty quoted argument to be kept, got %q", args)
+	}
+
+	cases := map[string]string{
+		`'C:\Windows\system32'`: `C:\Windows\system32`,
+		`"a\"b"`:                `a"b`,
+		`a\ b`:                  `a b`,
+		`a\"b`:                  `a"b`,
+		`'it'\''s'`:             `it's`,
+	}
+
+	for arg, expected := range cases {
+		line = ParseLine("cmd "+arg, 0)
+		if len(line.Arguments) != 1 || line.Arguments[0].Value() != expected {
+			t.Errorf("%s: expected %q, got %q", arg, expected, line.ArgumentsAsStrings())
+		}
+	}
+}
+
+func TestEndOfFlags(t *testing.T) {
+	line := ParseLine("rc --shell bash -- -al --long -x", 0)
+
+	if line.Command == nil || line.Command.Value() != "rc" {
+		t.Fatalf("Expected 'rc' as command, got %v", line.Command)
+	}
+
+	shell, err := line.GetArgString("shell")
+	if err != nil || shell != "bash" {
+		t.Fatalf("Expected --shell before -- to still be a flag with 'bash', got %q: %v", shell, err)
+	}
+
+	for _, flag := range []string{"al", "a", "l", "long", "x", "-"} {
+		if line.IsSet(flag) {
+			t.Errorf("Expected nothing after -- to be a flag, but %q is set", flag)
+		}
+	}
+
+	args := line.ArgumentsAsStrings()
+	if fmt.Sprint(args) != "[bash -al --long -x]" {
+		t.Fatalf("Expected everything after -- as arguments, got %q", args)
+	}
+
+	if args, _ := line.GetArgsString("shell"); len(args) != 1 {
+		t.Fatalf("Expected -- to end the arguments of --shell, got %q", args)
+	}
+
+	if fmt.Sprint(line.Chunks) != "[rc --shell bash -- -al --long -x]" {
+		t.Fatalf("Expected -- to be kept in the chunks, got %q", line.Chunks)
+	}
+
+	// Completing after -- is completing an argument, not the value of the last flag
+	line = ParseLine("rc --shell bash -- -a", 21)
+	if line.Section != nil {
+		t.Fatalf("Expected no flag section after --, got %v", line.Section.Value())
+	}
+
+	line = ParseLine("rc -- -- x", 0)
+	if fmt.Sprint(line.ArgumentsAsStrings()) != "[-- x]" {
+		t.Fatalf("Expected a second -- to be an argument, got %q", line.ArgumentsAsStrings())
+	}
+
+	line = ParseLine("rc --- -x", 0)
+	if !line.IsSet("x") {
+		t.Fatalf("Expected only a bare -- to end flags")
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	raw := "upload ./résumé.pdf --naïve -ü 3 --to 東京"
+
+	// The cursor is in bytes, as the terminal gives it, here inside 東京
+	line := ParseLine(raw, strings.Index(raw, "東")+3)
+
+	if len(line.Arguments) != 3 || line.Arguments[0].Value() != "./résumé.pdf" {
+		t.Fatalf("Expected the arguments to survive parsing, got %v", line.ArgumentsAsStrings())
+	}
+
+	if !line.IsSet("naïve") {
+		t.Fatalf("Expected --naïve to be set, got %v", line.Flags)
+	}
+
+	if v, err := line.GetArgInt("ü"); err != nil || v != 3 {
+		t.Fatalf("Expected -ü to take its value like any other short flag, got %d, %v", v, err)
+	}
+
+	arg := line.Arguments[0]
+	if raw[arg.Start():arg.End()] != "./résumé.pdf" {
+		t.Fatalf("Expected the byte span to slice the argument out of the line, got %q", raw[arg.Start():arg.End()])
+	}
+
+	if arg.RuneStart() != 7 || arg.RuneEnd() != 19 {
+		t.Fatalf("Expected the argument to span characters 7 to 19, got %d to %d", arg.RuneStart(), arg.RuneEnd())
+	}
+
+	if line.Focus == nil || line.Focus.Value() != "東京" {
+		t.Fatalf("Expected the focus to be 東京, got %v", line.Focus)
+	}
+
+	if runes := []rune(raw); string(runes[line.Focus.RuneStart():line.Focus.RuneEnd()]) != "東京" {
+		t.Fatalf("Expected the focus rune span to hold 東京, got %d to %d", line.Focus.RuneStart(), line.Focus.RuneEnd())
+	}
+
+	if line.Section == nil || line.Section.Value() != "to" {
+		t.Fatalf("Expected the section to be --to, got %v", line.Section)
+	}
+
+	cluster := ParseLine("ls -éa", 0)
+	if !cluster.IsSet("é") || !cluster.IsSet("a") {
+		t.Fatalf("Expected -éa to set é and a, got %v", cluster.Flags)
+	}
+}
+
+func TestTypedArgs(t *testing.T) {
+	line := ParseLine("link --downloads 3 --expires 2h -n x --uses --compress web01 --kill off --name", 0)
+
+	if n, err := line.GetArgInt("downloads"); err != nil || n != 3 {
+		t.Fatalf("expected 3 downloads, got %d %v", n, err)
+	}
+
+	if d, err := line.GetArgDuration("expires"); err != nil || d != 2*time.Hour {
+		t.Fatalf("expected 2h, got %s %v", d, err)
+	}
+
+	_, err := line.GetArgInt("n")
+	if err == nil || err.Error() != `-n needs a number, "x" is not one` || CategoryOf(err) != Usage {
+		t.Fatalf("expected a usage error naming -n, got %v", err)
+	}
+
+	if _, err := line.GetArgDuration("n"); err == nil || err.Error() != `-n needs a duration, such as 30s or 5m, "x" is not one` {
+		t.Fatalf("expected a duration error naming -n, got %v", err)
+	}
+
+	if _, err := line.GetArgInt("uses"); err == nil || err.Error() != "--uses needs a number" {
+		t.Fatalf("expected --uses with no value to be refused, got %v", err)
+	}
+
+	if _, err := line.GetArgInt("parallel"); err != ErrFlagNotSet {
+		t.Fatalf("expected ErrFlagNotSet for a flag that is not on the line, got %v", err)
+	}
+
+	if on, err := line.GetArgBool("compress"); err != nil || !on {
+		t.Fatalf("expected a flag followed by an argument to be on, got %t %v", on, err)
+	}
+
+	if on, err := line.GetArgBool("kill"); err != nil || on {
+		t.Fatalf("expected --kill off to be off, got %t %v", on, err)
+	}
+
+	if on, err := line.GetArgBool("missing"); err != ErrFlagNotSet || on {
+		t.Fatalf("expected a missing flag to be off, got %t %v", on, err)
+	}
+
+	if v := line.GetArgStringDefault("name", "random"); v != "random" {
+		t.Fatalf("expected the default for a flag with no value, got %q", v)
+	}
+
+	if v := line.GetArgStringDefault("n", "none"); v != "x" {
+		t.Fatalf("expected the value given, got %q", v)
+	}
+}
+
+func TestFlagSet(t *testing.T) {
+	fs := &FlagSet{
+		Usage: []string{"scan [OPTIONS] <remote_id>"},
+		Flags: []FlagSpec{
+			{Name: "rate", Type: FlagInt, Description: "Attempts per second"},
+			{Name: "timeout", Aliases: []string{"t"}, Type: FlagDuration, Description: "How long to wait"},
+			{Name: "to", Type: FlagString, Required: true, Description: "Where to send it"},
+			{Name: "v", Description: "Verbose"},
+		},
+		Notes: []string{"\tscan web01"},
+	}
+
+	checks := []struct {
+		line string
+		err  string
+	}{
+		{"scan --rate 10 -t 2s --to x -v web01", ""},
+		{"scan --to x --bogus web01", "Unknown flag --bogus, see scan -h"},
+		{"scan web01", "--to is required"},
+		{"scan --to", "--to needs a value"},
+		{"scan --to x --rate many", `--rate needs a number, "many" is not one`},
+		{"scan --to x -t soon", `-t needs a duration, such as 30s or 5m, "soon" is not one`},
+		{"scan --bogus -h", ""},
+	}
+
+	for _, c := range checks {
+		line := ParseLine(c.line, 0)
+		err := fs.Validate(&line)
+		if c.err == "" {
+			if err != nil {
+				t.Fatalf("%q: expected no error, got %v", c.line, err)
+			}
+			continue
+		}
+
+		if err == nil || err.Error() != c.err || CategoryOf(err) != Usage {
+			t.Fatalf("%q: expected usage error %q, got %v", c.line, c.err, err)
+		}
+	}
+
+	line := ParseLine("listen add --bogus", 0)
+	line.Descend(&treeCommand{subcommands: map[string]Command{"add": &leafCommand{}}})
+	if err := fs.Validate(&line); err == nil || err.Error() != "Unknown flag --bogus, see listen add -h" {
+		t.Fatalf("expected the subcommand to be named, got %v", err)
+	}
+
+	line = ParseLine("scan -t 2s --to x web01", 0)
+	if err := fs.Validate(&line); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := line.GetArgDuration("timeout"); err != nil || d != 2*time.Second {
+		t.Fatalf("expected -t to be read as --timeout, got %s %v", d, err)
+	}
+
+	accented := &FlagSet{Flags: []FlagSpec{{Name: "é", Type: FlagString, Required: true, Description: "Accent"}}}
+	line = ParseLine("scan web01", 0)
+	if err := accented.Validate(&line); err == nil || err.Error() != "-é is required" {
+		t.Fatalf("expected a one character flag to be named as a short flag, got %v", err)
+	}
+	line = ParseLine("scan -é x web01", 0)
+	if err := accented.Validate(&line); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(accented.Help(), "\t-é\tAccent") {
+		t.Fatalf("expected -é in the help, got:\n%s", accented.Help())
+	}
+
+	expected := MakeHelpText(
+		"scan [OPTIONS] <remote_id>",
+		"\t--rate\tAttempts per second",
+		"\t--timeout, -t\tHow long to wait",
+		"\t--to\tWhere to send it (required)",
+		"\t-v\tVerbose",
+		"\tscan web01",
+	)
+	if fs.Help() != expected {
+		t.Fatalf("expected help:\n%s\ngot:\n%s", expected, fs.Help())
+	}
+}
+
+func TestPositional(t *testing.T) {
+	fs := &FlagSet{
+		Flags: []FlagSpec{
+			{Name: "rate", Type: FlagInt},
+			{Name: "timeout", Aliases: []string{"t"}, Type: FlagDuration},
+			{Name: "between", Type: FlagString, Arity: 2},
+			{Name: "v"},
+		},
+	}
+
+	for input, expected := range map[string]string{
+		"scan web01 10.0.0.0/24 22":                            "web01,10.0.0.0/24,22",
+		"scan --rate 50 web01 10.0.0.0/24 22":                  "web01,10.0.0.0/24,22",
+		"scan web01 --rate 50 10.0.0.0/24 -t 2s 22":            "web01,10.0.0.0/24,22",
+		"scan web01 10.0.0.0/24 22 --rate 50":                  "web01,10.0.0.0/24,22",
+		"scan -v web01 10.0.0.0/24":                            "web01,10.0.0.0/24",
+		"scan --between 1 2 web01":                             "web01",
+		"scan --rate 50 --between 1 2 web01 -v 10.0.0.0/24 22": "web01,10.0.0.0/24,22",
+		"scan --rate 50 --rate 60 web01":                       "web01",
+		"scan --rate":                                          "",
+	} {
+		line := ParseLine(input, 0)
+		fs.Validate(&line)
+
+		var got []string
+		for _, arg := range line.Positional {
+			got = append(got, arg.Value())
+		}
+		if strings.Join(got, ",") != expected {
+			t.Errorf("%q: expected positional arguments %q, got %q", input, expected, strings.Join(got, ","))
+		}
+	}
+}
+
+type leafCommand struct {
+	name string
+}
+
+func (l *leafCommand) Expect(line ParsedLine) []string { return nil }
+
+func (l *leafCommand) Run(output io.ReadWriter, line ParsedLine) error {
+	_, err := fmt.Fprintf(output, "%s %s", l.name, strings.Join(line.ArgumentsAsStrings(), ","))
+	return err
+}
+
+func (l *leafCommand) Help(explain bool) string { return "" }
+
+type treeCommand struct {
+	leafCommand
+	subcommands map[string]Command
+}
+
+func (t *treeCommand) Subcommands() map[string]Command { return t.subcommands }
+
+func TestDescend(t *testing.T) {
+	add := &leafCommand{name: "add"}
+	rm := &leafCommand{name: "rm"}
+	forwards := &treeCommand{subcommands: map[string]Command{"add": add, "rm": rm}}
+	listen := &treeCommand{leafCommand: leafCommand{name: "listen"}, subcommands: map[string]Command{"forwards": forwards, "ls": &leafCommand{name: "ls"}}}
+
+	line := ParseLine("listen forwards add :8080 --on web01", 0)
+	if c := line.Descend(listen); c != Command(add) {
+		t.Fatalf("expected listen forwards add, got %v", c)
+	}
+	if len(line.Path) != 2 || line.Path[0].Value() != "forwards" || line.Path[1].Value() != "add" {
+		t.Fatalf("expected the path forwards add, got %v", line.Path)
+	}
+	if args := strings.Join(line.ArgumentsAsStrings(), ","); args != ":8080,web01" {
+		t.Fatalf("expected the subcommands taken out of the arguments, got %q", args)
+	}
+	if c := line.Descend(listen); c != Command(add) || len(line.Path) != 2 || len(line.Arguments) != 2 {
+		t.Fatalf("expected descending twice to change nothing, got %v %v", c, line.Path)
+	}
+
+	for input, expected := range map[string]Command{
+		"listen":                listen,
+		"listen bogus":          listen,
+		"listen -v ls":          listen,
+		"listen forwards":       forwards,
+		"listen forwards -x rm": forwards,
+	} {
+		line := ParseLine(input, 0)
+		if c := line.Descend(listen); c != expected {
+			t.Errorf("%q: expected %v, got %v", input, expected, c)
+		}
+	}
+
+	var out bytes.Buffer
+	if ran, err := RunSubcommand(listen, &out, ParseLine("listen forwards rm :8080", 0)); !ran || err != nil || out.String() != "rm :8080" {
+		t.Fatalf("expected rm to run, got %v %v %q", ran, err, out.String())
+	}
+
+	if ran, _ := RunSubcommand(listen, &out, ParseLine("listen -l", 0)); ran {
+		t.Fatal("expected no subcommand to run for a line that names none")
+	}
+}
+
+func TestSubcommandsAt(t *testing.T) {
+	forwards := &treeCommand{subcommands: map[string]Command{"add": &leafCommand{}, "rm": &leafCommand{}, "reset": &leafCommand{}}}
+	listen := &treeCommand{subcommands: map[string]Command{"forwards": forwards, "ls": &leafCommand{}}}
+
+	for input, expected := range map[string]string{
+		"listen ":              "forwards,ls",
+		"listen f":             "forwards",
+		"listen forwards ":     "add,reset,rm",
+		"listen forwards r":    "reset,rm",
+		"listen forwards add":  "add",
+		"listen -v ":           "",
+		"listen forwards add ": "",
+		"listen ls x":          "",
+	} {
+		line := ParseLine(input, len(input))
+		names, ok := line.SubcommandsAt(listen)
+		if strings.Join(names, ",") != expected || ok != (expected != "") {
+			t.Errorf("%q: expected %q, got %v %v", input, expected, names, ok)
+		}
+	}
+}
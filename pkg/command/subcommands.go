@@ -0,0 +1,139 @@
+package command
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// Tree is implemented by commands made of subcommands, such as bans with ls, add and rm. The console follows the words after the
+// command that name subcommands, so the line a subcommand is given has them in Path rather than Arguments, its flags are the ones
+// checked and tab completes subcommand names where one can be typed. Subcommands can be Trees too
+type Tree interface {
+	Command
+	Subcommands() map[string]Command
+}
+
+// Descend finds the subcommand of c the line is for, following each word after the command path that names a subcommand of the
+// one before and moving it into Path. A subcommand can only be named before any flag. It returns c when the line names none,
+// and can be called again on a line that has already been descended from c
+func (pl *ParsedLine) Descend(c Command) Command {
+	for depth := 0; ; depth++ {
+		t, ok := c.(Tree)
+		if !ok {
+			return c
+		}
+
+		var name string
+		if depth < len(pl.Path) {
+			name = pl.Path[depth].Value()
+		} else if arg, ok := pl.subcommandSlot(); ok {
+			name = arg.Value()
+		} else {
+			return c
+		}
+
+		sub, ok := t.Subcommands()[name]
+		if !ok {
+			return c
+		}
+
+		if depth >= len(pl.Path) {
+			pl.takeSubcommand()
+		}
+		c = sub
+	}
+}
+
+// RunSubcommand runs the subcommand of t that line names, for a Tree's Run to hand its line on. It reports false when the line
+// names none, leaving t to handle it
+func RunSubcommand(t Tree, output io.ReadWriter, line ParsedLine) (bool, error) {
+	sub := line.Descend(t)
+	if sub == Command(t) {
+		return false, nil
+	}
+
+	return true, sub.Run(output, line)
+}
+
+// SubcommandsAt gives the names of the subcommands that can be typed at Focus, for completion. It reports false when Focus is
+// not where a subcommand of c or one of its subcommands is named
+func (pl *ParsedLine) SubcommandsAt(c Command) ([]string, bool) {
+	leaf := pl.Descend(c)
+
+	var (
+		t      Tree
+		prefix string
+	)
+
+	if focus, ok := pl.Focus.(*Cmd); ok && focus != pl.Command {
+		// An already complete subcommand name, completing it again gives the names it could have been
+		parent := c
+		for _, p := range pl.Path {
+			if p.Start() == focus.Start() {
+				break
+			}
+			parent = parent.(Tree).Subcommands()[p.Value()]
+		}
+
+		t, _ = parent.(Tree)
+		prefix = focus.Value()
+	} else {
+		t, ok = leaf.(Tree)
+		if !ok {
+			return nil, false
+		}
+
+		arg, free := pl.subcommandSlot()
+		switch {
+		case pl.Focus == nil && len(pl.Arguments) == 0 && len(pl.FlagsOrdered) == 0:
+		case free && pl.Focus != nil && pl.Focus.Type() == arg.Type() && pl.Focus.Start() == arg.Start():
+			prefix = arg.Value()
+		default:
+			return nil, false
+		}
+	}
+
+	if t == nil {
+		return nil, false
+	}
+
+	var names []string
+	for name := range t.Subcommands() {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, len(names) > 0
+}
+
+// subcommandSlot is the word that could name the next subcommand, the first argument left when no flag comes before it
+func (pl *ParsedLine) subcommandSlot() (Argument, bool) {
+	if len(pl.Arguments) == 0 {
+		return Argument{}, false
+	}
+
+	arg := pl.Arguments[0]
+	for _, f := range pl.FlagsOrdered {
+		if f.Start() < arg.Start() {
+			return Argument{}, false
+		}
+	}
+
+	return arg, true
+}
+
+// takeSubcommand moves the first argument into Path, keeping Focus on it if it was there
+func (pl *ParsedLine) takeSubcommand() {
+	arg := pl.Arguments[0]
+	pl.Arguments = pl.Arguments[1:]
+
+	sub := Cmd{baseNode: arg.baseNode}
+	pl.Path = append(pl.Path, sub)
+
+	if pl.Focus != nil && pl.Focus.Type() == arg.Type() && pl.Focus.Start() == arg.Start() {
+		pl.Focus = &sub
+	}
+}
@@ -0,0 +1,96 @@
+// Package delta finds which blocks of a file changed since a copy of it was last sent somewhere, so only those need sending again.
+// Blocks are at fixed offsets, so bytes inserted or removed change every block after them, but a file rebuilt or patched in place
+// only differs in the blocks it touched
+package delta
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+const BlockSize = 64 * 1024
+
+// Signature describes the contents of a file well enough to tell which of its blocks differ from another version of it
+type Signature struct {
+	Size   int64
+	SHA256 string
+	// The sha256 of each block in order, the last one may be short
+	Blocks []string
+}
+
+// Range is a run of bytes at Offset in the new version of a file
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// Sign reads r to the end, taking the hash of each block and of the whole
+func Sign(r io.Reader) (Signature, error) {
+	var (
+		s     Signature
+		whole = sha256.New()
+		block = make([]byte, BlockSize)
+	)
+
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			h := sha256.Sum256(block[:n])
+			s.Blocks = append(s.Blocks, hex.EncodeToString(h[:]))
+			whole.Write(block[:n])
+			s.Size += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return Signature{}, err
+		}
+	}
+
+	s.SHA256 = hex.EncodeToString(whole.Sum(nil))
+	return s, nil
+}
+
+// Changed gives the parts of next that differ from previous, with neighbouring blocks joined into one range. Writing them over a
+// copy of previous and then cutting it to next.Size makes it next
+func Changed(previous, next Signature) []Range {
+	var out []Range
+	for i, h := range next.Blocks {
+		if i < len(previous.Blocks) && previous.Blocks[i] == h {
+			continue
+		}
+
+		offset := int64(i) * BlockSize
+		length := next.Size - offset
+		if length > BlockSize {
+			length = BlockSize
+		}
+
+		if len(out) > 0 && out[len(out)-1].Offset+out[len(out)-1].Length == offset {
+			out[len(out)-1].Length += length
+			continue
+		}
+		out = append(out, Range{Offset: offset, Length: length})
+	}
+
+	return out
+}
+
+// Whole is every byte of a file of size, for when there is nothing to compare it with
+func Whole(size int64) []Range {
+	if size == 0 {
+		return nil
+	}
+	return []Range{{Offset: 0, Length: size}}
+}
+
+// Bytes is how many bytes ranges cover
+func Bytes(ranges []Range) (n int64) {
+	for _, r := range ranges {
+		n += r.Length
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package delta
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// apply writes the changed parts of next over previous, as sync does to the copy on a client
+func apply(previous, next []byte, ranges []Range) []byte {
+	out := append([]byte{}, previous...)
+	for _, r := range ranges {
+		end := r.Offset + r.Length
+		for int64(len(out)) < end {
+			out = append(out, 0)
+		}
+		copy(out[r.Offset:end], next[r.Offset:end])
+	}
+	return out[:len(next)]
+}
+
+func TestChanged(t *testing.T) {
+	original := make([]byte, 5*BlockSize+100)
+	rand.New(rand.NewSource(1)).Read(original)
+
+	patched := append([]byte{}, original...)
+	patched[BlockSize+10] ^= 0xff
+	patched[BlockSize*2+5] ^= 0xff
+	patched[BlockSize*4] ^= 0xff
+
+	for _, test := range []struct {
+		name    string
+		next    []byte
+		changed int64
+	}{
+		{"unchanged", original, 0},
+		{"patched in place", patched, 3 * BlockSize},
+		{"grown", append(append([]byte{}, original...), 1, 2, 3), 100 + 3},
+		{"shrunk", original[:3*BlockSize], 0},
+		{"shrunk mid block", original[:3*BlockSize+7], 7},
+		{"emptied", nil, 0},
+	} {
+		previous, err := Sign(bytes.NewReader(original))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		next, err := Sign(bytes.NewReader(test.next))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ranges := Changed(previous, next)
+		if got := Bytes(ranges); got != test.changed {
+			t.Errorf("%s: expected %d bytes to be sent, got %d in %+v", test.name, test.changed, got, ranges)
+		}
+
+		if got := apply(original, test.next, ranges); !bytes.Equal(got, test.next) {
+			t.Errorf("%s: writing the changed ranges did not give the new file", test.name)
+		}
+	}
+
+	// Blocks 1 and 2 differ, so are sent as one range
+	previous, _ := Sign(bytes.NewReader(original))
+	next, _ := Sign(bytes.NewReader(patched))
+	if ranges := Changed(previous, next); len(ranges) != 2 {
+		t.Errorf("expected neighbouring blocks to be joined, got %+v", ranges)
+	}
+}
@@ -0,0 +1,230 @@
+// Package diff compares two texts line by line, giving the differences in the unified format of diff -u
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Past this many differing lines the texts are shown as entirely replaced, which is still correct but costs nothing to work out
+const MaxChanges = 1000
+
+type edit struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Lines splits text into lines, without their line endings
+func Lines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Unified gives the difference between a and b with context lines around each change, or nothing if they are the same
+func Unified(nameA, nameB string, a, b []string, context int) string {
+	edits := compare(a, b)
+
+	var out strings.Builder
+	for start := 0; start < len(edits); {
+		if edits[start].kind == ' ' {
+			start++
+			continue
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+
+		// A hunk carries on over runs of unchanged lines short enough that their context would overlap
+		end := start
+		for {
+			for end < len(edits) && edits[end].kind != ' ' {
+				end++
+			}
+
+			next := end
+			for next < len(edits) && edits[next].kind == ' ' {
+				next++
+			}
+
+			if next < len(edits) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			break
+		}
+
+		from, to := start-context, end+context
+		if from < 0 {
+			from = 0
+		}
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		writeHunk(&out, edits, from, to)
+		start = end
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, edits []edit, from, to int) {
+	// Line numbers in a and b of where the hunk starts
+	aLine, bLine := 0, 0
+	for _, e := range edits[:from] {
+		if e.kind != '+' {
+			aLine++
+		}
+		if e.kind != '-' {
+			bLine++
+		}
+	}
+
+	aLen, bLen := 0, 0
+	for _, e := range edits[from:to] {
+		if e.kind != '+' {
+			aLen++
+		}
+		if e.kind != '-' {
+			bLen++
+		}
+	}
+
+	// Empty ranges are numbered from the line before them, as diff -u does
+	if aLen > 0 {
+		aLine++
+	}
+	if bLen > 0 {
+		bLine++
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aLine, aLen, bLine, bLen)
+	for _, e := range edits[from:to] {
+		fmt.Fprintf(out, "%c%s\n", e.kind, e.line)
+	}
+}
+
+// compare finds the fewest lines to remove from a and add from b to turn it into b
+func compare(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	for _, line := range a[:prefix] {
+		edits = append(edits, edit{' ', line})
+	}
+
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, edit{' ', line})
+	}
+
+	return edits
+}
+
+// myers is the O(ND) algorithm of Eugene Myers, keeping only as much of each step as the backtrack needs
+func myers(a, b []string) []edit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	offset := n + m
+	v := make([]int, 2*offset+2)
+
+	var trace [][]int
+	found := -1
+
+search:
+	for d := 0; d <= offset && d <= MaxChanges; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				found = d
+				break search
+			}
+		}
+	}
+
+	if found == -1 {
+		return replaced(a, b)
+	}
+
+	var reversed []edit
+	x, y := n, m
+	for d := found; d > 0; d-- {
+		previous := trace[d]
+		at := func(k int) int { return previous[k+d] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			reversed = append(reversed, edit{' ', a[x]})
+		}
+
+		if x == prevX {
+			reversed = append(reversed, edit{'+', b[prevY]})
+		} else {
+			reversed = append(reversed, edit{'-', a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		reversed = append(reversed, edit{' ', a[x]})
+	}
+
+	edits := make([]edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
+
+func replaced(a, b []string) []edit {
+	edits := make([]edit, 0, len(a)+len(b))
+	for _, line := range a {
+		edits = append(edits, edit{'-', line})
+	}
+	for _, line := range b {
+		edits = append(edits, edit{'+', line})
+	}
+	return edits
+}
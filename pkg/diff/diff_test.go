@@ -0,0 +1,76 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	a := Lines("PermitRootLogin no\nPasswordAuthentication no\nPort 22\nX11Forwarding no\nUseDNS no\n")
+	b := Lines("PermitRootLogin yes\nPasswordAuthentication no\nPort 22\nX11Forwarding no\nUseDNS no\nAllowUsers admin\n")
+
+	expected := `--- web01
++++ web02
+@@ -1,5 +1,6 @@
+-PermitRootLogin no
++PermitRootLogin yes
+ PasswordAuthentication no
+ Port 22
+ X11Forwarding no
+ UseDNS no
++AllowUsers admin
+`
+	if d := Unified("web01", "web02", a, b, 3); d != expected {
+		t.Errorf("unexpected diff:\n%s", d)
+	}
+
+	if d := Unified("web01", "web02", a, a, 3); d != "" {
+		t.Errorf("expected no diff for the same text, got:\n%s", d)
+	}
+}
+
+func TestHunks(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 20; i++ {
+		a = append(a, fmt.Sprint(i))
+		b = append(b, fmt.Sprint(i))
+	}
+	b[2], b[17] = "two", "seventeen"
+
+	d := Unified("a", "b", a, b, 1)
+	if strings.Count(d, "@@ -") != 2 || !strings.Contains(d, "@@ -2,3 +2,3 @@\n 1\n-2\n+two\n 3\n") || !strings.Contains(d, "@@ -17,3 +17,3 @@\n") {
+		t.Errorf("expected changes far apart to be in their own hunks, got:\n%s", d)
+	}
+
+	if d := Unified("a", "b", nil, []string{"new"}, 3); d != "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+new\n" {
+		t.Errorf("unexpected diff from nothing:\n%s", d)
+	}
+}
+
+// Applying the edits to a has to give b, whatever the texts are
+func TestApply(t *testing.T) {
+	for _, c := range [][2]string{
+		{"a b c a b b a", "c b a b a c"},
+		{"x y z", ""},
+		{"", "x y z"},
+		{"1 2 3 4 5", "5 4 3 2 1"},
+		{"same", "same"},
+	} {
+		a, b := strings.Fields(c[0]), strings.Fields(c[1])
+
+		var fromA, toB []string
+		for _, e := range compare(a, b) {
+			if e.kind != '+' {
+				fromA = append(fromA, e.line)
+			}
+			if e.kind != '-' {
+				toB = append(toB, e.line)
+			}
+		}
+
+		if strings.Join(fromA, " ") != c[0] || strings.Join(toB, " ") != c[1] {
+			t.Errorf("%q to %q: edits gave %q and %q", c[0], c[1], fromA, toB)
+		}
+	}
+}
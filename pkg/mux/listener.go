@@ -1,43 +1,47 @@
 package mux
 
 import (
-	"errors"
 	"net"
+	"sync"
 )
 
 type multiplexerListener struct {
 	addr        net.Addr
 	connections chan net.Conn
-	closed      bool
+	done        chan struct{}
+	closeOnce   sync.Once
 	protocol    string
 }
 
 func newMultiplexerListener(addr net.Addr, protocol string) *multiplexerListener {
-	return &multiplexerListener{addr: addr, connections: make(chan net.Conn), protocol: protocol}
+	return &multiplexerListener{addr: addr, connections: make(chan net.Conn), done: make(chan struct{}), protocol: protocol}
 }
 
 func (ml *multiplexerListener) Accept() (net.Conn, error) {
-	if ml.closed {
-		return nil, errors.New("Accept on closed listener")
+	select {
+	case conn := <-ml.connections:
+		return conn, nil
+	case <-ml.done:
+		return nil, net.ErrClosed
 	}
-	return <-ml.connections, nil
 }
 
 // Close closes the listener.
 // Any blocked Accept operations will be unblocked and return errors.
 func (ml *multiplexerListener) Close() error {
-	if !ml.closed {
-		ml.closed = true
-		close(ml.connections)
-	}
+	ml.closeOnce.Do(func() {
+		close(ml.done)
+	})
 
 	return nil
 }
 
 // Addr returns the listener's network address.
 func (ml *multiplexerListener) Addr() net.Addr {
-	if ml.closed {
+	select {
+	case <-ml.done:
 		return nil
+	default:
+		return ml.addr
 	}
-	return ml.addr
 }
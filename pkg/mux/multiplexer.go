@@ -34,6 +34,9 @@ type MultiplexerConfig struct {
 
 	TcpKeepAlive int
 
+	// Called with each websocket upgrade request, returning an error refuses the upgrade
+	WebsocketHandshake func(*http.Request) error
+
 	tlsConfig *tls.Config
 }
 
@@ -78,7 +81,8 @@ func genX509KeyPair(AutoTLSCommonName string) (tls.Certificate, error) {
 type Multiplexer struct {
 	sync.RWMutex
 	protocols      map[string]*multiplexerListener
-	done           bool
+	done           chan struct{}
+	closeOnce      sync.Once
 	listeners      map[string]net.Listener
 	newConnections chan net.Conn
 
@@ -128,6 +132,8 @@ func (m *Multiplexer) StartListener(network, address string) error {
 			go func() {
 				select {
 				case m.newConnections <- conn:
+				case <-m.done:
+					conn.Close()
 				case <-time.After(2 * time.Second):
 					log.Println("Accepting new connection timed out")
 					conn.Close()
@@ -170,6 +176,8 @@ func (m *Multiplexer) QueueConn(c net.Conn) error {
 	select {
 	case m.newConnections <- c:
 		return nil
+	case <-m.done:
+		return errors.New("multiplexer is closed")
 	case <-time.After(250 * time.Millisecond):
 		return errors.New("too busy to queue connection")
 	}
@@ -180,6 +188,7 @@ func ListenWithConfig(network, address string, _c MultiplexerConfig) (*Multiplex
 	var m Multiplexer
 
 	m.newConnections = make(chan net.Conn)
+	m.done = make(chan struct{})
 	m.listeners = make(map[string]net.Listener)
 	m.protocols = map[string]*multiplexerListener{}
 	m.config = _c
@@ -199,7 +208,13 @@ func ListenWithConfig(network, address string, _c MultiplexerConfig) (*Multiplex
 
 	var waitingConnections int32
 	go func() {
-		for conn := range m.newConnections {
+		for {
+			var conn net.Conn
+			select {
+			case conn = <-m.newConnections:
+			case <-m.done:
+				return
+			}
 
 			if atomic.LoadInt32(&waitingConnections) > 1000 {
 				conn.Close()
@@ -288,7 +303,12 @@ func ListenWithConfig(network, address string, _c MultiplexerConfig) (*Multiplex
 						Config: websocket.Config{},
 
 						// Disable origin validation because.... its ssh we dont need it
-						Handshake: nil,
+						Handshake: func(_ *websocket.Config, r *http.Request) error {
+							if m.config.WebsocketHandshake != nil {
+								return m.config.WebsocketHandshake(r)
+							}
+							return nil
+						},
 						Handler: func(c *websocket.Conn) {
 							// Pain and suffering https://github.com/golang/go/issues/7350
 							c.PayloadType = websocket.BinaryFrame
@@ -335,6 +355,8 @@ func ListenWithConfig(network, address string, _c MultiplexerConfig) (*Multiplex
 				select {
 				//Allow whatever we're multiplexing to apply backpressure if it cant accept things
 				case l.connections <- functionalConn:
+				case <-l.done:
+					functionalConn.Close()
 				case <-time.After(2 * time.Second):
 
 					log.Println(l.protocol, "Failed to accept new connection within 2 seconds, closing connection (may indicate high resource usage)")
@@ -360,18 +382,17 @@ func Listen(network, address string) (*Multiplexer, error) {
 }
 
 func (m *Multiplexer) Close() {
-	m.done = true
+	m.closeOnce.Do(func() {
+		close(m.done)
 
-	for address := range m.listeners {
-		m.StopListener(address)
-	}
-
-	for _, v := range m.protocols {
-		v.Close()
-	}
-
-	close(m.newConnections)
+		for _, address := range m.GetListeners() {
+			m.StopListener(address)
+		}
 
+		for _, v := range m.protocols {
+			v.Close()
+		}
+	})
 }
 
 func isHttp(b []byte) bool {
@@ -0,0 +1,280 @@
+// Package server runs the rssh server inside another Go program. It is the same server the rssh binary runs, configured with a
+// Config rather than a command line, with logins decided by an Authenticator of the program's own if it likes and everything
+// the server would tell watchers and webhooks about handed to it as events.
+//
+// The server keeps what it knows of clients and operators in package variables, so a program can only start one, once.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	rssh "github.com/NHAS/reverse_ssh/internal/server"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/internal/server/commands"
+	"github.com/NHAS/reverse_ssh/internal/server/middleware"
+	"github.com/NHAS/reverse_ssh/internal/server/observers"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/pkg/command"
+	"github.com/NHAS/reverse_ssh/pkg/observer"
+)
+
+// Authenticator decides logins along with the key files in the data directory, embed BaseAuthenticator to only implement some of it
+type Authenticator = rssh.Authenticator
+
+// BaseAuthenticator keeps the decisions of the key files and logs nobody in with keyboard-interactive
+type BaseAuthenticator = rssh.BaseAuthenticator
+
+// AuthRequest describes a login by key, Known is whether the key is in authorized_keys (or authorized_controllee_keys for
+// CheckClientKey) and Role is the role it was given there
+type AuthRequest = rssh.AuthRequest
+
+// AuthResponse decides an operator login, an empty Role keeps the role from authorized_keys, or operator for keys that werent there
+type AuthResponse = rssh.AuthResponse
+
+// Hook runs around operator sessions and the commands run in them, its before hooks can refuse either with an error
+type Hook = middleware.Hook
+
+// HookSession is the operator session hooks are run for, Annotations made by BeforeSession hooks last the whole session
+type HookSession = middleware.Session
+
+// HookCommand is the run of a console command hooks are run for
+type HookCommand = middleware.Command
+
+// Limits caps how many clients the server takes, see the --max-clients options of the rssh binary
+type Limits = clients.Limits
+
+// What the server does with a client whose identity is already connected from another address
+const (
+	ClonesSuffix     = clients.ClonesSuffix
+	ClonesAlert      = clients.ClonesAlert
+	ClonesQuarantine = clients.ClonesQuarantine
+	ClonesRefuse     = clients.ClonesRefuse
+)
+
+// Event is either a ClientState, sent as clients connect and disconnect, or an Alert
+type Event = observer.Message
+
+type ClientState = observers.ClientState
+
+// Alert is something operators should hear about that isnt a client connecting or disconnecting, such as a tripped canary
+type Alert = observers.Alert
+
+type Config struct {
+	// Address to listen on, port 0 picks a free one which Addr then gives
+	ListenAddress string
+	// Directory the server keeps its key, authorized key files and state in, it must already exist
+	DataDir string
+	// Where the server key is instead, file:/path or agent:[/path/to/socket][?fingerprint=SHA256:...] for a key an ssh agent holds
+	HostKey string
+	// Where the passphrase of an encrypted key file comes from: env:NAME, file:/path, agent:[socket][?fingerprint=...] or exec:/program
+	HostKeyPassphrase string
+
+	// Address clients built by the link command call back to, the listen address if empty
+	ExternalAddress string
+	// Serve client downloads and the link command over http on the listen address
+	Webserver bool
+	// Accept TLS wrapped connections, with a self signed certificate unless TLSCertPath and TLSKeyPath are set
+	TLS         bool
+	TLSCertPath string
+	TLSKeyPath  string
+
+	// Let in clients whose keys arent in authorized_controllee_keys
+	Insecure bool
+	// Let in proxies whose keys arent in authorized_proxy_keys
+	OpenProxy bool
+	// Send password logins to the honeypot
+	Honeypot bool
+	// Refuse websocket upgrades from clients that dont sign them
+	StrictWebsockets bool
+
+	// Limits on clients, zero values are no limit and the default prefixes and clone policy
+	Limits Limits
+	// Seconds between keepalives, 0 turns them off. The rssh binary uses 5
+	Timeout int
+	// OTLP/HTTP endpoint to export spans to
+	Collector string
+
+	// Authenticator decides logins along with the key files, it is not asked about clients in Insecure mode
+	Authenticator Authenticator
+	// AuthProgram is an auth hook program to run for each operator login instead, ignored if Authenticator is set
+	AuthProgram string
+
+	// Events is called, on a goroutine of its own, with each event the server raises
+	Events func(Event)
+
+	// Hooks are run in order around every operator session and console command, including those of Commands
+	Hooks []Hook
+
+	// Commands are added to the console alongside the server's own, each made afresh for every console session and exec request
+	Commands map[string]func(command.Session) command.Command
+}
+
+type Server struct {
+	config Config
+
+	lock    sync.Mutex
+	running *rssh.Server
+
+	// Observer registrations for Events
+	clientEvents, alerts string
+}
+
+// NewServer checks config and fills in the defaults of anything left unset, the server does not listen until Start
+func NewServer(config Config) (*Server, error) {
+	if config.ListenAddress == "" {
+		return nil, errors.New("no listen address given")
+	}
+
+	if info, err := os.Stat(config.DataDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("data directory %q does not exist", config.DataDir)
+	}
+
+	if config.Limits.IPv4Prefix == 0 {
+		config.Limits.IPv4Prefix = 32
+	}
+
+	if config.Limits.IPv6Prefix == 0 {
+		config.Limits.IPv6Prefix = 64
+	}
+
+	if config.Limits.Clones == "" {
+		config.Limits.Clones = ClonesAlert
+	}
+
+	if err := clients.CheckClonePolicy(config.Limits.Clones); err != nil {
+		return nil, err
+	}
+
+	if config.Limits.Total < 0 || config.Limits.PerSource < 0 || config.Timeout < 0 {
+		return nil, errors.New("limits and the timeout cannot be negative")
+	}
+
+	return &Server{config: config}, nil
+}
+
+// Start starts listening and returns once the server is ready for connections
+func (s *Server) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running != nil {
+		return errors.New("server is already running")
+	}
+
+	for name, new := range s.config.Commands {
+		if err := AddCommand(name, new); err != nil {
+			return err
+		}
+	}
+
+	for _, hook := range s.config.Hooks {
+		if err := middleware.Use(hook); err != nil {
+			return err
+		}
+	}
+
+	auth := s.config.Authenticator
+	if auth == nil && s.config.AuthProgram != "" {
+		auth = rssh.AuthProgram(s.config.AuthProgram)
+	}
+
+	running, err := rssh.Start(rssh.Config{
+		ListenAddress:     s.config.ListenAddress,
+		DataDir:           s.config.DataDir,
+		HostKey:           s.config.HostKey,
+		HostKeyPassphrase: s.config.HostKeyPassphrase,
+		ExternalAddress:   s.config.ExternalAddress,
+		TLSCertPath:       s.config.TLSCertPath,
+		TLSKeyPath:        s.config.TLSKeyPath,
+		Collector:         s.config.Collector,
+		Authenticator:     auth,
+		Insecure:          s.config.Insecure,
+		Webserver:         s.config.Webserver,
+		TLS:               s.config.TLS,
+		OpenProxy:         s.config.OpenProxy,
+		Honeypot:          s.config.Honeypot,
+		StrictWebsockets:  s.config.StrictWebsockets,
+		Limits:            s.config.Limits,
+		Timeout:           s.config.Timeout,
+	})
+	if err != nil {
+		return err
+	}
+	s.running = running
+
+	if s.config.Events != nil {
+		target := observer.Target(s.config.Events)
+		s.clientEvents = observers.ConnectionState.Register(target)
+		s.alerts = observers.Alerts.Register(target)
+	}
+
+	return nil
+}
+
+// AddCommand adds a console command while the server runs, as for Config.Commands. Open consoles get it straight away and it
+// shows up in help and tab completion
+func AddCommand(name string, new func(command.Session) command.Command) error {
+	return commands.Register(name, func(user *internal.User) terminal.Command {
+		return new(command.Session{Operator: user.Operator(), Role: user.Role, Connection: user.ConnectionDetails})
+	})
+}
+
+// RemoveCommand takes away a command added by AddCommand or Config.Commands, from open consoles as well
+func RemoveCommand(name string) error {
+	return commands.Unregister(name)
+}
+
+// Stop disconnects everyone and closes the listeners
+func (s *Server) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running == nil {
+		return errors.New("server is not running")
+	}
+
+	if s.config.Events != nil {
+		observers.ConnectionState.Deregister(s.clientEvents)
+		observers.Alerts.Deregister(s.alerts)
+	}
+
+	return s.running.Stop()
+}
+
+// Wait blocks until the server is stopped, returning straight away if it was never started
+func (s *Server) Wait() {
+	s.lock.Lock()
+	running := s.running
+	s.lock.Unlock()
+
+	if running != nil {
+		running.Wait()
+	}
+}
+
+// Addr is the address the server is listening on, nil until it is started
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running == nil {
+		return nil
+	}
+	return s.running.Addr()
+}
+
+// Fingerprint is the SHA256 fingerprint of the server key, as clients are built to expect it. Empty until the server is started
+func (s *Server) Fingerprint() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running == nil {
+		return ""
+	}
+	return internal.FingerprintSHA256Hex(s.running.HostKey())
+}
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/pkg/command"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "rssh-server")
+	if err != nil {
+		panic(err)
+	}
+
+	// The server makes its downloads directory in the working directory
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type greet struct {
+	session command.Session
+}
+
+func (g *greet) Expect(line command.ParsedLine) []string {
+	return []string{command.CompleteClients}
+}
+
+func (g *greet) Run(output io.ReadWriter, line command.ParsedLine) error {
+	name, err := line.GetArgString("name")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(output, "hello %s, you are an %s\n", name, g.session.Role)
+	return nil
+}
+
+func (g *greet) Help(explain bool) string {
+	if explain {
+		return "Say hello"
+	}
+	return command.MakeHelpText("greet --name <name>")
+}
+
+type authenticator struct {
+	BaseAuthenticator
+	operator, client ssh.PublicKey
+}
+
+func (a *authenticator) CheckOperatorKey(r AuthRequest) (AuthResponse, error) {
+	return AuthResponse{Allow: r.Fingerprint == ssh.FingerprintSHA256(a.operator), Reason: "not this one"}, nil
+}
+
+func (a *authenticator) CheckClientKey(r AuthRequest) (bool, error) {
+	return r.Known || r.Fingerprint == ssh.FingerprintSHA256(a.client), nil
+}
+
+func (a *authenticator) Banner(conn ssh.ConnMetadata) string {
+	return "embedded rssh\n"
+}
+
+func (a *authenticator) KeyboardInteractive(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (AuthResponse, error) {
+	answers, err := challenge("", "", []string{"Password: "}, []bool{false})
+	if err != nil || len(answers) != 1 {
+		return AuthResponse{}, err
+	}
+
+	return AuthResponse{Allow: answers[0] == "letmein", Role: command.RoleAdmin}, nil
+}
+
+func newKey(t *testing.T) ssh.Signer {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestNewServer(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, config := range []Config{
+		{DataDir: dir},
+		{ListenAddress: "127.0.0.1:0", DataDir: filepath.Join(dir, "missing")},
+		{ListenAddress: "127.0.0.1:0", DataDir: dir, Limits: Limits{Clones: "ignore"}},
+		{ListenAddress: "127.0.0.1:0", DataDir: dir, Timeout: -1},
+	} {
+		if _, err := NewServer(config); err == nil {
+			t.Errorf("expected %+v to be refused", config)
+		}
+	}
+
+	s, err := NewServer(Config{ListenAddress: "127.0.0.1:0", DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.config.Limits != (Limits{IPv4Prefix: 32, IPv6Prefix: 64, Clones: ClonesAlert}) {
+		t.Errorf("unexpected default limits: %+v", s.config.Limits)
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	dir := t.TempDir()
+
+	allowed, refused, client, extra := newKey(t), newKey(t), newKey(t), newKey(t)
+
+	if err := os.WriteFile(filepath.Join(dir, "authorized_controllee_keys"), ssh.MarshalAuthorizedKey(client.PublicKey()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "authorized_keys"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan ClientState, 10)
+
+	var (
+		hookLock sync.Mutex
+		ran      []string
+		ended    []string
+	)
+
+	team := Hook{
+		Name: "team",
+		BeforeSession: func(s *HookSession) error {
+			if strings.HasPrefix(s.Connection, "intruder@") {
+				return errors.New("not on the team")
+			}
+			s.Annotations["team"] = "red"
+			return nil
+		},
+		AfterSession: func(s *HookSession) {
+			hookLock.Lock()
+			defer hookLock.Unlock()
+			ended = append(ended, s.Connection)
+		},
+		BeforeCommand: func(c *HookCommand) error {
+			if strings.Contains(c.Line, "mallory") {
+				return errors.New("no greeting mallory")
+			}
+			return nil
+		},
+		AfterCommand: func(c *HookCommand, err error) {
+			hookLock.Lock()
+			defer hookLock.Unlock()
+			ran = append(ran, fmt.Sprintf("%s %s %v", c.Line, c.Session.Annotations["team"], err))
+		},
+	}
+
+	s, err := NewServer(Config{
+		ListenAddress: "127.0.0.1:0",
+		DataDir:       dir,
+		Timeout:       5,
+		Authenticator: &authenticator{operator: allowed.PublicKey(), client: extra.PublicKey()},
+		Hooks:         []Hook{team},
+		Events: func(e Event) {
+			if state, ok := e.(ClientState); ok {
+				events <- state
+			}
+		},
+		Commands: map[string]func(command.Session) command.Command{
+			"greet": func(s command.Session) command.Command {
+				return &greet{session: s}
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var banner string
+	dial := func(user string, auth ssh.AuthMethod) (*ssh.Client, error) {
+		return ssh.Dial("tcp", s.Addr().String(), &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			BannerCallback: func(message string) error {
+				banner = message
+				return nil
+			},
+			Timeout: 5 * time.Second,
+		})
+	}
+
+	login := func(user string, key ssh.Signer) (*ssh.Client, error) {
+		return dial(user, ssh.PublicKeys(key))
+	}
+
+	password := func(answer string) ssh.AuthMethod {
+		return ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			return []string{answer}, nil
+		})
+	}
+
+	run := func(operator *ssh.Client, line, expected string) {
+		session, err := operator.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+
+		output, _ := session.Output(line)
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("expected %q in the output of %q: %q", expected, line, output)
+		}
+	}
+
+	operator, err := login("operator", allowed)
+	if err != nil {
+		t.Fatalf("operator allowed by the authenticator was refused: %s", err)
+	}
+	defer operator.Close()
+
+	if banner != "embedded rssh\n" {
+		t.Errorf("expected the authenticator's banner, got %q", banner)
+	}
+
+	run(operator, "greet --name embedder", "hello embedder, you are an operator")
+	run(operator, "help", "Say hello")
+	run(operator, "greet --name mallory", "greet refused by team: no greeting mallory")
+
+	if conn, err := login("operator", refused); err == nil {
+		conn.Close()
+		t.Fatal("operator refused by the authenticator was let in")
+	}
+
+	admin, err := dial("admin", password("letmein"))
+	if err != nil {
+		t.Fatalf("keyboard-interactive login allowed by the authenticator was refused: %s", err)
+	}
+	defer admin.Close()
+
+	run(admin, "greet --name admin", "hello admin, you are an admin")
+
+	if conn, err := dial("admin", password("guess")); err == nil {
+		conn.Close()
+		t.Fatal("keyboard-interactive login with the wrong answer was let in")
+	}
+
+	console, err := operator.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer console.Close()
+
+	if err := console.RequestPty("xterm", 40, 120, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	in, _ := console.StdinPipe()
+	out, _ := console.StdoutPipe()
+	if err := console.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	screen := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := out.Read(buf)
+			if err != nil {
+				close(screen)
+				return
+			}
+			screen <- string(buf[:n])
+		}
+	}()
+
+	expect := func(expected string) {
+		var seen string
+		timeout := time.After(5 * time.Second)
+		for !strings.Contains(seen, expected) {
+			select {
+			case s, ok := <-screen:
+				if !ok {
+					t.Fatalf("console closed waiting for %q, got %q", expected, seen)
+				}
+				seen += s
+			case <-timeout:
+				t.Fatalf("expected %q on the console, got %q", expected, seen)
+			}
+		}
+	}
+
+	if err := AddCommand("wave", func(s command.Session) command.Command { return &greet{session: s} }); err != nil {
+		t.Fatal(err)
+	}
+
+	if AddCommand("greet", func(s command.Session) command.Command { return &greet{session: s} }) == nil {
+		t.Error("expected a second greet command to be refused")
+	}
+
+	fmt.Fprintf(in, "wave --name console\r")
+	expect("hello console, you are an operator")
+
+	if err := RemoveCommand("wave"); err != nil {
+		t.Fatal(err)
+	}
+
+	if RemoveCommand("ls") == nil {
+		t.Error("expected built in commands to be kept")
+	}
+
+	fmt.Fprintf(in, "wave --name console\r")
+	expect("Unknown command: wave")
+
+	intruder, err := dial("intruder", password("letmein"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := intruder.NewSession(); err == nil || !strings.Contains(err.Error(), "not on the team") {
+		t.Errorf("expected a session refused by the hook to be refused, got %v", err)
+	}
+	intruder.Close()
+
+	operator.Close()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		hookLock.Lock()
+		done := len(ended) > 0
+		hookLock.Unlock()
+
+		if done {
+			break
+		}
+	}
+
+	hookLock.Lock()
+	if len(ended) != 1 || !strings.HasPrefix(ended[0], "operator@") {
+		t.Errorf("expected the after session hook to be called for the operator that disconnected, got %v", ended)
+	}
+
+	expected := []string{"greet --name embedder red <nil>", "help red <nil>", "greet --name admin red <nil>", "wave --name console red <nil>"}
+	if strings.Join(ran, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected the after command hook to see %q, got %q", expected, ran)
+	}
+	hookLock.Unlock()
+
+	for _, c := range []struct {
+		hostname string
+		key      ssh.Signer
+	}{{"embedded", client}, {"extra", extra}} {
+		conn, err := login(c.hostname, c.key)
+		if err != nil {
+			t.Fatalf("client %s was refused: %s", c.hostname, err)
+		}
+		defer conn.Close()
+
+		select {
+		case state := <-events:
+			if state.HostName != c.hostname || state.Status != "connected" {
+				t.Errorf("unexpected event: %+v", state)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event for %s connecting", c.hostname)
+		}
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	s.Wait()
+
+	if conn, err := login("operator", allowed); err == nil {
+		conn.Close()
+		t.Fatal("server still took logins after it was stopped")
+	}
+}
@@ -1,11 +1,36 @@
 package storage
 
 import (
+	"errors"
 	"io"
 	"os"
+	"sync/atomic"
 )
 
+var (
+	memoryOnly int32
+
+	ErrMemoryOnly = errors.New("memory only mode is enabled, refusing to write to disk")
+)
+
+// SetMemoryOnly stops Store from ever falling back to writing files to disk
+func SetMemoryOnly(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&memoryOnly, v)
+}
+
+func MemoryOnly() bool {
+	return atomic.LoadInt32(&memoryOnly) == 1
+}
+
 func StoreDisk(path string, r io.ReadCloser) (string, error) {
+	if MemoryOnly() {
+		return "", ErrMemoryOnly
+	}
+
 	out, err := os.Create(path)
 	if err != nil {
 		return "", err
@@ -1,6 +1,8 @@
 package table
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -8,6 +10,10 @@ import (
 	"strings"
 )
 
+// Columns are not narrowed below this when a table is fitted to a terminal, as a handful of characters and an ellipsis says
+// more than one or two
+const minColumn = 8
+
 type value struct {
 	parts   []string
 	longest int
@@ -25,13 +31,29 @@ func makeValue(rn string) (val value) {
 	rn = strings.TrimSpace(rn)
 	val.parts = strings.Split(rn, "\n")
 	for _, n := range val.parts {
-		if len(n) > val.longest {
-			val.longest = len(n)
+		if width(n) > val.longest {
+			val.longest = width(n)
+		}
+	}
+	return
+}
+
+// width is how many columns s takes up on a terminal, wide characters such as CJK take two
+func width(s string) (w int) {
+	for _, r := range s {
+		w++
+		if wide(r) {
+			w++
 		}
 	}
 	return
 }
 
+func wide(r rune) bool {
+	return (r >= 0x1100 && r <= 0x115f) || (r >= 0x2e80 && r <= 0xa4cf) || (r >= 0xac00 && r <= 0xd7a3) ||
+		(r >= 0xf900 && r <= 0xfaff) || (r >= 0xfe30 && r <= 0xfe4f) || (r >= 0xff00 && r <= 0xff60) || (r >= 0xffe0 && r <= 0xffe6)
+}
+
 func (t *Table) updateMax(line []value) error {
 	if len(line) != t.cols {
 		return errors.New("Number of values exceeds max number of columns")
@@ -92,25 +114,212 @@ func (t *Table) Print() {
 	t.Fprint(os.Stdout)
 }
 
+// Plain is implemented by writers for operators who want plain output, such as screen reader users. Tables written to them
+// become labelled lines, as columns and box drawing characters are read out one by one
+type Plain interface {
+	Plain() bool
+}
+
+// IsPlain reports whether w wants labelled lines instead of tables
+func IsPlain(w io.Writer) bool {
+	p, ok := w.(Plain)
+	return ok && p.Plain()
+}
+
+// Sized is implemented by writers that know how many columns the operator's terminal has, which tables written to them are
+// fitted to
+type Sized interface {
+	GetWidth() int
+}
+
+// Fprint writes the table to w, fitted to its width if it is Sized
 func (t *Table) Fprint(w io.Writer) {
+	if s, ok := w.(Sized); ok && s.GetWidth() > 0 {
+		t.FprintWidth(w, s.GetWidth())
+		return
+	}
+
+	t.FprintWide(w)
+}
+
+// FprintWide writes the table at its full width, even to a Sized writer
+func (t *Table) FprintWide(w io.Writer) {
+	if IsPlain(w) {
+		t.fprintPlain(w)
+		return
+	}
 
 	for _, line := range t.OutputStrings() {
 		fmt.Fprint(w, line+"\n")
 	}
 }
 
-func (t *Table) FprintWidth(w io.Writer, width int) {
+// FprintWidth writes the table in no more than limit columns. The widest columns are narrowed first, with values cut short
+// ending in an ellipsis, and only if that is not enough are the ends of lines cut off
+func (t *Table) FprintWidth(w io.Writer, limit int) {
+	if IsPlain(w) {
+		t.fprintPlain(w)
+		return
+	}
 
-	lines := t.OutputStrings()
+	// The last column is left free, as a line that fills the terminal wraps on some of them
+	fitted := t.fit(limit - 1)
+	lines := fitted.OutputStrings()
 
 	for _, line := range lines {
-		for i := 0; i < len(line) && i < width-1; i++ {
-			fmt.Fprintf(w, "%c", line[i])
+		used := 0
+		for _, r := range line {
+			if used += width(string(r)); used > limit-1 {
+				break
+			}
+			fmt.Fprintf(w, "%c", r)
 		}
 		fmt.Fprint(w, "\n")
 	}
 }
 
+// fit returns a copy of the table narrowed to no more than limit columns, as far as minColumn allows
+func (t *Table) fit(limit int) *Table {
+	widths := append([]int(nil), t.cellMaxWidth...)
+
+	total := 1
+	for _, w := range widths {
+		// A space either side and the border after the value
+		total += w + 3
+	}
+
+	for total > limit {
+		widest := 0
+		for i := range widths {
+			if widths[i] > widths[widest] {
+				widest = i
+			}
+		}
+
+		if widths[widest] <= minColumn {
+			break
+		}
+
+		widths[widest]--
+		total--
+	}
+
+	fitted := *t
+	fitted.cellMaxWidth = widths
+	fitted.line = make([][]value, len(t.line))
+	for y, line := range t.line {
+		fitted.line[y] = make([]value, len(line))
+		for x, v := range line {
+			if v.longest <= widths[x] {
+				fitted.line[y][x] = v
+				continue
+			}
+
+			cut := value{longest: widths[x]}
+			for _, part := range v.parts {
+				cut.parts = append(cut.parts, shorten(part, widths[x]))
+			}
+			fitted.line[y][x] = cut
+		}
+	}
+
+	return &fitted
+}
+
+// shorten cuts s down to limit columns, ending it with an ellipsis when anything was cut
+func shorten(s string, limit int) string {
+	if width(s) <= limit {
+		return s
+	}
+
+	var (
+		sb   strings.Builder
+		used int
+	)
+	for _, r := range s {
+		if used+width(string(r)) > limit-1 {
+			break
+		}
+		used += width(string(r))
+		sb.WriteRune(r)
+	}
+
+	return sb.String() + "…"
+}
+
+// Records returns the column names followed by every row, with the lines of multi line values joined by newlines
+func (t *Table) Records() (out [][]string) {
+	for _, line := range t.line {
+		record := make([]string, len(line))
+		for x, v := range line {
+			record[x] = strings.Join(v.parts, "\n")
+		}
+		out = append(out, record)
+	}
+	return
+}
+
+// WriteCSV writes the table as csv with the column names as the first record, for spreadsheets and scripts
+func (t *Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(t.Records()); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// WriteJSON writes the table as a json array with an object per row, its keys the column names in the order of the columns
+func (t *Table) WriteJSON(w io.Writer) error {
+	records := t.Records()
+
+	var sb strings.Builder
+	sb.WriteString("[")
+	for n, record := range records[1:] {
+		if n > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\n    {")
+		for x, v := range record {
+			if x > 0 {
+				sb.WriteString(",")
+			}
+
+			key, _ := json.Marshal(records[0][x])
+			value, _ := json.Marshal(v)
+			fmt.Fprintf(&sb, "\n        %s: %s", key, value)
+		}
+		sb.WriteString("\n    }")
+	}
+	if len(records) > 1 {
+		sb.WriteString("\n")
+	}
+	sb.WriteString("]\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// fprintPlain writes each row as "Column: value" lines, with multi line values joined by commas and a blank line between rows
+func (t *Table) fprintPlain(w io.Writer) {
+	if t.name != "" {
+		fmt.Fprintf(w, "%s, %d rows\n", t.name, len(t.line)-1)
+	}
+
+	for _, line := range t.line[1:] {
+		fmt.Fprint(w, "\n")
+		for x, v := range line {
+			parts := []string{}
+			for _, p := range v.parts {
+				if p = strings.TrimSpace(p); p != "" {
+					parts = append(parts, p)
+				}
+			}
+
+			fmt.Fprintf(w, "%s: %s\n", strings.Join(t.line[0][x].parts, " "), strings.Join(parts, ", "))
+		}
+	}
+}
+
 func (t *Table) OutputStrings() (output []string) {
 
 	seperator := t.seperator()
@@ -130,7 +339,7 @@ func (t *Table) OutputStrings() (output []string) {
 				if len(values[x]) > y {
 					val = values[x][y]
 				}
-				m += fmt.Sprintf(" %-"+fmt.Sprintf("%d", t.cellMaxWidth[x])+"s |", val)
+				m += " " + val + strings.Repeat(" ", t.cellMaxWidth[x]-width(val)) + " |"
 			}
 
 			output = append(output, m)
@@ -140,7 +349,12 @@ func (t *Table) OutputStrings() (output []string) {
 		output = append(output, seperator)
 	}
 
-	output = append([]string{fmt.Sprintf("%"+fmt.Sprintf("%d", len(output[0])/2)+"s", t.name), seperator}, output...)
+	title := t.name
+	if pad := width(output[0])/2 - width(t.name); pad > 0 {
+		title = strings.Repeat(" ", pad) + title
+	}
+
+	output = append([]string{title, seperator}, output...)
 
 	return
 
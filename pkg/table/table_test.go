@@ -0,0 +1,115 @@
+package table
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type plainBuffer struct {
+	bytes.Buffer
+}
+
+func (p *plainBuffer) Plain() bool {
+	return true
+}
+
+func TestPlain(t *testing.T) {
+	tb, _ := NewTable("Targets", "IDs", "Version")
+	tb.AddValues("abc\nroot.vm\n", "SSH-v1")
+
+	var out plainBuffer
+	tb.Fprint(&out)
+
+	expected := "Targets, 1 rows\n\nIDs: abc, root.vm\nVersion: SSH-v1\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+
+	var normal bytes.Buffer
+	tb.Fprint(&normal)
+	if !strings.Contains(normal.String(), "+") {
+		t.Errorf("writers that are not plain should still get a table, got %q", normal.String())
+	}
+}
+
+func TestWide(t *testing.T) {
+	tb, _ := NewTable("", "Function", "Purpose")
+	tb.AddValues("ls", "列出客户端")
+
+	lines := tb.OutputStrings()
+	if width(lines[2]) != width(lines[4]) {
+		t.Errorf("rows with wide characters should line up with the rest, got %q and %q", lines[2], lines[4])
+	}
+}
+
+type sizedBuffer struct {
+	bytes.Buffer
+	width int
+}
+
+func (s *sizedBuffer) GetWidth() int {
+	return s.width
+}
+
+func TestFit(t *testing.T) {
+	tb, _ := NewTable("Targets", "ID", "Version")
+	tb.AddValues(strings.Repeat("a", 60), "SSH-v2.4-linux_amd64")
+
+	out := sizedBuffer{width: 60}
+	tb.Fprint(&out)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	for _, line := range lines {
+		if width(line) > 59 {
+			t.Errorf("expected every line to fit in 59 columns, got %d: %q", width(line), line)
+		}
+	}
+
+	if !strings.Contains(out.String(), "…") || !strings.HasSuffix(lines[len(lines)-1], "+") {
+		t.Errorf("expected the widest column to be cut short rather than the end of the table, got\n%s", out.String())
+	}
+
+	if !strings.Contains(out.String(), "SSH-v2.4-linux_amd64") {
+		t.Errorf("expected the narrow column to be left alone, got\n%s", out.String())
+	}
+
+	var wide sizedBuffer
+	wide.width = 40
+	tb.FprintWide(&wide)
+	if !strings.Contains(wide.String(), strings.Repeat("a", 60)) {
+		t.Errorf("expected the full table at full width, got\n%s", wide.String())
+	}
+}
+
+func TestAlternates(t *testing.T) {
+	tb, _ := NewTable("Targets", "ID", "Note")
+	tb.AddValues("abc", "first\nsecond, \"quoted\"")
+
+	var csv bytes.Buffer
+	if err := tb.WriteCSV(&csv); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ID,Note\nabc,\"first\nsecond, \"\"quoted\"\"\"\n"
+	if csv.String() != expected {
+		t.Errorf("expected %q, got %q", expected, csv.String())
+	}
+
+	var json bytes.Buffer
+	if err := tb.WriteJSON(&json); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "[\n    {\n        \"ID\": \"abc\",\n        \"Note\": \"first\\nsecond, \\\"quoted\\\"\"\n    }\n]\n"
+	if json.String() != expected {
+		t.Errorf("expected %q, got %q", expected, json.String())
+	}
+
+	empty, _ := NewTable("Nothing", "ID")
+	json.Reset()
+	empty.WriteJSON(&json)
+	if json.String() != "[]\n" {
+		t.Errorf("expected an empty array, got %q", json.String())
+	}
+}
@@ -0,0 +1,281 @@
+package testharness
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"golang.org/x/crypto/ssh"
+)
+
+// Client is a fake rssh client connected to the server. Its shell echoes back what it is sent, exec answers with whatever
+// was given to Respond, and forwards are dialled from the test process
+type Client struct {
+	Hostname string
+
+	conn        ssh.Conn
+	fingerprint string
+
+	lock      sync.Mutex
+	responses map[string]string
+	answers   map[string]Answer
+	received  []Message
+}
+
+// Answer is how a client replies to a global request from the server
+type Answer struct {
+	OK      bool
+	Payload []byte
+}
+
+// Message is something the server sent a client. Kind is "request" for a global request, "channel" for a channel it opened,
+// with the channel's extra data as the payload, and "session" for a request made on a session channel
+type Message struct {
+	Kind    string
+	Type    string
+	Payload []byte
+}
+
+// Client connects a fake client with a key of its own, which is added to authorized_controllee_keys first. hostname is what
+// it logs in as, so it is also how ls lists it once normalised. It refuses every global request, as a client too old to know
+// any of them would
+func (s *Server) Client(hostname string) (*Client, error) {
+	return s.ClientAnswering(hostname, nil)
+}
+
+// ClientAnswering connects a fake client that replies to the global requests in answers, keyed by request type, as a client of
+// some release would. Answers are in place before it connects, for the requests the server makes as soon as a client arrives
+func (s *Server) ClientAnswering(hostname string, answers map[string]Answer) (*Client, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorize("authorized_controllee_keys", key.PublicKey(), hostname); err != nil {
+		return nil, err
+	}
+
+	tcp, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, chans, reqs, err := ssh.NewClientConn(tcp, s.Addr, &ssh.ClientConfig{
+		User:            hostname,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+		HostKeyCallback: s.hostKeyCallback,
+		ClientVersion:   "SSH-" + internal.Version + "-testharness",
+	})
+	if err != nil {
+		tcp.Close()
+		return nil, err
+	}
+
+	c := &Client{
+		Hostname:    hostname,
+		conn:        conn,
+		fingerprint: internal.FingerprintSHA1Hex(key.PublicKey()),
+		responses:   map[string]string{},
+		answers:     answers,
+	}
+
+	go c.requests(reqs)
+	go c.serve(chans)
+
+	return c, nil
+}
+
+// Clients connects n fake clients named prefix0 to prefixN-1
+func (s *Server) Clients(prefix string, n int) ([]*Client, error) {
+	var out []*Client
+	for i := 0; i < n; i++ {
+		c, err := s.Client(fmt.Sprintf("%s%d", prefix, i))
+		if err != nil {
+			for _, connected := range out {
+				connected.Close()
+			}
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// Respond sets what the client writes when the server execs command on it
+func (c *Client) Respond(command, output string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.responses[command] = output
+}
+
+// ID waits for the server to register the client and returns the id it was given
+func (c *Client) ID() (string, error) {
+	var id string
+	err := until(func() bool {
+		found, _ := clients.Search(c.fingerprint)
+		for i, conn := range found {
+			if conn.Permissions.Extensions["pubkey-fp"] == c.fingerprint {
+				id = i
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s was not registered by the server: %s", c.Hostname, err)
+	}
+
+	return id, nil
+}
+
+// Received waits for the server to send the client a message of kind and type, and returns the first one it sent
+func (c *Client) Received(kind, messageType string) (Message, error) {
+	var found Message
+	err := until(func() bool {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		for _, m := range c.received {
+			if m.Kind == kind && m.Type == messageType {
+				found = m
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return found, fmt.Errorf("%s was not sent %s %s: %s", c.Hostname, kind, messageType, err)
+	}
+
+	return found, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) record(kind, messageType string, payload []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.received = append(c.received, Message{Kind: kind, Type: messageType, Payload: append([]byte{}, payload...)})
+}
+
+func (c *Client) requests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		c.record("request", req.Type, req.Payload)
+
+		c.lock.Lock()
+		answer := c.answers[req.Type]
+		c.lock.Unlock()
+
+		if req.WantReply {
+			req.Reply(answer.OK, answer.Payload)
+		}
+	}
+}
+
+func (c *Client) serve(chans <-chan ssh.NewChannel) {
+	for newChannel := range chans {
+		c.record("channel", newChannel.ChannelType(), newChannel.ExtraData())
+
+		switch newChannel.ChannelType() {
+		case "session":
+			go c.session(newChannel)
+		case "direct-tcpip":
+			go c.forward(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "the testharness client only has sessions and forwards")
+		}
+	}
+}
+
+func (c *Client) session(newChannel ssh.NewChannel) {
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	for req := range reqs {
+		c.record("session", req.Type, req.Payload)
+
+		switch req.Type {
+		case "shell":
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+
+			io.Copy(ch, ch)
+			exit(ch, 0)
+			return
+
+		case "exec":
+			var command struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &command); err != nil {
+				req.Reply(false, nil)
+				return
+			}
+			req.Reply(true, nil)
+
+			c.lock.Lock()
+			output, ok := c.responses[command.Command]
+			c.lock.Unlock()
+
+			if !ok {
+				// Real clients send stderr along with stdout, which is all the server reads
+				fmt.Fprintf(ch, "%s: command not found\n", command.Command)
+				exit(ch, 127)
+				return
+			}
+
+			io.WriteString(ch, output)
+			exit(ch, 0)
+			return
+
+		default:
+			req.Reply(req.Type == "pty-req" || req.Type == "env" || req.Type == "window-change", nil)
+		}
+	}
+}
+
+func (c *Client) forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "undecodable forward")
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		io.Copy(conn, ch)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(ch, conn)
+}
+
+func exit(ch ssh.Channel, status uint32) {
+	ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+}
@@ -0,0 +1,56 @@
+package testharness
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Operator is an ssh login to the server console as the admin operator the harness made
+type Operator struct {
+	conn *ssh.Client
+}
+
+// Operator logs in to the server console
+func (s *Server) Operator() (*Operator, error) {
+	conn, err := ssh.Dial("tcp", s.Addr, &ssh.ClientConfig{
+		User:            "testharness",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(s.operator)},
+		HostKeyCallback: s.hostKeyCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Operator{conn: conn}, nil
+}
+
+// Run runs console commands as one exec request, so several are run as a script sharing variables, and returns what they wrote.
+// The error is only set when the request itself fails, such as for a command the server does not have, a command that fails
+// writes its error to the output
+func (o *Operator) Run(commands ...string) (string, error) {
+	session, err := o.conn.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	// Separate buffers as both are copied into at once
+	var output, errors bytes.Buffer
+	session.Stdout = &output
+	session.Stderr = &errors
+
+	err = session.Run(strings.Join(commands, "\n"))
+
+	if _, missing := err.(*ssh.ExitMissingError); missing {
+		// The console does not send exit statuses
+		err = nil
+	}
+
+	return output.String() + errors.String(), err
+}
+
+func (o *Operator) Close() error {
+	return o.conn.Close()
+}
@@ -0,0 +1,201 @@
+// Package testharness runs an rssh server and fake clients inside a test process, for end to end tests of the server and for
+// programs embedding it. The server is the real one, started on a free loopback port with its own data directory, and is
+// reached over ssh exactly as an operator or client would reach it.
+//
+// Only one server can run in a process as the server keeps its state in package variables. It also makes a downloads directory
+// in the working directory, so tests usually change to a temporary one first
+package testharness
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/clients"
+	"github.com/NHAS/reverse_ssh/pkg/server"
+	"golang.org/x/crypto/ssh"
+)
+
+// Timeout is how long helpers wait for the server to catch up, such as for a new client to be registered
+var Timeout = 10 * time.Second
+
+var (
+	startLock sync.Mutex
+	running   *Server
+)
+
+type Server struct {
+	// Address the server listens on
+	Addr string
+	// Data directory the server was started with
+	DataDir string
+	// Fingerprint of the server key, as clients are given it
+	Fingerprint string
+
+	operator ssh.Signer
+	keysLock sync.Mutex
+}
+
+// Start starts the server with dataDir as its data directory, adding an admin operator key for Operator to use. Later calls
+// return the server already running, whatever directory they are given
+func Start(dataDir string) (*Server, error) {
+	startLock.Lock()
+	defer startLock.Unlock()
+
+	if running != nil {
+		return running, nil
+	}
+
+	operator, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{DataDir: dataDir, operator: operator}
+
+	if err := s.authorize("authorized_keys", operator.PublicKey(), "testharness-operator"); err != nil {
+		return nil, err
+	}
+
+	// The server refuses to start without this file unless it is insecure, and clients are added to it as they are made
+	if err := s.authorize("authorized_controllee_keys", nil, ""); err != nil {
+		return nil, err
+	}
+
+	srv, err := server.NewServer(server.Config{ListenAddress: "127.0.0.1:0", DataDir: dataDir, Timeout: 5})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := srv.Start(); err != nil {
+		return nil, err
+	}
+
+	s.Addr = srv.Addr().String()
+	s.Fingerprint = srv.Fingerprint()
+
+	running = s
+	return s, nil
+}
+
+// Connected returns the hostnames of the connected clients by their id, as ls would list them
+func (s *Server) Connected() map[string]string {
+	found, _ := clients.Search("")
+
+	out := map[string]string{}
+	for id, conn := range found {
+		out[id] = clients.NormaliseHostname(conn.User())
+	}
+	return out
+}
+
+// RequireConnected fails t unless each hostname is connected before Timeout
+func (s *Server) RequireConnected(t testing.TB, hostnames ...string) {
+	t.Helper()
+
+	missing := hostnames
+	err := until(func() bool {
+		connected := map[string]bool{}
+		for _, hostname := range s.Connected() {
+			connected[hostname] = true
+		}
+
+		missing = nil
+		for _, h := range hostnames {
+			if !connected[h] {
+				missing = append(missing, h)
+			}
+		}
+		return len(missing) == 0
+	})
+
+	if err != nil {
+		t.Fatalf("clients %v were not connected, connected are %v", missing, sortedValues(s.Connected()))
+	}
+}
+
+// RequireDisconnected fails t if any of the hostnames are still connected after Timeout
+func (s *Server) RequireDisconnected(t testing.TB, hostnames ...string) {
+	t.Helper()
+
+	gone := map[string]bool{}
+	for _, h := range hostnames {
+		gone[h] = true
+	}
+
+	err := until(func() bool {
+		for _, hostname := range s.Connected() {
+			if gone[hostname] {
+				return false
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		t.Fatalf("expected %v to have disconnected, connected are %v", hostnames, sortedValues(s.Connected()))
+	}
+}
+
+// authorize adds key to one of the server's key files, reread by the server on every login. A nil key just makes sure the file exists
+func (s *Server) authorize(file string, key ssh.PublicKey, comment string) error {
+	s.keysLock.Lock()
+	defer s.keysLock.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(s.DataDir, file), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if key == nil {
+		return nil
+	}
+
+	_, err = fmt.Fprintf(f, "%s %s\n", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))), comment)
+	return err
+}
+
+func (s *Server) hostKeyCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if internal.FingerprintSHA256Hex(key) != s.Fingerprint {
+		return errors.New("server key does not match the harness server")
+	}
+	return nil
+}
+
+func newKey() (ssh.Signer, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(private)
+}
+
+// until polls condition until it is true or Timeout has passed
+func until(condition func() bool) error {
+	for deadline := time.Now().Add(Timeout); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if condition() {
+			return nil
+		}
+	}
+	return fmt.Errorf("gave up after %s", Timeout)
+}
+
+func sortedValues(m map[string]string) []string {
+	out := []string{}
+	for _, v := range m {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
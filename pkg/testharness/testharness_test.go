@@ -0,0 +1,164 @@
+package testharness
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal/server/handlers"
+)
+
+// Only one server runs in a process, so every test shares it and the data directory it was started with
+var dataDir string
+
+// Found before TestMain leaves the package directory
+var testdata string
+
+func TestMain(m *testing.M) {
+	var err error
+	if testdata, err = filepath.Abs("testdata"); err != nil {
+		panic(err)
+	}
+
+	dir, err := os.MkdirTemp("", "testharness")
+	if err != nil {
+		panic(err)
+	}
+	dataDir = filepath.Join(dir, "data")
+	if err := os.Mkdir(dataDir, 0700); err != nil {
+		panic(err)
+	}
+
+	// The server makes its downloads directory in the working directory
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestHarness(t *testing.T) {
+	s, err := Start(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fakes, err := s.Clients("client", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RequireConnected(t, "client0", "client1")
+
+	id, err := fakes[0].ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fakes[0].Respond("whoami", "harness-user\n")
+
+	operator, err := s.Operator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer operator.Close()
+
+	output, err := operator.Run("ls", "exec -y "+id+" whoami", "exec -y "+id+" hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"client0", "client1", "harness-user", "hostname: command not found"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in the operator output: %q", expected, output)
+		}
+	}
+
+	fakes[0].Close()
+	s.RequireDisconnected(t, "client0")
+	s.RequireConnected(t, "client1")
+}
+
+func TestAdminSubsystem(t *testing.T) {
+	s, err := Start(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Clients("admin", 1); err != nil {
+		t.Fatal(err)
+	}
+	s.RequireConnected(t, "admin0")
+
+	operator, err := s.Operator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer operator.Close()
+
+	session, err := operator.conn.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	requests, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.RequestSubsystem("admin-json"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []string{
+		`{"ID": "1", "Line": "ls"}`,
+		`{"ID": "2", "Line": "set name=admin0\nls $name"}`,
+		`{"ID": "3", "Line": "ls nothere"}`,
+		`{"ID": "4", "Line": "nosuchcommand"}`,
+		`not json`,
+	} {
+		if _, err := io.WriteString(requests, r+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decoder := json.NewDecoder(responses)
+	var got []handlers.AdminResponse
+	for i := 0; i < 5; i++ {
+		var response handlers.AdminResponse
+		if err := decoder.Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, response)
+	}
+
+	if !got[0].OK || got[0].ID != "1" || !strings.Contains(got[0].Output, "admin0") {
+		t.Errorf("expected ls to list the client, got %+v", got[0])
+	}
+
+	if !got[1].OK || !strings.Contains(got[1].Output, "admin0") {
+		t.Errorf("expected a script to share its variables, got %+v", got[1])
+	}
+
+	if got[2].OK || got[2].Category != "failed" || !strings.Contains(got[2].Error, "nothere") {
+		t.Errorf("expected a failed command to say why, got %+v", got[2])
+	}
+
+	if got[3].OK || got[3].ID != "4" || got[3].Category != "not-found" {
+		t.Errorf("expected an unknown command to be refused, got %+v", got[3])
+	}
+
+	if got[4].OK || got[4].Category != "usage" {
+		t.Errorf("expected a line that is not json to be refused, got %+v", got[4])
+	}
+}
@@ -0,0 +1,230 @@
+package testharness
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"golang.org/x/crypto/ssh"
+)
+
+
+// golden is the transcript of every custom request and channel payload, by name. Clients already out in the field send and decode
+// exactly these bytes, so an entry never changes. A message that takes a new shape is added as a new entry, and the old one stays
+// to prove the server still understands clients that send it
+type golden map[string][]byte
+
+func loadGolden(t *testing.T) golden {
+	f, err := os.Open(filepath.Join(testdata, "wire.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g := golden{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			t.Fatalf("malformed line in wire.golden: %q", line)
+		}
+
+		payload := []byte{}
+		if len(fields) == 2 {
+			if payload, err = hex.DecodeString(fields[1]); err != nil {
+				t.Fatalf("malformed payload for %s in wire.golden: %s", fields[0], err)
+			}
+		}
+		g[fields[0]] = payload
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+}
+
+// expect checks what is sent today is still what was recorded as name, and returns the recorded bytes to be sent on
+func (g golden) expect(t *testing.T, name string, sent []byte) []byte {
+	t.Helper()
+
+	recorded, ok := g[name]
+	if !ok {
+		t.Errorf("%s is not in testdata/wire.golden, if it is a new message add:\n%s %x", name, name, sent)
+		return sent
+	}
+
+	if !bytes.Equal(recorded, sent) {
+		t.Errorf("%s has changed, clients already deployed will not understand it:\nrecorded %x\nsent     %x", name, recorded, sent)
+	}
+
+	return recorded
+}
+
+// sent returns every payload of kind and type the server has sent c so far, in order
+func sent(c *Client, kind, messageType string) (out [][]byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, m := range c.received {
+		if m.Kind == kind && m.Type == messageType {
+			out = append(out, m.Payload)
+		}
+	}
+	return
+}
+
+func TestWireCompatibility(t *testing.T) {
+	g := loadGolden(t)
+
+	s, err := Start(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	neighbours := []internal.Neighbour{{IP: "10.1.1.254", MAC: "02:00:00:00:00:01", Interface: "eth0", State: "REACHABLE"}}
+	routes := []internal.Route{{Destination: "0.0.0.0/0", Gateway: "10.1.1.254", Interface: "eth0", Metric: 100}}
+	interfaces := []internal.Interface{{Name: "eth0", MAC: "02:00:00:00:00:02", Addresses: []string{"10.1.1.5/24", "fe80::2/64"}}}
+
+	// Replies built with what clients send today, which have to match what the releases that added them sent
+	networkTables := map[string]Answer{
+		"query-neighbours": {true, g.expect(t, "reply/query-neighbours", internal.MarshalNeighbours(neighbours))},
+		"query-routes":     {true, g.expect(t, "reply/query-routes", internal.MarshalRoutes(routes))},
+		"query-interfaces": {true, g.expect(t, "reply/query-interfaces", internal.MarshalInterfaces(interfaces))},
+	}
+
+	for name, decode := range map[string]func([]byte) (interface{}, error){
+		"query-neighbours": func(b []byte) (interface{}, error) { return internal.UnmarshalNeighbours(b) },
+		"query-routes":     func(b []byte) (interface{}, error) { return internal.UnmarshalRoutes(b) },
+		"query-interfaces": func(b []byte) (interface{}, error) { return internal.UnmarshalInterfaces(b) },
+	} {
+		if _, err := decode(networkTables[name].Payload); err != nil {
+			t.Errorf("the recorded %s reply no longer decodes: %s", name, err)
+		}
+	}
+
+	// A release from before protocol versions, which only lists its capabilities
+	protocol1 := map[string]Answer{
+		"query-capabilities": {true, g.expect(t, "reply/query-capabilities/protocol1", ssh.Marshal(struct{ Capabilities []string }{
+			[]string{"shell", "exec", "forward", "transfer", "scan"},
+		}))},
+		"memory-only": {true, g.expect(t, "reply/memory-only", ssh.Marshal(struct{ Enabled bool }{false}))},
+	}
+	for name, answer := range networkTables {
+		protocol1[name] = answer
+	}
+
+	current := map[string]Answer{
+		"query-capabilities": {true, g.expect(t, "reply/query-capabilities/protocol2", ssh.Marshal(internal.Features{
+			Capabilities: []string{"shell", "exec", "forward", "transfer", "scan"},
+			Protocol:     2,
+		}))},
+	}
+
+	old, err := s.ClientAnswering("wire-protocol1", protocol1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newer, err := s.ClientAnswering("wire-protocol2", current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Refuses every request, as the releases from before capabilities were reported did
+	oldest, err := s.Client("wire-protocol0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RequireConnected(t, "wire-protocol0", "wire-protocol1", "wire-protocol2")
+
+	oldID, _ := old.ID()
+	newerID, _ := newer.ID()
+	oldestID, _ := oldest.ID()
+
+	old.Respond("whoami", "wire-user\n")
+
+	operator, err := s.Operator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer operator.Close()
+
+	var output string
+	for _, command := range []string{
+		"info " + oldID,
+		"info " + newerID,
+		"info " + oldestID,
+		"neighbors " + oldID,
+		"routes " + oldID,
+		"memoryonly --on " + oldID,
+		"exec -y " + oldID + " whoami",
+		"scan " + oldID + " 10.1.1.0/24 22,80",
+		"scan " + oldestID + " 10.1.1.0/24 22",
+		"kill " + oldID,
+	} {
+		// One at a time, as a script stops at the first command that fails
+		out, err := operator.Run(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output += out
+	}
+
+	for _, expected := range []string{
+		"Protocol: 1 (older than the server's",
+		"Protocol: 2\n",
+		"Protocol: 0",
+		"Memory only: false",
+		"10.1.1.254",
+		"0.0.0.0/0",
+		"wire-user",
+		"speaks protocol 0",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in the operator output: %q", expected, output)
+		}
+	}
+
+	if _, err := old.Received("request", "kill"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []struct {
+		name, kind, messageType string
+		n                       int
+	}{
+		{"request/query-capabilities", "request", "query-capabilities", 0},
+		{"request/memory-only/query", "request", "memory-only", 0},
+		{"request/memory-only/on", "request", "memory-only", 1},
+		{"request/query-neighbours", "request", "query-neighbours", 0},
+		{"request/query-routes", "request", "query-routes", 0},
+		{"channel/session", "channel", "session", 0},
+		{"session/exec", "session", "exec", 0},
+		{"channel/scan", "channel", "scan", 0},
+		{"request/kill", "request", "kill", 0},
+	} {
+		payloads := sent(old, m.kind, m.messageType)
+		if len(payloads) <= m.n {
+			t.Errorf("%s was never sent", m.name)
+			continue
+		}
+
+		g.expect(t, m.name, payloads[m.n])
+	}
+
+	if len(sent(oldest, "channel", "scan")) != 0 {
+		t.Error("expected a scan not to be sent to a client that cannot scan")
+	}
+}
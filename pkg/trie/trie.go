@@ -36,6 +36,12 @@ func (t *Trie) Add(s string) {
 
 }
 
+// char is the byte the node is for as a string. Converting the byte itself would read it as a rune, so the bytes of a multi-byte
+// character would each become a character of their own
+func (t *Trie) char() string {
+	return string([]byte{t.c})
+}
+
 func (t *Trie) getAll() (result []string) {
 	if t.root {
 		t.mut.RLock()
@@ -43,10 +49,10 @@ func (t *Trie) getAll() (result []string) {
 	}
 
 	if len(t.children) == 0 {
-		return []string{string(t.c)}
+		return []string{t.char()}
 	}
 
-	prefix := string(t.c)
+	prefix := t.char()
 	if t.root {
 		prefix = ""
 	}
@@ -79,7 +85,7 @@ func (t *Trie) PrefixMatch(prefix string) (result []string) {
 	if child, ok := t.children[prefix[0]]; ok {
 		c := child.PrefixMatch(prefix[1:])
 		for i := range c {
-			c[i] = string(prefix[0]) + c[i]
+			c[i] = prefix[:1] + c[i]
 		}
 		return c
 	}
@@ -1,6 +1,7 @@
 package trie
 
 import (
+	"sort"
 	"strings"
 	"testing"
 )
@@ -72,3 +73,31 @@ func TestSimpleRemove(t *testing.T) {
 
 	}
 }
+
+func TestMultiByte(t *testing.T) {
+	nt := NewTrie()
+
+	nt.Add("bücher.example")
+	nt.Add("bureau")
+	nt.Add("東京")
+
+	s := nt.PrefixMatch("bü")
+	if len(s) != 1 || s[0] != "bücher.example" {
+		t.Fatalf("Expected bücher.example, got %q", s)
+	}
+
+	s = nt.PrefixMatch("b")
+	sort.Strings(s)
+	if len(s) != 2 || s[0] != "bureau" || s[1] != "bücher.example" {
+		t.Fatalf("Expected both completions whole, got %q", s)
+	}
+
+	s = nt.PrefixMatch("")
+	found := false
+	for _, m := range s {
+		found = found || m == "東京"
+	}
+	if !found {
+		t.Fatalf("Expected 東京 to be returned whole, got %q", s)
+	}
+}